@@ -1,6 +1,7 @@
-// crdt-smoke is a throwaway sanity probe for the cgo wrapper around
-// yffi/yrs. Built once during Phase 2.2 bring-up; safe to delete once
-// the wrapper has automated tests.
+// crdt-smoke is a throwaway sanity probe for the CRDT wrapper (yrs by
+// default, the pure-Go engine with -tags purego). Built once during
+// Phase 2.2 bring-up; safe to delete once the wrapper has automated
+// tests.
 package main
 
 import (
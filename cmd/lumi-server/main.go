@@ -11,11 +11,15 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -31,20 +35,26 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 
+	"github.com/ViniZap4/lumi-server/internal/admin"
 	"github.com/ViniZap4/lumi-server/internal/audit"
 	"github.com/ViniZap4/lumi-server/internal/auth"
+	"github.com/ViniZap4/lumi-server/internal/capguard"
 	"github.com/ViniZap4/lumi-server/internal/crdt"
 	"github.com/ViniZap4/lumi-server/internal/domain"
 	"github.com/ViniZap4/lumi-server/internal/federation"
 	"github.com/ViniZap4/lumi-server/internal/fswatch"
+	"github.com/ViniZap4/lumi-server/internal/geoip"
 	"github.com/ViniZap4/lumi-server/internal/invites"
+	"github.com/ViniZap4/lumi-server/internal/mail"
 	"github.com/ViniZap4/lumi-server/internal/members"
 	"github.com/ViniZap4/lumi-server/internal/notes"
+	"github.com/ViniZap4/lumi-server/internal/oidc"
 	"github.com/ViniZap4/lumi-server/internal/roles"
 	"github.com/ViniZap4/lumi-server/internal/storage/fs"
 	"github.com/ViniZap4/lumi-server/internal/storage/pg"
 	"github.com/ViniZap4/lumi-server/internal/users"
 	"github.com/ViniZap4/lumi-server/internal/vaults"
+	"github.com/ViniZap4/lumi-server/internal/webauthn"
 	"github.com/ViniZap4/lumi-server/internal/wsync"
 
 	"github.com/google/uuid"
@@ -86,6 +96,44 @@ func (a crdtRepoAdapter) ListUpdatesSince(ctx context.Context, vaultID uuid.UUID
 	return out, nil
 }
 
+func (a crdtRepoAdapter) ListCompactionCandidates(ctx context.Context, vaultID uuid.UUID, minCount int, minBytes int64, limit int) ([]crdt.LogStat, error) {
+	rows, err := a.NoteYjsStore.ListCompactionCandidates(ctx, vaultID, minCount, minBytes, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]crdt.LogStat, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, crdt.LogStat(r))
+	}
+	return out, nil
+}
+
+func (a crdtRepoAdapter) ListSnapshotsAfter(ctx context.Context, vaultID uuid.UUID, after crdt.SnapshotCursor, limit int) ([]crdt.SnapshotStat, error) {
+	rows, err := a.NoteYjsStore.ListSnapshotsAfter(ctx, vaultID, after.VaultID, after.NoteID, limit)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]crdt.SnapshotStat, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, crdt.SnapshotStat(r))
+	}
+	return out, nil
+}
+
+func (a crdtRepoAdapter) LogTotals(ctx context.Context, vaultID uuid.UUID) (crdt.LogTotals, error) {
+	t, err := a.NoteYjsStore.Totals(ctx, vaultID)
+	if err != nil {
+		return crdt.LogTotals{}, err
+	}
+	return crdt.LogTotals{
+		Notes:         t.NotesWithLog,
+		Rows:          t.LogRows,
+		Bytes:         t.LogBytes,
+		Snapshots:     t.Snapshots,
+		SnapshotBytes: t.SnapshotBytes,
+	}, nil
+}
+
 // fedMemberAdapter bridges pg.FederatedMemberStore to
 // federation.FederatedMemberRepo (DTO type conversion only).
 type fedMemberAdapter struct{ *pg.FederatedMemberStore }
@@ -107,6 +155,77 @@ func (a fedMemberAdapter) ListForVault(ctx context.Context, vaultID uuid.UUID) (
 	return out, nil
 }
 
+// fsReplayAdapter bridges the fswatch handler to notes.FSReplayer so
+// startup reconciliation takes the same path as live watcher events.
+type fsReplayAdapter struct {
+	h  fswatch.Handler
+	fs *fs.Manager
+}
+
+func (a fsReplayAdapter) ReplayFSChange(ctx context.Context, slug string, c notes.FSChange) {
+	ev := fswatch.Event{VaultSlug: slug, RelativePath: c.Path, OldRelativePath: c.OldPath}
+	switch c.Kind {
+	case notes.FSChangeRemove:
+		ev.Op = fswatch.OpRemove
+	case notes.FSChangeMove:
+		ev.Op = fswatch.OpMove
+	}
+	ev.AbsPath, _ = a.fs.NotePath(slug, c.Path)
+	a.h.HandleFSEvent(ctx, ev)
+}
+
+// vaultRoleGranter implements auth.VaultRoleGranter over the role and
+// member stores, for OIDC group → vault role mappings.
+type vaultRoleGranter struct {
+	roles   *pg.RoleStore
+	members *pg.MemberStore
+}
+
+func (g vaultRoleGranter) GrantVaultRole(ctx context.Context, vaultID, userID uuid.UUID, role string) (bool, error) {
+	if _, err := g.members.Get(ctx, vaultID, userID); err == nil {
+		return false, nil
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return false, err
+	}
+	r, err := g.roles.GetByName(ctx, vaultID, role)
+	if err != nil {
+		return false, err
+	}
+	err = g.members.Add(ctx, domain.Member{VaultID: vaultID, UserID: userID, RoleID: r.ID, JoinedAt: time.Now().UTC()})
+	if errors.Is(err, domain.ErrConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// vaultSummaryAdapter bridges pg.VaultStore to admin.VaultLister (DTO
+// type conversion only).
+type vaultSummaryAdapter struct{ *pg.VaultStore }
+
+func (a vaultSummaryAdapter) ListSummaries(ctx context.Context) ([]admin.VaultSummary, error) {
+	rows, err := a.VaultStore.ListSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]admin.VaultSummary, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, admin.VaultSummary(r))
+	}
+	return out, nil
+}
+
+// registrationAdapter exposes auth's typed registration policy to the
+// admin package as a string.
+type registrationAdapter struct{ *auth.Service }
+
+func (a registrationAdapter) RegistrationPolicy(ctx context.Context) string {
+	return string(a.Service.RegistrationPolicy(ctx))
+}
+
+func (a registrationAdapter) SetRegistrationPolicy(ctx context.Context, p string) error {
+	return a.Service.SetRegistrationPolicy(ctx, auth.RegistrationPolicy(p))
+}
+
 // memberRepoAdapter bridges pg.MemberStore to members.Repo. Same reason.
 type memberRepoAdapter struct{ *pg.MemberStore }
 
@@ -133,6 +252,7 @@ const (
 	exitMigrate    = 81
 	exitDB         = 82
 	exitListenFail = 83
+	exitFsckDirty  = 84
 )
 
 func main() {
@@ -147,6 +267,8 @@ func realMain(args []string) int {
 			return exitOK
 		case "migrate":
 			return migrateCmd(args[1:])
+		case "fsck":
+			return fsckCmd(args[1:])
 		case "help", "-h", "--help":
 			printUsage()
 			return exitOK
@@ -167,6 +289,9 @@ Usage:
   lumi-server migrate up         run all pending migrations
   lumi-server migrate down N     roll back N migrations
   lumi-server migrate status     print current migration version
+  lumi-server fsck [-vault SLUG] [-repair crdt-to-fs|fs-to-crdt]
+                                 check notes, files and CRDT state; print a
+                                 JSON report (exit 84 if issues remain)
   lumi-server version            print version
 
 Configuration is via environment variables. See .env.example.`)
@@ -191,6 +316,51 @@ type config struct {
 	logFormat          string
 	logLevel           string
 	autoMigrate        bool
+	crdtCacheMB        int
+	fswatchMode        fswatch.Mode
+	fswatchPollSeconds int
+	twoFactorPolicy    string
+	totpIssuer         string
+	webauthnRPID       string
+	webauthnOrigins    []string
+	loginStepUp        bool
+	geoipCSV           string
+	rateLimitStore     string
+	argon2MemoryKiB    int
+	argon2Time         int
+	argon2Threads      int
+	argon2Concurrency  int
+	passwordMinLength  int
+	breachedPasswords  string
+	tokenMode          string
+	accessKeys         [][]byte
+	accessKeyErr       error
+	accessTTLMinutes   int
+	exportDir          string
+	oidc               oidcConfig
+	mail               mailConfig
+}
+
+// mailConfig selects the outgoing mail transport: smtp, or log or file
+// for development. Disabled when transport is empty.
+type mailConfig struct {
+	transport string
+	from      string
+	dir       string
+	smtp      mail.SMTPConfig
+}
+
+// oidcConfig is the OpenID Connect client registration. Disabled when
+// issuer is empty.
+type oidcConfig struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	groupsClaim  string
+	groupRoles   []auth.GroupRole
+	groupErr     error
 }
 
 func loadConfig() (config, error) {
@@ -217,8 +387,79 @@ func loadConfig() (config, error) {
 		return config{}, err
 	}
 	c.auditRetentionDays = retention
+	cacheMB, err := envInt("LUMI_CRDT_CACHE_MB", 64)
+	if err != nil {
+		return config{}, err
+	}
+	c.crdtCacheMB = cacheMB
+	mode, err := fswatch.ParseMode(os.Getenv("LUMI_FSWATCH_MODE"))
+	if err != nil {
+		return config{}, fmt.Errorf("%w: LUMI_FSWATCH_MODE must be auto, notify or poll", domain.ErrValidation)
+	}
+	c.fswatchMode = mode
+	pollSeconds, err := envInt("LUMI_FSWATCH_POLL_SECONDS", 5)
+	if err != nil {
+		return config{}, err
+	}
+	c.fswatchPollSeconds = pollSeconds
+	if c.argon2MemoryKiB, err = envInt("LUMI_ARGON2_MEMORY_KIB", int(auth.DefaultArgon2.Memory)); err != nil {
+		return config{}, err
+	}
+	if c.argon2Time, err = envInt("LUMI_ARGON2_TIME", int(auth.DefaultArgon2.Time)); err != nil {
+		return config{}, err
+	}
+	if c.argon2Threads, err = envInt("LUMI_ARGON2_THREADS", int(auth.DefaultArgon2.Threads)); err != nil {
+		return config{}, err
+	}
+	if c.argon2Concurrency, err = envInt("LUMI_ARGON2_MAX_CONCURRENT", auth.MaxConcurrentHashes); err != nil {
+		return config{}, err
+	}
+	if c.passwordMinLength, err = envInt("LUMI_PASSWORD_MIN_LENGTH", auth.MinPasswordLength); err != nil {
+		return config{}, err
+	}
+	c.breachedPasswords = os.Getenv("LUMI_BREACHED_PASSWORDS")
+	c.tokenMode = envDefault("LUMI_TOKEN_MODE", "opaque")
+	if c.accessTTLMinutes, err = envInt("LUMI_ACCESS_TOKEN_TTL_MINUTES", 10); err != nil {
+		return config{}, err
+	}
+	c.accessKeys, c.accessKeyErr = parseAccessKeys(os.Getenv("LUMI_ACCESS_TOKEN_KEY"), os.Getenv("LUMI_ACCESS_TOKEN_PREVIOUS_KEY"))
+	c.exportDir = envDefault("LUMI_EXPORT_DIR", filepath.Join(os.TempDir(), "lumi-exports"))
 	c.requireTLS = envBool("LUMI_REQUIRE_TLS", true)
 	c.autoMigrate = envBool("LUMI_AUTO_MIGRATE", false)
+	c.twoFactorPolicy = envDefault("LUMI_2FA_POLICY", "optional")
+	c.totpIssuer = envDefault("LUMI_TOTP_ISSUER", "lumi")
+	c.loginStepUp = envBool("LUMI_LOGIN_STEP_UP", false)
+	c.geoipCSV = os.Getenv("LUMI_GEOIP_CSV")
+	c.rateLimitStore = envDefault("LUMI_RATE_LIMIT_STORE", "postgres")
+	c.webauthnRPID = os.Getenv("LUMI_WEBAUTHN_RP_ID")
+	if origins := os.Getenv("LUMI_WEBAUTHN_ORIGINS"); origins != "" {
+		for _, o := range strings.Split(origins, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				c.webauthnOrigins = append(c.webauthnOrigins, o)
+			}
+		}
+	}
+	c.oidc = oidcConfig{
+		issuer:       os.Getenv("LUMI_OIDC_ISSUER"),
+		clientID:     os.Getenv("LUMI_OIDC_CLIENT_ID"),
+		clientSecret: os.Getenv("LUMI_OIDC_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("LUMI_OIDC_REDIRECT_URL"),
+		scopes:       strings.Fields(envDefault("LUMI_OIDC_SCOPES", "openid profile email")),
+		groupsClaim:  envDefault("LUMI_OIDC_GROUPS_CLAIM", "groups"),
+	}
+	c.oidc.groupRoles, c.oidc.groupErr = parseGroupRoles(os.Getenv("LUMI_OIDC_GROUP_ROLES"))
+	c.mail = mailConfig{
+		transport: os.Getenv("LUMI_MAIL_TRANSPORT"),
+		from:      os.Getenv("LUMI_MAIL_FROM"),
+		dir:       os.Getenv("LUMI_MAIL_DIR"),
+		smtp: mail.SMTPConfig{
+			Addr:     os.Getenv("LUMI_SMTP_ADDR"),
+			Username: os.Getenv("LUMI_SMTP_USERNAME"),
+			Password: os.Getenv("LUMI_SMTP_PASSWORD"),
+			From:     os.Getenv("LUMI_MAIL_FROM"),
+			TLS:      envDefault("LUMI_SMTP_TLS", mail.TLSStartTLS),
+		},
+	}
 	if origins := os.Getenv("LUMI_ALLOWED_ORIGINS"); origins != "" {
 		for _, o := range strings.Split(origins, ",") {
 			if o = strings.TrimSpace(o); o != "" {
@@ -246,9 +487,18 @@ func (c config) validate() error {
 	if c.auditRetentionDays < 1 {
 		problems = append(problems, "LUMI_AUDIT_RETENTION_DAYS must be >= 1")
 	}
+	if c.crdtCacheMB < 0 {
+		problems = append(problems, "LUMI_CRDT_CACHE_MB must be >= 0")
+	}
+	if c.fswatchPollSeconds < 1 {
+		problems = append(problems, "LUMI_FSWATCH_POLL_SECONDS must be >= 1")
+	}
 	if c.registration != "open" && c.registration != "invite-only" {
 		problems = append(problems, fmt.Sprintf("LUMI_REGISTRATION must be 'open' or 'invite-only', got %q", c.registration))
 	}
+	if c.twoFactorPolicy != "optional" && c.twoFactorPolicy != "required" {
+		problems = append(problems, fmt.Sprintf("LUMI_2FA_POLICY must be 'optional' or 'required', got %q", c.twoFactorPolicy))
+	}
 	if (c.adminUsername == "") != (c.adminPassword == "") {
 		problems = append(problems, "LUMI_ADMIN_USERNAME and LUMI_ADMIN_PASSWORD must be set together")
 	}
@@ -257,12 +507,170 @@ func (c config) validate() error {
 			problems = append(problems, fmt.Sprintf("LUMI_PUBLIC_BASE_URL invalid: %v", err))
 		}
 	}
+	if c.oidc.issuer != "" {
+		if c.oidc.clientID == "" {
+			problems = append(problems, "LUMI_OIDC_CLIENT_ID is required when LUMI_OIDC_ISSUER is set")
+		}
+		if c.oidc.redirectURL == "" && c.publicBaseURL == "" {
+			problems = append(problems, "LUMI_OIDC_REDIRECT_URL (or LUMI_PUBLIC_BASE_URL) is required when LUMI_OIDC_ISSUER is set")
+		}
+	}
+	if c.oidc.groupErr != nil {
+		problems = append(problems, fmt.Sprintf("LUMI_OIDC_GROUP_ROLES: %v", c.oidc.groupErr))
+	}
+	problems = append(problems, c.mail.problems(c.publicBaseURL)...)
+	if c.rateLimitStore != "postgres" && c.rateLimitStore != "memory" {
+		problems = append(problems, fmt.Sprintf("LUMI_RATE_LIMIT_STORE must be 'postgres' or 'memory', got %q", c.rateLimitStore))
+	}
+	if c.argon2MemoryKiB < 8*1024 || c.argon2Time < 1 || c.argon2Threads < 1 || c.argon2Threads > 255 {
+		problems = append(problems, "LUMI_ARGON2_MEMORY_KIB must be at least 8192, LUMI_ARGON2_TIME at least 1 and LUMI_ARGON2_THREADS between 1 and 255")
+	}
+	if c.argon2Concurrency < 1 {
+		problems = append(problems, "LUMI_ARGON2_MAX_CONCURRENT must be at least 1")
+	}
+	if c.passwordMinLength < auth.MinPasswordLength || c.passwordMinLength > 128 {
+		problems = append(problems, fmt.Sprintf("LUMI_PASSWORD_MIN_LENGTH must be between %d and 128", auth.MinPasswordLength))
+	}
+	switch c.tokenMode {
+	case "opaque":
+	case "signed":
+		switch {
+		case c.accessKeyErr != nil:
+			problems = append(problems, c.accessKeyErr.Error())
+		case len(c.accessKeys) == 0:
+			problems = append(problems, "LUMI_ACCESS_TOKEN_KEY is required when LUMI_TOKEN_MODE=signed")
+		}
+		if c.accessTTLMinutes < 1 || c.accessTTLMinutes > 60 {
+			problems = append(problems, "LUMI_ACCESS_TOKEN_TTL_MINUTES must be between 1 and 60")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("LUMI_TOKEN_MODE must be 'opaque' or 'signed', got %q", c.tokenMode))
+	}
+	if c.loginStepUp && c.mail.transport == "" {
+		problems = append(problems, "LUMI_LOGIN_STEP_UP needs LUMI_MAIL_TRANSPORT (the codes are sent by email)")
+	}
 	if len(problems) > 0 {
 		return fmt.Errorf("%w: %s", domain.ErrValidation, strings.Join(problems, "; "))
 	}
 	return nil
 }
 
+func (m mailConfig) problems(publicBaseURL string) []string {
+	var out []string
+	switch m.transport {
+	case "":
+		return nil
+	case "smtp":
+		if m.smtp.Addr == "" {
+			out = append(out, "LUMI_SMTP_ADDR is required when LUMI_MAIL_TRANSPORT=smtp")
+		}
+		if m.smtp.TLS != mail.TLSStartTLS && m.smtp.TLS != mail.TLSImplicit && m.smtp.TLS != mail.TLSNone {
+			out = append(out, fmt.Sprintf("LUMI_SMTP_TLS must be 'starttls', 'tls' or 'none', got %q", m.smtp.TLS))
+		}
+	case "file":
+		if m.dir == "" {
+			out = append(out, "LUMI_MAIL_DIR is required when LUMI_MAIL_TRANSPORT=file")
+		}
+	case "log":
+	default:
+		return []string{fmt.Sprintf("LUMI_MAIL_TRANSPORT must be 'smtp', 'file' or 'log', got %q", m.transport)}
+	}
+	if m.transport != "log" {
+		if _, err := mail.ParseAddress(m.from); err != nil {
+			out = append(out, "LUMI_MAIL_FROM must be a bare email address")
+		}
+	}
+	if publicBaseURL == "" {
+		out = append(out, "LUMI_PUBLIC_BASE_URL is required when LUMI_MAIL_TRANSPORT is set (links in mail point there)")
+	}
+	return out
+}
+
+// mailSender builds the configured transport.
+func (m mailConfig) mailSender(zlog zerolog.Logger) (mail.Sender, error) {
+	switch m.transport {
+	case "smtp":
+		return mail.NewSMTPSender(m.smtp)
+	case "file":
+		return mail.FileSender{Dir: m.dir, From: m.from}, nil
+	default:
+		return mail.LogSender{Log: zlog}, nil
+	}
+}
+
+// webauthnRP derives the WebAuthn relying party, defaulting the RP ID
+// and origin to LUMI_PUBLIC_BASE_URL. Empty when neither is configured.
+func (c config) webauthnRP() webauthn.RelyingParty {
+	rp := webauthn.RelyingParty{ID: c.webauthnRPID, Name: c.totpIssuer, Origins: c.webauthnOrigins}
+	if base, err := url.Parse(c.publicBaseURL); err == nil && base.Host != "" {
+		if rp.ID == "" {
+			rp.ID = base.Hostname()
+		}
+		if len(rp.Origins) == 0 {
+			rp.Origins = []string{base.Scheme + "://" + base.Host}
+		}
+	}
+	return rp
+}
+
+// oidcRedirectURL is the web client page the provider returns to,
+// defaulting to <LUMI_PUBLIC_BASE_URL>/auth/oidc/callback.
+func (c config) oidcRedirectURL() string {
+	if c.oidc.redirectURL != "" {
+		return c.oidc.redirectURL
+	}
+	return strings.TrimSuffix(c.publicBaseURL, "/") + "/auth/oidc/callback"
+}
+
+// parseAccessKeys decodes the base64 access token signing keys: the
+// current one first, then the previous one while it is rotated out.
+func parseAccessKeys(current, previous string) ([][]byte, error) {
+	var keys [][]byte
+	for _, kv := range []struct{ name, value string }{
+		{"LUMI_ACCESS_TOKEN_KEY", current},
+		{"LUMI_ACCESS_TOKEN_PREVIOUS_KEY", previous},
+	} {
+		v := strings.TrimSpace(kv.value)
+		if v == "" {
+			continue
+		}
+		if len(keys) == 0 && kv.name != "LUMI_ACCESS_TOKEN_KEY" {
+			return nil, fmt.Errorf("LUMI_ACCESS_TOKEN_PREVIOUS_KEY needs LUMI_ACCESS_TOKEN_KEY")
+		}
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not valid base64", kv.name)
+		}
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%s must decode to at least 32 bytes", kv.name)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// parseGroupRoles reads "group=<vault-uuid>:<Role>,..." mappings.
+func parseGroupRoles(v string) ([]auth.GroupRole, error) {
+	var out []auth.GroupRole
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, target, ok := strings.Cut(entry, "=")
+		vault, role, ok2 := strings.Cut(target, ":")
+		if !ok || !ok2 || strings.TrimSpace(group) == "" || strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("%q: want group=<vault-id>:<role>", entry)
+		}
+		id, err := uuid.Parse(strings.TrimSpace(vault))
+		if err != nil {
+			return nil, fmt.Errorf("%q: vault id: %v", entry, err)
+		}
+		out = append(out, auth.GroupRole{Group: strings.TrimSpace(group), VaultID: id, Role: strings.TrimSpace(role)})
+	}
+	return out, nil
+}
+
 func (c config) isLoopback() bool {
 	return c.bindAddr == "127.0.0.1" || c.bindAddr == "::1" || c.bindAddr == "localhost"
 }
@@ -330,6 +738,7 @@ func runServer() int {
 	zlog.Info().
 		Str("version", Version).
 		Str("registration", cfg.registration).
+		Str("2fa_policy", cfg.twoFactorPolicy).
 		Bool("require_tls", cfg.requireTLS).
 		Int("audit_retention_days", cfg.auditRetentionDays).
 		Msg("lumi-server starting")
@@ -413,6 +822,9 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 	// Stores.
 	userStore := pg.NewUserStore(pool)
 	sessionStore := pg.NewSessionStore(pool)
+	apiTokenStore := pg.NewAPITokenStore(pool)
+	twoFactorStore := pg.NewTwoFactorStore(pool)
+	webauthnStore := pg.NewWebAuthnStore(pool)
 	consentStore := pg.NewConsentStore(pool)
 	auditStore := pg.NewAuditStore(pool)
 	vaultStore := pg.NewVaultStore(pool)
@@ -421,22 +833,120 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 	inviteStore := pg.NewInviteStore(pool)
 	noteStore := pg.NewNoteStore(pool)
 	noteYjsStore := pg.NewNoteYjsStore(pool)
-	crdtRegistry := crdt.NewRegistry(crdtRepoAdapter{noteYjsStore})
+	crdtRegistry := crdt.NewRegistry(crdtRepoAdapter{noteYjsStore},
+		crdt.WithCacheBytes(int64(cfg.crdtCacheMB)<<20))
+	zlog.Info().Str("backend", crdt.Backend).Msg("crdt engine")
+	go logCacheStats(ctx, zlog, crdtRegistry, 5*time.Minute)
+	// Compaction runs off the write path; PersistChange only nudges it.
+	crdtCompactor := crdt.NewCompactor(crdtRegistry, crdtRepoAdapter{noteYjsStore}, zlog,
+		crdt.WithCompactionLocker(noteYjsStore))
+	go crdtCompactor.Run(ctx)
 
 	// Auth service.
+	var breached *auth.BreachedList
+	if cfg.breachedPasswords != "" {
+		list, err := auth.OpenBreachedList(cfg.breachedPasswords)
+		if err != nil {
+			return nil, nil, fmt.Errorf("LUMI_BREACHED_PASSWORDS: %w", err)
+		}
+		breached = list
+		zlog.Info().Int("entries", list.Len()).Msg("breached password list loaded")
+	}
+	auth.MaxConcurrentHashes = cfg.argon2Concurrency
 	authCfg := auth.Config{
-		BcryptCost:         12,
+		Argon2: auth.Argon2Params{
+			Memory:  uint32(cfg.argon2MemoryKiB),
+			Time:    uint32(cfg.argon2Time),
+			Threads: uint8(cfg.argon2Threads),
+		},
+		PasswordMinLength:  cfg.passwordMinLength,
+		BreachedPasswords:  breached,
 		SessionTTL:         30 * 24 * time.Hour,
 		RegistrationPolicy: auth.RegistrationPolicy(cfg.registration),
 		RequireConsent:     cfg.tosVersion != "" && cfg.privacyVersion != "",
 		TosVersion:         cfg.tosVersion,
 		PrivacyVersion:     cfg.privacyVersion,
+		TwoFactorPolicy:    auth.TwoFactorPolicy(cfg.twoFactorPolicy),
+		TOTPIssuer:         cfg.totpIssuer,
+		LoginStepUp:        cfg.loginStepUp,
 		Logger:             zlog,
 	}
 	authSvc, err := auth.NewService(authUserRepoAdapter{userStore}, sessionStore, consentStore, auditStore, authCfg)
 	if err != nil {
 		return nil, nil, fmt.Errorf("auth service: %w", err)
 	}
+	authSvc.SetAPITokenStore(apiTokenStore)
+	authSvc.SetTwoFactorStore(twoFactorStore)
+	authSvc.SetPasswordResetStore(pg.NewPasswordResetStore(pool))
+	authSvc.SetSettingsStore(pg.NewSettingsStore(pool))
+	authSvc.SetLoginSecurity(pg.NewLoginSecurityStore(pool))
+	authSvc.SetDeviceAuthStore(pg.NewDeviceAuthStore(pool), cfg.publicBaseURL)
+	if cfg.tokenMode == "signed" {
+		signer, err := auth.NewAccessSigner(time.Duration(cfg.accessTTLMinutes)*time.Minute, cfg.accessKeys...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("access tokens: %w", err)
+		}
+		authSvc.SetAccessTokens(signer, pg.NewRefreshTokenStore(pool))
+		zlog.Info().Int("ttl_minutes", cfg.accessTTLMinutes).Msg("signed access tokens enabled")
+	}
+	// Rate limits are shared by all replicas through Postgres unless
+	// LUMI_RATE_LIMIT_STORE=memory; limitStore stays nil then.
+	var limitStore auth.LimiterStore
+	if cfg.rateLimitStore == "postgres" {
+		store := pg.NewRateLimitStore(pool)
+		limitStore = store
+		authSvc.SetLimiterStore(store)
+		go auth.SweepRateLimits(ctx, store, time.Hour, 24*time.Hour, zlog)
+	}
+	acceptPolicy := auth.LimitPolicy{Capacity: 10, Window: time.Minute, IdleTTL: time.Hour, Lockout: 5 * time.Minute, MaxLockout: time.Hour}
+	if cfg.geoipCSV != "" {
+		geo, err := geoip.Open(cfg.geoipCSV)
+		if err != nil {
+			return nil, nil, fmt.Errorf("LUMI_GEOIP_CSV: %w", err)
+		}
+		authSvc.SetLocator(geo)
+		zlog.Info().Int("blocks", geo.Len()).Msg("geoip loaded; impossible travel checks enabled")
+	}
+	rp := cfg.webauthnRP()
+	authSvc.SetWebAuthn(webauthnStore, rp)
+	if rp.Enabled() {
+		zlog.Info().Str("rp_id", rp.ID).Strs("origins", rp.Origins).Msg("webauthn enabled")
+	}
+	if cfg.oidc.issuer != "" {
+		// A provider that is down at boot disables SSO rather than the
+		// server; password and passkey sign-in keep working.
+		provider, err := oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidcRedirectURL(),
+			Scopes:       cfg.oidc.scopes,
+		})
+		if err != nil {
+			zlog.Warn().Err(err).Str("issuer", cfg.oidc.issuer).Msg("oidc discovery failed; single sign-on disabled")
+		} else {
+			authSvc.SetOIDC(provider, pg.NewOIDCStore(pool), auth.OIDCOptions{
+				GroupsClaim: cfg.oidc.groupsClaim,
+				GroupRoles:  cfg.oidc.groupRoles,
+			})
+			authSvc.SetVaultRoleGranter(vaultRoleGranter{roles: roleStore, members: memberStore})
+			zlog.Info().Str("issuer", provider.Issuer()).Int("group_roles", len(cfg.oidc.groupRoles)).Msg("oidc enabled")
+		}
+	}
+
+	// Outgoing mail goes through a Postgres outbox; the worker retries
+	// while the relay is unreachable.
+	var outbox *mail.Outbox
+	if cfg.mail.transport != "" {
+		sender, err := cfg.mail.mailSender(zlog)
+		if err != nil {
+			return nil, nil, fmt.Errorf("mail: %w", err)
+		}
+		outbox = mail.NewOutbox(pg.NewMailOutboxStore(pool), sender, zlog)
+		go outbox.Run(ctx)
+		authSvc.SetMailer(outbox, cfg.publicBaseURL)
+		zlog.Info().Str("transport", cfg.mail.transport).Msg("mail enabled")
+	}
 
 	// Bootstrap admin if env supplies credentials and DB is empty.
 	if err := auth.Bootstrap(ctx, authSvc, userStore, auth.BootstrapConfig{
@@ -458,20 +968,40 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 	vaultsSvc := vaults.NewService(vaultStore, roleStore, memberStore, fsMgr, auditStore, fedResolver)
 	usersSvc := users.NewService(userStore, consentStore, auditStore, auditStore, vaultStore)
 	usersSvc.SetVaultDirRemover(fsMgr)
+	// Data exports are written to disk by a background worker and
+	// downloaded later.
+	if err := usersSvc.SetExports(pg.NewUserExportStore(pool), cfg.exportDir, users.ExportSources{
+		Notes:    noteStore,
+		Files:    fsMgr,
+		Invites:  inviteStore,
+		Sessions: sessionStore,
+	}, zlog); err != nil {
+		return nil, nil, err
+	}
+	go usersSvc.RunExports(ctx)
+	adminSvc := admin.NewService(userStore, vaultSummaryAdapter{vaultStore}, authSvc, registrationAdapter{authSvc}, auditStore)
+	adminSvc.SetDiskUsage(fsMgr)
 	// FS watcher. Handler is set below once the WS hub exists; the
 	// silencer side (SkipNext) is what notes.Service needs at this
 	// point, and that surface is available immediately.
-	fsWatcher, err := fswatch.New(fsMgr.Root, fsMgr, nil, zlog)
+	fsWatcher, err := fswatch.New(fsMgr.Root, fsMgr, nil, zlog,
+		fswatch.WithMode(cfg.fswatchMode),
+		fswatch.WithPollInterval(time.Duration(cfg.fswatchPollSeconds)*time.Second))
 	if err != nil {
 		return nil, nil, fmt.Errorf("fswatch: %w", err)
 	}
 
 	notesSvc := notes.NewService(noteStore, vaultStore, fsMgr, auditStore, fedResolver, crdtRegistry, fsWatcher)
+	notesSvc.SetCompactor(crdtCompactor)
 	wsHub := wsync.NewHub(crdtRegistry, wsync.WithFSMirror(notesSvc.WriteBodyFromCRDT))
+	authSvc.SetSessionTerminator(wsHub)
 
-	fsWatcher.SetHandler(buildFSHandler(zlog, vaultStore, noteStore, fsMgr, crdtRegistry, wsHub))
+	fsHandler := buildFSHandler(zlog, vaultStore, noteStore, notesSvc, fsMgr, crdtRegistry, wsHub)
+	fsWatcher.SetHandler(fsHandler)
+	notesSvc.SetFSReplayer(fsReplayAdapter{h: fsHandler, fs: fsMgr})
 	vaultsSvc.SetWatcher(fsWatcher)
 	vaultsSvc.SetOwnershipDeps(memberStore, userStore, notesSvc)
+	vaultsSvc.SetDocInvalidator(crdtRegistry)
 	if err := fsWatcher.WatchExistingVaults(); err != nil {
 		zlog.Warn().Err(err).Msg("fswatch: WatchExistingVaults")
 	}
@@ -508,6 +1038,7 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 	notesSvc.SetFederationNotifier(relayLinks)
 	relayManager := federation.NewManager(federationSvc, relayLinks, nil, zlog)
 	federationSvc.SetLinkController(relayManager)
+	federationSvc.SetLimiter(auth.NewLimiter(limitStore, auth.ScopeFederationAccept, acceptPolicy, zlog))
 
 	// F3 control plane.
 	fedStore := pg.NewFederationStore(pool)
@@ -531,6 +1062,9 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 	if err := relayManager.Start(ctx); err != nil {
 		zlog.Warn().Err(err).Msg("federation: relay manager start")
 	}
+	// Catch up on edits made while the server was down. Runs after the
+	// relay is wired so imports and deletes reach federated peers.
+	go reconcileVaults(ctx, zlog, vaultStore, notesSvc)
 	invitesSvc := invites.NewService(invites.Deps{
 		Repo:          inviteStore,
 		Users:         userStore,
@@ -544,6 +1078,10 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 		Audit:         auditStore,
 		PublicBaseURL: cfg.publicBaseURL,
 	})
+	if outbox != nil {
+		invitesSvc.SetMailer(outbox)
+	}
+	invitesSvc.SetLimiter(auth.NewLimiter(limitStore, auth.ScopeInviteAccept, acceptPolicy, zlog))
 
 	// Fiber app. BodyLimit is set to 4 MiB to accommodate note bodies;
 	// auth/admin payloads are < 1 KiB so the larger cap costs nothing.
@@ -583,6 +1121,7 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 	authed := app.Group("/api", auth.Required(authSvc))
 
 	users.NewHandlers(usersSvc, authSvc).Register(authed)
+	admin.NewHandlers(adminSvc).Register(authed.Group("/admin", capguard.SessionOnly(), auth.AdminOnly(authSvc)))
 	vaults.NewHandlers(vaultsSvc).Register(authed)
 	roles.NewHandlers(rolesSvc, fedResolver).Register(authed)
 	members.NewHandlers(membersSvc, fedResolver).Register(authed)
@@ -599,18 +1138,53 @@ func buildApp(ctx context.Context, cfg config, zlog zerolog.Logger, pool *pgxpoo
 		_ = ctx
 		_ = fsWatcher.Close()
 		wsHub.Close()
+		crdtRegistry.Close()
 		return nil
 	}
 	return app, shutdown, nil
 }
 
+// logCacheStats emits the CRDT document cache counters every interval
+// until ctx is cancelled. Idle periods (no lookups since the last tick)
+// are skipped to keep the log quiet.
+func logCacheStats(ctx context.Context, zlog zerolog.Logger, reg *crdt.Registry, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var last uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		st := reg.CacheStats()
+		lookups := st.Hits + st.Misses
+		if lookups == last {
+			continue
+		}
+		last = lookups
+		zlog.Info().
+			Int("entries", st.Entries).
+			Int64("bytes", st.Bytes).
+			Int64("max_bytes", st.MaxBytes).
+			Uint64("hits", st.Hits).
+			Uint64("misses", st.Misses).
+			Uint64("evictions", st.Evictions).
+			Float64("hit_rate", st.HitRate()).
+			Msg("crdt cache stats")
+	}
+}
+
 // buildFSHandler stitches an fswatch.Handler that routes external markdown
-// edits into the CRDT layer. Pipeline:
+// changes into the notes/CRDT layer. Removes and moves go through
+// notes.Service (row, CRDT state, audit and federation in one place); a
+// deleted note's live room is closed. Writes follow this pipeline:
 //
 //  1. Look up the vault by on-disk slug (skip if unknown — could be a
 //     stale dir left behind by a deleted vault).
-//  2. Look up the note row by path (skip if no row — Phase 2.4 does NOT
-//     auto-create on drop-in files; that's a 2.4.b call).
+//  2. Look up the note row by path. A file with no row was dropped in
+//     from outside (sync tool, editor) and is imported as a new note;
+//     the import seeds the CRDT, so the pipeline stops there.
 //  3. Read frontmatter + body from disk.
 //  4. If a live WS Room exists, drive the change through it so
 //     subscribers see the broadcast; otherwise apply + persist via the
@@ -624,6 +1198,7 @@ func buildFSHandler(
 	zlog zerolog.Logger,
 	vaultStore *pg.VaultStore,
 	noteStore *pg.NoteStore,
+	notesSvc *notes.Service,
 	fsMgr *fs.Manager,
 	crdtReg *crdt.Registry,
 	hub *wsync.Hub,
@@ -636,9 +1211,42 @@ func buildFSHandler(
 			log.Debug().Err(err).Str("slug", ev.VaultSlug).Msg("vault lookup failed")
 			return
 		}
+		switch ev.Op {
+		case fswatch.OpRemove:
+			ids, err := notesSvc.DeleteFromFS(ctx, v.ID, ev.RelativePath, ev.Dir)
+			if err != nil {
+				log.Warn().Err(err).Str("path", ev.RelativePath).Msg("delete from fs failed")
+			}
+			for _, id := range ids {
+				hub.CloseRoom(v.ID, id)
+			}
+			return
+		case fswatch.OpMove:
+			replaced, err := notesSvc.MoveFromFS(ctx, v.ID, ev.OldRelativePath, ev.RelativePath)
+			if errors.Is(err, domain.ErrNotFound) {
+				log.Debug().Str("path", ev.OldRelativePath).Msg("moved file is not a note")
+				return
+			}
+			if err != nil {
+				log.Warn().Err(err).Str("from", ev.OldRelativePath).Str("to", ev.RelativePath).Msg("move from fs failed")
+			}
+			if replaced != "" {
+				hub.CloseRoom(v.ID, replaced)
+			}
+			return
+		}
 		n, err := noteStore.GetByPath(ctx, v.ID, ev.RelativePath)
+		if errors.Is(err, domain.ErrNotFound) {
+			n, err = notesSvc.ImportFromFS(ctx, v.ID, ev.RelativePath)
+			if err != nil {
+				log.Warn().Err(err).Str("path", ev.RelativePath).Msg("import from fs failed")
+				return
+			}
+			log.Info().Str("path", n.Path).Str("note", n.ID).Msg("imported note from fs")
+			return
+		}
 		if err != nil {
-			log.Debug().Err(err).Str("path", ev.RelativePath).Msg("note lookup failed (no auto-create in 2.4)")
+			log.Warn().Err(err).Str("path", ev.RelativePath).Msg("note lookup failed")
 			return
 		}
 		_, body, err := fsMgr.ReadNote(v.Slug, n.Path)
@@ -650,9 +1258,14 @@ func buildFSHandler(
 		// If subscribers are connected, drive the diff through the
 		// live Room so they see the update. Otherwise apply + persist
 		// without touching the WS layer.
-		newText := string(body)
+		// ReadNote keeps the blank line WriteNote puts after the
+		// frontmatter; it is not part of the CRDT text.
+		newText := strings.TrimPrefix(string(body), "\n")
 		if room := hub.RoomIfActive(v.ID, n.ID); room != nil {
 			doc := room.Doc()
+			if skipStructured(log, doc, n.Path) {
+				return
+			}
 			update, err := doc.ApplyTextDiff(newText, originKind)
 			if err != nil {
 				log.Warn().Err(err).Msg("live ApplyTextDiff failed")
@@ -674,6 +1287,9 @@ func buildFSHandler(
 			return
 		}
 		defer doc.Close()
+		if skipStructured(log, doc, n.Path) {
+			return
+		}
 		update, err := doc.ApplyTextDiff(newText, originKind)
 		if err != nil {
 			log.Warn().Err(err).Msg("cold ApplyTextDiff failed")
@@ -695,6 +1311,55 @@ func buildFSHandler(
 	})
 }
 
+// reconcileVaults runs a quick (mtime-based) notes.Reconcile over every
+// vault and logs what it replayed. Failures are per vault and logged.
+func reconcileVaults(ctx context.Context, zlog zerolog.Logger, vaultStore *pg.VaultStore, notesSvc *notes.Service) {
+	log := zlog.With().Str("component", "reconcile").Logger()
+	vs, err := vaultStore.ListAll(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("list vaults failed")
+		return
+	}
+	var dirty int
+	for _, v := range vs {
+		rep, err := notesSvc.Reconcile(ctx, v.ID, false, uuid.Nil, "", "")
+		if err != nil {
+			log.Warn().Err(err).Str("slug", v.Slug).Msg("reconcile failed")
+			continue
+		}
+		for _, e := range rep.Errors {
+			log.Warn().Str("slug", v.Slug).Str("path", e.Path).Str("detail", e.Detail).Msg("reconcile skipped file")
+		}
+		if !rep.Dirty() {
+			continue
+		}
+		dirty++
+		log.Info().
+			Str("slug", v.Slug).
+			Int("changed", len(rep.Changed)).
+			Int("appeared", len(rep.Appeared)).
+			Int("vanished", len(rep.Vanished)).
+			Int("moved", len(rep.Moved)).
+			Msg("vault reconciled")
+	}
+	log.Info().Int("vaults", len(vs)).Int("dirty", dirty).Msg("startup reconcile done")
+}
+
+// skipStructured reports whether an on-disk edit must be dropped
+// because the note's body lives in the rich editor's prosemirror
+// fragment. The .md file is a rendered projection of that fragment;
+// there is no markdown → ProseMirror import, and diffing into the
+// "content" YText would be invisible to the editor. The next live edit
+// re-renders the file.
+func skipStructured(log zerolog.Logger, doc *crdt.Doc, path string) bool {
+	structured, err := doc.Structured()
+	if err != nil || !structured {
+		return false
+	}
+	log.Warn().Str("path", path).Msg("external edit to structured note ignored")
+	return true
+}
+
 // ---------------------------------------------------------- middleware ------
 
 func securityHeaders() fiber.Handler {
@@ -824,3 +1489,96 @@ func migrationsDir() string {
 	}
 	return "migrations"
 }
+
+// ------------------------------------------------------------------ fsck ----
+
+// fsckOutput is the JSON document `lumi-server fsck` prints to stdout.
+type fsckOutput struct {
+	Backend string             `json:"backend"`
+	Vaults  []notes.FsckReport `json:"vaults"`
+}
+
+// fsckCmd cross-checks note rows, markdown files and CRDT state for one
+// vault (or every vault) and prints a JSON report. Exits exitOK when
+// nothing is left to fix and exitFsckDirty otherwise.
+//
+// The command talks to Postgres and the vault tree directly, so repairs
+// bypass a running server's document cache: stop the server first, or
+// use POST /api/vaults/:vault/crdt/fsck on the live instance.
+func fsckCmd(args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	slug := flags.String("vault", "", "check only the vault with this slug")
+	repairFlag := flags.String("repair", "", "repair direction: crdt-to-fs or fs-to-crdt")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	repair, err := notes.ParseFsckRepair(*repairFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: %v\n", err)
+		return exitUsage
+	}
+
+	_ = godotenv.Load()
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		return exitConfig
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	pool, err := pg.New(ctx, cfg.databaseURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "postgres connect: %v\n", err)
+		return exitDB
+	}
+	defer pool.Close()
+	fsMgr, err := fs.NewManager(cfg.root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fs manager: %v\n", err)
+		return exitConfig
+	}
+
+	vaultStore := pg.NewVaultStore(pool)
+	auditStore := pg.NewAuditStore(pool)
+	// One pass over every note: caching documents would only hold memory.
+	crdtRegistry := crdt.NewRegistry(crdtRepoAdapter{pg.NewNoteYjsStore(pool)}, crdt.WithCacheBytes(0))
+	// Capability checks never run here, but the service requires a resolver.
+	resolver := members.NewService(memberRepoAdapter{pg.NewMemberStore(pool)}, auditStore)
+	notesSvc := notes.NewService(pg.NewNoteStore(pool), vaultStore, fsMgr, auditStore, resolver, crdtRegistry, nil)
+
+	var targets []domain.Vault
+	if *slug != "" {
+		v, err := vaultStore.GetBySlug(ctx, *slug)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fsck: vault %q: %v\n", *slug, err)
+			return exitUsage
+		}
+		targets = append(targets, v)
+	} else if targets, err = vaultStore.ListAll(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: list vaults: %v\n", err)
+		return exitDB
+	}
+
+	out := fsckOutput{Backend: crdt.Backend, Vaults: []notes.FsckReport{}}
+	clean := true
+	for _, v := range targets {
+		rep, err := notesSvc.Fsck(ctx, v.ID, repair, uuid.Nil, "", "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fsck: vault %q: %v\n", v.Slug, err)
+			return exitDB
+		}
+		clean = clean && rep.Clean()
+		out.Vaults = append(out.Vaults, rep)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintf(os.Stderr, "fsck: write report: %v\n", err)
+		return exitDB
+	}
+	if !clean {
+		return exitFsckDirty
+	}
+	return exitOK
+}
@@ -0,0 +1,553 @@
+// Package admin implements the server-wide administration API: finding
+// users, suspending them, ending their sessions, granting the admin
+// flag, an overview of every vault, and switching the registration
+// policy at runtime. Authority here is the users.is_admin flag, not a
+// vault capability; the routes are mounted behind auth.AdminOnly.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/audit"
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// UserRepo is the storage surface this package needs.
+type UserRepo interface {
+	GetByID(ctx context.Context, id uuid.UUID) (domain.User, error)
+	Search(ctx context.Context, query string, limit, offset int) ([]domain.User, int, error)
+	SetSuspended(ctx context.Context, id uuid.UUID, at *time.Time) error
+	SetAdmin(ctx context.Context, id uuid.UUID, admin bool) error
+}
+
+// VaultSummary is one row of the vault overview.
+type VaultSummary struct {
+	Vault         domain.Vault
+	OwnerUsername string
+	Members       int
+	Notes         int
+	CRDTBytes     int64
+}
+
+// VaultLister returns every vault on the server.
+type VaultLister interface {
+	ListSummaries(ctx context.Context) ([]VaultSummary, error)
+}
+
+// DiskUsage measures a vault's directory. Implemented by *fs.Manager.
+type DiskUsage interface {
+	DiskUsage(slug string) (int64, error)
+}
+
+// SessionRevoker ends a user's sessions, cuts their connections and
+// refuses the access tokens already issued to them. Implemented by
+// *auth.Service.
+type SessionRevoker interface {
+	ForceLogout(ctx context.Context, userID uuid.UUID) (int, error)
+	DisconnectUser(userID uuid.UUID) int
+	ExpireAccessTokens(ctx context.Context, userID uuid.UUID) error
+}
+
+// RegistrationControl reads and switches the registration policy.
+type RegistrationControl interface {
+	RegistrationPolicy(ctx context.Context) string
+	SetRegistrationPolicy(ctx context.Context, policy string) error
+}
+
+// Service orchestrates the admin operations. Every mutation is audited
+// under the acting admin's id, with the target in the payload.
+type Service struct {
+	users    UserRepo
+	vaults   VaultLister
+	disk     DiskUsage
+	sessions SessionRevoker
+	reg      RegistrationControl
+	recorder audit.Recorder
+	now      func() time.Time
+}
+
+func NewService(users UserRepo, vaults VaultLister, sessions SessionRevoker, reg RegistrationControl, recorder audit.Recorder) *Service {
+	if users == nil || vaults == nil || sessions == nil || reg == nil || recorder == nil {
+		panic("admin.NewService: all dependencies are required")
+	}
+	return &Service{
+		users:    users,
+		vaults:   vaults,
+		sessions: sessions,
+		reg:      reg,
+		recorder: recorder,
+		now:      time.Now,
+	}
+}
+
+// SetDiskUsage wires on-disk vault sizes into ListVaults; nil leaves
+// them out.
+func (s *Service) SetDiskUsage(d DiskUsage) { s.disk = d }
+
+// Actor is the admin making a request, for the audit trail.
+type Actor struct {
+	UserID    uuid.UUID
+	IP        *string
+	UserAgent *string
+}
+
+// SearchUsers pages through users matching query.
+func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset int) ([]domain.User, int, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.users.Search(ctx, strings.TrimSpace(query), limit, offset)
+}
+
+func (s *Service) GetUser(ctx context.Context, id uuid.UUID) (domain.User, error) {
+	return s.users.GetByID(ctx, id)
+}
+
+// Suspend blocks every credential of the account until Unsuspend: its
+// sessions are ended and its open WebSockets closed, and its personal
+// access tokens are refused while the flag is set. Data, memberships
+// and vault ownership are kept. Admins cannot suspend themselves.
+func (s *Service) Suspend(ctx context.Context, actor Actor, id uuid.UUID, reason string) (domain.User, error) {
+	if id == actor.UserID {
+		return domain.User{}, fmt.Errorf("admin: cannot suspend yourself: %w", domain.ErrValidation)
+	}
+	u, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	if u.SuspendedAt != nil {
+		return u, nil
+	}
+	at := s.now().UTC()
+	if err := s.users.SetSuspended(ctx, id, &at); err != nil {
+		return domain.User{}, err
+	}
+	u.SuspendedAt = &at
+	revoked, err := s.sessions.ForceLogout(ctx, id)
+	if err != nil {
+		// The flag already blocks the sessions at their next refresh;
+		// only the cleanup failed, so at least refuse their access tokens.
+		revoked = -1
+		_ = s.sessions.ExpireAccessTokens(ctx, id)
+	}
+	closed := s.sessions.DisconnectUser(id)
+	s.record(ctx, actor, domain.ActionAdminSuspend, map[string]any{
+		"target_user_id":     id,
+		"reason":             strings.TrimSpace(reason),
+		"sessions_revoked":   revoked,
+		"connections_closed": closed,
+	})
+	return u, nil
+}
+
+// Unsuspend lifts a suspension.
+func (s *Service) Unsuspend(ctx context.Context, actor Actor, id uuid.UUID) (domain.User, error) {
+	u, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	if u.SuspendedAt == nil {
+		return u, nil
+	}
+	if err := s.users.SetSuspended(ctx, id, nil); err != nil {
+		return domain.User{}, err
+	}
+	u.SuspendedAt = nil
+	s.record(ctx, actor, domain.ActionAdminUnsuspend, map[string]any{"target_user_id": id})
+	return u, nil
+}
+
+// ForceLogout ends every session of the user.
+func (s *Service) ForceLogout(ctx context.Context, actor Actor, id uuid.UUID) (int, error) {
+	if _, err := s.users.GetByID(ctx, id); err != nil {
+		return 0, err
+	}
+	n, err := s.sessions.ForceLogout(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	s.record(ctx, actor, domain.ActionAdminForceLogout, map[string]any{
+		"target_user_id":   id,
+		"sessions_revoked": n,
+	})
+	return n, nil
+}
+
+// SetAdmin grants or withdraws server-admin authority. The target's
+// access tokens are refused so their next refresh carries the new flag.
+// Admins cannot demote themselves, so the server always keeps at least
+// the caller.
+func (s *Service) SetAdmin(ctx context.Context, actor Actor, id uuid.UUID, isAdmin bool) (domain.User, error) {
+	if id == actor.UserID && !isAdmin {
+		return domain.User{}, fmt.Errorf("admin: cannot revoke your own admin flag: %w", domain.ErrValidation)
+	}
+	u, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return domain.User{}, err
+	}
+	if u.IsAdmin == isAdmin {
+		return u, nil
+	}
+	if err := s.users.SetAdmin(ctx, id, isAdmin); err != nil {
+		return domain.User{}, err
+	}
+	u.IsAdmin = isAdmin
+	// AdminOnly re-reads the flag, so a failure here only delays the
+	// claim in the target's tokens.
+	_ = s.sessions.ExpireAccessTokens(ctx, id)
+	action := domain.ActionAdminGrant
+	if !isAdmin {
+		action = domain.ActionAdminRevoke
+	}
+	s.record(ctx, actor, action, map[string]any{"target_user_id": id})
+	return u, nil
+}
+
+// VaultOverview is a VaultSummary plus its size on disk (-1 when it
+// could not be measured or disk usage is not wired).
+type VaultOverview struct {
+	VaultSummary
+	DiskBytes int64
+}
+
+// ListVaults returns every vault with its owner and sizes.
+func (s *Service) ListVaults(ctx context.Context) ([]VaultOverview, error) {
+	rows, err := s.vaults.ListSummaries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]VaultOverview, 0, len(rows))
+	for _, r := range rows {
+		ov := VaultOverview{VaultSummary: r, DiskBytes: -1}
+		if s.disk != nil {
+			if n, err := s.disk.DiskUsage(r.Vault.Slug); err == nil {
+				ov.DiskBytes = n
+			}
+		}
+		out = append(out, ov)
+	}
+	return out, nil
+}
+
+// RegistrationPolicy returns the policy in force.
+func (s *Service) RegistrationPolicy(ctx context.Context) string {
+	return s.reg.RegistrationPolicy(ctx)
+}
+
+// SetRegistrationPolicy switches the policy on every replica. It is
+// stored, so it survives restarts and LUMI_REGISTRATION no longer
+// applies.
+func (s *Service) SetRegistrationPolicy(ctx context.Context, actor Actor, policy string) error {
+	from := s.reg.RegistrationPolicy(ctx)
+	if policy == from {
+		return nil
+	}
+	if err := s.reg.SetRegistrationPolicy(ctx, policy); err != nil {
+		return err
+	}
+	s.record(ctx, actor, domain.ActionAdminRegistration, map[string]any{"from": from, "to": policy})
+	return nil
+}
+
+func (s *Service) record(ctx context.Context, actor Actor, action string, payload map[string]any) {
+	body, _ := json.Marshal(payload)
+	_ = s.recorder.Record(ctx, domain.AuditEntry{
+		UserID:    &actor.UserID,
+		Action:    action,
+		Payload:   body,
+		IP:        actor.IP,
+		UserAgent: actor.UserAgent,
+		CreatedAt: s.now(),
+	})
+}
+
+// ---- HTTP ------------------------------------------------------------------
+
+type Handlers struct {
+	svc *Service
+}
+
+func NewHandlers(svc *Service) *Handlers {
+	if svc == nil {
+		panic("admin.NewHandlers: nil service")
+	}
+	return &Handlers{svc: svc}
+}
+
+// Register mounts the routes on r, which the caller has already
+// restricted to signed-in server admins (e.g. the /api/admin group).
+func (h *Handlers) Register(r fiber.Router) {
+	r.Get("/users", h.ListUsers)
+	r.Get("/users/:id", h.GetUser)
+	r.Post("/users/:id/suspend", h.Suspend)
+	r.Post("/users/:id/unsuspend", h.Unsuspend)
+	r.Post("/users/:id/logout", h.ForceLogout)
+	r.Put("/users/:id/admin", h.SetAdmin)
+	r.Get("/vaults", h.ListVaults)
+	r.Get("/settings", h.GetSettings)
+	r.Patch("/settings", h.UpdateSettings)
+}
+
+const userIDKey = "auth.user"
+
+type userDTO struct {
+	ID               uuid.UUID  `json:"id"`
+	Username         string     `json:"username"`
+	DisplayName      string     `json:"display_name"`
+	CreatedAt        time.Time  `json:"created_at"`
+	IsAdmin          bool       `json:"is_admin"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	SuspendedAt      *time.Time `json:"suspended_at"`
+}
+
+func toUserDTO(u domain.User) userDTO {
+	return userDTO{
+		ID:               u.ID,
+		Username:         u.Username,
+		DisplayName:      u.DisplayName,
+		CreatedAt:        u.CreatedAt,
+		IsAdmin:          u.IsAdmin,
+		TwoFactorEnabled: u.TwoFactorEnabled,
+		SuspendedAt:      u.SuspendedAt,
+	}
+}
+
+type vaultDTO struct {
+	ID        uuid.UUID `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Owner     struct {
+		ID       uuid.UUID `json:"id"`
+		Username string    `json:"username"`
+	} `json:"owner"`
+	Members   int   `json:"members"`
+	Notes     int   `json:"notes"`
+	CRDTBytes int64 `json:"crdt_bytes"`
+	DiskBytes int64 `json:"disk_bytes"`
+}
+
+func (h *Handlers) actor(c *fiber.Ctx) (Actor, bool) {
+	u, ok := c.Locals(userIDKey).(*domain.User)
+	if !ok || u == nil {
+		return Actor{}, false
+	}
+	return Actor{UserID: u.ID, IP: callerIP(c), UserAgent: callerUA(c)}, true
+}
+
+// ListUsers — GET /api/admin/users?q=&limit=&offset=.
+func (h *Handlers) ListUsers(c *fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	users, total, err := h.svc.SearchUsers(c.UserContext(), c.Query("q"), limit, offset)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	out := make([]userDTO, 0, len(users))
+	for _, u := range users {
+		out = append(out, toUserDTO(u))
+	}
+	return c.JSON(fiber.Map{"users": out, "total": total})
+}
+
+// GetUser — GET /api/admin/users/:id.
+func (h *Handlers) GetUser(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_user_id"})
+	}
+	u, err := h.svc.GetUser(c.UserContext(), id)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return c.JSON(toUserDTO(u))
+}
+
+type suspendReq struct {
+	Reason string `json:"reason"`
+}
+
+// Suspend — POST /api/admin/users/:id/suspend.
+func (h *Handlers) Suspend(c *fiber.Ctx) error {
+	actor, ok := h.actor(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_user_id"})
+	}
+	var body suspendReq
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_body"})
+		}
+	}
+	u, err := h.svc.Suspend(c.UserContext(), actor, id, body.Reason)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return c.JSON(toUserDTO(u))
+}
+
+// Unsuspend — POST /api/admin/users/:id/unsuspend.
+func (h *Handlers) Unsuspend(c *fiber.Ctx) error {
+	actor, ok := h.actor(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_user_id"})
+	}
+	u, err := h.svc.Unsuspend(c.UserContext(), actor, id)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return c.JSON(toUserDTO(u))
+}
+
+// ForceLogout — POST /api/admin/users/:id/logout.
+func (h *Handlers) ForceLogout(c *fiber.Ctx) error {
+	actor, ok := h.actor(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_user_id"})
+	}
+	n, err := h.svc.ForceLogout(c.UserContext(), actor, id)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return c.JSON(fiber.Map{"sessions_revoked": n})
+}
+
+type setAdminReq struct {
+	IsAdmin *bool `json:"is_admin"`
+}
+
+// SetAdmin — PUT /api/admin/users/:id/admin.
+func (h *Handlers) SetAdmin(c *fiber.Ctx) error {
+	actor, ok := h.actor(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_user_id"})
+	}
+	var body setAdminReq
+	if err := c.BodyParser(&body); err != nil || body.IsAdmin == nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_body"})
+	}
+	u, err := h.svc.SetAdmin(c.UserContext(), actor, id, *body.IsAdmin)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return c.JSON(toUserDTO(u))
+}
+
+// ListVaults — GET /api/admin/vaults.
+func (h *Handlers) ListVaults(c *fiber.Ctx) error {
+	rows, err := h.svc.ListVaults(c.UserContext())
+	if err != nil {
+		return mapErr(c, err)
+	}
+	out := make([]vaultDTO, 0, len(rows))
+	for _, r := range rows {
+		d := vaultDTO{
+			ID:        r.Vault.ID,
+			Slug:      r.Vault.Slug,
+			Name:      r.Vault.Name,
+			CreatedAt: r.Vault.CreatedAt,
+			Members:   r.Members,
+			Notes:     r.Notes,
+			CRDTBytes: r.CRDTBytes,
+			DiskBytes: r.DiskBytes,
+		}
+		d.Owner.ID = r.Vault.OwnerUserID
+		d.Owner.Username = r.OwnerUsername
+		out = append(out, d)
+	}
+	return c.JSON(fiber.Map{"vaults": out})
+}
+
+type settingsDTO struct {
+	Registration string `json:"registration"`
+}
+
+// GetSettings — GET /api/admin/settings.
+func (h *Handlers) GetSettings(c *fiber.Ctx) error {
+	return c.JSON(settingsDTO{Registration: h.svc.RegistrationPolicy(c.UserContext())})
+}
+
+// UpdateSettings — PATCH /api/admin/settings. Changes are stored and
+// apply to every replica.
+func (h *Handlers) UpdateSettings(c *fiber.Ctx) error {
+	actor, ok := h.actor(c)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	var body settingsDTO
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_body"})
+	}
+	if body.Registration != "" {
+		if err := h.svc.SetRegistrationPolicy(c.UserContext(), actor, body.Registration); err != nil {
+			return mapErr(c, err)
+		}
+	}
+	return c.JSON(settingsDTO{Registration: h.svc.RegistrationPolicy(c.UserContext())})
+}
+
+func callerIP(c *fiber.Ctx) *string {
+	ip := c.IP()
+	if ip == "" {
+		return nil
+	}
+	return &ip
+}
+
+func callerUA(c *fiber.Ctx) *string {
+	ua := c.Get(fiber.HeaderUserAgent)
+	if ua == "" {
+		return nil
+	}
+	return &ua
+}
+
+func mapErr(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
+	case errors.Is(err, domain.ErrValidation):
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "validation", "detail": err.Error()})
+	case errors.Is(err, domain.ErrConflict):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "conflict"})
+	default:
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal"})
+	}
+}
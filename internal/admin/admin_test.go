@@ -0,0 +1,230 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+type fakeUsers struct {
+	byID map[uuid.UUID]domain.User
+}
+
+func (f *fakeUsers) GetByID(_ context.Context, id uuid.UUID) (domain.User, error) {
+	u, ok := f.byID[id]
+	if !ok {
+		return domain.User{}, domain.ErrNotFound
+	}
+	return u, nil
+}
+func (f *fakeUsers) Search(context.Context, string, int, int) ([]domain.User, int, error) {
+	return nil, 0, nil
+}
+func (f *fakeUsers) SetSuspended(_ context.Context, id uuid.UUID, at *time.Time) error {
+	u := f.byID[id]
+	u.SuspendedAt = at
+	f.byID[id] = u
+	return nil
+}
+func (f *fakeUsers) SetAdmin(_ context.Context, id uuid.UUID, admin bool) error {
+	u := f.byID[id]
+	u.IsAdmin = admin
+	f.byID[id] = u
+	return nil
+}
+
+type fakeVaults struct{ rows []VaultSummary }
+
+func (f fakeVaults) ListSummaries(context.Context) ([]VaultSummary, error) { return f.rows, nil }
+
+type fakeDisk map[string]int64
+
+func (f fakeDisk) DiskUsage(slug string) (int64, error) {
+	n, ok := f[slug]
+	if !ok {
+		return 0, errors.New("missing")
+	}
+	return n, nil
+}
+
+type fakeSessions struct{ revoked, disconnected, expired []uuid.UUID }
+
+func (f *fakeSessions) ForceLogout(_ context.Context, id uuid.UUID) (int, error) {
+	f.revoked = append(f.revoked, id)
+	return 2, nil
+}
+func (f *fakeSessions) DisconnectUser(id uuid.UUID) int {
+	f.disconnected = append(f.disconnected, id)
+	return 1
+}
+func (f *fakeSessions) ExpireAccessTokens(_ context.Context, id uuid.UUID) error {
+	f.expired = append(f.expired, id)
+	return nil
+}
+
+type fakeRegistration struct{ policy string }
+
+func (f *fakeRegistration) RegistrationPolicy(context.Context) string { return f.policy }
+func (f *fakeRegistration) SetRegistrationPolicy(_ context.Context, p string) error {
+	if p != "open" && p != "invite-only" {
+		return domain.ErrValidation
+	}
+	f.policy = p
+	return nil
+}
+
+type fakeRecorder struct{ entries []domain.AuditEntry }
+
+func (f *fakeRecorder) Record(_ context.Context, e domain.AuditEntry) error {
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+type fixture struct {
+	svc      *Service
+	users    *fakeUsers
+	sessions *fakeSessions
+	reg      *fakeRegistration
+	rec      *fakeRecorder
+	admin    Actor
+	target   uuid.UUID
+}
+
+func newFixture() fixture {
+	adminID, targetID := uuid.New(), uuid.New()
+	f := fixture{
+		users: &fakeUsers{byID: map[uuid.UUID]domain.User{
+			adminID:  {ID: adminID, Username: "root", IsAdmin: true},
+			targetID: {ID: targetID, Username: "leaver"},
+		}},
+		sessions: &fakeSessions{},
+		reg:      &fakeRegistration{policy: "invite-only"},
+		rec:      &fakeRecorder{},
+		admin:    Actor{UserID: adminID},
+		target:   targetID,
+	}
+	f.svc = NewService(f.users, fakeVaults{rows: []VaultSummary{
+		{Vault: domain.Vault{Slug: "measured"}},
+		{Vault: domain.Vault{Slug: "gone"}},
+	}}, f.sessions, f.reg, f.rec)
+	f.svc.SetDiskUsage(fakeDisk{"measured": 42})
+	return f
+}
+
+func (f fixture) actions() []string {
+	out := make([]string, 0, len(f.rec.entries))
+	for _, e := range f.rec.entries {
+		out = append(out, e.Action)
+	}
+	return out
+}
+
+func TestSuspendIsAuditedOnceAndReversible(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+
+	u, err := f.svc.Suspend(ctx, f.admin, f.target, "left the company")
+	if err != nil || u.SuspendedAt == nil {
+		t.Fatalf("Suspend = %+v, %v", u, err)
+	}
+	if _, err := f.svc.Suspend(ctx, f.admin, f.target, "again"); err != nil {
+		t.Fatalf("second Suspend: %v", err)
+	}
+	u, err = f.svc.Unsuspend(ctx, f.admin, f.target)
+	if err != nil || u.SuspendedAt != nil {
+		t.Fatalf("Unsuspend = %+v, %v", u, err)
+	}
+	got := f.actions()
+	if len(got) != 2 || got[0] != domain.ActionAdminSuspend || got[1] != domain.ActionAdminUnsuspend {
+		t.Fatalf("audit = %v", got)
+	}
+	var payload map[string]any
+	_ = json.Unmarshal(f.rec.entries[0].Payload, &payload)
+	if payload["reason"] != "left the company" || payload["target_user_id"] != f.target.String() {
+		t.Fatalf("suspend payload = %v", payload)
+	}
+	if len(f.sessions.revoked) != 1 || len(f.sessions.disconnected) != 1 || payload["connections_closed"] != 1.0 {
+		t.Fatalf("suspend did not cut access once: revoked %v, disconnected %v", f.sessions.revoked, f.sessions.disconnected)
+	}
+	if *f.rec.entries[0].UserID != f.admin.UserID {
+		t.Fatal("audit entry not attributed to the acting admin")
+	}
+}
+
+func TestAdminsCannotLockThemselvesOut(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+	if _, err := f.svc.Suspend(ctx, f.admin, f.admin.UserID, ""); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("self-suspend err = %v", err)
+	}
+	if _, err := f.svc.SetAdmin(ctx, f.admin, f.admin.UserID, false); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("self-demote err = %v", err)
+	}
+	if len(f.rec.entries) != 0 {
+		t.Fatalf("rejected calls were audited: %v", f.actions())
+	}
+}
+
+func TestSetAdminAndForceLogout(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+	if u, err := f.svc.SetAdmin(ctx, f.admin, f.target, true); err != nil || !u.IsAdmin {
+		t.Fatalf("grant = %+v, %v", u, err)
+	}
+	if u, err := f.svc.SetAdmin(ctx, f.admin, f.target, false); err != nil || u.IsAdmin {
+		t.Fatalf("revoke = %+v, %v", u, err)
+	}
+	if len(f.sessions.expired) != 2 || f.sessions.expired[1] != f.target {
+		t.Fatalf("access tokens expired for %v, want the target on both changes", f.sessions.expired)
+	}
+	if n, err := f.svc.ForceLogout(ctx, f.admin, f.target); err != nil || n != 2 {
+		t.Fatalf("ForceLogout = %d, %v", n, err)
+	}
+	if _, err := f.svc.ForceLogout(ctx, f.admin, uuid.New()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("ForceLogout unknown user err = %v", err)
+	}
+	want := []string{domain.ActionAdminGrant, domain.ActionAdminRevoke, domain.ActionAdminForceLogout}
+	got := f.actions()
+	if len(got) != len(want) {
+		t.Fatalf("audit = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("audit = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRegistrationPolicy(t *testing.T) {
+	f := newFixture()
+	ctx := context.Background()
+	if err := f.svc.SetRegistrationPolicy(ctx, f.admin, "everyone"); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("bad policy err = %v", err)
+	}
+	if err := f.svc.SetRegistrationPolicy(ctx, f.admin, "open"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.svc.SetRegistrationPolicy(ctx, f.admin, "open"); err != nil {
+		t.Fatal(err)
+	}
+	if f.svc.RegistrationPolicy(ctx) != "open" || len(f.rec.entries) != 1 {
+		t.Fatalf("policy = %q, audit = %v", f.svc.RegistrationPolicy(ctx), f.actions())
+	}
+}
+
+func TestListVaultsDiskUsage(t *testing.T) {
+	f := newFixture()
+	rows, err := f.svc.ListVaults(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].DiskBytes != 42 || rows[1].DiskBytes != -1 {
+		t.Fatalf("rows = %+v", rows)
+	}
+}
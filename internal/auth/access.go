@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Signed access tokens --------------------------------------------------
+
+// By default a login hands out an opaque session token that is looked
+// up (and its expiry pushed back) on every request. With an
+// AccessSigner the login instead returns a short-lived access token, a
+// JWT signed with a server key that the middleware checks without a
+// database round trip, and a refresh token that trades for a new pair.
+// Each refresh token works once: presenting a spent one means a copy
+// leaked, and the whole session is revoked. Opaque tokens issued
+// before keep working.
+//
+// An access token carries what the middleware needs about the user, so
+// a change (admin flag, 2FA enrolment) reaches it at the next refresh.
+// Revoking a session takes effect at once on this replica and within
+// the access token's lifetime on the others.
+
+const (
+	// RefreshTokenPrefix marks refresh tokens so they are recognisable
+	// in leaked logs.
+	RefreshTokenPrefix = "lumi_rt_"
+	accessTokenIssuer  = "lumi"
+	accessKeyMinBytes  = 32
+)
+
+var errSignedTokensUnavailable = errors.New("auth: signed access tokens are not configured")
+
+// AccessSigner signs and verifies access tokens (HS256 JWTs). The first
+// key signs; any key verifies, so a key can be rotated out without
+// logging everyone out.
+type AccessSigner struct {
+	ttl  time.Duration
+	keys []accessKey
+}
+
+type accessKey struct {
+	id     string
+	secret []byte
+}
+
+// NewAccessSigner returns a signer issuing tokens valid for ttl. Every
+// key must have at least 32 bytes.
+func NewAccessSigner(ttl time.Duration, keys ...[]byte) (*AccessSigner, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("auth: access token ttl must be positive: %w", domain.ErrValidation)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("auth: access token key required: %w", domain.ErrValidation)
+	}
+	a := &AccessSigner{ttl: ttl}
+	for _, k := range keys {
+		if len(k) < accessKeyMinBytes {
+			return nil, fmt.Errorf("auth: access token key shorter than %d bytes: %w", accessKeyMinBytes, domain.ErrValidation)
+		}
+		sum := sha256.Sum256(k)
+		a.keys = append(a.keys, accessKey{id: hex.EncodeToString(sum[:4]), secret: k})
+	}
+	return a, nil
+}
+
+// TTL is how long the tokens it signs are valid.
+func (a *AccessSigner) TTL() time.Duration { return a.ttl }
+
+// AccessClaims is what an access token asserts.
+type AccessClaims struct {
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+	Username  string
+	Admin     bool
+	TwoFactor bool
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type accessHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type accessPayload struct {
+	Iss string `json:"iss"`
+	Sub string `json:"sub"`
+	Sid string `json:"sid"`
+	Usr string `json:"preferred_username"`
+	Adm bool   `json:"adm,omitempty"`
+	TFA bool   `json:"tfa,omitempty"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+// Sign returns a token for c; ExpiresAt is IssuedAt plus the TTL.
+func (a *AccessSigner) Sign(c AccessClaims) (string, time.Time, error) {
+	exp := c.IssuedAt.Add(a.ttl)
+	k := a.keys[0]
+	header, err := json.Marshal(accessHeader{Alg: "HS256", Typ: "JWT", Kid: k.id})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload, err := json.Marshal(accessPayload{
+		Iss: accessTokenIssuer,
+		Sub: c.UserID.String(),
+		Sid: c.SessionID.String(),
+		Usr: c.Username,
+		Adm: c.Admin,
+		TFA: c.TwoFactor,
+		Iat: c.IssuedAt.Unix(),
+		Exp: exp.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed := b64(header) + "." + b64(payload)
+	return signed + "." + b64(k.sign(signed)), exp, nil
+}
+
+// Verify checks token's signature and expiry at now. ErrTokenExpired
+// for a well-signed token past its expiry, ErrTokenInvalid otherwise.
+func (a *AccessSigner) Verify(token string, now time.Time) (AccessClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	var h accessHeader
+	if err := unb64JSON(parts[0], &h); err != nil || h.Alg != "HS256" {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	var key *accessKey
+	for i := range a.keys {
+		if a.keys[i].id == h.Kid {
+			key = &a.keys[i]
+			break
+		}
+	}
+	if key == nil || !hmac.Equal(sig, key.sign(parts[0]+"."+parts[1])) {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	var p accessPayload
+	if err := unb64JSON(parts[1], &p); err != nil || p.Iss != accessTokenIssuer {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	uid, err := uuid.Parse(p.Sub)
+	if err != nil {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	sid, err := uuid.Parse(p.Sid)
+	if err != nil {
+		return AccessClaims{}, domain.ErrTokenInvalid
+	}
+	c := AccessClaims{
+		UserID:    uid,
+		SessionID: sid,
+		Username:  p.Usr,
+		Admin:     p.Adm,
+		TwoFactor: p.TFA,
+		IssuedAt:  time.Unix(p.Iat, 0).UTC(),
+		ExpiresAt: time.Unix(p.Exp, 0).UTC(),
+	}
+	if !c.ExpiresAt.After(now) {
+		return AccessClaims{}, domain.ErrTokenExpired
+	}
+	return c, nil
+}
+
+func (k accessKey) sign(s string) []byte {
+	m := hmac.New(sha256.New, k.secret)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func unb64JSON(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// IsAccessToken reports whether token has the shape of a signed access
+// token: three dot-separated parts, the first a JSON object.
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// SetAccessTokens switches logins to signed access tokens with refresh
+// tokens kept in store.
+func (s *Service) SetAccessTokens(signer *AccessSigner, store RefreshTokenStore) {
+	s.access = signer
+	s.refresh = store
+}
+
+// SignedTokens reports whether logins hand out signed access tokens.
+func (s *Service) SignedTokens() bool { return s.access != nil && s.refresh != nil }
+
+// TokenPair is what a login or a refresh returns in signed mode.
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// IssueTokenPair starts the refresh chain of a freshly issued session.
+func (s *Service) IssueTokenPair(ctx context.Context, user domain.User, sessionID uuid.UUID) (TokenPair, error) {
+	if !s.SignedTokens() {
+		return TokenPair{}, errSignedTokensUnavailable
+	}
+	return s.issueTokenPair(ctx, user, sessionID, time.Now().UTC())
+}
+
+func (s *Service) issueTokenPair(ctx context.Context, user domain.User, sessionID uuid.UUID, now time.Time) (TokenPair, error) {
+	raw, err := IssueToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh := RefreshTokenPrefix + raw
+	rt := domain.RefreshToken{
+		TokenHash: HashAPIToken(refresh),
+		SessionID: sessionID,
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.cfg.SessionTTL),
+	}
+	if err := s.refresh.IssueRefreshToken(ctx, rt); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return TokenPair{}, domain.ErrTokenInvalid
+		}
+		return TokenPair{}, fmt.Errorf("auth: issue refresh token: %w", err)
+	}
+	access, exp, err := s.access.Sign(AccessClaims{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Username:  user.Username,
+		Admin:     user.IsAdmin,
+		TwoFactor: user.TwoFactorEnabled,
+		IssuedAt:  now,
+	})
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("auth: sign access token: %w", err)
+	}
+	return TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  exp,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: rt.ExpiresAt,
+	}, nil
+}
+
+// RefreshInput trades a refresh token for a new pair.
+type RefreshInput struct {
+	Token     string
+	IP        string
+	UserAgent string
+}
+
+// Refresh spends a refresh token and returns a new pair for its session,
+// with the user it belongs to. A token spent before revokes the session.
+func (s *Service) Refresh(ctx context.Context, in RefreshInput) (TokenPair, domain.User, error) {
+	if !s.SignedTokens() {
+		return TokenPair{}, domain.User{}, errSignedTokensUnavailable
+	}
+	now := time.Now().UTC()
+	old, err := s.refresh.UseRefreshToken(ctx, HashAPIToken(strings.TrimSpace(in.Token)), now)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return TokenPair{}, domain.User{}, domain.ErrTokenInvalid
+		}
+		return TokenPair{}, domain.User{}, fmt.Errorf("auth: refresh lookup: %w", err)
+	}
+	if old.UsedAt != nil {
+		s.revokeOnReuse(ctx, old, in)
+		return TokenPair{}, domain.User{}, domain.ErrTokenInvalid
+	}
+	if !old.ExpiresAt.After(now) {
+		return TokenPair{}, domain.User{}, domain.ErrTokenExpired
+	}
+	user, err := s.users.GetByID(ctx, old.UserID)
+	if err != nil {
+		return TokenPair{}, domain.User{}, fmt.Errorf("auth: refresh user lookup: %w", err)
+	}
+	if user.SuspendedAt != nil {
+		return TokenPair{}, domain.User{}, domain.ErrAccountSuspended
+	}
+	pair, err := s.issueTokenPair(ctx, user, old.SessionID, now)
+	if err != nil {
+		return TokenPair{}, domain.User{}, err
+	}
+	return pair, user, nil
+}
+
+// revokeOnReuse ends the session whose spent refresh token came back:
+// either the client or whoever copied the token is replaying it, and
+// there is no telling which.
+func (s *Service) revokeOnReuse(ctx context.Context, t domain.RefreshToken, in RefreshInput) {
+	sess, err := s.sessions.DeleteSessionByID(ctx, t.UserID, t.SessionID)
+	if err != nil {
+		if !errors.Is(err, domain.ErrNotFound) {
+			s.cfg.Logger.Error().Err(err).Str("session_id", t.SessionID.String()).Msg("auth: failed to revoke session after refresh token reuse")
+		}
+		return
+	}
+	s.closeConnections(sess)
+	details := map[string]any{"session_id": t.SessionID, "used_at": t.UsedAt}
+	if s.security != nil {
+		s.recordSecurityEvent(ctx, t.UserID, domain.SecurityRefreshReuse, in.IP, in.UserAgent, details)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &t.UserID,
+		Action:    domain.ActionAuthSessionRevoke,
+		Payload:   mustJSON(map[string]any{"session_id": t.SessionID, "count": 1, "reason": domain.SecurityRefreshReuse}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+}
+
+// ValidateAccessToken checks a signed access token without touching the
+// database. The session and user are rebuilt from its claims; only the
+// fields the claims carry are set.
+func (s *Service) ValidateAccessToken(token string) (domain.Session, domain.User, error) {
+	if s.access == nil {
+		return domain.Session{}, domain.User{}, domain.ErrTokenInvalid
+	}
+	now := time.Now().UTC()
+	c, err := s.access.Verify(token, now)
+	if err != nil {
+		return domain.Session{}, domain.User{}, err
+	}
+	if s.revoked.has(c.SessionID, c.IssuedAt, now) {
+		return domain.Session{}, domain.User{}, domain.ErrTokenInvalid
+	}
+	sess := domain.Session{
+		ID:         c.SessionID,
+		UserID:     c.UserID,
+		ExpiresAt:  c.ExpiresAt,
+		LastUsedAt: now,
+	}
+	user := domain.User{
+		ID:               c.UserID,
+		Username:         c.Username,
+		IsAdmin:          c.Admin,
+		TwoFactorEnabled: c.TwoFactor,
+	}
+	return sess, user, nil
+}
+
+// revokedSessions remembers, per session, a moment before which this
+// replica refuses its access tokens, until those tokens have expired. A
+// revoked session can no longer refresh; a session whose account changed
+// gets a fresh token, carrying the new claims, at its next refresh.
+type revokedSessions struct {
+	mu      sync.Mutex
+	entries map[uuid.UUID]revokedEntry
+}
+
+type revokedEntry struct {
+	before time.Time
+	until  time.Time
+}
+
+func (r *revokedSessions) add(ttl time.Duration, ids ...uuid.UUID) {
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.entries == nil {
+		r.entries = map[uuid.UUID]revokedEntry{}
+	}
+	for id, e := range r.entries {
+		if !e.until.After(now) {
+			delete(r.entries, id)
+		}
+	}
+	// Tokens carry their issue time in whole seconds, so one issued in
+	// the second of the revocation is refused too.
+	before := now.Truncate(time.Second)
+	for _, id := range ids {
+		r.entries[id] = revokedEntry{before: before, until: now.Add(ttl)}
+	}
+}
+
+// has reports whether a token for id issued at issuedAt is refused.
+func (r *revokedSessions) has(id uuid.UUID, issuedAt, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[id]
+	return ok && e.until.After(now) && !issuedAt.After(e.before)
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+func testAccessKey(b byte) []byte { return bytes.Repeat([]byte{b}, 32) }
+
+func TestAccessSignerRoundTrip(t *testing.T) {
+	a, err := NewAccessSigner(10*time.Minute, testAccessKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	in := AccessClaims{UserID: uuid.New(), SessionID: uuid.New(), Username: "ana", Admin: true, IssuedAt: now}
+	token, exp, err := a.Sign(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAccessToken(token) || IsAPIToken(token) {
+		t.Fatalf("token %q not recognised as an access token", token)
+	}
+	if !exp.Equal(now.Add(10 * time.Minute)) {
+		t.Fatalf("expiry = %v, want ten minutes after issue", exp)
+	}
+	got, err := a.Verify(token, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.ExpiresAt = exp
+	if got != in {
+		t.Fatalf("claims = %+v, want %+v", got, in)
+	}
+	if _, err := a.Verify(token, exp); !errors.Is(err, domain.ErrTokenExpired) {
+		t.Fatalf("at expiry: %v, want ErrTokenExpired", err)
+	}
+}
+
+func TestAccessSignerRejectsForgeries(t *testing.T) {
+	a, _ := NewAccessSigner(time.Minute, testAccessKey(1))
+	other, _ := NewAccessSigner(time.Minute, testAccessKey(2))
+	now := time.Now()
+	token, _, _ := a.Sign(AccessClaims{UserID: uuid.New(), SessionID: uuid.New(), Username: "ana", IssuedAt: now})
+	forged, _, _ := other.Sign(AccessClaims{UserID: uuid.New(), SessionID: uuid.New(), Username: "ana", IssuedAt: now})
+
+	parts := strings.Split(token, ".")
+	admin, _, _ := a.Sign(AccessClaims{UserID: uuid.New(), SessionID: uuid.New(), Username: "ana", Admin: true, IssuedAt: now})
+	spliced := parts[0] + "." + strings.Split(admin, ".")[1] + "." + parts[2]
+	none := b64([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	for name, bad := range map[string]string{"other key": forged, "spliced claims": spliced, "alg none": none, "garbage": "eyJ.x.y"} {
+		if _, err := a.Verify(bad, now); !errors.Is(err, domain.ErrTokenInvalid) {
+			t.Errorf("%s: %v, want ErrTokenInvalid", name, err)
+		}
+	}
+}
+
+func TestAccessSignerKeyRotation(t *testing.T) {
+	old, _ := NewAccessSigner(time.Minute, testAccessKey(1))
+	rotated, _ := NewAccessSigner(time.Minute, testAccessKey(2), testAccessKey(1))
+	now := time.Now()
+	token, _, _ := old.Sign(AccessClaims{UserID: uuid.New(), SessionID: uuid.New(), IssuedAt: now})
+	if _, err := rotated.Verify(token, now); err != nil {
+		t.Fatalf("token signed with the previous key: %v", err)
+	}
+	if _, err := NewAccessSigner(time.Minute, []byte("short")); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("short key: %v, want ErrValidation", err)
+	}
+}
+
+func TestRevokedSessions(t *testing.T) {
+	var r revokedSessions
+	id := uuid.New()
+	issued := time.Now().Add(-time.Second)
+	r.add(time.Minute, id)
+	now := time.Now()
+	if !r.has(id, issued, now) {
+		t.Fatal("revoked session not remembered")
+	}
+	if r.has(uuid.New(), issued, now) {
+		t.Fatal("unrelated session reported revoked")
+	}
+	if r.has(id, issued, now.Add(2*time.Minute)) {
+		t.Fatal("revocation outlived the access tokens")
+	}
+	if r.has(id, now.Add(2*time.Second), now.Add(2*time.Second)) {
+		t.Fatal("token issued after the revocation refused")
+	}
+}
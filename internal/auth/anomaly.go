@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/geoip"
+	"github.com/ViniZap4/lumi-server/internal/mail"
+)
+
+// ---- Login anomalies -------------------------------------------------------
+
+// Every login is compared with the account's earlier ones: the device
+// (a fingerprint of the user agent, blind to version numbers), the
+// network (the /24 or /48 around the address) and, with a GeoIP
+// database, the distance from the last located login. A new device, a
+// trip faster than an airliner, or a login straight after a burst of
+// wrong passwords is flagged: it is recorded as a security event and,
+// when the user has an email address, mailed to them. A new network on
+// its own is only recorded, since home and mobile addresses change all
+// the time. An account's first remembered login sets its baseline and
+// flags nothing.
+//
+// With Config.LoginStepUp a flagged password login by a user without a
+// second factor is held until they enter a code sent to their email;
+// users with one are asked for it on every login anyway.
+
+const (
+	failureWindow   = 15 * time.Minute
+	failureAlertAt  = 5 // failed logins within failureWindow worth an alert
+	travelMinKm     = 500
+	travelMaxKmH    = 1000
+	securityListMax = 200
+	methodEmailCode = "email_code"
+)
+
+var errLoginSecurityUnavailable = errors.New("auth: login security is not configured")
+
+// SetLoginSecurity enables login anomaly checks and the security event
+// log. Without it, a login from a user agent none of the user's
+// sessions has is still mailed about.
+func (s *Service) SetLoginSecurity(store LoginSecurityStore) { s.security = store }
+
+// SetLocator adds GeoIP locations, and with them impossible travel
+// checks.
+func (s *Service) SetLocator(l Locator) { s.locator = l }
+
+// loginFinding is one thing that stood out about a login.
+type loginFinding struct {
+	kind    string
+	details map[string]any
+}
+
+// loginAssessment is a login as compared with the baseline.
+type loginAssessment struct {
+	sighting domain.LoginSighting
+	findings []loginFinding
+}
+
+// alerts lists the kinds worth telling the user about.
+func (a loginAssessment) alerts() []string {
+	var out []string
+	for _, f := range a.findings {
+		if f.kind != domain.SecurityNewNetwork {
+			out = append(out, f.kind)
+		}
+	}
+	return out
+}
+
+// assessLogin compares a login from ip and ua with userID's history.
+func (s *Service) assessLogin(ctx context.Context, userID uuid.UUID, ip, ua string) (loginAssessment, error) {
+	now := time.Now().UTC()
+	l := domain.LoginSighting{
+		UserID:      userID,
+		Fingerprint: deviceFingerprint(ua),
+		UserAgent:   strings.TrimSpace(ua),
+		Network:     networkOf(ip),
+		IP:          strings.TrimSpace(ip),
+		At:          now,
+	}
+	if s.locator != nil {
+		if loc, ok := s.locator.Locate(l.IP); ok {
+			l.Located, l.Latitude, l.Longitude = true, loc.Latitude, loc.Longitude
+		}
+	}
+	base, err := s.security.LoginBaseline(ctx, userID, l.Fingerprint, l.Network)
+	if err != nil {
+		return loginAssessment{}, fmt.Errorf("auth: login baseline: %w", err)
+	}
+	failures, err := s.security.CountLoginFailures(ctx, userID, now.Add(-failureWindow))
+	if err != nil {
+		return loginAssessment{}, fmt.Errorf("auth: login failures: %w", err)
+	}
+	return loginAssessment{sighting: l, findings: judgeLogin(base, l, failures)}, nil
+}
+
+// judgeLogin decides what stands out about l.
+func judgeLogin(base domain.LoginBaseline, l domain.LoginSighting, failures int) []loginFinding {
+	if base.Devices == 0 {
+		return nil
+	}
+	var out []loginFinding
+	if !base.DeviceKnown {
+		out = append(out, loginFinding{kind: domain.SecurityNewDevice})
+	}
+	if !base.NetworkKnown && l.Network != "" {
+		out = append(out, loginFinding{kind: domain.SecurityNewNetwork, details: map[string]any{"network": l.Network}})
+	}
+	if prev := base.LastLocated; prev != nil && l.Located && prev.Network != l.Network {
+		km := geoip.DistanceKm(
+			geoip.Location{Latitude: prev.Latitude, Longitude: prev.Longitude},
+			geoip.Location{Latitude: l.Latitude, Longitude: l.Longitude})
+		hours := l.At.Sub(prev.At).Hours()
+		if km >= travelMinKm && (hours <= 0 || km/hours > travelMaxKmH) {
+			out = append(out, loginFinding{kind: domain.SecurityImpossibleTravel, details: map[string]any{
+				"km":          int(km),
+				"hours":       hours,
+				"previous_ip": prev.IP,
+				"previous_at": prev.At,
+			}})
+		}
+	}
+	if failures >= failureAlertAt {
+		out = append(out, loginFinding{kind: domain.SecurityAfterFailures, details: map[string]any{"failures": failures}})
+	}
+	return out
+}
+
+// reviewLogin records what stood out about a new session, mails the
+// user about it and remembers the login for next time. Errors are
+// logged: the login itself has already been verified.
+func (s *Service) reviewLogin(ctx context.Context, user domain.User, sess domain.Session) {
+	if s.security == nil {
+		s.alertNewDevice(ctx, user, sess)
+		return
+	}
+	a, err := s.assessLogin(ctx, user.ID, sess.IP, sess.UserAgent)
+	if err != nil {
+		s.cfg.Logger.Warn().Err(err).Msg("auth: login review")
+		return
+	}
+	for _, f := range a.findings {
+		s.recordSecurityEvent(ctx, user.ID, f.kind, sess.IP, sess.UserAgent, f.details)
+	}
+	if err := s.security.RememberLogin(ctx, a.sighting); err != nil {
+		s.cfg.Logger.Warn().Err(err).Msg("auth: remember login")
+	}
+	if kinds := a.alerts(); len(kinds) > 0 && user.Email != "" {
+		s.sendMail(ctx, user.Email, mail.TemplateNewLogin, mail.NewLoginData{
+			Username:  user.Username,
+			IP:        sess.IP,
+			UserAgent: sess.UserAgent,
+			At:        sess.CreatedAt,
+			Reasons:   kinds,
+		})
+	}
+}
+
+// requireStepUp holds a flagged login until the user enters a code
+// mailed to them, answering with a *SecondFactorRequired whose only
+// method is methodEmailCode. oidcGroups ride on the challenge as in
+// issueChallenge. It is a no-op unless Config.LoginStepUp is on and the
+// user has an address to send to.
+func (s *Service) requireStepUp(ctx context.Context, user domain.User, in LoginInput, oidcGroups []string) error {
+	if !s.cfg.LoginStepUp || s.security == nil || s.twoFA == nil || s.mailer == nil || user.Email == "" {
+		return nil
+	}
+	a, err := s.assessLogin(ctx, user.ID, in.IP, in.UserAgent)
+	if err != nil {
+		return err
+	}
+	kinds := a.alerts()
+	if len(kinds) == 0 {
+		return nil
+	}
+	token, err := IssueToken()
+	if err != nil {
+		return fmt.Errorf("auth: issue challenge: %w", err)
+	}
+	code, err := newLoginCode()
+	if err != nil {
+		return fmt.Errorf("auth: login code: %w", err)
+	}
+	now := time.Now().UTC()
+	c := domain.LoginChallenge{
+		TokenHash:  HashAPIToken(token),
+		UserID:     user.ID,
+		CodeHash:   loginCodeHash(token, code),
+		OIDCGroups: oidcGroups,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(challengeTTL),
+	}
+	if err := s.twoFA.CreateLoginChallenge(ctx, c); err != nil {
+		return fmt.Errorf("auth: persist challenge: %w", err)
+	}
+	s.sendMail(ctx, user.Email, mail.TemplateLoginCode, mail.LoginCodeData{
+		Username:  user.Username,
+		Code:      code,
+		IP:        in.IP,
+		UserAgent: in.UserAgent,
+		ExpiresAt: c.ExpiresAt,
+	})
+	s.recordSecurityEvent(ctx, user.ID, domain.SecurityStepUp, in.IP, in.UserAgent, map[string]any{"reasons": kinds})
+	return &SecondFactorRequired{Challenge: token, ExpiresAt: c.ExpiresAt, Methods: []string{methodEmailCode}}
+}
+
+// checkLoginCode verifies the emailed code of a step-up challenge.
+// token is the challenge token the code was bound to.
+func (s *Service) checkLoginCode(ctx context.Context, ch domain.LoginChallenge, token, code, ip, ua string) (string, error) {
+	if !s.rlCode.Allow(ch.UserID.String()) {
+		s.recordCodeFailure(ctx, ch.UserID, "login", "rate_limited", ip, ua)
+		return "", fmt.Errorf("auth: code attempts throttled: %w", domain.ErrRateLimited)
+	}
+	if !ConstantTimeEqual(loginCodeHash(strings.TrimSpace(token), strings.TrimSpace(code)), ch.CodeHash) {
+		s.recordCodeFailure(ctx, ch.UserID, "login", methodEmailCode, ip, ua)
+		return "", domain.ErrInvalidCredentials
+	}
+	s.rlCode.Reset(ch.UserID.String())
+	return methodEmailCode, nil
+}
+
+// watchFailures raises a security event, and mails the user, when a
+// wrong password brings their recent failures to failureAlertAt.
+func (s *Service) watchFailures(ctx context.Context, userID uuid.UUID, ip string) {
+	if s.security == nil {
+		return
+	}
+	n, err := s.security.CountLoginFailures(ctx, userID, time.Now().Add(-failureWindow))
+	if err != nil {
+		s.cfg.Logger.Warn().Err(err).Msg("auth: count login failures")
+		return
+	}
+	if n != failureAlertAt {
+		return
+	}
+	s.recordSecurityEvent(ctx, userID, domain.SecurityRepeatedFailures, ip, "", map[string]any{"failures": n})
+	if s.mailer == nil {
+		return
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil || user.Email == "" {
+		return
+	}
+	s.sendMail(ctx, user.Email, mail.TemplateLoginFailures, mail.LoginFailuresData{
+		Username: user.Username,
+		Count:    n,
+		Minutes:  int(failureWindow / time.Minute),
+		IP:       ip,
+		At:       time.Now().UTC(),
+	})
+}
+
+func (s *Service) recordSecurityEvent(ctx context.Context, userID uuid.UUID, kind, ip, ua string, details map[string]any) {
+	e := domain.SecurityEvent{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Kind:      kind,
+		IP:        strings.TrimSpace(ip),
+		UserAgent: strings.TrimSpace(ua),
+		CreatedAt: time.Now().UTC(),
+	}
+	if details != nil {
+		e.Details = mustJSON(details)
+	}
+	if err := s.security.RecordSecurityEvent(ctx, e); err != nil {
+		s.cfg.Logger.Error().Err(err).Str("kind", kind).Msg("auth: security event record failed")
+	}
+}
+
+// ListSecurityEvents returns the user's newest security events, at most
+// limit of them.
+func (s *Service) ListSecurityEvents(ctx context.Context, userID uuid.UUID, limit int) ([]domain.SecurityEvent, error) {
+	if s.security == nil {
+		return nil, errLoginSecurityUnavailable
+	}
+	if limit <= 0 || limit > securityListMax {
+		limit = securityListMax
+	}
+	events, err := s.security.ListSecurityEvents(ctx, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("auth: list security events: %w", err)
+	}
+	return events, nil
+}
+
+var digitRuns = regexp.MustCompile(`[0-9]+`)
+
+// deviceFingerprint identifies a browser or client by its user agent
+// with the version numbers blanked, so an update is not a new device.
+func deviceFingerprint(ua string) string {
+	norm := digitRuns.ReplaceAllString(strings.ToLower(strings.TrimSpace(ua)), "#")
+	sum := sha256.Sum256([]byte(norm))
+	return hex.EncodeToString(sum[:])
+}
+
+// networkOf is the /24 (IPv4) or /48 (IPv6) holding ip, or "" when ip
+// is not an address.
+func networkOf(ip string) string {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	p, err := addr.WithZone("").Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return p.String()
+}
+
+// newLoginCode returns six random digits.
+func newLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// loginCodeHash binds a step-up code to its challenge token, so the
+// stored hash cannot be reversed by trying the million codes alone.
+func loginCodeHash(token, code string) string {
+	return HashAPIToken(token + ":" + code)
+}
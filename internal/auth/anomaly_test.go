@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+func TestDeviceFingerprintIgnoresVersions(t *testing.T) {
+	a := deviceFingerprint("Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+	b := deviceFingerprint("Mozilla/5.0 (X11; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0")
+	c := deviceFingerprint("Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 Safari/605.1.15")
+	if a != b {
+		t.Error("a browser update changed the fingerprint")
+	}
+	if a == c {
+		t.Error("different browsers share a fingerprint")
+	}
+}
+
+func TestNetworkOf(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.77":        "203.0.113.0/24",
+		"::ffff:203.0.113.77": "203.0.113.0/24",
+		"2001:db8:1:2::5":     "2001:db8:1::/48",
+		"fe80::1%eth0":        "fe80::/48",
+		"":                    "",
+		"localhost":           "",
+	}
+	for ip, want := range cases {
+		if got := networkOf(ip); got != want {
+			t.Errorf("networkOf(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestJudgeLogin(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	saoPaulo := domain.LoginSighting{Network: "198.51.100.0/24", Located: true, Latitude: -23.55, Longitude: -46.63, At: now.Add(-2 * time.Hour)}
+	lisbon := domain.LoginSighting{Network: "203.0.113.0/24", Located: true, Latitude: 38.72, Longitude: -9.14, At: now}
+	known := domain.LoginBaseline{Devices: 2, DeviceKnown: true, NetworkKnown: true}
+
+	kinds := func(fs []loginFinding) []string {
+		var out []string
+		for _, f := range fs {
+			out = append(out, f.kind)
+		}
+		return out
+	}
+	cases := []struct {
+		name     string
+		base     domain.LoginBaseline
+		l        domain.LoginSighting
+		failures int
+		want     []string
+	}{
+		{"first login sets the baseline", domain.LoginBaseline{}, lisbon, 9, nil},
+		{"familiar", known, lisbon, 0, nil},
+		{"new device and network", domain.LoginBaseline{Devices: 1}, lisbon, 0,
+			[]string{domain.SecurityNewDevice, domain.SecurityNewNetwork}},
+		{"after failures", known, lisbon, failureAlertAt, []string{domain.SecurityAfterFailures}},
+		{"ocean crossed in two hours", withLast(known, saoPaulo), lisbon, 0, []string{domain.SecurityImpossibleTravel}},
+		{"ocean crossed in a day", withLast(known, at(saoPaulo, now.Add(-24*time.Hour))), lisbon, 0, nil},
+		{"same network", withLast(known, saoPaulo), at(saoPaulo, now), 0, nil},
+		{"unlocated", withLast(known, saoPaulo), domain.LoginSighting{Network: lisbon.Network, At: now}, 0, nil},
+	}
+	for _, c := range cases {
+		if got := kinds(judgeLogin(c.base, c.l, c.failures)); !slices.Equal(got, c.want) {
+			t.Errorf("%s: findings = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func withLast(b domain.LoginBaseline, l domain.LoginSighting) domain.LoginBaseline {
+	b.LastLocated = &l
+	return b
+}
+
+func at(l domain.LoginSighting, t time.Time) domain.LoginSighting {
+	l.At = t
+	return l
+}
+
+func TestAlertsSkipNewNetwork(t *testing.T) {
+	a := loginAssessment{findings: []loginFinding{{kind: domain.SecurityNewNetwork}}}
+	if got := a.alerts(); len(got) != 0 {
+		t.Fatalf("alerts = %v, want none for a new network alone", got)
+	}
+}
+
+func TestLoginCodeIsBoundToChallenge(t *testing.T) {
+	code, err := newLoginCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 6 {
+		t.Fatalf("code = %q, want six digits", code)
+	}
+	if loginCodeHash("challenge-a", code) == loginCodeHash("challenge-b", code) {
+		t.Fatal("code hash does not depend on the challenge")
+	}
+}
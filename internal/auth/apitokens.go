@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Personal access tokens ------------------------------------------------
+
+const (
+	apiTokenNameMax = 64
+	// apiTokenMaxTTL caps how far ahead a token may expire; long-lived
+	// CI secrets get rotated at least yearly.
+	apiTokenMaxTTL = 366 * 24 * time.Hour
+	// apiTokenTouchEvery throttles last_used_at writes so a busy script
+	// does not cost one UPDATE per request.
+	apiTokenTouchEvery = time.Minute
+)
+
+// SetAPITokenStore enables personal access tokens. Without one, token
+// requests are rejected as invalid and the token routes fail.
+func (s *Service) SetAPITokenStore(store APITokenStore) { s.tokens = store }
+
+var errAPITokensUnavailable = errors.New("auth: api tokens are not configured")
+
+// CreateAPITokenInput describes a new personal access token.
+type CreateAPITokenInput struct {
+	UserID       uuid.UUID
+	Name         string
+	VaultID      *uuid.UUID
+	Capabilities domain.CapabilitySet
+	ExpiresAt    time.Time
+	IP           string
+	UserAgent    string
+}
+
+// CreateAPIToken stores a new token and returns it with its plaintext
+// secret, which is never retrievable again.
+func (s *Service) CreateAPIToken(ctx context.Context, in CreateAPITokenInput) (domain.APIToken, string, error) {
+	if s.tokens == nil {
+		return domain.APIToken{}, "", errAPITokensUnavailable
+	}
+	name := strings.TrimSpace(in.Name)
+	if name == "" || len(name) > apiTokenNameMax {
+		return domain.APIToken{}, "", fmt.Errorf("auth: token name must be 1-%d characters: %w", apiTokenNameMax, domain.ErrValidation)
+	}
+	if len(in.Capabilities) == 0 {
+		return domain.APIToken{}, "", fmt.Errorf("auth: token needs at least one capability: %w", domain.ErrValidation)
+	}
+	caps := make(domain.CapabilitySet, 0, len(in.Capabilities))
+	seen := make(map[domain.Capability]bool, len(in.Capabilities))
+	for _, c := range in.Capabilities {
+		if !c.Known() {
+			return domain.APIToken{}, "", fmt.Errorf("auth: unknown capability %q: %w", c, domain.ErrValidation)
+		}
+		if !seen[c] {
+			seen[c] = true
+			caps = append(caps, c)
+		}
+	}
+	now := time.Now().UTC()
+	if !in.ExpiresAt.After(now) {
+		return domain.APIToken{}, "", fmt.Errorf("auth: token expiry must be in the future: %w", domain.ErrValidation)
+	}
+	if in.ExpiresAt.Sub(now) > apiTokenMaxTTL {
+		return domain.APIToken{}, "", fmt.Errorf("auth: token expiry is more than a year away: %w", domain.ErrValidation)
+	}
+
+	secret, hash, err := IssueAPIToken()
+	if err != nil {
+		return domain.APIToken{}, "", fmt.Errorf("auth: create token: %w", err)
+	}
+	t := domain.APIToken{
+		ID:           uuid.New(),
+		UserID:       in.UserID,
+		Name:         name,
+		TokenHash:    hash,
+		VaultID:      in.VaultID,
+		Capabilities: caps,
+		CreatedAt:    now,
+		ExpiresAt:    in.ExpiresAt.UTC(),
+	}
+	if err := s.tokens.CreateAPIToken(ctx, t); err != nil {
+		return domain.APIToken{}, "", fmt.Errorf("auth: create token: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &in.UserID,
+		VaultID:   in.VaultID,
+		Action:    domain.ActionAuthTokenCreate,
+		Payload:   mustJSON(map[string]any{"token_id": t.ID, "name": t.Name, "capabilities": t.Capabilities, "expires_at": t.ExpiresAt}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return t, secret, nil
+}
+
+// ListAPITokens returns the user's tokens, expired ones included.
+func (s *Service) ListAPITokens(ctx context.Context, userID uuid.UUID) ([]domain.APIToken, error) {
+	if s.tokens == nil {
+		return nil, errAPITokensUnavailable
+	}
+	out, err := s.tokens.ListAPITokens(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("auth: list tokens: %w", err)
+	}
+	return out, nil
+}
+
+// RevokeAPIToken deletes one of the user's tokens. Requests already past
+// the middleware finish; the next one is rejected.
+func (s *Service) RevokeAPIToken(ctx context.Context, userID, id uuid.UUID, ip, ua string) error {
+	if s.tokens == nil {
+		return errAPITokensUnavailable
+	}
+	t, err := s.tokens.DeleteAPIToken(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("auth: revoke token: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		VaultID:   t.VaultID,
+		Action:    domain.ActionAuthTokenRevoke,
+		Payload:   mustJSON(map[string]any{"token_id": t.ID, "name": t.Name}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return nil
+}
+
+// ValidateAPIToken is Validate for personal access tokens.
+func (s *Service) ValidateAPIToken(ctx context.Context, token string) (domain.APIToken, domain.User, error) {
+	if s.tokens == nil || !IsAPIToken(token) {
+		return domain.APIToken{}, domain.User{}, domain.ErrTokenInvalid
+	}
+	t, err := s.tokens.GetAPITokenByHash(ctx, HashAPIToken(token))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.APIToken{}, domain.User{}, domain.ErrTokenInvalid
+		}
+		return domain.APIToken{}, domain.User{}, fmt.Errorf("auth: validate token lookup: %w", err)
+	}
+	now := time.Now().UTC()
+	if !t.ExpiresAt.After(now) {
+		return domain.APIToken{}, domain.User{}, domain.ErrTokenExpired
+	}
+	user, err := s.users.GetByID(ctx, t.UserID)
+	if err != nil {
+		return domain.APIToken{}, domain.User{}, domain.ErrTokenInvalid
+	}
+	if user.SuspendedAt != nil {
+		return domain.APIToken{}, domain.User{}, domain.ErrAccountSuspended
+	}
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= apiTokenTouchEvery {
+		if err := s.tokens.TouchAPIToken(ctx, t.ID, now); err == nil {
+			t.LastUsedAt = &now
+		} else {
+			s.cfg.Logger.Warn().Err(err).Msg("auth: api token touch failed")
+		}
+	}
+	return t, user, nil
+}
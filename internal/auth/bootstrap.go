@@ -18,14 +18,16 @@ type BootstrapConfig struct {
 }
 
 // UserCounter is the minimal interface needed by Bootstrap to detect
-// first-run state.
+// first-run state and grant the admin flag.
 type UserCounter interface {
 	CountUsers(ctx context.Context) (int, error)
+	PromoteAdmin(ctx context.Context, username string) (bool, error)
 }
 
 // Bootstrap creates an initial admin if (and only if) the users table is
-// empty AND credentials are supplied. Idempotent: re-running with users
-// present is a no-op.
+// empty AND credentials are supplied, and makes sure the configured
+// username holds server-admin authority. Idempotent: re-running with
+// users present only re-asserts the flag.
 func Bootstrap(ctx context.Context, svc *Service, counter UserCounter, cfg BootstrapConfig) error {
 	if svc == nil {
 		return errors.New("auth.Bootstrap: nil service")
@@ -46,7 +48,7 @@ func Bootstrap(ctx context.Context, svc *Service, counter UserCounter, cfg Boots
 	}
 	if count > 0 {
 		svc.cfg.Logger.Debug().Int("user_count", count).Msg("auth.Bootstrap: users present; skipping")
-		return nil
+		return promoteAdmin(ctx, svc, counter, username)
 	}
 
 	displayName := strings.TrimSpace(cfg.DisplayName)
@@ -67,10 +69,30 @@ func Bootstrap(ctx context.Context, svc *Service, counter UserCounter, cfg Boots
 	if _, err := svc.registerSkippingConsent(ctx, in); err != nil {
 		if errors.Is(err, domain.ErrConflict) {
 			svc.cfg.Logger.Warn().Msg("auth.Bootstrap: admin already created by concurrent caller")
-			return nil
+			return promoteAdmin(ctx, svc, counter, username)
 		}
 		return fmt.Errorf("auth.Bootstrap: create admin: %w", err)
 	}
 	svc.cfg.Logger.Info().Str("username", username).Msg("auth.Bootstrap: admin created")
+	return promoteAdmin(ctx, svc, counter, username)
+}
+
+// promoteAdmin grants the server-admin flag to the bootstrap username.
+// The account may have been renamed or erased since; that only warns.
+func promoteAdmin(ctx context.Context, svc *Service, counter UserCounter, username string) error {
+	name, err := canonicaliseUsername(username)
+	if err != nil {
+		name = username
+	}
+	changed, err := counter.PromoteAdmin(ctx, name)
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		svc.cfg.Logger.Warn().Str("username", name).Msg("auth.Bootstrap: admin user not found; no server admin promoted")
+		return nil
+	case err != nil:
+		return fmt.Errorf("auth.Bootstrap: promote admin: %w", err)
+	case changed:
+		svc.cfg.Logger.Info().Str("username", name).Msg("auth.Bootstrap: granted server admin")
+	}
 	return nil
 }
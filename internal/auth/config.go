@@ -12,28 +12,52 @@ import (
 
 type RegistrationPolicy string
 
+// TwoFactorPolicy decides whether users may skip a second factor.
+type TwoFactorPolicy string
+
 const (
 	PolicyOpen        RegistrationPolicy = "open"
 	PolicyInviteOnly  RegistrationPolicy = "invite-only"
-	defaultBcryptCost                    = 12
 	defaultSessionTTL                    = 30 * 24 * time.Hour
 )
 
+const (
+	// TwoFactorOptional lets each user decide; TwoFactorRequired confines
+	// users without a confirmed enrolment to enrolling.
+	TwoFactorOptional TwoFactorPolicy = "optional"
+	TwoFactorRequired TwoFactorPolicy = "required"
+	defaultTOTPIssuer                 = "lumi"
+)
+
 // Config bundles the tunables for the auth subsystem.
 type Config struct {
-	BcryptCost         int
+	Argon2             Argon2Params // zero fields take DefaultArgon2's
+	PasswordMinLength  int          // at least MinPasswordLength
+	BreachedPasswords  *BreachedList
 	SessionTTL         time.Duration
 	RegistrationPolicy RegistrationPolicy
 	RequireConsent     bool
 	TosVersion         string
 	PrivacyVersion     string
+	TwoFactorPolicy    TwoFactorPolicy
+	TOTPIssuer         string // account label in authenticator apps
+	LoginStepUp        bool   // hold flagged logins for an emailed code
 	Logger             zerolog.Logger
 }
 
 func (cfg Config) withDefaults() Config {
 	out := cfg
-	if out.BcryptCost < defaultBcryptCost {
-		out.BcryptCost = defaultBcryptCost
+	if out.Argon2.Memory == 0 {
+		out.Argon2.Memory = DefaultArgon2.Memory
+	}
+	if out.Argon2.Time == 0 {
+		out.Argon2.Time = DefaultArgon2.Time
+	}
+	if out.Argon2.Threads == 0 {
+		out.Argon2.Threads = DefaultArgon2.Threads
+	}
+	if out.PasswordMinLength < MinPasswordLength {
+		out.PasswordMinLength = MinPasswordLength
 	}
 	if out.SessionTTL <= 0 {
 		out.SessionTTL = defaultSessionTTL
@@ -43,5 +67,11 @@ func (cfg Config) withDefaults() Config {
 	default:
 		out.RegistrationPolicy = PolicyInviteOnly
 	}
+	if out.TwoFactorPolicy != TwoFactorRequired {
+		out.TwoFactorPolicy = TwoFactorOptional
+	}
+	if out.TOTPIssuer == "" {
+		out.TOTPIssuer = defaultTOTPIssuer
+	}
 	return out
 }
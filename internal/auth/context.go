@@ -7,8 +7,10 @@ import (
 )
 
 const (
-	ctxKeyUser    = "auth.user"
-	ctxKeySession = "auth.session"
+	ctxKeyUser     = "auth.user"
+	ctxKeySession  = "auth.session"
+	ctxKeyAPIToken = "auth.api_token"
+	ctxKeyAccess   = "auth.access_token"
 )
 
 // UserFromCtx extracts the authenticated user attached by Required /
@@ -37,3 +39,24 @@ func SessionFromCtx(c *fiber.Ctx) *domain.Session {
 	}
 	return s
 }
+
+// APITokenFromCtx extracts the personal access token a request was
+// authenticated with; nil for session requests.
+func APITokenFromCtx(c *fiber.Ctx) *domain.APIToken {
+	v := c.Locals(ctxKeyAPIToken)
+	if v == nil {
+		return nil
+	}
+	t, ok := v.(*domain.APIToken)
+	if !ok {
+		return nil
+	}
+	return t
+}
+
+// viaAccessToken reports whether the request was authenticated with a
+// signed access token, whose user carries only the claimed fields.
+func viaAccessToken(c *fiber.Ctx) bool {
+	v, _ := c.Locals(ctxKeyAccess).(bool)
+	return v
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Device authorization grant --------------------------------------------
+
+// Terminal clients sign in without handling the password (RFC 8628).
+// The client asks for a grant and shows the user code; the user enters
+// it in the logged-in web UI and approves. Meanwhile the client polls
+// with the device code and, once approved, receives a session of its
+// own, labelled with the client's name. It appears among the user's
+// sessions and is revoked like any other.
+
+const (
+	deviceGrantTTL      = 10 * time.Minute
+	devicePollInterval  = 5 * time.Second
+	deviceClientMaxLen  = 64
+	deviceDefaultClient = "device"
+	// userCodeAlphabet has no vowels (no words) and no look-alikes.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLen      = 8
+)
+
+var (
+	errDeviceAuthUnavailable = errors.New("auth: device authorization is not configured")
+	errDevicePending         = errors.New("auth: device authorization pending")
+	errDeviceSlowDown        = errors.New("auth: device polling too fast")
+	errDeviceDenied          = errors.New("auth: device authorization denied")
+)
+
+// SetDeviceAuthStore enables the device authorization grant.
+// publicBaseURL is the web UI's origin; the verification page is
+// <publicBaseURL>/device.
+func (s *Service) SetDeviceAuthStore(store DeviceAuthStore, publicBaseURL string) {
+	s.devices = store
+	if publicBaseURL != "" {
+		s.baseURL = strings.TrimRight(publicBaseURL, "/")
+	}
+}
+
+// DeviceGrant is what a client needs to run the flow. DeviceCode is
+// secret; UserCode is shown to the user.
+type DeviceGrant struct {
+	DeviceCode      string
+	UserCode        string
+	VerificationURI string
+	ExpiresAt       time.Time
+	Interval        time.Duration
+}
+
+// StartDeviceInput describes the client asking for a grant.
+type StartDeviceInput struct {
+	ClientName string
+	IP         string
+	UserAgent  string
+}
+
+// StartDeviceAuthorization opens a grant for a client.
+func (s *Service) StartDeviceAuthorization(ctx context.Context, in StartDeviceInput) (DeviceGrant, error) {
+	if s.devices == nil {
+		return DeviceGrant{}, errDeviceAuthUnavailable
+	}
+	if !s.rlDevice.Allow(in.IP) {
+		return DeviceGrant{}, fmt.Errorf("auth: device grant throttled: %w", domain.ErrRateLimited)
+	}
+	client := strings.TrimSpace(in.ClientName)
+	if client == "" {
+		client = deviceDefaultClient
+	}
+	if utf8.RuneCountInString(client) > deviceClientMaxLen {
+		return DeviceGrant{}, fmt.Errorf("auth: client name longer than %d characters: %w", deviceClientMaxLen, domain.ErrValidation)
+	}
+	deviceCode, err := IssueToken()
+	if err != nil {
+		return DeviceGrant{}, err
+	}
+	now := time.Now().UTC()
+	d := domain.DeviceAuthorization{
+		DeviceCodeHash: HashAPIToken(deviceCode),
+		ClientName:     client,
+		IP:             strings.TrimSpace(in.IP),
+		UserAgent:      strings.TrimSpace(in.UserAgent),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(deviceGrantTTL),
+	}
+	// 20^8 codes make a collision among live grants unlikely; retry once
+	// or twice rather than fail.
+	for attempt := 0; ; attempt++ {
+		if d.UserCode, err = newUserCode(); err != nil {
+			return DeviceGrant{}, err
+		}
+		err = s.devices.CreateDeviceAuthorization(ctx, d)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, domain.ErrConflict) || attempt == 2 {
+			return DeviceGrant{}, fmt.Errorf("auth: start device authorization: %w", err)
+		}
+	}
+	grant := DeviceGrant{
+		DeviceCode: deviceCode,
+		UserCode:   formatUserCode(d.UserCode),
+		ExpiresAt:  d.ExpiresAt,
+		Interval:   devicePollInterval,
+	}
+	if s.baseURL != "" {
+		grant.VerificationURI = s.baseURL + "/device"
+	}
+	return grant, nil
+}
+
+// PendingDevice returns the undecided grant behind a user code so the
+// web UI can show which client is asking before the user approves.
+func (s *Service) PendingDevice(ctx context.Context, userID uuid.UUID, userCode string) (domain.DeviceAuthorization, error) {
+	if s.devices == nil {
+		return domain.DeviceAuthorization{}, errDeviceAuthUnavailable
+	}
+	if !s.rlCode.Allow("device:" + userID.String()) {
+		return domain.DeviceAuthorization{}, fmt.Errorf("auth: device code throttled: %w", domain.ErrRateLimited)
+	}
+	d, err := s.devices.GetPendingDeviceAuthorization(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		return domain.DeviceAuthorization{}, fmt.Errorf("auth: device lookup: %w", err)
+	}
+	return d, nil
+}
+
+// DecideDeviceInput approves or denies a grant on the user's behalf.
+type DecideDeviceInput struct {
+	UserID    uuid.UUID
+	UserCode  string
+	Approve   bool
+	IP        string
+	UserAgent string
+}
+
+// DecideDevice records the user's answer to a grant. ErrNotFound when
+// the code is unknown, expired or already decided.
+func (s *Service) DecideDevice(ctx context.Context, in DecideDeviceInput) error {
+	if s.devices == nil {
+		return errDeviceAuthUnavailable
+	}
+	limitKey := "device:" + in.UserID.String()
+	if !s.rlCode.Allow(limitKey) {
+		return fmt.Errorf("auth: device code throttled: %w", domain.ErrRateLimited)
+	}
+	d, err := s.devices.DecideDeviceAuthorization(ctx, normalizeUserCode(in.UserCode), in.UserID, in.Approve, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("auth: decide device: %w", err)
+	}
+	s.rlCode.Reset(limitKey)
+	action := domain.ActionAuthDeviceDeny
+	if in.Approve {
+		action = domain.ActionAuthDeviceApprove
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &in.UserID,
+		Action:    action,
+		Payload:   mustJSON(map[string]any{"client_name": d.ClientName, "client_ip": d.IP}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return nil
+}
+
+// PollDeviceInput is one poll by the client.
+type PollDeviceInput struct {
+	DeviceCode string
+	IP         string
+	UserAgent  string
+}
+
+// PollDevice answers a client's poll: errDevicePending or
+// errDeviceSlowDown while the user has not decided, errDeviceDenied or
+// ErrTokenExpired when the grant is over, and a fresh session once it is
+// approved. The outcome is handed out once.
+func (s *Service) PollDevice(ctx context.Context, in PollDeviceInput) (domain.Session, error) {
+	if s.devices == nil {
+		return domain.Session{}, errDeviceAuthUnavailable
+	}
+	hash := HashAPIToken(strings.TrimSpace(in.DeviceCode))
+	now := time.Now().UTC()
+	d, err := s.devices.PollDeviceAuthorization(ctx, hash, now)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Session{}, domain.ErrTokenInvalid
+		}
+		return domain.Session{}, fmt.Errorf("auth: device poll: %w", err)
+	}
+	state := devicePollState(d, now)
+	switch {
+	case errors.Is(state, errDevicePending), errors.Is(state, errDeviceSlowDown):
+		return domain.Session{}, state
+	case state != nil:
+		if _, err := s.devices.TakeDeviceAuthorization(ctx, hash); err != nil && !errors.Is(err, domain.ErrNotFound) {
+			s.cfg.Logger.Warn().Err(err).Msg("auth: failed to drop finished device grant")
+		}
+		return domain.Session{}, state
+	}
+
+	// Approved. Taking the row makes sure only one poll gets a session.
+	if _, err := s.devices.TakeDeviceAuthorization(ctx, hash); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Session{}, domain.ErrTokenInvalid
+		}
+		return domain.Session{}, fmt.Errorf("auth: device take: %w", err)
+	}
+	session, err := s.issueDeviceSession(ctx, *d.UserID, in.IP, in.UserAgent, d.ClientName)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("auth: device issue session: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    d.UserID,
+		Action:    domain.ActionAuthLogin,
+		Payload:   mustJSON(map[string]any{"method": "device", "client_name": d.ClientName, "session_id": session.ID}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return session, nil
+}
+
+// devicePollState says where a grant stands at now, as seen by a poll:
+// nil once approved. d.LastPolledAt is the poll before this one.
+func devicePollState(d domain.DeviceAuthorization, now time.Time) error {
+	switch {
+	case !d.ExpiresAt.After(now):
+		return domain.ErrTokenExpired
+	case d.DeniedAt != nil:
+		return errDeviceDenied
+	case d.ApprovedAt != nil && d.UserID != nil:
+		return nil
+	case d.LastPolledAt != nil && now.Sub(*d.LastPolledAt) < devicePollInterval:
+		return errDeviceSlowDown
+	default:
+		return errDevicePending
+	}
+}
+
+// newUserCode returns userCodeLen letters of userCodeAlphabet.
+func newUserCode() (string, error) {
+	buf := make([]byte, userCodeLen)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("auth: read random: %w", err)
+	}
+	// 256 is not a multiple of 20; drop the biased top values.
+	out := make([]byte, 0, userCodeLen)
+	for len(out) < userCodeLen {
+		for _, b := range buf {
+			if int(b) < 256-256%len(userCodeAlphabet) && len(out) < userCodeLen {
+				out = append(out, userCodeAlphabet[int(b)%len(userCodeAlphabet)])
+			}
+		}
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("auth: read random: %w", err)
+		}
+	}
+	return string(out), nil
+}
+
+// formatUserCode shows a code as two groups, "BCDF-GHJK".
+func formatUserCode(code string) string {
+	return code[:userCodeLen/2] + "-" + code[userCodeLen/2:]
+}
+
+// normalizeUserCode undoes formatting and typing slips: case, dashes
+// and spaces.
+func normalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
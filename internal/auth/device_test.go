@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+func TestUserCode(t *testing.T) {
+	code, err := newUserCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != userCodeLen {
+		t.Fatalf("code = %q, want %d letters", code, userCodeLen)
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(userCodeAlphabet, r) {
+			t.Fatalf("code %q has %q, outside the alphabet", code, r)
+		}
+	}
+	shown := formatUserCode(code)
+	if normalizeUserCode(shown) != code || normalizeUserCode(" "+strings.ToLower(shown)+" ") != code {
+		t.Fatalf("%q does not normalise back to %q", shown, code)
+	}
+}
+
+func TestDevicePollState(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	ptr := func(t time.Time) *time.Time { return &t }
+	uid := uuid.New()
+	live := domain.DeviceAuthorization{ExpiresAt: now.Add(time.Minute)}
+
+	cases := []struct {
+		name string
+		edit func(*domain.DeviceAuthorization)
+		want error
+	}{
+		{"first poll", func(*domain.DeviceAuthorization) {}, errDevicePending},
+		{"polite poll", func(d *domain.DeviceAuthorization) { d.LastPolledAt = ptr(now.Add(-devicePollInterval)) }, errDevicePending},
+		{"eager poll", func(d *domain.DeviceAuthorization) { d.LastPolledAt = ptr(now.Add(-time.Second)) }, errDeviceSlowDown},
+		{"denied", func(d *domain.DeviceAuthorization) { d.UserID, d.DeniedAt = &uid, ptr(now) }, errDeviceDenied},
+		{"approved", func(d *domain.DeviceAuthorization) { d.UserID, d.ApprovedAt = &uid, ptr(now) }, nil},
+		{"approved too late", func(d *domain.DeviceAuthorization) {
+			d.UserID, d.ApprovedAt, d.ExpiresAt = &uid, ptr(now.Add(-2*time.Minute)), now
+		}, domain.ErrTokenExpired},
+	}
+	for _, c := range cases {
+		d := live
+		c.edit(&d)
+		if got := devicePollState(d, now); !errors.Is(got, c.want) || (c.want == nil) != (got == nil) {
+			t.Errorf("%s: state = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
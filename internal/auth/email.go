@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/mail"
+)
+
+// ---- Email -----------------------------------------------------------------
+
+// An account's email address is optional. With a Mailer wired it
+// receives password reset links on request and security alerts: a
+// login that stood out (see anomaly.go), a burst of failed ones, and a
+// change of the address itself (sent to the old one). Mail is queued,
+// so a slow or failing relay never fails the request that caused it.
+
+// SetMailer enables outgoing email. publicBaseURL is the web UI's origin;
+// reset links point at <publicBaseURL>/reset-password.
+func (s *Service) SetMailer(m Mailer, publicBaseURL string) {
+	s.mailer = m
+	s.baseURL = strings.TrimRight(publicBaseURL, "/")
+}
+
+// ChangeEmailInput sets or, with an empty Email, clears the address.
+// The current password is required, since the address receives reset
+// links.
+type ChangeEmailInput struct {
+	UserID    uuid.UUID
+	Email     string
+	Password  string
+	IP        string
+	UserAgent string
+}
+
+// ChangeEmail updates the user's address. ErrConflict when another
+// account uses it.
+func (s *Service) ChangeEmail(ctx context.Context, in ChangeEmailInput) (domain.User, error) {
+	email := strings.TrimSpace(in.Email)
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return domain.User{}, fmt.Errorf("auth: email: %w", domain.ErrValidation)
+		}
+		email = addr
+	}
+	if err := s.CheckPassword(ctx, in.UserID, in.Password); err != nil {
+		return domain.User{}, err
+	}
+	user, err := s.users.GetByID(ctx, in.UserID)
+	if err != nil {
+		return domain.User{}, fmt.Errorf("auth: change email: %w", err)
+	}
+	if user.Email == email {
+		return user, nil
+	}
+	if err := s.users.UpdateEmail(ctx, in.UserID, email); err != nil {
+		return domain.User{}, fmt.Errorf("auth: change email: %w", err)
+	}
+	if user.Email != "" {
+		s.sendMail(ctx, user.Email, mail.TemplateEmailChanged, mail.EmailChangedData{
+			Username: user.Username,
+			NewEmail: email,
+			At:       time.Now().UTC(),
+		})
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &in.UserID,
+		Action:    domain.ActionUserUpdate,
+		Payload:   mustJSON(map[string]any{"field": "email", "cleared": email == ""}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	user.Email = email
+	return user, nil
+}
+
+// RequestPasswordResetInput asks for a reset link by email address.
+type RequestPasswordResetInput struct {
+	Email     string
+	IP        string
+	UserAgent string
+}
+
+// resetRequestTimeout bounds the work RequestPasswordReset leaves
+// running once it has answered.
+const resetRequestTimeout = 30 * time.Second
+
+// RequestPasswordReset mails a reset link to the account with that
+// address. It answers the same whether or not one exists, so it cannot
+// be used to find out which addresses are registered: past validation
+// and the IP budget, the lookup and the mail happen after it returns,
+// and its timing says nothing either.
+func (s *Service) RequestPasswordReset(ctx context.Context, in RequestPasswordResetInput) error {
+	if s.resets == nil || s.mailer == nil || s.baseURL == "" {
+		return errPasswordResetUnavailable
+	}
+	if !s.rlResetRequest.Allow(in.IP) {
+		return fmt.Errorf("auth: password reset request throttled: %w", domain.ErrRateLimited)
+	}
+	email, err := mail.ParseAddress(in.Email)
+	if err != nil {
+		return fmt.Errorf("auth: email: %w", domain.ErrValidation)
+	}
+	in.Email = email
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resetRequestTimeout)
+		defer cancel()
+		if err := s.mailPasswordReset(ctx, in); err != nil {
+			s.cfg.Logger.Warn().Err(err).Msg("auth: password reset request")
+		}
+	}()
+	return nil
+}
+
+// mailPasswordReset does the work of a reset request for in.Email, a
+// parsed address: nothing when no account uses it.
+func (s *Service) mailPasswordReset(ctx context.Context, in RequestPasswordResetInput) error {
+	user, err := s.users.GetByEmail(ctx, in.Email)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("auth: password reset request lookup: %w", err)
+	}
+	// Each request replaces the pending token; the per-account budget
+	// keeps the endpoint from being used to flood someone's inbox.
+	if user.SuspendedAt != nil || !s.rlUser.Allow("reset:"+user.ID.String()) {
+		return nil
+	}
+	token, expires, err := s.createPasswordReset(ctx, user.ID, nil)
+	if err != nil {
+		return fmt.Errorf("auth: password reset request: %w", err)
+	}
+	s.sendMail(ctx, user.Email, mail.TemplatePasswordReset, mail.PasswordResetData{
+		Username:  user.Username,
+		URL:       s.baseURL + "/reset-password#token=" + url.QueryEscape(token),
+		ExpiresAt: expires,
+	})
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &user.ID,
+		Action:    domain.ActionAuthResetRequest,
+		Payload:   mustJSON(map[string]any{"expires_at": expires}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return nil
+}
+
+// alertNewDevice mails the user when sess comes from a user agent that
+// none of their other sessions has. It stands in for reviewLogin when
+// no LoginSecurityStore is set.
+func (s *Service) alertNewDevice(ctx context.Context, user domain.User, sess domain.Session) {
+	if s.mailer == nil || user.Email == "" {
+		return
+	}
+	others, err := s.sessions.ListSessionsForUser(ctx, user.ID)
+	if err != nil {
+		s.cfg.Logger.Warn().Err(err).Msg("auth: new device check")
+		return
+	}
+	for _, o := range others {
+		if o.ID != sess.ID && o.UserAgent == sess.UserAgent {
+			return
+		}
+	}
+	s.sendMail(ctx, user.Email, mail.TemplateNewLogin, mail.NewLoginData{
+		Username:  user.Username,
+		IP:        sess.IP,
+		UserAgent: sess.UserAgent,
+		At:        sess.CreatedAt,
+	})
+}
+
+// sendMail queues a message, logging rather than failing the caller.
+func (s *Service) sendMail(ctx context.Context, to, template string, data any) {
+	if s.mailer == nil {
+		return
+	}
+	if err := s.mailer.Enqueue(ctx, to, template, data); err != nil {
+		s.cfg.Logger.Warn().Err(err).Str("template", template).Msg("auth: queue mail")
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// slowUsers answers GetByEmail once release is closed; the rest of
+// UserRepo is not used by a reset request.
+type slowUsers struct {
+	UserRepo
+	release chan struct{}
+	byEmail map[string]domain.User
+}
+
+func (u *slowUsers) GetByEmail(ctx context.Context, email string) (domain.User, error) {
+	select {
+	case <-u.release:
+	case <-ctx.Done():
+		return domain.User{}, ctx.Err()
+	}
+	user, ok := u.byEmail[email]
+	if !ok {
+		return domain.User{}, domain.ErrNotFound
+	}
+	return user, nil
+}
+
+type memResets struct{ created chan domain.PasswordReset }
+
+func (m *memResets) CreatePasswordReset(_ context.Context, r domain.PasswordReset) error {
+	m.created <- r
+	return nil
+}
+
+func (m *memResets) TakePasswordReset(context.Context, string) (domain.PasswordReset, error) {
+	return domain.PasswordReset{}, domain.ErrNotFound
+}
+
+type nopMailer struct{}
+
+func (nopMailer) Enqueue(context.Context, string, string, any) error { return nil }
+
+func TestRequestPasswordResetAnswersBeforeTheLookup(t *testing.T) {
+	known := domain.User{ID: uuid.New(), Username: "ada", Email: "ada@example.com"}
+	users := &slowUsers{release: make(chan struct{}), byEmail: map[string]domain.User{known.Email: known}}
+	resets := &memResets{created: make(chan domain.PasswordReset, 2)}
+	s := &Service{users: users, cfg: Config{Logger: zerolog.Nop()}}
+	s.setLimiters(nil)
+	s.SetPasswordResetStore(resets)
+	s.SetMailer(nopMailer{}, "https://lumi.example")
+
+	// Both answer while the lookup is still blocked: neither the
+	// result nor the time taken depends on the account existing.
+	for _, email := range []string{known.Email, "nobody@example.com"} {
+		if err := s.RequestPasswordReset(context.Background(), RequestPasswordResetInput{Email: email}); err != nil {
+			t.Fatalf("request for %s: %v", email, err)
+		}
+	}
+	close(users.release)
+	select {
+	case r := <-resets.created:
+		if r.UserID != known.ID {
+			t.Fatalf("reset created for %s, want %s", r.UserID, known.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset created for the known address")
+	}
+	select {
+	case r := <-resets.created:
+		t.Fatalf("unexpected second reset for %s", r.UserID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,11 +1,18 @@
 package auth
 
 import (
+	"encoding/json"
 	"errors"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 
+	"github.com/ViniZap4/lumi-server/internal/capguard"
 	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/webauthn"
 )
 
 type Handlers struct {
@@ -20,14 +27,65 @@ func NewHandlers(svc *Service) *Handlers {
 }
 
 // Register attaches the routes documented in SPEC.md "API surface".
+// Account management needs a real session; a personal access token may
+// only read the profile.
 func (h *Handlers) Register(app *fiber.App) {
+	sessionOnly := capguard.SessionOnly()
+
 	app.Post("/api/auth/register", h.RegisterHandler)
 	app.Post("/api/auth/login", h.Login)
-	app.Post("/api/auth/logout", Required(h.svc), h.Logout)
+	app.Post("/api/auth/login/2fa", h.LoginSecondFactor)
+	app.Post("/api/auth/logout", Required(h.svc), sessionOnly, h.Logout)
+	app.Post("/api/auth/refresh", h.Refresh)
 
 	app.Get("/api/users/me", Required(h.svc), h.Me)
-	app.Patch("/api/users/me", Required(h.svc), h.UpdateMe)
-	app.Post("/api/users/me/password", Required(h.svc), h.ChangePassword)
+	app.Patch("/api/users/me", Required(h.svc), sessionOnly, h.UpdateMe)
+	app.Post("/api/users/me/password", Required(h.svc), sessionOnly, h.ChangePassword)
+	app.Put("/api/users/me/email", Required(h.svc), sessionOnly, h.ChangeEmail)
+
+	app.Get("/api/users/me/sessions", Required(h.svc), sessionOnly, h.ListSessions)
+	app.Delete("/api/users/me/sessions", Required(h.svc), sessionOnly, h.RevokeOtherSessions)
+	app.Delete("/api/users/me/sessions/:id", Required(h.svc), sessionOnly, h.RevokeSession)
+	app.Get("/api/users/me/security-events", Required(h.svc), sessionOnly, h.ListSecurityEvents)
+
+	app.Post("/api/users/me/tokens", Required(h.svc), sessionOnly, h.CreateToken)
+	app.Get("/api/users/me/tokens", Required(h.svc), sessionOnly, h.ListTokens)
+	app.Delete("/api/users/me/tokens/:id", Required(h.svc), sessionOnly, h.RevokeToken)
+
+	app.Get("/api/users/me/2fa", Required(h.svc), sessionOnly, h.TwoFactorStatus)
+	app.Post("/api/users/me/2fa/totp", Required(h.svc), sessionOnly, h.BeginTOTP)
+	app.Post("/api/users/me/2fa/totp/confirm", Required(h.svc), sessionOnly, h.ConfirmTOTP)
+	app.Post("/api/users/me/2fa/disable", Required(h.svc), sessionOnly, h.DisableTOTP)
+	app.Post("/api/users/me/2fa/recovery-codes", Required(h.svc), sessionOnly, h.RegenerateRecoveryCodes)
+
+	app.Post("/api/auth/webauthn/register/begin", Required(h.svc), sessionOnly, h.BeginWebAuthnRegistration)
+	app.Post("/api/auth/webauthn/register/finish", Required(h.svc), sessionOnly, h.FinishWebAuthnRegistration)
+	app.Post("/api/auth/webauthn/login/begin", h.BeginWebAuthnLogin)
+	app.Post("/api/auth/webauthn/login/finish", h.FinishWebAuthnLogin)
+	app.Post("/api/auth/webauthn/2fa/begin", h.BeginWebAuthnSecondFactor)
+	app.Post("/api/auth/webauthn/2fa/finish", h.FinishWebAuthnSecondFactor)
+	app.Get("/api/users/me/webauthn", Required(h.svc), sessionOnly, h.ListWebAuthnCredentials)
+	app.Delete("/api/users/me/webauthn/:id", Required(h.svc), sessionOnly, h.RemoveWebAuthnCredential)
+
+	app.Get("/api/auth/oidc", h.OIDCInfo)
+	app.Post("/api/auth/oidc/start", h.StartOIDC)
+	app.Post("/api/auth/oidc/callback", h.CompleteOIDC)
+	app.Post("/api/users/me/oidc/link", Required(h.svc), sessionOnly, h.LinkOIDC)
+	app.Post("/api/users/me/oidc/link/callback", Required(h.svc), sessionOnly, h.CompleteOIDCLink)
+	app.Post("/api/users/me/oidc/reauth", Required(h.svc), sessionOnly, h.LinkOIDC)
+	app.Post("/api/users/me/oidc/reauth/callback", Required(h.svc), sessionOnly, h.ReauthenticateOIDC)
+
+	app.Post("/api/auth/reset", h.ResetPassword)
+	app.Post("/api/auth/reset/request", h.RequestPasswordReset)
+	app.Post("/api/admin/users/:id/password-reset", Required(h.svc), sessionOnly, AdminOnly(h.svc), h.IssuePasswordReset)
+	app.Get("/api/admin/rate-limits", Required(h.svc), sessionOnly, AdminOnly(h.svc), h.ListLockouts)
+	app.Post("/api/admin/rate-limits/unlock", Required(h.svc), sessionOnly, AdminOnly(h.svc), h.Unlock)
+
+	app.Post("/api/auth/device", h.StartDevice)
+	app.Post("/api/auth/device/token", h.PollDevice)
+	app.Get("/api/auth/device/:code", Required(h.svc), sessionOnly, h.PendingDevice)
+	app.Post("/api/auth/device/:code/approve", Required(h.svc), sessionOnly, h.ApproveDevice)
+	app.Post("/api/auth/device/:code/deny", Required(h.svc), sessionOnly, h.DenyDevice)
 }
 
 type registerReq struct {
@@ -55,19 +113,23 @@ type changePasswordReq struct {
 }
 
 type sessionResp struct {
-	Token     string  `json:"token"`
-	ExpiresAt string  `json:"expires_at"`
-	User      userDTO `json:"user"`
+	Token            string  `json:"token"`
+	ExpiresAt        string  `json:"expires_at"`
+	RefreshToken     string  `json:"refresh_token,omitempty"`
+	RefreshExpiresAt string  `json:"refresh_expires_at,omitempty"`
+	User             userDTO `json:"user"`
 }
 
 type userDTO struct {
 	ID          string `json:"id"`
 	Username    string `json:"username"`
 	DisplayName string `json:"display_name"`
+	IsAdmin     bool   `json:"is_admin,omitempty"`
+	Email       string `json:"email,omitempty"`
 }
 
 func (h *Handlers) RegisterHandler(c *fiber.Ctx) error {
-	if h.svc.cfg.RegistrationPolicy != PolicyOpen {
+	if h.svc.RegistrationPolicy(c.UserContext()) != PolicyOpen {
 		return errorJSON(c, fiber.StatusForbidden, "registration_closed",
 			"public registration is disabled; use an invite link")
 	}
@@ -91,21 +153,9 @@ func (h *Handlers) RegisterHandler(c *fiber.Ctx) error {
 	}
 	user, err := h.svc.users.GetByID(c.UserContext(), sess.UserID)
 	if err != nil {
-		return c.Status(fiber.StatusCreated).JSON(sessionResp{
-			Token:     sess.Token,
-			ExpiresAt: sess.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
-			User:      userDTO{ID: sess.UserID.String()},
-		})
+		user = domain.User{ID: sess.UserID}
 	}
-	return c.Status(fiber.StatusCreated).JSON(sessionResp{
-		Token:     sess.Token,
-		ExpiresAt: sess.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
-		User: userDTO{
-			ID:          user.ID.String(),
-			Username:    user.Username,
-			DisplayName: user.DisplayName,
-		},
-	})
+	return h.writeSession(c, fiber.StatusCreated, sess, user)
 }
 
 func (h *Handlers) Login(c *fiber.Ctx) error {
@@ -119,20 +169,118 @@ func (h *Handlers) Login(c *fiber.Ctx) error {
 		IP:        c.IP(),
 		UserAgent: c.Get(fiber.HeaderUserAgent),
 	})
+	if err != nil {
+		return loginErr(c, err)
+	}
+	return h.loggedIn(c, sess)
+}
+
+// loginErr answers a failed login; a second factor still to be given is
+// 2fa_required with the challenge to complete it.
+func loginErr(c *fiber.Ctx, err error) error {
+	var sf *SecondFactorRequired
+	if errors.As(err, &sf) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error":      "2fa_required",
+			"challenge":  sf.Challenge,
+			"expires_at": sf.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
+			"methods":    sf.Methods,
+		})
+	}
+	return mapServiceErr(c, err)
+}
+
+type secondFactorReq struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginSecondFactor — POST /api/auth/login/2fa. Completes a login that
+// answered 2fa_required.
+func (h *Handlers) LoginSecondFactor(c *fiber.Ctx) error {
+	var body secondFactorReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	sess, err := h.svc.CompleteLogin(c.UserContext(), SecondFactorInput{
+		Challenge:    body.Challenge,
+		Code:         body.Code,
+		RecoveryCode: body.RecoveryCode,
+		IP:           c.IP(),
+		UserAgent:    c.Get(fiber.HeaderUserAgent),
+	})
 	if err != nil {
 		return mapServiceErr(c, err)
 	}
+	return h.loggedIn(c, sess)
+}
+
+func (h *Handlers) loggedIn(c *fiber.Ctx, sess domain.Session) error {
 	user, err := h.svc.users.GetByID(c.UserContext(), sess.UserID)
 	if err != nil {
 		return mapServiceErr(c, err)
 	}
-	return c.Status(fiber.StatusOK).JSON(sessionResp{
+	return h.writeSession(c, fiber.StatusOK, sess, user)
+}
+
+// writeSession answers a login with the session's credentials: its
+// opaque token or, with signed tokens, an access and a refresh token.
+func (h *Handlers) writeSession(c *fiber.Ctx, status int, sess domain.Session, user domain.User) error {
+	resp := sessionResp{
 		Token:     sess.Token,
 		ExpiresAt: sess.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
 		User: userDTO{
 			ID:          user.ID.String(),
 			Username:    user.Username,
 			DisplayName: user.DisplayName,
+			IsAdmin:     user.IsAdmin,
+			Email:       user.Email,
+		},
+	}
+	if h.svc.SignedTokens() {
+		pair, err := h.svc.IssueTokenPair(c.UserContext(), user, sess.ID)
+		if err != nil {
+			return mapServiceErr(c, err)
+		}
+		resp.Token = pair.AccessToken
+		resp.ExpiresAt = pair.AccessExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00")
+		resp.RefreshToken = pair.RefreshToken
+		resp.RefreshExpiresAt = pair.RefreshExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00")
+	}
+	return c.Status(status).JSON(resp)
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh — POST /api/auth/refresh. Trades a refresh token for a new
+// access and refresh token; each refresh token works once.
+func (h *Handlers) Refresh(c *fiber.Ctx) error {
+	var body refreshReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	pair, user, err := h.svc.Refresh(c.UserContext(), RefreshInput{
+		Token:     body.RefreshToken,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.JSON(sessionResp{
+		Token:            pair.AccessToken,
+		ExpiresAt:        pair.AccessExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		RefreshToken:     pair.RefreshToken,
+		RefreshExpiresAt: pair.RefreshExpiresAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		User: userDTO{
+			ID:          user.ID.String(),
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			IsAdmin:     user.IsAdmin,
+			Email:       user.Email,
 		},
 	})
 }
@@ -142,7 +290,14 @@ func (h *Handlers) Logout(c *fiber.Ctx) error {
 	if sess == nil {
 		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
 	}
-	if err := h.svc.Logout(c.UserContext(), sess.Token); err != nil {
+	var err error
+	if sess.Token == "" {
+		// Signed access tokens name their session but are not its token.
+		err = h.svc.LogoutSession(c.UserContext(), sess.UserID, sess.ID)
+	} else {
+		err = h.svc.Logout(c.UserContext(), sess.Token)
+	}
+	if err != nil {
 		return mapServiceErr(c, err)
 	}
 	return c.Status(fiber.StatusNoContent).Send(nil)
@@ -153,10 +308,19 @@ func (h *Handlers) Me(c *fiber.Ctx) error {
 	if user == nil {
 		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
 	}
+	if viaAccessToken(c) {
+		full, err := h.svc.users.GetByID(c.UserContext(), user.ID)
+		if err != nil {
+			return mapServiceErr(c, err)
+		}
+		user = &full
+	}
 	return c.Status(fiber.StatusOK).JSON(userDTO{
 		ID:          user.ID.String(),
 		Username:    user.Username,
 		DisplayName: user.DisplayName,
+		IsAdmin:     user.IsAdmin,
+		Email:       user.Email,
 	})
 }
 
@@ -180,6 +344,8 @@ func (h *Handlers) UpdateMe(c *fiber.Ctx) error {
 		ID:          updated.ID.String(),
 		Username:    updated.Username,
 		DisplayName: updated.DisplayName,
+		IsAdmin:     updated.IsAdmin,
+		Email:       updated.Email,
 	})
 }
 
@@ -198,8 +364,897 @@ func (h *Handlers) ChangePassword(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusNoContent).Send(nil)
 }
 
+type changeEmailReq struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// ChangeEmail — PUT /api/users/me/email. An empty email removes it.
+func (h *Handlers) ChangeEmail(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body changeEmailReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	updated, err := h.svc.ChangeEmail(c.UserContext(), ChangeEmailInput{
+		UserID:    user.ID,
+		Email:     body.Email,
+		Password:  body.Password,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(userDTO{
+		ID:          updated.ID.String(),
+		Username:    updated.Username,
+		DisplayName: updated.DisplayName,
+		IsAdmin:     updated.IsAdmin,
+		Email:       updated.Email,
+	})
+}
+
+// sessionDTO never carries the token; ID is the public handle.
+type sessionDTO struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Device     string    `json:"device,omitempty"`
+	Current    bool      `json:"current"`
+}
+
+// ListSessions — GET /api/users/me/sessions.
+func (h *Handlers) ListSessions(c *fiber.Ctx) error {
+	user, sess := UserFromCtx(c), SessionFromCtx(c)
+	if user == nil || sess == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	ss, err := h.svc.ListSessions(c.UserContext(), user.ID)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	out := make([]sessionDTO, 0, len(ss))
+	for _, s := range ss {
+		out = append(out, sessionDTO{
+			ID:         s.ID.String(),
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+			CreatedAt:  s.CreatedAt.UTC(),
+			LastUsedAt: s.LastUsedAt.UTC(),
+			ExpiresAt:  s.ExpiresAt.UTC(),
+			Device:     s.DeviceName,
+			Current:    s.ID == sess.ID,
+		})
+	}
+	return c.JSON(fiber.Map{"sessions": out})
+}
+
+// RevokeSession — DELETE /api/users/me/sessions/:id. Revoking the current
+// session is a logout.
+func (h *Handlers) RevokeSession(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid session id")
+	}
+	if err := h.svc.RevokeSession(c.UserContext(), user.ID, id, c.IP(), c.Get(fiber.HeaderUserAgent)); err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// RevokeOtherSessions — DELETE /api/users/me/sessions: log out everywhere
+// but here.
+func (h *Handlers) RevokeOtherSessions(c *fiber.Ctx) error {
+	user, sess := UserFromCtx(c), SessionFromCtx(c)
+	if user == nil || sess == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	n, err := h.svc.RevokeOtherSessions(c.UserContext(), user.ID, sess.ID, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.JSON(fiber.Map{"revoked": n})
+}
+
+type securityEventDTO struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ListSecurityEvents — GET /api/users/me/security-events?limit=N: logins
+// that stood out and bursts of failed ones, newest first.
+func (h *Handlers) ListSecurityEvents(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	events, err := h.svc.ListSecurityEvents(c.UserContext(), user.ID, limit)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	out := make([]securityEventDTO, 0, len(events))
+	for _, e := range events {
+		d := securityEventDTO{
+			ID:        e.ID.String(),
+			Kind:      e.Kind,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			CreatedAt: e.CreatedAt.UTC(),
+		}
+		if len(e.Details) > 0 && string(e.Details) != "{}" {
+			d.Details = e.Details
+		}
+		out = append(out, d)
+	}
+	return c.JSON(fiber.Map{"events": out})
+}
+
+type createTokenReq struct {
+	Name         string               `json:"name"`
+	VaultID      *uuid.UUID           `json:"vault_id"`
+	Capabilities domain.CapabilitySet `json:"capabilities"`
+	ExpiresAt    time.Time            `json:"expires_at"`
+}
+
+type tokenDTO struct {
+	ID           string               `json:"id"`
+	Name         string               `json:"name"`
+	VaultID      *uuid.UUID           `json:"vault_id"`
+	Capabilities domain.CapabilitySet `json:"capabilities"`
+	CreatedAt    time.Time            `json:"created_at"`
+	ExpiresAt    time.Time            `json:"expires_at"`
+	LastUsedAt   *time.Time           `json:"last_used_at"`
+}
+
+type createTokenResp struct {
+	tokenDTO
+	Token string `json:"token"`
+}
+
+func toTokenDTO(t domain.APIToken) tokenDTO {
+	return tokenDTO{
+		ID:           t.ID.String(),
+		Name:         t.Name,
+		VaultID:      t.VaultID,
+		Capabilities: t.Capabilities,
+		CreatedAt:    t.CreatedAt.UTC(),
+		ExpiresAt:    t.ExpiresAt.UTC(),
+		LastUsedAt:   t.LastUsedAt,
+	}
+}
+
+// CreateToken — POST /api/users/me/tokens. The plaintext token is in
+// this response only.
+func (h *Handlers) CreateToken(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body createTokenReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	t, secret, err := h.svc.CreateAPIToken(c.UserContext(), CreateAPITokenInput{
+		UserID:       user.ID,
+		Name:         body.Name,
+		VaultID:      body.VaultID,
+		Capabilities: body.Capabilities,
+		ExpiresAt:    body.ExpiresAt,
+		IP:           c.IP(),
+		UserAgent:    c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(createTokenResp{tokenDTO: toTokenDTO(t), Token: secret})
+}
+
+// ListTokens — GET /api/users/me/tokens.
+func (h *Handlers) ListTokens(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	ts, err := h.svc.ListAPITokens(c.UserContext(), user.ID)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	out := make([]tokenDTO, 0, len(ts))
+	for _, t := range ts {
+		out = append(out, toTokenDTO(t))
+	}
+	return c.JSON(fiber.Map{"tokens": out})
+}
+
+// RevokeToken — DELETE /api/users/me/tokens/:id.
+func (h *Handlers) RevokeToken(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid token id")
+	}
+	if err := h.svc.RevokeAPIToken(c.UserContext(), user.ID, id, c.IP(), c.Get(fiber.HeaderUserAgent)); err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+type twoFactorStatusDTO struct {
+	Enabled             bool       `json:"enabled"`
+	TOTPEnabled         bool       `json:"totp_enabled"`
+	TOTPPending         bool       `json:"totp_pending"`
+	TOTPConfirmedAt     *time.Time `json:"totp_confirmed_at,omitempty"`
+	RecoveryCodesLeft   int        `json:"recovery_codes_left"`
+	WebAuthnCredentials int        `json:"webauthn_credentials"`
+	Required            bool       `json:"required"`
+}
+
+type totpCodeReq struct {
+	Code string `json:"code"`
+}
+
+type disableTOTPReq struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// TwoFactorStatus — GET /api/users/me/2fa.
+func (h *Handlers) TwoFactorStatus(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	st, err := h.svc.TwoFactorStatus(c.UserContext(), user.ID)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(twoFactorStatusDTO(st))
+}
+
+// BeginTOTP — POST /api/users/me/2fa/totp. Returns the secret and the
+// otpauth:// URI to render as a QR code; 2FA stays off until confirmed.
+func (h *Handlers) BeginTOTP(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	secret, uri, err := h.svc.BeginTOTP(c.UserContext(), user.ID)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"secret": secret, "otpauth_uri": uri})
+}
+
+// ConfirmTOTP — POST /api/users/me/2fa/totp/confirm. The recovery codes
+// in the response are never shown again.
+func (h *Handlers) ConfirmTOTP(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body totpCodeReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	codes, err := h.svc.ConfirmTOTP(c.UserContext(), user.ID, body.Code, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// DisableTOTP — POST /api/users/me/2fa/disable.
+func (h *Handlers) DisableTOTP(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body disableTOTPReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	err := h.svc.DisableTOTP(c.UserContext(), user.ID, body.Password, body.Code, body.RecoveryCode,
+		c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// RegenerateRecoveryCodes — POST /api/users/me/2fa/recovery-codes.
+func (h *Handlers) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body totpCodeReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	codes, err := h.svc.RegenerateRecoveryCodes(c.UserContext(), user.ID, body.Code, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// WebAuthn begin endpoints answer {"publicKey": options}, ready for
+// navigator.credentials.create/get once the base64url fields are
+// decoded (PublicKeyCredential.parse*OptionsFromJSON does that).
+
+type webauthnRegisterReq struct {
+	Name       string                       `json:"name"`
+	Credential webauthn.AttestationResponse `json:"credential"`
+}
+
+type webauthnAssertReq struct {
+	Challenge  string                     `json:"challenge"` // login challenge, second factor only
+	Credential webauthn.AssertionResponse `json:"credential"`
+}
+
+type removeWebAuthnReq struct {
+	Password string `json:"password"`
+}
+
+type webauthnCredentialDTO struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func toWebAuthnCredentialDTO(c domain.WebAuthnCredential) webauthnCredentialDTO {
+	transports := c.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+	return webauthnCredentialDTO{
+		ID:         c.ID.String(),
+		Name:       c.Name,
+		Transports: transports,
+		CreatedAt:  c.CreatedAt,
+		LastUsedAt: c.LastUsedAt,
+	}
+}
+
+// BeginWebAuthnRegistration — POST /api/auth/webauthn/register/begin.
+func (h *Handlers) BeginWebAuthnRegistration(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	opts, err := h.svc.BeginWebAuthnRegistration(c.UserContext(), user.ID)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"publicKey": opts})
+}
+
+// FinishWebAuthnRegistration — POST /api/auth/webauthn/register/finish.
+func (h *Handlers) FinishWebAuthnRegistration(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body webauthnRegisterReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	cred, err := h.svc.FinishWebAuthnRegistration(c.UserContext(), user.ID, body.Name, body.Credential,
+		c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(toWebAuthnCredentialDTO(cred))
+}
+
+// BeginWebAuthnLogin — POST /api/auth/webauthn/login/begin.
+func (h *Handlers) BeginWebAuthnLogin(c *fiber.Ctx) error {
+	opts, err := h.svc.BeginWebAuthnLogin(c.UserContext())
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"publicKey": opts})
+}
+
+// FinishWebAuthnLogin — POST /api/auth/webauthn/login/finish.
+func (h *Handlers) FinishWebAuthnLogin(c *fiber.Ctx) error {
+	var body webauthnAssertReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	sess, err := h.svc.FinishWebAuthnLogin(c.UserContext(), body.Credential, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return h.loggedIn(c, sess)
+}
+
+// BeginWebAuthnSecondFactor — POST /api/auth/webauthn/2fa/begin.
+func (h *Handlers) BeginWebAuthnSecondFactor(c *fiber.Ctx) error {
+	var body webauthnAssertReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	opts, err := h.svc.BeginWebAuthnSecondFactor(c.UserContext(), body.Challenge)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"publicKey": opts})
+}
+
+// FinishWebAuthnSecondFactor — POST /api/auth/webauthn/2fa/finish.
+func (h *Handlers) FinishWebAuthnSecondFactor(c *fiber.Ctx) error {
+	var body webauthnAssertReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	sess, err := h.svc.FinishWebAuthnSecondFactor(c.UserContext(), body.Challenge, body.Credential,
+		c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return h.loggedIn(c, sess)
+}
+
+// ListWebAuthnCredentials — GET /api/users/me/webauthn.
+func (h *Handlers) ListWebAuthnCredentials(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	creds, err := h.svc.ListWebAuthnCredentials(c.UserContext(), user.ID)
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	out := make([]webauthnCredentialDTO, 0, len(creds))
+	for _, cred := range creds {
+		out = append(out, toWebAuthnCredentialDTO(cred))
+	}
+	return c.Status(fiber.StatusOK).JSON(out)
+}
+
+// RemoveWebAuthnCredential — DELETE /api/users/me/webauthn/:id, with
+// {"password": …} in the body.
+func (h *Handlers) RemoveWebAuthnCredential(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid credential id")
+	}
+	var body removeWebAuthnReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	if err := h.svc.RemoveWebAuthnCredential(c.UserContext(), user.ID, id, body.Password,
+		c.IP(), c.Get(fiber.HeaderUserAgent)); err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// OIDC: the provider redirects the browser to the web client's
+// callback page, which posts the code and state here, or to the link
+// callback when it started a link. The session token therefore never
+// travels in a URL.
+
+type oidcStartReq struct {
+	Consent struct {
+		TosVersion     string `json:"tos_version"`
+		PrivacyVersion string `json:"privacy_version"`
+	} `json:"consent"`
+}
+
+type oidcCallbackReq struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// OIDCInfo — GET /api/auth/oidc. Tells clients whether to offer SSO.
+func (h *Handlers) OIDCInfo(c *fiber.Ctx) error {
+	if !h.svc.OIDCEnabled() {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"enabled": false})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"enabled": true, "issuer": h.svc.idp.Issuer()})
+}
+
+// StartOIDC — POST /api/auth/oidc/start. Returns the provider URL.
+func (h *Handlers) StartOIDC(c *fiber.Ctx) error {
+	var body oidcStartReq
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+		}
+	}
+	u, err := h.svc.StartOIDC(c.UserContext(), OIDCStartInput{
+		Consent: ConsentInput{
+			TosVersion:     body.Consent.TosVersion,
+			PrivacyVersion: body.Consent.PrivacyVersion,
+		},
+		IP: c.IP(),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"authorization_url": u})
+}
+
+// LinkOIDC — POST /api/users/me/oidc/link, and /api/users/me/oidc/reauth.
+// Like StartOIDC, but the callback attaches the provider identity to the
+// signed-in account, or re-authenticates it.
+func (h *Handlers) LinkOIDC(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	id := user.ID
+	u, err := h.svc.StartOIDC(c.UserContext(), OIDCStartInput{LinkUserID: &id, IP: c.IP()})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"authorization_url": u})
+}
+
+// CompleteOIDC — POST /api/auth/oidc/callback.
+func (h *Handlers) CompleteOIDC(c *fiber.Ctx) error {
+	var body oidcCallbackReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	sess, err := h.svc.CompleteOIDC(c.UserContext(), OIDCCallbackInput{
+		Code:      body.Code,
+		State:     body.State,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return loginErr(c, err)
+	}
+	return h.loggedIn(c, sess)
+}
+
+// CompleteOIDCLink — POST /api/users/me/oidc/link/callback. The callback
+// of a link started with LinkOIDC; only the user who started it can
+// complete it.
+func (h *Handlers) CompleteOIDCLink(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body oidcCallbackReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	err := h.svc.CompleteOIDCLink(c.UserContext(), user.ID, OIDCCallbackInput{
+		Code:      body.Code,
+		State:     body.State,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+// ReauthenticateOIDC — POST /api/users/me/oidc/reauth/callback. The
+// callback of a round trip started at /api/users/me/oidc/reauth (the
+// same as a link); answers a grant to send as the password of a
+// password-confirmed request.
+func (h *Handlers) ReauthenticateOIDC(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body oidcCallbackReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	token, expires, err := h.svc.ReauthenticateOIDC(c.UserContext(), user.ID, OIDCCallbackInput{
+		Code:      body.Code,
+		State:     body.State,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"reauth_token": token, "expires_at": expires})
+}
+
+type resetPasswordReq struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// IssuePasswordReset — POST /api/admin/users/:id/password-reset. The
+// token is shown once; the admin hands it to the user out of band.
+func (h *Handlers) IssuePasswordReset(c *fiber.Ctx) error {
+	admin := UserFromCtx(c)
+	if admin == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid user id")
+	}
+	token, expires, err := h.svc.IssuePasswordReset(c.UserContext(), IssuePasswordResetInput{
+		AdminID:   admin.ID,
+		UserID:    id,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"user_id":    id.String(),
+		"token":      token,
+		"expires_at": expires,
+	})
+}
+
+// ResetPassword — POST /api/auth/reset. Public: the token is the
+// credential.
+func (h *Handlers) ResetPassword(c *fiber.Ctx) error {
+	var body resetPasswordReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	err := h.svc.ResetPassword(c.UserContext(), ResetPasswordInput{
+		Token:       body.Token,
+		NewPassword: body.NewPassword,
+		IP:          c.IP(),
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
+type requestResetReq struct {
+	Email string `json:"email"`
+}
+
+// RequestPasswordReset — POST /api/auth/reset/request. Always 202 for a
+// well-formed address, whether or not an account uses it.
+func (h *Handlers) RequestPasswordReset(c *fiber.Ctx) error {
+	var body requestResetReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	err := h.svc.RequestPasswordReset(c.UserContext(), RequestPasswordResetInput{
+		Email:     body.Email,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).Send(nil)
+}
+
+type lockoutDTO struct {
+	Scope       string     `json:"scope"`
+	Key         string     `json:"key"`
+	Lockouts    int        `json:"lockouts"`
+	LockedUntil *time.Time `json:"locked_until"`
+}
+
+// ListLockouts — GET /api/admin/rate-limits: the usernames, accounts and
+// addresses locked out right now.
+func (h *Handlers) ListLockouts(c *fiber.Ctx) error {
+	buckets, err := h.svc.ListLockouts(c.UserContext())
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	out := make([]lockoutDTO, 0, len(buckets))
+	for _, b := range buckets {
+		out = append(out, lockoutDTO{Scope: b.Scope, Key: b.Key, Lockouts: b.Lockouts, LockedUntil: b.LockedUntil})
+	}
+	return c.JSON(fiber.Map{"lockouts": out})
+}
+
+type unlockReq struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
+
+// Unlock — POST /api/admin/rate-limits/unlock with a username and/or an
+// ip: lifts their lockouts and empties their buckets.
+func (h *Handlers) Unlock(c *fiber.Ctx) error {
+	admin := UserFromCtx(c)
+	if admin == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	var body unlockReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	n, err := h.svc.Unlock(c.UserContext(), UnlockInput{
+		AdminID:   admin.ID,
+		Username:  body.Username,
+		TargetIP:  body.IP,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	if n < 0 {
+		return c.JSON(fiber.Map{"cleared": nil})
+	}
+	return c.JSON(fiber.Map{"cleared": n})
+}
+
+type startDeviceReq struct {
+	ClientName string `json:"client_name"`
+}
+
+// StartDevice — POST /api/auth/device. Opens a device grant for a
+// terminal client; the response follows RFC 8628.
+func (h *Handlers) StartDevice(c *fiber.Ctx) error {
+	var body startDeviceReq
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+		}
+	}
+	g, err := h.svc.StartDeviceAuthorization(c.UserContext(), StartDeviceInput{
+		ClientName: body.ClientName,
+		IP:         c.IP(),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	out := fiber.Map{
+		"device_code": g.DeviceCode,
+		"user_code":   g.UserCode,
+		"expires_in":  int(time.Until(g.ExpiresAt).Seconds()),
+		"interval":    int(g.Interval.Seconds()),
+	}
+	if g.VerificationURI != "" {
+		out["verification_uri"] = g.VerificationURI
+		out["verification_uri_complete"] = g.VerificationURI + "?code=" + url.QueryEscape(g.UserCode)
+	}
+	return c.JSON(out)
+}
+
+type pollDeviceReq struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// PollDevice — POST /api/auth/device/token. Answers with a session once
+// the user has approved, otherwise with the RFC 8628 error codes:
+// authorization_pending, slow_down, access_denied, expired_token.
+func (h *Handlers) PollDevice(c *fiber.Ctx) error {
+	var body pollDeviceReq
+	if err := c.BodyParser(&body); err != nil {
+		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", "invalid json body")
+	}
+	sess, err := h.svc.PollDevice(c.UserContext(), PollDeviceInput{
+		DeviceCode: body.DeviceCode,
+		IP:         c.IP(),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+	})
+	switch {
+	case err == nil:
+		return h.loggedIn(c, sess)
+	case errors.Is(err, errDevicePending):
+		return errorJSON(c, fiber.StatusBadRequest, "authorization_pending", "")
+	case errors.Is(err, errDeviceSlowDown):
+		return errorJSON(c, fiber.StatusBadRequest, "slow_down", "")
+	case errors.Is(err, errDeviceDenied):
+		return errorJSON(c, fiber.StatusBadRequest, "access_denied", "")
+	case errors.Is(err, domain.ErrTokenExpired):
+		return errorJSON(c, fiber.StatusBadRequest, "expired_token", "")
+	case errors.Is(err, domain.ErrTokenInvalid):
+		return errorJSON(c, fiber.StatusBadRequest, "invalid_grant", "")
+	}
+	return mapServiceErr(c, err)
+}
+
+type deviceDTO struct {
+	ClientName string    `json:"client_name"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// PendingDevice — GET /api/auth/device/:code. Shows the client behind a
+// user code so the user can check it before approving.
+func (h *Handlers) PendingDevice(c *fiber.Ctx) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	d, err := h.svc.PendingDevice(c.UserContext(), user.ID, c.Params("code"))
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.JSON(deviceDTO{
+		ClientName: d.ClientName,
+		IP:         d.IP,
+		UserAgent:  d.UserAgent,
+		CreatedAt:  d.CreatedAt.UTC(),
+		ExpiresAt:  d.ExpiresAt.UTC(),
+	})
+}
+
+// ApproveDevice — POST /api/auth/device/:code/approve.
+func (h *Handlers) ApproveDevice(c *fiber.Ctx) error { return h.decideDevice(c, true) }
+
+// DenyDevice — POST /api/auth/device/:code/deny.
+func (h *Handlers) DenyDevice(c *fiber.Ctx) error { return h.decideDevice(c, false) }
+
+func (h *Handlers) decideDevice(c *fiber.Ctx, approve bool) error {
+	user := UserFromCtx(c)
+	if user == nil {
+		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
+	}
+	err := h.svc.DecideDevice(c.UserContext(), DecideDeviceInput{
+		UserID:    user.ID,
+		UserCode:  c.Params("code"),
+		Approve:   approve,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	})
+	if err != nil {
+		return mapServiceErr(c, err)
+	}
+	return c.Status(fiber.StatusNoContent).Send(nil)
+}
+
 func mapServiceErr(c *fiber.Ctx, err error) error {
 	switch {
+	case errors.Is(err, errAPITokensUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "tokens_unavailable", "")
+	case errors.Is(err, errTwoFactorUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "2fa_unavailable", "")
+	case errors.Is(err, errWebAuthnUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "webauthn_unavailable", "")
+	case errors.Is(err, errOIDCUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "oidc_unavailable", "")
+	case errors.Is(err, errPasswordResetUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "password_reset_unavailable", "")
+	case errors.Is(err, errRateLimitsUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "rate_limits_unavailable", "")
+	case errors.Is(err, errLoginSecurityUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "security_events_unavailable", "")
+	case errors.Is(err, errSignedTokensUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "signed_tokens_unavailable", "")
+	case errors.Is(err, errDeviceAuthUnavailable):
+		return errorJSON(c, fiber.StatusServiceUnavailable, "device_auth_unavailable", "")
+	case errors.Is(err, errRegistrationClosed):
+		return errorJSON(c, fiber.StatusForbidden, "registration_closed",
+			"no account is linked to this identity; sign in and link it, or use an invite")
 	case errors.Is(err, domain.ErrValidation):
 		return errorJSON(c, fiber.StatusBadRequest, "validation_failed", err.Error())
 	case errors.Is(err, domain.ErrConsentRequired):
@@ -210,6 +1265,10 @@ func mapServiceErr(c *fiber.Ctx, err error) error {
 		return errorJSON(c, fiber.StatusUnauthorized, "unauthorized", "")
 	case errors.Is(err, domain.ErrTokenExpired):
 		return errorJSON(c, fiber.StatusUnauthorized, "token_expired", "")
+	case errors.Is(err, domain.ErrAccountSuspended):
+		return errorJSON(c, fiber.StatusForbidden, "account_suspended", "")
+	case errors.Is(err, domain.ErrForbidden):
+		return errorJSON(c, fiber.StatusForbidden, "forbidden", "")
 	case errors.Is(err, domain.ErrConflict):
 		return errorJSON(c, fiber.StatusConflict, "conflict", "")
 	case errors.Is(err, domain.ErrRateLimited):
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Rate limit administration ---------------------------------------------
+
+// Login, second-factor and reset-request attempts are throttled per
+// username (or user) and per IP; reset redemptions, device grants, OIDC
+// callbacks and passkey logins per IP, each flow in its own scope. By
+// default the buckets live in process
+// memory; SetLimiterStore moves them into a shared store with
+// progressive lockouts. Because a username bucket can be drained by
+// anyone who knows the name, admins can list lockouts and lift them.
+
+var errRateLimitsUnavailable = errors.New("auth: shared rate limits are not configured")
+
+// SetLimiterStore replaces the in-memory limiters with ones kept in
+// store.
+func (s *Service) SetLimiterStore(store LimiterStore) {
+	s.limits = store
+	s.setLimiters(store)
+}
+
+// setLimiters builds every limiter over store, or in memory when nil.
+func (s *Service) setLimiters(store LimiterStore) {
+	log := s.cfg.Logger
+	s.rlUser = NewLimiter(store, ScopeLoginUser, loginUserPolicy, log)
+	s.rlIP = NewLimiter(store, ScopeLoginIP, loginIPPolicy, log)
+	s.rlCode = NewLimiter(store, ScopeSecondFactor, codePolicy, log)
+	s.rlResetRequest = NewLimiter(store, ScopeResetRequest, resetRequestPolicy, log)
+	s.rlResetRedeem = NewLimiter(store, ScopeResetRedeem, resetRedeemPolicy, log)
+	s.rlDevice = NewLimiter(store, ScopeDeviceStart, deviceStartPolicy, log)
+	s.rlOIDC = NewLimiter(store, ScopeOIDCCallback, oidcPolicy, log)
+	s.rlPasskey = NewLimiter(store, ScopePasskeyLogin, passkeyLoginPolicy, log)
+}
+
+// ListLockouts returns the buckets currently locked out, in any scope.
+func (s *Service) ListLockouts(ctx context.Context) ([]domain.RateBucket, error) {
+	if s.limits == nil {
+		return nil, errRateLimitsUnavailable
+	}
+	out, err := s.limits.ListLockedBuckets(ctx, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("auth: list lockouts: %w", err)
+	}
+	return out, nil
+}
+
+// UnlockInput names what to unlock: an account by username, an IP
+// address, or both.
+type UnlockInput struct {
+	AdminID   uuid.UUID
+	Username  string
+	TargetIP  string
+	IP        string
+	UserAgent string
+}
+
+// Unlock clears the rate-limit buckets and lockouts of a username and
+// its account, and/or an IP address. With a shared store that covers
+// every scope (invite and federation accepts too); otherwise only this
+// replica's auth limiters. It returns the number of buckets cleared,
+// or -1 when the in-memory limiters cannot say.
+func (s *Service) Unlock(ctx context.Context, in UnlockInput) (int, error) {
+	admin, err := s.users.GetByID(ctx, in.AdminID)
+	if err != nil {
+		return 0, fmt.Errorf("auth: unlock: %w", err)
+	}
+	if !admin.IsAdmin {
+		return 0, fmt.Errorf("auth: unlock: %w", domain.ErrForbidden)
+	}
+	var keys []string
+	var target *uuid.UUID
+	if name := strings.TrimSpace(in.Username); name != "" {
+		username, err := canonicaliseUsername(name)
+		if err != nil {
+			return 0, err
+		}
+		keys = append(keys, username)
+		user, err := s.users.GetByUsername(ctx, username)
+		switch {
+		case err == nil:
+			target = &user.ID
+			keys = append(keys, user.ID.String(), "reset:"+user.ID.String())
+		case !errors.Is(err, domain.ErrNotFound):
+			return 0, fmt.Errorf("auth: unlock lookup: %w", err)
+		}
+	}
+	if ip := strings.TrimSpace(in.TargetIP); ip != "" {
+		keys = append(keys, ip)
+	}
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("auth: unlock: username or ip required: %w", domain.ErrValidation)
+	}
+
+	n := -1
+	if s.limits != nil {
+		if n, err = s.limits.DeleteBucketsByKey(ctx, keys...); err != nil {
+			return 0, fmt.Errorf("auth: unlock: %w", err)
+		}
+	} else {
+		limiters := []Limiter{s.rlUser, s.rlIP, s.rlCode, s.rlResetRequest, s.rlResetRedeem, s.rlDevice, s.rlOIDC, s.rlPasskey}
+		for _, k := range keys {
+			for _, l := range limiters {
+				l.Reset(k)
+			}
+		}
+	}
+	payload := map[string]any{"username": in.Username, "ip": in.TargetIP, "cleared": n}
+	if target != nil {
+		payload["target_user_id"] = target.String()
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &in.AdminID,
+		Action:    domain.ActionAdminUnlock,
+		Payload:   mustJSON(payload),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return n, nil
+}
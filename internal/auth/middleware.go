@@ -28,7 +28,8 @@ var queryTokenPathPrefixes = []string{
 	"/ws",
 }
 
-// Required returns a middleware that demands a valid session.
+// Required returns a middleware that demands a valid session, signed
+// access or personal access token.
 func Required(svc *Service) fiber.Handler {
 	if svc == nil {
 		panic("auth: Required called with nil Service")
@@ -38,15 +39,64 @@ func Required(svc *Service) fiber.Handler {
 		if token == "" {
 			return unauthorized(c)
 		}
-		sess, user, err := svc.Validate(c.UserContext(), token)
-		if err != nil {
+		if err := authenticate(c, svc, token); err != nil {
 			return mapValidateErr(c, err)
 		}
-		bind(c, sess, user)
+		if u := UserFromCtx(c); u != nil && svc.enrolmentRequired(*u) && !enrolmentPath(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "2fa_enrolment_required"})
+		}
+		return c.Next()
+	}
+}
+
+// AdminOnly rejects users without server-admin authority. Mount it
+// after Required. An access token's admin claim may predate a demotion
+// or suspension made on another replica, so for those requests the
+// account is read again.
+func AdminOnly(svc *Service) fiber.Handler {
+	if svc == nil {
+		panic("auth: AdminOnly called with nil Service")
+	}
+	return func(c *fiber.Ctx) error {
+		u := UserFromCtx(c)
+		if u == nil || !u.IsAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "admin_required"})
+		}
+		if !viaAccessToken(c) {
+			return c.Next()
+		}
+		fresh, err := svc.users.GetByID(c.UserContext(), u.ID)
+		switch {
+		case errors.Is(err, domain.ErrNotFound):
+			return unauthorized(c)
+		case err != nil:
+			return mapValidateErr(c, err)
+		case fresh.SuspendedAt != nil:
+			return mapValidateErr(c, domain.ErrAccountSuspended)
+		case !fresh.IsAdmin:
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "admin_required"})
+		}
 		return c.Next()
 	}
 }
 
+// enrolmentPath reports whether the request stays reachable for a user
+// the TwoFactorRequired policy confines to setting up 2FA.
+func enrolmentPath(c *fiber.Ctx) bool {
+	p := c.Path()
+	switch {
+	case p == "/api/users/me/2fa", strings.HasPrefix(p, "/api/users/me/2fa/"):
+		return true
+	case strings.HasPrefix(p, "/api/auth/webauthn/register/"):
+		return true
+	case p == "/api/users/me":
+		return c.Method() == fiber.MethodGet
+	case p == "/api/auth/logout":
+		return true
+	}
+	return false
+}
+
 // Optional attaches user/session if present, but does not error on miss.
 func Optional(svc *Service) fiber.Handler {
 	if svc == nil {
@@ -57,11 +107,7 @@ func Optional(svc *Service) fiber.Handler {
 		if token == "" {
 			return c.Next()
 		}
-		sess, user, err := svc.Validate(c.UserContext(), token)
-		if err != nil {
-			return c.Next()
-		}
-		bind(c, sess, user)
+		_ = authenticate(c, svc, token)
 		return c.Next()
 	}
 }
@@ -104,6 +150,34 @@ func isQueryTokenAllowed(path string) bool {
 	return false
 }
 
+// authenticate validates token as whichever credential its shape says it
+// is and binds the result to the request.
+func authenticate(c *fiber.Ctx, svc *Service, token string) error {
+	if IsAPIToken(token) {
+		tok, user, err := svc.ValidateAPIToken(c.UserContext(), token)
+		if err != nil {
+			return err
+		}
+		bindAPIToken(c, tok, user)
+		return nil
+	}
+	if IsAccessToken(token) {
+		sess, user, err := svc.ValidateAccessToken(token)
+		if err != nil {
+			return err
+		}
+		bind(c, sess, user)
+		c.Locals(ctxKeyAccess, true)
+		return nil
+	}
+	sess, user, err := svc.Validate(c.UserContext(), token)
+	if err != nil {
+		return err
+	}
+	bind(c, sess, user)
+	return nil
+}
+
 func bind(c *fiber.Ctx, sess domain.Session, user domain.User) {
 	sCopy := sess
 	uCopy := user
@@ -112,6 +186,19 @@ func bind(c *fiber.Ctx, sess domain.Session, user domain.User) {
 	// Also publish to capguard so capability handlers can read the user id
 	// without importing this package.
 	capguard.SetUserID(c, user.ID)
+	capguard.SetSessionID(c, sess.ID)
+}
+
+// bindAPIToken is bind for personal access tokens. No session is bound,
+// and the token's restrictions are published to capguard, which
+// intersects them with the user's role on every check.
+func bindAPIToken(c *fiber.Ctx, tok domain.APIToken, user domain.User) {
+	tCopy := tok
+	uCopy := user
+	c.Locals(ctxKeyAPIToken, &tCopy)
+	c.Locals(ctxKeyUser, &uCopy)
+	capguard.SetUserID(c, user.ID)
+	capguard.SetScope(c, capguard.Scope{VaultID: tok.VaultID, Capabilities: tok.Capabilities})
 }
 
 func mapValidateErr(c *fiber.Ctx, err error) error {
@@ -120,6 +207,8 @@ func mapValidateErr(c *fiber.Ctx, err error) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "token_expired"})
 	case errors.Is(err, domain.ErrTokenInvalid):
 		return unauthorized(c)
+	case errors.Is(err, domain.ErrAccountSuspended):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "account_suspended"})
 	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
 		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "timeout"})
 	default:
@@ -0,0 +1,483 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/oidc"
+)
+
+// ---- OpenID Connect --------------------------------------------------------
+
+// Sign-in through the company's OpenID provider: authorization code
+// flow with PKCE, the state/nonce/verifier kept server-side between
+// StartOIDC and CompleteOIDC. An account is found by the (issuer,
+// subject) it was linked to, never by matching email or username. An
+// unknown subject gets a new account only under PolicyOpen; otherwise
+// an existing user links the identity from a signed-in session first.
+// A link is completed by that same user on an authenticated route, so a
+// link URL handed to someone else cannot attach their identity to the
+// account that started it.
+// An account with a second factor enrolled still answers it after the
+// provider, like a password login, and anomalous logins are stepped up
+// the same way. Vault roles mapped from the provider's groups are
+// granted only once the login has issued a session.
+// Accounts created here have no password. Where a password confirms a
+// sensitive change they sign in at the provider again instead, through
+// a link-style round trip that yields a short-lived, single-use grant.
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// oidcNoPassword is stored as the password hash of accounts created
+	// through OIDC. It is not a bcrypt hash, so no password matches it.
+	oidcNoPassword = "!oidc"
+	// reauthTokenPrefix marks re-authentication grants, which stand in
+	// for the password of an oidcNoPassword account.
+	reauthTokenPrefix = "lumi_ra_"
+	oidcReauthTTL     = 5 * time.Minute
+)
+
+var (
+	errOIDCUnavailable    = errors.New("auth: oidc is not configured")
+	errRegistrationClosed = errors.New("auth: registration is closed")
+)
+
+// GroupRole grants membership of a vault, under the named role, to
+// users whose groups claim contains Group.
+type GroupRole struct {
+	Group   string
+	VaultID uuid.UUID
+	Role    string
+}
+
+// OIDCOptions tunes how provider claims map onto lumi.
+type OIDCOptions struct {
+	GroupsClaim string // default "groups"
+	GroupRoles  []GroupRole
+}
+
+// SetOIDC enables OpenID Connect sign-in against idp.
+func (s *Service) SetOIDC(idp IdentityProvider, store OIDCStore, opts OIDCOptions) {
+	if opts.GroupsClaim == "" {
+		opts.GroupsClaim = "groups"
+	}
+	s.idp, s.idents, s.oidcOpts = idp, store, opts
+}
+
+// SetVaultRoleGranter wires the membership writer used by GroupRoles.
+func (s *Service) SetVaultRoleGranter(g VaultRoleGranter) { s.granter = g }
+
+// OIDCEnabled reports whether OIDC sign-in is configured.
+func (s *Service) OIDCEnabled() bool { return s.idp != nil && s.idents != nil }
+
+// OIDCStartInput begins an authorization request. LinkUserID is set when
+// a signed-in user attaches an identity; Consent is what a new user
+// accepted before leaving for the provider.
+type OIDCStartInput struct {
+	LinkUserID *uuid.UUID
+	Consent    ConsentInput
+	IP         string
+}
+
+// OIDCCallbackInput is what the provider redirected back with.
+type OIDCCallbackInput struct {
+	Code      string
+	State     string
+	IP        string
+	UserAgent string
+}
+
+// StartOIDC returns the provider URL to send the browser to.
+func (s *Service) StartOIDC(ctx context.Context, in OIDCStartInput) (string, error) {
+	if !s.OIDCEnabled() {
+		return "", errOIDCUnavailable
+	}
+	// Starts and callbacks share the OIDC budget; each start stores a
+	// state row.
+	if !s.rlOIDC.Allow(in.IP) {
+		return "", fmt.Errorf("auth: oidc start throttled: %w", domain.ErrRateLimited)
+	}
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	err = s.idents.CreateState(ctx, domain.OIDCState{
+		StateHash:      HashAPIToken(state),
+		Nonce:          nonce,
+		CodeVerifier:   verifier,
+		LinkUserID:     in.LinkUserID,
+		TosVersion:     strings.TrimSpace(in.Consent.TosVersion),
+		PrivacyVersion: strings.TrimSpace(in.Consent.PrivacyVersion),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("auth: persist oidc state: %w", err)
+	}
+	return s.idp.AuthCodeURL(state, nonce, verifier), nil
+}
+
+// CompleteOIDC redeems the provider's answer and issues a session for
+// the linked or newly provisioned user, or a *SecondFactorRequired
+// challenge as Login does. Link states are refused here;
+// they complete through CompleteOIDCLink.
+func (s *Service) CompleteOIDC(ctx context.Context, in OIDCCallbackInput) (domain.Session, error) {
+	st, claims, err := s.redeemOIDC(ctx, in, nil)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	userID, err := s.resolveIdentity(ctx, st, claims, in)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	// Group roles are granted only once the login succeeds, here or
+	// when its challenge is answered.
+	groups := s.oidcGroups(claims)
+
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("auth: oidc user lookup: %w", err)
+	}
+	login := LoginInput{Username: user.Username, IP: in.IP, UserAgent: in.UserAgent}
+	if user.SuspendedAt != nil {
+		s.recordLoginFailure(ctx, &user.ID, login, "account_suspended")
+		return domain.Session{}, domain.ErrAccountSuspended
+	}
+	if user.TwoFactorEnabled && s.twoFA != nil {
+		return domain.Session{}, s.issueChallenge(ctx, user.ID, groups)
+	}
+	if err := s.requireStepUp(ctx, user, login, groups); err != nil {
+		return domain.Session{}, err
+	}
+
+	session, err := s.issueSession(ctx, userID, in.IP, in.UserAgent)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("auth: login issue session: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuthLogin,
+		Payload:   mustJSON(map[string]any{"method": "oidc", "issuer": claims.Issuer}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	s.grantGroupRoles(ctx, userID, groups, in.IP, in.UserAgent)
+	return session, nil
+}
+
+// CompleteOIDCLink redeems the provider's answer to a link started by
+// userID and attaches the identity to that account. No session is
+// issued: the caller is already signed in.
+func (s *Service) CompleteOIDCLink(ctx context.Context, userID uuid.UUID, in OIDCCallbackInput) error {
+	_, claims, err := s.redeemOIDC(ctx, in, &userID)
+	if err != nil {
+		return err
+	}
+	linked, err := s.idents.GetIdentity(ctx, claims.Issuer, claims.Subject)
+	switch {
+	case err == nil:
+		if linked.UserID != userID {
+			return fmt.Errorf("auth: identity linked to another account: %w", domain.ErrConflict)
+		}
+	case !errors.Is(err, domain.ErrNotFound):
+		return fmt.Errorf("auth: oidc identity lookup: %w", err)
+	default:
+		if err := s.linkIdentity(ctx, userID, claims); err != nil {
+			return err
+		}
+		s.recordAudit(ctx, domain.AuditEntry{
+			UserID:    &userID,
+			Action:    domain.ActionAuthOIDCLink,
+			Payload:   mustJSON(map[string]any{"issuer": claims.Issuer}),
+			IP:        nilIfEmpty(in.IP),
+			UserAgent: nilIfEmpty(in.UserAgent),
+		})
+	}
+	s.grantGroupRoles(ctx, userID, s.oidcGroups(claims), in.IP, in.UserAgent)
+	return nil
+}
+
+// ReauthenticateOIDC redeems the provider's answer to a round trip
+// started by userID like a link, and returns a grant that CheckPassword
+// accepts once, within oidcReauthTTL, in place of the password of an
+// account created through OIDC. The identity must already be linked to
+// userID.
+func (s *Service) ReauthenticateOIDC(ctx context.Context, userID uuid.UUID, in OIDCCallbackInput) (string, time.Time, error) {
+	_, claims, err := s.redeemOIDC(ctx, in, &userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	linked, err := s.idents.GetIdentity(ctx, claims.Issuer, claims.Subject)
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		return "", time.Time{}, fmt.Errorf("auth: oidc identity lookup: %w", err)
+	}
+	if err != nil || linked.UserID != userID {
+		s.recordLoginFailure(ctx, &userID, LoginInput{IP: in.IP, UserAgent: in.UserAgent}, "oidc_reauth_other_identity")
+		return "", time.Time{}, domain.ErrInvalidCredentials
+	}
+	raw, err := IssueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token := reauthTokenPrefix + raw
+	now := time.Now().UTC()
+	g := domain.ReauthGrant{
+		TokenHash: HashAPIToken(token),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(oidcReauthTTL),
+	}
+	if err := s.idents.CreateReauthGrant(ctx, g); err != nil {
+		return "", time.Time{}, fmt.Errorf("auth: persist reauth grant: %w", err)
+	}
+	return token, g.ExpiresAt, nil
+}
+
+// redeemOIDC takes the state and exchanges the code for verified
+// claims. linkUserID is the signed-in caller of a link completion, nil
+// for a login; a state started for anything else is ErrTokenInvalid.
+func (s *Service) redeemOIDC(ctx context.Context, in OIDCCallbackInput, linkUserID *uuid.UUID) (domain.OIDCState, oidc.Claims, error) {
+	if !s.OIDCEnabled() {
+		return domain.OIDCState{}, oidc.Claims{}, errOIDCUnavailable
+	}
+	login := LoginInput{IP: in.IP, UserAgent: in.UserAgent}
+	if !s.rlOIDC.Allow(in.IP) {
+		s.recordLoginFailure(ctx, nil, login, "rate_limited_ip")
+		return domain.OIDCState{}, oidc.Claims{}, fmt.Errorf("auth: ip throttled: %w", domain.ErrRateLimited)
+	}
+	st, err := s.idents.TakeState(ctx, HashAPIToken(strings.TrimSpace(in.State)))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.OIDCState{}, oidc.Claims{}, domain.ErrTokenInvalid
+		}
+		return domain.OIDCState{}, oidc.Claims{}, fmt.Errorf("auth: oidc state lookup: %w", err)
+	}
+	if !oidcStateFor(st, linkUserID) {
+		s.recordLoginFailure(ctx, linkUserID, login, "oidc_state_mismatch")
+		return domain.OIDCState{}, oidc.Claims{}, domain.ErrTokenInvalid
+	}
+	if !st.ExpiresAt.After(time.Now()) {
+		return domain.OIDCState{}, oidc.Claims{}, domain.ErrTokenExpired
+	}
+	raw, err := s.idp.Exchange(ctx, in.Code, st.CodeVerifier)
+	if err != nil {
+		s.cfg.Logger.Warn().Err(err).Msg("auth: oidc code exchange failed")
+		s.recordLoginFailure(ctx, st.LinkUserID, login, "oidc_exchange_failed")
+		return domain.OIDCState{}, oidc.Claims{}, domain.ErrInvalidCredentials
+	}
+	claims, err := s.idp.VerifyIDToken(ctx, raw, st.Nonce)
+	if err != nil {
+		s.cfg.Logger.Warn().Err(err).Msg("auth: oidc id token rejected")
+		s.recordLoginFailure(ctx, st.LinkUserID, login, "oidc_token_invalid")
+		return domain.OIDCState{}, oidc.Claims{}, domain.ErrInvalidCredentials
+	}
+	return st, claims, nil
+}
+
+// oidcStateFor reports whether st may be redeemed by linkUserID: a
+// login state without a caller, or a link state by the user who
+// started it.
+func oidcStateFor(st domain.OIDCState, linkUserID *uuid.UUID) bool {
+	if st.LinkUserID == nil || linkUserID == nil {
+		return st.LinkUserID == nil && linkUserID == nil
+	}
+	return *st.LinkUserID == *linkUserID
+}
+
+// resolveIdentity maps the provider subject to a user: the linked one
+// or a freshly provisioned account.
+func (s *Service) resolveIdentity(ctx context.Context, st domain.OIDCState, claims oidc.Claims, in OIDCCallbackInput) (uuid.UUID, error) {
+	linked, err := s.idents.GetIdentity(ctx, claims.Issuer, claims.Subject)
+	switch {
+	case err == nil:
+		return linked.UserID, nil
+	case !errors.Is(err, domain.ErrNotFound):
+		return uuid.Nil, fmt.Errorf("auth: oidc identity lookup: %w", err)
+	}
+
+	if s.RegistrationPolicy(ctx) != PolicyOpen {
+		s.recordLoginFailure(ctx, nil, LoginInput{IP: in.IP, UserAgent: in.UserAgent}, "oidc_unknown_subject")
+		return uuid.Nil, errRegistrationClosed
+	}
+	consent := ConsentInput{TosVersion: st.TosVersion, PrivacyVersion: st.PrivacyVersion}
+	if s.cfg.RequireConsent {
+		if err := s.checkConsent(consent); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	return s.provisionOIDCUser(ctx, claims, consent, in)
+}
+
+func (s *Service) linkIdentity(ctx context.Context, userID uuid.UUID, claims oidc.Claims) error {
+	err := s.idents.CreateIdentity(ctx, domain.UserIdentity{
+		Issuer:    claims.Issuer,
+		Subject:   claims.Subject,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("auth: link oidc identity: %w", err)
+	}
+	return nil
+}
+
+// provisionOIDCUser creates an account for a first-time subject. The
+// username comes from the claims, suffixed when already taken. The
+// account, its identity link and its consent are written together, so
+// a failure leaves no account that nobody can sign in to.
+func (s *Service) provisionOIDCUser(ctx context.Context, claims oidc.Claims, consent ConsentInput, in OIDCCallbackInput) (uuid.UUID, error) {
+	base := usernameFromClaims(claims)
+	now := time.Now().UTC()
+	var (
+		user     domain.User
+		recorded domain.Consent
+		err      error
+	)
+	for attempt := range 4 {
+		name := base
+		if attempt > 0 {
+			name = fitUsername(base, "-"+uuid.NewString()[:4])
+		}
+		user = domain.User{
+			ID:           uuid.New(),
+			Username:     name,
+			PasswordHash: oidcNoPassword,
+			DisplayName:  strings.TrimSpace(claims.Name),
+			CreatedAt:    now,
+		}
+		recorded = s.newConsent(user.ID, consent, in.IP, in.UserAgent)
+		err = s.idents.ProvisionUser(ctx, user, domain.UserIdentity{
+			Issuer:    claims.Issuer,
+			Subject:   claims.Subject,
+			UserID:    user.ID,
+			CreatedAt: now,
+		}, recorded)
+		if !errors.Is(err, domain.ErrConflict) {
+			break
+		}
+		// A concurrent first login may have provisioned the subject.
+		if linked, lerr := s.idents.GetIdentity(ctx, claims.Issuer, claims.Subject); lerr == nil {
+			return linked.UserID, nil
+		}
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("auth: create oidc user: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &user.ID,
+		Action:    domain.ActionAuthRegister,
+		Payload:   mustJSON(map[string]any{"username": user.Username, "via": "oidc", "issuer": claims.Issuer}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &user.ID,
+		Action:    domain.ActionConsentAccept,
+		Payload:   mustJSON(map[string]any{"tos_version": recorded.TosVersion, "privacy_version": recorded.PrivacyVersion}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return user.ID, nil
+}
+
+// oidcGroups returns the groups in claims that some GroupRole maps.
+func (s *Service) oidcGroups(claims oidc.Claims) []string {
+	var out []string
+	for _, g := range claims.Strings(s.oidcOpts.GroupsClaim) {
+		mapped := slices.ContainsFunc(s.oidcOpts.GroupRoles, func(gr GroupRole) bool { return gr.Group == g })
+		if mapped && !slices.Contains(out, g) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// grantGroupRoles adds the user to every vault one of groups maps to.
+// Existing memberships are left alone, so a role changed by a vault
+// admin sticks; failures are logged, not fatal to the login.
+func (s *Service) grantGroupRoles(ctx context.Context, userID uuid.UUID, groups []string, ip, ua string) {
+	if s.granter == nil || len(groups) == 0 {
+		return
+	}
+	for _, gr := range s.oidcOpts.GroupRoles {
+		if !slices.Contains(groups, gr.Group) {
+			continue
+		}
+		added, err := s.granter.GrantVaultRole(ctx, gr.VaultID, userID, gr.Role)
+		if err != nil {
+			s.cfg.Logger.Warn().Err(err).Str("group", gr.Group).Stringer("vault", gr.VaultID).
+				Msg("auth: oidc group role grant failed")
+			continue
+		}
+		if added {
+			vaultID := gr.VaultID
+			s.recordAudit(ctx, domain.AuditEntry{
+				UserID:    &userID,
+				VaultID:   &vaultID,
+				Action:    domain.ActionMemberAdd,
+				Payload:   mustJSON(map[string]any{"role": gr.Role, "via": "oidc", "group": gr.Group}),
+				IP:        nilIfEmpty(ip),
+				UserAgent: nilIfEmpty(ua),
+			})
+		}
+	}
+}
+
+// usernameFromClaims derives a valid username from preferred_username,
+// the email's local part or, failing both, the subject.
+func usernameFromClaims(c oidc.Claims) string {
+	local, _, _ := strings.Cut(c.Email, "@")
+	for _, candidate := range []string{c.PreferredUsername, local} {
+		if u := sanitiseUsername(candidate); u != "" {
+			return u
+		}
+	}
+	if u := sanitiseUsername("user-" + c.Subject); u != "" {
+		return u
+	}
+	return "user"
+}
+
+// sanitiseUsername maps raw onto the username alphabet, or "" when too
+// little of it survives.
+func sanitiseUsername(raw string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(raw)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	u := fitUsername(b.String(), "")
+	if _, err := canonicaliseUsername(u); err != nil {
+		return ""
+	}
+	return u
+}
+
+// fitUsername appends suffix, truncating base so the result stays
+// within usernameMax and does not end in a separator.
+func fitUsername(base, suffix string) string {
+	if len(base)+len(suffix) > usernameMax {
+		base = base[:usernameMax-len(suffix)]
+	}
+	return strings.Trim(base, "-_") + suffix
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/oidc"
+)
+
+func TestUsernameFromClaims(t *testing.T) {
+	cases := []struct {
+		claims oidc.Claims
+		want   string
+	}{
+		{oidc.Claims{PreferredUsername: "Ada.Lovelace"}, "ada-lovelace"},
+		{oidc.Claims{PreferredUsername: "x", Email: "grace@example.com"}, "grace"},
+		{oidc.Claims{Email: "é@example.com", Subject: "12345"}, "user-12345"},
+		{oidc.Claims{PreferredUsername: "-_-", Subject: "!"}, "user"},
+		{oidc.Claims{PreferredUsername: "a-very-long-preferred-username-that-overflows"}, "a-very-long-preferred-username-t"},
+	}
+	for _, tc := range cases {
+		got := usernameFromClaims(tc.claims)
+		if got != tc.want {
+			t.Errorf("usernameFromClaims(%+v) = %q, want %q", tc.claims, got, tc.want)
+		}
+		if _, err := canonicaliseUsername(got); err != nil {
+			t.Errorf("%q is not a valid username: %v", got, err)
+		}
+	}
+	if got := fitUsername("a-very-long-preferred-username-that-overflows", "-1a2b"); len(got) > usernameMax {
+		t.Errorf("fitUsername overflowed: %q", got)
+	}
+}
+
+func TestOIDCStateFor(t *testing.T) {
+	owner, other := uuid.New(), uuid.New()
+	login := domain.OIDCState{}
+	link := domain.OIDCState{LinkUserID: &owner}
+	cases := []struct {
+		name   string
+		st     domain.OIDCState
+		caller *uuid.UUID
+		want   bool
+	}{
+		{"login state at the login callback", login, nil, true},
+		{"login state at the link callback", login, &owner, false},
+		{"link state at the login callback", link, nil, false},
+		{"link state by its owner", link, &owner, true},
+		{"link state by someone else", link, &other, false},
+	}
+	for _, c := range cases {
+		if got := oidcStateFor(c.st, c.caller); got != c.want {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestOIDCGroupsKeepsMappedGroups(t *testing.T) {
+	s := &Service{oidcOpts: OIDCOptions{GroupsClaim: "groups", GroupRoles: []GroupRole{
+		{Group: "eng", VaultID: uuid.New(), Role: "editor"},
+		{Group: "eng", VaultID: uuid.New(), Role: "viewer"},
+		{Group: "ops", VaultID: uuid.New(), Role: "admin"},
+	}}}
+	claims := oidc.Claims{Raw: map[string]any{"groups": []any{"sales", "eng", "ops", "eng"}}}
+	got := s.oidcGroups(claims)
+	if len(got) != 2 || got[0] != "eng" || got[1] != "ops" {
+		t.Fatalf("oidcGroups = %v, want [eng ops]", got)
+	}
+	if got := s.oidcGroups(oidc.Claims{}); got != nil {
+		t.Fatalf("oidcGroups without the claim = %v", got)
+	}
+}
+
+// grantStore keeps re-authentication grants; the other OIDCStore
+// methods are not used by confirmUser.
+type grantStore struct {
+	OIDCStore
+	grants map[string]domain.ReauthGrant
+}
+
+func (g *grantStore) CreateReauthGrant(_ context.Context, gr domain.ReauthGrant) error {
+	g.grants[gr.TokenHash] = gr
+	return nil
+}
+
+func (g *grantStore) TakeReauthGrant(_ context.Context, hash string) (domain.ReauthGrant, error) {
+	gr, ok := g.grants[hash]
+	if !ok {
+		return domain.ReauthGrant{}, domain.ErrNotFound
+	}
+	delete(g.grants, hash)
+	return gr, nil
+}
+
+func TestConfirmUserSpendsReauthGrant(t *testing.T) {
+	ctx := context.Background()
+	store := &grantStore{grants: map[string]domain.ReauthGrant{}}
+	s := &Service{idents: store}
+	user := domain.User{ID: uuid.New(), PasswordHash: oidcNoPassword}
+	grant := func(userID uuid.UUID, ttl time.Duration) string {
+		token := reauthTokenPrefix + uuid.NewString()
+		_ = store.CreateReauthGrant(ctx, domain.ReauthGrant{
+			TokenHash: HashAPIToken(token), UserID: userID, ExpiresAt: time.Now().Add(ttl),
+		})
+		return token
+	}
+
+	token := grant(user.ID, time.Minute)
+	if err := s.confirmUser(ctx, user, token); err != nil {
+		t.Fatalf("fresh grant refused: %v", err)
+	}
+	if err := s.confirmUser(ctx, user, token); !errors.Is(err, domain.ErrInvalidCredentials) {
+		t.Fatalf("spent grant: %v, want ErrInvalidCredentials", err)
+	}
+	for name, tok := range map[string]string{
+		"expired":          grant(user.ID, -time.Second),
+		"another user's":   grant(uuid.New(), time.Minute),
+		"unknown":          reauthTokenPrefix + "nope",
+		"a password guess": "!oidc",
+	} {
+		if err := s.confirmUser(ctx, user, tok); !errors.Is(err, domain.ErrInvalidCredentials) {
+			t.Errorf("%s grant: %v, want ErrInvalidCredentials", name, err)
+		}
+	}
+
+	// An account with a password never takes a grant in its place.
+	withPassword := domain.User{ID: user.ID, PasswordHash: "$2a$10$invalid"}
+	if err := s.confirmUser(ctx, withPassword, grant(user.ID, time.Minute)); !errors.Is(err, domain.ErrInvalidCredentials) {
+		t.Errorf("grant for a password account: %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -1,10 +1,17 @@
 package auth
 
 import (
-	"errors"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
 	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"unicode"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/ViniZap4/lumi-server/internal/domain"
@@ -12,42 +19,156 @@ import (
 
 const (
 	MinPasswordLength = 8
-	maxPasswordBytes  = 72
+	// maxPasswordBytes bounds the work one login attempt can ask for;
+	// argon2id itself has no length limit.
+	maxPasswordBytes = 1024
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+	argon2Prefix  = "$argon2id$"
 )
 
-// HashPassword produces a bcrypt hash. Refuses inputs > 72 bytes since
-// bcrypt silently truncates them.
-func HashPassword(plain string, cost int) (string, error) {
-	if cost < bcrypt.MinCost {
-		cost = bcrypt.DefaultCost
+// Argon2Params are the argon2id cost parameters. They are encoded into
+// every hash, so raising them only affects new hashes; older ones are
+// upgraded on the owner's next login.
+type Argon2Params struct {
+	Memory  uint32 // KiB
+	Time    uint32 // passes over memory
+	Threads uint8
+}
+
+// DefaultArgon2 follows the second recommended option of RFC 9106
+// (64 MiB, three passes), with two lanes.
+var DefaultArgon2 = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2}
+
+// MaxConcurrentHashes bounds how many password hashes are computed at
+// once, and so the memory argon2id can take (Memory KiB each). Callers
+// past the bound are turned away with errHashingBusy rather than queued.
+// Set it before serving requests.
+var MaxConcurrentHashes = max(4, runtime.NumCPU())
+
+var hashesInFlight atomic.Int64
+
+var errHashingBusy = fmt.Errorf("auth: password hashing busy: %w", domain.ErrRateLimited)
+
+// acquireHashSlot reserves one of the MaxConcurrentHashes slots; release
+// it with releaseHashSlot.
+func acquireHashSlot() bool {
+	if hashesInFlight.Add(1) > int64(MaxConcurrentHashes) {
+		hashesInFlight.Add(-1)
+		return false
 	}
+	return true
+}
+
+func releaseHashSlot() { hashesInFlight.Add(-1) }
+
+// HashPassword produces an argon2id hash in the PHC string format:
+// $argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>.
+func HashPassword(plain string, p Argon2Params) (string, error) {
 	if len(plain) > maxPasswordBytes {
 		return "", fmt.Errorf("auth: password exceeds %d bytes: %w", maxPasswordBytes, domain.ErrValidation)
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(plain), cost)
-	if err != nil {
+	if !acquireHashSlot() {
+		return "", errHashingBusy
+	}
+	defer releaseHashSlot()
+	if p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		p = DefaultArgon2
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
 		return "", fmt.Errorf("auth: hash password: %w", err)
 	}
-	return string(hash), nil
+	key := argon2.IDKey([]byte(plain), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+	return encodeArgon2(p, salt, key), nil
 }
 
-// CheckPassword verifies plain against a bcrypt hash. Any mismatch returns
-// domain.ErrInvalidCredentials so callers cannot distinguish wrong-password
-// from malformed-hash via the error.
+// CheckPassword verifies plain against an argon2id or a legacy bcrypt
+// hash. Any mismatch returns domain.ErrInvalidCredentials so callers
+// cannot distinguish wrong-password from malformed-hash via the error;
+// errHashingBusy (domain.ErrRateLimited) means no check was made.
 func CheckPassword(hash, plain string) error {
-	if hash == "" || plain == "" {
+	if hash == "" || plain == "" || len(plain) > maxPasswordBytes {
 		return domain.ErrInvalidCredentials
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+	if !acquireHashSlot() {
+		return errHashingBusy
+	}
+	defer releaseHashSlot()
+	if strings.HasPrefix(hash, argon2Prefix) {
+		p, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return domain.ErrInvalidCredentials
+		}
+		got := argon2.IDKey([]byte(plain), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(got, key) != 1 {
 			return domain.ErrInvalidCredentials
 		}
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
 		return domain.ErrInvalidCredentials
 	}
 	return nil
 }
 
-// ValidatePassword runs structural checks. Does not enforce a dictionary.
+// needsRehash reports whether hash was made with another algorithm or
+// other parameters than p.
+func needsRehash(hash string, p Argon2Params) bool {
+	got, _, key, err := decodeArgon2(hash)
+	return err != nil || got != p || len(key) != argon2KeyLen
+}
+
+func encodeArgon2(p Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, fmt.Errorf("auth: not an argon2id hash")
+	}
+	if parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return p, nil, nil, fmt.Errorf("auth: unsupported argon2 version %q", parts[2])
+	}
+	for _, kv := range strings.Split(parts[3], ",") {
+		name, val, _ := strings.Cut(kv, "=")
+		n, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return p, nil, nil, fmt.Errorf("auth: argon2 parameter %q: %w", kv, err)
+		}
+		switch name {
+		case "m":
+			p.Memory = uint32(n)
+		case "t":
+			p.Time = uint32(n)
+		case "p":
+			if n > 255 {
+				return p, nil, nil, fmt.Errorf("auth: argon2 parameter %q out of range", kv)
+			}
+			p.Threads = uint8(n)
+		}
+	}
+	if p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return p, nil, nil, fmt.Errorf("auth: argon2 parameters incomplete")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, fmt.Errorf("auth: argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < 16 {
+		return p, nil, nil, fmt.Errorf("auth: argon2 key malformed")
+	}
+	return p, salt, key, nil
+}
+
+// ValidatePassword runs structural checks. The configured length and
+// breached-password list are applied by Service.ValidatePassword.
 func ValidatePassword(plain string) error {
 	if len(plain) > maxPasswordBytes {
 		return fmt.Errorf("auth: password exceeds %d bytes: %w", maxPasswordBytes, domain.ErrValidation)
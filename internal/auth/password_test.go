@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// testArgon2 keeps the tests fast; production uses DefaultArgon2.
+var testArgon2 = Argon2Params{Memory: 1024, Time: 1, Threads: 1}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	hash, err := HashPassword("correct horse battery", testArgon2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash = %q, want an encoded argon2id hash", hash)
+	}
+	if err := CheckPassword(hash, "correct horse battery"); err != nil {
+		t.Fatalf("right password: %v", err)
+	}
+	if err := CheckPassword(hash, "correct horse batterY"); !errors.Is(err, domain.ErrInvalidCredentials) {
+		t.Fatalf("wrong password: %v, want ErrInvalidCredentials", err)
+	}
+	again, _ := HashPassword("correct horse battery", testArgon2)
+	if again == hash {
+		t.Fatal("two hashes of one password share a salt")
+	}
+}
+
+func TestHashPasswordAcceptsLongPasswords(t *testing.T) {
+	long := strings.Repeat("a1", 100)
+	hash, err := HashPassword(long, testArgon2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CheckPassword(hash, long[:72]) == nil {
+		t.Fatal("password truncated to 72 bytes")
+	}
+	if _, err := HashPassword(strings.Repeat("a", maxPasswordBytes+1), testArgon2); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("oversized password: %v, want ErrValidation", err)
+	}
+}
+
+func TestHashingIsBounded(t *testing.T) {
+	hash, err := HashPassword("correct horse battery", testArgon2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int) { MaxConcurrentHashes = n }(MaxConcurrentHashes)
+	MaxConcurrentHashes = 1
+	if !acquireHashSlot() {
+		t.Fatal("no free slot")
+	}
+	if err := CheckPassword(hash, "correct horse battery"); !errors.Is(err, domain.ErrRateLimited) {
+		t.Fatalf("check with every slot taken: %v, want ErrRateLimited", err)
+	}
+	if _, err := HashPassword("correct horse battery", testArgon2); !errors.Is(err, domain.ErrRateLimited) {
+		t.Fatalf("hash with every slot taken: %v, want ErrRateLimited", err)
+	}
+	releaseHashSlot()
+	if err := CheckPassword(hash, "correct horse battery"); err != nil {
+		t.Fatalf("check after the slot was released: %v", err)
+	}
+}
+
+func TestCheckPasswordLegacyBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("old but gold 1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckPassword(string(legacy), "old but gold 1"); err != nil {
+		t.Fatalf("bcrypt hash no longer verifies: %v", err)
+	}
+	if !needsRehash(string(legacy), testArgon2) {
+		t.Fatal("bcrypt hash not flagged for rehash")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, _ := HashPassword("correct horse battery", testArgon2)
+	if needsRehash(hash, testArgon2) {
+		t.Fatal("current hash flagged for rehash")
+	}
+	stronger := testArgon2
+	stronger.Time++
+	if !needsRehash(hash, stronger) {
+		t.Fatal("hash with outdated parameters not flagged")
+	}
+	for _, bad := range []string{"", oidcNoPassword, "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		if !needsRehash(bad, testArgon2) {
+			t.Errorf("needsRehash(%q) = false", bad)
+		}
+		if CheckPassword(bad, "anything at all") == nil {
+			t.Errorf("CheckPassword(%q) accepted a password", bad)
+		}
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	const list = `# comment
+Summer2024!
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493
+
+`
+	l, err := LoadBreachedList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", l.Len())
+	}
+	for _, pw := range []string{"summer2024!", "SUMMER2024!", "password", "Password"} {
+		if !l.Contains(pw) {
+			t.Errorf("%q not found", pw)
+		}
+	}
+	if l.Contains("correct horse battery") {
+		t.Error("unlisted password found")
+	}
+	var none *BreachedList
+	if none.Contains("password") {
+		t.Error("nil list found a password")
+	}
+}
+
+func TestServiceValidatePassword(t *testing.T) {
+	breached, _ := LoadBreachedList(strings.NewReader("Tr0ub4dor&3\n"))
+	s := &Service{cfg: Config{PasswordMinLength: 12, BreachedPasswords: breached}.withDefaults()}
+	cases := map[string]bool{
+		"correct horse battery": true,
+		"short1pass":            false, // under the configured minimum
+		"12345678901234":        false, // structural
+		"Password1234":          false, // built-in list
+		"tr0ub4dor&3xyz":        true,
+		"TR0UB4DOR&3":           false, // operator list, and short
+	}
+	for pw, ok := range cases {
+		err := s.ValidatePassword(pw)
+		if ok && err != nil {
+			t.Errorf("%q rejected: %v", pw, err)
+		}
+		if !ok && !errors.Is(err, domain.ErrValidation) {
+			t.Errorf("%q: %v, want ErrValidation", pw, err)
+		}
+	}
+	s.cfg.PasswordMinLength = MinPasswordLength
+	if err := s.ValidatePassword("Tr0ub4dor&3"); !errors.Is(err, domain.ErrValidation) {
+		t.Errorf("operator-listed password accepted: %v", err)
+	}
+}
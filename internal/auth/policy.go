@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Password policy -------------------------------------------------------
+
+// A new password (register, change, reset, invite signup) must pass the
+// structural checks of ValidatePassword, be at least PasswordMinLength
+// characters long, and not appear on a breached password list: a small
+// built-in one plus the operator's file, if any.
+
+// BreachedList is a set of known-breached passwords, kept as sorted
+// SHA-1 digests so a trimmed Have I Been Pwned dump fits in memory.
+type BreachedList struct {
+	sums [][sha1.Size]byte
+}
+
+// OpenBreachedList loads a list from path. See LoadBreachedList.
+func OpenBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("breached list: %w", err)
+	}
+	defer f.Close()
+	return LoadBreachedList(f)
+}
+
+// LoadBreachedList reads one entry per line: either a SHA-1 hex digest,
+// optionally followed by ":count" (the Have I Been Pwned format), or a
+// plain password. Blank lines and lines starting with '#' are skipped.
+// Plain entries match case-insensitively.
+func LoadBreachedList(r io.Reader) (*BreachedList, error) {
+	l := &BreachedList{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sum, ok := parseSHA1Entry(line); ok {
+			l.sums = append(l.sums, sum)
+			continue
+		}
+		l.sums = append(l.sums, sha1.Sum([]byte(strings.ToLower(line))))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("breached list: %w", err)
+	}
+	slices.SortFunc(l.sums, func(a, b [sha1.Size]byte) int { return bytes.Compare(a[:], b[:]) })
+	l.sums = slices.Compact(l.sums)
+	return l, nil
+}
+
+func parseSHA1Entry(line string) ([sha1.Size]byte, bool) {
+	var sum [sha1.Size]byte
+	digest, _, _ := strings.Cut(line, ":")
+	if len(digest) != 2*sha1.Size {
+		return sum, false
+	}
+	if _, err := hex.Decode(sum[:], []byte(digest)); err != nil {
+		return sum, false
+	}
+	return sum, true
+}
+
+// Len returns the number of distinct entries.
+func (l *BreachedList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.sums)
+}
+
+// Contains reports whether plain, or its lower-cased form, is listed.
+func (l *BreachedList) Contains(plain string) bool {
+	if l.Len() == 0 {
+		return false
+	}
+	return l.has(sha1.Sum([]byte(plain))) || l.has(sha1.Sum([]byte(strings.ToLower(plain))))
+}
+
+func (l *BreachedList) has(sum [sha1.Size]byte) bool {
+	_, ok := slices.BinarySearchFunc(l.sums, sum, func(a, b [sha1.Size]byte) int { return bytes.Compare(a[:], b[:]) })
+	return ok
+}
+
+// commonPasswords are frequent entries of public breach corpora that
+// would otherwise pass the structural checks.
+var commonPasswords = mustLoadBreached(`
+password1
+password12
+password123
+password1234
+passw0rd
+p@ssw0rd
+p@ssword1
+qwerty123
+qwerty1234
+qwertyuiop
+1q2w3e4r
+1q2w3e4r5t
+1qaz2wsx
+zaq12wsx
+abc12345
+abcd1234
+iloveyou1
+iloveyou2
+welcome1
+welcome123
+letmein1
+monkey123
+dragon123
+football1
+baseball1
+sunshine1
+princess1
+superman1
+trustno1!
+asdf1234
+aa123456
+a1234567
+q1w2e3r4
+changeme1
+admin123
+administrator
+test1234
+lumi1234
+`)
+
+func mustLoadBreached(s string) *BreachedList {
+	l, err := LoadBreachedList(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// ValidatePassword applies the server's password policy to a new
+// password. Invites call it through their AuthHasher.
+func (s *Service) ValidatePassword(plain string) error {
+	if err := ValidatePassword(plain); err != nil {
+		return err
+	}
+	if n := s.cfg.PasswordMinLength; utf8.RuneCountInString(plain) < n {
+		return fmt.Errorf("auth: password shorter than %d characters: %w", n, domain.ErrValidation)
+	}
+	if commonPasswords.Contains(plain) || s.cfg.BreachedPasswords.Contains(plain) {
+		return fmt.Errorf("auth: password appears in a breached password list: %w", domain.ErrValidation)
+	}
+	return nil
+}
@@ -1,13 +1,26 @@
 package auth
 
 import (
+	"context"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
 )
 
+// Limiter throttles attempts per key. *RateLimiter keeps its buckets in
+// process memory; *StoreLimiter shares them through a LimiterStore.
+type Limiter interface {
+	Allow(key string) bool
+	Reset(key string)
+}
+
 // RateLimiter is a per-process in-memory token bucket. Acceptable per Pillar
-// 4 with sticky sessions; horizontal scale beyond a few replicas needs Redis.
+// 4 with sticky sessions; with several replicas, or to survive restarts,
+// use a StoreLimiter.
 type RateLimiter struct {
 	capacity     float64
 	refillPerSec float64
@@ -95,3 +108,185 @@ func (r *RateLimiter) bucketFor(key string, now time.Time) *bucket {
 	}
 	return fresh
 }
+
+// ---- Shared limits ---------------------------------------------------------
+
+// A StoreLimiter keeps its buckets in a LimiterStore (Postgres), so
+// every replica counts the same attempts and a restart or deploy resets
+// nothing. Once a bucket runs dry its key is locked out, for longer each
+// time it happens again before the bucket goes idle.
+
+// Limiter scopes: which count a stored bucket belongs to.
+const (
+	ScopeLoginUser        = "login_user"
+	ScopeLoginIP          = "login_ip"
+	ScopeSecondFactor     = "second_factor"
+	ScopeResetRequest     = "reset_request"
+	ScopeResetRedeem      = "reset_redeem"
+	ScopeDeviceStart      = "device_start"
+	ScopeOIDCCallback     = "oidc_callback"
+	ScopePasskeyLogin     = "passkey_login"
+	ScopeInviteAccept     = "invite_accept"
+	ScopeFederationAccept = "federation_accept"
+)
+
+// LimitPolicy shapes a limiter: Capacity attempts, refilled over Window,
+// with the bucket forgotten after IdleTTL unused. When it runs dry the
+// key is locked out for Lockout, doubling with every further lockout up
+// to MaxLockout; a Reset or going idle clears the count. A zero Lockout
+// means no lockouts, and the in-memory RateLimiter ignores both.
+type LimitPolicy struct {
+	Capacity   int
+	Window     time.Duration
+	IdleTTL    time.Duration
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+var (
+	loginUserPolicy = LimitPolicy{Capacity: 5, Window: time.Minute, IdleTTL: 30 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+	loginIPPolicy   = LimitPolicy{Capacity: 30, Window: time.Minute, IdleTTL: 30 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+	codePolicy      = LimitPolicy{Capacity: 5, Window: 15 * time.Minute, IdleTTL: time.Hour, Lockout: 15 * time.Minute, MaxLockout: 4 * time.Hour}
+
+	// Per-IP budgets of the other ways in, each in its own scope so
+	// draining one leaves password logins alone.
+	resetRequestPolicy = LimitPolicy{Capacity: 10, Window: time.Hour, IdleTTL: 2 * time.Hour, Lockout: 15 * time.Minute, MaxLockout: 4 * time.Hour}
+	resetRedeemPolicy  = LimitPolicy{Capacity: 10, Window: 15 * time.Minute, IdleTTL: time.Hour, Lockout: 15 * time.Minute, MaxLockout: 4 * time.Hour}
+	deviceStartPolicy  = LimitPolicy{Capacity: 10, Window: time.Minute, IdleTTL: 30 * time.Minute, Lockout: 5 * time.Minute, MaxLockout: time.Hour}
+	oidcPolicy         = LimitPolicy{Capacity: 30, Window: time.Minute, IdleTTL: 30 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+	passkeyLoginPolicy = LimitPolicy{Capacity: 30, Window: time.Minute, IdleTTL: 30 * time.Minute, Lockout: time.Minute, MaxLockout: time.Hour}
+)
+
+// LimiterStore persists rate-limit buckets. UpdateBucket hands fn the
+// bucket for (scope, key), zero-valued when there is none, saves what fn
+// leaves in it and returns fn's verdict, serialised against concurrent
+// updates of the same bucket.
+type LimiterStore interface {
+	UpdateBucket(ctx context.Context, scope, key string, fn func(b *domain.RateBucket) bool) (bool, error)
+	DeleteBucket(ctx context.Context, scope, key string) error
+	DeleteBucketsByKey(ctx context.Context, keys ...string) (int, error)
+	ListLockedBuckets(ctx context.Context, now time.Time) ([]domain.RateBucket, error)
+	PurgeBuckets(ctx context.Context, before time.Time) (int64, error)
+}
+
+// limiterTimeout bounds a StoreLimiter's round trip; Allow has no
+// context of its own.
+const limiterTimeout = 3 * time.Second
+
+// StoreLimiter is a Limiter whose buckets live in a LimiterStore.
+type StoreLimiter struct {
+	store  LimiterStore
+	scope  string
+	policy LimitPolicy
+	log    zerolog.Logger
+	clock  func() time.Time
+}
+
+func NewStoreLimiter(store LimiterStore, scope string, p LimitPolicy, log zerolog.Logger) *StoreLimiter {
+	if p.Capacity < 1 {
+		p.Capacity = 1
+	}
+	if p.Window <= 0 {
+		p.Window = time.Minute
+	}
+	if p.IdleTTL <= 0 {
+		p.IdleTTL = 10 * time.Minute
+	}
+	if p.MaxLockout < p.Lockout {
+		p.MaxLockout = p.Lockout
+	}
+	return &StoreLimiter{store: store, scope: scope, policy: p, log: log, clock: time.Now}
+}
+
+// NewLimiter returns a StoreLimiter over store, or an in-memory
+// RateLimiter when store is nil.
+func NewLimiter(store LimiterStore, scope string, p LimitPolicy, log zerolog.Logger) Limiter {
+	if store == nil {
+		return NewRateLimiter(p.Capacity, p.Window, p.IdleTTL)
+	}
+	return NewStoreLimiter(store, scope, p, log)
+}
+
+// Allow consumes one token. Empty/whitespace keys are always allowed. A
+// store error lets the attempt through: it is about to need the same
+// database anyway.
+func (l *StoreLimiter) Allow(key string) bool {
+	if l == nil || strings.TrimSpace(key) == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), limiterTimeout)
+	defer cancel()
+	now := l.clock()
+	ok, err := l.store.UpdateBucket(ctx, l.scope, key, func(b *domain.RateBucket) bool {
+		return l.policy.take(b, now)
+	})
+	if err != nil {
+		l.log.Warn().Err(err).Str("scope", l.scope).Msg("auth: rate limit store unavailable; allowing")
+		return true
+	}
+	return ok
+}
+
+// Reset forgets key's bucket, lockouts included.
+func (l *StoreLimiter) Reset(key string) {
+	if l == nil || strings.TrimSpace(key) == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), limiterTimeout)
+	defer cancel()
+	if err := l.store.DeleteBucket(ctx, l.scope, key); err != nil {
+		l.log.Warn().Err(err).Str("scope", l.scope).Msg("auth: rate limit reset failed")
+	}
+}
+
+// take is the bucket arithmetic: refill, then spend a token or, with
+// none left, start the next lockout.
+func (p LimitPolicy) take(b *domain.RateBucket, now time.Time) bool {
+	capacity := float64(p.Capacity)
+	if b.LockedUntil != nil && b.LockedUntil.After(now) {
+		return false
+	}
+	// A lockout counts as use: idleness starts when it ends.
+	last := b.UpdatedAt
+	if b.LockedUntil != nil && b.LockedUntil.After(last) {
+		last = *b.LockedUntil
+	}
+	if b.UpdatedAt.IsZero() || now.Sub(last) > p.IdleTTL {
+		b.Tokens, b.Lockouts, b.LockedUntil = capacity, 0, nil
+	} else if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = min(capacity, b.Tokens+elapsed*capacity/p.Window.Seconds())
+	}
+	b.UpdatedAt = now
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true
+	}
+	if p.Lockout > 0 {
+		b.Lockouts++
+		d := p.MaxLockout
+		if shift := b.Lockouts - 1; shift < 30 && p.Lockout<<shift < p.MaxLockout {
+			d = p.Lockout << shift
+		}
+		until := now.Add(d)
+		b.LockedUntil = &until
+	}
+	return false
+}
+
+// SweepRateLimits deletes stored buckets unused for longer than idle,
+// every interval until ctx ends. idle must exceed every policy's IdleTTL
+// and MaxLockout.
+func SweepRateLimits(ctx context.Context, store LimiterStore, every, idle time.Duration, log zerolog.Logger) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := store.PurgeBuckets(ctx, time.Now().Add(-idle)); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Msg("auth: rate limit sweep failed")
+			}
+		}
+	}
+}
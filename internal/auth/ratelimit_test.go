@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// memLimiterStore is a LimiterStore over a map.
+type memLimiterStore struct {
+	buckets map[[2]string]domain.RateBucket
+	err     error
+}
+
+func (m *memLimiterStore) UpdateBucket(_ context.Context, scope, key string, fn func(*domain.RateBucket) bool) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	b, ok := m.buckets[[2]string{scope, key}]
+	if !ok {
+		b = domain.RateBucket{Scope: scope, Key: key}
+	}
+	allowed := fn(&b)
+	m.buckets[[2]string{scope, key}] = b
+	return allowed, nil
+}
+func (m *memLimiterStore) DeleteBucket(_ context.Context, scope, key string) error {
+	delete(m.buckets, [2]string{scope, key})
+	return nil
+}
+func (m *memLimiterStore) DeleteBucketsByKey(_ context.Context, keys ...string) (int, error) {
+	n := 0
+	for k := range m.buckets {
+		for _, key := range keys {
+			if k[1] == key {
+				delete(m.buckets, k)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+func (m *memLimiterStore) ListLockedBuckets(context.Context, time.Time) ([]domain.RateBucket, error) {
+	return nil, nil
+}
+func (m *memLimiterStore) PurgeBuckets(context.Context, time.Time) (int64, error) { return 0, nil }
+
+func newTestStoreLimiter(p LimitPolicy) (*StoreLimiter, *memLimiterStore, *time.Time) {
+	store := &memLimiterStore{buckets: map[[2]string]domain.RateBucket{}}
+	clock := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewStoreLimiter(store, ScopeLoginUser, p, zerolog.Nop())
+	l.clock = func() time.Time { return clock }
+	return l, store, &clock
+}
+
+func TestStoreLimiterProgressiveLockout(t *testing.T) {
+	l, store, clock := newTestStoreLimiter(loginUserPolicy)
+	drain := func() {
+		t.Helper()
+		for l.Allow("ana") {
+		}
+	}
+	drain()
+	b := store.buckets[[2]string{ScopeLoginUser, "ana"}]
+	if b.Lockouts != 1 || !b.LockedUntil.Equal(clock.Add(time.Minute)) {
+		t.Fatalf("after draining: %+v, want one lockout of a minute", b)
+	}
+
+	*clock = clock.Add(59 * time.Second)
+	if l.Allow("ana") {
+		t.Fatal("allowed during the lockout")
+	}
+	*clock = clock.Add(time.Second)
+	if !l.Allow("ana") {
+		t.Fatal("still locked once the lockout ended")
+	}
+
+	drain()
+	b = store.buckets[[2]string{ScopeLoginUser, "ana"}]
+	if b.Lockouts != 2 || !b.LockedUntil.Equal(clock.Add(2*time.Minute)) {
+		t.Fatalf("second lockout: %+v, want two minutes", b)
+	}
+
+	for range 10 {
+		*clock = *b.LockedUntil
+		drain()
+		b = store.buckets[[2]string{ScopeLoginUser, "ana"}]
+	}
+	if got := b.LockedUntil.Sub(*clock); got != loginUserPolicy.MaxLockout {
+		t.Fatalf("lockout after many rounds = %v, want the %v cap", got, loginUserPolicy.MaxLockout)
+	}
+
+	*clock = b.LockedUntil.Add(loginUserPolicy.IdleTTL + time.Second)
+	if !l.Allow("ana") {
+		t.Fatal("not allowed after going idle")
+	}
+	if b = store.buckets[[2]string{ScopeLoginUser, "ana"}]; b.Lockouts != 0 {
+		t.Fatalf("lockouts = %d after going idle, want 0", b.Lockouts)
+	}
+}
+
+func TestStoreLimiterReset(t *testing.T) {
+	l, _, _ := newTestStoreLimiter(loginUserPolicy)
+	for l.Allow("ana") {
+	}
+	l.Reset("ana")
+	if !l.Allow("ana") {
+		t.Fatal("Reset did not lift the lockout")
+	}
+	if !l.Allow("  ") {
+		t.Fatal("blank key throttled")
+	}
+}
+
+func TestStoreLimiterFailsOpen(t *testing.T) {
+	l, store, _ := newTestStoreLimiter(loginUserPolicy)
+	store.err = errors.New("connection refused")
+	for range 20 {
+		if !l.Allow("ana") {
+			t.Fatal("throttled while the store was down")
+		}
+	}
+}
+
+func TestFlowsKeepTheirOwnIPBuckets(t *testing.T) {
+	store := &memLimiterStore{buckets: map[[2]string]domain.RateBucket{}}
+	s := &Service{cfg: Config{Logger: zerolog.Nop()}}
+	s.SetLimiterStore(store)
+	const ip = "203.0.113.7"
+	for name, l := range map[string]Limiter{
+		"reset request": s.rlResetRequest,
+		"reset redeem":  s.rlResetRedeem,
+		"device start":  s.rlDevice,
+		"oidc":          s.rlOIDC,
+		"passkey login": s.rlPasskey,
+	} {
+		for l.Allow(ip) {
+		}
+		if !s.rlIP.Allow(ip) {
+			t.Fatalf("draining the %s bucket throttled password logins", name)
+		}
+	}
+	scopes := map[string]bool{}
+	for k := range store.buckets {
+		scopes[k[0]] = true
+	}
+	if len(scopes) != 6 {
+		t.Fatalf("buckets in %d scopes, want one per flow plus login_ip", len(scopes))
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Password resets -------------------------------------------------------
+
+// A reset token is short-lived and single-use. A server admin can mint
+// one for any user and hand it over out of band; with mail configured,
+// users with an email address can also have a link sent to themselves
+// (RequestPasswordReset). Redeeming a token sets a new password and
+// signs the user out everywhere, like ChangePassword. Second factors are
+// left in place.
+
+const passwordResetTTL = time.Hour
+
+var errPasswordResetUnavailable = errors.New("auth: password resets are not configured")
+
+// SetPasswordResetStore enables admin-issued password resets.
+func (s *Service) SetPasswordResetStore(store PasswordResetStore) { s.resets = store }
+
+// IssuePasswordResetInput names the admin acting and the account to
+// recover.
+type IssuePasswordResetInput struct {
+	AdminID   uuid.UUID
+	UserID    uuid.UUID
+	IP        string
+	UserAgent string
+}
+
+// IssuePasswordReset returns a reset token for in.UserID, valid for
+// passwordResetTTL and replacing any token issued before. The plaintext
+// is never retrievable again.
+func (s *Service) IssuePasswordReset(ctx context.Context, in IssuePasswordResetInput) (string, time.Time, error) {
+	if s.resets == nil {
+		return "", time.Time{}, errPasswordResetUnavailable
+	}
+	admin, err := s.users.GetByID(ctx, in.AdminID)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("auth: issue password reset: %w", err)
+	}
+	if !admin.IsAdmin {
+		return "", time.Time{}, fmt.Errorf("auth: issue password reset: %w", domain.ErrForbidden)
+	}
+	if _, err := s.users.GetByID(ctx, in.UserID); err != nil {
+		return "", time.Time{}, fmt.Errorf("auth: issue password reset: %w", err)
+	}
+	token, expires, err := s.createPasswordReset(ctx, in.UserID, &in.AdminID)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("auth: issue password reset: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &in.AdminID,
+		Action:    domain.ActionAuthResetIssue,
+		Payload:   mustJSON(map[string]any{"target_user_id": in.UserID.String(), "expires_at": expires}),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return token, expires, nil
+}
+
+// createPasswordReset stores a fresh token for userID, replacing any
+// pending one. createdBy is nil when the user asked for it themselves.
+func (s *Service) createPasswordReset(ctx context.Context, userID uuid.UUID, createdBy *uuid.UUID) (string, time.Time, error) {
+	token, err := IssueToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	expires := now.Add(passwordResetTTL)
+	err = s.resets.CreatePasswordReset(ctx, domain.PasswordReset{
+		TokenHash: HashAPIToken(token),
+		UserID:    userID,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// ResetPasswordInput redeems a reset token.
+type ResetPasswordInput struct {
+	Token       string
+	NewPassword string
+	IP          string
+	UserAgent   string
+}
+
+// ResetPassword consumes a reset token, sets the new password and
+// revokes every session of the account.
+func (s *Service) ResetPassword(ctx context.Context, in ResetPasswordInput) error {
+	if s.resets == nil {
+		return errPasswordResetUnavailable
+	}
+	if !s.rlResetRedeem.Allow(in.IP) {
+		return fmt.Errorf("auth: password reset throttled: %w", domain.ErrRateLimited)
+	}
+	// Validate first so a weak password does not burn the token.
+	if err := s.ValidatePassword(in.NewPassword); err != nil {
+		return err
+	}
+	r, err := s.resets.TakePasswordReset(ctx, HashAPIToken(strings.TrimSpace(in.Token)))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.ErrTokenInvalid
+		}
+		return fmt.Errorf("auth: password reset lookup: %w", err)
+	}
+	if !r.ExpiresAt.After(time.Now()) {
+		return domain.ErrTokenExpired
+	}
+	hash, err := HashPassword(in.NewPassword, s.cfg.Argon2)
+	if err != nil {
+		return fmt.Errorf("auth: password reset hash: %w", err)
+	}
+	if err := s.users.UpdatePasswordHash(ctx, r.UserID, hash); err != nil {
+		return fmt.Errorf("auth: password reset store: %w", err)
+	}
+	if _, err := s.revokeAllSessions(ctx, r.UserID); err != nil {
+		s.cfg.Logger.Warn().Err(err).Str("user_id", r.UserID.String()).Msg("auth: failed to revoke sessions after password reset")
+	}
+	payload := map[string]any{}
+	if r.CreatedBy != nil {
+		payload["issued_by"] = r.CreatedBy.String()
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &r.UserID,
+		Action:    domain.ActionAuthPasswordReset,
+		Payload:   mustJSON(payload),
+		IP:        nilIfEmpty(in.IP),
+		UserAgent: nilIfEmpty(in.UserAgent),
+	})
+	return nil
+}
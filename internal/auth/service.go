@@ -6,13 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 
 	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/webauthn"
 )
 
 // Service is the entry point for all auth operations.
@@ -21,10 +22,38 @@ type Service struct {
 	sessions SessionStore
 	consents ConsentStore
 	audit    AuditRecorder
+	tokens   APITokenStore
+	conns    SessionTerminator
+	twoFA    TwoFactorStore
+	passkeys WebAuthnStore
+	rp       webauthn.RelyingParty
+	idp      IdentityProvider
+	idents   OIDCStore
+	oidcOpts OIDCOptions
+	granter  VaultRoleGranter
+	resets   PasswordResetStore
+	devices  DeviceAuthStore
+	access   *AccessSigner
+	refresh  RefreshTokenStore
+	revoked  revokedSessions
+	mailer   Mailer
+	baseURL  string
+	security LoginSecurityStore
+	locator  Locator
+	settings SettingsStore
+	policy   atomic.Pointer[RegistrationPolicy]
 	cfg      Config
 
-	rlUser *RateLimiter
-	rlIP   *RateLimiter
+	rlUser Limiter
+	rlIP   Limiter
+	rlCode Limiter
+	// Per-IP limiters of the flows other than password login.
+	rlResetRequest Limiter
+	rlResetRedeem  Limiter
+	rlDevice       Limiter
+	rlOIDC         Limiter
+	rlPasskey      Limiter
+	limits         LimiterStore
 
 	dummyHash string
 }
@@ -35,23 +64,81 @@ func NewService(users UserRepo, sessions SessionStore, consents ConsentStore, au
 			users == nil, sessions == nil, consents == nil, audit == nil)
 	}
 	cfg = cfg.withDefaults()
-	dummy, err := bcrypt.GenerateFromPassword([]byte("lumi-dummy-"+uuid.NewString()), cfg.BcryptCost)
+	dummy, err := HashPassword("lumi-dummy-"+uuid.NewString(), cfg.Argon2)
 	if err != nil {
 		return nil, fmt.Errorf("auth: precompute dummy hash: %w", err)
 	}
-	return &Service{
+	s := &Service{
 		users:     users,
 		sessions:  sessions,
 		consents:  consents,
 		audit:     audit,
 		cfg:       cfg,
-		dummyHash: string(dummy),
-		rlUser:    NewRateLimiter(5, time.Minute, 30*time.Minute),
-		rlIP:      NewRateLimiter(30, time.Minute, 30*time.Minute),
-	}, nil
+		dummyHash: dummy,
+	}
+	s.setLimiters(nil)
+	s.policy.Store(&cfg.RegistrationPolicy)
+	return s, nil
+}
+
+// Config returns the configuration in force, with the registration
+// policy last read.
+func (s *Service) Config() Config {
+	cfg := s.cfg
+	cfg.RegistrationPolicy = *s.policy.Load()
+	return cfg
 }
 
-func (s *Service) Config() Config { return s.cfg }
+// settingRegistration is the SettingsStore key of the registration
+// policy.
+const settingRegistration = "registration"
+
+// SetSettingsStore keeps the registration policy admins set in store,
+// so every replica applies the same one and it outlives restarts.
+func (s *Service) SetSettingsStore(store SettingsStore) { s.settings = store }
+
+// RegistrationPolicy is the policy in force: the one an admin last set,
+// or else Config.RegistrationPolicy. With a SettingsStore it is read
+// from there on every call; if that fails, the last policy read stands.
+func (s *Service) RegistrationPolicy(ctx context.Context) RegistrationPolicy {
+	if s.settings == nil {
+		return *s.policy.Load()
+	}
+	v, err := s.settings.GetSetting(ctx, settingRegistration)
+	p := RegistrationPolicy(v)
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		p = s.cfg.RegistrationPolicy
+	case err != nil:
+		s.cfg.Logger.Warn().Err(err).Msg("auth: read registration policy; keeping the last one read")
+		return *s.policy.Load()
+	case !validRegistrationPolicy(p):
+		s.cfg.Logger.Warn().Str("policy", v).Msg("auth: unknown stored registration policy; using the configured one")
+		p = s.cfg.RegistrationPolicy
+	}
+	s.policy.Store(&p)
+	return p
+}
+
+// SetRegistrationPolicy switches the policy, on every replica and for
+// good when a SettingsStore is set, else for this process until it
+// restarts.
+func (s *Service) SetRegistrationPolicy(ctx context.Context, p RegistrationPolicy) error {
+	if !validRegistrationPolicy(p) {
+		return fmt.Errorf("auth: unknown registration policy %q: %w", p, domain.ErrValidation)
+	}
+	if s.settings != nil {
+		if err := s.settings.PutSetting(ctx, settingRegistration, string(p)); err != nil {
+			return fmt.Errorf("auth: save registration policy: %w", err)
+		}
+	}
+	s.policy.Store(&p)
+	return nil
+}
+
+func validRegistrationPolicy(p RegistrationPolicy) bool {
+	return p == PolicyOpen || p == PolicyInviteOnly
+}
 
 type ConsentInput struct {
 	TosVersion     string
@@ -113,22 +200,16 @@ func (s *Service) register(ctx context.Context, in RegisterInput, requireConsent
 	if err != nil {
 		return domain.Session{}, err
 	}
-	if err := ValidatePassword(in.Password); err != nil {
+	if err := s.ValidatePassword(in.Password); err != nil {
 		return domain.Session{}, err
 	}
 	if requireConsent {
-		if strings.TrimSpace(in.Consent.TosVersion) == "" || strings.TrimSpace(in.Consent.PrivacyVersion) == "" {
-			return domain.Session{}, fmt.Errorf("auth: missing consent versions: %w", domain.ErrConsentRequired)
-		}
-		if s.cfg.TosVersion != "" && in.Consent.TosVersion != s.cfg.TosVersion {
-			return domain.Session{}, fmt.Errorf("auth: stale tos version: %w", domain.ErrConsentRequired)
-		}
-		if s.cfg.PrivacyVersion != "" && in.Consent.PrivacyVersion != s.cfg.PrivacyVersion {
-			return domain.Session{}, fmt.Errorf("auth: stale privacy version: %w", domain.ErrConsentRequired)
+		if err := s.checkConsent(in.Consent); err != nil {
+			return domain.Session{}, err
 		}
 	}
 
-	hash, err := HashPassword(in.Password, s.cfg.BcryptCost)
+	hash, err := HashPassword(in.Password, s.cfg.Argon2)
 	if err != nil {
 		return domain.Session{}, fmt.Errorf("auth: register: %w", err)
 	}
@@ -142,27 +223,12 @@ func (s *Service) register(ctx context.Context, in RegisterInput, requireConsent
 		return domain.Session{}, fmt.Errorf("auth: create user: %w", err)
 	}
 
-	tosVersion := in.Consent.TosVersion
-	privacyVersion := in.Consent.PrivacyVersion
-	if tosVersion == "" {
-		tosVersion = s.cfg.TosVersion
-	}
-	if privacyVersion == "" {
-		privacyVersion = s.cfg.PrivacyVersion
-	}
-	consent := domain.Consent{
-		UserID:         user.ID,
-		TosVersion:     tosVersion,
-		PrivacyVersion: privacyVersion,
-		AcceptedAt:     time.Now().UTC(),
-		IP:             nilIfEmpty(in.IP),
-		UserAgent:      nilIfEmpty(in.UserAgent),
-	}
-	if err := s.consents.RecordConsent(ctx, consent); err != nil {
-		return domain.Session{}, fmt.Errorf("auth: record consent: %w", err)
+	consent, err := s.recordConsent(ctx, user.ID, in.Consent, in.IP, in.UserAgent)
+	if err != nil {
+		return domain.Session{}, err
 	}
 
-	session, err := s.issueSession(ctx, user.ID)
+	session, err := s.issueSession(ctx, user.ID, in.IP, in.UserAgent)
 	if err != nil {
 		return domain.Session{}, fmt.Errorf("auth: register: %w", err)
 	}
@@ -177,28 +243,71 @@ func (s *Service) register(ctx context.Context, in RegisterInput, requireConsent
 	s.recordAudit(ctx, domain.AuditEntry{
 		UserID:    &user.ID,
 		Action:    domain.ActionConsentAccept,
-		Payload:   mustJSON(map[string]any{"tos_version": tosVersion, "privacy_version": privacyVersion}),
+		Payload:   mustJSON(map[string]any{"tos_version": consent.TosVersion, "privacy_version": consent.PrivacyVersion}),
 		IP:        nilIfEmpty(in.IP),
 		UserAgent: nilIfEmpty(in.UserAgent),
 	})
 	return session, nil
 }
 
+// checkConsent rejects missing or stale consent versions.
+func (s *Service) checkConsent(c ConsentInput) error {
+	if strings.TrimSpace(c.TosVersion) == "" || strings.TrimSpace(c.PrivacyVersion) == "" {
+		return fmt.Errorf("auth: missing consent versions: %w", domain.ErrConsentRequired)
+	}
+	if s.cfg.TosVersion != "" && c.TosVersion != s.cfg.TosVersion {
+		return fmt.Errorf("auth: stale tos version: %w", domain.ErrConsentRequired)
+	}
+	if s.cfg.PrivacyVersion != "" && c.PrivacyVersion != s.cfg.PrivacyVersion {
+		return fmt.Errorf("auth: stale privacy version: %w", domain.ErrConsentRequired)
+	}
+	return nil
+}
+
+// recordConsent writes the consent ledger entry for a new account.
+func (s *Service) recordConsent(ctx context.Context, userID uuid.UUID, c ConsentInput, ip, ua string) (domain.Consent, error) {
+	consent := s.newConsent(userID, c, ip, ua)
+	if err := s.consents.RecordConsent(ctx, consent); err != nil {
+		return domain.Consent{}, fmt.Errorf("auth: record consent: %w", err)
+	}
+	return consent, nil
+}
+
+// newConsent builds the consent ledger entry for a new account, falling
+// back to the configured versions.
+func (s *Service) newConsent(userID uuid.UUID, c ConsentInput, ip, ua string) domain.Consent {
+	consent := domain.Consent{
+		UserID:         userID,
+		TosVersion:     c.TosVersion,
+		PrivacyVersion: c.PrivacyVersion,
+		AcceptedAt:     time.Now().UTC(),
+		IP:             nilIfEmpty(ip),
+		UserAgent:      nilIfEmpty(ua),
+	}
+	if consent.TosVersion == "" {
+		consent.TosVersion = s.cfg.TosVersion
+	}
+	if consent.PrivacyVersion == "" {
+		consent.PrivacyVersion = s.cfg.PrivacyVersion
+	}
+	return consent
+}
+
 // Login authenticates and issues a session. Constant-time on user-not-found
 // via a precomputed dummy hash.
 func (s *Service) Login(ctx context.Context, in LoginInput) (domain.Session, error) {
+	// The IP budget comes first so a throttled caller gets no hashing
+	// work, not even the dummy one.
+	if !s.rlIP.Allow(in.IP) {
+		s.recordLoginFailure(ctx, nil, in, "rate_limited_ip")
+		return domain.Session{}, fmt.Errorf("auth: ip throttled: %w", domain.ErrRateLimited)
+	}
 	username, err := canonicaliseUsername(in.Username)
 	if err != nil {
-		s.consumeFailureBudget("", in.IP)
 		_ = CheckPassword(s.dummyHash, in.Password)
 		s.recordLoginFailure(ctx, nil, in, "invalid_username")
 		return domain.Session{}, domain.ErrInvalidCredentials
 	}
-
-	if !s.rlIP.Allow(in.IP) {
-		s.recordLoginFailure(ctx, nil, in, "rate_limited_ip")
-		return domain.Session{}, fmt.Errorf("auth: ip throttled: %w", domain.ErrRateLimited)
-	}
 	if !s.rlUser.Allow(username) {
 		s.recordLoginFailure(ctx, nil, in, "rate_limited_user")
 		return domain.Session{}, fmt.Errorf("auth: user throttled: %w", domain.ErrRateLimited)
@@ -217,6 +326,10 @@ func (s *Service) Login(ctx context.Context, in LoginInput) (domain.Session, err
 	}
 
 	cmpErr := CheckPassword(hash, in.Password)
+	if errors.Is(cmpErr, domain.ErrRateLimited) {
+		s.recordLoginFailure(ctx, nil, in, "hashing_busy")
+		return domain.Session{}, cmpErr
+	}
 	if !realUser || cmpErr != nil {
 		var uid *uuid.UUID
 		if realUser {
@@ -228,8 +341,21 @@ func (s *Service) Login(ctx context.Context, in LoginInput) (domain.Session, err
 	}
 
 	s.rlUser.Reset(username)
+	s.upgradeHash(ctx, user, in.Password)
 
-	session, err := s.issueSession(ctx, user.ID)
+	// Only the right password learns that the account is suspended.
+	if user.SuspendedAt != nil {
+		s.recordLoginFailure(ctx, &user.ID, in, "account_suspended")
+		return domain.Session{}, domain.ErrAccountSuspended
+	}
+	if user.TwoFactorEnabled && s.twoFA != nil {
+		return domain.Session{}, s.issueChallenge(ctx, user.ID, nil)
+	}
+	if err := s.requireStepUp(ctx, user, in, nil); err != nil {
+		return domain.Session{}, err
+	}
+
+	session, err := s.issueSession(ctx, user.ID, in.IP, in.UserAgent)
 	if err != nil {
 		return domain.Session{}, fmt.Errorf("auth: login issue session: %w", err)
 	}
@@ -256,6 +382,7 @@ func (s *Service) Logout(ctx context.Context, token string) error {
 	if err := s.sessions.DeleteSession(ctx, token); err != nil {
 		return fmt.Errorf("auth: logout delete: %w", err)
 	}
+	s.closeConnections(sess)
 	uid := sess.UserID
 	s.recordAudit(ctx, domain.AuditEntry{
 		UserID: &uid,
@@ -264,15 +391,55 @@ func (s *Service) Logout(ctx context.Context, token string) error {
 	return nil
 }
 
+// LogoutSession ends a session by public id; it is how a client
+// holding a signed access token logs out.
+func (s *Service) LogoutSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	sess, err := s.sessions.DeleteSessionByID(ctx, userID, sessionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("auth: logout delete: %w", err)
+	}
+	s.closeConnections(sess)
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID: &userID,
+		Action: domain.ActionAuthLogout,
+	})
+	return nil
+}
+
 // CheckPassword satisfies users.PasswordChecker (LGPD erasure password
-// confirmation flow).
+// confirmation flow). An account created through OIDC confirms with a
+// grant from ReauthenticateOIDC instead of a password.
 func (s *Service) CheckPassword(ctx context.Context, userID uuid.UUID, password string) error {
 	user, err := s.users.GetByID(ctx, userID)
 	if err != nil {
-		_ = CheckPassword(s.dummyHash, password)
+		if err := CheckPassword(s.dummyHash, password); errors.Is(err, domain.ErrRateLimited) {
+			return err
+		}
 		return domain.ErrInvalidCredentials
 	}
-	return CheckPassword(user.PasswordHash, password)
+	return s.confirmUser(ctx, user, password)
+}
+
+// confirmUser checks secret against the user's password or, for an
+// account without one, spends the re-authentication grant it names.
+func (s *Service) confirmUser(ctx context.Context, user domain.User, secret string) error {
+	if user.PasswordHash != oidcNoPassword || s.idents == nil || !strings.HasPrefix(secret, reauthTokenPrefix) {
+		return CheckPassword(user.PasswordHash, secret)
+	}
+	g, err := s.idents.TakeReauthGrant(ctx, HashAPIToken(secret))
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.ErrInvalidCredentials
+		}
+		return fmt.Errorf("auth: reauth grant lookup: %w", err)
+	}
+	if g.UserID != user.ID || !g.ExpiresAt.After(time.Now()) {
+		return domain.ErrInvalidCredentials
+	}
+	return nil
 }
 
 func (s *Service) ChangePassword(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error {
@@ -280,7 +447,10 @@ func (s *Service) ChangePassword(ctx context.Context, userID uuid.UUID, oldPassw
 	if err != nil {
 		return fmt.Errorf("auth: change password: %w", err)
 	}
-	if err := CheckPassword(user.PasswordHash, oldPassword); err != nil {
+	if err := s.confirmUser(ctx, user, oldPassword); err != nil {
+		if errors.Is(err, domain.ErrRateLimited) {
+			return err
+		}
 		s.recordAudit(ctx, domain.AuditEntry{
 			UserID:  &userID,
 			Action:  domain.ActionAuthLoginFailed,
@@ -288,20 +458,20 @@ func (s *Service) ChangePassword(ctx context.Context, userID uuid.UUID, oldPassw
 		})
 		return domain.ErrInvalidCredentials
 	}
-	if err := ValidatePassword(newPassword); err != nil {
+	if err := s.ValidatePassword(newPassword); err != nil {
 		return err
 	}
 	if oldPassword == newPassword {
 		return fmt.Errorf("auth: new password equals old: %w", domain.ErrValidation)
 	}
-	hash, err := HashPassword(newPassword, s.cfg.BcryptCost)
+	hash, err := HashPassword(newPassword, s.cfg.Argon2)
 	if err != nil {
 		return fmt.Errorf("auth: change password hash: %w", err)
 	}
 	if err := s.users.UpdatePasswordHash(ctx, userID, hash); err != nil {
 		return fmt.Errorf("auth: change password store: %w", err)
 	}
-	if _, err := s.sessions.DeleteSessionsForUser(ctx, userID); err != nil {
+	if _, err := s.revokeAllSessions(ctx, userID); err != nil {
 		s.cfg.Logger.Warn().Err(err).Str("user_id", userID.String()).Msg("auth: failed to revoke sessions after password change")
 	}
 	s.recordAudit(ctx, domain.AuditEntry{
@@ -351,6 +521,9 @@ func (s *Service) Validate(ctx context.Context, token string) (domain.Session, d
 		_ = s.sessions.DeleteSession(ctx, token)
 		return domain.Session{}, domain.User{}, domain.ErrTokenInvalid
 	}
+	if user.SuspendedAt != nil {
+		return domain.Session{}, domain.User{}, domain.ErrAccountSuspended
+	}
 
 	newExpiry := now.Add(s.cfg.SessionTTL)
 	if err := s.sessions.TouchSession(ctx, token, now, newExpiry); err == nil {
@@ -362,29 +535,67 @@ func (s *Service) Validate(ctx context.Context, token string) (domain.Session, d
 	return sess, user, nil
 }
 
-func (s *Service) issueSession(ctx context.Context, userID uuid.UUID) (domain.Session, error) {
+// issueSession is where every login path ends, so it is also where a
+// suspended account is turned away, whichever credential it presented.
+func (s *Service) issueSession(ctx context.Context, userID uuid.UUID, ip, ua string) (domain.Session, error) {
+	return s.issueDeviceSession(ctx, userID, ip, ua, "")
+}
+
+// issueDeviceSession is issueSession for a session labelled with the
+// client that obtained it through a device grant.
+func (s *Service) issueDeviceSession(ctx context.Context, userID uuid.UUID, ip, ua, device string) (domain.Session, error) {
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("auth: issue session lookup: %w", err)
+	}
+	if user.SuspendedAt != nil {
+		s.recordLoginFailure(ctx, &userID, LoginInput{IP: ip, UserAgent: ua}, "account_suspended")
+		return domain.Session{}, domain.ErrAccountSuspended
+	}
 	token, err := IssueToken()
 	if err != nil {
 		return domain.Session{}, fmt.Errorf("auth: issue token: %w", err)
 	}
 	now := time.Now().UTC()
 	sess := domain.Session{
+		ID:         uuid.New(),
 		Token:      token,
 		UserID:     userID,
+		IP:         strings.TrimSpace(ip),
+		UserAgent:  strings.TrimSpace(ua),
 		CreatedAt:  now,
 		ExpiresAt:  now.Add(s.cfg.SessionTTL),
 		LastUsedAt: now,
+		DeviceName: device,
 	}
 	if err := s.sessions.CreateSession(ctx, sess); err != nil {
 		return domain.Session{}, fmt.Errorf("auth: persist session: %w", err)
 	}
+	s.reviewLogin(ctx, user, sess)
 	return sess, nil
 }
 
 // HashPassword exposes the password-hashing primitive for callers (e.g.
 // internal/invites which creates users without going through Register).
 func (s *Service) HashPassword(plaintext string) (string, error) {
-	return HashPassword(plaintext, s.cfg.BcryptCost)
+	return HashPassword(plaintext, s.cfg.Argon2)
+}
+
+// upgradeHash re-hashes a password that just verified against a bcrypt
+// hash or one with outdated argon2 parameters. The swap only lands if
+// the stored hash is still the one verified, so a concurrent password
+// change wins.
+func (s *Service) upgradeHash(ctx context.Context, user domain.User, plain string) {
+	if !needsRehash(user.PasswordHash, s.cfg.Argon2) {
+		return
+	}
+	hash, err := HashPassword(plain, s.cfg.Argon2)
+	if err == nil {
+		_, err = s.users.ReplacePasswordHash(ctx, user.ID, user.PasswordHash, hash)
+	}
+	if err != nil {
+		s.cfg.Logger.Warn().Err(err).Str("user_id", user.ID.String()).Msg("auth: failed to upgrade password hash")
+	}
 }
 
 // NewSessionToken exposes session-token issuance to callers like
@@ -394,15 +605,6 @@ func (s *Service) NewSessionToken() string {
 	return t
 }
 
-func (s *Service) consumeFailureBudget(username, ip string) {
-	if username != "" {
-		s.rlUser.Allow(username)
-	}
-	if ip != "" {
-		s.rlIP.Allow(ip)
-	}
-}
-
 func (s *Service) recordLoginFailure(ctx context.Context, uid *uuid.UUID, in LoginInput, reason string) {
 	s.recordAudit(ctx, domain.AuditEntry{
 		UserID:    uid,
@@ -411,6 +613,9 @@ func (s *Service) recordLoginFailure(ctx context.Context, uid *uuid.UUID, in Log
 		IP:        nilIfEmpty(in.IP),
 		UserAgent: nilIfEmpty(in.UserAgent),
 	})
+	if uid != nil && reason == "invalid_credentials" {
+		s.watchFailures(ctx, *uid, in.IP)
+	}
 }
 
 func (s *Service) recordAudit(ctx context.Context, e domain.AuditEntry) {
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+type memSettings struct {
+	values map[string]string
+	err    error
+}
+
+func (m *memSettings) GetSetting(_ context.Context, key string) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	v, ok := m.values[key]
+	if !ok {
+		return "", domain.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m *memSettings) PutSetting(_ context.Context, key, value string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.values[key] = value
+	return nil
+}
+
+func TestRegistrationPolicyIsShared(t *testing.T) {
+	ctx := context.Background()
+	store := &memSettings{values: map[string]string{}}
+	replica := func() *Service {
+		s := &Service{cfg: Config{RegistrationPolicy: PolicyInviteOnly, Logger: zerolog.Nop()}}
+		s.policy.Store(&s.cfg.RegistrationPolicy)
+		s.SetSettingsStore(store)
+		return s
+	}
+	a, b := replica(), replica()
+
+	if got := b.RegistrationPolicy(ctx); got != PolicyInviteOnly {
+		t.Fatalf("nothing stored: %q, want the configured policy", got)
+	}
+	if err := a.SetRegistrationPolicy(ctx, PolicyOpen); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.RegistrationPolicy(ctx); got != PolicyOpen {
+		t.Fatalf("other replica sees %q, want open", got)
+	}
+	if got := replica().RegistrationPolicy(ctx); got != PolicyOpen {
+		t.Fatalf("after a restart: %q, want open", got)
+	}
+
+	store.err = errors.New("database down")
+	if got := b.RegistrationPolicy(ctx); got != PolicyOpen {
+		t.Fatalf("store unavailable: %q, want the last policy read", got)
+	}
+	if err := a.SetRegistrationPolicy(ctx, PolicyInviteOnly); err == nil {
+		t.Fatal("a change the store could not keep was accepted")
+	}
+	if err := a.SetRegistrationPolicy(ctx, "everyone"); !errors.Is(err, domain.ErrValidation) {
+		t.Fatalf("unknown policy: %v, want ErrValidation", err)
+	}
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Session management ----------------------------------------------------
+
+// SetSessionTerminator wires the component holding long-lived
+// connections; without one, revoking a session only stops new requests.
+func (s *Service) SetSessionTerminator(t SessionTerminator) { s.conns = t }
+
+// ListSessions returns the user's active sessions.
+func (s *Service) ListSessions(ctx context.Context, userID uuid.UUID) ([]domain.Session, error) {
+	out, err := s.sessions.ListSessionsForUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("auth: list sessions: %w", err)
+	}
+	return out, nil
+}
+
+// RevokeSession ends one of the user's sessions by public id, including
+// any WebSocket it holds open.
+func (s *Service) RevokeSession(ctx context.Context, userID, id uuid.UUID, ip, ua string) error {
+	sess, err := s.sessions.DeleteSessionByID(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("auth: revoke session: %w", err)
+	}
+	s.closeConnections(sess)
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuthSessionRevoke,
+		Payload:   mustJSON(map[string]any{"session_id": id, "count": 1}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return nil
+}
+
+// RevokeOtherSessions ends every session of the user but keepID — "log
+// out everywhere else". Returns how many were revoked.
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID, keepID uuid.UUID, ip, ua string) (int, error) {
+	gone, err := s.sessions.DeleteOtherSessions(ctx, userID, keepID)
+	if err != nil {
+		return 0, fmt.Errorf("auth: revoke other sessions: %w", err)
+	}
+	s.closeConnections(gone...)
+	if len(gone) > 0 {
+		s.recordAudit(ctx, domain.AuditEntry{
+			UserID:    &userID,
+			Action:    domain.ActionAuthSessionRevoke,
+			Payload:   mustJSON(map[string]any{"kept_session_id": keepID, "count": len(gone)}),
+			IP:        nilIfEmpty(ip),
+			UserAgent: nilIfEmpty(ua),
+		})
+	}
+	return len(gone), nil
+}
+
+// ForceLogout ends every session of the user on an admin's behalf and
+// reports how many there were. Personal access tokens are untouched.
+func (s *Service) ForceLogout(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := s.revokeAllSessions(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("auth: force logout: %w", err)
+	}
+	return n, nil
+}
+
+// DisconnectUser closes every live connection of the user, including
+// ones opened with a personal access token, and reports how many.
+func (s *Service) DisconnectUser(userID uuid.UUID) int {
+	if s.conns == nil {
+		return 0
+	}
+	return s.conns.CloseUser(userID)
+}
+
+// ExpireAccessTokens makes this replica refuse the access tokens already
+// issued for the user's sessions, so a change to the account (admin
+// flag, suspension) reaches them at their next refresh rather than when
+// they expire. The sessions themselves stay.
+func (s *Service) ExpireAccessTokens(ctx context.Context, userID uuid.UUID) error {
+	if s.access == nil {
+		return nil
+	}
+	live, err := s.sessions.ListSessionsForUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("auth: expire access tokens: %w", err)
+	}
+	ids := make([]uuid.UUID, 0, len(live))
+	for _, sess := range live {
+		ids = append(ids, sess.ID)
+	}
+	s.revoked.add(s.access.TTL(), ids...)
+	return nil
+}
+
+// revokeAllSessions deletes every session of the user and cuts their
+// connections.
+func (s *Service) revokeAllSessions(ctx context.Context, userID uuid.UUID) (int, error) {
+	live, err := s.sessions.ListSessionsForUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.sessions.DeleteSessionsForUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	s.closeConnections(live...)
+	return n, nil
+}
+
+// closeConnections cuts the connections of ended sessions and, on this
+// replica, refuses the access tokens still out for them.
+func (s *Service) closeConnections(sessions ...domain.Session) {
+	if len(sessions) == 0 {
+		return
+	}
+	ids := make([]uuid.UUID, 0, len(sessions))
+	for _, sess := range sessions {
+		ids = append(ids, sess.ID)
+	}
+	if s.access != nil {
+		s.revoked.add(s.access.TTL(), ids...)
+	}
+	if s.conns == nil {
+		return
+	}
+	s.conns.CloseSessions(ids...)
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/geoip"
+	"github.com/ViniZap4/lumi-server/internal/oidc"
 )
 
 // UserRepo is the slice of user persistence the auth package needs.
@@ -16,6 +18,9 @@ type UserRepo interface {
 	CreateUser(ctx context.Context, in CreateUserInput) (domain.User, error)
 	UpdateDisplayName(ctx context.Context, id uuid.UUID, displayName string) error
 	UpdatePasswordHash(ctx context.Context, id uuid.UUID, hash string) error
+	ReplacePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error)
+	GetByEmail(ctx context.Context, email string) (domain.User, error)
+	UpdateEmail(ctx context.Context, id uuid.UUID, email string) error
 }
 
 // CreateUserInput is the input shape for new-user creation.
@@ -32,6 +37,132 @@ type SessionStore interface {
 	TouchSession(ctx context.Context, token string, lastUsedAt, expiresAt time.Time) error
 	DeleteSession(ctx context.Context, token string) error
 	DeleteSessionsForUser(ctx context.Context, userID uuid.UUID) (int, error)
+	ListSessionsForUser(ctx context.Context, userID uuid.UUID) ([]domain.Session, error)
+	DeleteSessionByID(ctx context.Context, userID, id uuid.UUID) (domain.Session, error)
+	DeleteOtherSessions(ctx context.Context, userID, keepID uuid.UUID) ([]domain.Session, error)
+}
+
+// TwoFactorStore manages TOTP enrolments, recovery codes and login
+// challenges.
+type TwoFactorStore interface {
+	GetTOTP(ctx context.Context, userID uuid.UUID) (domain.TOTPEnrolment, error)
+	BeginTOTP(ctx context.Context, userID uuid.UUID, secret string) error
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, codeHashes []string, at time.Time) error
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) error
+	AdvanceTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string, at time.Time) (bool, error)
+	CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+	CreateLoginChallenge(ctx context.Context, c domain.LoginChallenge) error
+	GetLoginChallenge(ctx context.Context, tokenHash string) (domain.LoginChallenge, error)
+	BumpLoginChallenge(ctx context.Context, tokenHash string) (int, error)
+	DeleteLoginChallenge(ctx context.Context, tokenHash string) error
+	TakeLoginChallenge(ctx context.Context, tokenHash string) error
+}
+
+// WebAuthnStore manages WebAuthn credentials and ceremonies.
+type WebAuthnStore interface {
+	CreateCredential(ctx context.Context, c domain.WebAuthnCredential) error
+	GetCredential(ctx context.Context, credentialID []byte) (domain.WebAuthnCredential, error)
+	ListCredentials(ctx context.Context, userID uuid.UUID) ([]domain.WebAuthnCredential, error)
+	RecordCredentialUse(ctx context.Context, id uuid.UUID, signCount uint32, at time.Time) error
+	DeleteCredential(ctx context.Context, userID, id uuid.UUID) (domain.WebAuthnCredential, error)
+	CreateChallenge(ctx context.Context, c domain.WebAuthnChallenge) error
+	TakeChallenge(ctx context.Context, challenge []byte) (domain.WebAuthnChallenge, error)
+}
+
+// OIDCStore manages linked OpenID Connect identities and authorization
+// requests in flight.
+type OIDCStore interface {
+	GetIdentity(ctx context.Context, issuer, subject string) (domain.UserIdentity, error)
+	CreateIdentity(ctx context.Context, id domain.UserIdentity) error
+	// ProvisionUser creates u, links the identity to it and records the
+	// consent in one transaction. ErrConflict when the username is taken
+	// or the subject already linked.
+	ProvisionUser(ctx context.Context, u domain.User, id domain.UserIdentity, c domain.Consent) error
+	CreateState(ctx context.Context, st domain.OIDCState) error
+	TakeState(ctx context.Context, stateHash string) (domain.OIDCState, error)
+	CreateReauthGrant(ctx context.Context, g domain.ReauthGrant) error
+	TakeReauthGrant(ctx context.Context, tokenHash string) (domain.ReauthGrant, error)
+}
+
+// PasswordResetStore manages admin-issued password reset tokens.
+type PasswordResetStore interface {
+	CreatePasswordReset(ctx context.Context, r domain.PasswordReset) error
+	TakePasswordReset(ctx context.Context, tokenHash string) (domain.PasswordReset, error)
+}
+
+// RefreshTokenStore keeps the refresh tokens of signed-token sessions.
+type RefreshTokenStore interface {
+	IssueRefreshToken(ctx context.Context, t domain.RefreshToken) error
+	UseRefreshToken(ctx context.Context, tokenHash string, at time.Time) (domain.RefreshToken, error)
+}
+
+// DeviceAuthStore manages device authorization grants.
+type DeviceAuthStore interface {
+	CreateDeviceAuthorization(ctx context.Context, d domain.DeviceAuthorization) error
+	GetPendingDeviceAuthorization(ctx context.Context, userCode string) (domain.DeviceAuthorization, error)
+	DecideDeviceAuthorization(ctx context.Context, userCode string, userID uuid.UUID, approve bool, at time.Time) (domain.DeviceAuthorization, error)
+	PollDeviceAuthorization(ctx context.Context, deviceCodeHash string, at time.Time) (domain.DeviceAuthorization, error)
+	TakeDeviceAuthorization(ctx context.Context, deviceCodeHash string) (domain.DeviceAuthorization, error)
+}
+
+// LoginSecurityStore remembers the devices and networks a user logs in
+// from and keeps their security events.
+type LoginSecurityStore interface {
+	LoginBaseline(ctx context.Context, userID uuid.UUID, fingerprint, network string) (domain.LoginBaseline, error)
+	RememberLogin(ctx context.Context, l domain.LoginSighting) error
+	RecordSecurityEvent(ctx context.Context, e domain.SecurityEvent) error
+	ListSecurityEvents(ctx context.Context, userID uuid.UUID, limit int) ([]domain.SecurityEvent, error)
+	CountLoginFailures(ctx context.Context, userID uuid.UUID, since time.Time) (int, error)
+}
+
+// Locator places an IP address on the map. Implemented by *geoip.DB.
+type Locator interface {
+	Locate(ip string) (geoip.Location, bool)
+}
+
+// IdentityProvider is an OpenID provider. Implemented by *oidc.Provider.
+type IdentityProvider interface {
+	Issuer() string
+	AuthCodeURL(state, nonce, codeVerifier string) string
+	Exchange(ctx context.Context, code, codeVerifier string) (string, error)
+	VerifyIDToken(ctx context.Context, raw, nonce string) (oidc.Claims, error)
+}
+
+// VaultRoleGranter makes a user a member of a vault under the named
+// role, reporting false when they already were a member.
+type VaultRoleGranter interface {
+	GrantVaultRole(ctx context.Context, vaultID, userID uuid.UUID, role string) (bool, error)
+}
+
+// SessionTerminator cuts live connections (WebSockets) opened with a
+// revoked session or by a suspended user. Implemented by wsync.Hub.
+type SessionTerminator interface {
+	CloseSessions(ids ...uuid.UUID) int
+	CloseUser(userID uuid.UUID) int
+}
+
+// SettingsStore keeps the server-wide settings admins change at
+// runtime. GetSetting is ErrNotFound for a key never set.
+type SettingsStore interface {
+	GetSetting(ctx context.Context, key string) (string, error)
+	PutSetting(ctx context.Context, key, value string) error
+}
+
+// Mailer queues templated email (see the mail.Template* names).
+// Implemented by *mail.Outbox.
+type Mailer interface {
+	Enqueue(ctx context.Context, to, template string, data any) error
+}
+
+// APITokenStore manages the api_tokens table.
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, t domain.APIToken) error
+	GetAPITokenByHash(ctx context.Context, hash string) (domain.APIToken, error)
+	ListAPITokens(ctx context.Context, userID uuid.UUID) ([]domain.APIToken, error)
+	TouchAPIToken(ctx context.Context, id uuid.UUID, at time.Time) error
+	DeleteAPIToken(ctx context.Context, userID, id uuid.UUID) (domain.APIToken, error)
 }
 
 // ConsentStore writes immutable consent ledger entries.
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP per RFC 6238 with the parameters every authenticator app
+// defaults to: HMAC-SHA1, 6 digits, 30-second steps. One step of clock
+// skew is tolerated either way.
+const (
+	totpPeriod      = 30
+	totpDigits      = 6
+	totpModulo      = 1_000_000 // 10^totpDigits
+	totpSkew        = 1
+	totpSecretBytes = 20
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a fresh base32 secret.
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("auth: read random: %w", err)
+	}
+	return b32.EncodeToString(buf), nil
+}
+
+// totpURI is the otpauth:// URI authenticator apps scan as a QR code.
+func totpURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// totpCode computes the code for one time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, v%totpModulo)
+}
+
+// matchTOTP checks code against the steps around now and returns the
+// step it matched, so the caller can refuse to accept it twice.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+	cur := now.Unix() / totpPeriod
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if ConstantTimeEqual(totpCode(key, step), code) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns display-form recovery codes and their hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for range recoveryCodeCount {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("auth: read random: %w", err)
+		}
+		raw := strings.ToLower(b32.EncodeToString(buf)) // 16 chars
+		code := raw[:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalises a recovery code (case, dashes, spaces)
+// and hashes it. Codes carry 80 random bits; SHA-256 is enough.
+func hashRecoveryCode(code string) string {
+	norm := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(norm))
+	return hex.EncodeToString(sum[:])
+}
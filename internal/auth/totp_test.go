@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1, truncated to six digits.
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		if got := totpCode(key, tc.unix/totpPeriod); got != tc.want {
+			t.Errorf("t=%d: code = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := b32.DecodeString(secret)
+	now := time.Unix(1_700_000_000, 0)
+	cur := now.Unix() / totpPeriod
+
+	for _, d := range []int64{-1, 0, 1} {
+		step, ok := matchTOTP(secret, totpCode(key, cur+d), now)
+		if !ok || step != cur+d {
+			t.Errorf("skew %d: step = %d, ok = %v", d, step, ok)
+		}
+	}
+	if _, ok := matchTOTP(secret, totpCode(key, cur+2), now); ok {
+		t.Error("code two steps ahead accepted")
+	}
+	if _, ok := matchTOTP(secret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}
+
+func TestRecoveryCodeHashNormalises(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes", len(codes))
+	}
+	if got := hashRecoveryCode(" " + codes[0][:9] + " " + codes[0][10:] + " "); got != hashes[0] {
+		t.Error("spacing changed the hash")
+	}
+	if hashRecoveryCode(codes[0]) == hashRecoveryCode(codes[1]) {
+		t.Error("distinct codes hash alike")
+	}
+}
@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Two-factor authentication ---------------------------------------------
+
+// A user with a confirmed TOTP enrolment logs in in two steps: Login
+// checks the password and answers with a short-lived challenge
+// (*SecondFactorRequired), and CompleteLogin trades the challenge plus a
+// TOTP or recovery code for a session. Code attempts are rate-limited
+// per user across every flow that accepts one, and a challenge dies
+// after challengeMaxAttempts wrong codes so the password must be
+// proven again.
+
+const (
+	challengeTTL         = 5 * time.Minute
+	challengeMaxAttempts = 5
+)
+
+// SetTwoFactorStore enables TOTP. Without one, nobody is asked for a
+// second factor and the enrolment routes fail.
+func (s *Service) SetTwoFactorStore(store TwoFactorStore) { s.twoFA = store }
+
+var errTwoFactorUnavailable = errors.New("auth: two-factor authentication is not configured")
+
+// SecondFactorRequired is returned by Login when the password was right
+// and the account has 2FA, or the login needs an emailed code (see
+// requireStepUp). Challenge goes to CompleteLogin or the WebAuthn
+// second-factor ceremony; Methods lists what the user has.
+type SecondFactorRequired struct {
+	Challenge string
+	ExpiresAt time.Time
+	Methods   []string
+}
+
+func (e *SecondFactorRequired) Error() string { return "auth: second factor required" }
+func (e *SecondFactorRequired) Unwrap() error { return domain.ErrSecondFactor }
+
+// SecondFactorInput completes a two-step login. Exactly one of Code and
+// RecoveryCode is expected.
+type SecondFactorInput struct {
+	Challenge    string
+	Code         string
+	RecoveryCode string
+	IP           string
+	UserAgent    string
+}
+
+// Second factors, as named in SecondFactorRequired.Methods and in the
+// login audit payload.
+const (
+	methodTOTP         = "totp"
+	methodRecoveryCode = "recovery_code"
+	methodWebAuthn     = "webauthn"
+)
+
+// TwoFactorStatus describes a user's enrolment. Enabled is true when any
+// second factor is set up.
+type TwoFactorStatus struct {
+	Enabled             bool
+	TOTPEnabled         bool
+	TOTPPending         bool
+	TOTPConfirmedAt     *time.Time
+	RecoveryCodesLeft   int
+	WebAuthnCredentials int
+	Required            bool
+}
+
+// enrolmentRequired reports whether the policy confines u to enrolling.
+func (s *Service) enrolmentRequired(u domain.User) bool {
+	return s.cfg.TwoFactorPolicy == TwoFactorRequired && s.twoFA != nil && !u.TwoFactorEnabled
+}
+
+// issueChallenge starts the second login step for userID.
+func (s *Service) issueChallenge(ctx context.Context, userID uuid.UUID, oidcGroups []string) error {
+	token, err := IssueToken()
+	if err != nil {
+		return fmt.Errorf("auth: issue challenge: %w", err)
+	}
+	now := time.Now().UTC()
+	c := domain.LoginChallenge{
+		TokenHash:  HashAPIToken(token),
+		UserID:     userID,
+		OIDCGroups: oidcGroups,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(challengeTTL),
+	}
+	if err := s.twoFA.CreateLoginChallenge(ctx, c); err != nil {
+		return fmt.Errorf("auth: persist challenge: %w", err)
+	}
+	return &SecondFactorRequired{Challenge: token, ExpiresAt: c.ExpiresAt, Methods: s.secondFactors(ctx, userID)}
+}
+
+// secondFactors lists the factors userID can complete a login with.
+func (s *Service) secondFactors(ctx context.Context, userID uuid.UUID) []string {
+	var out []string
+	if enr, err := s.twoFA.GetTOTP(ctx, userID); err == nil && enr.ConfirmedAt != nil {
+		out = append(out, methodTOTP, methodRecoveryCode)
+	}
+	if s.webauthnReady() {
+		if creds, err := s.passkeys.ListCredentials(ctx, userID); err == nil && len(creds) > 0 {
+			out = append(out, methodWebAuthn)
+		}
+	}
+	return out
+}
+
+// CompleteLogin finishes a two-step login and issues the session.
+func (s *Service) CompleteLogin(ctx context.Context, in SecondFactorInput) (domain.Session, error) {
+	ch, err := s.loginChallenge(ctx, in.Challenge)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	var method string
+	if ch.CodeHash != "" {
+		method, err = s.checkLoginCode(ctx, ch, in.Challenge, in.Code, in.IP, in.UserAgent)
+	} else {
+		method, err = s.checkSecondFactor(ctx, ch.UserID, in.Code, in.RecoveryCode, "login", in.IP, in.UserAgent)
+	}
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCredentials) {
+			s.failChallenge(ctx, ch)
+		}
+		return domain.Session{}, err
+	}
+	return s.finishSecondFactor(ctx, ch, method, in.IP, in.UserAgent)
+}
+
+// loginChallenge resolves a challenge token from Login.
+func (s *Service) loginChallenge(ctx context.Context, token string) (domain.LoginChallenge, error) {
+	if s.twoFA == nil {
+		return domain.LoginChallenge{}, errTwoFactorUnavailable
+	}
+	hash := HashAPIToken(strings.TrimSpace(token))
+	ch, err := s.twoFA.GetLoginChallenge(ctx, hash)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.LoginChallenge{}, domain.ErrTokenInvalid
+		}
+		return domain.LoginChallenge{}, fmt.Errorf("auth: challenge lookup: %w", err)
+	}
+	if !ch.ExpiresAt.After(time.Now()) {
+		_ = s.twoFA.DeleteLoginChallenge(ctx, hash)
+		return domain.LoginChallenge{}, domain.ErrTokenExpired
+	}
+	return ch, nil
+}
+
+// failChallenge counts a wrong second factor against ch and kills it
+// once it has used up its attempts.
+func (s *Service) failChallenge(ctx context.Context, ch domain.LoginChallenge) {
+	if n, err := s.twoFA.BumpLoginChallenge(ctx, ch.TokenHash); err == nil && n >= challengeMaxAttempts {
+		_ = s.twoFA.DeleteLoginChallenge(ctx, ch.TokenHash)
+	}
+}
+
+// finishSecondFactor consumes ch and issues the session it was for.
+// Taking the challenge makes sure one answer yields one session.
+func (s *Service) finishSecondFactor(ctx context.Context, ch domain.LoginChallenge, method, ip, ua string) (domain.Session, error) {
+	if err := s.twoFA.TakeLoginChallenge(ctx, ch.TokenHash); err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.Session{}, domain.ErrTokenInvalid
+		}
+		return domain.Session{}, fmt.Errorf("auth: take challenge: %w", err)
+	}
+
+	session, err := s.issueSession(ctx, ch.UserID, ip, ua)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("auth: login issue session: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &ch.UserID,
+		Action:    domain.ActionAuthLogin,
+		Payload:   mustJSON(map[string]any{"second_factor": method}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	s.grantGroupRoles(ctx, ch.UserID, ch.OIDCGroups, ip, ua)
+	return session, nil
+}
+
+// checkSecondFactor verifies a TOTP code or, when recovery is set, burns
+// a recovery code. Failures are rate-limited and audited; the method
+// used is returned on success.
+func (s *Service) checkSecondFactor(ctx context.Context, userID uuid.UUID, code, recovery, flow, ip, ua string) (string, error) {
+	if !s.rlCode.Allow(userID.String()) {
+		s.recordCodeFailure(ctx, userID, flow, "rate_limited", ip, ua)
+		return "", fmt.Errorf("auth: code attempts throttled: %w", domain.ErrRateLimited)
+	}
+	now := time.Now().UTC()
+	method, ok := methodTOTP, false
+	if strings.TrimSpace(recovery) != "" {
+		method = methodRecoveryCode
+		used, err := s.twoFA.UseRecoveryCode(ctx, userID, hashRecoveryCode(recovery), now)
+		if err != nil {
+			return "", fmt.Errorf("auth: recovery code: %w", err)
+		}
+		ok = used
+	} else {
+		enr, err := s.twoFA.GetTOTP(ctx, userID)
+		if err != nil && !errors.Is(err, domain.ErrNotFound) {
+			return "", fmt.Errorf("auth: totp lookup: %w", err)
+		}
+		if err == nil && enr.ConfirmedAt != nil {
+			if step, match := matchTOTP(enr.Secret, code, now); match {
+				// A code is good once: a second use within its window
+				// is a replay.
+				if ok, err = s.twoFA.AdvanceTOTPStep(ctx, userID, step); err != nil {
+					return "", fmt.Errorf("auth: totp step: %w", err)
+				}
+			}
+		}
+	}
+	if !ok {
+		s.recordCodeFailure(ctx, userID, flow, method, ip, ua)
+		return "", domain.ErrInvalidCredentials
+	}
+	s.rlCode.Reset(userID.String())
+	return method, nil
+}
+
+func (s *Service) recordCodeFailure(ctx context.Context, userID uuid.UUID, flow, reason, ip, ua string) {
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuth2FAFailed,
+		Payload:   mustJSON(map[string]any{"in": flow, "reason": reason}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+}
+
+// TwoFactorStatus reports the user's enrolment and the server policy.
+func (s *Service) TwoFactorStatus(ctx context.Context, userID uuid.UUID) (TwoFactorStatus, error) {
+	if s.twoFA == nil {
+		return TwoFactorStatus{}, errTwoFactorUnavailable
+	}
+	st := TwoFactorStatus{Required: s.cfg.TwoFactorPolicy == TwoFactorRequired}
+	enr, err := s.twoFA.GetTOTP(ctx, userID)
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+	case err != nil:
+		return TwoFactorStatus{}, fmt.Errorf("auth: 2fa status: %w", err)
+	default:
+		st.TOTPEnabled = enr.ConfirmedAt != nil
+		st.TOTPPending = !st.TOTPEnabled
+		st.TOTPConfirmedAt = enr.ConfirmedAt
+	}
+	if st.TOTPEnabled {
+		if st.RecoveryCodesLeft, err = s.twoFA.CountRecoveryCodes(ctx, userID); err != nil {
+			return TwoFactorStatus{}, fmt.Errorf("auth: 2fa status: %w", err)
+		}
+	}
+	if s.passkeys != nil {
+		creds, err := s.passkeys.ListCredentials(ctx, userID)
+		if err != nil {
+			return TwoFactorStatus{}, fmt.Errorf("auth: 2fa status: %w", err)
+		}
+		st.WebAuthnCredentials = len(creds)
+	}
+	st.Enabled = st.TOTPEnabled || st.WebAuthnCredentials > 0
+	return st, nil
+}
+
+// BeginTOTP starts enrolment: a fresh secret and its otpauth:// URI.
+// Nothing changes for the user until ConfirmTOTP. ErrConflict when TOTP
+// is already on.
+func (s *Service) BeginTOTP(ctx context.Context, userID uuid.UUID) (secret, uri string, err error) {
+	if s.twoFA == nil {
+		return "", "", errTwoFactorUnavailable
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return "", "", fmt.Errorf("auth: begin totp: %w", err)
+	}
+	if secret, err = newTOTPSecret(); err != nil {
+		return "", "", err
+	}
+	if err := s.twoFA.BeginTOTP(ctx, userID, secret); err != nil {
+		return "", "", fmt.Errorf("auth: begin totp: %w", err)
+	}
+	return secret, totpURI(s.cfg.TOTPIssuer, user.Username, secret), nil
+}
+
+// ConfirmTOTP turns 2FA on once code proves the authenticator has the
+// pending secret, and returns the recovery codes — shown this once.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code, ip, ua string) ([]string, error) {
+	if s.twoFA == nil {
+		return nil, errTwoFactorUnavailable
+	}
+	enr, err := s.twoFA.GetTOTP(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("auth: confirm totp: %w", err)
+	}
+	if enr.ConfirmedAt != nil {
+		return nil, fmt.Errorf("auth: totp already confirmed: %w", domain.ErrConflict)
+	}
+	if !s.rlCode.Allow(userID.String()) {
+		s.recordCodeFailure(ctx, userID, "confirm", "rate_limited", ip, ua)
+		return nil, fmt.Errorf("auth: code attempts throttled: %w", domain.ErrRateLimited)
+	}
+	now := time.Now().UTC()
+	step, ok := matchTOTP(enr.Secret, code, now)
+	if !ok {
+		s.recordCodeFailure(ctx, userID, "confirm", "totp", ip, ua)
+		return nil, domain.ErrInvalidCredentials
+	}
+	s.rlCode.Reset(userID.String())
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.twoFA.ConfirmTOTP(ctx, userID, step, hashes, now); err != nil {
+		return nil, fmt.Errorf("auth: confirm totp: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuth2FAEnrol,
+		Payload:   mustJSON(map[string]any{"method": "totp"}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off. Both the password and a current code (or a
+// recovery code) are required, so a hijacked session alone cannot.
+func (s *Service) DisableTOTP(ctx context.Context, userID uuid.UUID, password, code, recovery, ip, ua string) error {
+	if s.twoFA == nil {
+		return errTwoFactorUnavailable
+	}
+	if err := s.CheckPassword(ctx, userID, password); err != nil {
+		return err
+	}
+	if _, err := s.checkSecondFactor(ctx, userID, code, recovery, "disable", ip, ua); err != nil {
+		return err
+	}
+	if err := s.twoFA.DeleteTOTP(ctx, userID); err != nil {
+		return fmt.Errorf("auth: disable totp: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuth2FADisable,
+		Payload:   mustJSON(map[string]any{"method": "totp"}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after a
+// current TOTP code proves possession.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code, ip, ua string) ([]string, error) {
+	if s.twoFA == nil {
+		return nil, errTwoFactorUnavailable
+	}
+	if _, err := s.checkSecondFactor(ctx, userID, code, "", "recovery_codes", ip, ua); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.twoFA.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, fmt.Errorf("auth: regenerate recovery codes: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuth2FARecovery,
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return codes, nil
+}
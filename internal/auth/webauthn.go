@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/webauthn"
+)
+
+// ---- WebAuthn --------------------------------------------------------------
+
+// A registered WebAuthn credential serves two ways. On its own it is a
+// passwordless login, for which the authenticator must verify the user
+// (PIN or biometric), since it replaces both password and second
+// factor. After a password it is a second factor, where presence is
+// enough. Having any credential turns 2FA on for the account.
+
+// Ceremony kinds, stored with each challenge so a response cannot be
+// replayed into a different flow.
+const (
+	ceremonyRegister     = "register"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "2fa"
+
+	webauthnNameMax = 64
+)
+
+// SetWebAuthn enables WebAuthn for rp. Without a store or a configured
+// relying party the ceremonies fail.
+func (s *Service) SetWebAuthn(store WebAuthnStore, rp webauthn.RelyingParty) {
+	s.passkeys = store
+	s.rp = rp
+}
+
+var errWebAuthnUnavailable = errors.New("auth: webauthn is not configured")
+
+func (s *Service) webauthnReady() bool { return s.passkeys != nil && s.rp.Enabled() }
+
+// newCeremony stores a fresh challenge for kind.
+func (s *Service) newCeremony(ctx context.Context, kind string, userID *uuid.UUID) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	err = s.passkeys.CreateChallenge(ctx, domain.WebAuthnChallenge{
+		Challenge: challenge,
+		Kind:      kind,
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(webauthn.Timeout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("auth: persist webauthn challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// takeCeremony consumes the ceremony a response answers. userID, when
+// set, must be the user it was started for.
+func (s *Service) takeCeremony(ctx context.Context, challenge []byte, kind string, userID *uuid.UUID) (domain.WebAuthnChallenge, error) {
+	c, err := s.passkeys.TakeChallenge(ctx, challenge)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.WebAuthnChallenge{}, domain.ErrTokenInvalid
+		}
+		return domain.WebAuthnChallenge{}, fmt.Errorf("auth: webauthn challenge lookup: %w", err)
+	}
+	if c.Kind != kind {
+		return domain.WebAuthnChallenge{}, domain.ErrTokenInvalid
+	}
+	if userID != nil && (c.UserID == nil || *c.UserID != *userID) {
+		return domain.WebAuthnChallenge{}, domain.ErrTokenInvalid
+	}
+	if !c.ExpiresAt.After(time.Now()) {
+		return domain.WebAuthnChallenge{}, domain.ErrTokenExpired
+	}
+	return c, nil
+}
+
+func descriptors(creds []domain.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	out := make([]webauthn.CredentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		out = append(out, webauthn.Descriptor(c.CredentialID, c.Transports))
+	}
+	return out
+}
+
+// BeginWebAuthnRegistration starts adding a credential to the user.
+func (s *Service) BeginWebAuthnRegistration(ctx context.Context, userID uuid.UUID) (webauthn.CreationOptions, error) {
+	if !s.webauthnReady() {
+		return webauthn.CreationOptions{}, errWebAuthnUnavailable
+	}
+	user, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, fmt.Errorf("auth: begin webauthn registration: %w", err)
+	}
+	existing, err := s.passkeys.ListCredentials(ctx, userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, fmt.Errorf("auth: begin webauthn registration: %w", err)
+	}
+	challenge, err := s.newCeremony(ctx, ceremonyRegister, &userID)
+	if err != nil {
+		return webauthn.CreationOptions{}, err
+	}
+	display := user.DisplayName
+	if display == "" {
+		display = user.Username
+	}
+	return s.rp.CreationOptions(challenge, webauthn.User{
+		ID:          userID[:],
+		Name:        user.Username,
+		DisplayName: display,
+	}, descriptors(existing)), nil
+}
+
+// FinishWebAuthnRegistration verifies the authenticator's response and
+// stores the credential under name.
+func (s *Service) FinishWebAuthnRegistration(ctx context.Context, userID uuid.UUID, name string, resp webauthn.AttestationResponse, ip, ua string) (domain.WebAuthnCredential, error) {
+	if !s.webauthnReady() {
+		return domain.WebAuthnCredential{}, errWebAuthnUnavailable
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > webauthnNameMax {
+		return domain.WebAuthnCredential{}, fmt.Errorf("auth: credential name too long: %w", domain.ErrValidation)
+	}
+	challenge, err := resp.Challenge()
+	if err != nil {
+		return domain.WebAuthnCredential{}, domain.ErrInvalidCredentials
+	}
+	if _, err := s.takeCeremony(ctx, challenge, ceremonyRegister, &userID); err != nil {
+		return domain.WebAuthnCredential{}, err
+	}
+	verified, err := s.rp.VerifyRegistration(resp, challenge, false)
+	if err != nil {
+		s.cfg.Logger.Debug().Err(err).Msg("auth: webauthn registration rejected")
+		return domain.WebAuthnCredential{}, domain.ErrInvalidCredentials
+	}
+	cred := domain.WebAuthnCredential{
+		ID:           uuid.New(),
+		UserID:       userID,
+		Name:         name,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    verified.SignCount,
+		Transports:   verified.Transports,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := s.passkeys.CreateCredential(ctx, cred); err != nil {
+		return domain.WebAuthnCredential{}, fmt.Errorf("auth: store webauthn credential: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuthWebAuthnAdd,
+		Payload:   mustJSON(map[string]any{"credential_id": cred.ID, "name": cred.Name}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return cred, nil
+}
+
+// ListWebAuthnCredentials returns the user's credentials.
+func (s *Service) ListWebAuthnCredentials(ctx context.Context, userID uuid.UUID) ([]domain.WebAuthnCredential, error) {
+	if s.passkeys == nil {
+		return nil, errWebAuthnUnavailable
+	}
+	out, err := s.passkeys.ListCredentials(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("auth: list webauthn credentials: %w", err)
+	}
+	return out, nil
+}
+
+// RemoveWebAuthnCredential deletes one of the user's credentials. Like
+// disabling TOTP it needs the password, so a hijacked session cannot
+// strip the account's second factor.
+func (s *Service) RemoveWebAuthnCredential(ctx context.Context, userID, id uuid.UUID, password, ip, ua string) error {
+	if s.passkeys == nil {
+		return errWebAuthnUnavailable
+	}
+	if err := s.CheckPassword(ctx, userID, password); err != nil {
+		return err
+	}
+	cred, err := s.passkeys.DeleteCredential(ctx, userID, id)
+	if err != nil {
+		return fmt.Errorf("auth: remove webauthn credential: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &userID,
+		Action:    domain.ActionAuthWebAuthnRemove,
+		Payload:   mustJSON(map[string]any{"credential_id": cred.ID, "name": cred.Name}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return nil
+}
+
+// BeginWebAuthnLogin starts a passwordless login. No username is asked
+// for: the browser offers the user's discoverable credentials for this
+// site, so nothing here reveals whether an account exists.
+func (s *Service) BeginWebAuthnLogin(ctx context.Context) (webauthn.RequestOptions, error) {
+	if !s.webauthnReady() {
+		return webauthn.RequestOptions{}, errWebAuthnUnavailable
+	}
+	challenge, err := s.newCeremony(ctx, ceremonyLogin, nil)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	return s.rp.RequestOptions(challenge, nil, "required"), nil
+}
+
+// FinishWebAuthnLogin verifies a passwordless login and issues the
+// session.
+func (s *Service) FinishWebAuthnLogin(ctx context.Context, resp webauthn.AssertionResponse, ip, ua string) (domain.Session, error) {
+	if !s.webauthnReady() {
+		return domain.Session{}, errWebAuthnUnavailable
+	}
+	in := LoginInput{IP: ip, UserAgent: ua}
+	if !s.rlPasskey.Allow(ip) {
+		s.recordLoginFailure(ctx, nil, in, "rate_limited_ip")
+		return domain.Session{}, fmt.Errorf("auth: ip throttled: %w", domain.ErrRateLimited)
+	}
+	challenge, err := resp.Challenge()
+	if err != nil {
+		return domain.Session{}, domain.ErrInvalidCredentials
+	}
+	if _, err := s.takeCeremony(ctx, challenge, ceremonyLogin, nil); err != nil {
+		return domain.Session{}, err
+	}
+	cred, err := s.assertedCredential(ctx, resp, nil)
+	if err != nil {
+		s.recordLoginFailure(ctx, nil, in, "webauthn_unknown_credential")
+		return domain.Session{}, err
+	}
+	as, err := s.verifyAssertion(ctx, cred, resp, challenge, true)
+	if err != nil {
+		s.recordLoginFailure(ctx, &cred.UserID, in, "webauthn_failed")
+		return domain.Session{}, err
+	}
+	if as.UserHandle != nil && !bytes.Equal(as.UserHandle, cred.UserID[:]) {
+		s.recordLoginFailure(ctx, &cred.UserID, in, "webauthn_failed")
+		return domain.Session{}, domain.ErrInvalidCredentials
+	}
+
+	session, err := s.issueSession(ctx, cred.UserID, ip, ua)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("auth: login issue session: %w", err)
+	}
+	s.recordAudit(ctx, domain.AuditEntry{
+		UserID:    &cred.UserID,
+		Action:    domain.ActionAuthLogin,
+		Payload:   mustJSON(map[string]any{"method": methodWebAuthn, "credential_id": cred.ID}),
+		IP:        nilIfEmpty(ip),
+		UserAgent: nilIfEmpty(ua),
+	})
+	return session, nil
+}
+
+// BeginWebAuthnSecondFactor starts the WebAuthn ceremony for a login
+// that answered 2fa_required.
+func (s *Service) BeginWebAuthnSecondFactor(ctx context.Context, loginChallenge string) (webauthn.RequestOptions, error) {
+	if !s.webauthnReady() {
+		return webauthn.RequestOptions{}, errWebAuthnUnavailable
+	}
+	ch, err := s.loginChallenge(ctx, loginChallenge)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	creds, err := s.passkeys.ListCredentials(ctx, ch.UserID)
+	if err != nil {
+		return webauthn.RequestOptions{}, fmt.Errorf("auth: begin webauthn 2fa: %w", err)
+	}
+	if len(creds) == 0 {
+		return webauthn.RequestOptions{}, fmt.Errorf("auth: no webauthn credentials: %w", domain.ErrNotFound)
+	}
+	challenge, err := s.newCeremony(ctx, ceremonySecondFactor, &ch.UserID)
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+	return s.rp.RequestOptions(challenge, descriptors(creds), "discouraged"), nil
+}
+
+// FinishWebAuthnSecondFactor completes a two-step login with a WebAuthn
+// assertion instead of a code.
+func (s *Service) FinishWebAuthnSecondFactor(ctx context.Context, loginChallenge string, resp webauthn.AssertionResponse, ip, ua string) (domain.Session, error) {
+	if !s.webauthnReady() {
+		return domain.Session{}, errWebAuthnUnavailable
+	}
+	ch, err := s.loginChallenge(ctx, loginChallenge)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	if !s.rlCode.Allow(ch.UserID.String()) {
+		s.recordCodeFailure(ctx, ch.UserID, "login", "rate_limited", ip, ua)
+		return domain.Session{}, fmt.Errorf("auth: code attempts throttled: %w", domain.ErrRateLimited)
+	}
+	challenge, err := resp.Challenge()
+	if err != nil {
+		return domain.Session{}, domain.ErrInvalidCredentials
+	}
+	if _, err := s.takeCeremony(ctx, challenge, ceremonySecondFactor, &ch.UserID); err != nil {
+		return domain.Session{}, err
+	}
+	cred, err := s.assertedCredential(ctx, resp, &ch.UserID)
+	if err == nil {
+		_, err = s.verifyAssertion(ctx, cred, resp, challenge, false)
+	}
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCredentials) {
+			s.failChallenge(ctx, ch)
+			s.recordCodeFailure(ctx, ch.UserID, "login", methodWebAuthn, ip, ua)
+		}
+		return domain.Session{}, err
+	}
+	s.rlCode.Reset(ch.UserID.String())
+	return s.finishSecondFactor(ctx, ch, methodWebAuthn, ip, ua)
+}
+
+// assertedCredential loads the credential a response claims to come
+// from, which must belong to owner when set.
+func (s *Service) assertedCredential(ctx context.Context, resp webauthn.AssertionResponse, owner *uuid.UUID) (domain.WebAuthnCredential, error) {
+	id, err := resp.CredentialID()
+	if err != nil {
+		return domain.WebAuthnCredential{}, domain.ErrInvalidCredentials
+	}
+	cred, err := s.passkeys.GetCredential(ctx, id)
+	if err != nil {
+		if errors.Is(err, domain.ErrNotFound) {
+			return domain.WebAuthnCredential{}, domain.ErrInvalidCredentials
+		}
+		return domain.WebAuthnCredential{}, fmt.Errorf("auth: webauthn credential lookup: %w", err)
+	}
+	if owner != nil && cred.UserID != *owner {
+		return domain.WebAuthnCredential{}, domain.ErrInvalidCredentials
+	}
+	return cred, nil
+}
+
+// verifyAssertion checks resp against cred and records the new counter.
+func (s *Service) verifyAssertion(ctx context.Context, cred domain.WebAuthnCredential, resp webauthn.AssertionResponse, challenge []byte, requireUV bool) (webauthn.Assertion, error) {
+	as, err := s.rp.VerifyAssertion(resp, challenge, cred.PublicKey, cred.SignCount, requireUV)
+	if err != nil {
+		s.cfg.Logger.Debug().Err(err).Stringer("credential", cred.ID).Msg("auth: webauthn assertion rejected")
+		return webauthn.Assertion{}, domain.ErrInvalidCredentials
+	}
+	if err := s.passkeys.RecordCredentialUse(ctx, cred.ID, as.SignCount, time.Now().UTC()); err != nil {
+		return webauthn.Assertion{}, fmt.Errorf("auth: record webauthn use: %w", err)
+	}
+	return as, nil
+}
@@ -24,6 +24,27 @@ import (
 // session is resolved.
 const userIDKey = "lumi.user_id"
 
+// sessionIDKey holds the public id of the login session behind the
+// request, so long-lived connections can be cut when it is revoked.
+const sessionIDKey = "lumi.session_id"
+
+// scopeKey holds the Scope of a request authenticated with a personal
+// access token. Session requests carry none.
+const scopeKey = "lumi.scope"
+
+// Scope narrows a request below the caller's role: only VaultID (when
+// set) is reachable, and only capabilities both the role and
+// Capabilities grant are held.
+type Scope struct {
+	VaultID      *uuid.UUID
+	Capabilities domain.CapabilitySet
+}
+
+// AllowsVault reports whether the scope reaches vaultID.
+func (s Scope) AllowsVault(vaultID uuid.UUID) bool {
+	return s.VaultID == nil || *s.VaultID == vaultID
+}
+
 // Resolver returns the role a user holds within a vault. Returns
 // domain.ErrNotFound when the user is not a member.
 type Resolver interface {
@@ -31,6 +52,7 @@ type Resolver interface {
 }
 
 // Require enforces that the authenticated caller holds cap inside vaultID.
+// A token-scoped request must also be allowed cap in vaultID by its Scope.
 // On denial it writes a 403 JSON body and returns domain.ErrForbidden so
 // callers can short-circuit. Membership absence is mapped to forbidden
 // (not 404) to avoid leaking which vault IDs exist on the server.
@@ -39,6 +61,9 @@ func Require(c *fiber.Ctx, r Resolver, vaultID uuid.UUID, cap domain.Capability)
 	if !ok {
 		return respondForbidden(c, cap)
 	}
+	if sc, ok := ScopeFrom(c); ok && (!sc.AllowsVault(vaultID) || !sc.Capabilities.Has(cap)) {
+		return respondForbidden(c, cap)
+	}
 	role, err := r.RoleForUser(c.UserContext(), vaultID, uid)
 	if err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
@@ -59,6 +84,9 @@ func RequireMembership(c *fiber.Ctx, r Resolver, vaultID uuid.UUID) error {
 	if !ok {
 		return respondForbidden(c, "")
 	}
+	if sc, ok := ScopeFrom(c); ok && !sc.AllowsVault(vaultID) {
+		return respondForbidden(c, "")
+	}
 	if _, err := r.RoleForUser(c.UserContext(), vaultID, uid); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			return respondForbidden(c, "")
@@ -124,6 +152,43 @@ func SetUserID(c *fiber.Ctx, id uuid.UUID) {
 	c.Locals(userIDKey, id)
 }
 
+// SetSessionID records the public id of the request's session. Token
+// requests have none.
+func SetSessionID(c *fiber.Ctx, id uuid.UUID) {
+	c.Locals(sessionIDKey, id)
+}
+
+// SessionIDFrom returns the id recorded by SetSessionID.
+func SessionIDFrom(c *fiber.Ctx) (uuid.UUID, bool) {
+	id, ok := c.Locals(sessionIDKey).(uuid.UUID)
+	return id, ok
+}
+
+// SetScope marks the request as authenticated by a scoped token. The
+// auth middleware calls this alongside SetUserID.
+func SetScope(c *fiber.Ctx, s Scope) {
+	c.Locals(scopeKey, s)
+}
+
+// ScopeFrom returns the request's token scope; ok is false for session
+// requests, which are bounded by the role alone.
+func ScopeFrom(c *fiber.Ctx) (Scope, bool) {
+	s, ok := c.Locals(scopeKey).(Scope)
+	return s, ok
+}
+
+// SessionOnly is a middleware for account-level routes no capability
+// covers (password, tokens, vault creation, erasure): requests made
+// with a personal access token get 403 session_required.
+func SessionOnly() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := ScopeFrom(c); ok {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "session_required"})
+		}
+		return c.Next()
+	}
+}
+
 // RequireCapability is a middleware factory: builds a fiber.Handler that
 // enforces cap on the vault id parsed from the URL.
 func RequireCapability(r Resolver, cap domain.Capability) fiber.Handler {
@@ -0,0 +1,96 @@
+package capguard
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+type fixedResolver struct {
+	vaultID uuid.UUID
+	caps    domain.CapabilitySet
+}
+
+func (r fixedResolver) RoleForUser(_ context.Context, vaultID, _ uuid.UUID) (domain.Role, error) {
+	if vaultID != r.vaultID {
+		return domain.Role{}, domain.ErrNotFound
+	}
+	return domain.Role{VaultID: vaultID, Capabilities: r.caps}, nil
+}
+
+// status runs one request against a route guarded by cap, with the
+// caller bound as auth would bind it.
+func status(t *testing.T, r Resolver, vaultID uuid.UUID, scope *Scope, cap domain.Capability) int {
+	t.Helper()
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		SetUserID(c, uuid.New())
+		if scope != nil {
+			SetScope(c, *scope)
+		}
+		return c.Next()
+	})
+	app.Get("/vaults/:vault", RequireCapability(r, cap), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	resp, err := app.Test(httptest.NewRequest("GET", "/vaults/"+vaultID.String(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestRequireIntersectsTokenScope(t *testing.T) {
+	vault, other := uuid.New(), uuid.New()
+	editor := fixedResolver{vaultID: vault, caps: domain.SeedRoles()["Editor"]}
+
+	cases := []struct {
+		name  string
+		scope *Scope
+		cap   domain.Capability
+		want  int
+	}{
+		{"session uses role", nil, domain.CapNoteEdit, fiber.StatusNoContent},
+		{"token within role", &Scope{Capabilities: domain.CapabilitySet{domain.CapNoteRead}}, domain.CapNoteRead, fiber.StatusNoContent},
+		{"token narrower than role", &Scope{Capabilities: domain.CapabilitySet{domain.CapNoteRead}}, domain.CapNoteEdit, fiber.StatusForbidden},
+		{"token wider than role", &Scope{Capabilities: domain.CapabilitySet{domain.CapAll}}, domain.CapVaultManage, fiber.StatusForbidden},
+		{"token wildcard", &Scope{Capabilities: domain.CapabilitySet{domain.CapNoteAll}}, domain.CapNoteDelete, fiber.StatusNoContent},
+		{"token for this vault", &Scope{VaultID: &vault, Capabilities: domain.CapabilitySet{domain.CapAll}}, domain.CapNoteRead, fiber.StatusNoContent},
+		{"token for another vault", &Scope{VaultID: &other, Capabilities: domain.CapabilitySet{domain.CapAll}}, domain.CapNoteRead, fiber.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := status(t, editor, vault, tc.scope, tc.cap); got != tc.want {
+				t.Fatalf("status = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSessionOnlyRejectsTokens(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Get("X-Scoped") != "" {
+			SetScope(c, Scope{Capabilities: domain.CapabilitySet{domain.CapAll}})
+		}
+		return c.Next()
+	})
+	app.Post("/vaults", SessionOnly(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusCreated)
+	})
+
+	req := httptest.NewRequest("POST", "/vaults", nil)
+	if resp, _ := app.Test(req); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("session request: status = %d", resp.StatusCode)
+	}
+	req = httptest.NewRequest("POST", "/vaults", nil)
+	req.Header.Set("X-Scoped", "1")
+	if resp, _ := app.Test(req); resp.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("token request: status = %d", resp.StatusCode)
+	}
+}
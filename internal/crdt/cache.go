@@ -0,0 +1,244 @@
+package crdt
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// DefaultCacheBytes is the document cache budget used when the Registry
+// is constructed without WithCacheBytes. 64 MiB holds a few thousand
+// typical notes; the estimate is the encoded size of everything the
+// document absorbed, which tracks the yrs heap footprint within a small
+// constant factor.
+const DefaultCacheBytes int64 = 64 << 20
+
+// CacheStats is a point-in-time view of the document cache counters.
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"max_bytes"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// HitRate returns hits / (hits + misses), or 0 before the first lookup.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+type docKey struct {
+	vaultID uuid.UUID
+	noteID  string
+}
+
+type cacheEntry struct {
+	key  docKey
+	doc  *Doc // the cache's own handle; borrowers get Share()d copies
+	size int64
+}
+
+// docCache is a byte-budgeted LRU of live documents keyed by
+// (vault_id, note_id). The cache owns one handle per entry; eviction
+// closes that handle, which only destroys the YDoc once every borrower
+// (a wsync Room, an in-flight REST request) has closed theirs too.
+//
+// epoch is bumped whenever an entry is dropped for correctness rather
+// than space (invalidation, or a write through a document that is not
+// the cached one). A load that started before the bump may have read a
+// stale log and must not be cached; see Registry.LoadDoc.
+type docCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[docKey]*list.Element
+	epoch    uint64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func newDocCache(maxBytes int64) *docCache {
+	return &docCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[docKey]*list.Element),
+	}
+}
+
+// get returns a fresh handle onto the cached document, or nil on miss.
+func (c *docCache) get(k docKey) *Doc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		c.misses.Add(1)
+		return nil
+	}
+	d := el.Value.(*cacheEntry).doc.Share()
+	if d == nil {
+		// Cannot happen while the cache holds its handle, but never hand
+		// out a dead doc.
+		c.removeElement(el)
+		c.misses.Add(1)
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	c.hits.Add(1)
+	return d
+}
+
+// currentEpoch returns the invalidation epoch. Capture it before reading
+// storage and pass it to add.
+func (c *docCache) currentEpoch() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// add offers doc for caching and returns the handle the caller should
+// use. When another loader won the race the caller's doc is closed and
+// a handle onto the winner is returned instead. Documents loaded before
+// an invalidation (epoch moved) are returned uncached.
+func (c *docCache) add(k docKey, doc *Doc, size int64, epoch uint64) *Doc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		if shared := el.Value.(*cacheEntry).doc.Share(); shared != nil {
+			c.ll.MoveToFront(el)
+			_ = doc.Close()
+			return shared
+		}
+		c.removeElement(el)
+	}
+	if epoch != c.epoch {
+		return doc
+	}
+	own := doc.Share()
+	if own == nil {
+		return doc
+	}
+	c.items[k] = c.ll.PushFront(&cacheEntry{key: k, doc: own, size: size})
+	c.bytes += size
+	c.evictOverBudget()
+	return doc
+}
+
+// noteWrite records that update bytes were persisted through doc. When
+// doc is the cached document the entry grows by delta; otherwise the
+// cached copy (if any) missed the write and is dropped.
+func (c *docCache) noteWrite(k docKey, doc *Doc, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		c.epoch++
+		return
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.doc.SameDocument(doc) {
+		c.epoch++
+		c.removeElement(el)
+		return
+	}
+	e.size += delta
+	c.bytes += delta
+	c.evictOverBudget()
+}
+
+// resize replaces the size estimate for an entry, e.g. after compaction
+// folded the log into a (smaller) snapshot.
+func (c *docCache) resize(k docKey, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		return
+	}
+	e := el.Value.(*cacheEntry)
+	c.bytes += size - e.size
+	e.size = size
+}
+
+// remove drops a single entry.
+func (c *docCache) remove(k docKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	if el, ok := c.items[k]; ok {
+		c.removeElement(el)
+	}
+}
+
+// removeVault drops every entry belonging to vaultID.
+func (c *docCache) removeVault(vaultID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for k, el := range c.items {
+		if k.vaultID == vaultID {
+			c.removeElement(el)
+		}
+	}
+}
+
+// purge drops every entry. Used at shutdown.
+func (c *docCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for _, el := range c.items {
+		c.removeElement(el)
+	}
+}
+
+func (c *docCache) stats() CacheStats {
+	c.mu.Lock()
+	entries, bytes := len(c.items), c.bytes
+	c.mu.Unlock()
+	return CacheStats{
+		Entries:   entries,
+		Bytes:     bytes,
+		MaxBytes:  c.maxBytes,
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+	}
+}
+
+// evictOverBudget pops least-recently-used entries until the budget
+// holds. Entries somebody still borrows (a live wsync Room, an in-flight
+// request) are pinned: evicting them would let the next LoadDoc build a
+// second YDoc for the same note that the borrower's writes never reach.
+// The budget may therefore be exceeded while every entry is in use.
+// Caller holds c.mu.
+func (c *docCache) evictOverBudget() {
+	el := c.ll.Back()
+	for el != nil && c.bytes > c.maxBytes {
+		prev := el.Prev()
+		if !el.Value.(*cacheEntry).doc.borrowed() {
+			c.removeElement(el)
+			c.evictions.Add(1)
+		}
+		el = prev
+	}
+}
+
+// removeElement unlinks el and closes the cache's handle. Caller holds
+// c.mu.
+func (c *docCache) removeElement(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+	_ = e.doc.Close()
+}
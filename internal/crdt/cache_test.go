@@ -0,0 +1,309 @@
+package crdt
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// memStore is an in-memory SnapshotRepo for registry tests.
+type memStore struct {
+	mu         sync.Mutex
+	snapshots  map[docKey][]byte
+	updates    map[docKey][]UpdateRow
+	nextID     int64
+	failAppend bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		snapshots: map[docKey][]byte{},
+		updates:   map[docKey][]UpdateRow{},
+	}
+}
+
+var errNoSnapshot = errors.New("no snapshot")
+
+func (m *memStore) GetSnapshot(_ context.Context, v uuid.UUID, n string) (SnapshotRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.snapshots[docKey{v, n}]; ok {
+		return SnapshotRow{State: append([]byte(nil), s...)}, nil
+	}
+	return SnapshotRow{}, errNoSnapshot
+}
+
+func (m *memStore) UpsertSnapshot(_ context.Context, v uuid.UUID, n string, state []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[docKey{v, n}] = append([]byte(nil), state...)
+	return nil
+}
+
+func (m *memStore) AppendUpdate(_ context.Context, v uuid.UUID, n string, u []byte, _ uuid.UUID, origin string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failAppend {
+		return 0, errors.New("append failed")
+	}
+	m.nextID++
+	k := docKey{v, n}
+	m.updates[k] = append(m.updates[k], UpdateRow{ID: m.nextID, Update: append([]byte(nil), u...), OriginKind: origin})
+	return m.nextID, nil
+}
+
+func (m *memStore) ListUpdatesSince(_ context.Context, v uuid.UUID, n string, since int64, limit int) ([]UpdateRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []UpdateRow{}
+	for _, r := range m.updates[docKey{v, n}] {
+		if r.ID > since {
+			out = append(out, r)
+		}
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) CountUpdates(_ context.Context, v uuid.UUID, n string) (int, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b int64
+	for _, r := range m.updates[docKey{v, n}] {
+		b += int64(len(r.Update))
+	}
+	return len(m.updates[docKey{v, n}]), b, nil
+}
+
+func (m *memStore) DeleteUpdatesUpTo(_ context.Context, v uuid.UUID, n string, maxID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := docKey{v, n}
+	kept := m.updates[k][:0]
+	var deleted int64
+	for _, r := range m.updates[k] {
+		if r.ID > maxID {
+			kept = append(kept, r)
+		} else {
+			deleted++
+		}
+	}
+	m.updates[k] = kept
+	return deleted, nil
+}
+
+func (m *memStore) HighestUpdateID(_ context.Context, v uuid.UUID, n string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var max int64
+	for _, r := range m.updates[docKey{v, n}] {
+		if r.ID > max {
+			max = r.ID
+		}
+	}
+	return max, nil
+}
+
+func seed(t *testing.T, reg *Registry, v uuid.UUID, n, body string) {
+	t.Helper()
+	if err := reg.InitFromText(context.Background(), v, n, body, uuid.Nil, "snapshot-init"); err != nil {
+		t.Fatalf("InitFromText: %v", err)
+	}
+}
+
+func TestRegistryCacheSharesDoc(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(newMemStore())
+	v := uuid.New()
+	seed(t, reg, v, "a", "hello")
+
+	d1, err := reg.LoadDoc(ctx, v, "a")
+	if err != nil {
+		t.Fatalf("LoadDoc: %v", err)
+	}
+	defer d1.Close()
+	d2, err := reg.LoadDoc(ctx, v, "a")
+	if err != nil {
+		t.Fatalf("LoadDoc: %v", err)
+	}
+	if !d1.SameDocument(d2) {
+		t.Fatal("second LoadDoc did not hit the cache")
+	}
+
+	// Closing one handle must not tear down the shared document.
+	_ = d2.Close()
+	if txt, err := d1.Text(); err != nil || txt != "hello" {
+		t.Fatalf("Text after sibling Close = %q, %v", txt, err)
+	}
+	if _, err := d2.Text(); err == nil {
+		t.Fatal("closed handle still usable")
+	}
+
+	st := reg.CacheStats()
+	if st.Hits != 1 || st.Misses != 1 || st.Entries != 1 {
+		t.Fatalf("stats = %+v", st)
+	}
+	if st.HitRate() != 0.5 {
+		t.Fatalf("hit rate = %v", st.HitRate())
+	}
+}
+
+func TestRegistryCacheSeesWrites(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(newMemStore())
+	v := uuid.New()
+	seed(t, reg, v, "a", "one")
+
+	d, _ := reg.LoadDoc(ctx, v, "a")
+	upd, err := d.ApplyTextDiff("one two", "test")
+	if err != nil {
+		t.Fatalf("ApplyTextDiff: %v", err)
+	}
+	if err := reg.PersistChange(ctx, v, "a", upd, uuid.Nil, "test", d); err != nil {
+		t.Fatalf("PersistChange: %v", err)
+	}
+	_ = d.Close()
+
+	d2, _ := reg.LoadDoc(ctx, v, "a")
+	defer d2.Close()
+	if txt, _ := d2.Text(); txt != "one two" {
+		t.Fatalf("cached text = %q", txt)
+	}
+}
+
+func TestRegistryCacheInvalidate(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(newMemStore())
+	v := uuid.New()
+	seed(t, reg, v, "a", "old")
+
+	d1, _ := reg.LoadDoc(ctx, v, "a")
+	defer d1.Close()
+	seed(t, reg, v, "a", "new") // re-seed invalidates
+
+	d2, _ := reg.LoadDoc(ctx, v, "a")
+	defer d2.Close()
+	if d1.SameDocument(d2) {
+		t.Fatal("re-seeded note served from stale cache entry")
+	}
+	if txt, _ := d2.Text(); txt != "new" {
+		t.Fatalf("text = %q", txt)
+	}
+
+	reg.InvalidateVault(v)
+	if st := reg.CacheStats(); st.Entries != 0 {
+		t.Fatalf("entries after InvalidateVault = %d", st.Entries)
+	}
+}
+
+func TestRegistryCacheEvictsOverBudget(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(newMemStore(), WithCacheBytes(256))
+	v := uuid.New()
+	body := strings.Repeat("x", 100)
+	for _, n := range []string{"a", "b", "c", "d"} {
+		seed(t, reg, v, n, body)
+		d, err := reg.LoadDoc(ctx, v, n)
+		if err != nil {
+			t.Fatalf("LoadDoc %s: %v", n, err)
+		}
+		_ = d.Close()
+	}
+	st := reg.CacheStats()
+	if st.Bytes > st.MaxBytes {
+		t.Fatalf("bytes %d over budget %d", st.Bytes, st.MaxBytes)
+	}
+	if st.Evictions == 0 {
+		t.Fatalf("expected evictions, stats = %+v", st)
+	}
+}
+
+func TestRegistryCachePinsBorrowedDocs(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(newMemStore(), WithCacheBytes(128))
+	v := uuid.New()
+	body := strings.Repeat("y", 100)
+	seed(t, reg, v, "held", body)
+	held, _ := reg.LoadDoc(ctx, v, "held")
+	defer held.Close()
+
+	for _, n := range []string{"a", "b", "c"} {
+		seed(t, reg, v, n, body)
+		d, _ := reg.LoadDoc(ctx, v, n)
+		_ = d.Close()
+	}
+
+	again, _ := reg.LoadDoc(ctx, v, "held")
+	defer again.Close()
+	if !held.SameDocument(again) {
+		t.Fatal("borrowed document was evicted")
+	}
+}
+
+func TestRegistryPersistFailureDropsCachedDoc(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	reg := NewRegistry(store)
+	v := uuid.New()
+	seed(t, reg, v, "a", "base")
+
+	d, _ := reg.LoadDoc(ctx, v, "a")
+	upd, _ := d.ApplyTextDiff("base unsaved", "test")
+	store.failAppend = true
+	if err := reg.PersistChange(ctx, v, "a", upd, uuid.Nil, "test", d); err == nil {
+		t.Fatal("expected append failure")
+	}
+	_ = d.Close()
+	store.failAppend = false
+
+	d2, _ := reg.LoadDoc(ctx, v, "a")
+	defer d2.Close()
+	if txt, _ := d2.Text(); txt != "base" {
+		t.Fatalf("text after failed persist = %q, want storage state", txt)
+	}
+}
+
+func TestRegistryCacheDisabled(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(newMemStore(), WithCacheBytes(0))
+	v := uuid.New()
+	seed(t, reg, v, "a", "x")
+	d1, _ := reg.LoadDoc(ctx, v, "a")
+	defer d1.Close()
+	d2, _ := reg.LoadDoc(ctx, v, "a")
+	defer d2.Close()
+	if d1.SameDocument(d2) {
+		t.Fatal("cache disabled but documents shared")
+	}
+	if st := reg.CacheStats(); st != (CacheStats{}) {
+		t.Fatalf("stats = %+v", st)
+	}
+}
+
+func TestRegistryHasState(t *testing.T) {
+	store := newMemStore()
+	reg := NewRegistry(store)
+	ctx := context.Background()
+	v := uuid.New()
+
+	if ok, err := reg.HasState(ctx, v, "n"); err != nil || ok {
+		t.Fatalf("unseeded note: HasState = %v, %v", ok, err)
+	}
+	seed(t, reg, v, "n", "")
+	if ok, err := reg.HasState(ctx, v, "n"); err != nil || !ok {
+		t.Fatalf("seeded empty note: HasState = %v, %v", ok, err)
+	}
+	// An update log without a snapshot counts too.
+	if _, err := store.AppendUpdate(ctx, v, "log-only", []byte{0, 0}, uuid.Nil, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := reg.HasState(ctx, v, "log-only"); err != nil || !ok {
+		t.Fatalf("log-only note: HasState = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,509 @@
+package crdt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// DefaultCompactInterval is how often the Compactor scans the update log
+// for notes past either threshold. Busy notes are picked up sooner via
+// the registry's hot-note signal.
+const DefaultCompactInterval = time.Minute
+
+// DefaultSweepInterval is how often the Compactor walks every snapshot
+// looking for tombstone garbage. The sweep is paced (one batch per
+// tick of the compaction loop) so it never competes with live traffic.
+const DefaultSweepInterval = 6 * time.Hour
+
+// DefaultCompactBatch bounds how many notes one scan or sweep step
+// handles.
+const DefaultCompactBatch = 100
+
+// CompactionSource is the read side the Compactor scans. The pg
+// note_yjs store satisfies it through the composition-root adapter.
+// A uuid.Nil vaultID means "every vault".
+type CompactionSource interface {
+	// ListCompactionCandidates returns notes whose log has at least
+	// minCount rows or minBytes bytes, busiest first.
+	ListCompactionCandidates(ctx context.Context, vaultID uuid.UUID, minCount int, minBytes int64, limit int) ([]LogStat, error)
+	// ListSnapshotsAfter pages through snapshots ordered by
+	// (vault_id, note_id), starting strictly after the cursor.
+	ListSnapshotsAfter(ctx context.Context, vaultID uuid.UUID, after SnapshotCursor, limit int) ([]SnapshotStat, error)
+	// LogTotals aggregates the update log.
+	LogTotals(ctx context.Context, vaultID uuid.UUID) (LogTotals, error)
+}
+
+// CompactionLocker serialises the compaction of a note across replicas,
+// which all run the same scan. TryLockCompaction reports false when
+// another process holds the note; release must be called otherwise. The
+// pg note_yjs store satisfies it with an advisory lock.
+type CompactionLocker interface {
+	TryLockCompaction(ctx context.Context, vaultID uuid.UUID, noteID string) (release func(), ok bool, err error)
+}
+
+// LogStat is the update-log footprint of one note.
+type LogStat struct {
+	VaultID uuid.UUID
+	NoteID  string
+	Count   int
+	Bytes   int64
+}
+
+// SnapshotStat is the stored snapshot size of one note.
+type SnapshotStat struct {
+	VaultID uuid.UUID
+	NoteID  string
+	Bytes   int64
+}
+
+// SnapshotCursor is the sweep position. The zero value starts at the
+// beginning.
+type SnapshotCursor struct {
+	VaultID uuid.UUID
+	NoteID  string
+}
+
+// LogTotals aggregates the update log and snapshot tables.
+type LogTotals struct {
+	Notes         int   `json:"notes_with_log"`
+	Rows          int64 `json:"log_rows"`
+	Bytes         int64 `json:"log_bytes"`
+	Snapshots     int   `json:"snapshots"`
+	SnapshotBytes int64 `json:"snapshot_bytes"`
+}
+
+// CompactReport summarises one compaction pass.
+type CompactReport struct {
+	Scanned        int           `json:"scanned"`
+	Compacted      int           `json:"compacted"`
+	RowsDeleted    int64         `json:"rows_deleted"`
+	BytesReclaimed int64         `json:"bytes_reclaimed"`
+	Skipped        int           `json:"skipped"` // note busy in another pass
+	Failed         int           `json:"failed"`
+	Duration       time.Duration `json:"duration_ns"`
+}
+
+func (r *CompactReport) add(res CompactResult) {
+	if !res.Rewritten {
+		return
+	}
+	r.Compacted++
+	r.RowsDeleted += res.RowsDeleted
+	r.BytesReclaimed += res.Reclaimed()
+}
+
+// CompactorStats is the cumulative view exposed to operators.
+type CompactorStats struct {
+	Runs           uint64    `json:"runs"`
+	Compacted      uint64    `json:"compacted"`
+	RowsDeleted    uint64    `json:"rows_deleted"`
+	BytesReclaimed uint64    `json:"bytes_reclaimed"`
+	Failed         uint64    `json:"failed"`
+	HotPending     int       `json:"hot_pending"`
+	LastRunAt      time.Time `json:"last_run_at,omitempty"`
+	LastSweepAt    time.Time `json:"last_sweep_at,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// Compactor folds update logs into snapshots in the background, off the
+// write path. Three sources feed it:
+//
+//   - a periodic scan of note_yjs_updates for notes past
+//     CompactCountThreshold / CompactBytesThreshold;
+//   - the registry's hot-note signal, fired when a note has taken
+//     CompactCountThreshold writes since it was last signalled;
+//   - explicit requests (CompactVault, Trigger) from the admin surface.
+//
+// A slower sweep walks every snapshot and rewrites the ones whose
+// rebuilt state is noticeably smaller, i.e. that carry tombstone garbage.
+//
+// Per-note locking: a note is compacted by at most one goroutine at a
+// time, and with a CompactionLocker by at most one replica. A pass that
+// finds a note busy skips it rather than waiting; the concurrent pass is
+// doing the same work.
+type Compactor struct {
+	reg   *Registry
+	src   CompactionSource
+	log   zerolog.Logger
+	every time.Duration
+	sweep time.Duration
+	batch int
+
+	trigger chan struct{}
+
+	busyMu sync.Mutex
+	busy   map[docKey]struct{}
+	locker CompactionLocker // nil: this process is the only compactor
+
+	hotMu sync.Mutex
+	hot   map[docKey]struct{}
+
+	cursorMu sync.Mutex
+	cursor   SnapshotCursor
+	sweeping bool
+
+	runs           atomic.Uint64
+	compacted      atomic.Uint64
+	rowsDeleted    atomic.Uint64
+	bytesReclaimed atomic.Uint64
+	failed         atomic.Uint64
+
+	statMu    sync.Mutex
+	lastRun   time.Time
+	lastSweep time.Time
+	lastErr   string
+}
+
+// CompactorOption customises a Compactor at construction time.
+type CompactorOption func(*Compactor)
+
+// WithCompactInterval overrides DefaultCompactInterval.
+func WithCompactInterval(d time.Duration) CompactorOption {
+	return func(c *Compactor) {
+		if d > 0 {
+			c.every = d
+		}
+	}
+}
+
+// WithSweepInterval overrides DefaultSweepInterval. d <= 0 disables the
+// tombstone sweep.
+func WithSweepInterval(d time.Duration) CompactorOption {
+	return func(c *Compactor) { c.sweep = d }
+}
+
+// WithCompactBatch overrides DefaultCompactBatch.
+func WithCompactBatch(n int) CompactorOption {
+	return func(c *Compactor) {
+		if n > 0 {
+			c.batch = n
+		}
+	}
+}
+
+// WithCompactionLocker makes the Compactor take l's per-note lock around
+// every compaction. Required when several replicas share the database.
+func WithCompactionLocker(l CompactionLocker) CompactorOption {
+	return func(c *Compactor) { c.locker = l }
+}
+
+// NewCompactor wires a Compactor to the registry and installs the
+// registry's hot-note hook. reg and src must not be nil.
+func NewCompactor(reg *Registry, src CompactionSource, log zerolog.Logger, opts ...CompactorOption) *Compactor {
+	if reg == nil || src == nil {
+		panic("crdt.NewCompactor: missing dependency")
+	}
+	c := &Compactor{
+		reg:     reg,
+		src:     src,
+		log:     log.With().Str("component", "crdt-compactor").Logger(),
+		every:   DefaultCompactInterval,
+		sweep:   DefaultSweepInterval,
+		batch:   DefaultCompactBatch,
+		trigger: make(chan struct{}, 1),
+		busy:    make(map[docKey]struct{}),
+		hot:     make(map[docKey]struct{}),
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	reg.setHotHook(c.markHot)
+	return c
+}
+
+// Run drives the worker until ctx is cancelled. Call it in its own
+// goroutine.
+func (c *Compactor) Run(ctx context.Context) {
+	t := time.NewTicker(c.every)
+	defer t.Stop()
+	var nextSweep time.Time
+	if c.sweep > 0 {
+		nextSweep = time.Now().Add(c.sweep)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-c.trigger:
+		}
+		if _, err := c.RunOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			c.log.Warn().Err(err).Msg("compaction pass failed")
+		}
+		if c.sweep > 0 && !time.Now().Before(nextSweep) {
+			done, err := c.sweepStep(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				c.log.Warn().Err(err).Msg("tombstone sweep failed")
+			}
+			if done {
+				nextSweep = time.Now().Add(c.sweep)
+			}
+		}
+	}
+}
+
+// Trigger asks the worker to run a pass as soon as possible. Never
+// blocks; coalesces with a pending trigger.
+func (c *Compactor) Trigger() {
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Compactor) markHot(vaultID uuid.UUID, noteID string) {
+	c.hotMu.Lock()
+	c.hot[docKey{vaultID, noteID}] = struct{}{}
+	c.hotMu.Unlock()
+	c.Trigger()
+}
+
+// RunOnce compacts the notes signalled hot since the last pass plus one
+// batch of scan candidates across every vault.
+func (c *Compactor) RunOnce(ctx context.Context) (CompactReport, error) {
+	start := time.Now()
+	var rep CompactReport
+
+	c.hotMu.Lock()
+	hot := make([]docKey, 0, len(c.hot))
+	for k := range c.hot {
+		hot = append(hot, k)
+	}
+	c.hot = make(map[docKey]struct{})
+	c.hotMu.Unlock()
+	for _, k := range hot {
+		if ctx.Err() != nil {
+			break
+		}
+		c.compactOne(ctx, k, &rep)
+	}
+
+	cands, err := c.src.ListCompactionCandidates(ctx, uuid.Nil,
+		CompactCountThreshold, CompactBytesThreshold, c.batch)
+	if err == nil {
+		for _, cand := range cands {
+			if ctx.Err() != nil {
+				break
+			}
+			c.compactOne(ctx, docKey{cand.VaultID, cand.NoteID}, &rep)
+		}
+	}
+	rep.Duration = time.Since(start)
+	c.finishRun(rep, err)
+	if rep.Compacted > 0 {
+		c.log.Info().
+			Int("compacted", rep.Compacted).
+			Int64("rows_deleted", rep.RowsDeleted).
+			Int64("bytes_reclaimed", rep.BytesReclaimed).
+			Dur("took", rep.Duration).
+			Msg("compaction pass")
+	}
+	return rep, err
+}
+
+// CompactVault compacts every note in vaultID that has a non-empty log,
+// regardless of thresholds, then sweeps the vault's snapshots for
+// tombstones. Synchronous: it is the admin "compact now" action.
+func (c *Compactor) CompactVault(ctx context.Context, vaultID uuid.UUID) (CompactReport, error) {
+	start := time.Now()
+	var rep CompactReport
+	var err error
+	// seen bounds the loop: each note is compacted once per call even
+	// if concurrent writes keep putting it back on the candidate list.
+	seen := make(map[docKey]struct{})
+	for {
+		var cands []LogStat
+		cands, err = c.src.ListCompactionCandidates(ctx, vaultID, 1, 1, c.batch)
+		if err != nil {
+			break
+		}
+		fresh := 0
+		for _, cand := range cands {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				break
+			}
+			k := docKey{cand.VaultID, cand.NoteID}
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			fresh++
+			c.compactOne(ctx, k, &rep)
+		}
+		if err != nil || fresh == 0 {
+			break
+		}
+	}
+	if err == nil {
+		var cursor SnapshotCursor
+		for {
+			stats, lerr := c.src.ListSnapshotsAfter(ctx, vaultID, cursor, c.batch)
+			if lerr != nil {
+				err = lerr
+				break
+			}
+			for _, st := range stats {
+				k := docKey{st.VaultID, st.NoteID}
+				if _, ok := seen[k]; ok {
+					continue
+				}
+				c.compactOne(ctx, k, &rep)
+			}
+			if len(stats) < c.batch {
+				break
+			}
+			last := stats[len(stats)-1]
+			cursor = SnapshotCursor{VaultID: last.VaultID, NoteID: last.NoteID}
+		}
+	}
+	rep.Duration = time.Since(start)
+	c.finishRun(rep, err)
+	return rep, err
+}
+
+// sweepStep advances the global tombstone sweep by one batch. Returns
+// done=true when the walk wrapped around to the beginning.
+func (c *Compactor) sweepStep(ctx context.Context) (bool, error) {
+	c.cursorMu.Lock()
+	if c.sweeping {
+		c.cursorMu.Unlock()
+		return false, nil
+	}
+	c.sweeping = true
+	cursor := c.cursor
+	c.cursorMu.Unlock()
+	defer func() {
+		c.cursorMu.Lock()
+		c.sweeping = false
+		c.cursorMu.Unlock()
+	}()
+
+	stats, err := c.src.ListSnapshotsAfter(ctx, uuid.Nil, cursor, c.batch)
+	if err != nil {
+		return false, err
+	}
+	var rep CompactReport
+	for _, st := range stats {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		c.compactOne(ctx, docKey{st.VaultID, st.NoteID}, &rep)
+	}
+	done := len(stats) < c.batch
+	c.cursorMu.Lock()
+	if done {
+		c.cursor = SnapshotCursor{}
+	} else {
+		last := stats[len(stats)-1]
+		c.cursor = SnapshotCursor{VaultID: last.VaultID, NoteID: last.NoteID}
+	}
+	c.cursorMu.Unlock()
+	c.finishRun(rep, nil)
+	if done {
+		c.statMu.Lock()
+		c.lastSweep = time.Now()
+		c.statMu.Unlock()
+	}
+	return done, nil
+}
+
+// compactOne runs Registry.Compact under the per-note locks and folds
+// the outcome into rep.
+func (c *Compactor) compactOne(ctx context.Context, k docKey, rep *CompactReport) {
+	rep.Scanned++
+	if !c.lock(k) {
+		rep.Skipped++
+		return
+	}
+	defer c.unlock(k)
+	if c.locker != nil {
+		release, ok, err := c.locker.TryLockCompaction(ctx, k.vaultID, k.noteID)
+		if err != nil {
+			rep.Failed++
+			c.log.Warn().Err(err).
+				Str("vault_id", k.vaultID.String()).
+				Str("note_id", k.noteID).
+				Msg("lock note for compaction")
+			return
+		}
+		if !ok {
+			rep.Skipped++
+			return
+		}
+		defer release()
+	}
+	res, err := c.reg.Compact(ctx, k.vaultID, k.noteID)
+	if err != nil {
+		rep.Failed++
+		c.log.Warn().Err(err).
+			Str("vault_id", k.vaultID.String()).
+			Str("note_id", k.noteID).
+			Msg("compact note")
+		return
+	}
+	rep.add(res)
+}
+
+func (c *Compactor) lock(k docKey) bool {
+	c.busyMu.Lock()
+	defer c.busyMu.Unlock()
+	if _, ok := c.busy[k]; ok {
+		return false
+	}
+	c.busy[k] = struct{}{}
+	return true
+}
+
+func (c *Compactor) unlock(k docKey) {
+	c.busyMu.Lock()
+	delete(c.busy, k)
+	c.busyMu.Unlock()
+}
+
+func (c *Compactor) finishRun(rep CompactReport, err error) {
+	c.runs.Add(1)
+	c.compacted.Add(uint64(rep.Compacted))
+	c.rowsDeleted.Add(uint64(rep.RowsDeleted))
+	c.bytesReclaimed.Add(uint64(rep.BytesReclaimed))
+	c.failed.Add(uint64(rep.Failed))
+	c.statMu.Lock()
+	c.lastRun = time.Now()
+	if err != nil {
+		c.lastErr = err.Error()
+	} else {
+		c.lastErr = ""
+	}
+	c.statMu.Unlock()
+}
+
+// Stats returns the cumulative worker counters.
+func (c *Compactor) Stats() CompactorStats {
+	c.hotMu.Lock()
+	pending := len(c.hot)
+	c.hotMu.Unlock()
+	c.statMu.Lock()
+	defer c.statMu.Unlock()
+	return CompactorStats{
+		Runs:           c.runs.Load(),
+		Compacted:      c.compacted.Load(),
+		RowsDeleted:    c.rowsDeleted.Load(),
+		BytesReclaimed: c.bytesReclaimed.Load(),
+		Failed:         c.failed.Load(),
+		HotPending:     pending,
+		LastRunAt:      c.lastRun,
+		LastSweepAt:    c.lastSweep,
+		LastError:      c.lastErr,
+	}
+}
+
+// LogTotals proxies the source aggregate for one vault (uuid.Nil: all).
+func (c *Compactor) LogTotals(ctx context.Context, vaultID uuid.UUID) (LogTotals, error) {
+	return c.src.LogTotals(ctx, vaultID)
+}
@@ -0,0 +1,259 @@
+package crdt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// memStore doubles as the CompactionSource for compactor tests.
+
+func (m *memStore) ListCompactionCandidates(_ context.Context, v uuid.UUID, minCount int, minBytes int64, limit int) ([]LogStat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []LogStat{}
+	for k, rows := range m.updates {
+		if v != uuid.Nil && k.vaultID != v {
+			continue
+		}
+		st := LogStat{VaultID: k.vaultID, NoteID: k.noteID, Count: len(rows)}
+		for _, r := range rows {
+			st.Bytes += int64(len(r.Update))
+		}
+		if st.Count > 0 && (st.Count >= minCount || st.Bytes >= minBytes) {
+			out = append(out, st)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *memStore) ListSnapshotsAfter(_ context.Context, v uuid.UUID, after SnapshotCursor, limit int) ([]SnapshotStat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []SnapshotStat{}
+	for k, s := range m.snapshots {
+		if v != uuid.Nil && k.vaultID != v {
+			continue
+		}
+		out = append(out, SnapshotStat{VaultID: k.vaultID, NoteID: k.noteID, Bytes: int64(len(s))})
+	}
+	less := func(a, b SnapshotCursor) bool {
+		if a.VaultID != b.VaultID {
+			return a.VaultID.String() < b.VaultID.String()
+		}
+		return a.NoteID < b.NoteID
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return less(SnapshotCursor{out[i].VaultID, out[i].NoteID}, SnapshotCursor{out[j].VaultID, out[j].NoteID})
+	})
+	page := []SnapshotStat{}
+	for _, st := range out {
+		if (after != SnapshotCursor{}) && !less(after, SnapshotCursor{st.VaultID, st.NoteID}) {
+			continue
+		}
+		page = append(page, st)
+		if len(page) == limit {
+			break
+		}
+	}
+	return page, nil
+}
+
+func (m *memStore) LogTotals(_ context.Context, v uuid.UUID) (LogTotals, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var t LogTotals
+	for k, rows := range m.updates {
+		if (v != uuid.Nil && k.vaultID != v) || len(rows) == 0 {
+			continue
+		}
+		t.Notes++
+		t.Rows += int64(len(rows))
+		for _, r := range rows {
+			t.Bytes += int64(len(r.Update))
+		}
+	}
+	for k, s := range m.snapshots {
+		if v != uuid.Nil && k.vaultID != v {
+			continue
+		}
+		t.Snapshots++
+		t.SnapshotBytes += int64(len(s))
+	}
+	return t, nil
+}
+
+func writeN(t *testing.T, reg *Registry, v uuid.UUID, n string, count int) {
+	t.Helper()
+	ctx := context.Background()
+	d, err := reg.LoadDoc(ctx, v, n)
+	if err != nil {
+		t.Fatalf("LoadDoc: %v", err)
+	}
+	defer d.Close()
+	for i := 0; i < count; i++ {
+		txt, _ := d.Text()
+		upd, err := d.ApplyTextDiff(txt+fmt.Sprintf(" %d", i), "test")
+		if err != nil {
+			t.Fatalf("ApplyTextDiff: %v", err)
+		}
+		if err := reg.PersistChange(ctx, v, n, upd, uuid.Nil, "test", d); err != nil {
+			t.Fatalf("PersistChange: %v", err)
+		}
+	}
+}
+
+func TestPersistChangeDoesNotCompactInline(t *testing.T) {
+	store := newMemStore()
+	reg := NewRegistry(store)
+	v := uuid.New()
+	seed(t, reg, v, "a", "x")
+	writeN(t, reg, v, "a", CompactCountThreshold+5)
+
+	if n, _, _ := store.CountUpdates(context.Background(), v, "a"); n != CompactCountThreshold+5 {
+		t.Fatalf("log rows = %d; compaction ran on the write path", n)
+	}
+}
+
+func TestCompactorHotSignalQueuesNote(t *testing.T) {
+	store := newMemStore()
+	reg := NewRegistry(store)
+	c := NewCompactor(reg, store, zerolog.Nop())
+	v := uuid.New()
+	seed(t, reg, v, "a", "x")
+	writeN(t, reg, v, "a", CompactCountThreshold)
+
+	if st := c.Stats(); st.HotPending != 1 {
+		t.Fatalf("hot pending = %d, want 1", st.HotPending)
+	}
+	rep, err := c.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if rep.Compacted != 1 || rep.RowsDeleted != CompactCountThreshold {
+		t.Fatalf("report = %+v", rep)
+	}
+	if n, _, _ := store.CountUpdates(context.Background(), v, "a"); n != 0 {
+		t.Fatalf("log rows after compaction = %d", n)
+	}
+}
+
+func TestCompactVaultPreservesText(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	reg := NewRegistry(store)
+	c := NewCompactor(reg, store, zerolog.Nop())
+	v, other := uuid.New(), uuid.New()
+	seed(t, reg, v, "a", "hello")
+	seed(t, reg, other, "b", "keep")
+	writeN(t, reg, v, "a", 3)
+	writeN(t, reg, other, "b", 3)
+
+	d, _ := reg.LoadDoc(ctx, v, "a")
+	want, _ := d.Text()
+	_ = d.Close()
+
+	rep, err := c.CompactVault(ctx, v)
+	if err != nil {
+		t.Fatalf("CompactVault: %v", err)
+	}
+	if rep.Compacted == 0 || rep.RowsDeleted != 3 {
+		t.Fatalf("report = %+v", rep)
+	}
+	if n, _, _ := store.CountUpdates(ctx, other, "b"); n != 3 {
+		t.Fatalf("other vault compacted: %d rows left", n)
+	}
+
+	// Reload from storage alone.
+	fresh := NewRegistry(store, WithCacheBytes(0))
+	d2, err := fresh.LoadDoc(ctx, v, "a")
+	if err != nil {
+		t.Fatalf("LoadDoc: %v", err)
+	}
+	defer d2.Close()
+	if got, _ := d2.Text(); got != want {
+		t.Fatalf("text after compaction = %q, want %q", got, want)
+	}
+}
+
+func TestCompactReclaimsTombstones(t *testing.T) {
+	ctx := context.Background()
+	store := newMemStore()
+	reg := NewRegistry(store)
+	v := uuid.New()
+	seed(t, reg, v, "a", "")
+
+	d, _ := reg.LoadDoc(ctx, v, "a")
+	big := make([]byte, 8<<10)
+	for i := range big {
+		big[i] = 'z'
+	}
+	for _, txt := range []string{string(big), "short"} {
+		upd, _ := d.ApplyTextDiff(txt, "test")
+		if err := reg.PersistChange(ctx, v, "a", upd, uuid.Nil, "test", d); err != nil {
+			t.Fatalf("PersistChange: %v", err)
+		}
+	}
+	_ = d.Close()
+
+	res, err := reg.Compact(ctx, v, "a")
+	if err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if !res.Rewritten || res.BytesAfter >= int64(len(big)) {
+		t.Fatalf("deleted text not collected: %+v", res)
+	}
+}
+
+func TestCompactorSkipsBusyNote(t *testing.T) {
+	store := newMemStore()
+	reg := NewRegistry(store)
+	c := NewCompactor(reg, store, zerolog.Nop())
+	v := uuid.New()
+	seed(t, reg, v, "a", "x")
+	writeN(t, reg, v, "a", 2)
+
+	k := docKey{v, "a"}
+	if !c.lock(k) {
+		t.Fatal("lock failed")
+	}
+	rep, _ := c.CompactVault(context.Background(), v)
+	c.unlock(k)
+	if rep.Skipped == 0 || rep.Compacted != 0 {
+		t.Fatalf("busy note not skipped: %+v", rep)
+	}
+}
+
+// heldLocker reports every note as locked by another replica.
+type heldLocker struct{ calls int }
+
+func (h *heldLocker) TryLockCompaction(context.Context, uuid.UUID, string) (func(), bool, error) {
+	h.calls++
+	return nil, false, nil
+}
+
+func TestCompactorSkipsNoteLockedElsewhere(t *testing.T) {
+	store := newMemStore()
+	reg := NewRegistry(store)
+	locker := &heldLocker{}
+	c := NewCompactor(reg, store, zerolog.Nop(), WithCompactionLocker(locker))
+	v := uuid.New()
+	seed(t, reg, v, "a", "x")
+	writeN(t, reg, v, "a", 2)
+
+	rep, _ := c.CompactVault(context.Background(), v)
+	if locker.calls == 0 || rep.Skipped == 0 || rep.Compacted != 0 {
+		t.Fatalf("note locked by another replica was compacted: %+v", rep)
+	}
+	if n, _, _ := store.CountUpdates(context.Background(), v, "a"); n != 2 {
+		t.Fatalf("log rows = %d, want the 2 left untouched", n)
+	}
+}
@@ -0,0 +1,228 @@
+//go:build cgo && !purego
+
+package crdt
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ViniZap4/lumi-server/internal/crdt/yjs"
+)
+
+// Conformance between the yrs backend and the pure-Go engine behind
+// the purego tag. Both consume the same update streams; we compare
+// what they mean (text, structured roots, decoded state vectors) and
+// that each can load the other's encoded state. Byte equality is not
+// expected: yrs does not sort clients in state vectors and delete sets.
+
+// conformancePair feeds every update to one document of each backend.
+type conformancePair struct {
+	t    *testing.T
+	yrs  *Doc
+	pure *yjs.Doc
+}
+
+func newConformancePair(t *testing.T) *conformancePair {
+	t.Helper()
+	p := &conformancePair{t: t, yrs: NewDoc(), pure: yjs.NewDoc()}
+	t.Cleanup(func() { _ = p.yrs.Close() })
+	return p
+}
+
+func (p *conformancePair) apply(u []byte) {
+	p.t.Helper()
+	if err := p.yrs.ApplyUpdate(u); err != nil {
+		p.t.Fatalf("yrs apply: %v", err)
+	}
+	if err := p.pure.ApplyUpdate(u); err != nil {
+		p.t.Fatalf("yjs apply: %v", err)
+	}
+}
+
+func (p *conformancePair) check() {
+	p.t.Helper()
+	text, err := p.yrs.Text()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if got := p.pure.Text(rootBranchName); got != text {
+		p.t.Fatalf("text diverged:\n yrs %q\n yjs %q", text, got)
+	}
+	svY, err := p.yrs.StateVectorV1()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	a, err := yjs.DecodeStateVector(svY)
+	if err != nil {
+		p.t.Fatalf("decode yrs state vector: %v", err)
+	}
+	b, err := yjs.DecodeStateVector(p.pure.StateVector())
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if !maps.Equal(a, b) {
+		p.t.Fatalf("state vectors diverged: yrs %v, yjs %v", a, b)
+	}
+
+	// Each backend loads the other's full state to the same text.
+	fullY, err := p.yrs.EncodeStateAsUpdate()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	fromY := yjs.NewDoc()
+	if err := fromY.ApplyUpdate(fullY); err != nil {
+		p.t.Fatalf("yjs load of yrs state: %v", err)
+	}
+	if got := fromY.Text(rootBranchName); got != text {
+		p.t.Fatalf("yjs load of yrs state = %q, want %q", got, text)
+	}
+	fullP, err := p.pure.EncodeStateAsUpdate(nil)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	fromP, err := LoadDoc(fullP)
+	if err != nil {
+		p.t.Fatalf("yrs load of yjs state: %v", err)
+	}
+	defer fromP.Close()
+	if got, _ := fromP.Text(); got != text {
+		p.t.Fatalf("yrs load of yjs state = %q, want %q", got, text)
+	}
+
+	// And each backend's diff against the other's state vector is a
+	// no-op for the other.
+	diff, err := p.pure.EncodeStateAsUpdate(svY)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if err := p.yrs.ApplyUpdate(diff); err != nil {
+		p.t.Fatalf("yrs apply yjs diff: %v", err)
+	}
+	if got, _ := p.yrs.Text(); got != text {
+		p.t.Fatalf("yjs diff changed yrs text to %q", got)
+	}
+}
+
+// editors produces updates from both engines: two yjs replicas and one
+// yrs replica editing concurrently, each seeing only part of the rest.
+type editors struct {
+	r    *rand.Rand
+	pure []*yjs.Doc
+	yrs  *Doc
+	log  [][]byte
+}
+
+func (e *editors) step(t *testing.T) {
+	t.Helper()
+	words := []string{"a", "lumi", " ", "é", "🙂", "\n"}
+	if i := e.r.IntN(len(e.pure) + 1); i < len(e.pure) {
+		d := e.pure[i]
+		n := len(utf16OfString(d.Text(rootBranchName)))
+		u, err := d.Transact(func(tx *yjs.Txn) {
+			if n > 0 && e.r.IntN(3) == 0 {
+				at := e.r.IntN(n)
+				tx.DeleteText(rootBranchName, at, 1+e.r.IntN(min(4, n-at)))
+				return
+			}
+			tx.InsertText(rootBranchName, e.r.IntN(n+1), words[e.r.IntN(len(words))])
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.log = append(e.log, u)
+	} else {
+		cur, err := e.yrs.Text()
+		if err != nil {
+			t.Fatal(err)
+		}
+		runes := []rune(cur)
+		at := e.r.IntN(len(runes) + 1)
+		next := string(runes[:at]) + words[e.r.IntN(len(words))] + string(runes[at:])
+		if len(runes) > 0 && e.r.IntN(3) == 0 {
+			at = e.r.IntN(len(runes))
+			next = string(runes[:at]) + string(runes[at+1:])
+		}
+		u, err := e.yrs.ApplyTextDiff(next, "conformance")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(u) > 0 {
+			e.log = append(e.log, u)
+		}
+	}
+	// Partial gossip between the editors.
+	for _, u := range e.log[e.r.IntN(len(e.log)):] {
+		_ = e.pure[e.r.IntN(len(e.pure))].ApplyUpdate(u)
+		_ = e.yrs.ApplyUpdate(u)
+	}
+}
+
+func TestConformanceInOrder(t *testing.T) {
+	for seed := uint64(0); seed < 20; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			e := &editors{r: rand.New(rand.NewPCG(seed, 1)), pure: []*yjs.Doc{yjs.NewDoc(), yjs.NewDoc()}, yrs: NewDoc()}
+			defer e.yrs.Close()
+			for i := 0; i < 60; i++ {
+				e.step(t)
+			}
+			p := newConformancePair(t)
+			for _, u := range e.log {
+				p.apply(u)
+			}
+			p.check()
+		})
+	}
+}
+
+func TestConformanceShuffledWithDuplicates(t *testing.T) {
+	for seed := uint64(0); seed < 20; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			e := &editors{r: rand.New(rand.NewPCG(seed, 2)), pure: []*yjs.Doc{yjs.NewDoc(), yjs.NewDoc()}, yrs: NewDoc()}
+			defer e.yrs.Close()
+			for i := 0; i < 60; i++ {
+				e.step(t)
+			}
+			stream := append([][]byte(nil), e.log...)
+			stream = append(stream, e.log[:len(e.log)/3]...)
+			e.r.Shuffle(len(stream), func(i, j int) { stream[i], stream[j] = stream[j], stream[i] })
+			p := newConformancePair(t)
+			for _, u := range stream {
+				p.apply(u)
+			}
+			p.check()
+		})
+	}
+}
+
+func TestConformanceStructuredRoots(t *testing.T) {
+	// A hand-assembled v1 update: <heading level="2">hi</heading> in the
+	// prosemirror fragment plus props.title = "T".
+	u := []byte{
+		1, 5, 1, 0,
+		0x07, 1, 11, 'p', 'r', 'o', 's', 'e', 'm', 'i', 'r', 'r', 'o', 'r', 3, 7, 'h', 'e', 'a', 'd', 'i', 'n', 'g',
+		0x07, 0, 1, 0, 6,
+		0x04, 0, 1, 1, 2, 'h', 'i',
+		0x28, 1, 5, 'p', 'r', 'o', 'p', 's', 5, 't', 'i', 't', 'l', 'e', 1, 119, 1, 'T',
+		0x28, 0, 1, 0, 5, 'l', 'e', 'v', 'e', 'l', 1, 125, 2,
+		0,
+	}
+	p := newConformancePair(t)
+	p.apply(u)
+	p.check()
+	xml, err := p.yrs.XMLString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.pure.XMLString(XMLRootName); got != xml {
+		t.Fatalf("xml diverged:\n yrs %q\n yjs %q", xml, got)
+	}
+	props, err := p.yrs.Props()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.pure.MapStrings(PropsRootName); !maps.Equal(got, props) {
+		t.Fatalf("props diverged: yrs %v, yjs %v", props, got)
+	}
+}
@@ -0,0 +1,171 @@
+//go:build purego || !cgo
+
+package crdt
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ViniZap4/lumi-server/internal/crdt/yjs"
+)
+
+// Backend names the CRDT engine this binary was built with.
+const Backend = "purego"
+
+// SupportsEncoding reports whether the engine can apply and produce
+// updates in enc. The pure-Go engine speaks lib0-v1 only.
+func SupportsEncoding(enc Encoding) bool { return enc == EncodingV1 }
+
+// docCore is the reference-counted pure-Go document behind one or more
+// Doc handles. mu serialises every call into the (single-threaded) yjs
+// engine.
+type docCore struct {
+	mu   sync.Mutex
+	doc  *yjs.Doc
+	refs int
+}
+
+// NewDoc allocates a fresh, empty CRDT document. The caller owns the
+// returned Doc and must Close it when done; SetFinalizer covers leaks.
+// Roots are created lazily by the engine, so there is nothing to seed.
+func NewDoc() *Doc {
+	return newHandle(&docCore{doc: yjs.NewDoc(), refs: 1})
+}
+
+func (c *docCore) alive() bool { return c.doc != nil }
+
+// destroy drops the engine document; the caller holds c.mu.
+func (c *docCore) destroy() { c.doc = nil }
+
+// ---- Text reads ------------------------------------------------------------
+
+// Text returns the current value of the content root as a UTF-8 string.
+func (d *Doc) Text() (string, error) {
+	c, err := d.lock()
+	if err != nil {
+		return "", err
+	}
+	defer c.mu.Unlock()
+	return c.doc.Text(rootBranchName), nil
+}
+
+// ---- State vectors + updates -----------------------------------------------
+
+// StateVectorV1 returns the lib0-v1-encoded state vector of the current
+// document. The blob is opaque to clients and only meaningful as a
+// "what does this peer already have?" identifier.
+func (d *Doc) StateVectorV1() ([]byte, error) {
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	return c.doc.StateVector(), nil
+}
+
+func (d *Doc) encodeDiffSince(sv []byte, enc Encoding) ([]byte, error) {
+	if !SupportsEncoding(enc) {
+		return nil, ErrUnsupportedEncoding
+	}
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	out, err := c.doc.EncodeStateAsUpdate(sv)
+	if err != nil {
+		return nil, fmt.Errorf("crdt: decode state vector: %w", err)
+	}
+	return out, nil
+}
+
+// ApplyUpdate applies a lib0-v1 update blob to the document. Safe to
+// call repeatedly with overlapping or out-of-order updates: structs
+// whose dependencies have not arrived yet stay pending until they do.
+func (d *Doc) ApplyUpdate(update []byte) error {
+	c, err := d.lock()
+	if err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+	if err := c.doc.ApplyUpdate(update); err != nil {
+		return fmt.Errorf("crdt: apply update: %w", err)
+	}
+	return nil
+}
+
+// ApplyUpdateV2 always fails with ErrUnsupportedEncoding: the pure-Go
+// engine does not decode lib0-v2.
+func (d *Doc) ApplyUpdateV2(update []byte) ([]byte, error) {
+	return nil, ErrUnsupportedEncoding
+}
+
+// ---- Structured roots ------------------------------------------------------
+
+// XMLString returns the XML serialisation of the prosemirror fragment,
+// or "" when it has no children.
+func (d *Doc) XMLString() (string, error) {
+	c, err := d.lock()
+	if err != nil {
+		return "", err
+	}
+	defer c.mu.Unlock()
+	if c.doc.XMLChildLen(XMLRootName) == 0 {
+		return "", nil
+	}
+	return c.doc.XMLString(XMLRootName), nil
+}
+
+// Structured reports whether the prosemirror fragment holds content,
+// i.e. whether the rich editor (not the "content" YText) is the
+// authoritative body.
+func (d *Doc) Structured() (bool, error) {
+	c, err := d.lock()
+	if err != nil {
+		return false, err
+	}
+	defer c.mu.Unlock()
+	return c.doc.XMLChildLen(XMLRootName) > 0, nil
+}
+
+// Props returns the string-valued entries of the props map. Entries
+// holding other value types (numbers, nested shared types) are skipped.
+func (d *Doc) Props() (map[string]string, error) {
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	return c.doc.MapStrings(PropsRootName), nil
+}
+
+// ---- Textual diff application ----------------------------------------------
+
+// ApplyTextDiff replaces the document content with newText, expressed as
+// a single (remove_range, insert) pair at the boundary of the longest
+// common prefix and suffix. Returns the lib0-v1 update blob describing
+// exactly this change — persist it in note_yjs_updates.
+//
+// origin is accepted for parity with the yrs backend; the pure-Go
+// engine has no observers to surface it to.
+func (d *Doc) ApplyTextDiff(newText string, origin string) ([]byte, error) {
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	prefix, _, oldMid, newMid := diffSlice(c.doc.Text(rootBranchName), newText)
+	if oldMid == "" && newMid == "" {
+		return []byte{}, nil
+	}
+	at := utf16Len(prefix)
+	update, err := c.doc.Transact(func(tx *yjs.Txn) {
+		tx.DeleteText(rootBranchName, at, utf16Len(oldMid))
+		tx.InsertText(rootBranchName, at, newMid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("crdt: apply text diff: %w", err)
+	}
+	return update, nil
+}
@@ -1,30 +1,5 @@
-// Package crdt is a narrow cgo wrapper around the yffi C API of the yrs
-// CRDT engine. We expose only the operations needed by lumi-server v2
-// Phase 2.2 — load a document from a persisted state, read its text root,
-// apply a textual diff, encode state vectors and update blobs.
-//
-// # Threading
-//
-// yrs serialises transactions per-document via an internal RwLock and
-// returns NULL from ydoc_read_transaction / ydoc_write_transaction if a
-// transaction is already open. We never want a goroutine to receive a
-// surprise NULL pointer, so every Doc holds its own sync.Mutex and all
-// public methods take it. Callers may freely share a *Doc across
-// goroutines; the wrapper serialises them.
-//
-// # Memory
-//
-// Heap pointers returned by yffi (char* strings, char* binaries) are
-// released via ystring_destroy / ybinary_destroy as soon as we have
-// copied the contents into Go memory. Doc itself is released by
-// ydoc_destroy on Close; a runtime.SetFinalizer guards against leaks
-// when a caller forgets to Close.
-//
-// # Text encoding
-//
-// yrs Text uses UTF-16 code-unit offsets for index/length arguments,
-// matching Yjs/JavaScript string semantics. The wrapper translates from
-// Go UTF-8 strings to UTF-16 offsets transparently in ApplyTextDiff.
+//go:build cgo && !purego
+
 package crdt
 
 /*
@@ -42,37 +17,42 @@ import "C"
 import (
 	"errors"
 	"fmt"
-	"runtime"
 	"sync"
-	"unicode/utf16"
 	"unsafe"
 )
 
-// rootBranchName is the YText root the entire note body lives in.
-// Persisted updates reference this key; do not change without a
-// migration path.
-const rootBranchName = "content"
+// Backend names the CRDT engine this binary was built with.
+const Backend = "yrs"
 
-// Doc is the Go-side handle for a yrs YDoc. Safe for concurrent use.
+// SupportsEncoding reports whether the engine can apply and produce
+// updates in enc. yrs handles both lib0 formats.
+func SupportsEncoding(enc Encoding) bool {
+	return enc == EncodingV1 || enc == EncodingV2
+}
+
+// docCore is the reference-counted yrs document behind one or more Doc
+// handles. mu serialises every yffi call against the document.
 //
-// The "content" YText root is created eagerly at construction time and
-// its Branch pointer is cached. Calling yrs's `ytext()` lazily inside
-// a read transaction would deadlock: the function takes an internal
-// write lock to allocate the root, and our read transaction holds the
-// same RwLock in shared mode. By materialising the root once up-front
-// inside a dedicated write transaction we side-step the deadlock and
-// gain a fast Branch handle for subsequent text ops.
-type Doc struct {
+// The three root Branch pointers are materialised at construction time
+// and cached. Calling yrs's `ytext()` lazily inside a read transaction
+// would deadlock: the function takes an internal write lock to allocate
+// the root, and our read transaction holds the same RwLock in shared
+// mode. Allocating the roots once up-front side-steps the deadlock and
+// gives fast Branch handles for subsequent ops.
+type docCore struct {
 	mu       sync.Mutex
 	ptr      *C.YDoc
 	contentB *C.Branch
-	closed   bool
+	xmlB     *C.Branch
+	propsB   *C.Branch
+	refs     int
 }
 
 // NewDoc allocates a fresh, empty CRDT document. The caller owns the
 // returned Doc and must Close it when done; SetFinalizer covers leaks.
 //
-// The "content" YText root is materialised via ytext() called OUTSIDE
+// All three roots are materialised via ytext()/yxmlfragment()/ymap()
+// called OUTSIDE
 // any transaction. Internally yrs grabs the doc's exclusive lock to
 // allocate the root; calling it inside our own write transaction
 // deadlocks against the same lock (parking_lot RwLock is not
@@ -80,85 +60,50 @@ type Doc struct {
 // allocation through its own internal txn, which is fine — we just
 // can't hold our own.
 func NewDoc() *Doc {
-	d := &Doc{ptr: C.ydoc_new()}
-	if d.ptr == nil {
+	c := &docCore{ptr: C.ydoc_new(), refs: 1}
+	if c.ptr == nil {
 		panic("crdt: ydoc_new returned NULL")
 	}
 	cname := C.CString(rootBranchName)
 	defer C.free(unsafe.Pointer(cname))
-	d.contentB = C.ytext(d.ptr, cname)
-	if d.contentB == nil {
-		C.ydoc_destroy(d.ptr)
-		panic("crdt: ytext returned NULL while seeding root")
-	}
-	runtime.SetFinalizer(d, (*Doc).finalize)
-	return d
+	c.contentB = C.ytext(c.ptr, cname)
+	xname := C.CString(XMLRootName)
+	defer C.free(unsafe.Pointer(xname))
+	c.xmlB = C.yxmlfragment(c.ptr, xname)
+	pname := C.CString(PropsRootName)
+	defer C.free(unsafe.Pointer(pname))
+	c.propsB = C.ymap(c.ptr, pname)
+	if c.contentB == nil || c.xmlB == nil || c.propsB == nil {
+		C.ydoc_destroy(c.ptr)
+		panic("crdt: root allocation returned NULL while seeding roots")
+	}
+	return newHandle(c)
 }
 
-// LoadDoc allocates a fresh document and applies the given persisted
-// state (a lib0 v1 update blob, e.g. the union of a snapshot and the
-// replayed update log) before returning. The caller owns the result and
-// must Close it.
-func LoadDoc(state []byte) (*Doc, error) {
-	d := NewDoc()
-	if len(state) == 0 {
-		return d, nil
-	}
-	if err := d.ApplyUpdate(state); err != nil {
-		_ = d.Close()
-		return nil, err
-	}
-	return d, nil
-}
+func (c *docCore) alive() bool { return c.ptr != nil }
 
-// Close releases the underlying YDoc. Idempotent.
-func (d *Doc) Close() error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return nil
-	}
-	C.ydoc_destroy(d.ptr)
-	d.ptr = nil
-	d.contentB = nil
-	d.closed = true
-	runtime.SetFinalizer(d, nil)
-	return nil
+// destroy frees the YDoc; the caller holds c.mu.
+func (c *docCore) destroy() {
+	C.ydoc_destroy(c.ptr)
+	c.ptr = nil
+	c.contentB, c.xmlB, c.propsB = nil, nil, nil
 }
 
-func (d *Doc) finalize() {
-	// Synchronisation is irrelevant inside a finalizer — the GC has
-	// already established no other reference exists — but we keep the
-	// lock as defence in depth.
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return
-	}
-	C.ydoc_destroy(d.ptr)
-	d.ptr = nil
-	d.contentB = nil
-	d.closed = true
-}
-
-// errClosed is returned for operations on a closed Doc.
-var errClosed = errors.New("crdt: document is closed")
-
 // ---- Text reads ------------------------------------------------------------
 
 // Text returns the current value of the content root as a UTF-8 string.
 func (d *Doc) Text() (string, error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return "", errClosed
+	c, err := d.lock()
+	if err != nil {
+		return "", err
 	}
-	txn := C.ydoc_read_transaction(d.ptr)
+	defer c.mu.Unlock()
+	txn := C.ydoc_read_transaction(c.ptr)
 	if txn == nil {
 		return "", errors.New("crdt: read transaction unavailable")
 	}
 	defer C.ytransaction_commit(txn)
-	return readContent(d.contentB, txn), nil
+	return readContent(c.contentB, txn), nil
 }
 
 // readContent assumes the caller holds an open transaction and uses the
@@ -181,12 +126,12 @@ func readContent(branch *C.Branch, txn *C.YTransaction) string {
 // document. The blob is opaque to clients and only meaningful as a
 // "what does this peer already have?" identifier.
 func (d *Doc) StateVectorV1() ([]byte, error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return nil, errClosed
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
 	}
-	txn := C.ydoc_read_transaction(d.ptr)
+	defer c.mu.Unlock()
+	txn := C.ydoc_read_transaction(c.ptr)
 	if txn == nil {
 		return nil, errors.New("crdt: read transaction unavailable")
 	}
@@ -200,30 +145,13 @@ func (d *Doc) StateVectorV1() ([]byte, error) {
 	return C.GoBytes(unsafe.Pointer(ptr), C.int(n)), nil
 }
 
-// EncodeStateAsUpdate returns a lib0-v1 update blob carrying the full
-// state of the document. Equivalent to state_diff_v1(nil). Use it for
-// the snapshot row in note_yjs_snapshots.
-func (d *Doc) EncodeStateAsUpdate() ([]byte, error) {
-	return d.encodeDiffSince(nil)
-}
-
-// EncodeDiffSince returns a lib0-v1 update blob containing only the
-// operations the document has that are NOT covered by the supplied
-// state vector. This is the SyncStep2 payload in the Yjs y-protocols
-// wire protocol — server sends one of these in response to a client's
-// SyncStep1 carrying the client's sv. Passing a nil/empty sv is
-// equivalent to EncodeStateAsUpdate (full state).
-func (d *Doc) EncodeDiffSince(sv []byte) ([]byte, error) {
-	return d.encodeDiffSince(sv)
-}
-
-func (d *Doc) encodeDiffSince(sv []byte) ([]byte, error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return nil, errClosed
+func (d *Doc) encodeDiffSince(sv []byte, enc Encoding) ([]byte, error) {
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
 	}
-	txn := C.ydoc_read_transaction(d.ptr)
+	defer c.mu.Unlock()
+	txn := C.ydoc_read_transaction(c.ptr)
 	if txn == nil {
 		return nil, errors.New("crdt: read transaction unavailable")
 	}
@@ -235,7 +163,12 @@ func (d *Doc) encodeDiffSince(sv []byte) ([]byte, error) {
 		svPtr = (*C.char)(unsafe.Pointer(&sv[0]))
 		svLen = C.uint32_t(len(sv))
 	}
-	ptr := C.ytransaction_state_diff_v1(txn, svPtr, svLen, &n)
+	var ptr *C.char
+	if enc == EncodingV2 {
+		ptr = C.ytransaction_state_diff_v2(txn, svPtr, svLen, &n)
+	} else {
+		ptr = C.ytransaction_state_diff_v1(txn, svPtr, svLen, &n)
+	}
 	if ptr == nil {
 		return []byte{}, nil
 	}
@@ -247,15 +180,15 @@ func (d *Doc) encodeDiffSince(sv []byte) ([]byte, error) {
 // call repeatedly with overlapping or out-of-order updates: yrs's CRDT
 // merge is order-independent.
 func (d *Doc) ApplyUpdate(update []byte) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return errClosed
+	c, err := d.lock()
+	if err != nil {
+		return err
 	}
+	defer c.mu.Unlock()
 	if len(update) == 0 {
 		return nil
 	}
-	txn := C.ydoc_write_transaction(d.ptr, 0, nil)
+	txn := C.ydoc_write_transaction(c.ptr, 0, nil)
 	if txn == nil {
 		return errors.New("crdt: write transaction unavailable")
 	}
@@ -268,6 +201,119 @@ func (d *Doc) ApplyUpdate(update []byte) error {
 	return nil
 }
 
+// ApplyUpdateV2 applies a lib0-v2 update blob and returns the lib0-v1
+// update describing what it integrated — the form the update log and
+// v1 peers expect. Returns an empty slice when the update brought
+// nothing new. Structs still waiting on missing dependencies stay
+// pending in the live document but are not part of the returned delta.
+func (d *Doc) ApplyUpdateV2(update []byte) ([]byte, error) {
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	if len(update) == 0 {
+		return []byte{}, nil
+	}
+	svBefore := stateVectorUnderReadTxn(c.ptr)
+	txn := C.ydoc_write_transaction(c.ptr, 0, nil)
+	if txn == nil {
+		return nil, errors.New("crdt: write transaction unavailable")
+	}
+	cdata := (*C.char)(unsafe.Pointer(&update[0]))
+	if rc := C.ytransaction_apply_v2(txn, cdata, C.uint32_t(len(update))); rc != 0 {
+		C.ytransaction_commit(txn)
+		return nil, fmt.Errorf("crdt: ytransaction_apply_v2 failed with code %d", int(rc))
+	}
+	var n C.uint32_t
+	var svPtr *C.char
+	var svLen C.uint32_t
+	if len(svBefore) > 0 {
+		svPtr = (*C.char)(unsafe.Pointer(&svBefore[0]))
+		svLen = C.uint32_t(len(svBefore))
+	}
+	ptr := C.ytransaction_state_diff_v1(txn, svPtr, svLen, &n)
+	C.ytransaction_commit(txn)
+	if ptr == nil {
+		return []byte{}, nil
+	}
+	defer C.ybinary_destroy(ptr, n)
+	return C.GoBytes(unsafe.Pointer(ptr), C.int(n)), nil
+}
+
+// ---- Structured roots ------------------------------------------------------
+
+// XMLString returns the XML serialisation of the prosemirror fragment,
+// or "" when it has no children.
+func (d *Doc) XMLString() (string, error) {
+	c, err := d.lock()
+	if err != nil {
+		return "", err
+	}
+	defer c.mu.Unlock()
+	txn := C.ydoc_read_transaction(c.ptr)
+	if txn == nil {
+		return "", errors.New("crdt: read transaction unavailable")
+	}
+	defer C.ytransaction_commit(txn)
+	if C.yxmlelem_child_len(c.xmlB, txn) == 0 {
+		return "", nil
+	}
+	raw := C.yxmlelem_string(c.xmlB, txn)
+	if raw == nil {
+		return "", nil
+	}
+	defer C.ystring_destroy(raw)
+	return C.GoString(raw), nil
+}
+
+// Structured reports whether the prosemirror fragment holds content,
+// i.e. whether the rich editor (not the "content" YText) is the
+// authoritative body.
+func (d *Doc) Structured() (bool, error) {
+	c, err := d.lock()
+	if err != nil {
+		return false, err
+	}
+	defer c.mu.Unlock()
+	txn := C.ydoc_read_transaction(c.ptr)
+	if txn == nil {
+		return false, errors.New("crdt: read transaction unavailable")
+	}
+	defer C.ytransaction_commit(txn)
+	return C.yxmlelem_child_len(c.xmlB, txn) > 0, nil
+}
+
+// Props returns the string-valued entries of the props map. Entries
+// holding other value types (numbers, nested shared types) are skipped.
+func (d *Doc) Props() (map[string]string, error) {
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+	txn := C.ydoc_read_transaction(c.ptr)
+	if txn == nil {
+		return nil, errors.New("crdt: read transaction unavailable")
+	}
+	defer C.ytransaction_commit(txn)
+	out := map[string]string{}
+	it := C.ymap_iter(c.propsB, txn)
+	if it == nil {
+		return out, nil
+	}
+	defer C.ymap_iter_destroy(it)
+	for e := C.ymap_iter_next(it); e != nil; e = C.ymap_iter_next(it) {
+		// youtput_read_string borrows from the entry; copy before
+		// destroying it.
+		if s := C.youtput_read_string(e.value); s != nil {
+			out[C.GoString(e.key)] = C.GoString(s)
+		}
+		C.ymap_entry_destroy(e)
+	}
+	return out, nil
+}
+
 // ---- Textual diff application ----------------------------------------------
 
 // ApplyTextDiff replaces the document content with newText, expressed as
@@ -279,11 +325,11 @@ func (d *Doc) ApplyUpdate(update []byte) error {
 // the produced update; it surfaces in the awareness/observer APIs for
 // presence-aware UIs. Empty origin is allowed.
 func (d *Doc) ApplyTextDiff(newText string, origin string) ([]byte, error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.closed {
-		return nil, errClosed
+	c, err := d.lock()
+	if err != nil {
+		return nil, err
 	}
+	defer c.mu.Unlock()
 
 	// Read current text under a read txn so the diff is computed against
 	// the same snapshot we then mutate atomically — yrs serialises the
@@ -291,10 +337,10 @@ func (d *Doc) ApplyTextDiff(newText string, origin string) ([]byte, error) {
 	// between our two transactions. For lumi-server's single-process
 	// model that's adequate; for the live-sync slice (2.3) the read+
 	// write will collapse into one composite txn via observer hooks.
-	current := readUnderReadTxn(d.ptr, d.contentB)
+	current := readUnderReadTxn(c.ptr, c.contentB)
 
 	// Snapshot state vector for the post-write diff. Same caveat.
-	svBefore := stateVectorUnderReadTxn(d.ptr)
+	svBefore := stateVectorUnderReadTxn(c.ptr)
 
 	// Compute the minimal middle slice that changed.
 	prefixRunes, suffixRunes, oldMid, newMid := diffSlice(current, newText)
@@ -315,12 +361,12 @@ func (d *Doc) ApplyTextDiff(newText string, origin string) ([]byte, error) {
 		defer C.free(unsafe.Pointer(originPtr))
 		originLen = C.uint32_t(len(origin))
 	}
-	txn := C.ydoc_write_transaction(d.ptr, originLen, originPtr)
+	txn := C.ydoc_write_transaction(c.ptr, originLen, originPtr)
 	if txn == nil {
 		return nil, errors.New("crdt: write transaction unavailable")
 	}
 
-	branch := d.contentB
+	branch := c.contentB
 	if removeLen > 0 {
 		C.ytext_remove_range(branch, txn, C.uint32_t(insertIndex), C.uint32_t(removeLen))
 	}
@@ -375,57 +421,3 @@ func stateVectorUnderReadTxn(doc *C.YDoc) []byte {
 	defer C.ybinary_destroy(ptr, n)
 	return C.GoBytes(unsafe.Pointer(ptr), C.int(n))
 }
-
-// diffSlice finds the common prefix and suffix of two strings (by rune,
-// not byte, so we never split a multi-byte codepoint). Returns:
-//
-//   - prefix runes shared by both,
-//   - suffix runes shared by both,
-//   - the middle slice of `old` (what to remove),
-//   - the middle slice of `new` (what to insert).
-//
-// Suffix scan stops as soon as it would overlap the prefix.
-func diffSlice(old, neu string) (prefix string, suffix string, oldMid string, newMid string) {
-	oldRunes := []rune(old)
-	newRunes := []rune(neu)
-
-	p := 0
-	for p < len(oldRunes) && p < len(newRunes) && oldRunes[p] == newRunes[p] {
-		p++
-	}
-
-	s := 0
-	for s < len(oldRunes)-p && s < len(newRunes)-p &&
-		oldRunes[len(oldRunes)-1-s] == newRunes[len(newRunes)-1-s] {
-		s++
-	}
-
-	prefix = string(oldRunes[:p])
-	suffix = string(oldRunes[len(oldRunes)-s:])
-	oldMid = string(oldRunes[p : len(oldRunes)-s])
-	newMid = string(newRunes[p : len(newRunes)-s])
-	return
-}
-
-// utf16Len returns the number of UTF-16 code units required to encode s.
-// BMP runes are 1 unit; supplementary-plane runes (e.g. most emoji) are
-// 2 units (surrogate pair). Matches the JS String.length convention that
-// Yjs ytext indices follow.
-func utf16Len(s string) int {
-	n := 0
-	for _, r := range s {
-		if r > 0xFFFF {
-			n += 2
-		} else {
-			n++
-		}
-	}
-	return n
-}
-
-// utf16OfString is exported as a tiny helper for tests; the in-tree
-// callers prefer utf16Len. utf16.Encode returns []uint16 which is
-// more allocation-heavy than counting code units directly.
-func utf16OfString(s string) []uint16 {
-	return utf16.Encode([]rune(s))
-}
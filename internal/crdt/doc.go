@@ -0,0 +1,302 @@
+// Package crdt wraps the Yjs CRDT engine behind the narrow API
+// lumi-server v2 needs — load a document from a persisted state, read
+// its text root, apply a textual diff, encode state vectors and update
+// blobs.
+//
+// # Backends
+//
+// Two interchangeable engines implement Doc, selected at build time:
+//
+//   - yrs (default when cgo is available): a cgo wrapper around the
+//     yffi C API of y-crdt, built from third_party/y-crdt. Supports
+//     lib0-v1 and lib0-v2 updates.
+//   - purego (`-tags purego`, or any CGO_ENABLED=0 build): the pure-Go
+//     engine in internal/crdt/yjs. Supports lib0-v1 only; Backend and
+//     SupportsEncoding let callers reject v2 peers up front.
+//
+// Both produce Yjs-compatible updates and state vectors, and converge
+// on the same update streams (see conformance_test.go).
+//
+// # Threading
+//
+// Every Doc holds its own sync.Mutex and all public methods take it.
+// Callers may freely share a *Doc across goroutines; the wrapper
+// serialises them. (yrs additionally returns NULL from
+// ydoc_read_transaction / ydoc_write_transaction if a transaction is
+// already open; the mutex guarantees that never happens.)
+//
+// # Memory
+//
+// Doc handles are reference counted: the engine document is released
+// when the last handle is Closed; a runtime.SetFinalizer guards
+// against leaks when a caller forgets to Close. Under yrs, heap
+// pointers returned by yffi are released as soon as their contents are
+// copied into Go memory.
+//
+// # Text encoding
+//
+// Yjs Text uses UTF-16 code-unit offsets for index/length arguments,
+// matching JavaScript string semantics. The wrapper translates from
+// Go UTF-8 strings to UTF-16 offsets transparently in ApplyTextDiff.
+//
+// # Roots
+//
+// Every document carries three roots: the "content" YText (the plain
+// markdown body the TUI and REST diff paths edit), a "prosemirror"
+// Y.XmlFragment written by the rich web editor, and a "props" Y.Map of
+// note properties. A note whose fragment is non-empty is "structured":
+// its markdown projection (Markdown) is rendered from the fragment
+// instead of read from the YText.
+package crdt
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"unicode/utf16"
+
+	"github.com/ViniZap4/lumi-server/internal/crdt/richtext"
+)
+
+// rootBranchName is the YText root the entire note body lives in.
+// Persisted updates reference this key; do not change without a
+// migration path.
+const rootBranchName = "content"
+
+// XMLRootName is the Y.XmlFragment root rich editors bind to
+// (y-prosemirror's `ydoc.getXmlFragment("prosemirror")`).
+const XMLRootName = "prosemirror"
+
+// PropsRootName is the Y.Map root holding note properties.
+const PropsRootName = "props"
+
+// Doc is the Go-side handle for a Yjs document. Safe for concurrent
+// use.
+//
+// Several Doc handles may share one underlying document: the
+// Registry's document cache hands every LoadDoc caller its own handle
+// onto the cached document. Each handle is Closed independently; the
+// document is destroyed once the last handle (the cache's included)
+// lets go.
+type Doc struct {
+	core   *docCore
+	closed atomic.Bool
+}
+
+func newHandle(c *docCore) *Doc {
+	d := &Doc{core: c}
+	runtime.SetFinalizer(d, (*Doc).finalize)
+	return d
+}
+
+// LoadDoc allocates a fresh document and applies the given persisted
+// state (a lib0 v1 update blob, e.g. the union of a snapshot and the
+// replayed update log) before returning. The caller owns the result and
+// must Close it.
+func LoadDoc(state []byte) (*Doc, error) {
+	d := NewDoc()
+	if len(state) == 0 {
+		return d, nil
+	}
+	if err := d.ApplyUpdate(state); err != nil {
+		_ = d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Share returns a new handle onto the same underlying document. The
+// returned Doc must be Closed independently of d. Returns nil when d is
+// already closed.
+func (d *Doc) Share() *Doc {
+	if d.closed.Load() {
+		return nil
+	}
+	c := d.core
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.alive() {
+		return nil
+	}
+	c.refs++
+	return newHandle(c)
+}
+
+// SameDocument reports whether d and other are handles onto the same
+// underlying document.
+func (d *Doc) SameDocument(other *Doc) bool {
+	return d != nil && other != nil && d.core == other.core
+}
+
+// borrowed reports whether any handle other than d shares the document.
+func (d *Doc) borrowed() bool {
+	c := d.core
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refs > 1
+}
+
+// Close releases this handle. The underlying document is destroyed when the
+// last handle sharing it is closed. Idempotent per handle.
+func (d *Doc) Close() error {
+	if !d.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	runtime.SetFinalizer(d, nil)
+	d.core.release()
+	return nil
+}
+
+func (d *Doc) finalize() {
+	// The GC has established no other reference to this handle exists;
+	// other handles may still share the core, so release rather than
+	// destroy.
+	if d.closed.CompareAndSwap(false, true) {
+		d.core.release()
+	}
+}
+
+func (c *docCore) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs--
+	if c.refs > 0 || !c.alive() {
+		return
+	}
+	c.destroy()
+}
+
+// lock takes the core mutex and returns the core, or errClosed when the
+// handle or the document is gone. Callers must unlock c.mu.
+func (d *Doc) lock() (*docCore, error) {
+	if d.closed.Load() {
+		return nil, errClosed
+	}
+	c := d.core
+	c.mu.Lock()
+	if !c.alive() {
+		c.mu.Unlock()
+		return nil, errClosed
+	}
+	return c, nil
+}
+
+// errClosed is returned for operations on a closed Doc.
+var errClosed = errors.New("crdt: document is closed")
+
+// ---- Encodings -------------------------------------------------------------
+
+// EncodeStateAsUpdate returns a lib0-v1 update blob carrying the full
+// state of the document. Equivalent to state_diff_v1(nil). Use it for
+// the snapshot row in note_yjs_snapshots.
+func (d *Doc) EncodeStateAsUpdate() ([]byte, error) {
+	return d.encodeDiffSince(nil, EncodingV1)
+}
+
+// EncodeStateAsUpdateV2 is EncodeStateAsUpdate in the lib0-v2 format.
+func (d *Doc) EncodeStateAsUpdateV2() ([]byte, error) {
+	return d.encodeDiffSince(nil, EncodingV2)
+}
+
+// EncodeDiffSince returns a lib0-v1 update blob containing only the
+// operations the document has that are NOT covered by the supplied
+// state vector. This is the SyncStep2 payload in the Yjs y-protocols
+// wire protocol — server sends one of these in response to a client's
+// SyncStep1 carrying the client's sv. Passing a nil/empty sv is
+// equivalent to EncodeStateAsUpdate (full state).
+func (d *Doc) EncodeDiffSince(sv []byte) ([]byte, error) {
+	return d.encodeDiffSince(sv, EncodingV1)
+}
+
+// EncodeDiffSinceV2 is EncodeDiffSince in the lib0-v2 format. The state
+// vector itself is always v1 — Yjs has no v2 state vector.
+func (d *Doc) EncodeDiffSinceV2(sv []byte) ([]byte, error) {
+	return d.encodeDiffSince(sv, EncodingV2)
+}
+
+// EncodeDiffSinceAs picks EncodeDiffSince or EncodeDiffSinceV2 by enc.
+func (d *Doc) EncodeDiffSinceAs(sv []byte, enc Encoding) ([]byte, error) {
+	return d.encodeDiffSince(sv, enc)
+}
+
+// ApplyUpdateAs applies update in the given encoding and returns its
+// lib0-v1 form for persistence. For v1 input that is update itself.
+func (d *Doc) ApplyUpdateAs(update []byte, enc Encoding) ([]byte, error) {
+	if enc == EncodingV2 {
+		return d.ApplyUpdateV2(update)
+	}
+	if err := d.ApplyUpdate(update); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// Markdown returns the note body as markdown: the prosemirror fragment
+// rendered through richtext for structured notes, the "content" YText
+// verbatim otherwise. This is what the FS mirror writes to disk.
+func (d *Doc) Markdown() (string, error) {
+	xml, err := d.XMLString()
+	if err != nil {
+		return "", err
+	}
+	if xml == "" {
+		return d.Text()
+	}
+	return richtext.Markdown(xml)
+}
+
+// ---- Helpers ---------------------------------------------------------------
+
+// diffSlice finds the common prefix and suffix of two strings (by rune,
+// not byte, so we never split a multi-byte codepoint). Returns:
+//
+//   - prefix runes shared by both,
+//   - suffix runes shared by both,
+//   - the middle slice of `old` (what to remove),
+//   - the middle slice of `new` (what to insert).
+//
+// Suffix scan stops as soon as it would overlap the prefix.
+func diffSlice(old, neu string) (prefix string, suffix string, oldMid string, newMid string) {
+	oldRunes := []rune(old)
+	newRunes := []rune(neu)
+
+	p := 0
+	for p < len(oldRunes) && p < len(newRunes) && oldRunes[p] == newRunes[p] {
+		p++
+	}
+
+	s := 0
+	for s < len(oldRunes)-p && s < len(newRunes)-p &&
+		oldRunes[len(oldRunes)-1-s] == newRunes[len(newRunes)-1-s] {
+		s++
+	}
+
+	prefix = string(oldRunes[:p])
+	suffix = string(oldRunes[len(oldRunes)-s:])
+	oldMid = string(oldRunes[p : len(oldRunes)-s])
+	newMid = string(newRunes[p : len(newRunes)-s])
+	return
+}
+
+// utf16Len returns the number of UTF-16 code units required to encode s.
+// BMP runes are 1 unit; supplementary-plane runes (e.g. most emoji) are
+// 2 units (surrogate pair). Matches the JS String.length convention that
+// Yjs ytext indices follow.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r > 0xFFFF {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// utf16OfString is exported as a tiny helper for tests; the in-tree
+// callers prefer utf16Len. utf16.Encode returns []uint16 which is
+// more allocation-heavy than counting code units directly.
+func utf16OfString(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
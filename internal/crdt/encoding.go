@@ -0,0 +1,50 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Encoding selects the lib0 update encoding on the wire. Storage is
+// always v1 — note_yjs_snapshots and note_yjs_updates hold v1 blobs —
+// so v2 only ever exists between the server and a peer that asked for
+// it.
+type Encoding uint8
+
+const (
+	// EncodingV1 is the lib0-v1 update format, Yjs's default.
+	EncodingV1 Encoding = iota
+	// EncodingV2 is the column-oriented lib0-v2 update format
+	// (Y.encodeStateAsUpdateV2). Smaller for rich-text documents with
+	// many formatting attributes.
+	EncodingV2
+)
+
+// String returns the wire spelling ("v1" or "v2").
+func (e Encoding) String() string {
+	switch e {
+	case EncodingV1:
+		return "v1"
+	case EncodingV2:
+		return "v2"
+	}
+	return fmt.Sprintf("Encoding(%d)", uint8(e))
+}
+
+// ParseEncoding maps the `encoding` query/body parameter onto an
+// Encoding. The empty string means v1 so existing clients are
+// unaffected.
+func ParseEncoding(s string) (Encoding, error) {
+	switch s {
+	case "", "v1", "1":
+		return EncodingV1, nil
+	case "v2", "2":
+		return EncodingV2, nil
+	}
+	return EncodingV1, fmt.Errorf("crdt: unknown update encoding %q", s)
+}
+
+// ErrUnsupportedEncoding is returned when the CRDT backend this binary
+// was built with cannot handle the requested encoding (lib0-v2 under
+// the purego backend). See SupportsEncoding.
+var ErrUnsupportedEncoding = errors.New("crdt: update encoding not supported by this build")
@@ -11,6 +11,7 @@ import (
 // Compaction thresholds. The update log is folded into a new snapshot
 // when either limit is exceeded. Values come from SPEC.md "Persistence"
 // (200 entries / ~1 MiB). Tune as needed once we have telemetry.
+// Compaction runs off the write path in the Compactor worker.
 const (
 	CompactCountThreshold = 200
 	CompactBytesThreshold = 1 << 20 // 1 MiB
@@ -46,14 +47,42 @@ type UpdateRow struct {
 }
 
 // Registry orchestrates snapshot + update-log persistence around a yrs
-// document. Phase 2.2 ships without an in-memory document cache: each
-// public method loads the doc, performs work, closes the doc. Slice 2.3
-// (live Yjs sync) introduces an LRU keyed by (vault_id, note_id).
+// document. Loaded documents are kept in a byte-budgeted LRU keyed by
+// (vault_id, note_id) so REST reads, the FS bridge, federation and the
+// wsync rooms all operate on the same live YDoc instead of replaying the
+// log per request.
+//
+// The cache assumes this process is the only writer of note_yjs_*: a
+// second server instance appending to the same log would not be seen by
+// cached documents. That matches the single-process deployment model.
 type Registry struct {
 	store SnapshotRepo
+	cache *docCache // nil when caching is disabled
 
 	hookMu    sync.RWMutex
 	onPersist PersistHook
+	onHot     func(vaultID uuid.UUID, noteID string)
+
+	// appends counts PersistChange calls per note since the last hot
+	// signal. Purely in-memory and approximate (reset on restart); the
+	// Compactor's periodic scan is the source of truth.
+	appendsMu sync.Mutex
+	appends   map[docKey]int
+}
+
+// RegistryOption customises a Registry at construction time.
+type RegistryOption func(*Registry)
+
+// WithCacheBytes sets the document cache budget. n <= 0 disables the
+// cache: every LoadDoc then replays storage into a private document.
+func WithCacheBytes(n int64) RegistryOption {
+	return func(r *Registry) {
+		if n <= 0 {
+			r.cache = nil
+			return
+		}
+		r.cache = newDocCache(n)
+	}
 }
 
 // PersistHook observes every successfully-appended update (v3 F2: the
@@ -64,12 +93,68 @@ type Registry struct {
 type PersistHook func(vaultID uuid.UUID, noteID string, update []byte, originKind string)
 
 // NewRegistry constructs a Registry around the storage. store must not
-// be nil.
-func NewRegistry(store SnapshotRepo) *Registry {
+// be nil. The document cache defaults to DefaultCacheBytes.
+func NewRegistry(store SnapshotRepo, opts ...RegistryOption) *Registry {
 	if store == nil {
 		panic("crdt.NewRegistry: store is required")
 	}
-	return &Registry{store: store}
+	r := &Registry{
+		store:   store,
+		cache:   newDocCache(DefaultCacheBytes),
+		appends: make(map[docKey]int),
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// CacheStats returns the document cache counters. The zero value is
+// returned when caching is disabled.
+func (r *Registry) CacheStats() CacheStats {
+	if r.cache == nil {
+		return CacheStats{}
+	}
+	return r.cache.stats()
+}
+
+// Invalidate drops the cached document for (vaultID, noteID). Call it
+// whenever the note's CRDT rows disappear or are replaced wholesale
+// (delete, re-seed). Handles already borrowed stay usable; the next
+// LoadDoc reloads from storage. Moves keep the note id — and therefore
+// the cache key and the update log — so they need no invalidation.
+func (r *Registry) Invalidate(vaultID uuid.UUID, noteID string) {
+	key := docKey{vaultID, noteID}
+	if r.cache != nil {
+		r.cache.remove(key)
+	}
+	r.appendsMu.Lock()
+	delete(r.appends, key)
+	r.appendsMu.Unlock()
+}
+
+// InvalidateVault drops every cached document belonging to vaultID.
+// Call it after a vault is deleted so its documents do not linger until
+// LRU pressure pushes them out.
+func (r *Registry) InvalidateVault(vaultID uuid.UUID) {
+	if r.cache != nil {
+		r.cache.removeVault(vaultID)
+	}
+	r.appendsMu.Lock()
+	for k := range r.appends {
+		if k.vaultID == vaultID {
+			delete(r.appends, k)
+		}
+	}
+	r.appendsMu.Unlock()
+}
+
+// Close releases every cached document. Borrowed handles stay valid
+// until their holders close them.
+func (r *Registry) Close() {
+	if r.cache != nil {
+		r.cache.purge()
+	}
 }
 
 // SetOnPersist installs the persist hook. Pass nil to remove.
@@ -79,6 +164,15 @@ func (r *Registry) SetOnPersist(h PersistHook) {
 	r.onPersist = h
 }
 
+// setHotHook installs the callback fired when a note's in-memory append
+// counter reaches CompactCountThreshold. Used by the Compactor to pick
+// busy notes up before its next scheduled scan.
+func (r *Registry) setHotHook(h func(vaultID uuid.UUID, noteID string)) {
+	r.hookMu.Lock()
+	defer r.hookMu.Unlock()
+	r.onHot = h
+}
+
 func (r *Registry) firePersistHook(vaultID uuid.UUID, noteID string, update []byte, originKind string) {
 	r.hookMu.RLock()
 	h := r.onPersist
@@ -88,15 +182,38 @@ func (r *Registry) firePersistHook(vaultID uuid.UUID, noteID string, update []by
 	}
 }
 
-// LoadDoc fetches the persisted snapshot (if any) plus every update in
-// the log and returns a freshly-allocated yrs document reflecting their
-// merged state. The caller owns Close().
+// LoadDoc returns a handle onto the document for (vaultID, noteID). On
+// a cache hit the handle shares the cached YDoc; on a miss the persisted
+// snapshot (if any) plus every update in the log are replayed into a
+// fresh document, which is then cached. Either way the caller owns
+// Close() on the returned handle — closing never tears down a document
+// somebody else still holds.
 //
 // "Every update in the log" is correct rather than "every update since
 // the snapshot": Yjs CRDT merges are idempotent, so feeding the doc an
 // update it has already absorbed is a no-op. Avoiding the bookkeeping
 // keeps the storage schema simple.
 func (r *Registry) LoadDoc(ctx context.Context, vaultID uuid.UUID, noteID string) (*Doc, error) {
+	if r.cache == nil {
+		doc, _, err := r.loadFromStore(ctx, vaultID, noteID)
+		return doc, err
+	}
+	key := docKey{vaultID, noteID}
+	if doc := r.cache.get(key); doc != nil {
+		return doc, nil
+	}
+	epoch := r.cache.currentEpoch()
+	doc, size, err := r.loadFromStore(ctx, vaultID, noteID)
+	if err != nil {
+		return nil, err
+	}
+	return r.cache.add(key, doc, size, epoch), nil
+}
+
+// loadFromStore replays storage into a private document and returns it
+// together with the number of encoded bytes it absorbed (the cache's
+// size estimate).
+func (r *Registry) loadFromStore(ctx context.Context, vaultID uuid.UUID, noteID string) (*Doc, int64, error) {
 	var initial []byte
 	snap, err := r.store.GetSnapshot(ctx, vaultID, noteID)
 	if err == nil {
@@ -105,29 +222,35 @@ func (r *Registry) LoadDoc(ctx context.Context, vaultID uuid.UUID, noteID string
 
 	doc, err := LoadDoc(initial)
 	if err != nil {
-		return nil, fmt.Errorf("crdt registry: load snapshot: %w", err)
+		return nil, 0, fmt.Errorf("crdt registry: load snapshot: %w", err)
 	}
+	size := int64(len(initial))
 
 	updates, err := r.store.ListUpdatesSince(ctx, vaultID, noteID, 0, 0)
 	if err != nil {
 		_ = doc.Close()
-		return nil, fmt.Errorf("crdt registry: list updates: %w", err)
+		return nil, 0, fmt.Errorf("crdt registry: list updates: %w", err)
 	}
 	for _, u := range updates {
 		if err := doc.ApplyUpdate(u.Update); err != nil {
 			_ = doc.Close()
-			return nil, fmt.Errorf("crdt registry: replay update %d: %w", u.ID, err)
+			return nil, 0, fmt.Errorf("crdt registry: replay update %d: %w", u.ID, err)
 		}
+		size += int64(len(u.Update))
 	}
-	return doc, nil
+	return doc, size, nil
 }
 
-// PersistChange appends an update produced by a transaction on doc, then
-// runs compaction if the log has grown past either threshold. doc must
-// already reflect the change captured in update — typically you get
+// PersistChange appends an update produced by a transaction on doc. doc
+// must already reflect the change captured in update — typically you get
 // `update` from doc.ApplyTextDiff and pass the same doc straight in.
+// Compaction is not done here: the write path only bumps an in-memory
+// counter that nudges the Compactor once the note looks busy.
 //
 // originUserID may be uuid.Nil for system-initiated writes.
+//
+// When the append fails the cached document (which already absorbed the
+// change) is dropped so the next LoadDoc matches storage again.
 func (r *Registry) PersistChange(
 	ctx context.Context,
 	vaultID uuid.UUID, noteID string,
@@ -137,21 +260,38 @@ func (r *Registry) PersistChange(
 	if len(update) == 0 {
 		return nil
 	}
+	key := docKey{vaultID, noteID}
 	if _, err := r.store.AppendUpdate(ctx, vaultID, noteID, update, originUserID, originKind); err != nil {
+		if r.cache != nil {
+			r.cache.remove(key)
+		}
 		return err
 	}
+	if r.cache != nil {
+		r.cache.noteWrite(key, doc, int64(len(update)))
+	}
 	r.firePersistHook(vaultID, noteID, update, originKind)
+	r.countAppend(key)
+	return nil
+}
 
-	count, bytes, err := r.store.CountUpdates(ctx, vaultID, noteID)
-	if err != nil {
-		// Append succeeded; failure to *check* size shouldn't fail the
-		// request. Compaction will catch up on the next write.
-		return nil
+func (r *Registry) countAppend(key docKey) {
+	r.appendsMu.Lock()
+	r.appends[key]++
+	hot := r.appends[key] >= CompactCountThreshold
+	if hot {
+		delete(r.appends, key)
 	}
-	if count < CompactCountThreshold && bytes < CompactBytesThreshold {
-		return nil
+	r.appendsMu.Unlock()
+	if !hot {
+		return
+	}
+	r.hookMu.RLock()
+	h := r.onHot
+	r.hookMu.RUnlock()
+	if h != nil {
+		h(key.vaultID, key.noteID)
 	}
-	return r.compact(ctx, vaultID, noteID, doc)
 }
 
 // InitFromText creates a fresh doc, applies `body` as the initial text,
@@ -181,31 +321,105 @@ func (r *Registry) InitFromText(
 	if err := r.store.UpsertSnapshot(ctx, vaultID, noteID, state); err != nil {
 		return err
 	}
+	// The snapshot replaces whatever was stored for this id before (a
+	// re-seed after delete, a vault copy); drop any stale cached doc.
+	r.Invalidate(vaultID, noteID)
 	// We do not append an entry to the update log: the snapshot now is
 	// the full base state. Subsequent diffs (web, tui-diff) will append
 	// against it.
 	return nil
 }
 
-// compact captures the current doc state as a new snapshot and deletes
-// every update with id <= maxID where maxID is the highest log entry at
+// HasState reports whether anything is persisted for the note: a
+// snapshot or at least one logged update. An empty document and a note
+// that was never seeded both load as ""; this tells them apart.
+func (r *Registry) HasState(ctx context.Context, vaultID uuid.UUID, noteID string) (bool, error) {
+	if _, err := r.store.GetSnapshot(ctx, vaultID, noteID); err == nil {
+		return true, nil
+	}
+	maxID, err := r.store.HighestUpdateID(ctx, vaultID, noteID)
+	if err != nil {
+		return false, fmt.Errorf("crdt registry: highest update: %w", err)
+	}
+	return maxID > 0, nil
+}
+
+// CompactResult describes one Registry.Compact call.
+type CompactResult struct {
+	// Rewritten is false when there was nothing to fold and the rebuilt
+	// state was not meaningfully smaller than the stored snapshot.
+	Rewritten   bool
+	RowsDeleted int64
+	// BytesBefore is the stored snapshot plus every log row; BytesAfter
+	// is the new snapshot. Rows appended during the compaction are not
+	// counted on either side.
+	BytesBefore int64
+	BytesAfter  int64
+}
+
+// Reclaimed returns BytesBefore - BytesAfter, or 0 when nothing shrank.
+func (c CompactResult) Reclaimed() int64 {
+	if !c.Rewritten || c.BytesAfter >= c.BytesBefore {
+		return 0
+	}
+	return c.BytesBefore - c.BytesAfter
+}
+
+// tombstoneGain is the shrink ratio a snapshot-only rewrite must reach to
+// be worth the write: the rebuilt state has to be at most 90% of the
+// stored one.
+const tombstoneGain = 0.9
+
+// Compact folds the update log of a note into a new snapshot and deletes
+// every update with id <= maxID, where maxID is the highest log entry at
 // the moment we read it. Updates written concurrently after that read
 // survive — they reference state already absorbed by the snapshot, so
 // re-applying them on the next load is a CRDT no-op.
-func (r *Registry) compact(ctx context.Context, vaultID uuid.UUID, noteID string, doc *Doc) error {
+//
+// The new state is built from storage into a private document rather
+// than taken from the cache: every row <= maxID is guaranteed to be in
+// it, whichever handle produced the row. Replaying into a fresh doc is
+// also what garbage-collects tombstones — yrs collapses deleted items
+// into GC ranges on commit, dropping their content, so text that was
+// typed and deleted again costs a few bytes in the new snapshot instead
+// of its full length. With an empty log the snapshot is only rewritten
+// when that shrinks it by at least 10%.
+//
+// Callers must serialise Compact per note, across replicas too: the
+// snapshot write is unconditional, so an older pass finishing after a
+// newer one would drop the updates the newer one deleted. The Compactor
+// does, with its in-process lock and a CompactionLocker.
+func (r *Registry) Compact(ctx context.Context, vaultID uuid.UUID, noteID string) (CompactResult, error) {
 	maxID, err := r.store.HighestUpdateID(ctx, vaultID, noteID)
 	if err != nil {
-		return fmt.Errorf("crdt compact: max id: %w", err)
+		return CompactResult{}, fmt.Errorf("crdt compact: max id: %w", err)
+	}
+	doc, loaded, err := r.loadFromStore(ctx, vaultID, noteID)
+	if err != nil {
+		return CompactResult{}, fmt.Errorf("crdt compact: %w", err)
 	}
+	defer doc.Close()
 	state, err := doc.EncodeStateAsUpdate()
 	if err != nil {
-		return fmt.Errorf("crdt compact: encode state: %w", err)
+		return CompactResult{}, fmt.Errorf("crdt compact: encode state: %w", err)
+	}
+	res := CompactResult{BytesBefore: loaded, BytesAfter: int64(len(state))}
+	if maxID == 0 && float64(len(state)) > float64(loaded)*tombstoneGain {
+		return res, nil
 	}
 	if err := r.store.UpsertSnapshot(ctx, vaultID, noteID, state); err != nil {
-		return fmt.Errorf("crdt compact: write snapshot: %w", err)
+		return CompactResult{}, fmt.Errorf("crdt compact: write snapshot: %w", err)
+	}
+	res.Rewritten = true
+	if maxID > 0 {
+		n, err := r.store.DeleteUpdatesUpTo(ctx, vaultID, noteID, maxID)
+		if err != nil {
+			return res, fmt.Errorf("crdt compact: delete updates: %w", err)
+		}
+		res.RowsDeleted = n
 	}
-	if _, err := r.store.DeleteUpdatesUpTo(ctx, vaultID, noteID, maxID); err != nil {
-		return fmt.Errorf("crdt compact: delete updates: %w", err)
+	if r.cache != nil {
+		r.cache.resize(docKey{vaultID, noteID}, int64(len(state)))
 	}
-	return nil
+	return res, nil
 }
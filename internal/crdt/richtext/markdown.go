@@ -0,0 +1,254 @@
+// Package richtext renders the XML serialisation of a Y.XmlFragment,
+// as produced by y-prosemirror / TipTap editors, into markdown. It is
+// the bridge that keeps the on-disk .md mirror readable for notes whose
+// authoritative body lives in the structured "prosemirror" root rather
+// than the plain "content" YText.
+//
+// The conversion is lossy by design: nodes and marks without a markdown
+// equivalent are flattened to their text content. Both camelCase
+// (TipTap) and snake_case (prosemirror-schema-basic) node names are
+// recognised.
+package richtext
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// node is one element or text run of the parsed fragment.
+type node struct {
+	name     string // empty for text runs
+	attrs    map[string]string
+	children []*node
+	text     string
+}
+
+// Markdown converts the XML string of a Y.XmlFragment into markdown.
+// The input may be wrapped in a single synthetic root element (yrs
+// renders the fragment itself as an element); unknown elements are
+// transparently descended into. The result ends in exactly one newline
+// unless it is empty.
+func Markdown(fragmentXML string) (string, error) {
+	root, err := parse(fragmentXML)
+	if err != nil {
+		return "", err
+	}
+	blocks := renderBlocks(root.children)
+	if len(blocks) == 0 {
+		return "", nil
+	}
+	return strings.Join(blocks, "\n\n") + "\n", nil
+}
+
+// parse builds a node tree from the fragment XML. yrs does not escape
+// text content, so the decoder runs in non-strict mode with HTML
+// entities to tolerate stray ampersands.
+func parse(s string) (*node, error) {
+	dec := xml.NewDecoder(strings.NewReader(s))
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+
+	root := &node{name: "#root"}
+	stack := []*node{root}
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("richtext: parse: %w", err)
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			top.children = append(top.children, &node{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+// ---- Blocks ----------------------------------------------------------------
+
+func renderBlocks(nodes []*node) []string {
+	var out []string
+	var inline []*node
+	flush := func() {
+		if len(inline) == 0 {
+			return
+		}
+		if s := strings.TrimSpace(renderInline(inline)); s != "" {
+			out = append(out, s)
+		}
+		inline = nil
+	}
+	for _, n := range nodes {
+		if !isBlock(n) {
+			inline = append(inline, n)
+			continue
+		}
+		flush()
+		if s := renderBlock(n); s != "" {
+			out = append(out, s)
+		}
+	}
+	flush()
+	return out
+}
+
+func isBlock(n *node) bool {
+	if n.name == "" {
+		return false
+	}
+	switch n.name {
+	case "bold", "strong", "b", "italic", "em", "i", "code", "strike", "s",
+		"link", "a", "underline", "u", "hardBreak", "hard_break", "br",
+		"image", "img", "highlight", "subscript", "superscript", "textStyle":
+		return false
+	}
+	return true
+}
+
+func renderBlock(n *node) string {
+	switch n.name {
+	case "paragraph", "p":
+		return renderInline(n.children)
+	case "heading":
+		level, _ := strconv.Atoi(n.attrs["level"])
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return strings.Repeat("#", level) + " " + strings.TrimSpace(renderInline(n.children))
+	case "blockquote":
+		return prefixLines(strings.Join(renderBlocks(n.children), "\n\n"), "> ", "> ")
+	case "bulletList", "bullet_list":
+		return renderList(n, func(int) string { return "- " })
+	case "orderedList", "ordered_list":
+		start, err := strconv.Atoi(n.attrs["start"])
+		if err != nil || start < 0 {
+			start = 1
+		}
+		return renderList(n, func(i int) string { return strconv.Itoa(start+i) + ". " })
+	case "taskList", "task_list":
+		return renderList(n, func(int) string { return "- " })
+	case "codeBlock", "code_block":
+		lang := n.attrs["language"]
+		return "```" + lang + "\n" + strings.TrimRight(textOf(n), "\n") + "\n```"
+	case "horizontalRule", "horizontal_rule", "hr":
+		return "---"
+	}
+	// Unknown container (including the fragment wrapper itself).
+	return strings.Join(renderBlocks(n.children), "\n\n")
+}
+
+func renderList(n *node, marker func(i int) string) string {
+	var lines []string
+	i := 0
+	for _, item := range n.children {
+		if item.name == "" {
+			continue
+		}
+		m := marker(i)
+		if item.name == "taskItem" || item.name == "task_item" {
+			if item.attrs["checked"] == "true" {
+				m += "[x] "
+			} else {
+				m += "[ ] "
+			}
+		}
+		body := strings.Join(renderBlocks(item.children), "\n")
+		lines = append(lines, prefixLines(body, m, strings.Repeat(" ", len(marker(i)))))
+		i++
+	}
+	return strings.Join(lines, "\n")
+}
+
+// prefixLines prefixes the first line of s with first and every later
+// non-empty line with rest.
+func prefixLines(s, first, rest string) string {
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		switch {
+		case i == 0:
+			lines[i] = first + l
+		case l == "":
+			lines[i] = strings.TrimRight(rest, " ")
+		default:
+			lines[i] = rest + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ---- Inline ----------------------------------------------------------------
+
+func renderInline(nodes []*node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		if n.name == "" {
+			b.WriteString(n.text)
+			continue
+		}
+		inner := renderInline(n.children)
+		switch n.name {
+		case "bold", "strong", "b":
+			b.WriteString(wrap(inner, "**"))
+		case "italic", "em", "i":
+			b.WriteString(wrap(inner, "*"))
+		case "strike", "s":
+			b.WriteString(wrap(inner, "~~"))
+		case "code":
+			b.WriteString(wrap(inner, "`"))
+		case "link", "a":
+			if href := n.attrs["href"]; href != "" {
+				fmt.Fprintf(&b, "[%s](%s)", inner, href)
+			} else {
+				b.WriteString(inner)
+			}
+		case "hardBreak", "hard_break", "br":
+			b.WriteString("  \n")
+		case "image", "img":
+			fmt.Fprintf(&b, "![%s](%s)", n.attrs["alt"], n.attrs["src"])
+		default:
+			b.WriteString(inner)
+		}
+	}
+	return b.String()
+}
+
+// wrap surrounds s with the delimiter, keeping surrounding whitespace
+// outside it so "** bold**" never happens.
+func wrap(s, delim string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	lead := s[:strings.Index(s, trimmed)]
+	trail := s[len(lead)+len(trimmed):]
+	return lead + delim + trimmed + delim + trail
+}
+
+func textOf(n *node) string {
+	if n.name == "" {
+		return n.text
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		b.WriteString(textOf(c))
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package richtext
+
+import "testing"
+
+func TestMarkdown(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"empty fragment", "<UNDEFINED></UNDEFINED>", ""},
+		{
+			"paragraphs and headings",
+			`<heading level="2">Title</heading><paragraph>Hello <bold>world</bold></paragraph><paragraph>second</paragraph>`,
+			"## Title\n\nHello **world**\n\nsecond\n",
+		},
+		{
+			"wrapped fragment",
+			`<UNDEFINED><paragraph>x</paragraph></UNDEFINED>`,
+			"x\n",
+		},
+		{
+			"marks",
+			`<paragraph><italic>a</italic> <code>b</code> <strike>c </strike>d <link href="https://x.y">e</link></paragraph>`,
+			"*a* `b` ~~c~~ d [e](https://x.y)\n",
+		},
+		{
+			"bullet list",
+			`<bulletList><listItem><paragraph>one</paragraph></listItem><listItem><paragraph>two</paragraph><bulletList><listItem><paragraph>nested</paragraph></listItem></bulletList></listItem></bulletList>`,
+			"- one\n- two\n  - nested\n",
+		},
+		{
+			"ordered list with start",
+			`<ordered_list start="3"><list_item><paragraph>a</paragraph></list_item><list_item><paragraph>b</paragraph></list_item></ordered_list>`,
+			"3. a\n4. b\n",
+		},
+		{
+			"task list",
+			`<taskList><taskItem checked="true"><paragraph>done</paragraph></taskItem><taskItem checked="false"><paragraph>todo</paragraph></taskItem></taskList>`,
+			"- [x] done\n- [ ] todo\n",
+		},
+		{
+			"code block",
+			`<codeBlock language="go">fmt.Println(1)</codeBlock>`,
+			"```go\nfmt.Println(1)\n```\n",
+		},
+		{
+			"blockquote and rule",
+			`<blockquote><paragraph>q1</paragraph><paragraph>q2</paragraph></blockquote><horizontalRule></horizontalRule>`,
+			"> q1\n>\n> q2\n\n---\n",
+		},
+		{
+			"unescaped ampersand",
+			`<paragraph>salt & pepper</paragraph>`,
+			"salt & pepper\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Markdown(tc.in)
+			if err != nil {
+				t.Fatalf("Markdown: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package yjs
+
+import "fmt"
+
+// Content reference numbers (the low five bits of a struct's info
+// byte). 0 and 10 are the GC and Skip structs, not item content.
+const (
+	refGC      = 0
+	refDeleted = 1
+	refJSON    = 2
+	refBinary  = 3
+	refString  = 4
+	refEmbed   = 5
+	refFormat  = 6
+	refType    = 7
+	refAny     = 8
+	refDoc     = 9
+	refSkip    = 10
+)
+
+// Shared type references (ContentType).
+const (
+	typeArray       = 0
+	typeMap         = 1
+	typeText        = 2
+	typeXMLElement  = 3
+	typeXMLFragment = 4
+	typeXMLHook     = 5
+	typeXMLText     = 6
+)
+
+// content is an Item's payload. Lengths are in clock units: UTF-16 code
+// units for strings, elements for Any/JSON, one for everything else.
+type content interface {
+	ref() byte
+	length() int
+	countable() bool
+	// splice truncates the receiver to [0, offset) and returns the rest.
+	splice(offset int) content
+	// mergeWith appends right in place, reporting success.
+	mergeWith(right content) bool
+	write(e *encoder, offset int)
+}
+
+func readContent(d *decoder, info byte) (content, error) {
+	switch info & 0x1f {
+	case refDeleted:
+		n, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errMalformed
+		}
+		return &contentDeleted{n: int(n)}, nil
+	case refJSON:
+		n, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errMalformed
+		}
+		c := &contentJSON{}
+		for i := uint64(0); i < n; i++ {
+			s, err := d.varString()
+			if err != nil {
+				return nil, err
+			}
+			c.vals = append(c.vals, s)
+		}
+		return c, nil
+	case refBinary:
+		b, err := d.varBytes()
+		if err != nil {
+			return nil, err
+		}
+		return &contentBinary{b: append([]byte(nil), b...)}, nil
+	case refString:
+		s, err := d.varString()
+		if err != nil {
+			return nil, err
+		}
+		u := toUTF16(s)
+		if len(u) == 0 {
+			return nil, errMalformed
+		}
+		return &contentString{s: u}, nil
+	case refEmbed:
+		s, err := d.varString()
+		if err != nil {
+			return nil, err
+		}
+		return &contentEmbed{json: s}, nil
+	case refFormat:
+		k, err := d.varString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.varString()
+		if err != nil {
+			return nil, err
+		}
+		return &contentFormat{key: k, value: v}, nil
+	case refType:
+		ref, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		t := &ytype{ref: int(ref), smap: map[string]*block{}}
+		switch ref {
+		case typeArray, typeMap, typeText, typeXMLFragment, typeXMLText:
+		case typeXMLElement, typeXMLHook:
+			if t.name, err = d.varString(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errMalformed
+		}
+		return &contentType{t: t}, nil
+	case refAny:
+		n, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errMalformed
+		}
+		c := &contentAny{}
+		for i := uint64(0); i < n; i++ {
+			raw, err := d.rawAny()
+			if err != nil {
+				return nil, err
+			}
+			c.vals = append(c.vals, raw)
+		}
+		return c, nil
+	case refDoc:
+		guid, err := d.varString()
+		if err != nil {
+			return nil, err
+		}
+		opts, err := d.rawAny()
+		if err != nil {
+			return nil, err
+		}
+		return &contentDoc{guid: guid, opts: opts}, nil
+	}
+	return nil, fmt.Errorf("yjs: unknown content ref %d", info&0x1f)
+}
+
+// ---- Deleted ---------------------------------------------------------------
+
+type contentDeleted struct{ n int }
+
+func (c *contentDeleted) ref() byte       { return refDeleted }
+func (c *contentDeleted) length() int     { return c.n }
+func (c *contentDeleted) countable() bool { return false }
+func (c *contentDeleted) splice(off int) content {
+	r := &contentDeleted{n: c.n - off}
+	c.n = off
+	return r
+}
+func (c *contentDeleted) mergeWith(r content) bool {
+	rr, ok := r.(*contentDeleted)
+	if ok {
+		c.n += rr.n
+	}
+	return ok
+}
+func (c *contentDeleted) write(e *encoder, off int) { e.varUint(uint64(c.n - off)) }
+
+// ---- String ----------------------------------------------------------------
+
+// contentString holds JS string code units so clock arithmetic and
+// splitting follow Yjs exactly.
+type contentString struct{ s []uint16 }
+
+func (c *contentString) ref() byte       { return refString }
+func (c *contentString) length() int     { return len(c.s) }
+func (c *contentString) countable() bool { return true }
+
+// splice mirrors ContentString.splice: a split inside a surrogate pair
+// replaces both halves with U+FFFD rather than produce invalid UTF-16.
+func (c *contentString) splice(off int) content {
+	right := &contentString{s: append([]uint16(nil), c.s[off:]...)}
+	c.s = c.s[:off:off]
+	if last := c.s[off-1]; last >= 0xd800 && last <= 0xdbff {
+		c.s[off-1] = 0xfffd
+		right.s[0] = 0xfffd
+	}
+	return right
+}
+func (c *contentString) mergeWith(r content) bool {
+	rr, ok := r.(*contentString)
+	if ok {
+		c.s = append(c.s, rr.s...)
+	}
+	return ok
+}
+func (c *contentString) write(e *encoder, off int) { e.utf16String(c.s[off:]) }
+
+// ---- Any / JSON ------------------------------------------------------------
+
+// contentAny keeps each element's encoded bytes.
+type contentAny struct{ vals [][]byte }
+
+func (c *contentAny) ref() byte       { return refAny }
+func (c *contentAny) length() int     { return len(c.vals) }
+func (c *contentAny) countable() bool { return true }
+func (c *contentAny) splice(off int) content {
+	r := &contentAny{vals: append([][]byte(nil), c.vals[off:]...)}
+	c.vals = c.vals[:off:off]
+	return r
+}
+func (c *contentAny) mergeWith(r content) bool {
+	rr, ok := r.(*contentAny)
+	if ok {
+		c.vals = append(c.vals, rr.vals...)
+	}
+	return ok
+}
+func (c *contentAny) write(e *encoder, off int) {
+	e.varUint(uint64(len(c.vals) - off))
+	for _, v := range c.vals[off:] {
+		e.buf = append(e.buf, v...)
+	}
+}
+
+// contentJSON is the legacy JSON content; values stay stringified.
+type contentJSON struct{ vals []string }
+
+func (c *contentJSON) ref() byte       { return refJSON }
+func (c *contentJSON) length() int     { return len(c.vals) }
+func (c *contentJSON) countable() bool { return true }
+func (c *contentJSON) splice(off int) content {
+	r := &contentJSON{vals: append([]string(nil), c.vals[off:]...)}
+	c.vals = c.vals[:off:off]
+	return r
+}
+func (c *contentJSON) mergeWith(r content) bool {
+	rr, ok := r.(*contentJSON)
+	if ok {
+		c.vals = append(c.vals, rr.vals...)
+	}
+	return ok
+}
+func (c *contentJSON) write(e *encoder, off int) {
+	e.varUint(uint64(len(c.vals) - off))
+	for _, v := range c.vals[off:] {
+		e.varString(v)
+	}
+}
+
+// ---- Single-unit contents --------------------------------------------------
+
+type contentBinary struct{ b []byte }
+
+func (c *contentBinary) ref() byte                 { return refBinary }
+func (c *contentBinary) length() int               { return 1 }
+func (c *contentBinary) countable() bool           { return true }
+func (c *contentBinary) splice(int) content        { panic("yjs: split of binary content") }
+func (c *contentBinary) mergeWith(content) bool    { return false }
+func (c *contentBinary) write(e *encoder, off int) { e.varBytes(c.b) }
+
+type contentEmbed struct{ json string }
+
+func (c *contentEmbed) ref() byte                 { return refEmbed }
+func (c *contentEmbed) length() int               { return 1 }
+func (c *contentEmbed) countable() bool           { return true }
+func (c *contentEmbed) splice(int) content        { panic("yjs: split of embed content") }
+func (c *contentEmbed) mergeWith(content) bool    { return false }
+func (c *contentEmbed) write(e *encoder, off int) { e.varString(c.json) }
+
+// contentFormat is a rich-text formatting marker; value is JSON.
+type contentFormat struct{ key, value string }
+
+func (c *contentFormat) ref() byte              { return refFormat }
+func (c *contentFormat) length() int            { return 1 }
+func (c *contentFormat) countable() bool        { return false }
+func (c *contentFormat) splice(int) content     { panic("yjs: split of format content") }
+func (c *contentFormat) mergeWith(content) bool { return false }
+func (c *contentFormat) write(e *encoder, off int) {
+	e.varString(c.key)
+	e.varString(c.value)
+}
+
+type contentType struct{ t *ytype }
+
+func (c *contentType) ref() byte              { return refType }
+func (c *contentType) length() int            { return 1 }
+func (c *contentType) countable() bool        { return true }
+func (c *contentType) splice(int) content     { panic("yjs: split of type content") }
+func (c *contentType) mergeWith(content) bool { return false }
+func (c *contentType) write(e *encoder, off int) {
+	e.varUint(uint64(c.t.ref))
+	if c.t.ref == typeXMLElement || c.t.ref == typeXMLHook {
+		e.varString(c.t.name)
+	}
+}
+
+// contentDoc is a subdocument reference; opts stays encoded.
+type contentDoc struct {
+	guid string
+	opts []byte
+}
+
+func (c *contentDoc) ref() byte              { return refDoc }
+func (c *contentDoc) length() int            { return 1 }
+func (c *contentDoc) countable() bool        { return true }
+func (c *contentDoc) splice(int) content     { panic("yjs: split of doc content") }
+func (c *contentDoc) mergeWith(content) bool { return false }
+func (c *contentDoc) write(e *encoder, off int) {
+	e.varString(c.guid)
+	e.buf = append(e.buf, c.opts...)
+}
@@ -0,0 +1,230 @@
+// Package yjs is a pure-Go implementation of the subset of the Yjs CRDT
+// that lumi-server needs without cgo: decoding and encoding lib0-v1
+// updates and state vectors, integrating remote structs (including
+// out-of-order delivery), local text edits on Y.Text roots, and
+// read-only projections of Y.XmlFragment and Y.Map roots.
+//
+// The integration algorithm, struct store and encoders follow the Yjs
+// reference implementation (src/utils/encoding.js, src/structs/Item.js)
+// so documents converge with Yjs and yrs peers on the same update
+// streams. The lib0-v2 update format, undo, subdocuments and local
+// edits to non-text types are not implemented.
+//
+// A Doc is not safe for concurrent use; the crdt package wraps it in
+// the same per-document mutex it uses for yrs.
+package yjs
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+)
+
+// Doc is one Yjs document.
+type Doc struct {
+	clientID uint64
+	store    *store
+	roots    map[string]*ytype
+
+	// pending holds structs whose dependencies have not arrived yet and
+	// pendingDS the deletes that target them; both are retried on every
+	// ApplyUpdate and included in full-state encodings.
+	pending   map[uint64][]*block
+	pendingDS deleteSet
+}
+
+// NewDoc returns an empty document with a random client id.
+func NewDoc() *Doc { return NewDocWithClientID(newClientID()) }
+
+// NewDocWithClientID returns an empty document that writes local edits
+// as client id. Reusing a client id across live documents breaks
+// convergence; this exists for deterministic tests.
+func NewDocWithClientID(id uint64) *Doc {
+	return &Doc{clientID: id, store: newStore(), roots: map[string]*ytype{}}
+}
+
+// Yjs draws client ids from uint32 so they fit a JS number comfortably.
+func newClientID() uint64 { return uint64(rand.Uint32()) }
+
+// ClientID returns the id local edits are written under.
+func (d *Doc) ClientID() uint64 { return d.clientID }
+
+func (d *Doc) root(name string) *ytype {
+	t := d.roots[name]
+	if t == nil {
+		t = &ytype{ref: -1, rootName: name, smap: map[string]*block{}}
+		d.roots[name] = t
+	}
+	return t
+}
+
+// guard converts a panic raised while integrating into an error. An
+// inconsistent update can leave the document partially applied, exactly
+// as with the reference implementation; callers treat the error as
+// fatal for this document instance and reload it from storage.
+func guard(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if ce, ok := r.(corruptErr); ok {
+		*err = ce
+		return
+	}
+	*err = fmt.Errorf("yjs: inconsistent update: %v", r)
+}
+
+// ApplyUpdate integrates a lib0-v1 update. Structs whose dependencies
+// are missing are kept pending until a later update supplies them.
+func (d *Doc) ApplyUpdate(update []byte) (err error) {
+	if len(update) == 0 {
+		return nil
+	}
+	dec := &decoder{buf: update}
+	refs, err := d.readStructs(dec)
+	if err != nil {
+		return err
+	}
+	ds, err := readDeleteSet(dec)
+	if err != nil {
+		return err
+	}
+	defer guard(&err)
+
+	tx := d.newTxn()
+	for c, bs := range d.pending {
+		refs[c] = append(refs[c], bs...)
+	}
+	for _, bs := range refs {
+		sort.SliceStable(bs, func(i, j int) bool { return bs[i].id.Clock < bs[j].id.Clock })
+	}
+	d.pending = tx.integrateStructs(refs)
+	ds.merge(d.pendingDS)
+	d.pendingDS = tx.applyDeleteSet(ds)
+	tx.cleanup()
+
+	// Someone else wrote under our client id: switch to a fresh one so
+	// our next local edit does not fork that history (Yjs does the same
+	// in cleanupTransactions).
+	if d.store.state(d.clientID) != tx.beforeState[d.clientID] {
+		d.clientID = newClientID()
+	}
+	return nil
+}
+
+// HasPending reports whether structs or deletes are waiting on missing
+// history.
+func (d *Doc) HasPending() bool { return len(d.pending) > 0 || len(d.pendingDS) > 0 }
+
+// StateVector returns the lib0-v1 encoded state vector of integrated
+// structs. Pending structs are not included, as in Yjs.
+func (d *Doc) StateVector() []byte { return encodeStateVector(d.store.stateVector()) }
+
+// EncodeStateAsUpdate returns a lib0-v1 update holding everything the
+// document has beyond the encoded state vector sv (all of it for an
+// empty sv), plus the full delete set.
+func (d *Doc) EncodeStateAsUpdate(sv []byte) ([]byte, error) {
+	target, err := DecodeStateVector(sv)
+	if err != nil {
+		return nil, err
+	}
+	return d.encodeUpdate(target), nil
+}
+
+// ---- Local transactions ----------------------------------------------------
+
+// Txn is a local transaction handed to Transact's callback.
+type Txn struct{ tx *txn }
+
+// Transact runs fn as one local transaction and returns the lib0-v1
+// update describing its changes (empty when nothing changed).
+func (d *Doc) Transact(fn func(*Txn)) (update []byte, err error) {
+	defer guard(&err)
+	tx := d.newTxn()
+	fn(&Txn{tx: tx})
+	tx.cleanup()
+	return tx.encode(), nil
+}
+
+// InsertText inserts s into the Y.Text root at the given UTF-16 index.
+// Indexes past the end append.
+func (t *Txn) InsertText(root string, index int, s string) {
+	if s == "" {
+		return
+	}
+	tx := t.tx
+	doc := tx.doc
+	p := doc.root(root)
+	if p.ref < 0 {
+		p.ref = typeText
+	}
+	left, right := tx.findPosition(p, index)
+	// minimizeAttributeChanges with no attributes: step over deleted
+	// items and format end markers so the insert lands where Yjs puts it.
+	for right != nil && (right.deleted || isNullFormat(right)) {
+		left, right = right, right.right
+	}
+	b := &block{
+		kind:    kindItem,
+		id:      ID{doc.clientID, doc.store.state(doc.clientID)},
+		left:    left,
+		right:   right,
+		parent:  p,
+		content: &contentString{s: toUTF16(s)},
+	}
+	if left != nil {
+		id := left.lastID()
+		b.origin = &id
+	}
+	if right != nil {
+		id := right.id
+		b.rightOrigin = &id
+	}
+	b.length = b.content.length()
+	b.integrate(tx, 0)
+}
+
+// DeleteText removes n UTF-16 code units starting at index from the
+// Y.Text root.
+func (t *Txn) DeleteText(root string, index, n int) {
+	if n <= 0 {
+		return
+	}
+	tx := t.tx
+	p := tx.doc.root(root)
+	_, right := tx.findPosition(p, index)
+	for n > 0 && right != nil {
+		if !right.deleted {
+			switch right.content.(type) {
+			case *contentString, *contentEmbed, *contentType:
+				if n < right.length {
+					tx.doc.store.getCleanStart(tx, ID{right.id.Client, right.id.Clock + uint64(n)})
+				}
+				n -= right.length
+				right.delete(tx)
+			}
+		}
+		right = right.right
+	}
+}
+
+// findPosition walks to the UTF-16 index in a text type, splitting the
+// item it falls inside, and returns the neighbours of that position.
+func (tx *txn) findPosition(p *ytype, index int) (left, right *block) {
+	right = p.start
+	for right != nil && index > 0 {
+		if !right.deleted && right.countable() {
+			if index < right.length {
+				tx.doc.store.getCleanStart(tx, ID{right.id.Client, right.id.Clock + uint64(index)})
+			}
+			index -= right.length
+		}
+		left, right = right, right.right
+	}
+	return left, right
+}
+
+func isNullFormat(b *block) bool {
+	f, ok := b.content.(*contentFormat)
+	return ok && f.value == "null"
+}
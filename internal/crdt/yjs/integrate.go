@@ -0,0 +1,294 @@
+package yjs
+
+// txn is one Yjs transaction: it records the pre-state and the ranges
+// it deleted so cleanup can garbage collect and merge, and so a local
+// transaction can encode exactly its own update.
+type txn struct {
+	doc         *Doc
+	beforeState map[uint64]uint64
+	deleted     deleteSet
+	touched     map[uint64]bool
+}
+
+func (d *Doc) newTxn() *txn {
+	return &txn{
+		doc:         d,
+		beforeState: d.store.stateVector(),
+		deleted:     deleteSet{},
+		touched:     map[uint64]bool{},
+	}
+}
+
+func (tx *txn) touch(client uint64) { tx.touched[client] = true }
+
+// cleanup mirrors Yjs' cleanupTransactions: collect content deleted in
+// this transaction, then merge adjacent compatible structs.
+func (tx *txn) cleanup() {
+	s := tx.doc.store
+	for client, ranges := range tx.deleted {
+		bs := s.clients[client]
+		for _, r := range ranges {
+			end := r.clock + r.len
+			for i := findIndex(bs, r.clock); i < len(bs) && bs[i].id.Clock < end; i++ {
+				if b := bs[i]; b.kind == kindItem && b.deleted {
+					b.gc(s, false)
+				}
+			}
+		}
+		tx.touch(client)
+	}
+	for client := range tx.touched {
+		s.mergeClient(client)
+	}
+}
+
+// getMissing resolves origins and parent against the store, or returns
+// the client whose history is still missing (Item.getMissing).
+func (b *block) getMissing(tx *txn, s *store) (uint64, bool) {
+	if b.kind != kindItem {
+		return 0, false
+	}
+	if o := b.origin; o != nil && o.Client != b.id.Client && o.Clock >= s.state(o.Client) {
+		return o.Client, true
+	}
+	if o := b.rightOrigin; o != nil && o.Client != b.id.Client && o.Clock >= s.state(o.Client) {
+		return o.Client, true
+	}
+	if p := b.parentID; p != nil && p.Client != b.id.Client && p.Clock >= s.state(p.Client) {
+		return p.Client, true
+	}
+
+	if b.origin != nil {
+		b.left = s.getCleanEnd(tx, *b.origin)
+		last := b.left.lastID()
+		b.origin = &last
+	}
+	if b.rightOrigin != nil {
+		b.right = s.getCleanStart(tx, *b.rightOrigin)
+		id := b.right.id
+		b.rightOrigin = &id
+	}
+	switch {
+	case (b.left != nil && b.left.kind == kindGC) || (b.right != nil && b.right.kind == kindGC):
+		b.parent = nil
+		b.parentID = nil
+	case b.parent == nil && b.parentID == nil:
+		if b.left != nil && b.left.kind == kindItem {
+			b.parent, b.parentSub = b.left.parent, b.left.parentSub
+		} else if b.right != nil && b.right.kind == kindItem {
+			b.parent, b.parentSub = b.right.parent, b.right.parentSub
+		}
+	case b.parentID != nil:
+		pi := s.get(*b.parentID)
+		b.parentID = nil
+		if ct, ok := pi.content.(*contentType); ok && pi.kind == kindItem {
+			b.parent = ct.t
+		} else {
+			b.parent = nil
+		}
+	}
+	return 0, false
+}
+
+// integrate links the block into its parent using YATA conflict
+// resolution and adds it to the store (Item.integrate / GC.integrate).
+// offset > 0 means the first offset units are already known locally.
+func (b *block) integrate(tx *txn, offset int) {
+	s := tx.doc.store
+	if b.kind == kindGC {
+		if offset > 0 {
+			b.id.Clock += uint64(offset)
+			b.length -= offset
+		}
+		s.add(b)
+		tx.touch(b.id.Client)
+		return
+	}
+	if offset > 0 {
+		b.id.Clock += uint64(offset)
+		b.left = s.getCleanEnd(tx, ID{b.id.Client, b.id.Clock - 1})
+		last := b.left.lastID()
+		b.origin = &last
+		b.content = b.content.splice(offset)
+		b.length -= offset
+	}
+	if b.parent == nil {
+		gc := &block{kind: kindGC, id: b.id, length: b.length}
+		gc.integrate(tx, 0)
+		return
+	}
+	p := b.parent
+	if (b.left == nil && (b.right == nil || b.right.left != nil)) || (b.left != nil && b.left.right != b.right) {
+		left := b.left
+		var o *block
+		switch {
+		case left != nil:
+			o = left.right
+		case b.parentSub != nil:
+			o = p.smap[*b.parentSub]
+			for o != nil && o.left != nil {
+				o = o.left
+			}
+		default:
+			o = p.start
+		}
+		conflicting := map[*block]bool{}
+		beforeOrigin := map[*block]bool{}
+		for o != nil && o != b.right {
+			beforeOrigin[o] = true
+			conflicting[o] = true
+			if sameID(b.origin, o.origin) {
+				// case 1
+				if o.id.Client < b.id.Client {
+					left = o
+					clear(conflicting)
+				} else if sameID(b.rightOrigin, o.rightOrigin) {
+					break
+				}
+			} else if o.origin != nil && beforeOrigin[s.get(*o.origin)] {
+				// case 2
+				if !conflicting[s.get(*o.origin)] {
+					left = o
+					clear(conflicting)
+				}
+			} else {
+				break
+			}
+			o = o.right
+		}
+		b.left = left
+	}
+	if b.left != nil {
+		b.right = b.left.right
+		b.left.right = b
+	} else {
+		var r *block
+		if b.parentSub != nil {
+			r = p.smap[*b.parentSub]
+			for r != nil && r.left != nil {
+				r = r.left
+			}
+		} else {
+			r = p.start
+			p.start = b
+		}
+		b.right = r
+	}
+	if b.right != nil {
+		b.right.left = b
+	} else if b.parentSub != nil {
+		p.smap[*b.parentSub] = b
+		if b.left != nil {
+			b.left.delete(tx)
+		}
+	}
+	if b.parentSub == nil && b.content.countable() && !b.deleted {
+		p.length += b.length
+	}
+	s.add(b)
+	tx.touch(b.id.Client)
+	if ct, ok := b.content.(*contentType); ok {
+		ct.t.item = b
+	}
+	if (p.item != nil && p.item.deleted) || (b.parentSub != nil && b.right != nil) {
+		b.delete(tx)
+	}
+}
+
+// delete marks the item deleted and records it in the transaction.
+func (b *block) delete(tx *txn) {
+	if b.kind != kindItem || b.deleted {
+		return
+	}
+	if b.parent != nil && b.parentSub == nil && b.content.countable() {
+		b.parent.length -= b.length
+	}
+	b.deleted = true
+	tx.deleted.add(b.id.Client, b.id.Clock, uint64(b.length))
+	if ct, ok := b.content.(*contentType); ok {
+		for c := ct.t.start; c != nil; c = c.right {
+			c.delete(tx)
+		}
+		for _, c := range ct.t.smap {
+			c.delete(tx)
+		}
+	}
+}
+
+// gc drops the content of a deleted item. Children of a collected type
+// are replaced by GC structs outright (parentGCd).
+func (b *block) gc(s *store, parentGCd bool) {
+	if ct, ok := b.content.(*contentType); ok {
+		for c := ct.t.start; c != nil; {
+			next := c.right
+			if c.kind == kindItem {
+				c.gc(s, true)
+			}
+			c = next
+		}
+		ct.t.start = nil
+		for _, c := range ct.t.smap {
+			for c != nil {
+				prev := c.left
+				if c.kind == kindItem {
+					c.gc(s, true)
+				}
+				c = prev
+			}
+		}
+		ct.t.smap = map[string]*block{}
+	}
+	if parentGCd {
+		s.replace(b, &block{kind: kindGC, id: b.id, length: b.length})
+		return
+	}
+	if _, ok := b.content.(*contentDeleted); !ok {
+		b.content = &contentDeleted{n: b.length}
+	}
+}
+
+// mergeClient merges adjacent structs of one client where Yjs would
+// (Item.mergeWith / GC.mergeWith). Merging never changes semantics; it
+// keeps the store and its encodings compact.
+func (s *store) mergeClient(client uint64) {
+	bs := s.clients[client]
+	if len(bs) < 2 {
+		return
+	}
+	out := bs[:1]
+	for _, r := range bs[1:] {
+		l := out[len(out)-1]
+		if l.tryMerge(r) {
+			continue
+		}
+		out = append(out, r)
+	}
+	for i := len(out); i < len(bs); i++ {
+		bs[i] = nil
+	}
+	s.clients[client] = out
+}
+
+func (l *block) tryMerge(r *block) bool {
+	if l.kind != r.kind || l.id.Clock+uint64(l.length) != r.id.Clock {
+		return false
+	}
+	if l.kind == kindGC {
+		l.length += r.length
+		return true
+	}
+	lastL := l.lastID()
+	if !sameID(r.origin, &lastL) || l.right != r || !sameID(l.rightOrigin, r.rightOrigin) ||
+		l.deleted != r.deleted || l.content.ref() != r.content.ref() || !l.content.mergeWith(r.content) {
+		return false
+	}
+	if r.parentSub != nil && r.parent != nil && r.parent.smap[*r.parentSub] == r {
+		r.parent.smap[*r.parentSub] = l
+	}
+	l.right = r.right
+	if l.right != nil {
+		l.right.left = l
+	}
+	l.length += r.length
+	return true
+}
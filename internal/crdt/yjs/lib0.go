@@ -0,0 +1,303 @@
+package yjs
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// lib0 primitive codecs, as used by Yjs' UpdateEncoderV1 /
+// UpdateDecoderV1. Only what the v1 update and state-vector formats
+// need is implemented.
+
+var (
+	errTruncated = errors.New("yjs: truncated update")
+	errOverflow  = errors.New("yjs: varint overflows 53 bits")
+	errMalformed = errors.New("yjs: malformed update")
+)
+
+type encoder struct{ buf []byte }
+
+func (e *encoder) uint8(b byte) { e.buf = append(e.buf, b) }
+
+func (e *encoder) varUint(n uint64) {
+	for n > 0x7f {
+		e.buf = append(e.buf, byte(n&0x7f)|0x80)
+		n >>= 7
+	}
+	e.buf = append(e.buf, byte(n))
+}
+
+func (e *encoder) varBytes(b []byte) {
+	e.varUint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) varString(s string) { e.varBytes([]byte(s)) }
+
+// utf16String writes a JS string held as UTF-16 code units. Lone
+// surrogates become U+FFFD, matching TextEncoder.
+func (e *encoder) utf16String(s []uint16) { e.varString(string(utf16.Decode(s))) }
+
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *decoder) done() bool { return d.pos >= len(d.buf) }
+
+func (d *decoder) uint8() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, errTruncated
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+// varUint reads a lib0 unsigned varint. Values beyond 2^53 are
+// rejected: JS numbers cannot hold them, so no valid peer emits them.
+func (d *decoder) varUint() (uint64, error) {
+	var n uint64
+	var shift uint
+	for {
+		b, err := d.uint8()
+		if err != nil {
+			return 0, err
+		}
+		n |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			break
+		}
+		shift += 7
+		if shift > 53 {
+			return 0, errOverflow
+		}
+	}
+	if n > 1<<53 {
+		return 0, errOverflow
+	}
+	return n, nil
+}
+
+// varInt reads a lib0 signed varint: the first byte carries a sign bit
+// (0x40) and six value bits, the rest seven bits each.
+func (d *decoder) varInt() (int64, error) {
+	b, err := d.uint8()
+	if err != nil {
+		return 0, err
+	}
+	n := int64(b & 0x3f)
+	neg := b&0x40 != 0
+	shift := uint(6)
+	for b&0x80 != 0 {
+		if b, err = d.uint8(); err != nil {
+			return 0, err
+		}
+		n |= int64(b&0x7f) << shift
+		shift += 7
+		if shift > 60 {
+			return 0, errOverflow
+		}
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+func (d *decoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.buf[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *decoder) varBytes() ([]byte, error) {
+	n, err := d.varUint()
+	if err != nil {
+		return nil, err
+	}
+	return d.take(n)
+}
+
+func (d *decoder) varString() (string, error) {
+	b, err := d.varBytes()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		// TextDecoder semantics: invalid sequences become U+FFFD.
+		return string([]rune(string(b))), nil
+	}
+	return string(b), nil
+}
+
+// ---- lib0 Any --------------------------------------------------------------
+
+// lib0 Any type tags (encoding.writeAny).
+const (
+	anyUndefined = 127
+	anyNull      = 126
+	anyInteger   = 125
+	anyFloat32   = 124
+	anyFloat64   = 123
+	anyBigInt    = 122
+	anyFalse     = 121
+	anyTrue      = 120
+	anyString    = 119
+	anyObject    = 118
+	anyArray     = 117
+	anyBytes     = 116
+)
+
+// maxAnyDepth bounds object/array nesting so a hostile update cannot
+// blow the stack.
+const maxAnyDepth = 64
+
+// rawAny consumes one Any value and returns its encoded bytes verbatim,
+// so content can be re-emitted without a decode/encode round trip.
+func (d *decoder) rawAny() ([]byte, error) {
+	start := d.pos
+	if err := d.skipAny(0); err != nil {
+		return nil, err
+	}
+	return d.buf[start:d.pos], nil
+}
+
+func (d *decoder) skipAny(depth int) error {
+	if depth > maxAnyDepth {
+		return errMalformed
+	}
+	tag, err := d.uint8()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case anyUndefined, anyNull, anyFalse, anyTrue:
+		return nil
+	case anyInteger:
+		_, err = d.varInt()
+	case anyFloat32:
+		_, err = d.take(4)
+	case anyFloat64, anyBigInt:
+		_, err = d.take(8)
+	case anyString, anyBytes:
+		_, err = d.varBytes()
+	case anyObject:
+		var n uint64
+		if n, err = d.varUint(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err = d.varBytes(); err != nil {
+				return err
+			}
+			if err = d.skipAny(depth + 1); err != nil {
+				return err
+			}
+		}
+	case anyArray:
+		var n uint64
+		if n, err = d.varUint(); err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err = d.skipAny(depth + 1); err != nil {
+				return err
+			}
+		}
+	default:
+		return errMalformed
+	}
+	return err
+}
+
+// readAny decodes one Any value into Go: nil, bool, int64, float64,
+// string, []byte, []any or map[string]any. undefined decodes as nil.
+func (d *decoder) readAny(depth int) (any, error) {
+	if depth > maxAnyDepth {
+		return nil, errMalformed
+	}
+	tag, err := d.uint8()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case anyUndefined, anyNull:
+		return nil, nil
+	case anyFalse:
+		return false, nil
+	case anyTrue:
+		return true, nil
+	case anyInteger:
+		return d.varInt()
+	case anyFloat32:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case anyFloat64:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case anyBigInt:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case anyString:
+		return d.varString()
+	case anyBytes:
+		return d.varBytes()
+	case anyObject:
+		n, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any)
+		for i := uint64(0); i < n; i++ {
+			k, err := d.varString()
+			if err != nil {
+				return nil, err
+			}
+			if m[k], err = d.readAny(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case anyArray:
+		n, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		var out []any
+		for i := uint64(0); i < n; i++ {
+			v, err := d.readAny(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	return nil, errMalformed
+}
+
+// decodeAny decodes a raw Any blob captured by rawAny.
+func decodeAny(raw []byte) any {
+	d := &decoder{buf: raw}
+	v, _ := d.readAny(0)
+	return v
+}
+
+// toUTF16 converts a Go string to JS string code units.
+func toUTF16(s string) []uint16 { return utf16.Encode([]rune(s)) }
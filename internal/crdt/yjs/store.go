@@ -0,0 +1,198 @@
+package yjs
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ID identifies one clock unit of one client's history.
+type ID struct {
+	Client uint64
+	Clock  uint64
+}
+
+func sameID(a, b *ID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+type blockKind uint8
+
+const (
+	kindItem blockKind = iota
+	kindGC
+	kindSkip // only ever seen in decoded updates, never stored
+)
+
+// block is a Yjs struct: an Item, or a GC / Skip placeholder that only
+// carries an id range.
+type block struct {
+	kind   blockKind
+	id     ID
+	length int
+
+	origin      *ID
+	rightOrigin *ID
+	left, right *block
+
+	// parent is the resolved parent type. Before integration a decoded
+	// item may instead name its parent by parentID (a nested type); a
+	// nil parent with both origins nil and no parentID means the item
+	// will be garbage collected on integration.
+	parent    *ytype
+	parentID  *ID
+	parentSub *string
+
+	content content
+	deleted bool
+}
+
+func (b *block) lastID() ID {
+	return ID{b.id.Client, b.id.Clock + uint64(b.length) - 1}
+}
+
+func (b *block) isDeleted() bool { return b.kind != kindItem || b.deleted }
+
+func (b *block) countable() bool { return b.kind == kindItem && b.content.countable() }
+
+// ytype is a shared type: a root (item == nil) or one nested in an
+// item's ContentType.
+type ytype struct {
+	ref      int    // type reference; -1 for roots not yet given a type
+	name     string // XML element node name / hook name
+	rootName string
+	item     *block
+	start    *block
+	smap     map[string]*block
+	length   int
+}
+
+// store holds every client's integrated structs, sorted by clock and
+// gap-free.
+type store struct {
+	clients map[uint64][]*block
+}
+
+func newStore() *store { return &store{clients: map[uint64][]*block{}} }
+
+func (s *store) state(client uint64) uint64 {
+	bs := s.clients[client]
+	if len(bs) == 0 {
+		return 0
+	}
+	last := bs[len(bs)-1]
+	return last.id.Clock + uint64(last.length)
+}
+
+func (s *store) stateVector() map[uint64]uint64 {
+	sv := make(map[uint64]uint64, len(s.clients))
+	for c := range s.clients {
+		if st := s.state(c); st > 0 {
+			sv[c] = st
+		}
+	}
+	return sv
+}
+
+// corruptErr is raised (as a panic, recovered at the API boundary) when
+// an update references history that cannot exist.
+type corruptErr struct{ msg string }
+
+func (e corruptErr) Error() string { return "yjs: inconsistent update: " + e.msg }
+
+func corrupt(format string, args ...any) {
+	panic(corruptErr{fmt.Sprintf(format, args...)})
+}
+
+// findIndex returns the index of the struct containing clock.
+func findIndex(bs []*block, clock uint64) int {
+	i := sort.Search(len(bs), func(i int) bool {
+		b := bs[i]
+		return b.id.Clock+uint64(b.length) > clock
+	})
+	if i == len(bs) || bs[i].id.Clock > clock {
+		corrupt("clock %d not found", clock)
+	}
+	return i
+}
+
+func (s *store) add(b *block) {
+	bs := s.clients[b.id.Client]
+	if st := s.state(b.id.Client); st != b.id.Clock {
+		corrupt("struct %d:%d added at state %d", b.id.Client, b.id.Clock, st)
+	}
+	s.clients[b.id.Client] = append(bs, b)
+}
+
+// get returns the struct containing id.
+func (s *store) get(id ID) *block {
+	bs := s.clients[id.Client]
+	return bs[findIndex(bs, id.Clock)]
+}
+
+// getCleanStart returns the struct starting exactly at id, splitting
+// the containing item when needed. GC structs are returned unsplit.
+func (s *store) getCleanStart(tx *txn, id ID) *block {
+	bs := s.clients[id.Client]
+	i := findIndex(bs, id.Clock)
+	b := bs[i]
+	if b.id.Clock < id.Clock && b.kind == kindItem {
+		return s.splitBlock(tx, b, int(id.Clock-b.id.Clock))
+	}
+	return b
+}
+
+// getCleanEnd returns the struct ending exactly at id.
+func (s *store) getCleanEnd(tx *txn, id ID) *block {
+	bs := s.clients[id.Client]
+	i := findIndex(bs, id.Clock)
+	b := bs[i]
+	if id.Clock != b.id.Clock+uint64(b.length)-1 && b.kind == kindItem {
+		s.splitBlock(tx, b, int(id.Clock-b.id.Clock)+1)
+	}
+	return b
+}
+
+// splitBlock cuts l after diff units, inserts the right half into the
+// store right after l and returns it (splitItem in Yjs).
+func (s *store) splitBlock(tx *txn, l *block, diff int) *block {
+	r := &block{
+		kind:        kindItem,
+		id:          ID{l.id.Client, l.id.Clock + uint64(diff)},
+		length:      l.length - diff,
+		origin:      &ID{l.id.Client, l.id.Clock + uint64(diff) - 1},
+		rightOrigin: l.rightOrigin,
+		left:        l,
+		right:       l.right,
+		parent:      l.parent,
+		parentSub:   l.parentSub,
+		deleted:     l.deleted,
+	}
+	r.content = l.content.splice(diff)
+	l.length = diff
+	l.right = r
+	if r.right != nil {
+		r.right.left = r
+	}
+	if r.parentSub != nil && r.right == nil && r.parent != nil {
+		r.parent.smap[*r.parentSub] = r
+	}
+	bs := s.clients[l.id.Client]
+	i := findIndex(bs, l.id.Clock)
+	bs = append(bs, nil)
+	copy(bs[i+2:], bs[i+1:])
+	bs[i+1] = r
+	s.clients[l.id.Client] = bs
+	if tx != nil {
+		tx.touch(l.id.Client)
+	}
+	return r
+}
+
+// replace swaps the struct with the same id for nb (GC collection).
+func (s *store) replace(old, nb *block) {
+	bs := s.clients[old.id.Client]
+	bs[findIndex(bs, old.id.Clock)] = nb
+}
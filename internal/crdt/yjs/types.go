@@ -0,0 +1,184 @@
+package yjs
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Text returns the string content of a Y.Text root. Embeds and
+// formatting markers are skipped, as in yrs' ytext_string.
+func (d *Doc) Text(root string) string {
+	t := d.roots[root]
+	if t == nil {
+		return ""
+	}
+	var u []uint16
+	for b := t.start; b != nil; b = b.right {
+		if s, ok := b.content.(*contentString); ok && !b.deleted {
+			u = append(u, s.s...)
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// XMLChildLen returns the number of live children of a Y.XmlFragment
+// root.
+func (d *Doc) XMLChildLen(root string) int {
+	t := d.roots[root]
+	if t == nil {
+		return 0
+	}
+	return t.length
+}
+
+// XMLString serialises a Y.XmlFragment root the way yrs does: elements
+// as <name key="value">children</name> with attributes sorted by key,
+// text nodes as their formatted delta. Node names keep their case.
+func (d *Doc) XMLString(root string) string {
+	t := d.roots[root]
+	if t == nil {
+		return ""
+	}
+	var sb strings.Builder
+	writeXMLChildren(&sb, t)
+	return sb.String()
+}
+
+func writeXMLChildren(sb *strings.Builder, t *ytype) {
+	for b := t.start; b != nil; b = b.right {
+		if b.deleted {
+			continue
+		}
+		if ct, ok := b.content.(*contentType); ok {
+			writeXMLNode(sb, ct.t)
+		}
+	}
+}
+
+func writeXMLNode(sb *strings.Builder, t *ytype) {
+	switch t.ref {
+	case typeXMLElement:
+		sb.WriteString("<" + t.name)
+		attrs := mapValues(t)
+		for _, k := range slices.Sorted(maps.Keys(attrs)) {
+			sb.WriteString(" " + k + `="` + formatAny(attrs[k]) + `"`)
+		}
+		sb.WriteString(">")
+		writeXMLChildren(sb, t)
+		sb.WriteString("</" + t.name + ">")
+	case typeXMLText, typeText:
+		writeDelta(sb, t)
+	case typeXMLFragment:
+		writeXMLChildren(sb, t)
+	}
+}
+
+// writeDelta renders a text type's delta: each run of equally formatted
+// text wrapped in one tag per attribute (sorted by name), the
+// attribute's object value spread into XML attributes.
+func writeDelta(sb *strings.Builder, t *ytype) {
+	attrs := map[string]string{}
+	var run []uint16
+	var runAttrs map[string]string
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		names := slices.Sorted(maps.Keys(runAttrs))
+		for _, n := range names {
+			sb.WriteString("<" + n)
+			var obj map[string]any
+			if json.Unmarshal([]byte(runAttrs[n]), &obj) == nil {
+				for _, k := range slices.Sorted(maps.Keys(obj)) {
+					sb.WriteString(" " + k + `="` + formatAny(obj[k]) + `"`)
+				}
+			}
+			sb.WriteString(">")
+		}
+		sb.WriteString(string(utf16.Decode(run)))
+		for i := len(names) - 1; i >= 0; i-- {
+			sb.WriteString("</" + names[i] + ">")
+		}
+		run = nil
+	}
+	for b := t.start; b != nil; b = b.right {
+		if b.deleted {
+			continue
+		}
+		switch c := b.content.(type) {
+		case *contentFormat:
+			flush()
+			if c.value == "null" {
+				delete(attrs, c.key)
+			} else {
+				attrs[c.key] = c.value
+			}
+		case *contentString:
+			if len(run) == 0 {
+				runAttrs = maps.Clone(attrs)
+			}
+			run = append(run, c.s...)
+		}
+	}
+	flush()
+}
+
+// MapStrings returns the string-valued entries of a Y.Map root.
+func (d *Doc) MapStrings(root string) map[string]string {
+	out := map[string]string{}
+	t := d.roots[root]
+	if t == nil {
+		return out
+	}
+	for k, v := range mapValues(t) {
+		if s, ok := v.(string); ok {
+			out[k] = s
+		}
+	}
+	return out
+}
+
+// mapValues returns the live Any values of a type's map entries.
+// Entries holding nested types are skipped.
+func mapValues(t *ytype) map[string]any {
+	out := map[string]any{}
+	for k, b := range t.smap {
+		if b == nil || b.deleted {
+			continue
+		}
+		switch c := b.content.(type) {
+		case *contentAny:
+			out[k] = decodeAny(c.vals[len(c.vals)-1])
+		case *contentJSON:
+			var v any
+			if json.Unmarshal([]byte(c.vals[len(c.vals)-1]), &v) == nil {
+				out[k] = v
+			}
+		case *contentString:
+			out[k] = string(utf16.Decode(c.s))
+		}
+	}
+	return out
+}
+
+// formatAny formats an attribute value like yrs' Any Display.
+func formatAny(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,585 @@
+package yjs
+
+import (
+	"slices"
+	"sort"
+)
+
+// ---- Delete sets -----------------------------------------------------------
+
+type dsRange struct{ clock, len uint64 }
+
+// deleteSet maps a client to the clock ranges it had deleted.
+type deleteSet map[uint64][]dsRange
+
+func (ds deleteSet) add(client, clock, n uint64) {
+	if n == 0 {
+		return
+	}
+	ds[client] = append(ds[client], dsRange{clock, n})
+}
+
+func (ds deleteSet) merge(other deleteSet) {
+	for c, rs := range other {
+		ds[c] = append(ds[c], rs...)
+	}
+}
+
+// normalize sorts and coalesces every client's ranges
+// (sortAndMergeDeleteSet).
+func (ds deleteSet) normalize() {
+	for c, rs := range ds {
+		if len(rs) == 0 {
+			delete(ds, c)
+			continue
+		}
+		sort.Slice(rs, func(i, j int) bool { return rs[i].clock < rs[j].clock })
+		out := rs[:1]
+		for _, r := range rs[1:] {
+			last := &out[len(out)-1]
+			if r.clock <= last.clock+last.len {
+				if end := r.clock + r.len; end > last.clock+last.len {
+					last.len = end - last.clock
+				}
+				continue
+			}
+			out = append(out, r)
+		}
+		ds[c] = out
+	}
+}
+
+func (ds deleteSet) write(e *encoder) {
+	ds.normalize()
+	clients := sortedDesc(ds)
+	e.varUint(uint64(len(clients)))
+	for _, c := range clients {
+		e.varUint(c)
+		e.varUint(uint64(len(ds[c])))
+		for _, r := range ds[c] {
+			e.varUint(r.clock)
+			e.varUint(r.len)
+		}
+	}
+}
+
+func readDeleteSet(d *decoder) (deleteSet, error) {
+	ds := deleteSet{}
+	n, err := d.varUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		client, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		m, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < m; j++ {
+			clock, err := d.varUint()
+			if err != nil {
+				return nil, err
+			}
+			l, err := d.varUint()
+			if err != nil {
+				return nil, err
+			}
+			ds.add(client, clock, l)
+		}
+	}
+	return ds, nil
+}
+
+// deleteSet derives the delete set of everything in the store
+// (createDeleteSetFromStructStore).
+func (s *store) deleteSet() deleteSet {
+	ds := deleteSet{}
+	for c, bs := range s.clients {
+		for _, b := range bs {
+			if b.isDeleted() {
+				ds.add(c, b.id.Clock, uint64(b.length))
+			}
+		}
+	}
+	return ds
+}
+
+func sortedDesc[V any](m map[uint64]V) []uint64 {
+	keys := make([]uint64, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	slices.Reverse(keys)
+	return keys
+}
+
+// ---- State vectors ---------------------------------------------------------
+
+func encodeStateVector(sv map[uint64]uint64) []byte {
+	e := &encoder{}
+	clients := sortedDesc(sv)
+	e.varUint(uint64(len(clients)))
+	for _, c := range clients {
+		e.varUint(c)
+		e.varUint(sv[c])
+	}
+	return e.buf
+}
+
+// DecodeStateVector parses a lib0-v1 state vector into client → clock.
+// An empty input is the empty state vector.
+func DecodeStateVector(b []byte) (map[uint64]uint64, error) {
+	sv := map[uint64]uint64{}
+	if len(b) == 0 {
+		return sv, nil
+	}
+	d := &decoder{buf: b}
+	n, err := d.varUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		c, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		sv[c] = clock
+	}
+	return sv, nil
+}
+
+// ---- Struct decoding -------------------------------------------------------
+
+// readStructs decodes the struct section of a v1 update
+// (readClientsStructRefs). Root parents are resolved to the doc's root
+// types immediately; nested parents stay as ids.
+func (doc *Doc) readStructs(d *decoder) (map[uint64][]*block, error) {
+	refs := map[uint64][]*block{}
+	n, err := d.varUint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		count, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		client, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		clock, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		if count > uint64(len(d.buf)) {
+			// Every struct takes at least one byte.
+			return nil, errTruncated
+		}
+		list := make([]*block, 0, count)
+		for j := uint64(0); j < count; j++ {
+			b, err := doc.readStruct(d, ID{client, clock})
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, b)
+			clock += uint64(b.length)
+		}
+		refs[client] = append(refs[client], list...)
+	}
+	return refs, nil
+}
+
+func (doc *Doc) readStruct(d *decoder, id ID) (*block, error) {
+	info, err := d.uint8()
+	if err != nil {
+		return nil, err
+	}
+	switch info & 0x1f {
+	case refGC, refSkip:
+		n, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errMalformed
+		}
+		kind := kindGC
+		if info&0x1f == refSkip {
+			kind = kindSkip
+		}
+		return &block{kind: kind, id: id, length: int(n)}, nil
+	}
+	b := &block{kind: kindItem, id: id}
+	readID := func() (*ID, error) {
+		c, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		k, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		return &ID{c, k}, nil
+	}
+	if info&0x80 != 0 {
+		if b.origin, err = readID(); err != nil {
+			return nil, err
+		}
+	}
+	if info&0x40 != 0 {
+		if b.rightOrigin, err = readID(); err != nil {
+			return nil, err
+		}
+	}
+	if info&0xc0 == 0 {
+		isRoot, err := d.varUint()
+		if err != nil {
+			return nil, err
+		}
+		if isRoot == 1 {
+			name, err := d.varString()
+			if err != nil {
+				return nil, err
+			}
+			b.parent = doc.root(name)
+		} else if b.parentID, err = readID(); err != nil {
+			return nil, err
+		}
+		if info&0x20 != 0 {
+			sub, err := d.varString()
+			if err != nil {
+				return nil, err
+			}
+			b.parentSub = &sub
+		}
+	}
+	if b.content, err = readContent(d, info); err != nil {
+		return nil, err
+	}
+	b.length = b.content.length()
+	return b, nil
+}
+
+// ---- Struct encoding -------------------------------------------------------
+
+func (b *block) write(e *encoder, offset int) {
+	switch b.kind {
+	case kindGC:
+		e.uint8(refGC)
+		e.varUint(uint64(b.length - offset))
+		return
+	case kindSkip:
+		e.uint8(refSkip)
+		e.varUint(uint64(b.length - offset))
+		return
+	}
+	origin := b.origin
+	if offset > 0 {
+		origin = &ID{b.id.Client, b.id.Clock + uint64(offset) - 1}
+	}
+	info := b.content.ref() & 0x1f
+	if origin != nil {
+		info |= 0x80
+	}
+	if b.rightOrigin != nil {
+		info |= 0x40
+	}
+	if b.parentSub != nil {
+		info |= 0x20
+	}
+	e.uint8(info)
+	if origin != nil {
+		e.varUint(origin.Client)
+		e.varUint(origin.Clock)
+	}
+	if b.rightOrigin != nil {
+		e.varUint(b.rightOrigin.Client)
+		e.varUint(b.rightOrigin.Clock)
+	}
+	if origin == nil && b.rightOrigin == nil {
+		switch {
+		case b.parent != nil && b.parent.item == nil:
+			e.varUint(1)
+			e.varString(b.parent.rootName)
+		case b.parent != nil:
+			e.varUint(0)
+			e.varUint(b.parent.item.id.Client)
+			e.varUint(b.parent.item.id.Clock)
+		case b.parentID != nil:
+			e.varUint(0)
+			e.varUint(b.parentID.Client)
+			e.varUint(b.parentID.Clock)
+		default:
+			// Unreachable for well-formed structs: an item with neither
+			// origin carries its parent.
+			e.varUint(1)
+			e.varString("")
+		}
+		if b.parentSub != nil {
+			e.varString(*b.parentSub)
+		}
+	}
+	b.content.write(e, offset)
+}
+
+// writeClient emits one client section covering every clock from
+// `from` on: structs from bs (sorted by clock, possibly overlapping or
+// with gaps), sliced at from, with Skip structs filling gaps. Returns
+// false when there is nothing to write.
+func writeClient(e *encoder, client uint64, bs []*block, from uint64) bool {
+	type piece struct {
+		b      *block
+		offset int
+	}
+	var pieces []piece
+	cur := from
+	start := uint64(0)
+	for _, b := range bs {
+		end := b.id.Clock + uint64(b.length)
+		if end <= cur {
+			continue
+		}
+		off := 0
+		if b.id.Clock < cur {
+			off = int(cur - b.id.Clock)
+			if b.kind == kindItem && !splittable(b.content) {
+				continue
+			}
+		}
+		if len(pieces) == 0 {
+			start = b.id.Clock + uint64(off)
+		} else if b.id.Clock > cur {
+			gap := &block{kind: kindSkip, id: ID{client, cur}, length: int(b.id.Clock - cur)}
+			pieces = append(pieces, piece{gap, 0})
+		}
+		pieces = append(pieces, piece{b, off})
+		cur = end
+	}
+	if len(pieces) == 0 {
+		return false
+	}
+	e.varUint(uint64(len(pieces)))
+	e.varUint(client)
+	e.varUint(start)
+	for _, p := range pieces {
+		p.b.write(e, p.offset)
+	}
+	return true
+}
+
+func splittable(c content) bool {
+	switch c.(type) {
+	case *contentString, *contentAny, *contentJSON, *contentDeleted:
+		return true
+	}
+	return false
+}
+
+// encodeUpdate writes every struct past sv plus the full delete set,
+// folding in pending structs and deletes (encodeStateAsUpdate).
+func (doc *Doc) encodeUpdate(sv map[uint64]uint64) []byte {
+	lists := map[uint64][]*block{}
+	for c, bs := range doc.store.clients {
+		if doc.store.state(c) > sv[c] {
+			lists[c] = bs
+		}
+	}
+	for c, bs := range doc.pending {
+		merged := append(append([]*block(nil), lists[c]...), bs...)
+		sort.SliceStable(merged, func(i, j int) bool { return merged[i].id.Clock < merged[j].id.Clock })
+		lists[c] = merged
+	}
+	ds := doc.store.deleteSet()
+	ds.merge(doc.pendingDS)
+	return writeUpdate(lists, sv, ds)
+}
+
+func writeUpdate(lists map[uint64][]*block, sv map[uint64]uint64, ds deleteSet) []byte {
+	body := &encoder{}
+	n := 0
+	for _, c := range sortedDesc(lists) {
+		if writeClient(body, c, lists[c], sv[c]) {
+			n++
+		}
+	}
+	e := &encoder{}
+	e.varUint(uint64(n))
+	e.buf = append(e.buf, body.buf...)
+	ds.write(e)
+	return e.buf
+}
+
+// encode encodes exactly what tx changed: structs added past its
+// before-state and the ranges it deleted.
+func (tx *txn) encode() []byte {
+	s := tx.doc.store
+	lists := map[uint64][]*block{}
+	for c, bs := range s.clients {
+		if s.state(c) > tx.beforeState[c] {
+			lists[c] = bs
+		}
+	}
+	if len(lists) == 0 && len(tx.deleted) == 0 {
+		return []byte{}
+	}
+	return writeUpdate(lists, tx.beforeState, tx.deleted)
+}
+
+// ---- Applying updates ------------------------------------------------------
+
+type refList struct {
+	i    int
+	refs []*block
+}
+
+// integrateStructs integrates decoded structs in causal order and
+// returns those that still miss dependencies (integrateStructs in Yjs).
+func (tx *txn) integrateStructs(byClient map[uint64][]*block) map[uint64][]*block {
+	s := tx.doc.store
+	clientRefs := make(map[uint64]*refList, len(byClient))
+	ids := make([]uint64, 0, len(byClient))
+	for c, bs := range byClient {
+		if len(bs) == 0 {
+			continue
+		}
+		clientRefs[c] = &refList{refs: bs}
+		ids = append(ids, c)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	slices.Sort(ids)
+
+	nextTarget := func() *refList {
+		for len(ids) > 0 {
+			if t := clientRefs[ids[len(ids)-1]]; t != nil && t.i < len(t.refs) {
+				return t
+			}
+			ids = ids[:len(ids)-1]
+		}
+		return nil
+	}
+	cur := nextTarget()
+	if cur == nil {
+		return nil
+	}
+	rest := map[uint64][]*block{}
+	var stack []*block
+	addStackToRest := func() {
+		for _, it := range stack {
+			c := it.id.Client
+			if inapp := clientRefs[c]; inapp != nil {
+				inapp.i--
+				rest[c] = append([]*block(nil), inapp.refs[inapp.i:]...)
+				delete(clientRefs, c)
+				inapp.i, inapp.refs = 0, nil
+			} else {
+				rest[c] = []*block{it}
+			}
+			ids = slices.DeleteFunc(ids, func(x uint64) bool { return x == c })
+		}
+		stack = stack[:0]
+	}
+
+	head := cur.refs[cur.i]
+	cur.i++
+	state := map[uint64]uint64{}
+	for {
+		if head.kind != kindSkip {
+			local, ok := state[head.id.Client]
+			if !ok {
+				local = s.state(head.id.Client)
+				state[head.id.Client] = local
+			}
+			if head.id.Clock > local {
+				stack = append(stack, head)
+				addStackToRest()
+			} else {
+				offset := local - head.id.Clock
+				if missing, ok := head.getMissing(tx, s); ok {
+					stack = append(stack, head)
+					refs := clientRefs[missing]
+					if refs == nil || refs.i == len(refs.refs) {
+						addStackToRest()
+					} else {
+						head = refs.refs[refs.i]
+						refs.i++
+						continue
+					}
+				} else if offset == 0 || offset < uint64(head.length) {
+					head.integrate(tx, int(offset))
+					state[head.id.Client] = head.id.Clock + uint64(head.length)
+				}
+			}
+		}
+		switch {
+		case len(stack) > 0:
+			head = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case cur != nil && cur.i < len(cur.refs):
+			head = cur.refs[cur.i]
+			cur.i++
+		default:
+			if cur = nextTarget(); cur == nil {
+				if len(rest) == 0 {
+					return nil
+				}
+				return rest
+			}
+			head = cur.refs[cur.i]
+			cur.i++
+		}
+	}
+}
+
+// applyDeleteSet marks the ranges deleted and returns the part of ds
+// referring to structs not yet received (readAndApplyDeleteSet).
+func (tx *txn) applyDeleteSet(ds deleteSet) deleteSet {
+	s := tx.doc.store
+	unapplied := deleteSet{}
+	for client, ranges := range ds {
+		st := s.state(client)
+		for _, r := range ranges {
+			clock, end := r.clock, r.clock+r.len
+			if clock >= st {
+				unapplied.add(client, clock, end-clock)
+				continue
+			}
+			if st < end {
+				unapplied.add(client, st, end-st)
+			}
+			bs := s.clients[client]
+			i := findIndex(bs, clock)
+			if b := bs[i]; !b.isDeleted() && b.id.Clock < clock {
+				s.splitBlock(tx, b, int(clock-b.id.Clock))
+				i++
+			}
+			for i < len(s.clients[client]) {
+				b := s.clients[client][i]
+				i++
+				if b.id.Clock >= end {
+					break
+				}
+				if !b.isDeleted() {
+					if end < b.id.Clock+uint64(b.length) {
+						s.splitBlock(tx, b, int(end-b.id.Clock))
+					}
+					b.delete(tx)
+				}
+			}
+		}
+	}
+	if len(unapplied) == 0 {
+		return nil
+	}
+	return unapplied
+}
@@ -0,0 +1,343 @@
+package yjs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// The golden vectors below are hand-assembled from the Yjs v1 update
+// format (UpdateEncoderV1): numClients, then per client numStructs,
+// client, clock and the structs; then the delete set.
+
+var (
+	// Client 1 inserts "abc" into the empty "content" root.
+	goldenInsert = []byte{
+		1, 1, 1, 0, // 1 client, 1 struct, client 1, clock 0
+		0x04, 1, 7, 'c', 'o', 'n', 't', 'e', 'n', 't', // string item, root "content"
+		3, 'a', 'b', 'c',
+		0, // empty delete set
+	}
+	// Client 1 then inserts "X" at index 1, between (1,0) and (1,1).
+	goldenInsertMid = []byte{
+		1, 1, 1, 3,
+		0xc4, 1, 0, 1, 1, // origin (1,0), right origin (1,1)
+		1, 'X',
+		0,
+	}
+	// Full state after both inserts: "abc" split at 1, then "X".
+	goldenStateAfterMid = []byte{
+		1, 3, 1, 0,
+		0x04, 1, 7, 'c', 'o', 'n', 't', 'e', 'n', 't', 1, 'a',
+		0x84, 1, 0, 2, 'b', 'c',
+		0xc4, 1, 0, 1, 1, 1, 'X',
+		0,
+	}
+	// Client 1 deletes index 0 ("a"): no structs, one delete range.
+	goldenDeleteFirst = []byte{0, 1, 1, 1, 0, 1}
+)
+
+func TestGoldenLocalEdits(t *testing.T) {
+	d := NewDocWithClientID(1)
+	u, err := d.Transact(func(tx *Txn) { tx.InsertText("content", 0, "abc") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(u, goldenInsert) {
+		t.Fatalf("insert update = %v, want %v", u, goldenInsert)
+	}
+	if sv := d.StateVector(); !bytes.Equal(sv, []byte{1, 1, 3}) {
+		t.Fatalf("state vector = %v", sv)
+	}
+
+	u, err = d.Transact(func(tx *Txn) { tx.InsertText("content", 1, "X") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(u, goldenInsertMid) {
+		t.Fatalf("mid insert update = %v, want %v", u, goldenInsertMid)
+	}
+	full, err := d.EncodeStateAsUpdate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(full, goldenStateAfterMid) {
+		t.Fatalf("full state = %v, want %v", full, goldenStateAfterMid)
+	}
+
+	u, err = d.Transact(func(tx *Txn) { tx.DeleteText("content", 0, 1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(u, goldenDeleteFirst) {
+		t.Fatalf("delete update = %v, want %v", u, goldenDeleteFirst)
+	}
+	if got := d.Text("content"); got != "Xbc" {
+		t.Fatalf("text = %q", got)
+	}
+}
+
+func TestApplyGoldenUpdates(t *testing.T) {
+	d := NewDocWithClientID(2)
+	for _, u := range [][]byte{goldenInsert, goldenInsertMid, goldenDeleteFirst} {
+		if err := d.ApplyUpdate(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := d.Text("content"); got != "Xbc" {
+		t.Fatalf("text = %q, want Xbc", got)
+	}
+	// The deleted "a" is garbage collected to ContentDeleted; its range
+	// shows up in the delete set of a full-state encoding.
+	full, err := d.EncodeStateAsUpdate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(full, []byte{1, 1, 1, 0, 1}) {
+		t.Fatalf("delete set missing from %v", full)
+	}
+}
+
+func TestStructuredRoots(t *testing.T) {
+	u := []byte{
+		1, 5, 1, 0,
+		// (1,0) <heading> in root "prosemirror"
+		0x07, 1, 11, 'p', 'r', 'o', 's', 'e', 'm', 'i', 'r', 'r', 'o', 'r', 3, 7, 'h', 'e', 'a', 'd', 'i', 'n', 'g',
+		// (1,1) XmlText child of (1,0)
+		0x07, 0, 1, 0, 6,
+		// (1,2) "hi" in (1,1)
+		0x04, 0, 1, 1, 2, 'h', 'i',
+		// (1,4) props["title"] = "T"
+		0x28, 1, 5, 'p', 'r', 'o', 'p', 's', 5, 't', 'i', 't', 'l', 'e', 1, 119, 1, 'T',
+		// (1,5) heading attribute level = 2
+		0x28, 0, 1, 0, 5, 'l', 'e', 'v', 'e', 'l', 1, 125, 2,
+		0,
+	}
+	d := NewDocWithClientID(9)
+	if err := d.ApplyUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.XMLChildLen("prosemirror"); got != 1 {
+		t.Fatalf("child len = %d", got)
+	}
+	if got, want := d.XMLString("prosemirror"), `<heading level="2">hi</heading>`; got != want {
+		t.Fatalf("xml = %q, want %q", got, want)
+	}
+	if got := d.MapStrings("props"); len(got) != 1 || got["title"] != "T" {
+		t.Fatalf("props = %v", got)
+	}
+	// Re-encoding reproduces the input byte for byte.
+	full, err := d.EncodeStateAsUpdate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(full, u) {
+		t.Fatalf("re-encoded = %v\nwant        %v", full, u)
+	}
+}
+
+func TestOutOfOrderUpdatesStayPending(t *testing.T) {
+	a := NewDocWithClientID(1)
+	var updates [][]byte
+	for i, s := range []string{"one ", "two ", "three"} {
+		u, err := a.Transact(func(tx *Txn) { tx.InsertText("content", 1<<20, s) })
+		if err != nil {
+			t.Fatalf("edit %d: %v", i, err)
+		}
+		updates = append(updates, u)
+	}
+	del, err := a.Transact(func(tx *Txn) { tx.DeleteText("content", 0, 4) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewDocWithClientID(2)
+	for _, u := range [][]byte{del, updates[2], updates[1]} {
+		if err := b.ApplyUpdate(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Text("content") != "" || !b.HasPending() {
+		t.Fatalf("text %q pending %v before the first update arrives", b.Text("content"), b.HasPending())
+	}
+	if !bytes.Equal(b.StateVector(), []byte{0}) {
+		t.Fatalf("state vector counts pending structs: %v", b.StateVector())
+	}
+	// A full-state encoding carries the pending structs along.
+	c := NewDocWithClientID(3)
+	full, _ := b.EncodeStateAsUpdate(nil)
+	if err := c.ApplyUpdate(full); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApplyUpdate(updates[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ApplyUpdate(updates[0]); err != nil {
+		t.Fatal(err)
+	}
+	for name, d := range map[string]*Doc{"b": b, "c": c} {
+		if got := d.Text("content"); got != "two three" || d.HasPending() {
+			t.Fatalf("%s: text %q pending %v", name, got, d.HasPending())
+		}
+	}
+}
+
+func TestDiffAgainstStateVector(t *testing.T) {
+	a := NewDocWithClientID(1)
+	u1, _ := a.Transact(func(tx *Txn) { tx.InsertText("content", 0, "hello") })
+	if _, err := a.Transact(func(tx *Txn) { tx.InsertText("content", 5, " world") }); err != nil {
+		t.Fatal(err)
+	}
+	b := NewDocWithClientID(2)
+	if err := b.ApplyUpdate(u1); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := a.EncodeStateAsUpdate(b.StateVector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ApplyUpdate(diff); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Text("content"); got != "hello world" {
+		t.Fatalf("text = %q", got)
+	}
+	// A diff against an up-to-date peer carries no structs.
+	empty, _ := a.EncodeStateAsUpdate(b.StateVector())
+	if empty[0] != 0 {
+		t.Fatalf("diff against equal state has structs: %v", empty)
+	}
+}
+
+func TestSplitSurrogatePair(t *testing.T) {
+	d := NewDocWithClientID(1)
+	if _, err := d.Transact(func(tx *Txn) { tx.InsertText("content", 0, "a😀b") }); err != nil {
+		t.Fatal(err)
+	}
+	// Index 2 falls between the halves of the emoji's surrogate pair;
+	// Yjs replaces both halves with U+FFFD rather than keep them split.
+	if _, err := d.Transact(func(tx *Txn) { tx.InsertText("content", 2, "x") }); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Text("content"); got != "a�x�b" {
+		t.Fatalf("text = %q", got)
+	}
+}
+
+// TestConvergence runs random concurrent edits on several replicas,
+// exchanges the updates in a different shuffled order (with
+// duplicates) for every replica and checks that all of them converge,
+// including a replica loaded from another's full state.
+func TestConvergence(t *testing.T) {
+	for seed := uint64(0); seed < 30; seed++ {
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			r := rand.New(rand.NewPCG(seed, 7))
+			docs := []*Doc{NewDocWithClientID(10), NewDocWithClientID(20), NewDocWithClientID(30)}
+			var log [][]byte
+			for round := 0; round < 8; round++ {
+				for _, d := range docs {
+					for n := r.IntN(4); n > 0; n-- {
+						log = append(log, randomEdit(t, r, d))
+					}
+				}
+				// Partial sync: every replica sees a random prefix.
+				for _, d := range docs {
+					for _, u := range log[:r.IntN(len(log)+1)] {
+						if err := d.ApplyUpdate(u); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			}
+			for _, d := range docs {
+				shuffled := append([][]byte(nil), log...)
+				shuffled = append(shuffled, log[:len(log)/2]...)
+				r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+				for _, u := range shuffled {
+					if err := d.ApplyUpdate(u); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			want := docs[0].Text("content")
+			for i, d := range docs {
+				if d.HasPending() {
+					t.Fatalf("doc %d still has pending structs", i)
+				}
+				if got := d.Text("content"); got != want {
+					t.Fatalf("doc %d = %q, doc 0 = %q", i, got, want)
+				}
+			}
+			full, _ := docs[1].EncodeStateAsUpdate(nil)
+			fresh := NewDocWithClientID(99)
+			if err := fresh.ApplyUpdate(full); err != nil {
+				t.Fatal(err)
+			}
+			if got := fresh.Text("content"); got != want {
+				t.Fatalf("reloaded = %q, want %q", got, want)
+			}
+			again, _ := fresh.EncodeStateAsUpdate(nil)
+			if !bytes.Equal(again, full) {
+				t.Fatalf("full state does not round-trip")
+			}
+		})
+	}
+}
+
+func randomEdit(t *testing.T, r *rand.Rand, d *Doc) []byte {
+	t.Helper()
+	n := len(toUTF16(d.Text("content")))
+	u, err := d.Transact(func(tx *Txn) {
+		if n > 0 && r.IntN(3) == 0 {
+			i := r.IntN(n)
+			tx.DeleteText("content", i, 1+r.IntN(min(3, n-i)))
+			return
+		}
+		words := []string{"a", "bc", "déf", "ghij", "🙂"}
+		tx.InsertText("content", r.IntN(n+1), words[r.IntN(len(words))])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestMalformedUpdates(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated struct": goldenInsert[:10],
+		"unknown content":  {1, 1, 1, 0, 0x0f, 1, 1, 'x', 0},
+		"varint overflow":  {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"missing ds":       goldenInsert[:len(goldenInsert)-1],
+	}
+	for name, u := range cases {
+		if err := NewDoc().ApplyUpdate(u); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	// Random garbage must never panic.
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 2000; i++ {
+		b := make([]byte, r.IntN(40))
+		for j := range b {
+			b[j] = byte(r.UintN(256))
+		}
+		_ = NewDoc().ApplyUpdate(b)
+		mut := append([]byte(nil), goldenStateAfterMid...)
+		mut[r.IntN(len(mut))] = byte(r.UintN(256))
+		_ = NewDoc().ApplyUpdate(mut)
+	}
+}
+
+func TestDecodeStateVector(t *testing.T) {
+	sv, err := DecodeStateVector([]byte{2, 0x80, 0x01, 5, 1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sv) != 2 || sv[128] != 5 || sv[1] != 3 {
+		t.Fatalf("sv = %v", sv)
+	}
+	if _, err := DecodeStateVector([]byte{2, 1}); err == nil {
+		t.Fatal("expected error on truncated state vector")
+	}
+}
@@ -34,6 +34,46 @@ const (
 	CapAuditRead Capability = "audit.read"
 )
 
+// knownCaps is the exact-capability vocabulary; knownWildcardPrefixes the
+// namespaces a ".*" wildcard may cover.
+var knownCaps = map[Capability]struct{}{
+	CapNoteRead:      {},
+	CapNoteCreate:    {},
+	CapNoteEdit:      {},
+	CapNoteDelete:    {},
+	CapNoteMove:      {},
+	CapMembersInvite: {},
+	CapMembersManage: {},
+	CapRolesManage:   {},
+	CapVaultManage:   {},
+	CapVaultExport:   {},
+	CapVaultFederate: {},
+	CapAuditRead:     {},
+}
+
+var knownWildcardPrefixes = map[string]struct{}{
+	"note.":    {},
+	"members.": {},
+	"roles.":   {},
+	"vault.":   {},
+	"audit.":   {},
+}
+
+// Known reports whether c is in the vocabulary: a built-in capability,
+// "*", or a wildcard over a known namespace.
+func (c Capability) Known() bool {
+	s := string(c)
+	if s == "*" {
+		return true
+	}
+	if strings.HasSuffix(s, ".*") {
+		_, ok := knownWildcardPrefixes[strings.TrimSuffix(s, "*")]
+		return ok
+	}
+	_, ok := knownCaps[c]
+	return ok
+}
+
 // CapabilitySet is the capability list granted to a role. Values may include
 // wildcards. Use Has() rather than membership tests; wildcards must expand.
 type CapabilitySet []Capability
@@ -23,4 +23,6 @@ var (
 	ErrSeedRoleProtected  = errors.New("seed role cannot be modified or deleted")
 	ErrRateLimited        = errors.New("rate limited")
 	ErrTLSRequired        = errors.New("TLS required")
+	ErrSecondFactor       = errors.New("second factor required")
+	ErrAccountSuspended   = errors.New("account suspended")
 )
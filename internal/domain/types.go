@@ -9,23 +9,271 @@ import (
 )
 
 // User represents a server-scoped account. Identity is independent per server
-// (no central identity provider). See SPEC.md "Identity".
+// (no central identity provider). See SPEC.md "Identity". TwoFactorEnabled
+// is derived: true once a TOTP enrolment has been confirmed or a WebAuthn
+// credential registered.
 type User struct {
+	ID               uuid.UUID
+	Username         string
+	PasswordHash     string
+	DisplayName      string
+	CreatedAt        time.Time
+	TwoFactorEnabled bool
+	IsAdmin          bool
+	SuspendedAt      *time.Time
+	Email            string
+}
+
+// TOTPEnrolment is a user's authenticator secret. It is pending until the
+// user proves possession with a first code (ConfirmedAt set). LastStep is
+// the newest time step accepted, so a code cannot be replayed.
+type TOTPEnrolment struct {
+	UserID      uuid.UUID
+	Secret      string
+	CreatedAt   time.Time
+	ConfirmedAt *time.Time
+	LastStep    int64
+}
+
+// LoginChallenge is the intermediate state of a two-step login: the
+// password was right and a second factor is due. Only the hash of the
+// challenge token is stored. CodeHash is set when the login looked
+// unusual and must be confirmed with a code sent by email instead.
+type LoginChallenge struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Attempts  int
+	CodeHash  string
+	// OIDCGroups are the provider groups of an OIDC login whose vault
+	// roles are granted once the challenge is answered.
+	OIDCGroups []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// WebAuthnCredential is a registered passkey or security key. PublicKey
+// is the COSE key from registration; SignCount the authenticator's last
+// reported counter.
+type WebAuthnCredential struct {
 	ID           uuid.UUID
-	Username     string
-	PasswordHash string
-	DisplayName  string
+	UserID       uuid.UUID
+	Name         string
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    uint32
+	Transports   []string
 	CreatedAt    time.Time
+	LastUsedAt   *time.Time
+}
+
+// WebAuthnChallenge is a WebAuthn ceremony in flight. UserID is nil for
+// a usernameless login, where the credential names the user.
+type WebAuthnChallenge struct {
+	Challenge []byte
+	Kind      string
+	UserID    *uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// UserIdentity links an OpenID Connect subject to a user.
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+// OIDCState is an OpenID Connect authorization request in flight.
+// LinkUserID is set when a signed-in user is attaching the identity to
+// their account; the consent versions are those the user accepted
+// before leaving for the provider, used if an account gets created.
+type OIDCState struct {
+	StateHash      string
+	Nonce          string
+	CodeVerifier   string
+	LinkUserID     *uuid.UUID
+	TosVersion     string
+	PrivacyVersion string
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// ReauthGrant is a single-use proof that a user just signed in again
+// through their OpenID provider, accepted where a password would be.
+type ReauthGrant struct {
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// PasswordReset is a single-use token that lets a user who lost their
+// password set a new one. CreatedBy is the admin who issued it; nil when
+// the user requested it by email or the admin's account is erased.
+type PasswordReset struct {
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedBy *uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// OutboxMail is a rendered email queued for delivery. It is pending
+// while SentAt and FailedAt are both nil; FailedAt marks a message the
+// worker gave up on after too many attempts.
+type OutboxMail struct {
+	ID            uuid.UUID
+	Recipient     string
+	Subject       string
+	Body          string
+	Template      string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        *time.Time
+	FailedAt      *time.Time
+}
+
+// UserExport is a data export the worker prepares in the background. It
+// is pending while ReadyAt and FailedAt are both nil; once ready, the
+// archive can be downloaded until ExpiresAt.
+type UserExport struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	Attempts      int
+	LastError     string
+	SizeBytes     int64
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	ReadyAt       *time.Time
+	FailedAt      *time.Time
+	ExpiresAt     *time.Time
+}
+
+// SecurityEvent is a login, or a run of failed ones, that the account
+// holder should look at. Details carries kind-specific JSON such as the
+// distance behind an impossible_travel event.
+type SecurityEvent struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	Kind      string
+	IP        string
+	UserAgent string
+	Details   []byte
+	CreatedAt time.Time
+}
+
+// SecurityEvent kinds.
+const (
+	SecurityNewDevice        = "new_device"
+	SecurityNewNetwork       = "new_network"
+	SecurityImpossibleTravel = "impossible_travel"
+	SecurityAfterFailures    = "login_after_failures"
+	SecurityRepeatedFailures = "repeated_failures"
+	SecurityStepUp           = "step_up_required"
+	SecurityRefreshReuse     = "refresh_token_reuse"
+)
+
+// LoginSighting is one successful login as remembered for comparing
+// later ones with: the device fingerprint and the network it came from,
+// located when a GeoIP database knew the address.
+type LoginSighting struct {
+	UserID      uuid.UUID
+	Fingerprint string
+	UserAgent   string
+	Network     string
+	IP          string
+	Located     bool
+	Latitude    float64
+	Longitude   float64
+	At          time.Time
+}
+
+// RateBucket is a persisted rate-limit token bucket. Scope names the
+// limiter, Key what it counts (a username, an IP address). Lockouts is
+// how many times the bucket has run dry since it was last idle or
+// reset; LockedUntil is set while the latest lockout lasts.
+type RateBucket struct {
+	Scope       string
+	Key         string
+	Tokens      float64
+	UpdatedAt   time.Time
+	Lockouts    int
+	LockedUntil *time.Time
+}
+
+// LoginBaseline is what a user's earlier logins say about a new one.
+// Devices is zero before the first remembered login. LastLocated is the
+// most recent network with coordinates, nil when there is none.
+type LoginBaseline struct {
+	Devices      int
+	DeviceKnown  bool
+	NetworkKnown bool
+	LastLocated  *LoginSighting
 }
 
 // Session is a bearer credential issued at login. Validated via constant-time
-// compare. Treat as a password; never log raw tokens.
+// compare. Treat as a password; never log raw tokens. ID is the public
+// handle used to list and revoke sessions; IP and UserAgent describe the
+// client it was issued to (empty when unknown).
 type Session struct {
+	ID         uuid.UUID
 	Token      string
 	UserID     uuid.UUID
+	IP         string
+	UserAgent  string
 	CreatedAt  time.Time
 	ExpiresAt  time.Time
 	LastUsedAt time.Time
+	DeviceName string // client that obtained it through a device grant
+}
+
+// RefreshToken renews the signed access token of a session. Only its
+// SHA-256 is stored. A token is spent once; UsedAt is set when it is
+// exchanged, and presenting it again reveals a stolen copy.
+type RefreshToken struct {
+	TokenHash string
+	SessionID uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// DeviceAuthorization is a pending device grant: a terminal client
+// polls with the device code (stored hashed) while the user approves
+// UserCode in the web UI. UserID is set by the decision; the grant is
+// undecided while ApprovedAt and DeniedAt are both nil.
+type DeviceAuthorization struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientName     string
+	IP             string
+	UserAgent      string
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	LastPolledAt   *time.Time
+	UserID         *uuid.UUID
+	ApprovedAt     *time.Time
+	DeniedAt       *time.Time
+}
+
+// APIToken is a user-created personal access token. Only the SHA-256 of
+// the secret is stored; the plaintext is shown once, at creation. A
+// request made with one is limited to VaultID (when set) and to
+// Capabilities intersected with the user's role in that vault.
+type APIToken struct {
+	ID           uuid.UUID
+	UserID       uuid.UUID
+	Name         string
+	TokenHash    string
+	VaultID      *uuid.UUID
+	Capabilities CapabilitySet
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	LastUsedAt   *time.Time
 }
 
 // Vault is the unit of organisation. May be local-only on disk or
@@ -122,6 +370,28 @@ const (
 	ActionAuthLogout         = "auth.logout"
 	ActionAuthRegister       = "auth.register"
 	ActionAuthPasswordChange = "auth.password_change"
+	ActionAuthTokenCreate    = "auth.token_create"
+	ActionAuthTokenRevoke    = "auth.token_revoke"
+	ActionAuthSessionRevoke  = "auth.session_revoke"
+	ActionAuth2FAEnrol       = "auth.2fa_enrol"
+	ActionAuth2FADisable     = "auth.2fa_disable"
+	ActionAuth2FAFailed      = "auth.2fa_failed"
+	ActionAuth2FARecovery    = "auth.2fa_recovery_codes"
+	ActionAuthWebAuthnAdd    = "auth.webauthn_add"
+	ActionAuthWebAuthnRemove = "auth.webauthn_remove"
+	ActionAuthOIDCLink       = "auth.oidc_link"
+	ActionAuthResetIssue     = "auth.password_reset_issue"
+	ActionAuthPasswordReset  = "auth.password_reset"
+	ActionAuthResetRequest   = "auth.password_reset_request"
+	ActionAuthDeviceApprove  = "auth.device_approve"
+	ActionAuthDeviceDeny     = "auth.device_deny"
+	ActionAdminSuspend       = "admin.user_suspend"
+	ActionAdminUnsuspend     = "admin.user_unsuspend"
+	ActionAdminForceLogout   = "admin.force_logout"
+	ActionAdminGrant         = "admin.grant"
+	ActionAdminRevoke        = "admin.revoke"
+	ActionAdminRegistration  = "admin.registration_change"
+	ActionAdminUnlock        = "admin.rate_limit_unlock"
 	ActionUserUpdate         = "user.update"
 	ActionUserDelete         = "user.delete"
 	ActionUserExportRequest  = "user.export_request"
@@ -130,6 +400,9 @@ const (
 	ActionVaultUpdate        = "vault.update"
 	ActionVaultTransfer      = "vault.transfer"
 	ActionVaultCopy          = "vault.copy"
+	ActionVaultCompact       = "vault.compact"
+	ActionVaultFsck          = "vault.fsck"
+	ActionVaultReconcile     = "vault.reconcile"
 	ActionMemberInvite       = "member.invite"
 	ActionMemberAdd          = "member.add"
 	ActionMemberRemove       = "member.remove"
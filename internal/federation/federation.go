@@ -79,6 +79,13 @@ type HomeClient interface {
 	SyncChallenge(ctx context.Context, homeURL string, vaultID uuid.UUID, peerURL string) (nonce string, err error)
 }
 
+// Limiter throttles accept attempts per client IP. Implemented by
+// auth.RateLimiter and auth.StoreLimiter.
+type Limiter interface {
+	Allow(key string) bool
+	Reset(key string)
+}
+
 // ---- wire shapes (shared by handlers and client) ------------------------------
 
 // Identity is the public identity of a server.
@@ -122,6 +129,7 @@ type Service struct {
 	audit       audit.Recorder
 	baseURL     string
 	relay       LinkController
+	limiter     Limiter
 	now         func() time.Time
 
 	// F3 control plane (nil = disabled).
@@ -467,6 +475,14 @@ type LinkController interface {
 // root after both objects exist (they reference each other).
 func (s *Service) SetLinkController(lc LinkController) { s.relay = lc }
 
+// SetLimiter throttles the public accept endpoint per client IP.
+func (s *Service) SetLimiter(l Limiter) { s.limiter = l }
+
+// acceptAllowed spends one of ip's accept attempts.
+func (s *Service) acceptAllowed(ip string) bool {
+	return s.limiter == nil || s.limiter.Allow(ip)
+}
+
 // RevokeFederation severs a link from this side: the row flips to revoked,
 // the live relay session (if any) is closed, and reconnect loops stop.
 // Signed revocation events to the peer are F3.
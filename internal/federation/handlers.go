@@ -27,7 +27,7 @@ func (h *Handlers) Register(app *fiber.App, authed fiber.Router) {
 	app.Get("/api/federation/identity", h.identity)
 	app.Post("/api/federation/accept", h.accept)
 
-	authed.Post("/federation/join", h.join)
+	authed.Post("/federation/join", capguard.SessionOnly(), h.join)
 	authed.Post("/vaults/:vault/federation-invites",
 		capguard.RequireCapability(h.resolver, domain.CapVaultFederate), h.createInvite)
 	authed.Get("/vaults/:vault/federation-invites",
@@ -60,6 +60,9 @@ func (h *Handlers) identity(c *fiber.Ctx) error {
 }
 
 func (h *Handlers) accept(c *fiber.Ctx) error {
+	if !h.svc.acceptAllowed(c.IP()) {
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "rate_limited"})
+	}
 	var req AcceptRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_body"})
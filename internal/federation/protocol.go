@@ -3,6 +3,8 @@ package federation
 import (
 	"errors"
 	"fmt"
+
+	"github.com/ViniZap4/lumi-server/internal/crdt"
 )
 
 // F2 relay frames. One WebSocket per (vault, peer link); frames multiplex
@@ -16,6 +18,12 @@ import (
 //	                                                sync message (Step1/2/Update)
 //	noteAnnounce:= noteMeta                       — peer may lack this note;
 //	                                                create metadata before sync
+//	noteSyncV2  := noteSync                       — as noteSync, but Step2/Update
+//	                                                bodies are lib0-v2 updates
+//
+// Relays only ever emit noteSync (v1, the storage format); noteSyncV2 is
+// accepted so peers whose editors produce v2 can forward without
+// transcoding, and is answered in kind.
 const (
 	frameManifest     uint64 = 1
 	frameNoteSync     uint64 = 2
@@ -24,6 +32,7 @@ const (
 	frameControlState uint64 = 5 // varBytes(stateJSON) varBytes(sig) — home → follower
 	frameControlAck   uint64 = 6 // varUint(seq) — follower → home
 	frameNoteMove     uint64 = 7 // noteMeta — note renamed/moved at the peer
+	frameNoteSyncV2   uint64 = 8
 )
 
 // syncAuthMessagePrefix versions the signed WS-upgrade auth (v3 F2). The
@@ -130,7 +139,16 @@ func EncodeManifest(notes []NoteMeta) []byte {
 
 // EncodeNoteSync wraps a per-note y-protocols sync payload.
 func EncodeNoteSync(noteID string, payload []byte) []byte {
-	out := writeVarUint(nil, frameNoteSync)
+	return EncodeNoteSyncAs(noteID, payload, crdt.EncodingV1)
+}
+
+// EncodeNoteSyncAs wraps a sync payload whose update bodies are in enc.
+func EncodeNoteSyncAs(noteID string, payload []byte, enc crdt.Encoding) []byte {
+	typ := frameNoteSync
+	if enc == crdt.EncodingV2 {
+		typ = frameNoteSyncV2
+	}
+	out := writeVarUint(nil, typ)
 	out = writeVarBytes(out, []byte(noteID))
 	return writeVarBytes(out, payload)
 }
@@ -174,8 +192,8 @@ type Frame struct {
 	Type     uint64
 	Manifest []NoteMeta // frameManifest
 	Note     NoteMeta   // frameNoteAnnounce
-	NoteID   string     // frameNoteSync / frameNoteDelete
-	Payload  []byte     // frameNoteSync: y-protocols message; frameControlState: state JSON
+	NoteID   string     // frameNoteSync(V2) / frameNoteDelete
+	Payload  []byte     // frameNoteSync(V2): y-protocols message; frameControlState: state JSON
 	Sig      []byte     // frameControlState
 	Seq      int64      // frameControlAck
 }
@@ -206,7 +224,7 @@ func DecodeFrame(buf []byte) (Frame, error) {
 			body = body[n:]
 		}
 		return Frame{Type: typ, Manifest: notes}, nil
-	case frameNoteSync:
+	case frameNoteSync, frameNoteSyncV2:
 		noteID, n, err := readVarString(body)
 		if err != nil {
 			return Frame{}, err
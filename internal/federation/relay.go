@@ -487,10 +487,14 @@ func (s *Session) handleFrame(f Frame) error {
 		}
 		return nil
 
-	case frameNoteSync:
+	case frameNoteSync, frameNoteSyncV2:
 		if err := validateNoteID(f.NoteID); err != nil {
 			return err
 		}
+		enc := crdt.EncodingV1
+		if f.Type == frameNoteSyncV2 {
+			enc = crdt.EncodingV2
+		}
 		msg, err := wsync.DecodeMessage(f.Payload)
 		if err != nil {
 			return err
@@ -500,9 +504,9 @@ func (s *Session) handleFrame(f Frame) error {
 		}
 		switch msg.SyncSub {
 		case wsync.SyncStep1:
-			return s.handleStep1(f.NoteID, msg.Body)
+			return s.handleStep1(f.NoteID, msg.Body, enc)
 		case wsync.SyncStep2, wsync.SyncUpdate:
-			return s.applyRemote(f.NoteID, msg.Body)
+			return s.applyRemote(f.NoteID, msg.Body, enc)
 		}
 		return nil
 
@@ -530,16 +534,17 @@ func (s *Session) sendStep1(noteID string) {
 	s.send(EncodeNoteSync(noteID, wsync.EncodeSyncStep1(sv)))
 }
 
-// handleStep1 answers the peer's state vector with the diff they lack.
-func (s *Session) handleStep1(noteID string, sv []byte) error {
+// handleStep1 answers the peer's state vector with the diff they lack,
+// in the encoding the peer asked in.
+func (s *Session) handleStep1(noteID string, sv []byte, enc crdt.Encoding) error {
 	diff, err := s.withDoc(noteID, func(doc *crdt.Doc) ([]byte, error) {
-		return doc.EncodeDiffSince(sv)
+		return doc.EncodeDiffSinceAs(sv, enc)
 	})
 	if err != nil {
 		return err
 	}
 	if len(diff) > 0 {
-		s.send(EncodeNoteSync(noteID, wsync.EncodeSyncStep2(diff)))
+		s.send(EncodeNoteSyncAs(noteID, wsync.EncodeSyncStep2(diff), enc))
 	}
 	return nil
 }
@@ -547,8 +552,9 @@ func (s *Session) handleStep1(noteID string, sv []byte) error {
 // applyRemote merges a remote update into local state: live room when one
 // exists (persists, broadcasts to local clients, schedules mirror), cold
 // path otherwise (persist + immediate mirror). Both persist via
-// PersistChange, so Links.OnPersist relays it onward to other links.
-func (s *Session) applyRemote(noteID string, update []byte) error {
+// PersistChange, so Links.OnPersist relays it onward to other links
+// (always as v1, whatever enc the update arrived in).
+func (s *Session) applyRemote(noteID string, update []byte, enc crdt.Encoding) error {
 	if len(update) == 0 {
 		return nil
 	}
@@ -558,7 +564,7 @@ func (s *Session) applyRemote(noteID string, update []byte) error {
 	origin := s.originKind()
 	if s.deps.Rooms != nil {
 		if room := s.deps.Rooms.RoomIfActive(s.vaultID, noteID); room != nil {
-			return room.ApplyAndBroadcastFederation(update, origin)
+			return room.ApplyAndBroadcastFederationAs(update, enc, origin)
 		}
 	}
 	doc, err := s.deps.Registry.LoadDoc(s.ctx, s.vaultID, noteID)
@@ -566,13 +572,17 @@ func (s *Session) applyRemote(noteID string, update []byte) error {
 		return err
 	}
 	defer doc.Close()
-	if err := doc.ApplyUpdate(update); err != nil {
+	v1, err := doc.ApplyUpdateAs(update, enc)
+	if err != nil {
 		return err
 	}
-	if err := s.deps.Registry.PersistChange(s.ctx, s.vaultID, noteID, update, uuid.Nil, origin, doc); err != nil {
+	if len(v1) == 0 {
+		return nil
+	}
+	if err := s.deps.Registry.PersistChange(s.ctx, s.vaultID, noteID, v1, uuid.Nil, origin, doc); err != nil {
 		return err
 	}
-	text, err := doc.Text()
+	text, err := doc.Markdown()
 	if err != nil {
 		return err
 	}
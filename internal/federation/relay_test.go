@@ -33,6 +33,10 @@ func TestFrameCodec_RoundTrips(t *testing.T) {
 	if err != nil || f.Type != frameNoteSync || f.NoteID != "alpha" || string(f.Payload) != string(payload) {
 		t.Fatalf("noteSync round-trip: %+v %v", f, err)
 	}
+	f, err = DecodeFrame(EncodeNoteSyncAs("alpha", payload, crdt.EncodingV2))
+	if err != nil || f.Type != frameNoteSyncV2 || f.NoteID != "alpha" || string(f.Payload) != string(payload) {
+		t.Fatalf("noteSyncV2 round-trip: %+v %v", f, err)
+	}
 
 	f, err = DecodeFrame(EncodeNoteAnnounce(NoteMeta{ID: "gamma", Path: "g.md", Title: "Γ"}))
 	if err != nil || f.Type != frameNoteAnnounce || f.Note.ID != "gamma" {
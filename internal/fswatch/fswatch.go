@@ -23,12 +23,32 @@
 //
 // # Scope of this slice
 //
-//   - WRITE events on `*.md` files inside a vault → dispatched.
+//   - WRITE events on `*.md` files inside a vault → dispatched as OpWrite.
 //   - CREATE of new directories → automatically watched + reconciled
 //     (synthesised WRITE for files already present, to close the
 //     classic fsnotify race).
-//   - REMOVE / RENAME of `.md` files → logged but not yet routed to
-//     pg.NoteStore.Delete; deferred to a follow-up.
+//   - REMOVE / RENAME of `.md` files and of directories → held for
+//     DefaultPairWindow, then dispatched as OpRemove.
+//
+// # Pairing renames
+//
+// fsnotify reports `mv a.md b.md` as RENAME a.md followed by CREATE
+// b.md; the kernel's rename cookie is not exposed. A removal is
+// therefore parked for a short window: a CREATE of the same path
+// (delete-and-recreate saves, vim's backup rename) turns it back into
+// a plain write, and a CREATE of another path in the same vault pairs
+// with the most recent RENAME — preferring one with the same base
+// name — into an OpMove. Directory renames pair the same way and fan
+// out into one OpMove per note inside. Anything left unpaired when the
+// window closes is a delete.
+//
+// # Network filesystems
+//
+// inotify sees only changes made through the local kernel, so writes
+// from other NFS/SMB clients never arrive. ModeAuto switches to a
+// polling backend (poll.go) when the root is on such a mount or a watch
+// cannot be added; ModePoll forces it. Handlers cannot tell the
+// difference.
 //
 // inotify capacity: defaults are 8192 watches / 128 instances per
 // user. Bump `fs.inotify.max_user_watches` on the Docker host (NOT
@@ -42,6 +62,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -68,6 +89,11 @@ const (
 	// burst length.
 	DefaultDebounce = 100 * time.Millisecond
 
+	// DefaultPairWindow is how long a removed path waits for the
+	// CREATE half of a rename. inotify emits both halves back to back;
+	// the window only has to cover our own scheduling jitter.
+	DefaultPairWindow = 250 * time.Millisecond
+
 	// JanitorInterval expires entries from the suppression map. Lazy
 	// expiry on lookup is good enough for correctness; this just
 	// keeps the map from growing unboundedly when no one calls
@@ -75,28 +101,59 @@ const (
 	JanitorInterval = 5 * time.Second
 )
 
+// Op is the kind of change an Event reports.
+type Op uint8
+
+const (
+	// OpWrite: the file was created or its content changed.
+	OpWrite Op = iota
+	// OpRemove: the file — or, when Event.Dir is set, the directory and
+	// every note under it — is gone.
+	OpRemove
+	// OpMove: the file at OldRelativePath now lives at RelativePath.
+	OpMove
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpWrite:
+		return "write"
+	case OpRemove:
+		return "remove"
+	case OpMove:
+		return "move"
+	}
+	return fmt.Sprintf("op(%d)", uint8(o))
+}
+
 // Event is the post-debounce, post-filter unit dispatched to the
 // handler. Identifies the vault by on-disk slug (first component
 // under root) and the note by its vault-relative path (matches
 // pg.NoteStore.path).
 type Event struct {
+	Op           Op
 	VaultSlug    string
 	RelativePath string
 	AbsPath      string
+
+	// OldRelativePath is the source path of an OpMove.
+	OldRelativePath string
+	// Dir marks an OpRemove of a whole directory.
+	Dir bool
 }
 
-// Handler receives coalesced external writes. Implementations must be
-// safe for concurrent use; the manager calls into them from a single
-// goroutine but the user may run other goroutines around them.
+// Handler receives coalesced external changes. Implementations must be
+// safe for concurrent use; the manager calls into them from its run
+// loop and debounce timers.
 type Handler interface {
-	HandleFSWrite(ctx context.Context, ev Event)
+	HandleFSEvent(ctx context.Context, ev Event)
 }
 
 // HandlerFunc adapts a function to Handler.
 type HandlerFunc func(ctx context.Context, ev Event)
 
-// HandleFSWrite implements Handler.
-func (f HandlerFunc) HandleFSWrite(ctx context.Context, ev Event) { f(ctx, ev) }
+// HandleFSEvent implements Handler.
+func (f HandlerFunc) HandleFSEvent(ctx context.Context, ev Event) { f(ctx, ev) }
 
 // Manager owns the watcher, suppression registry, and debounce
 // scheduler. Construct via New; drive via Run; tear down via Close.
@@ -118,6 +175,22 @@ type Manager struct {
 	debounceMu sync.Mutex
 	debounceFor time.Duration
 
+	// removals parks removed paths for pairWindow; see "Pairing renames".
+	removals   map[string]*removal
+	removalsMu sync.Mutex
+	pairWindow time.Duration
+
+	// dirs is every directory we hold a watch on, so a REMOVE/RENAME of
+	// a path that no longer exists can be told apart as a directory.
+	dirs   map[string]bool
+	dirsMu sync.Mutex
+
+	// mode picks the backend; polling flips once the poller has taken
+	// over (see poll.go).
+	mode      Mode
+	pollEvery time.Duration
+	polling   atomic.Bool
+
 	rootCtx    context.Context
 	rootCancel context.CancelFunc
 	closed     atomic.Bool
@@ -136,6 +209,11 @@ func WithDebounce(d time.Duration) Option {
 	return func(m *Manager) { m.debounceFor = d }
 }
 
+// WithPairWindow overrides DefaultPairWindow.
+func WithPairWindow(d time.Duration) Option {
+	return func(m *Manager) { m.pairWindow = d }
+}
+
 // New constructs a Manager rooted at the given absolute path. root
 // must exist (caller is responsible for calling fs.Manager.EnsureRootDir
 // before this).
@@ -169,8 +247,13 @@ func New(root string, fsMgr *fs.Manager, handler Handler, log zerolog.Logger, op
 		watcher:     w,
 		suppress:    make(map[string]time.Time),
 		debounce:    make(map[string]*time.Timer),
+		removals:    make(map[string]*removal),
+		dirs:        make(map[string]bool),
 		suppressTTL: DefaultSuppressTTL,
 		debounceFor: DefaultDebounce,
+		pairWindow:  DefaultPairWindow,
+		mode:        ModeAuto,
+		pollEvery:   DefaultPollInterval,
 		rootCtx:     ctx,
 		rootCancel:  cancel,
 	}
@@ -202,6 +285,12 @@ func (m *Manager) Close() error {
 	// rationale. A nil read is fine; a nil write panics.
 	m.debounceMu.Unlock()
 
+	m.removalsMu.Lock()
+	for _, r := range m.removals {
+		r.timer.Stop()
+	}
+	m.removalsMu.Unlock()
+
 	return m.watcher.Close()
 }
 
@@ -219,6 +308,14 @@ func (m *Manager) WatchExistingVaults() error {
 		}
 		return fmt.Errorf("fswatch: read root: %w", err)
 	}
+	switch {
+	case m.mode == ModePoll:
+		m.startPolling("configured")
+		return nil
+	case m.mode == ModeAuto && remoteFS(m.root):
+		m.startPolling("network filesystem")
+		return nil
+	}
 	// Watch the root for top-level dir Creates.
 	if err := m.watcher.Add(m.root); err != nil {
 		m.log.Warn().Err(err).Str("root", m.root).Msg("watch root failed")
@@ -251,8 +348,22 @@ func (m *Manager) WatchVault(slug string) error {
 
 // addRecursive walks dir and Add()s every directory. Skips the
 // `.lumi/` metadata directory (we don't want to receive events for
-// CRDT cache files we manage ourselves).
+// CRDT cache files we manage ourselves). Under ModeAuto a failed Add
+// (typically max_user_watches) hands over to the poller; once polling,
+// there is nothing to add.
 func (m *Manager) addRecursive(dir string) error {
+	if m.polling.Load() {
+		return nil
+	}
+	err := m.addWatches(dir)
+	if err != nil && m.mode == ModeAuto {
+		m.startPolling(err.Error())
+		return nil
+	}
+	return err
+}
+
+func (m *Manager) addWatches(dir string) error {
 	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -266,6 +377,9 @@ func (m *Manager) addRecursive(dir string) error {
 		if err := m.watcher.Add(path); err != nil {
 			return fmt.Errorf("watcher.Add(%s): %w", path, err)
 		}
+		m.dirsMu.Lock()
+		m.dirs[path] = true
+		m.dirsMu.Unlock()
 		return nil
 	})
 }
@@ -280,8 +394,13 @@ func (m *Manager) SkipNext(absPath string) {
 	if absPath == "" {
 		return
 	}
+	ttl := m.suppressTTL
+	if m.polling.Load() {
+		// The poller may not notice our write until two scans later.
+		ttl += 2*m.pollEvery + m.debounceFor
+	}
 	m.suppressMu.Lock()
-	m.suppress[absPath] = time.Now().Add(m.suppressTTL)
+	m.suppress[absPath] = time.Now().Add(ttl)
 	m.suppressMu.Unlock()
 }
 
@@ -317,6 +436,9 @@ func (m *Manager) Run(ctx context.Context) {
 			if !ok {
 				return
 			}
+			if m.polling.Load() {
+				continue // the poller owns change detection now
+			}
 			m.dispatch(ev)
 		case err, ok := <-m.watcher.Errors:
 			if !ok {
@@ -353,29 +475,44 @@ func (m *Manager) dispatch(ev fsnotify.Event) {
 	if m.closed.Load() {
 		return
 	}
-	// Directory create: watch it + reconcile.
+	// Directory create: watch it, then either finish a directory
+	// rename or reconcile its contents as fresh writes.
 	if ev.Op.Has(fsnotify.Create) {
 		if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
 			if filepath.Base(ev.Name) != ".lumi" {
 				if err := m.addRecursive(ev.Name); err != nil {
 					m.log.Warn().Err(err).Str("dir", ev.Name).Msg("recursive add failed")
 				}
-				m.reconcileDir(ev.Name)
+				if !m.pairDirCreate(ev.Name) {
+					m.reconcileDir(ev.Name)
+				}
 			}
 			return
 		}
 	}
 
+	// The path is gone: park it until we know whether this was a
+	// rename, an atomic save, or a delete.
+	if ev.Op.Has(fsnotify.Remove) || ev.Op.Has(fsnotify.Rename) {
+		if _, err := os.Lstat(ev.Name); errors.Is(err, os.ErrNotExist) {
+			m.holdRemoval(ev.Name, ev.Op.Has(fsnotify.Rename))
+			return
+		}
+	}
+
 	// We only care about content changes on regular files.
 	if !(ev.Op.Has(fsnotify.Write) || ev.Op.Has(fsnotify.Create) || ev.Op.Has(fsnotify.Rename)) {
 		return
 	}
-	if !isInterestingPath(ev.Name) {
+	if !isInterestingPath(ev.Name) || m.ignored(ev.Name, false) {
 		return
 	}
 	if m.shouldSuppress(ev.Name) {
 		return
 	}
+	if ev.Op.Has(fsnotify.Create) && m.pairCreate(ev.Name) {
+		return
+	}
 
 	m.debounceMu.Lock()
 	if t, ok := m.debounce[ev.Name]; ok {
@@ -403,7 +540,7 @@ func (m *Manager) reconcileDir(dir string) {
 			return nil // best-effort
 		}
 		if d.IsDir() {
-			if filepath.Base(path) == ".lumi" && path != dir {
+			if path != dir && (filepath.Base(path) == ".lumi" || m.ignored(path, true)) {
 				return filepath.SkipDir
 			}
 			return nil
@@ -436,30 +573,239 @@ func (m *Manager) fire(absPath string) {
 	if err != nil || info.IsDir() {
 		return
 	}
-	rel, err := filepath.Rel(m.root, absPath)
-	if err != nil {
-		return
-	}
-	parts := strings.SplitN(filepath.ToSlash(rel), "/", 2)
-	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+	slug, rel, ok := m.split(absPath)
+	if !ok {
 		return
 	}
-	ev := Event{
-		VaultSlug:    parts[0],
-		RelativePath: parts[1],
-		AbsPath:      absPath,
-	}
+	m.emit(Event{Op: OpWrite, VaultSlug: slug, RelativePath: rel, AbsPath: absPath})
+}
+
+// emit hands ev to the current handler, if any.
+func (m *Manager) emit(ev Event) {
 	m.handlerMu.RLock()
 	h := m.handler
 	m.handlerMu.RUnlock()
 	if h == nil {
 		return
 	}
-	h.HandleFSWrite(m.rootCtx, ev)
+	h.HandleFSEvent(m.rootCtx, ev)
+}
+
+// split resolves absPath into (vault slug, vault-relative path). ok is
+// false for the root, a vault directory itself, or a path outside root.
+func (m *Manager) split(absPath string) (slug, rel string, ok bool) {
+	r, err := filepath.Rel(m.root, absPath)
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(filepath.ToSlash(r), "/", 2)
+	if len(parts) < 2 || parts[0] == "" || parts[0] == ".." || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// ---- Removals and renames --------------------------------------------------
+
+// removal is a path that disappeared less than pairWindow ago.
+type removal struct {
+	abs, slug, rel string
+	dir            bool
+	renamed        bool // RENAME rather than REMOVE: may pair with a CREATE
+	at             time.Time
+	timer          *time.Timer
+}
+
+// holdRemoval parks a vanished path for pairWindow. Directories are
+// recognised from the watch set (the path can no longer be stat'ed),
+// and their watches — plus those of every subdirectory — are dropped
+// so a renamed tree is re-added under its new name.
+func (m *Manager) holdRemoval(absPath string, renamed bool) {
+	m.dirsMu.Lock()
+	dir := m.dirs[absPath]
+	var gone []string
+	if dir {
+		prefix := absPath + string(os.PathSeparator)
+		for d := range m.dirs {
+			if d == absPath || strings.HasPrefix(d, prefix) {
+				gone = append(gone, d)
+				delete(m.dirs, d)
+			}
+		}
+	}
+	m.dirsMu.Unlock()
+	for _, d := range gone {
+		_ = m.watcher.Remove(d) // already gone for deletes; best-effort
+	}
+
+	if (!dir && !isInterestingPath(absPath)) || m.ignored(absPath, dir) {
+		return
+	}
+	if m.shouldSuppress(absPath) {
+		return
+	}
+	slug, rel, ok := m.split(absPath)
+	if !ok {
+		return
+	}
+	m.cancelDebounce(absPath)
+
+	m.removalsMu.Lock()
+	defer m.removalsMu.Unlock()
+	// A directory rename arrives twice (from the parent's watch and
+	// its own); keep the first.
+	if _, dup := m.removals[absPath]; dup {
+		return
+	}
+	r := &removal{abs: absPath, slug: slug, rel: rel, dir: dir, renamed: renamed, at: time.Now()}
+	r.timer = time.AfterFunc(m.pairWindow, func() { m.expireRemoval(r) })
+	m.removals[absPath] = r
+}
+
+// cancelDebounce drops a pending write for absPath.
+func (m *Manager) cancelDebounce(absPath string) {
+	m.debounceMu.Lock()
+	if t, ok := m.debounce[absPath]; ok {
+		t.Stop()
+		delete(m.debounce, absPath)
+	}
+	m.debounceMu.Unlock()
+}
+
+// expireRemoval fires when nobody claimed r within the pair window.
+func (m *Manager) expireRemoval(r *removal) {
+	m.removalsMu.Lock()
+	if m.removals[r.abs] != r {
+		m.removalsMu.Unlock()
+		return
+	}
+	delete(m.removals, r.abs)
+	m.removalsMu.Unlock()
+
+	if _, err := os.Lstat(r.abs); err == nil {
+		// Back in place without a CREATE we saw: treat as an edit.
+		if !r.dir {
+			m.dispatch(fsnotify.Event{Name: r.abs, Op: fsnotify.Write})
+		}
+		return
+	}
+	m.emit(Event{Op: OpRemove, VaultSlug: r.slug, RelativePath: r.rel, AbsPath: r.abs, Dir: r.dir})
+}
+
+// partner picks the pending rename a CREATE of base in slug completes:
+// the most recent one with the same base name, else the most recent
+// one overall. Caller holds removalsMu.
+func (m *Manager) partner(slug, base string, dir bool) *removal {
+	var best, sameBase *removal
+	for _, r := range m.removals {
+		if !r.renamed || r.dir != dir || r.slug != slug {
+			continue
+		}
+		if best == nil || r.at.After(best.at) {
+			best = r
+		}
+		if path.Base(r.rel) == base && (sameBase == nil || r.at.After(sameBase.at)) {
+			sameBase = r
+		}
+	}
+	if sameBase != nil {
+		return sameBase
+	}
+	return best
+}
+
+// claim removes r from the pending set. Caller holds removalsMu.
+func (m *Manager) claim(r *removal) {
+	r.timer.Stop()
+	delete(m.removals, r.abs)
+}
+
+// pairCreate resolves a file CREATE against pending removals. Returns
+// true when it completed a rename (an OpMove was emitted); false means
+// the caller should treat the CREATE as a write.
+func (m *Manager) pairCreate(absPath string) bool {
+	slug, rel, ok := m.split(absPath)
+	if !ok {
+		return false
+	}
+	m.removalsMu.Lock()
+	if r, ok := m.removals[absPath]; ok {
+		// Removed and re-created in place: an atomic save.
+		m.claim(r)
+		m.removalsMu.Unlock()
+		return false
+	}
+	r := m.partner(slug, path.Base(rel), false)
+	if r == nil {
+		m.removalsMu.Unlock()
+		return false
+	}
+	m.claim(r)
+	m.removalsMu.Unlock()
+
+	m.emit(Event{Op: OpMove, VaultSlug: slug, RelativePath: rel, OldRelativePath: r.rel, AbsPath: absPath})
+	return true
+}
+
+// pairDirCreate completes a directory rename: every note under the new
+// directory is reported as moved from the same place under the old one.
+func (m *Manager) pairDirCreate(dir string) bool {
+	slug, rel, ok := m.split(dir)
+	if !ok {
+		return false
+	}
+	m.removalsMu.Lock()
+	r := m.partner(slug, path.Base(rel), true)
+	if r == nil {
+		m.removalsMu.Unlock()
+		return false
+	}
+	m.claim(r)
+	m.removalsMu.Unlock()
+
+	_ = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil // best-effort
+		}
+		if d.IsDir() {
+			if p != dir && (filepath.Base(p) == ".lumi" || m.ignored(p, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isInterestingPath(p) || m.ignored(p, false) {
+			return nil
+		}
+		sub, err := filepath.Rel(dir, p)
+		if err != nil {
+			return nil
+		}
+		sub = filepath.ToSlash(sub)
+		m.emit(Event{
+			Op:              OpMove,
+			VaultSlug:       slug,
+			RelativePath:    rel + "/" + sub,
+			OldRelativePath: r.rel + "/" + sub,
+			AbsPath:         p,
+		})
+		return nil
+	})
+	return true
 }
 
 // ---- Helpers ---------------------------------------------------------------
 
+// ignored reports whether the vault's .lumi/ignore excludes absPath.
+// The rules are re-read when the file changes, so edits take effect on
+// the next event.
+func (m *Manager) ignored(absPath string, isDir bool) bool {
+	slug, rel, ok := m.split(absPath)
+	if !ok {
+		return false
+	}
+	return m.fsMgr.Ignored(slug, rel, isDir)
+}
+
 // isInterestingPath returns true iff absPath looks like a real
 // markdown file under a vault. Editor temp files, dotfiles, and
 // non-`.md` extensions are excluded.
@@ -24,7 +24,7 @@ type recorder struct {
 
 func newRecorder() *recorder { return &recorder{ch: make(chan Event, 32)} }
 
-func (r *recorder) HandleFSWrite(_ context.Context, ev Event) {
+func (r *recorder) HandleFSEvent(_ context.Context, ev Event) {
 	r.count.Add(1)
 	select {
 	case r.ch <- ev:
@@ -296,3 +296,202 @@ func TestReconcileDirSurfacesExistingFiles(t *testing.T) {
 		t.Fatalf("relative path = %q", ev.RelativePath)
 	}
 }
+
+// waitForOp returns the first event of kind op within d, skipping
+// others.
+func (r *recorder) waitForOp(op Op, d time.Duration) (Event, bool) {
+	deadline := time.After(d)
+	for {
+		select {
+		case ev := <-r.ch:
+			if ev.Op == op {
+				return ev, true
+			}
+		case <-deadline:
+			return Event{}, false
+		}
+	}
+}
+
+func TestRenamePairsIntoMove(t *testing.T) {
+	_, _, root, rec, teardown := newTestManager(t, WithDebounce(20*time.Millisecond))
+	defer teardown()
+
+	oldPath := filepath.Join(root, "vault", "old.md")
+	if err := os.WriteFile(oldPath, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.waitForOp(OpWrite, 800*time.Millisecond); !ok {
+		t.Fatal("no write for the seed file")
+	}
+	if err := os.MkdirAll(filepath.Join(root, "vault", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Rename(oldPath, filepath.Join(root, "vault", "sub", "new.md")); err != nil {
+		t.Fatal(err)
+	}
+
+	ev, ok := rec.waitForOp(OpMove, 800*time.Millisecond)
+	if !ok {
+		t.Fatal("no move event")
+	}
+	if ev.OldRelativePath != "old.md" || ev.RelativePath != "sub/new.md" || ev.VaultSlug != "vault" {
+		t.Fatalf("move = %+v", ev)
+	}
+	if ev, ok := rec.waitForOp(OpRemove, 400*time.Millisecond); ok {
+		t.Fatalf("paired rename also reported a remove: %+v", ev)
+	}
+}
+
+func TestDeleteEmitsRemove(t *testing.T) {
+	_, _, root, rec, teardown := newTestManager(t,
+		WithDebounce(20*time.Millisecond), WithPairWindow(50*time.Millisecond))
+	defer teardown()
+
+	p := filepath.Join(root, "vault", "doomed.md")
+	if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.waitForOp(OpWrite, 800*time.Millisecond); !ok {
+		t.Fatal("no write for the seed file")
+	}
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForOp(OpRemove, 800*time.Millisecond)
+	if !ok {
+		t.Fatal("no remove event")
+	}
+	if ev.RelativePath != "doomed.md" || ev.Dir {
+		t.Fatalf("remove = %+v", ev)
+	}
+}
+
+func TestAtomicSaveStaysAWrite(t *testing.T) {
+	_, _, root, rec, teardown := newTestManager(t, WithDebounce(20*time.Millisecond))
+	defer teardown()
+
+	p := filepath.Join(root, "vault", "saved.md")
+	if err := os.WriteFile(p, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.waitForOp(OpWrite, 800*time.Millisecond); !ok {
+		t.Fatal("no write for the seed file")
+	}
+	// vim without backupcopy: rename the original away, write afresh.
+	if err := os.Rename(p, p+"~"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForEvent(800 * time.Millisecond)
+	if !ok || ev.Op != OpWrite || ev.RelativePath != "saved.md" {
+		t.Fatalf("atomic save = %+v, %v", ev, ok)
+	}
+	if ev, ok := rec.waitForEvent(400 * time.Millisecond); ok && ev.Op != OpWrite {
+		t.Fatalf("atomic save also reported %+v", ev)
+	}
+}
+
+func TestDirectoryRenameMovesEveryNote(t *testing.T) {
+	_, _, root, rec, teardown := newTestManager(t, WithDebounce(20*time.Millisecond))
+	defer teardown()
+
+	src := filepath.Join(root, "vault", "drafts")
+	if err := os.MkdirAll(filepath.Join(src, "deep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, f := range []string{"a.md", "deep/b.md"} {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(f)), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(150 * time.Millisecond)
+	for len(rec.ch) > 0 {
+		<-rec.ch
+	}
+
+	if err := os.Rename(src, filepath.Join(root, "vault", "posts")); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for len(got) < 2 {
+		ev, ok := rec.waitForOp(OpMove, 800*time.Millisecond)
+		if !ok {
+			t.Fatalf("moves so far: %v", got)
+		}
+		got[ev.OldRelativePath] = ev.RelativePath
+	}
+	if got["drafts/a.md"] != "posts/a.md" || got["drafts/deep/b.md"] != "posts/deep/b.md" {
+		t.Fatalf("moves = %v", got)
+	}
+
+	// The new tree is watched under its new name.
+	if err := os.WriteFile(filepath.Join(root, "vault", "posts", "deep", "c.md"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForOp(OpWrite, 800*time.Millisecond)
+	if !ok || ev.RelativePath != "posts/deep/c.md" {
+		t.Fatalf("write under renamed dir = %+v, %v", ev, ok)
+	}
+}
+
+func TestDirectoryDeleteEmitsDirRemove(t *testing.T) {
+	_, _, root, rec, teardown := newTestManager(t,
+		WithDebounce(20*time.Millisecond), WithPairWindow(50*time.Millisecond))
+	defer teardown()
+
+	dir := filepath.Join(root, "vault", "trash")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	// Move the directory out of the tree, as Finder's "Move to Trash"
+	// does: only the RENAME half is visible.
+	if err := os.Rename(dir, filepath.Join(t.TempDir(), "trash")); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForOp(OpRemove, 800*time.Millisecond)
+	if !ok {
+		t.Fatal("no remove event")
+	}
+	if !ev.Dir || ev.RelativePath != "trash" {
+		t.Fatalf("remove = %+v", ev)
+	}
+}
+
+func TestIgnoreFileFiltersEvents(t *testing.T) {
+	_, _, root, rec, teardown := newTestManager(t, WithDebounce(20*time.Millisecond))
+	defer teardown()
+
+	vault := filepath.Join(root, "vault")
+	if err := os.MkdirAll(filepath.Join(vault, ".lumi"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(vault, ".lumi", "ignore"), []byte("drafts/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(vault, "drafts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the new dir's watch land
+	if err := os.WriteFile(filepath.Join(vault, "drafts", "wip.md"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(vault, "drafts", "wip.md")); err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := rec.waitForEvent(300 * time.Millisecond); ok {
+		t.Fatalf("ignored path produced %+v", ev)
+	}
+	if err := os.WriteFile(filepath.Join(vault, "kept.md"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForEvent(800 * time.Millisecond)
+	if !ok || ev.RelativePath != "kept.md" {
+		t.Fatalf("got %+v, %v", ev, ok)
+	}
+}
@@ -0,0 +1,264 @@
+package fswatch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/ViniZap4/lumi-server/internal/storage/fs"
+)
+
+// ---- Polling backend -------------------------------------------------------
+
+// inotify never hears about writes made by another NFS/SMB client, so
+// on network filesystems the Manager scans the tree instead. A scan
+// records (mtime, size, inode) for every markdown file under the root
+// and diffs against the previous scan:
+//
+//   - new or changed files go through dispatch as writes, so they get
+//     the same debounce and SkipNext suppression as fsnotify events;
+//   - a vanished file whose inode reappeared under another path is an
+//     OpMove (directory renames fan out naturally, one per note);
+//   - any other vanished file is an OpRemove.
+//
+// Paths excluded by a vault's .lumi/ignore produce nothing; a note
+// moved into an ignored directory is therefore a remove.
+//
+// A scan is one WalkDir plus one lstat per file, and a snapshot entry
+// is 32 bytes, so tens of thousands of notes cost a few MB and well
+// under a second per interval on a warm attribute cache. Directories
+// that fail to read mid-scan keep their previous entries rather than
+// looking deleted.
+
+// Mode selects the change-detection backend.
+type Mode string
+
+const (
+	// ModeAuto uses fsnotify and falls back to polling when the root
+	// sits on a network filesystem or a watch cannot be added.
+	ModeAuto Mode = "auto"
+	// ModeNotify uses fsnotify only.
+	ModeNotify Mode = "notify"
+	// ModePoll always polls.
+	ModePoll Mode = "poll"
+)
+
+// ParseMode validates a Mode from configuration. "" means ModeAuto.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return ModeAuto, nil
+	case ModeAuto, ModeNotify, ModePoll:
+		return m, nil
+	}
+	return "", errors.New("fswatch: mode must be auto, notify or poll")
+}
+
+// DefaultPollInterval is the scan period of the polling backend.
+const DefaultPollInterval = 5 * time.Second
+
+// WithMode overrides ModeAuto.
+func WithMode(mode Mode) Option {
+	return func(m *Manager) { m.mode = mode }
+}
+
+// WithPollInterval overrides DefaultPollInterval.
+func WithPollInterval(d time.Duration) Option {
+	return func(m *Manager) { m.pollEvery = d }
+}
+
+// Polling reports whether the polling backend is active.
+func (m *Manager) Polling() bool { return m.polling.Load() }
+
+// startPolling switches the Manager to the polling backend. fsnotify
+// watches are released and later fsnotify events ignored. Idempotent.
+func (m *Manager) startPolling(reason string) {
+	if m.closed.Load() || !m.polling.CompareAndSwap(false, true) {
+		return
+	}
+	m.log.Warn().Str("reason", reason).Dur("interval", m.pollEvery).Msg("falling back to polling")
+	m.dirsMu.Lock()
+	for d := range m.dirs {
+		_ = m.watcher.Remove(d)
+		delete(m.dirs, d)
+	}
+	m.dirsMu.Unlock()
+	_ = m.watcher.Remove(m.root)
+	go m.pollLoop()
+}
+
+func (m *Manager) pollLoop() {
+	prev, ok := m.scan()
+	for !ok {
+		// The root itself is unreadable; there is no baseline to diff
+		// against yet.
+		select {
+		case <-m.rootCtx.Done():
+			return
+		case <-time.After(m.pollEvery):
+		}
+		prev, ok = m.scan()
+	}
+	t := time.NewTicker(m.pollEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.rootCtx.Done():
+			return
+		case <-t.C:
+		}
+		next, ok := m.scan()
+		if !ok {
+			continue
+		}
+		m.diffScans(prev, next)
+		prev = next
+	}
+}
+
+// pollEntry is what a scan remembers about one file.
+type pollEntry struct {
+	mtime, size int64
+	dev, ino    uint64
+}
+
+// pollSnapshot is one scan: entries by absolute path, plus the
+// directories whose listing failed.
+type pollSnapshot struct {
+	files  map[string]pollEntry
+	failed []string
+}
+
+// scan walks the root. ok is false when the root cannot be read.
+func (m *Manager) scan() (snap pollSnapshot, ok bool) {
+	snap.files = make(map[string]pollEntry)
+	rules := make(map[string]*fs.IgnoreRules)
+	err := filepath.WalkDir(m.root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if p == m.root {
+				return err
+			}
+			snap.failed = append(snap.failed, p)
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".lumi" {
+				return filepath.SkipDir
+			}
+			// Skip ignored trees rather than stat every file in them.
+			if slug, rel, ok := m.split(p); ok {
+				if _, seen := rules[slug]; !seen {
+					rules[slug] = m.fsMgr.IgnoreRules(slug)
+				}
+				if rules[slug].Match(rel, true) {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !isInterestingPath(p) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				snap.failed = append(snap.failed, p)
+			}
+			return nil
+		}
+		e := pollEntry{mtime: info.ModTime().UnixNano(), size: info.Size()}
+		e.dev, e.ino = fileID(info)
+		snap.files[p] = e
+		return nil
+	})
+	return snap, err == nil
+}
+
+// diffScans dispatches what changed between two scans.
+func (m *Manager) diffScans(prev, next pollSnapshot) {
+	var gone, added []string
+	for p, old := range prev.files {
+		if _, ok := next.files[p]; ok {
+			continue
+		}
+		if underAny(p, next.failed) {
+			next.files[p] = old // unreadable this time, not deleted
+			continue
+		}
+		if !m.ignored(p, false) {
+			gone = append(gone, p)
+		}
+	}
+	for p, cur := range next.files {
+		old, ok := prev.files[p]
+		switch {
+		case m.ignored(p, false):
+		case !ok, cur.ino != old.ino:
+			// New here, or replaced by another file — possibly one
+			// renamed over it.
+			added = append(added, p)
+		case old.mtime != cur.mtime || old.size != cur.size:
+			m.dispatch(fsnotify.Event{Name: p, Op: fsnotify.Write})
+		}
+	}
+	sort.Strings(gone)
+	sort.Strings(added)
+
+	// Pair renames by inode.
+	byID := make(map[[2]uint64]string, len(gone))
+	for _, p := range gone {
+		if e := prev.files[p]; e.ino != 0 {
+			byID[[2]uint64{e.dev, e.ino}] = p
+		}
+	}
+	moved := make(map[string]bool)
+	for _, p := range added {
+		cur := next.files[p]
+		from, ok := byID[[2]uint64{cur.dev, cur.ino}]
+		if cur.ino == 0 || !ok {
+			m.dispatch(fsnotify.Event{Name: p, Op: fsnotify.Write})
+			continue
+		}
+		delete(byID, [2]uint64{cur.dev, cur.ino})
+		moved[from] = true
+		if m.shouldSuppress(from) || m.shouldSuppress(p) {
+			continue
+		}
+		oldSlug, oldRel, ok1 := m.split(from)
+		slug, rel, ok2 := m.split(p)
+		if !ok1 || !ok2 || oldSlug != slug {
+			// Moved across vaults: a delete here and a create there.
+			moved[from] = false
+			m.dispatch(fsnotify.Event{Name: p, Op: fsnotify.Write})
+			continue
+		}
+		m.emit(Event{Op: OpMove, VaultSlug: slug, RelativePath: rel, OldRelativePath: oldRel, AbsPath: p})
+		if old := prev.files[from]; old.mtime != cur.mtime || old.size != cur.size {
+			m.dispatch(fsnotify.Event{Name: p, Op: fsnotify.Write})
+		}
+	}
+	for _, p := range gone {
+		if moved[p] || m.shouldSuppress(p) {
+			continue
+		}
+		m.cancelDebounce(p)
+		if slug, rel, ok := m.split(p); ok {
+			m.emit(Event{Op: OpRemove, VaultSlug: slug, RelativePath: rel, AbsPath: p})
+		}
+	}
+}
+
+// underAny reports whether p is one of dirs or lies inside one.
+func underAny(p string, dirs []string) bool {
+	for _, d := range dirs {
+		if p == d || strings.HasPrefix(p, d+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package fswatch
+
+import "os"
+
+// fileID is unavailable here; the poller reports renames as a remove
+// plus a write.
+func fileID(os.FileInfo) (dev, ino uint64) { return 0, 0 }
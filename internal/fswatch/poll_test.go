@@ -0,0 +1,150 @@
+package fswatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newPollingManager(t *testing.T) (*Manager, string, *recorder, func()) {
+	t.Helper()
+	mgr, _, root, rec, teardown := newTestManager(t,
+		WithMode(ModePoll),
+		WithPollInterval(30*time.Millisecond),
+		WithDebounce(10*time.Millisecond),
+	)
+	if !mgr.Polling() {
+		t.Fatal("ModePoll did not start the poller")
+	}
+	// Let the baseline scan land before the test touches the tree.
+	time.Sleep(60 * time.Millisecond)
+	return mgr, root, rec, teardown
+}
+
+func TestPollDetectsWrites(t *testing.T) {
+	_, root, rec, teardown := newPollingManager(t)
+	defer teardown()
+
+	p := filepath.Join(root, "vault", "n.md")
+	if err := os.WriteFile(p, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForOp(OpWrite, time.Second)
+	if !ok || ev.RelativePath != "n.md" {
+		t.Fatalf("create: %+v, %v", ev, ok)
+	}
+	if err := os.WriteFile(p, []byte("two, longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.waitForOp(OpWrite, time.Second); !ok {
+		t.Fatal("edit not detected")
+	}
+	// Nothing else changes: no further events.
+	if ev, ok := rec.waitForEvent(150 * time.Millisecond); ok {
+		t.Fatalf("spurious event %+v", ev)
+	}
+}
+
+func TestPollRenameAndDelete(t *testing.T) {
+	_, root, rec, teardown := newPollingManager(t)
+	defer teardown()
+
+	dir := filepath.Join(root, "vault")
+	a := filepath.Join(dir, "a.md")
+	if err := os.WriteFile(a, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.waitForOp(OpWrite, time.Second); !ok {
+		t.Fatal("create not detected")
+	}
+	if err := os.Rename(a, filepath.Join(dir, "b.md")); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := rec.waitForOp(OpMove, time.Second)
+	if !ok || ev.OldRelativePath != "a.md" || ev.RelativePath != "b.md" {
+		t.Fatalf("rename: %+v, %v", ev, ok)
+	}
+	if err := os.Remove(filepath.Join(dir, "b.md")); err != nil {
+		t.Fatal(err)
+	}
+	ev, ok = rec.waitForOp(OpRemove, time.Second)
+	if !ok || ev.RelativePath != "b.md" {
+		t.Fatalf("delete: %+v, %v", ev, ok)
+	}
+}
+
+func TestPollDirectoryRename(t *testing.T) {
+	_, root, rec, teardown := newPollingManager(t)
+	defer teardown()
+
+	dir := filepath.Join(root, "vault")
+	if err := os.MkdirAll(filepath.Join(dir, "old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"x.md", "y.md"} {
+		if err := os.WriteFile(filepath.Join(dir, "old", n), []byte(n), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := rec.waitForOp(OpWrite, time.Second); !ok {
+			t.Fatal("create not detected")
+		}
+	}
+	if err := os.Rename(filepath.Join(dir, "old"), filepath.Join(dir, "new")); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		ev, ok := rec.waitForOp(OpMove, time.Second)
+		if !ok {
+			t.Fatalf("moves so far: %v", got)
+		}
+		got[ev.OldRelativePath] = ev.RelativePath
+	}
+	if got["old/x.md"] != "new/x.md" || got["old/y.md"] != "new/y.md" {
+		t.Fatalf("moves = %v", got)
+	}
+}
+
+func TestPollHonoursSkipNext(t *testing.T) {
+	mgr, root, rec, teardown := newPollingManager(t)
+	defer teardown()
+
+	p := filepath.Join(root, "vault", "self.md")
+	mgr.SkipNext(p)
+	if err := os.WriteFile(p, []byte("server-write"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if ev, ok := rec.waitForEvent(200 * time.Millisecond); ok {
+		t.Fatalf("expected suppression, got %+v", ev)
+	}
+}
+
+func TestDiffScansKeepsUnreadableDirs(t *testing.T) {
+	mgr, _, root, rec, teardown := newTestManager(t, WithMode(ModeNotify))
+	defer teardown()
+
+	p := filepath.Join(root, "vault", "sub", "n.md")
+	prev := pollSnapshot{files: map[string]pollEntry{p: {mtime: 1, size: 1, ino: 7}}}
+	next := pollSnapshot{files: map[string]pollEntry{}, failed: []string{filepath.Join(root, "vault", "sub")}}
+	mgr.diffScans(prev, next)
+	if ev, ok := rec.waitForEvent(100 * time.Millisecond); ok {
+		t.Fatalf("unreadable directory reported as %+v", ev)
+	}
+	if _, ok := next.files[p]; !ok {
+		t.Fatal("entry not carried forward")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeAuto, "AUTO": ModeAuto, "notify": ModeNotify, " poll ": ModePoll} {
+		if got, err := ParseMode(in); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseMode("inotify"); err == nil {
+		t.Error("ParseMode accepted an unknown mode")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package fswatch
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the (device, inode) pair the poller pairs renames by.
+func fileID(info os.FileInfo) (dev, ino uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(st.Dev), st.Ino
+}
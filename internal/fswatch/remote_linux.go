@@ -0,0 +1,29 @@
+//go:build linux
+
+package fswatch
+
+import "syscall"
+
+// Superblock magics of filesystems whose remote changes inotify never
+// sees (linux/magic.h).
+const (
+	nfsMagic  = 0x6969
+	smbMagic  = 0x517b
+	cifsMagic = 0xff534d42
+	smb2Magic = 0xfe534d42
+	v9fsMagic = 0x01021997
+	cephMagic = 0x00c36400
+)
+
+// remoteFS reports whether path lives on a network filesystem.
+func remoteFS(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	switch uint32(st.Type) {
+	case nfsMagic, smbMagic, cifsMagic, smb2Magic, v9fsMagic, cephMagic:
+		return true
+	}
+	return false
+}
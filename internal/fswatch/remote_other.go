@@ -0,0 +1,7 @@
+//go:build !linux
+
+package fswatch
+
+// remoteFS is only detected on Linux; elsewhere select ModePoll
+// explicitly for network mounts.
+func remoteFS(string) bool { return false }
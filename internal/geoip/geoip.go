@@ -0,0 +1,150 @@
+// Package geoip places IP addresses on the map, roughly, from a local
+// CSV database, so the login checks can tell when two logins are too far
+// apart to have been made by one person. It reads the MaxMind GeoLite2
+// "City-Blocks" CSV layout (IPv4 and IPv6 files may be concatenated or
+// loaded together), or any CSV with network, latitude and longitude
+// columns. Nothing is looked up over the network.
+package geoip
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Location is a point on the globe in decimal degrees.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// DB is an in-memory table of network blocks. The zero value knows no
+// addresses.
+type DB struct {
+	blocks []block // sorted by first, non-overlapping per family
+}
+
+type block struct {
+	first, last netip.Addr
+	loc         Location
+}
+
+// Open loads the CSV file at path.
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Load reads CSV blocks from r. A header row names the columns; a repeated
+// header (from concatenated files) is skipped, as are blocks without
+// coordinates.
+func Load(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("geoip: read header: %w", err)
+	}
+	netCol, latCol, lonCol := -1, -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "network":
+			netCol = i
+		case "latitude":
+			latCol = i
+		case "longitude":
+			lonCol = i
+		}
+	}
+	if netCol < 0 || latCol < 0 || lonCol < 0 {
+		return nil, errors.New("geoip: header needs network, latitude and longitude columns")
+	}
+	width := max(netCol, latCol, lonCol) + 1
+	netName := header[netCol] // header's backing array is reused below
+
+	db := &DB{}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+		if len(rec) < width || rec[netCol] == netName || rec[latCol] == "" || rec[lonCol] == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(rec[netCol])
+		if err != nil {
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+		lat, err1 := strconv.ParseFloat(rec[latCol], 64)
+		lon, err2 := strconv.ParseFloat(rec[lonCol], 64)
+		if err := errors.Join(err1, err2); err != nil {
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+		prefix = prefix.Masked()
+		db.blocks = append(db.blocks, block{
+			first: prefix.Addr(),
+			last:  lastAddr(prefix),
+			loc:   Location{Latitude: lat, Longitude: lon},
+		})
+	}
+	sort.Slice(db.blocks, func(i, j int) bool { return db.blocks[i].first.Less(db.blocks[j].first) })
+	return db, nil
+}
+
+// Len is the number of blocks loaded.
+func (db *DB) Len() int { return len(db.blocks) }
+
+// Locate returns the location of the block holding ip.
+func (db *DB) Locate(ip string) (Location, bool) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return Location{}, false
+	}
+	addr = addr.Unmap()
+	// The last block starting at or before addr is the only candidate.
+	i := sort.Search(len(db.blocks), func(i int) bool { return addr.Less(db.blocks[i].first) }) - 1
+	if i < 0 {
+		return Location{}, false
+	}
+	b := db.blocks[i]
+	if b.first.BitLen() != addr.BitLen() || b.last.Less(addr) {
+		return Location{}, false
+	}
+	return b.loc, true
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().AsSlice()
+	for bit := p.Bits(); bit < len(a)*8; bit++ {
+		a[bit/8] |= 0x80 >> (bit % 8)
+	}
+	out, _ := netip.AddrFromSlice(a)
+	return out
+}
+
+const earthRadiusKm = 6371.0
+
+// DistanceKm is the great-circle distance between a and b.
+func DistanceKm(a, b Location) float64 {
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := rad(b.Latitude - a.Latitude)
+	dLon := rad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Latitude))*math.Cos(rad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,64 @@
+package geoip
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+const blocks = `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius
+81.2.69.0/24,2643743,2635167,,0,0,EC1A,51.5085,-0.1257,20
+175.16.199.0/24,1814991,1814991,,0,0,,43.88,125.3228,100
+216.160.83.56/29,5803556,6252001,,0,0,98354,47.2513,-122.3149,22
+10.0.0.0/8,,,,0,0,,,,
+network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius
+2001:480::/32,5391811,6252001,,0,0,92109,32.7977,-117.2335,1000
+`
+
+func TestLocate(t *testing.T) {
+	db, err := Load(strings.NewReader(blocks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 4 {
+		t.Fatalf("Len = %d, want 4 (blocks without coordinates skipped)", db.Len())
+	}
+	cases := []struct {
+		ip  string
+		lat float64
+		ok  bool
+	}{
+		{"81.2.69.142", 51.5085, true},
+		{"81.2.70.1", 0, false},
+		{"216.160.83.63", 47.2513, true},
+		{"216.160.83.64", 0, false},
+		{"::ffff:175.16.199.7", 43.88, true},
+		{"2001:480:10::1", 32.7977, true},
+		{"10.1.2.3", 0, false},
+		{"1.1.1.1", 0, false},
+		{"not an ip", 0, false},
+	}
+	for _, c := range cases {
+		loc, ok := db.Locate(c.ip)
+		if ok != c.ok || loc.Latitude != c.lat {
+			t.Errorf("Locate(%q) = %v, %v; want latitude %v, %v", c.ip, loc, ok, c.lat, c.ok)
+		}
+	}
+}
+
+func TestLoadNeedsCoordinateColumns(t *testing.T) {
+	if _, err := Load(strings.NewReader("network,geoname_id\n1.2.3.0/24,1\n")); err == nil {
+		t.Fatal("accepted a CSV without latitude and longitude")
+	}
+}
+
+func TestDistanceKm(t *testing.T) {
+	london := Location{Latitude: 51.5085, Longitude: -0.1257}
+	newYork := Location{Latitude: 40.7128, Longitude: -74.006}
+	if d := DistanceKm(london, newYork); math.Abs(d-5570) > 15 {
+		t.Fatalf("London-New York = %.0f km, want about 5570", d)
+	}
+	if d := DistanceKm(london, london); d != 0 {
+		t.Fatalf("distance to self = %v", d)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/ViniZap4/lumi-server/internal/audit"
 	"github.com/ViniZap4/lumi-server/internal/capguard"
 	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/mail"
 )
 
 // Tunables.
@@ -46,6 +47,7 @@ type Repo interface {
 
 // UserRepo is the slice the invite service needs.
 type UserRepo interface {
+	GetByID(ctx context.Context, id uuid.UUID) (domain.User, error)
 	GetByUsername(ctx context.Context, username string) (domain.User, error)
 	CreateUserDirect(ctx context.Context, u domain.User) error
 }
@@ -77,9 +79,11 @@ type SessionRepo interface {
 	Create(ctx context.Context, s domain.Session) error
 }
 
-// AuthHasher hides password hashing to avoid an import cycle.
+// AuthHasher hides password hashing and the password policy to avoid an
+// import cycle.
 type AuthHasher interface {
 	HashPassword(plaintext string) (string, error)
+	ValidatePassword(plaintext string) error
 }
 
 // TokenIssuer hides session-token issuance to avoid an import cycle.
@@ -87,6 +91,18 @@ type TokenIssuer interface {
 	NewSessionToken() string
 }
 
+// Mailer queues templated email. Implemented by *mail.Outbox.
+type Mailer interface {
+	Enqueue(ctx context.Context, to, template string, data any) error
+}
+
+// Limiter throttles accept attempts per client IP. Implemented by
+// auth.RateLimiter and auth.StoreLimiter.
+type Limiter interface {
+	Allow(key string) bool
+	Reset(key string)
+}
+
 // IssueToken returns a 32-byte hex random token for invites.
 func IssueToken() string {
 	b := make([]byte, 32)
@@ -108,6 +124,8 @@ type Service struct {
 	hasher        AuthHasher
 	tokens        TokenIssuer
 	audit         audit.Recorder
+	mailer        Mailer
+	limiter       Limiter
 	publicBaseURL string
 	now           func() time.Time
 }
@@ -157,6 +175,22 @@ func NewService(d Deps) *Service {
 	}
 }
 
+// SetMailer makes Create email the invite link to EmailHint when it is
+// an address. Needs PublicBaseURL; without one there is no link.
+func (s *Service) SetMailer(m Mailer) { s.mailer = m }
+
+// SetLimiter throttles invite acceptance per client IP, so tokens
+// cannot be guessed at speed.
+func (s *Service) SetLimiter(l Limiter) { s.limiter = l }
+
+// throttle spends one of ip's accept attempts.
+func (s *Service) throttle(ip string) error {
+	if s.limiter != nil && !s.limiter.Allow(ip) {
+		return fmt.Errorf("invites: accept throttled: %w", domain.ErrRateLimited)
+	}
+	return nil
+}
+
 type CreateInput struct {
 	VaultID   uuid.UUID
 	RoleID    uuid.UUID
@@ -203,14 +237,45 @@ func (s *Service) Create(ctx context.Context, in CreateInput) (domain.Invite, er
 	if err := s.repo.Create(ctx, inv); err != nil {
 		return domain.Invite{}, fmt.Errorf("persist invite: %w", err)
 	}
+	emailed := s.mailInvite(ctx, inv, role)
 	s.recordAudit(ctx, domain.ActionInviteCreate, &in.InviterID, &in.VaultID, in.IP, in.UA, map[string]any{
 		"role_id":    in.RoleID.String(),
 		"max_uses":   in.MaxUses,
 		"expires_at": inv.ExpiresAt,
+		"emailed":    emailed,
 	})
 	return inv, nil
 }
 
+// mailInvite queues the invite email and reports whether it did. A
+// failure only shows as emailed=false in the audit entry; the invite
+// itself stands and its link can still be shared by hand.
+func (s *Service) mailInvite(ctx context.Context, inv domain.Invite, role domain.Role) bool {
+	to, err := mail.ParseAddress(inv.EmailHint)
+	if s.mailer == nil || s.publicBaseURL == "" || err != nil {
+		return false
+	}
+	vault, err := s.vaults.GetByID(ctx, inv.VaultID)
+	if err != nil {
+		return false
+	}
+	inviter := "Someone"
+	if u, err := s.users.GetByID(ctx, inv.InviterUserID); err == nil {
+		inviter = u.Username
+		if u.DisplayName != "" {
+			inviter = u.DisplayName
+		}
+	}
+	err = s.mailer.Enqueue(ctx, to, mail.TemplateInvite, mail.InviteData{
+		VaultName:   vault.Name,
+		RoleName:    role.Name,
+		InviterName: inviter,
+		URL:         s.PublicURL(inv.Token),
+		ExpiresAt:   inv.ExpiresAt,
+	})
+	return err == nil
+}
+
 type InviteInfo struct {
 	VaultID       uuid.UUID
 	VaultName     string
@@ -278,6 +343,9 @@ type AcceptSignupInput struct {
 // modes are handled by per-step error checks; partial state can be
 // reconciled via background cleanup.
 func (s *Service) AcceptWithSignup(ctx context.Context, in AcceptSignupInput) (domain.Session, error) {
+	if err := s.throttle(in.IP); err != nil {
+		return domain.Session{}, err
+	}
 	if err := s.validateSignup(in); err != nil {
 		return domain.Session{}, err
 	}
@@ -320,8 +388,11 @@ func (s *Service) AcceptWithSignup(ctx context.Context, in AcceptSignupInput) (d
 		return domain.Session{}, fmt.Errorf("add member: %w", err)
 	}
 	session := domain.Session{
+		ID:         uuid.New(),
 		Token:      s.tokens.NewSessionToken(),
 		UserID:     user.ID,
+		IP:         in.IP,
+		UserAgent:  in.UA,
 		CreatedAt:  now,
 		ExpiresAt:  now.Add(SessionTTL),
 		LastUsedAt: now,
@@ -347,6 +418,9 @@ func (s *Service) AcceptAsExistingUser(ctx context.Context, in AcceptExistingInp
 	if in.Token == "" || in.UserID == uuid.Nil {
 		return fmt.Errorf("%w: token + user_id required", domain.ErrValidation)
 	}
+	if err := s.throttle(in.IP); err != nil {
+		return err
+	}
 	pre, err := s.repo.Get(ctx, in.Token)
 	if err != nil {
 		return err
@@ -430,6 +504,9 @@ func (s *Service) validateSignup(in AcceptSignupInput) error {
 	if len(in.Password) < MinPasswordLen {
 		return fmt.Errorf("%w: password too short", domain.ErrValidation)
 	}
+	if err := s.hasher.ValidatePassword(in.Password); err != nil {
+		return err
+	}
 	if in.Consent.TosVersion == "" || in.Consent.PrivacyVersion == "" {
 		return domain.ErrConsentRequired
 	}
@@ -722,6 +799,8 @@ func statusFromErr(c *fiber.Ctx, err error) error {
 		return c.Status(http.StatusForbidden).JSON(fiber.Map{"error": "forbidden"})
 	case errors.Is(err, domain.ErrNotFound):
 		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
+	case errors.Is(err, domain.ErrRateLimited):
+		return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "rate_limited"})
 	default:
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal"})
 	}
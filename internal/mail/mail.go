@@ -0,0 +1,87 @@
+// Package mail delivers the server's email: vault invites, password
+// reset links and security alerts. Messages are rendered from built-in
+// templates and written to a Postgres outbox first; a worker then hands
+// them to the configured Sender (SMTP, or a log or file sink for
+// development) and retries failures with exponential backoff, so a mail
+// server that is down delays mail instead of failing the request that
+// caused it.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAddress is returned for a recipient that is not a single
+// bare address.
+var ErrInvalidAddress = errors.New("mail: invalid address")
+
+// Message is one rendered email with a plain-text body.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender hands a message to a transport.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// ParseAddress accepts a bare address ("ana@example.com", no display
+// name) and returns it lower-cased.
+func ParseAddress(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	a, err := netmail.ParseAddress(raw)
+	if err != nil || a.Name != "" || a.Address != raw {
+		return "", fmt.Errorf("%w: %q", ErrInvalidAddress, raw)
+	}
+	return strings.ToLower(a.Address), nil
+}
+
+// Format renders m as an RFC 5322 message from the given sender.
+func Format(from string, m Message, at time.Time) ([]byte, error) {
+	if strings.ContainsAny(m.To+m.Subject+from, "\r\n") {
+		return nil, fmt.Errorf("%w: header contains a line break", ErrInvalidAddress)
+	}
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+	header("From", from)
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", at.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(m.Body)); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+	if a, err := netmail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(a.Address, "@"); ok {
+			domain = d
+		}
+	}
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
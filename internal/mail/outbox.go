@@ -0,0 +1,175 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// Delivery tunables. KeepDelivered is how long the metadata of a sent
+// or abandoned message is kept; its body is cleared when it leaves the
+// queue.
+var (
+	PollInterval  = 15 * time.Second
+	BatchSize     = 20
+	MaxAttempts   = 8
+	BaseBackoff   = 30 * time.Second
+	MaxBackoff    = 2 * time.Hour
+	SendTimeout   = 30 * time.Second
+	ClaimLease    = 5 * time.Minute
+	KeepDelivered = 7 * 24 * time.Hour
+)
+
+// Store persists the outbox. Implemented by pg.MailOutboxStore.
+type Store interface {
+	EnqueueMail(ctx context.Context, m domain.OutboxMail) error
+	ClaimMail(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.OutboxMail, error)
+	MarkMailSent(ctx context.Context, id uuid.UUID, at time.Time) error
+	RetryMail(ctx context.Context, id uuid.UUID, next time.Time, lastErr string) error
+	FailMail(ctx context.Context, id uuid.UUID, at time.Time, lastErr string) error
+	PurgeMail(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Outbox queues rendered messages and delivers them in the background.
+// Every replica may run the worker; claims keep them from sending the
+// same message twice.
+type Outbox struct {
+	store  Store
+	sender Sender
+	log    zerolog.Logger
+	now    func() time.Time
+	wake   chan struct{}
+}
+
+func NewOutbox(store Store, sender Sender, log zerolog.Logger) *Outbox {
+	if store == nil || sender == nil {
+		panic("mail.NewOutbox: store and sender are required")
+	}
+	return &Outbox{
+		store:  store,
+		sender: sender,
+		log:    log,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Enqueue renders the named template for to and queues the result. It
+// returns once the message is stored; delivery happens in Run.
+func (o *Outbox) Enqueue(ctx context.Context, to, template string, data any) error {
+	addr, err := ParseAddress(to)
+	if err != nil {
+		return err
+	}
+	subject, body, err := Render(template, data)
+	if err != nil {
+		return err
+	}
+	now := o.now().UTC()
+	err = o.store.EnqueueMail(ctx, domain.OutboxMail{
+		ID:            uuid.New(),
+		Recipient:     addr,
+		Subject:       subject,
+		Body:          body,
+		Template:      template,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return fmt.Errorf("mail: enqueue %s: %w", template, err)
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers queued mail until ctx is cancelled: on every
+// PollInterval, and right after Enqueue on this replica.
+func (o *Outbox) Run(ctx context.Context) {
+	tick := time.NewTicker(PollInterval)
+	defer tick.Stop()
+	lastPurge := time.Time{}
+	for {
+		if _, err := o.Flush(ctx); err != nil && ctx.Err() == nil {
+			o.log.Warn().Err(err).Msg("mail: flush outbox")
+		}
+		if now := o.now(); now.Sub(lastPurge) > time.Hour {
+			lastPurge = now
+			if n, err := o.store.PurgeMail(ctx, now.Add(-KeepDelivered)); err != nil && ctx.Err() == nil {
+				o.log.Warn().Err(err).Msg("mail: purge outbox")
+			} else if n > 0 {
+				o.log.Debug().Int64("rows", n).Msg("mail: purged outbox")
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Flush attempts every message due now and reports how many were sent.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+	sent := 0
+	for {
+		batch, err := o.store.ClaimMail(ctx, o.now().UTC(), ClaimLease, BatchSize)
+		if err != nil {
+			return sent, err
+		}
+		for _, m := range batch {
+			if o.deliver(ctx, m) {
+				sent++
+			}
+		}
+		if len(batch) < BatchSize {
+			return sent, nil
+		}
+	}
+}
+
+// deliver sends one claimed message and records the outcome.
+func (o *Outbox) deliver(ctx context.Context, m domain.OutboxMail) bool {
+	sendCtx, cancel := context.WithTimeout(ctx, SendTimeout)
+	err := o.sender.Send(sendCtx, Message{To: m.Recipient, Subject: m.Subject, Body: m.Body})
+	cancel()
+	now := o.now().UTC()
+	log := o.log.With().Stringer("mail_id", m.ID).Str("template", m.Template).Int("attempt", m.Attempts).Logger()
+	if err == nil {
+		if err := o.store.MarkMailSent(ctx, m.ID, now); err != nil {
+			log.Warn().Err(err).Msg("mail: sent but not marked; it may be sent again")
+		}
+		return true
+	}
+	if m.Attempts >= MaxAttempts {
+		log.Error().Err(err).Msg("mail: giving up")
+		if err2 := o.store.FailMail(ctx, m.ID, now, err.Error()); err2 != nil {
+			log.Warn().Err(err2).Msg("mail: record give-up")
+		}
+		return false
+	}
+	next := now.Add(Backoff(m.Attempts))
+	log.Warn().Err(err).Time("next_attempt_at", next).Msg("mail: send failed; will retry")
+	if err2 := o.store.RetryMail(ctx, m.ID, next, err.Error()); err2 != nil {
+		log.Warn().Err(err2).Msg("mail: record retry")
+	}
+	return false
+}
+
+// Backoff is the wait after the given (1-based) failed attempt:
+// BaseBackoff doubling each time, capped at MaxBackoff.
+func Backoff(attempt int) time.Duration {
+	d := BaseBackoff
+	for i := 1; i < attempt && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, MaxBackoff)
+}
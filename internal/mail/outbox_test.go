@@ -0,0 +1,150 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// memStore is an in-memory Store with the pg claim semantics.
+type memStore struct {
+	rows map[uuid.UUID]*domain.OutboxMail
+}
+
+func (s *memStore) EnqueueMail(_ context.Context, m domain.OutboxMail) error {
+	s.rows[m.ID] = &m
+	return nil
+}
+func (s *memStore) ClaimMail(_ context.Context, now time.Time, lease time.Duration, limit int) ([]domain.OutboxMail, error) {
+	var out []domain.OutboxMail
+	for _, m := range s.rows {
+		if len(out) == limit {
+			break
+		}
+		if m.SentAt == nil && m.FailedAt == nil && !m.NextAttemptAt.After(now) {
+			m.Attempts++
+			m.NextAttemptAt = now.Add(lease)
+			out = append(out, *m)
+		}
+	}
+	return out, nil
+}
+func (s *memStore) MarkMailSent(_ context.Context, id uuid.UUID, at time.Time) error {
+	s.rows[id].SentAt = &at
+	return nil
+}
+func (s *memStore) RetryMail(_ context.Context, id uuid.UUID, next time.Time, lastErr string) error {
+	s.rows[id].NextAttemptAt, s.rows[id].LastError = next, lastErr
+	return nil
+}
+func (s *memStore) FailMail(_ context.Context, id uuid.UUID, at time.Time, lastErr string) error {
+	s.rows[id].FailedAt, s.rows[id].LastError = &at, lastErr
+	return nil
+}
+func (s *memStore) PurgeMail(context.Context, time.Time) (int64, error) { return 0, nil }
+
+// flakySender fails its first `failures` sends, then succeeds.
+type flakySender struct {
+	failures int
+	sent     []Message
+}
+
+func (f *flakySender) Send(_ context.Context, m Message) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("relay unavailable")
+	}
+	f.sent = append(f.sent, m)
+	return nil
+}
+
+func newTestOutbox(sender Sender) (*Outbox, *memStore, *time.Time) {
+	store := &memStore{rows: map[uuid.UUID]*domain.OutboxMail{}}
+	clock := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	o := NewOutbox(store, sender, zerolog.Nop())
+	o.now = func() time.Time { return clock }
+	return o, store, &clock
+}
+
+func TestOutboxRetriesWithBackoff(t *testing.T) {
+	sender := &flakySender{failures: 2}
+	o, store, clock := newTestOutbox(sender)
+	ctx := context.Background()
+	err := o.Enqueue(ctx, "ana@example.com", TemplateNewLogin, NewLoginData{Username: "ana", At: *clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := o.Flush(ctx); n != 0 {
+		t.Fatalf("first flush sent %d", n)
+	}
+	var row *domain.OutboxMail
+	for _, r := range store.rows {
+		row = r
+	}
+	if want := clock.Add(BaseBackoff); !row.NextAttemptAt.Equal(want) || row.LastError == "" {
+		t.Fatalf("after one failure: next %v (want %v), last error %q", row.NextAttemptAt, want, row.LastError)
+	}
+	if n, _ := o.Flush(ctx); n != 0 {
+		t.Fatal("flush before the retry was due sent mail")
+	}
+
+	*clock = clock.Add(BaseBackoff)
+	o.Flush(ctx)
+	if want := clock.Add(2 * BaseBackoff); !row.NextAttemptAt.Equal(want) {
+		t.Fatalf("after two failures: next %v, want %v", row.NextAttemptAt, want)
+	}
+	*clock = clock.Add(2 * BaseBackoff)
+	if n, _ := o.Flush(ctx); n != 1 || row.SentAt == nil || row.Attempts != 3 {
+		t.Fatalf("third attempt: sent %d, row %+v", n, row)
+	}
+	if len(sender.sent) != 1 || sender.sent[0].Subject != "New sign-in to your lumi account" {
+		t.Fatalf("sent = %+v", sender.sent)
+	}
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	o, store, clock := newTestOutbox(&flakySender{failures: MaxAttempts})
+	ctx := context.Background()
+	if err := o.Enqueue(ctx, "ana@example.com", TemplateEmailChanged, EmailChangedData{Username: "ana"}); err != nil {
+		t.Fatal(err)
+	}
+	for range MaxAttempts {
+		o.Flush(ctx)
+		*clock = clock.Add(MaxBackoff)
+	}
+	for _, r := range store.rows {
+		if r.FailedAt == nil || r.SentAt != nil || r.Attempts != MaxAttempts {
+			t.Fatalf("row = %+v, want abandoned after %d attempts", r, MaxAttempts)
+		}
+	}
+}
+
+func TestEnqueueRejectsBadInput(t *testing.T) {
+	o, store, _ := newTestOutbox(&flakySender{})
+	ctx := context.Background()
+	if err := o.Enqueue(ctx, "not an address", TemplateNewLogin, NewLoginData{}); !errors.Is(err, ErrInvalidAddress) {
+		t.Fatalf("bad address err = %v", err)
+	}
+	if err := o.Enqueue(ctx, "ana@example.com", "newsletter", nil); err == nil {
+		t.Fatal("unknown template accepted")
+	}
+	if len(store.rows) != 0 {
+		t.Fatalf("rejected mail was queued: %d rows", len(store.rows))
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	if Backoff(1) != BaseBackoff || Backoff(3) != 4*BaseBackoff {
+		t.Fatalf("Backoff(1)=%v Backoff(3)=%v", Backoff(1), Backoff(3))
+	}
+	if Backoff(100) != MaxBackoff {
+		t.Fatalf("Backoff(100) = %v", Backoff(100))
+	}
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LogSender writes every message to the log instead of sending it, so
+// links can be copied out during development.
+type LogSender struct {
+	Log zerolog.Logger
+}
+
+func (s LogSender) Send(_ context.Context, m Message) error {
+	s.Log.Info().Str("to", m.To).Str("subject", m.Subject).Str("body", m.Body).Msg("mail: not sent (log transport)")
+	return nil
+}
+
+// FileSender writes every message as an .eml file into Dir.
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (s FileSender) Send(_ context.Context, m Message) error {
+	now := time.Now()
+	msg, err := Format(s.From, m, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return fmt.Errorf("mail: file transport: %w", err)
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(b))
+	if err := os.WriteFile(filepath.Join(s.Dir, name), msg, 0o600); err != nil {
+		return fmt.Errorf("mail: file transport: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP transport security.
+const (
+	TLSStartTLS = "starttls" // plain connection upgraded with STARTTLS; required
+	TLSImplicit = "tls"      // TLS from the first byte (usually port 465)
+	TLSNone     = "none"     // plain text; only for a relay on a trusted network
+)
+
+// SMTPConfig names the relay to submit mail to.
+type SMTPConfig struct {
+	Addr     string // host:port
+	Username string // empty skips AUTH
+	Password string
+	From     string
+	TLS      string // TLSStartTLS (default), TLSImplicit or TLSNone
+}
+
+// SMTPSender submits each message to a relay over a new connection.
+type SMTPSender struct {
+	cfg  SMTPConfig
+	host string
+	tls  *tls.Config
+}
+
+func NewSMTPSender(cfg SMTPConfig) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("mail: smtp address %q: %w", cfg.Addr, err)
+	}
+	if cfg.TLS == "" {
+		cfg.TLS = TLSStartTLS
+	}
+	switch cfg.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("mail: smtp tls mode %q", cfg.TLS)
+	}
+	if _, err := ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("mail: from address: %w", err)
+	}
+	return &SMTPSender{cfg: cfg, host: host, tls: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}, nil
+}
+
+func (s *SMTPSender) Send(ctx context.Context, m Message) error {
+	msg, err := Format(s.cfg.From, m, time.Now())
+	if err != nil {
+		return err
+	}
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("mail: smtp dial: %w", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(SendTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return fmt.Errorf("mail: smtp greeting: %w", err)
+	}
+	defer c.Close()
+	if s.cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("mail: smtp server does not offer STARTTLS")
+		}
+		if err := c.StartTLS(s.tls); err != nil {
+			return fmt.Errorf("mail: smtp starttls: %w", err)
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.host)); err != nil {
+			return fmt.Errorf("mail: smtp auth: %w", err)
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return fmt.Errorf("mail: smtp MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return fmt.Errorf("mail: smtp RCPT TO: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mail: smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("mail: smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mail: smtp end of data: %w", err)
+	}
+	return c.Quit()
+}
+
+func (s *SMTPSender) dial(ctx context.Context) (net.Conn, error) {
+	if s.cfg.TLS == TLSImplicit {
+		d := &tls.Dialer{Config: s.tls}
+		return d.DialContext(ctx, "tcp", s.cfg.Addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", s.cfg.Addr)
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"context"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a one-connection SMTP server good enough for net/smtp:
+// it accepts one message, or answers RCPT with rcptReply when set.
+type smtpStandIn struct {
+	addr      string
+	rcptReply string
+	got       chan string
+}
+
+func startSMTP(t *testing.T, rcptReply string) *smtpStandIn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpStandIn{addr: ln.Addr().String(), rcptReply: rcptReply, got: make(chan string, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.serve(textproto.NewConn(conn))
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(tp *textproto.Conn) {
+	_ = tp.PrintfLine("220 localhost ESMTP stand-in")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch verb {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250-localhost\r\n250 8BITMIME")
+		case "MAIL":
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			if s.rcptReply != "" {
+				_ = tp.PrintfLine("%s", s.rcptReply)
+				continue
+			}
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			body, err := io.ReadAll(tp.DotReader())
+			if err != nil {
+				return
+			}
+			s.got <- string(body)
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSMTPSenderDelivers(t *testing.T) {
+	srv := startSMTP(t, "")
+	sender, err := NewSMTPSender(SMTPConfig{Addr: srv.addr, From: "lumi@example.com", TLS: TLSNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, body, err := Render(TemplatePasswordReset, PasswordResetData{
+		Username:  "ana",
+		URL:       "https://notes.example.com/reset-password#token=abc",
+		ExpiresAt: time.Date(2030, 1, 2, 3, 4, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sender.Send(ctx, Message{To: "ana@example.com", Subject: subject, Body: body}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	raw := <-srv.got // the dot reader has turned CRLF into LF
+	head, encoded, ok := strings.Cut(raw, "\n\n")
+	if !ok {
+		t.Fatalf("no header/body split in %q", raw)
+	}
+	for _, want := range []string{"From: lumi@example.com", "To: ana@example.com", "Subject: Reset your lumi password"} {
+		if !strings.Contains(head, want) {
+			t.Errorf("headers missing %q:\n%s", want, head)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(decoded), "#token=abc") || !strings.Contains(string(decoded), "2030-01-02 03:04 UTC") {
+		t.Fatalf("body = %q", decoded)
+	}
+}
+
+func TestSMTPSenderReportsRejection(t *testing.T) {
+	srv := startSMTP(t, "450 mailbox busy")
+	sender, err := NewSMTPSender(SMTPConfig{Addr: srv.addr, From: "lumi@example.com", TLS: TLSNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sender.Send(context.Background(), Message{To: "ana@example.com", Subject: "s", Body: "b"})
+	if err == nil || !strings.Contains(err.Error(), "mailbox busy") {
+		t.Fatalf("Send err = %v, want the relay's rejection", err)
+	}
+}
+
+func TestStartTLSIsRequiredByDefault(t *testing.T) {
+	srv := startSMTP(t, "")
+	sender, err := NewSMTPSender(SMTPConfig{Addr: srv.addr, From: "lumi@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Send(context.Background(), Message{To: "ana@example.com", Subject: "s", Body: "b"}); err == nil {
+		t.Fatal("sent in plain text to a relay without STARTTLS")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	if got, err := ParseAddress(" Ana@Example.com "); err != nil || got != "ana@example.com" {
+		t.Fatalf("ParseAddress = %q, %v", got, err)
+	}
+	for _, bad := range []string{"", "ana", "Ana <ana@example.com>", "a@b.com, c@d.com", "ana@example.com\r\nBcc: x@y.z"} {
+		if _, err := ParseAddress(bad); err == nil {
+			t.Errorf("ParseAddress(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// Template names accepted by Outbox.Enqueue, each with its data type.
+const (
+	TemplateInvite        = "invite"         // InviteData
+	TemplatePasswordReset = "password_reset" // PasswordResetData
+	TemplateNewLogin      = "new_login"      // NewLoginData
+	TemplateEmailChanged  = "email_changed"  // EmailChangedData
+	TemplateLoginFailures = "login_failures" // LoginFailuresData
+	TemplateLoginCode     = "login_code"     // LoginCodeData
+)
+
+// InviteData fills TemplateInvite.
+type InviteData struct {
+	VaultName   string
+	RoleName    string
+	InviterName string
+	URL         string
+	ExpiresAt   time.Time
+}
+
+// PasswordResetData fills TemplatePasswordReset.
+type PasswordResetData struct {
+	Username  string
+	URL       string
+	ExpiresAt time.Time
+}
+
+// NewLoginData fills TemplateNewLogin. Reasons holds the
+// domain.Security* kinds that made the login stand out; without any it
+// is reported as a login from a new device.
+type NewLoginData struct {
+	Username  string
+	IP        string
+	UserAgent string
+	At        time.Time
+	Reasons   []string
+}
+
+// EmailChangedData fills TemplateEmailChanged.
+type EmailChangedData struct {
+	Username string
+	NewEmail string
+	At       time.Time
+}
+
+// LoginFailuresData fills TemplateLoginFailures.
+type LoginFailuresData struct {
+	Username string
+	Count    int
+	Minutes  int
+	IP       string
+	At       time.Time
+}
+
+// LoginCodeData fills TemplateLoginCode.
+type LoginCodeData struct {
+	Username  string
+	Code      string
+	IP        string
+	UserAgent string
+	ExpiresAt time.Time
+}
+
+const timeLayout = "2006-01-02 15:04 MST"
+
+var templates = map[string]struct{ subject, body string }{
+	TemplateInvite: {
+		subject: `{{.InviterName}} invited you to {{.VaultName}} on lumi`,
+		body: `{{.InviterName}} invited you to join the vault "{{.VaultName}}"{{if .RoleName}} as {{.RoleName}}{{end}}.
+
+Accept the invitation here:
+
+  {{.URL}}
+
+The link expires on {{utc .ExpiresAt}}. If you were not expecting it,
+you can ignore this message.
+`,
+	},
+	TemplatePasswordReset: {
+		subject: `Reset your lumi password`,
+		body: `Someone asked to reset the password of the lumi account "{{.Username}}".
+Set a new password here:
+
+  {{.URL}}
+
+The link works once and expires on {{utc .ExpiresAt}}. If you did not
+ask for it, ignore this message; your password stays as it is.
+`,
+	},
+	TemplateNewLogin: {
+		subject: `New sign-in to your lumi account`,
+		body: `Your lumi account "{{.Username}}" was signed in to{{if .Reasons}} in a way that stood out:
+{{range .Reasons}}
+  - {{reason .}}{{end}}
+{{else}} from a new device.
+{{end}}
+  When:    {{utc .At}}
+  Address: {{or .IP "unknown"}}
+  Device:  {{or .UserAgent "unknown"}}
+
+If this was you, there is nothing to do. Otherwise change your password
+and sign out the sessions you do not recognise.
+`,
+	},
+	TemplateEmailChanged: {
+		subject: `Your lumi email address was changed`,
+		body: `The email address of the lumi account "{{.Username}}" was changed
+{{if .NewEmail}}to {{.NewEmail}}{{else}}and removed{{end}} on {{utc .At}}.
+
+This address will no longer receive password reset links or alerts.
+If you did not make this change, contact your server administrator.
+`,
+	},
+	TemplateLoginFailures: {
+		subject: `Failed sign-ins to your lumi account`,
+		body: `There were {{.Count}} failed attempts to sign in to the lumi account
+"{{.Username}}" in the last {{.Minutes}} minutes, the latest from {{or .IP "an unknown address"}}.
+
+Further attempts are being slowed down. If these were not you, make
+sure your password is not used anywhere else, and consider turning on
+two-factor authentication.
+`,
+	},
+	TemplateLoginCode: {
+		subject: `Your lumi sign-in code`,
+		body: `A sign-in to the lumi account "{{.Username}}" looked unusual, so it
+needs this code to complete:
+
+  {{.Code}}
+
+  Address: {{or .IP "unknown"}}
+  Device:  {{or .UserAgent "unknown"}}
+
+The code expires on {{utc .ExpiresAt}}. If you are not signing in, do not
+share it with anyone, and change your password: whoever is signing in
+knows it.
+`,
+	},
+}
+
+// reasons words the domain.Security* kinds for NewLoginData.Reasons.
+var reasons = map[string]string{
+	domain.SecurityNewDevice:        "from a device the account has not used before",
+	domain.SecurityNewNetwork:       "from a network the account has not used before",
+	domain.SecurityImpossibleTravel: "from too far away to have travelled since the previous sign-in",
+	domain.SecurityAfterFailures:    "right after several failed password attempts",
+}
+
+func reason(kind string) string {
+	if r, ok := reasons[kind]; ok {
+		return r
+	}
+	return kind
+}
+
+var parsed = func() map[string][2]*template.Template {
+	funcs := template.FuncMap{
+		"utc":    func(t time.Time) string { return t.UTC().Format(timeLayout) },
+		"reason": reason,
+	}
+	out := make(map[string][2]*template.Template, len(templates))
+	for name, t := range templates {
+		out[name] = [2]*template.Template{
+			template.Must(template.New(name + ".subject").Funcs(funcs).Parse(t.subject)),
+			template.Must(template.New(name + ".body").Funcs(funcs).Parse(t.body)),
+		}
+	}
+	return out
+}()
+
+// Render fills the named template.
+func Render(name string, data any) (subject, body string, err error) {
+	t, ok := parsed[name]
+	if !ok {
+		return "", "", fmt.Errorf("mail: unknown template %q", name)
+	}
+	var sb, bb strings.Builder
+	if err := t[0].Execute(&sb, data); err != nil {
+		return "", "", fmt.Errorf("mail: render %s subject: %w", name, err)
+	}
+	if err := t[1].Execute(&bb, data); err != nil {
+		return "", "", fmt.Errorf("mail: render %s body: %w", name, err)
+	}
+	// A subject is one header line whatever the data held.
+	subject = strings.Join(strings.Fields(sb.String()), " ")
+	return subject, bb.String(), nil
+}
@@ -292,6 +292,7 @@ type memberDTO struct {
 	Capabilities domain.CapabilitySet `json:"capabilities"`
 	IsSeedRole   bool                 `json:"is_seed_role"`
 	JoinedAt     string               `json:"joined_at"`
+	Suspended    bool                 `json:"suspended,omitempty"`
 }
 
 func toMemberDTO(m MemberJoined) memberDTO {
@@ -309,6 +310,7 @@ func toMemberDTO(m MemberJoined) memberDTO {
 		Capabilities: caps,
 		IsSeedRole:   m.Role.IsSeed,
 		JoinedAt:     m.Member.JoinedAt.UTC().Format("2006-01-02T15:04:05Z07:00"),
+		Suspended:    m.User.SuspendedAt != nil,
 	}
 }
 
@@ -324,8 +326,14 @@ func (h *Handlers) list(c *fiber.Ctx) error {
 	if err != nil {
 		return mapError(c, err)
 	}
+	// Suspended accounts stay members but drop out of pickers unless
+	// the caller asks for them (e.g. to manage or remove them).
+	withSuspended := c.QueryBool("include_suspended")
 	out := make([]memberDTO, 0, len(rows))
 	for _, r := range rows {
+		if r.User.SuspendedAt != nil && !withSuspended {
+			continue
+		}
 		out = append(out, toMemberDTO(r))
 	}
 	return c.Status(http.StatusOK).JSON(fiber.Map{"members": out})
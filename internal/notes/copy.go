@@ -22,6 +22,14 @@ const copyPageSize = 200
 // Tolerant of a missing source file (the mirrored content may not have
 // landed yet) and idempotent when the note already matches.
 func (s *Service) MoveFromFederation(ctx context.Context, vaultID uuid.UUID, id, newPath, newTitle string) error {
+	return s.moveInternal(ctx, vaultID, id, newPath, newTitle, originFederation)
+}
+
+// moveInternal updates the row (and, for federation, the file) of a
+// move that did not come through the REST API. FS moves have already
+// happened on disk and are announced to federated peers; federation
+// moves are not (the relay fans them out itself).
+func (s *Service) moveInternal(ctx context.Context, vaultID uuid.UUID, id, newPath, newTitle, origin string) error {
 	cleaned, err := validateNoteRelPath(newPath)
 	if err != nil {
 		return err
@@ -45,10 +53,12 @@ func (s *Service) MoveFromFederation(ctx context.Context, vaultID uuid.UUID, id,
 		if other, err := s.notes.GetByPath(ctx, vaultID, cleaned); err == nil && other.ID != id {
 			return fmt.Errorf("%w: path %q already exists", domain.ErrConflict, cleaned)
 		}
-		s.suppressFSEvent(v.Slug, n.Path)
-		s.suppressFSEvent(v.Slug, cleaned)
-		if err := s.fs.MoveNote(v.Slug, n.Path, cleaned); err != nil && !errors.Is(err, domain.ErrNotFound) {
-			return err
+		if origin != originFS {
+			s.suppressFSEvent(v.Slug, n.Path)
+			s.suppressFSEvent(v.Slug, cleaned)
+			if err := s.fs.MoveNote(v.Slug, n.Path, cleaned); err != nil && !errors.Is(err, domain.ErrNotFound) {
+				return err
+			}
 		}
 	}
 	updated := n
@@ -58,11 +68,14 @@ func (s *Service) MoveFromFederation(ctx context.Context, vaultID uuid.UUID, id,
 	if err := s.notes.Upsert(ctx, updated); err != nil {
 		return err
 	}
+	if origin != originFederation && s.fedNotify != nil {
+		s.fedNotify.NoteMoved(vaultID, id, cleaned, title)
+	}
 	s.recordAudit(ctx, uuid.Nil, vaultID, domain.ActionNoteMove, "", "", map[string]any{
 		"note_id":  id,
 		"old_path": n.Path,
 		"new_path": cleaned,
-		"origin":   "federation",
+		"origin":   origin,
 	})
 	return nil
 }
@@ -16,6 +16,12 @@ type fakeNoteRepo struct {
 }
 
 func (f *fakeNoteRepo) Upsert(_ context.Context, n domain.Note) error {
+	for i, cur := range f.byVault[n.VaultID] {
+		if cur.ID == n.ID {
+			f.byVault[n.VaultID][i] = n
+			return nil
+		}
+	}
 	f.byVault[n.VaultID] = append(f.byVault[n.VaultID], n)
 	return nil
 }
@@ -44,13 +50,22 @@ func (f *fakeNoteRepo) ListForVault(_ context.Context, vaultID uuid.UUID, limit,
 		return nil, nil
 	}
 	end := offset + limit
-	if end > len(all) {
+	if limit <= 0 || end > len(all) {
 		end = len(all)
 	}
 	return all[offset:end], nil
 }
 
-func (f *fakeNoteRepo) Delete(_ context.Context, vaultID uuid.UUID, id string) error { return nil }
+func (f *fakeNoteRepo) Delete(_ context.Context, vaultID uuid.UUID, id string) error {
+	rows := f.byVault[vaultID]
+	for i, n := range rows {
+		if n.ID == id {
+			f.byVault[vaultID] = append(rows[:i:i], rows[i+1:]...)
+			return nil
+		}
+	}
+	return domain.ErrNotFound
+}
 
 type fakeVaultLookup struct {
 	byID map[uuid.UUID]domain.Vault
@@ -0,0 +1,318 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/capguard"
+	"github.com/ViniZap4/lumi-server/internal/crdt"
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Vault fsck ------------------------------------------------------------
+
+// A note lives in three places: the pg metadata row, the markdown file,
+// and the CRDT snapshot + update log. Write paths keep them in step on a
+// best-effort basis (ApplyDiff persists the CRDT change before the FS
+// mirror and surfaces, but does not undo, a failed mirror), so they can
+// drift. Fsck cross-checks all three for one vault and optionally
+// repairs what it finds.
+//
+// CRDT rows cannot outlive their pg row (note_yjs_* cascade on delete),
+// so "orphans" are only ever files without a row or rows without a
+// file or CRDT state.
+
+// FsckRepair selects which side wins when Fsck repairs a finding.
+type FsckRepair string
+
+const (
+	// FsckReportOnly checks without writing anything.
+	FsckReportOnly FsckRepair = "none"
+	// FsckCRDTToFS treats the CRDT as authoritative: drifted and missing
+	// files are rewritten from the document.
+	FsckCRDTToFS FsckRepair = "crdt-to-fs"
+	// FsckFSToCRDT treats the markdown files as authoritative: drifted
+	// documents take the file body and unknown files are imported.
+	FsckFSToCRDT FsckRepair = "fs-to-crdt"
+)
+
+// ParseFsckRepair validates a repair direction taken from a flag or a
+// query string. "" means report only.
+func ParseFsckRepair(s string) (FsckRepair, error) {
+	switch r := FsckRepair(strings.TrimSpace(s)); r {
+	case "", FsckReportOnly:
+		return FsckReportOnly, nil
+	case FsckCRDTToFS, FsckFSToCRDT:
+		return r, nil
+	}
+	return "", fmt.Errorf("%w: repair must be %q or %q", domain.ErrValidation, FsckCRDTToFS, FsckFSToCRDT)
+}
+
+// Finding kinds reported by Fsck.
+const (
+	FsckDrift       = "drift"        // CRDT body differs from the file body
+	FsckMissingFile = "missing_file" // pg row whose file is gone
+	FsckOrphanFile  = "orphan_file"  // markdown file with no pg row
+	FsckMissingCRDT = "missing_crdt" // row + file, but no snapshot or update log
+	FsckCRDTError   = "crdt_error"   // persisted CRDT state does not load
+	FsckReadError   = "read_error"   // file exists but cannot be read or parsed
+)
+
+// fsckOrigin tags update-log rows written by repairs.
+const fsckOrigin = "fsck"
+
+// FsckFinding is one inconsistency. RepairError is set when a repair was
+// attempted and failed; a finding the chosen direction cannot fix is
+// simply left with Repaired == false.
+type FsckFinding struct {
+	Kind        string `json:"kind"`
+	NoteID      string `json:"note_id,omitempty"`
+	Path        string `json:"path"`
+	Detail      string `json:"detail,omitempty"`
+	Repaired    bool   `json:"repaired"`
+	RepairError string `json:"repair_error,omitempty"`
+}
+
+// FsckReport is the machine-readable result of one vault check.
+type FsckReport struct {
+	VaultID  uuid.UUID     `json:"vault_id"`
+	Slug     string        `json:"slug"`
+	Repair   FsckRepair    `json:"repair"`
+	Notes    int           `json:"notes"`
+	Files    int           `json:"files"`
+	Findings []FsckFinding `json:"findings"`
+	Repaired int           `json:"repaired"`
+}
+
+// Clean reports whether nothing is left to fix.
+func (r FsckReport) Clean() bool { return r.Repaired == len(r.Findings) }
+
+func (r *FsckReport) add(f FsckFinding) {
+	r.Findings = append(r.Findings, f)
+	if f.Repaired {
+		r.Repaired++
+	}
+}
+
+// Fsck checks every note row, markdown file and CRDT document of the
+// vault against each other and, unless repair is FsckReportOnly, fixes
+// what the chosen direction allows:
+//
+//   - drift: crdt-to-fs rewrites the file body from the document;
+//     fs-to-crdt diffs the file body into the document (plain-text notes
+//     only — a structured note's prosemirror fragment always wins).
+//   - missing_file: crdt-to-fs recreates the file from the document.
+//   - missing_crdt: either direction seeds the CRDT from the file, the
+//     only copy of the body there is.
+//   - orphan_file: fs-to-crdt imports the file as a new note.
+//
+// Repairs never delete anything. Audited.
+func (s *Service) Fsck(ctx context.Context, vaultID uuid.UUID, repair FsckRepair, actor uuid.UUID, ip, ua string) (FsckReport, error) {
+	if s.crdt == nil {
+		return FsckReport{}, errCRDTUnavailable
+	}
+	v, err := s.vaults.GetByID(ctx, vaultID)
+	if err != nil {
+		return FsckReport{}, err
+	}
+	run := &fsckRun{s: s, vault: v, repair: repair, actor: actor}
+	rep := FsckReport{VaultID: vaultID, Slug: v.Slug, Repair: repair, Findings: []FsckFinding{}}
+
+	files, err := s.fs.ListNotes(v.Slug)
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		return rep, err
+	}
+	rep.Files = len(files)
+
+	known := map[string]bool{}
+	for offset := 0; ; offset += copyPageSize {
+		page, err := s.notes.ListForVault(ctx, vaultID, copyPageSize, offset)
+		if err != nil {
+			return rep, err
+		}
+		for _, n := range page {
+			rep.Notes++
+			known[n.Path] = true
+			if f, ok := run.checkNote(ctx, n); ok {
+				rep.add(f)
+			}
+		}
+		if len(page) < copyPageSize {
+			break
+		}
+	}
+	for _, rel := range files {
+		if known[rel] {
+			continue
+		}
+		f := FsckFinding{Kind: FsckOrphanFile, Path: rel}
+		if repair == FsckFSToCRDT {
+			n, err := s.importFile(ctx, run.vault, rel, actor, fsckOrigin)
+			f.NoteID = n.ID
+			run.settle(&f, err)
+		}
+		rep.add(f)
+	}
+
+	s.recordAudit(ctx, actor, vaultID, domain.ActionVaultFsck, ip, ua, map[string]any{
+		"repair":   string(repair),
+		"notes":    rep.Notes,
+		"files":    rep.Files,
+		"findings": len(rep.Findings),
+		"repaired": rep.Repaired,
+	})
+	return rep, nil
+}
+
+// fsckRun carries the per-vault state of one Fsck call.
+type fsckRun struct {
+	s      *Service
+	vault  domain.Vault
+	repair FsckRepair
+	actor  uuid.UUID
+}
+
+func (r *fsckRun) settle(f *FsckFinding, err error) {
+	if err != nil {
+		f.RepairError = err.Error()
+		return
+	}
+	f.Repaired = true
+}
+
+// checkNote compares one row against its file and CRDT state. ok is
+// false when the three agree.
+func (r *fsckRun) checkNote(ctx context.Context, n domain.Note) (f FsckFinding, ok bool) {
+	s, v := r.s, r.vault
+	f = FsckFinding{NoteID: n.ID, Path: n.Path}
+
+	_, raw, err := s.fs.ReadNote(v.Slug, n.Path)
+	fileMissing := errors.Is(err, domain.ErrNotFound)
+	if err != nil && !fileMissing {
+		f.Kind, f.Detail = FsckReadError, err.Error()
+		return f, true
+	}
+
+	has, err := s.crdt.HasState(ctx, v.ID, n.ID)
+	if err != nil {
+		f.Kind, f.Detail = FsckCRDTError, err.Error()
+		return f, true
+	}
+	if !has {
+		if fileMissing {
+			f.Kind, f.Detail = FsckMissingFile, "no file and no crdt state"
+			return f, true
+		}
+		f.Kind = FsckMissingCRDT
+		if r.repair != FsckReportOnly {
+			r.settle(&f, s.crdt.InitFromText(ctx, v.ID, n.ID, fileBody(raw), r.actor, fsckOrigin))
+		}
+		return f, true
+	}
+
+	doc, err := s.crdt.LoadDoc(ctx, v.ID, n.ID)
+	if err != nil {
+		f.Kind, f.Detail = FsckCRDTError, err.Error()
+		return f, true
+	}
+	defer doc.Close()
+	md, err := doc.Markdown()
+	if err != nil {
+		f.Kind, f.Detail = FsckCRDTError, err.Error()
+		return f, true
+	}
+
+	if fileMissing {
+		f.Kind = FsckMissingFile
+		if r.repair == FsckCRDTToFS {
+			r.settle(&f, r.recreateFile(ctx, n, md))
+		}
+		return f, true
+	}
+	body := fileBody(raw)
+	if strings.TrimPrefix(md, "\n") == body {
+		return f, false
+	}
+	f.Kind = FsckDrift
+	f.Detail = fmt.Sprintf("crdt body %d bytes, file body %d bytes", len(md), len(body))
+	switch r.repair {
+	case FsckCRDTToFS:
+		r.settle(&f, s.WriteBodyFromCRDT(ctx, v.ID, n.ID, md))
+	case FsckFSToCRDT:
+		r.settle(&f, r.applyFile(ctx, n.ID, doc, body))
+	}
+	return f, true
+}
+
+// fileBody strips the blank separator line fs.WriteFrontmatter puts
+// between the closing fence and the body, which ReadNote hands back as
+// part of the body. The CRDT never holds it.
+func fileBody(raw []byte) string {
+	return strings.TrimPrefix(string(raw), "\n")
+}
+
+// applyFile diffs the file body into the content root and persists the
+// update, the same way the FS watcher does for an external edit.
+func (r *fsckRun) applyFile(ctx context.Context, id string, doc *crdt.Doc, body string) error {
+	structured, err := doc.Structured()
+	if err != nil {
+		return err
+	}
+	if structured {
+		return fmt.Errorf("%w: structured note; the prosemirror fragment is authoritative, repair with %s",
+			domain.ErrConflict, FsckCRDTToFS)
+	}
+	update, err := doc.ApplyTextDiff(body, fsckOrigin)
+	if err != nil {
+		return err
+	}
+	return r.s.crdt.PersistChange(ctx, r.vault.ID, id, update, r.actor, fsckOrigin, doc)
+}
+
+// recreateFile writes a missing note file from the CRDT body, with
+// frontmatter rebuilt from the pg row.
+func (r *fsckRun) recreateFile(ctx context.Context, n domain.Note, body string) error {
+	s := r.s
+	now := s.now().UTC()
+	front := map[string]any{
+		"id":         n.ID,
+		"title":      n.Title,
+		"created_at": n.CreatedAt.UTC().Format(time.RFC3339),
+		"updated_at": now.Format(time.RFC3339),
+	}
+	s.suppressFSEvent(r.vault.Slug, n.Path)
+	if err := s.fs.WriteNote(r.vault.Slug, n.Path, front, []byte(body)); err != nil {
+		return err
+	}
+	updated := n
+	updated.UpdatedAt = now
+	return s.notes.Upsert(ctx, updated)
+}
+
+// fsck — POST /api/vaults/:vault/crdt/fsck?repair=crdt-to-fs|fs-to-crdt
+func (h *Handlers) fsck(c *fiber.Ctx) error {
+	vaultID, err := capguard.WithVaultID(c)
+	if err != nil {
+		return nil
+	}
+	repair, err := ParseFsckRepair(c.Query("repair"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_repair"})
+	}
+	uid, _ := capguard.UserIDFrom(c)
+	rep, err := h.svc.Fsck(c.UserContext(), vaultID, repair, uid, c.IP(), string(c.Request().Header.UserAgent()))
+	if err != nil {
+		if errors.Is(err, errCRDTUnavailable) {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "crdt_unavailable"})
+		}
+		return mapErr(c, err)
+	}
+	return c.JSON(rep)
+}
@@ -0,0 +1,309 @@
+package notes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/crdt"
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/storage/fs"
+)
+
+// memSnapshots is an in-memory crdt.SnapshotRepo.
+type memSnapshots struct {
+	snapshots map[string][]byte
+	updates   map[string][]crdt.UpdateRow
+	nextID    int64
+}
+
+func newMemSnapshots() *memSnapshots {
+	return &memSnapshots{snapshots: map[string][]byte{}, updates: map[string][]crdt.UpdateRow{}}
+}
+
+func snapKey(v uuid.UUID, n string) string { return v.String() + "/" + n }
+
+func (m *memSnapshots) GetSnapshot(_ context.Context, v uuid.UUID, n string) (crdt.SnapshotRow, error) {
+	s, ok := m.snapshots[snapKey(v, n)]
+	if !ok {
+		return crdt.SnapshotRow{}, domain.ErrNotFound
+	}
+	return crdt.SnapshotRow{State: s}, nil
+}
+
+func (m *memSnapshots) UpsertSnapshot(_ context.Context, v uuid.UUID, n string, state []byte) error {
+	m.snapshots[snapKey(v, n)] = append([]byte(nil), state...)
+	return nil
+}
+
+func (m *memSnapshots) AppendUpdate(_ context.Context, v uuid.UUID, n string, u []byte, _ uuid.UUID, origin string) (int64, error) {
+	m.nextID++
+	k := snapKey(v, n)
+	m.updates[k] = append(m.updates[k], crdt.UpdateRow{ID: m.nextID, Update: append([]byte(nil), u...), OriginKind: origin})
+	return m.nextID, nil
+}
+
+func (m *memSnapshots) ListUpdatesSince(_ context.Context, v uuid.UUID, n string, since int64, _ int) ([]crdt.UpdateRow, error) {
+	var out []crdt.UpdateRow
+	for _, r := range m.updates[snapKey(v, n)] {
+		if r.ID > since {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (m *memSnapshots) CountUpdates(_ context.Context, v uuid.UUID, n string) (int, int64, error) {
+	return len(m.updates[snapKey(v, n)]), 0, nil
+}
+
+func (m *memSnapshots) DeleteUpdatesUpTo(context.Context, uuid.UUID, string, int64) (int64, error) {
+	return 0, nil
+}
+
+func (m *memSnapshots) HighestUpdateID(_ context.Context, v uuid.UUID, n string) (int64, error) {
+	rows := m.updates[snapKey(v, n)]
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	return rows[len(rows)-1].ID, nil
+}
+
+type fsckFixture struct {
+	svc     *Service
+	mgr     *fs.Manager
+	repo    *fakeNoteRepo
+	reg     *crdt.Registry
+	vaultID uuid.UUID
+}
+
+// newFsckFixture seeds a vault with one note in each state Fsck reports:
+// "ok" (consistent), "drift" (file edited behind the CRDT's back),
+// "gone" (file deleted), "seedless" (row + file, no CRDT state) and an
+// unregistered loose/orphan.md.
+func newFsckFixture(t *testing.T) *fsckFixture {
+	t.Helper()
+	mgr, err := fs.NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.EnsureVaultDir("v"); err != nil {
+		t.Fatal(err)
+	}
+	vaultID := uuid.New()
+	lookup := &fakeVaultLookup{byID: map[uuid.UUID]domain.Vault{vaultID: {ID: vaultID, Slug: "v"}}}
+	repo := &fakeNoteRepo{byVault: map[uuid.UUID][]domain.Note{}}
+	reg := crdt.NewRegistry(newMemSnapshots())
+	svc := NewService(repo, lookup, mgr, nil, fakeCopyResolver{}, reg, nil)
+	ctx := context.Background()
+
+	for _, title := range []string{"ok", "drift", "gone"} {
+		if _, err := svc.Create(ctx, vaultID, CreateInput{Title: title, Body: "body of " + title}); err != nil {
+			t.Fatalf("create %s: %v", title, err)
+		}
+	}
+	if err := mgr.WriteNote("v", "drift.md", map[string]any{"id": "drift"}, []byte("edited on disk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.DeleteNote("v", "gone.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.WriteNote("v", "seedless.md", map[string]any{"id": "seedless"}, []byte("never seeded")); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Upsert(ctx, domain.Note{ID: "seedless", VaultID: vaultID, Path: "seedless.md", Title: "seedless"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.WriteNote("v", "loose/orphan.md", map[string]any{"title": "Loose Orphan"}, []byte("found on disk")); err != nil {
+		t.Fatal(err)
+	}
+	return &fsckFixture{svc: svc, mgr: mgr, repo: repo, reg: reg, vaultID: vaultID}
+}
+
+func (fx *fsckFixture) fsck(t *testing.T, repair FsckRepair) FsckReport {
+	t.Helper()
+	rep, err := fx.svc.Fsck(context.Background(), fx.vaultID, repair, uuid.Nil, "", "")
+	if err != nil {
+		t.Fatalf("Fsck(%s): %v", repair, err)
+	}
+	return rep
+}
+
+func (fx *fsckFixture) crdtText(t *testing.T, id string) string {
+	t.Helper()
+	doc, err := fx.reg.LoadDoc(context.Background(), fx.vaultID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	text, err := doc.Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+// structure gives note id a prosemirror fragment holding one "hi"
+// paragraph, as the rich editor would.
+func (fx *fsckFixture) structure(t *testing.T, id string) {
+	t.Helper()
+	ctx := context.Background()
+	doc, err := fx.reg.LoadDoc(ctx, fx.vaultID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	u := []byte{
+		1, 2, 1, 0,
+		0x07, 1, 11, 'p', 'r', 'o', 's', 'e', 'm', 'i', 'r', 'r', 'o', 'r', 3, 9, 'p', 'a', 'r', 'a', 'g', 'r', 'a', 'p', 'h',
+		0x04, 0, 1, 0, 2, 'h', 'i',
+		0,
+	}
+	if err := doc.ApplyUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := fx.reg.PersistChange(ctx, fx.vaultID, id, u, uuid.Nil, "test", doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// kinds renders the findings as sorted "kind:path[+]" strings, "+"
+// marking a repaired finding.
+func kinds(rep FsckReport) string {
+	var out []string
+	for _, f := range rep.Findings {
+		s := f.Kind + ":" + f.Path
+		if f.Repaired {
+			s += "+"
+		}
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return strings.Join(out, " ")
+}
+
+func TestFsckReportOnly(t *testing.T) {
+	fx := newFsckFixture(t)
+	rep := fx.fsck(t, FsckReportOnly)
+	want := "drift:drift.md missing_crdt:seedless.md missing_file:gone.md orphan_file:loose/orphan.md"
+	if got := kinds(rep); got != want {
+		t.Fatalf("findings = %q, want %q", got, want)
+	}
+	if rep.Notes != 4 || rep.Files != 4 || rep.Clean() {
+		t.Fatalf("notes=%d files=%d clean=%v", rep.Notes, rep.Files, rep.Clean())
+	}
+	// Nothing was written.
+	if got := fx.crdtText(t, "drift"); got != "body of drift" {
+		t.Fatalf("report-only run changed the crdt: %q", got)
+	}
+	if _, err := fx.repo.GetByPath(context.Background(), fx.vaultID, "loose/orphan.md"); err == nil {
+		t.Fatal("report-only run imported the orphan")
+	}
+}
+
+func TestFsckRepairFSToCRDT(t *testing.T) {
+	fx := newFsckFixture(t)
+	rep := fx.fsck(t, FsckFSToCRDT)
+	want := "drift:drift.md+ missing_crdt:seedless.md+ missing_file:gone.md orphan_file:loose/orphan.md+"
+	if got := kinds(rep); got != want {
+		t.Fatalf("findings = %q, want %q", got, want)
+	}
+	if got := fx.crdtText(t, "drift"); got != "edited on disk" {
+		t.Fatalf("drift crdt = %q", got)
+	}
+	if got := fx.crdtText(t, "seedless"); got != "never seeded" {
+		t.Fatalf("seedless crdt = %q", got)
+	}
+	n, err := fx.repo.GetByPath(context.Background(), fx.vaultID, "loose/orphan.md")
+	if err != nil {
+		t.Fatalf("orphan not imported: %v", err)
+	}
+	if n.ID != "orphan" || n.Title != "Loose Orphan" {
+		t.Fatalf("imported row = %+v", n)
+	}
+	if got := fx.crdtText(t, "orphan"); got != "found on disk" {
+		t.Fatalf("orphan crdt = %q", got)
+	}
+	front, _, err := fx.mgr.ReadNote("v", "loose/orphan.md")
+	if err != nil || front["id"] != "orphan" {
+		t.Fatalf("orphan frontmatter id not written back: %v %v", front, err)
+	}
+
+	// The file side cannot resurrect a deleted file; everything else is
+	// now consistent.
+	if got := kinds(fx.fsck(t, FsckReportOnly)); got != "missing_file:gone.md" {
+		t.Fatalf("after repair: %q", got)
+	}
+}
+
+func TestFsckRepairCRDTToFS(t *testing.T) {
+	fx := newFsckFixture(t)
+	rep := fx.fsck(t, FsckCRDTToFS)
+	want := "drift:drift.md+ missing_crdt:seedless.md+ missing_file:gone.md+ orphan_file:loose/orphan.md"
+	if got := kinds(rep); got != want {
+		t.Fatalf("findings = %q, want %q", got, want)
+	}
+	for id, body := range map[string]string{"drift": "body of drift", "gone": "body of gone"} {
+		front, got, err := fx.mgr.ReadNote("v", id+".md")
+		if err != nil {
+			t.Fatalf("read %s: %v", id, err)
+		}
+		if fileBody(got) != body || front["id"] != id {
+			t.Fatalf("%s file = %v %q", id, front, got)
+		}
+	}
+	if got := kinds(fx.fsck(t, FsckReportOnly)); got != "orphan_file:loose/orphan.md" {
+		t.Fatalf("after repair: %q", got)
+	}
+}
+
+func TestFsckStructuredDriftNeedsCRDTToFS(t *testing.T) {
+	fx := newFsckFixture(t)
+	// Give "ok" a prosemirror fragment; its rendered markdown now differs
+	// from the file, and the file side must not try to overwrite it.
+	fx.structure(t, "ok")
+
+	for _, f := range fx.fsck(t, FsckFSToCRDT).Findings {
+		if f.NoteID != "ok" {
+			continue
+		}
+		if f.Kind != FsckDrift || f.Repaired || f.RepairError == "" {
+			t.Fatalf("structured finding = %+v", f)
+		}
+		return
+	}
+	t.Fatal("structured drift not reported")
+}
+
+func TestFsckSkipsUnreadableFiles(t *testing.T) {
+	fx := newFsckFixture(t)
+	abs := filepath.Join(fx.mgr.Root, "v", "ok.md")
+	if err := os.WriteFile(abs, []byte("---\nid: ok\nno closing fence"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rep := fx.fsck(t, FsckFSToCRDT)
+	for _, f := range rep.Findings {
+		if f.Path == "ok.md" && (f.Kind != FsckReadError || f.Repaired) {
+			t.Fatalf("unreadable file finding = %+v", f)
+		}
+	}
+	if got := fx.crdtText(t, "ok"); got != "body of ok" {
+		t.Fatalf("unreadable file leaked into crdt: %q", got)
+	}
+}
+
+func TestParseFsckRepair(t *testing.T) {
+	for in, want := range map[string]FsckRepair{"": FsckReportOnly, "none": FsckReportOnly, "crdt-to-fs": FsckCRDTToFS, "fs-to-crdt": FsckFSToCRDT} {
+		if got, err := ParseFsckRepair(in); err != nil || got != want {
+			t.Fatalf("ParseFsckRepair(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseFsckRepair("both"); err == nil {
+		t.Fatal("want error for unknown direction")
+	}
+}
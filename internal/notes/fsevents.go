@@ -0,0 +1,192 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/storage/fs"
+)
+
+// ---- Filesystem-originated creates, deletes and moves ----------------------
+
+// fsImportOrigin tags the seed update of a note imported from a file
+// that appeared on disk.
+const fsImportOrigin = "fs-import"
+
+// ImportFromFS registers a markdown file that appeared in the vault
+// directory outside lumi (Syncthing, $EDITOR, a copy in Finder): the
+// row and CRDT seed are created as for an API create, federated peers
+// are told, and the audit entry is attributed to the system actor.
+// ErrConflict means a note already lives at rel.
+func (s *Service) ImportFromFS(ctx context.Context, vaultID uuid.UUID, rel string) (domain.Note, error) {
+	v, err := s.vaults.GetByID(ctx, vaultID)
+	if err != nil {
+		return domain.Note{}, err
+	}
+	if _, err := s.notes.GetByPath(ctx, vaultID, rel); err == nil {
+		return domain.Note{}, fmt.Errorf("%w: %s is already a note", domain.ErrConflict, rel)
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return domain.Note{}, err
+	}
+	n, err := s.importFile(ctx, v, rel, uuid.Nil, fsImportOrigin)
+	if err != nil {
+		return n, err
+	}
+	s.recordAudit(ctx, uuid.Nil, vaultID, domain.ActionNoteCreate, "", "", map[string]any{
+		"note_id": n.ID,
+		"path":    n.Path,
+		"title":   n.Title,
+		"origin":  originFS,
+	})
+	return n, nil
+}
+
+// importFile registers an unknown markdown file as a note: the pg row
+// is created from its frontmatter (falling back to the file name), and
+// the CRDT is seeded from its body. The frontmatter id is kept when it
+// is a valid, free note id; otherwise a fresh one is allocated and
+// written back to the file. Shared by ImportFromFS and fsck repairs.
+func (s *Service) importFile(ctx context.Context, v domain.Vault, rel string, actor uuid.UUID, originKind string) (domain.Note, error) {
+	if _, err := validateNoteRelPath(rel); err != nil {
+		return domain.Note{}, err
+	}
+	if s.fs.Ignored(v.Slug, rel, false) {
+		return domain.Note{}, fmt.Errorf("%w: %s is excluded by %s", domain.ErrValidation, rel, fs.IgnoreFile)
+	}
+	front, raw, err := s.fs.ReadNote(v.Slug, rel)
+	if err != nil {
+		return domain.Note{}, err
+	}
+	stem := strings.TrimSuffix(path.Base(rel), ".md")
+	title, _ := front["title"].(string)
+	if title = strings.TrimSpace(title); title == "" {
+		title = stem
+	}
+	id, _ := front["id"].(string)
+	if id == "" || id != slugifyTitle(id) {
+		id = ""
+	} else if _, err := s.notes.Get(ctx, v.ID, id); err == nil {
+		id = ""
+	} else if !errors.Is(err, domain.ErrNotFound) {
+		return domain.Note{}, err
+	}
+	if id == "" {
+		if id, err = s.allocateNoteID(ctx, v.ID, slugifyTitle(stem)); err != nil {
+			return domain.Note{}, err
+		}
+		front["id"] = id
+		s.suppressFSEvent(v.Slug, rel)
+		if err := s.fs.WriteNote(v.Slug, rel, front, raw); err != nil {
+			return domain.Note{}, err
+		}
+	}
+
+	now := s.now().UTC()
+	created := now
+	if ts, ok := front["created_at"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			created = t.UTC()
+		}
+	}
+	note := domain.Note{
+		ID:        id,
+		VaultID:   v.ID,
+		Path:      rel,
+		Title:     title,
+		CreatedAt: created,
+		UpdatedAt: now,
+	}
+	if err := s.notes.Upsert(ctx, note); err != nil {
+		return note, err
+	}
+	if s.crdt != nil {
+		if err := s.crdt.InitFromText(ctx, v.ID, id, fileBody(raw), actor, originKind); err != nil {
+			return note, err
+		}
+	}
+	if s.fedNotify != nil {
+		s.fedNotify.NoteCreated(v.ID, id, rel, title)
+	}
+	return note, nil
+}
+
+// DeleteFromFS forgets the note whose file was removed outside lumi
+// (Finder, the TUI, a sync tool). With dir set, rel is a directory and
+// every note under it goes. Returns the ids deleted so the caller can
+// close their live rooms; a path with no note row is not an error.
+func (s *Service) DeleteFromFS(ctx context.Context, vaultID uuid.UUID, rel string, dir bool) ([]string, error) {
+	var targets []domain.Note
+	if dir {
+		under, err := s.notesUnder(ctx, vaultID, rel)
+		if err != nil {
+			return nil, err
+		}
+		targets = under
+	} else {
+		n, err := s.notes.GetByPath(ctx, vaultID, rel)
+		if errors.Is(err, domain.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, n)
+	}
+	var deleted []string
+	for _, n := range targets {
+		if err := s.deleteInternal(ctx, vaultID, n.ID, uuid.Nil, "", "", originFS); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, n.ID)
+	}
+	return deleted, nil
+}
+
+// MoveFromFS records a rename done outside lumi: the file already sits
+// at newRel. The note keeps its id (and so its CRDT history and live
+// room); federated peers hear about the move. A note previously at
+// newRel had its file overwritten by the rename and is deleted; its id
+// is returned so the caller can close its room. ErrNotFound means no
+// note lived at oldRel.
+func (s *Service) MoveFromFS(ctx context.Context, vaultID uuid.UUID, oldRel, newRel string) (replaced string, err error) {
+	n, err := s.notes.GetByPath(ctx, vaultID, oldRel)
+	if err != nil {
+		return "", err
+	}
+	if other, err := s.notes.GetByPath(ctx, vaultID, newRel); err == nil && other.ID != n.ID {
+		if err := s.deleteInternal(ctx, vaultID, other.ID, uuid.Nil, "", "", originFS); err != nil {
+			return "", err
+		}
+		replaced = other.ID
+	} else if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		return "", err
+	}
+	return replaced, s.moveInternal(ctx, vaultID, n.ID, newRel, "", originFS)
+}
+
+// notesUnder lists every note whose path lies inside dir.
+func (s *Service) notesUnder(ctx context.Context, vaultID uuid.UUID, dir string) ([]domain.Note, error) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var out []domain.Note
+	for offset := 0; ; offset += copyPageSize {
+		page, err := s.notes.ListForVault(ctx, vaultID, copyPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range page {
+			if strings.HasPrefix(n.Path, prefix) {
+				out = append(out, n)
+			}
+		}
+		if len(page) < copyPageSize {
+			return out, nil
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/crdt"
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/storage/fs"
+)
+
+// fedRecorder is a FederationNotifier that records what it heard.
+type fedRecorder struct {
+	created, deleted []string
+	moved            map[string]string
+}
+
+func (f *fedRecorder) NoteCreated(_ uuid.UUID, id, _, _ string) { f.created = append(f.created, id) }
+func (f *fedRecorder) NoteDeleted(_ uuid.UUID, id string)       { f.deleted = append(f.deleted, id) }
+func (f *fedRecorder) NoteMoved(_ uuid.UUID, id, newPath, _ string) {
+	if f.moved == nil {
+		f.moved = map[string]string{}
+	}
+	f.moved[id] = newPath
+}
+
+func newFSEventsService(t *testing.T, paths ...string) (*Service, *fakeNoteRepo, *fedRecorder, *fs.Manager, uuid.UUID) {
+	t.Helper()
+	mgr, err := fs.NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.EnsureVaultDir("v"); err != nil {
+		t.Fatal(err)
+	}
+	vaultID := uuid.New()
+	lookup := &fakeVaultLookup{byID: map[uuid.UUID]domain.Vault{vaultID: {ID: vaultID, Slug: "v"}}}
+	repo := &fakeNoteRepo{byVault: map[uuid.UUID][]domain.Note{}}
+	svc := NewService(repo, lookup, mgr, nil, fakeCopyResolver{}, crdt.NewRegistry(newMemSnapshots()), nil)
+	fed := &fedRecorder{}
+	svc.SetFederationNotifier(fed)
+	for _, p := range paths {
+		id := filepath.Base(p[:len(p)-len(".md")])
+		if err := mgr.WriteNote("v", p, map[string]any{"id": id}, []byte("body")); err != nil {
+			t.Fatal(err)
+		}
+		repo.byVault[vaultID] = append(repo.byVault[vaultID], domain.Note{ID: id, VaultID: vaultID, Path: p, Title: id})
+	}
+	return svc, repo, fed, mgr, vaultID
+}
+
+func TestDeleteFromFSDropsRowAndNotifies(t *testing.T) {
+	svc, repo, fed, mgr, vaultID := newFSEventsService(t, "a.md", "b.md")
+	ctx := context.Background()
+	if err := os.Remove(filepath.Join(mgr.Root, "v", "a.md")); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := svc.DeleteFromFS(ctx, vaultID, "a.md", false)
+	if err != nil || len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("DeleteFromFS = %v, %v", ids, err)
+	}
+	if _, err := repo.Get(ctx, vaultID, "a"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("row survived: %v", err)
+	}
+	if len(fed.deleted) != 1 || fed.deleted[0] != "a" {
+		t.Fatalf("federation heard %v", fed.deleted)
+	}
+	// Unknown paths are ignored.
+	if ids, err := svc.DeleteFromFS(ctx, vaultID, "stray.md", false); err != nil || len(ids) != 0 {
+		t.Fatalf("stray delete = %v, %v", ids, err)
+	}
+}
+
+func TestDeleteFromFSLeavesRecreatedFile(t *testing.T) {
+	svc, _, _, mgr, vaultID := newFSEventsService(t, "a.md")
+	// The file is back by the time the delete is processed; it is not
+	// the service's to remove.
+	if _, err := svc.DeleteFromFS(context.Background(), vaultID, "a.md", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := mgr.ReadNote("v", "a.md"); err != nil {
+		t.Fatalf("DeleteFromFS removed the file: %v", err)
+	}
+}
+
+func TestDeleteFromFSDirectory(t *testing.T) {
+	svc, repo, _, _, vaultID := newFSEventsService(t, "keep.md", "drafts/a.md", "drafts/deep/b.md", "drafts-old/c.md")
+	ids, err := svc.DeleteFromFS(context.Background(), vaultID, "drafts", true)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("DeleteFromFS dir = %v, %v", ids, err)
+	}
+	left := map[string]bool{}
+	for _, n := range repo.byVault[vaultID] {
+		left[n.ID] = true
+	}
+	if !left["keep"] || !left["c"] || len(left) != 2 {
+		t.Fatalf("remaining rows = %v", left)
+	}
+}
+
+func TestMoveFromFSKeepsIDAndNotifies(t *testing.T) {
+	svc, repo, fed, mgr, vaultID := newFSEventsService(t, "a.md")
+	ctx := context.Background()
+	root := filepath.Join(mgr.Root, "v")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "a.md"), filepath.Join(root, "sub", "renamed.md")); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := svc.MoveFromFS(ctx, vaultID, "a.md", "sub/renamed.md")
+	if err != nil || replaced != "" {
+		t.Fatalf("MoveFromFS = %q, %v", replaced, err)
+	}
+	n, err := repo.Get(ctx, vaultID, "a")
+	if err != nil || n.Path != "sub/renamed.md" || n.Title != "a" {
+		t.Fatalf("row after move = %+v, %v", n, err)
+	}
+	if fed.moved["a"] != "sub/renamed.md" {
+		t.Fatalf("federation heard %v", fed.moved)
+	}
+	// The file stays where the user put it.
+	if _, _, err := mgr.ReadNote("v", "sub/renamed.md"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.MoveFromFS(ctx, vaultID, "ghost.md", "x.md"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("move of unknown file: %v", err)
+	}
+}
+
+func TestMoveFromFSOverwritingAnotherNote(t *testing.T) {
+	svc, repo, fed, mgr, vaultID := newFSEventsService(t, "a.md", "b.md")
+	ctx := context.Background()
+	root := filepath.Join(mgr.Root, "v")
+	if err := os.Rename(filepath.Join(root, "a.md"), filepath.Join(root, "b.md")); err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := svc.MoveFromFS(ctx, vaultID, "a.md", "b.md")
+	if err != nil || replaced != "b" {
+		t.Fatalf("MoveFromFS = %q, %v", replaced, err)
+	}
+	if _, err := repo.Get(ctx, vaultID, "b"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("overwritten note survived: %v", err)
+	}
+	if n, _ := repo.Get(ctx, vaultID, "a"); n.Path != "b.md" {
+		t.Fatalf("moved row = %+v", n)
+	}
+	if _, _, err := mgr.ReadNote("v", "b.md"); err != nil {
+		t.Fatalf("the moved file was removed with the overwritten note: %v", err)
+	}
+	if len(fed.deleted) != 1 || fed.deleted[0] != "b" {
+		t.Fatalf("federation deletes = %v", fed.deleted)
+	}
+}
+
+func TestImportFromFS(t *testing.T) {
+	svc, repo, fed, mgr, vaultID := newFSEventsService(t, "taken.md")
+	ctx := context.Background()
+	root := filepath.Join(mgr.Root, "v")
+	if err := os.MkdirAll(filepath.Join(root, "inbox"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "inbox", "Meeting Notes.md"), []byte("# agenda\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := svc.ImportFromFS(ctx, vaultID, "inbox/Meeting Notes.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != "meeting-notes" || n.Title != "Meeting Notes" || n.Path != "inbox/Meeting Notes.md" {
+		t.Fatalf("imported note = %+v", n)
+	}
+	if _, err := repo.GetByPath(ctx, vaultID, n.Path); err != nil {
+		t.Fatalf("no row: %v", err)
+	}
+	front, _, err := mgr.ReadNote("v", n.Path)
+	if err != nil || front["id"] != n.ID {
+		t.Fatalf("id not written back: %v, %v", front, err)
+	}
+	doc, err := svc.crdt.LoadDoc(ctx, vaultID, n.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer doc.Close()
+	if text, _ := doc.Text(); text != "# agenda\n" {
+		t.Fatalf("crdt seeded with %q", text)
+	}
+	if len(fed.created) != 1 || fed.created[0] != n.ID {
+		t.Fatalf("federation heard %v", fed.created)
+	}
+
+	if _, err := svc.ImportFromFS(ctx, vaultID, n.Path); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("second import: %v", err)
+	}
+}
+
+func TestImportFromFSCopiedFileGetsFreshID(t *testing.T) {
+	svc, _, _, mgr, vaultID := newFSEventsService(t, "taken.md")
+	ctx := context.Background()
+	// A copy of an existing note carries that note's id.
+	if err := mgr.WriteNote("v", "copy.md", map[string]any{"id": "taken", "title": "Copied"}, []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := svc.ImportFromFS(ctx, vaultID, "copy.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID == "taken" || n.Title != "Copied" {
+		t.Fatalf("imported note = %+v", n)
+	}
+	front, body, err := mgr.ReadNote("v", "copy.md")
+	if err != nil || front["id"] != n.ID || fileBody(body) != "body" {
+		t.Fatalf("file after import = %v %q, %v", front, body, err)
+	}
+}
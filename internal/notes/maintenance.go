@@ -0,0 +1,119 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/capguard"
+	"github.com/ViniZap4/lumi-server/internal/crdt"
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- CRDT maintenance ------------------------------------------------------
+
+// Compactor is the background CRDT compaction worker as seen by the
+// vault maintenance endpoints. *crdt.Compactor satisfies it.
+type Compactor interface {
+	CompactVault(ctx context.Context, vaultID uuid.UUID) (crdt.CompactReport, error)
+	LogTotals(ctx context.Context, vaultID uuid.UUID) (crdt.LogTotals, error)
+	Stats() crdt.CompactorStats
+}
+
+// SetCompactor wires the compaction worker; nil disables the
+// maintenance endpoints (they answer 503 crdt_unavailable).
+func (s *Service) SetCompactor(c Compactor) { s.compactor = c }
+
+// CompactVault folds every note log in the vault into its snapshot and
+// sweeps the vault's snapshots for tombstones. Audited.
+func (s *Service) CompactVault(ctx context.Context, vaultID, actor uuid.UUID, ip, ua string) (crdt.CompactReport, error) {
+	if s.compactor == nil {
+		return crdt.CompactReport{}, errCRDTUnavailable
+	}
+	rep, err := s.compactor.CompactVault(ctx, vaultID)
+	if err != nil {
+		return rep, err
+	}
+	s.recordAudit(ctx, actor, vaultID, domain.ActionVaultCompact, ip, ua, map[string]any{
+		"compacted":       rep.Compacted,
+		"rows_deleted":    rep.RowsDeleted,
+		"bytes_reclaimed": rep.BytesReclaimed,
+		"failed":          rep.Failed,
+	})
+	return rep, nil
+}
+
+// CRDTStats is the wire shape of GET /crdt/stats.
+type CRDTStats struct {
+	Vault  crdt.LogTotals      `json:"vault"`
+	Worker crdt.CompactorStats `json:"worker"`
+}
+
+// CRDTStats reports the vault's CRDT storage footprint alongside the
+// worker's cumulative counters.
+func (s *Service) CRDTStats(ctx context.Context, vaultID uuid.UUID) (CRDTStats, error) {
+	if s.compactor == nil {
+		return CRDTStats{}, errCRDTUnavailable
+	}
+	totals, err := s.compactor.LogTotals(ctx, vaultID)
+	if err != nil {
+		return CRDTStats{}, err
+	}
+	return CRDTStats{Vault: totals, Worker: s.compactor.Stats()}, nil
+}
+
+func (h *Handlers) registerMaintenance(r fiber.Router) {
+	resolver := h.svc.resolver
+	r.Post("/vaults/:vault/crdt/compact",
+		capguard.RequireCapability(resolver, domain.CapVaultManage),
+		h.compact,
+	)
+	r.Get("/vaults/:vault/crdt/stats",
+		capguard.RequireCapability(resolver, domain.CapVaultManage),
+		h.crdtStats,
+	)
+	r.Post("/vaults/:vault/crdt/fsck",
+		capguard.RequireCapability(resolver, domain.CapVaultManage),
+		h.fsck,
+	)
+	r.Post("/vaults/:vault/crdt/reconcile",
+		capguard.RequireCapability(resolver, domain.CapVaultManage),
+		h.reconcile,
+	)
+}
+
+// compact — POST /api/vaults/:vault/crdt/compact
+func (h *Handlers) compact(c *fiber.Ctx) error {
+	vaultID, err := capguard.WithVaultID(c)
+	if err != nil {
+		return nil
+	}
+	uid, _ := capguard.UserIDFrom(c)
+	rep, err := h.svc.CompactVault(c.UserContext(), vaultID, uid, c.IP(), string(c.Request().Header.UserAgent()))
+	if err != nil {
+		if errors.Is(err, errCRDTUnavailable) {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "crdt_unavailable"})
+		}
+		return mapErr(c, err)
+	}
+	return c.JSON(rep)
+}
+
+// crdtStats — GET /api/vaults/:vault/crdt/stats
+func (h *Handlers) crdtStats(c *fiber.Ctx) error {
+	vaultID, err := capguard.WithVaultID(c)
+	if err != nil {
+		return nil
+	}
+	st, err := h.svc.CRDTStats(c.UserContext(), vaultID)
+	if err != nil {
+		if errors.Is(err, errCRDTUnavailable) {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "crdt_unavailable"})
+		}
+		return mapErr(c, err)
+	}
+	return c.JSON(st)
+}
@@ -80,6 +80,8 @@ type Service struct {
 	crdt      *crdt.Registry
 	silencer  FSEventSilencer
 	fedNotify FederationNotifier
+	compactor Compactor
+	replayer  FSReplayer
 	now       func() time.Time
 }
 
@@ -296,8 +298,45 @@ func (s *Service) GetContent(ctx context.Context, vaultID uuid.UUID, id string)
 	return n, front, body, nil
 }
 
+// List pages through the vault's notes, leaving out paths its ignore
+// file excludes. A note imported before its rule was added keeps its
+// row (reconcile leaves it be) but is no longer listed, as it is no
+// longer watched. limit <= 0 means no limit.
 func (s *Service) List(ctx context.Context, vaultID uuid.UUID, limit, offset int) ([]domain.Note, error) {
-	return s.notes.ListForVault(ctx, vaultID, limit, offset)
+	v, err := s.vaults.GetByID(ctx, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	ignore := s.fs.IgnoreRules(v.Slug)
+	if ignore == nil {
+		return s.notes.ListForVault(ctx, vaultID, limit, offset)
+	}
+	// Ignored rows are skipped before offset and limit apply, so pages
+	// stay full and do not overlap.
+	var out []domain.Note
+	skipped := 0
+	for page := 0; ; page += copyPageSize {
+		rows, err := s.notes.ListForVault(ctx, vaultID, copyPageSize, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range rows {
+			if ignore.Match(n.Path, false) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			out = append(out, n)
+			if limit > 0 && len(out) == limit {
+				return out, nil
+			}
+		}
+		if len(rows) < copyPageSize {
+			return out, nil
+		}
+	}
 }
 
 // ---- Service: Update -------------------------------------------------------
@@ -318,6 +357,14 @@ func (s *Service) Update(ctx context.Context, vaultID uuid.UUID, id string, in U
 		return domain.Note{}, err
 	}
 
+	if in.Body != nil {
+		// Checked before anything is written, so a rejected PATCH moves
+		// nothing either.
+		if err := s.rejectStructured(ctx, vaultID, id); err != nil {
+			return domain.Note{}, err
+		}
+	}
+
 	moved := false
 	newPath := n.Path
 	if in.Path != nil {
@@ -448,7 +495,7 @@ func validateNoteRelPath(raw string) (string, error) {
 // ---- Service: Delete -------------------------------------------------------
 
 func (s *Service) Delete(ctx context.Context, vaultID uuid.UUID, id string, actor uuid.UUID, ip, ua string) error {
-	return s.deleteInternal(ctx, vaultID, id, actor, ip, ua, true)
+	return s.deleteInternal(ctx, vaultID, id, actor, ip, ua, "")
 }
 
 // DeleteFromFederation applies a deletion relayed from a federated peer.
@@ -456,10 +503,20 @@ func (s *Service) Delete(ctx context.Context, vaultID uuid.UUID, id string, acto
 // *other* links itself, so notifying here would bounce it back to its
 // source.
 func (s *Service) DeleteFromFederation(ctx context.Context, vaultID uuid.UUID, id string) error {
-	return s.deleteInternal(ctx, vaultID, id, uuid.Nil, "", "", false)
+	return s.deleteInternal(ctx, vaultID, id, uuid.Nil, "", "", originFederation)
 }
 
-func (s *Service) deleteInternal(ctx context.Context, vaultID uuid.UUID, id string, actor uuid.UUID, ip, ua string, notify bool) error {
+// Origins of deletes and moves that did not come through the REST API.
+const (
+	originFederation = "federation"
+	originFS         = "fs"
+)
+
+// deleteInternal drops the row, CRDT state and file. origin is "" for
+// API calls; federation deletes skip the notifier, and FS deletes skip
+// the file (it is already gone — and if something new landed at the
+// path since, it is not ours to remove).
+func (s *Service) deleteInternal(ctx context.Context, vaultID uuid.UUID, id string, actor uuid.UUID, ip, ua, origin string) error {
 	n, err := s.notes.Get(ctx, vaultID, id)
 	if err != nil {
 		return err
@@ -471,9 +528,22 @@ func (s *Service) deleteInternal(ctx context.Context, vaultID uuid.UUID, id stri
 	if err := s.notes.Delete(ctx, vaultID, id); err != nil {
 		return err
 	}
-	if notify && s.fedNotify != nil {
+	// The row delete cascades to note_yjs_*; drop the cached doc so a
+	// note later re-created under the same id starts from its new seed.
+	if s.crdt != nil {
+		s.crdt.Invalidate(vaultID, id)
+	}
+	if origin != originFederation && s.fedNotify != nil {
 		s.fedNotify.NoteDeleted(vaultID, id)
 	}
+	if origin == originFS {
+		s.recordAudit(ctx, actor, vaultID, domain.ActionNoteDelete, ip, ua, map[string]any{
+			"note_id": id,
+			"path":    n.Path,
+			"origin":  origin,
+		})
+		return nil
+	}
 	s.suppressFSEvent(v.Slug, n.Path)
 	if err := s.fs.DeleteNote(v.Slug, n.Path); err != nil && !errors.Is(err, domain.ErrNotFound) {
 		// pg row is already gone; surface but don't fail the request.
@@ -498,6 +568,38 @@ func (s *Service) deleteInternal(ctx context.Context, vaultID uuid.UUID, id stri
 // intentionally disabled).
 var errCRDTUnavailable = fmt.Errorf("%w: crdt not available", domain.ErrValidation)
 
+// errStructuredNote rejects plain-text edits of a note whose prosemirror
+// fragment is authoritative: the file mirror renders the fragment, so a
+// diff into the "content" text would reach neither the disk nor the
+// rich editor.
+var errStructuredNote = fmt.Errorf("%w: structured note; edit it in the rich editor", domain.ErrConflict)
+
+// checkPlainText returns errStructuredNote when doc carries a prosemirror
+// fragment.
+func checkPlainText(doc *crdt.Doc) error {
+	structured, err := doc.Structured()
+	if err != nil {
+		return err
+	}
+	if structured {
+		return errStructuredNote
+	}
+	return nil
+}
+
+// rejectStructured loads the note's document and applies checkPlainText.
+func (s *Service) rejectStructured(ctx context.Context, vaultID uuid.UUID, id string) error {
+	if s.crdt == nil {
+		return nil
+	}
+	doc, err := s.crdt.LoadDoc(ctx, vaultID, id)
+	if err != nil {
+		return err
+	}
+	defer doc.Close()
+	return checkPlainText(doc)
+}
+
 // SnapshotResult is the wire shape of GET /snapshot — text + opaque
 // state vector (lib0 v1). The handler base64-encodes the vector.
 type SnapshotResult struct {
@@ -575,6 +677,9 @@ func (s *Service) ApplyDiff(
 		return SnapshotResult{}, err
 	}
 	defer doc.Close()
+	if err := checkPlainText(doc); err != nil {
+		return SnapshotResult{}, err
+	}
 
 	update, err := doc.ApplyTextDiff(newText, originKind)
 	if err != nil {
@@ -599,6 +704,7 @@ func (s *Service) ApplyDiff(
 		// /content and re-PATCHing.
 		return SnapshotResult{}, fmt.Errorf("crdt + fs mirror: read note: %w", err)
 	}
+	body, _ := doc.Markdown()
 	mergedText, _ := doc.Text()
 	now := s.now().UTC()
 	front["updated_at"] = now.Format(time.RFC3339)
@@ -606,7 +712,7 @@ func (s *Service) ApplyDiff(
 		front["id"] = id
 	}
 	s.suppressFSEvent(v.Slug, n.Path)
-	if err := s.fs.WriteNote(v.Slug, n.Path, front, []byte(mergedText)); err != nil {
+	if err := s.fs.WriteNote(v.Slug, n.Path, front, []byte(body)); err != nil {
 		return SnapshotResult{}, fmt.Errorf("crdt + fs mirror: write note: %w", err)
 	}
 
@@ -642,9 +748,9 @@ func (s *Service) ApplyDiff(
 
 // ApplyUpdate is the CRDT-peer "here's a Yjs update I computed
 // locally" path (Phase H slice 3). The supplied `update` bytes are a
-// lib0-v1 encoded Y.Doc update — applied to the server's doc directly
-// without a text re-diff. The caller already speaks Yjs (e.g. the
-// apple client's LumiCRDT). Empty update is a no-op.
+// Y.Doc update in enc — applied to the server's doc directly without a
+// text re-diff, and persisted as v1. The caller already speaks Yjs
+// (e.g. the apple client's LumiCRDT). Empty update is a no-op.
 //
 // Same persistence + FS-mirror + audit flow as ApplyDiff; differs only
 // in how the update bytes are produced.
@@ -652,6 +758,7 @@ func (s *Service) ApplyUpdate(
 	ctx context.Context,
 	vaultID uuid.UUID, id string,
 	update []byte,
+	enc crdt.Encoding,
 	originKind string,
 	actor uuid.UUID, ip, ua string,
 ) (SnapshotResult, error) {
@@ -679,18 +786,23 @@ func (s *Service) ApplyUpdate(
 		sv, _ := doc.StateVectorV1()
 		return SnapshotResult{NoteID: n.ID, Path: n.Path, Text: text, VectorClock: sv}, nil
 	}
-	if err := doc.ApplyUpdate(update); err != nil {
+	v1, err := doc.ApplyUpdateAs(update, enc)
+	if err != nil {
 		return SnapshotResult{}, fmt.Errorf("apply update: %w", err)
 	}
-	if err := s.crdt.PersistChange(ctx, vaultID, id, update, actor, originKind, doc); err != nil {
-		return SnapshotResult{}, err
+	if len(v1) > 0 {
+		if err := s.crdt.PersistChange(ctx, vaultID, id, v1, actor, originKind, doc); err != nil {
+			return SnapshotResult{}, err
+		}
 	}
 
-	// Mirror merged text to disk, preserving frontmatter.
+	// Mirror merged body to disk, preserving frontmatter. Structured
+	// notes mirror their rendered prosemirror fragment.
 	front, _, err := s.fs.ReadNote(v.Slug, n.Path)
 	if err != nil {
 		return SnapshotResult{}, fmt.Errorf("crdt + fs mirror: read note: %w", err)
 	}
+	body, _ := doc.Markdown()
 	mergedText, _ := doc.Text()
 	now := s.now().UTC()
 	front["updated_at"] = now.Format(time.RFC3339)
@@ -698,7 +810,7 @@ func (s *Service) ApplyUpdate(
 		front["id"] = id
 	}
 	s.suppressFSEvent(v.Slug, n.Path)
-	if err := s.fs.WriteNote(v.Slug, n.Path, front, []byte(mergedText)); err != nil {
+	if err := s.fs.WriteNote(v.Slug, n.Path, front, []byte(body)); err != nil {
 		return SnapshotResult{}, fmt.Errorf("crdt + fs mirror: write note: %w", err)
 	}
 
@@ -786,6 +898,9 @@ func (s *Service) applyBodyToCRDT(ctx context.Context, vaultID uuid.UUID, id str
 		return err
 	}
 	defer doc.Close()
+	if err := checkPlainText(doc); err != nil {
+		return err
+	}
 	update, err := doc.ApplyTextDiff(newBody, originKind)
 	if err != nil {
 		return err
@@ -878,6 +993,7 @@ func (h *Handlers) Register(r fiber.Router) {
 		capguard.RequireCapability(resolver, domain.CapNoteEdit),
 		h.applyDiff,
 	)
+	h.registerMaintenance(r)
 }
 
 type noteDTO struct {
@@ -1145,6 +1261,8 @@ type diffReq struct {
 	// When set, the server applies the update directly — no text
 	// re-diff. Mutually exclusive with `Text`.
 	Update string `json:"update,omitempty"`
+	// Encoding is the lib0 encoding of Update: "v1" (default) or "v2".
+	Encoding string `json:"encoding,omitempty"`
 	// Origin labels the source ("tui-diff", "apple-diff", "web", etc).
 	// Defaults to "tui-diff" when omitted.
 	Origin string `json:"origin,omitempty"`
@@ -1155,9 +1273,9 @@ type diffReq struct {
 // Accepts two body shapes (mutually exclusive):
 //   - `{text, base_clock?, origin?}` — the original text-merge path.
 //     Server computes the minimal diff vs current CRDT state.
-//   - `{update, origin?}` — the Phase H slice 3 raw-update path.
-//     `update` is base64-encoded lib0-v1 Y.Doc update bytes; server
-//     applies them directly.
+//   - `{update, encoding?, origin?}` — the Phase H slice 3 raw-update
+//     path. `update` is base64-encoded Y.Doc update bytes, lib0-v1
+//     unless `encoding` is "v2"; server applies them directly.
 func (h *Handlers) applyDiff(c *fiber.Ctx) error {
 	vaultID, err := capguard.WithVaultID(c)
 	if err != nil {
@@ -1194,7 +1312,20 @@ func (h *Handlers) applyDiff(c *fiber.Ctx) error {
 				"detail": "update is not valid base64",
 			})
 		}
-		r, err := h.svc.ApplyUpdate(c.UserContext(), vaultID, id, updateBytes, origin, uid, c.IP(), string(c.Request().Header.UserAgent()))
+		enc, err := crdt.ParseEncoding(strings.TrimSpace(req.Encoding))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error":  "invalid_body",
+				"detail": "encoding must be v1 or v2",
+			})
+		}
+		if !crdt.SupportsEncoding(enc) {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{
+				"error":  "unsupported_encoding",
+				"detail": enc.String() + " updates are not supported by the " + crdt.Backend + " backend",
+			})
+		}
+		r, err := h.svc.ApplyUpdate(c.UserContext(), vaultID, id, updateBytes, enc, origin, uid, c.IP(), string(c.Request().Header.UserAgent()))
 		if err != nil {
 			if errors.Is(err, errCRDTUnavailable) {
 				return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "crdt_unavailable"})
@@ -1259,6 +1390,8 @@ func mapErr(c *fiber.Ctx, err error) error {
 		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
 	case errors.Is(err, domain.ErrValidation):
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "validation", "detail": err.Error()})
+	case errors.Is(err, errStructuredNote):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "structured_note"})
 	case errors.Is(err, domain.ErrConflict):
 		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "conflict", "detail": err.Error()})
 	case errors.Is(err, domain.ErrPathEscape):
@@ -0,0 +1,95 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+	"github.com/ViniZap4/lumi-server/internal/storage/fs"
+)
+
+func TestTextEditsRejectStructuredNotes(t *testing.T) {
+	fx := newFsckFixture(t)
+	ctx := context.Background()
+	fx.structure(t, "ok")
+	before, _, err := fx.mgr.ReadNote("v", "ok.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fx.svc.ApplyDiff(ctx, fx.vaultID, "ok", "typed over", "tui-diff", uuid.Nil, "", ""); !errors.Is(err, errStructuredNote) {
+		t.Fatalf("ApplyDiff on a structured note: %v, want errStructuredNote", err)
+	}
+	body, path := "typed over", "moved.md"
+	if _, err := fx.svc.Update(ctx, fx.vaultID, "ok", UpdateInput{Body: &body, Path: &path}); !errors.Is(err, errStructuredNote) {
+		t.Fatalf("PATCH body on a structured note: %v, want errStructuredNote", err)
+	}
+	if got := fx.crdtText(t, "ok"); got != "body of ok" {
+		t.Fatalf("content text = %q, want it untouched", got)
+	}
+	after, _, err := fx.mgr.ReadNote("v", "ok.md")
+	if err != nil {
+		t.Fatalf("file moved or lost: %v", err)
+	}
+	if after["updated_at"] != before["updated_at"] {
+		t.Fatal("file rewritten by a rejected edit")
+	}
+
+	if _, err := fx.svc.ApplyDiff(ctx, fx.vaultID, "drift", "plain edit", "tui-diff", uuid.Nil, "", ""); err != nil {
+		t.Fatalf("ApplyDiff on a plain note: %v", err)
+	}
+}
+
+func TestListLeavesOutIgnoredPaths(t *testing.T) {
+	fx := newFsckFixture(t)
+	ctx := context.Background()
+	ids := func(notes []domain.Note) []string {
+		var out []string
+		for _, n := range notes {
+			out = append(out, n.ID)
+		}
+		return out
+	}
+	all, err := fx.svc.List(ctx, fx.vaultID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("without an ignore file: %v, want all four notes", ids(all))
+	}
+
+	ignore := filepath.Join(fx.mgr.Root, "v", filepath.FromSlash(fs.IgnoreFile))
+	if err := os.MkdirAll(filepath.Dir(ignore), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ignore, []byte("drift.md\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := fx.svc.List(ctx, fx.vaultID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range listed {
+		if n.ID == "drift" {
+			t.Fatalf("ignored note listed: %v", ids(listed))
+		}
+	}
+	if len(listed) != 3 {
+		t.Fatalf("listed %v, want the three notes not ignored", ids(listed))
+	}
+	// Offsets count listed notes only, so pages neither skip nor repeat.
+	for i, want := range listed {
+		page, err := fx.svc.List(ctx, fx.vaultID, 1, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].ID != want.ID {
+			t.Fatalf("page %d = %v, want [%s]", i, ids(page), want.ID)
+		}
+	}
+}
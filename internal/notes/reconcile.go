@@ -0,0 +1,278 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/capguard"
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Disk reconciliation ---------------------------------------------------
+
+// The watcher only sees changes made while the server runs. Reconcile
+// catches up on the rest: it walks a vault directory, works out which
+// files changed, appeared or vanished since their rows were last
+// written, and replays each one through the live watcher pipeline so
+// open rooms broadcast the change exactly as if fsnotify had seen it.
+//
+// A file counts as changed when its mtime is past the row's updated_at.
+// lumi's own mirror writes stamp updated_at just before writing the
+// file, so mtimes within reconcileSlack of it are ours. A deep pass
+// also compares every remaining file body with its CRDT text, which
+// catches edits that kept the old mtime (rsync -t, restores).
+
+// reconcileSlack absorbs the gap between a mirror write's updated_at
+// and the file's mtime.
+const reconcileSlack = 2 * time.Second
+
+// Kinds of FSChange.
+const (
+	FSChangeWrite  = "write"
+	FSChangeRemove = "remove"
+	FSChangeMove   = "move"
+)
+
+// FSChange is one on-disk change for the watcher pipeline to apply.
+type FSChange struct {
+	Kind    string
+	Path    string
+	OldPath string // FSChangeMove source
+}
+
+// FSReplayer feeds a change through the same handler live watcher
+// events reach. main adapts the fswatch handler.
+type FSReplayer interface {
+	ReplayFSChange(ctx context.Context, slug string, c FSChange)
+}
+
+// SetFSReplayer wires the watcher pipeline; nil disables Reconcile.
+func (s *Service) SetFSReplayer(r FSReplayer) { s.replayer = r }
+
+// errReconcileUnavailable is returned by Reconcile when no FSReplayer
+// is wired.
+var errReconcileUnavailable = errors.New("notes: filesystem replay is not configured")
+
+// ReconcileMove is a rename done while nobody was watching, detected
+// by the frontmatter id following the file.
+type ReconcileMove struct {
+	NoteID string `json:"note_id"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// ReconcileError is a file Reconcile could not inspect.
+type ReconcileError struct {
+	Path   string `json:"path"`
+	Detail string `json:"detail"`
+}
+
+// ReconcileReport summarises one vault pass. The path lists name what
+// was replayed; the handler logs anything that then fails to apply.
+type ReconcileReport struct {
+	VaultID  uuid.UUID        `json:"vault_id"`
+	Slug     string           `json:"slug"`
+	Deep     bool             `json:"deep"`
+	Notes    int              `json:"notes"`
+	Files    int              `json:"files"`
+	Ignored  int              `json:"ignored"`
+	Changed  []string         `json:"changed"`
+	Appeared []string         `json:"appeared"`
+	Vanished []string         `json:"vanished"`
+	Moved    []ReconcileMove  `json:"moved"`
+	Errors   []ReconcileError `json:"errors"`
+}
+
+// Dirty reports whether the pass replayed anything.
+func (r ReconcileReport) Dirty() bool {
+	return len(r.Changed)+len(r.Appeared)+len(r.Vanished)+len(r.Moved) > 0
+}
+
+// Reconcile brings the vault's rows and CRDT state up to date with its
+// directory. Paths excluded by the vault's .lumi/ignore are skipped on
+// both sides. A missing vault directory is an error rather than a mass
+// delete: an unmounted volume must not empty the vault. Audited when
+// anything was replayed.
+func (s *Service) Reconcile(ctx context.Context, vaultID uuid.UUID, deep bool, actor uuid.UUID, ip, ua string) (ReconcileReport, error) {
+	if s.replayer == nil {
+		return ReconcileReport{}, errReconcileUnavailable
+	}
+	if deep && s.crdt == nil {
+		return ReconcileReport{}, errCRDTUnavailable
+	}
+	v, err := s.vaults.GetByID(ctx, vaultID)
+	if err != nil {
+		return ReconcileReport{}, err
+	}
+	rep := ReconcileReport{
+		VaultID: vaultID, Slug: v.Slug, Deep: deep,
+		Changed: []string{}, Appeared: []string{}, Vanished: []string{},
+		Moved: []ReconcileMove{}, Errors: []ReconcileError{},
+	}
+
+	files, err := s.fs.ListNotes(v.Slug)
+	if err != nil {
+		return rep, err
+	}
+	rep.Files = len(files)
+	onDisk := make(map[string]bool, len(files))
+	for _, rel := range files {
+		onDisk[rel] = true
+	}
+
+	ignore := s.fs.IgnoreRules(v.Slug)
+	known := map[string]bool{}
+	gone := map[string]domain.Note{} // by id
+	for offset := 0; ; offset += copyPageSize {
+		page, err := s.notes.ListForVault(ctx, vaultID, copyPageSize, offset)
+		if err != nil {
+			return rep, err
+		}
+		for _, n := range page {
+			rep.Notes++
+			known[n.Path] = true
+			if ignore.Match(n.Path, false) {
+				// Imported before the rule was added: leave it be
+				// rather than delete it as vanished.
+				rep.Ignored++
+				continue
+			}
+			if !onDisk[n.Path] {
+				gone[n.ID] = n
+				continue
+			}
+			changed, err := s.fileChanged(ctx, v, n, deep)
+			if err != nil {
+				rep.Errors = append(rep.Errors, ReconcileError{Path: n.Path, Detail: err.Error()})
+				continue
+			}
+			if changed {
+				rep.Changed = append(rep.Changed, n.Path)
+			}
+		}
+		if len(page) < copyPageSize {
+			break
+		}
+	}
+
+	for _, rel := range files {
+		if known[rel] {
+			continue
+		}
+		// A renamed file keeps its frontmatter id; pair it with the
+		// vanished row so the note keeps its history.
+		if front, _, err := s.fs.ReadNote(v.Slug, rel); err == nil {
+			if id, _ := front["id"].(string); id != "" {
+				if n, ok := gone[id]; ok {
+					delete(gone, id)
+					rep.Moved = append(rep.Moved, ReconcileMove{NoteID: id, From: n.Path, To: rel})
+					continue
+				}
+			}
+		}
+		rep.Appeared = append(rep.Appeared, rel)
+	}
+	for _, n := range gone {
+		rep.Vanished = append(rep.Vanished, n.Path)
+	}
+	sort.Strings(rep.Vanished)
+
+	// Moves and removals first so an appeared file is not imported
+	// under an id a vanished row still holds.
+	for _, m := range rep.Moved {
+		s.replayer.ReplayFSChange(ctx, v.Slug, FSChange{Kind: FSChangeMove, Path: m.To, OldPath: m.From})
+	}
+	for _, rel := range rep.Vanished {
+		s.replayer.ReplayFSChange(ctx, v.Slug, FSChange{Kind: FSChangeRemove, Path: rel})
+	}
+	for _, rel := range rep.Changed {
+		s.replayer.ReplayFSChange(ctx, v.Slug, FSChange{Kind: FSChangeWrite, Path: rel})
+	}
+	for _, rel := range rep.Appeared {
+		s.replayer.ReplayFSChange(ctx, v.Slug, FSChange{Kind: FSChangeWrite, Path: rel})
+	}
+
+	if rep.Dirty() {
+		s.recordAudit(ctx, actor, vaultID, domain.ActionVaultReconcile, ip, ua, map[string]any{
+			"deep":     deep,
+			"changed":  len(rep.Changed),
+			"appeared": len(rep.Appeared),
+			"vanished": len(rep.Vanished),
+			"moved":    len(rep.Moved),
+		})
+	}
+	return rep, nil
+}
+
+// fileChanged reports whether n's file was edited since the row was
+// last written; with deep set, a file that looks untouched is also
+// compared with the CRDT text.
+func (s *Service) fileChanged(ctx context.Context, v domain.Vault, n domain.Note, deep bool) (bool, error) {
+	abs, err := s.fs.NotePath(v.Slug, n.Path)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().After(n.UpdatedAt.Add(reconcileSlack)) {
+		return true, nil
+	}
+	if !deep {
+		return false, nil
+	}
+	_, raw, err := s.fs.ReadNote(v.Slug, n.Path)
+	if err != nil {
+		return false, err
+	}
+	doc, err := s.crdt.LoadDoc(ctx, v.ID, n.ID)
+	if err != nil {
+		return false, err
+	}
+	defer doc.Close()
+	// Structured notes render their file from the prosemirror
+	// fragment; the watcher ignores edits to them anyway.
+	if structured, err := doc.Structured(); err != nil || structured {
+		return false, err
+	}
+	text, err := doc.Text()
+	if err != nil {
+		return false, err
+	}
+	return text != fileBody(raw), nil
+}
+
+// reconcile — POST /api/vaults/:vault/crdt/reconcile?deep=true
+func (h *Handlers) reconcile(c *fiber.Ctx) error {
+	vaultID, err := capguard.WithVaultID(c)
+	if err != nil {
+		return nil
+	}
+	deep := false
+	if raw := c.Query("deep"); raw != "" {
+		if deep, err = strconv.ParseBool(raw); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_deep"})
+		}
+	}
+	uid, _ := capguard.UserIDFrom(c)
+	rep, err := h.svc.Reconcile(c.UserContext(), vaultID, deep, uid, c.IP(), string(c.Request().Header.UserAgent()))
+	if err != nil {
+		if errors.Is(err, errCRDTUnavailable) {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "crdt_unavailable"})
+		}
+		if errors.Is(err, errReconcileUnavailable) {
+			return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "reconcile_unavailable"})
+		}
+		return mapErr(c, err)
+	}
+	return c.JSON(rep)
+}
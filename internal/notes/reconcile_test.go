@@ -0,0 +1,142 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// replayRecorder is an FSReplayer that records what it was asked to do.
+type replayRecorder struct{ got []FSChange }
+
+func (r *replayRecorder) ReplayFSChange(_ context.Context, _ string, c FSChange) {
+	r.got = append(r.got, c)
+}
+
+func TestReconcileClassifiesAndReplays(t *testing.T) {
+	svc, repo, _, mgr, vaultID := newFSEventsService(t, "keep.md", "edited.md", "gone.md", "old.md")
+	ctx := context.Background()
+	rec := &replayRecorder{}
+	svc.SetFSReplayer(rec)
+	root := filepath.Join(mgr.Root, "v")
+
+	// keep.md was last written by lumi after its file; edited.md's
+	// file is newer than its row.
+	for i, n := range repo.byVault[vaultID] {
+		if n.ID == "keep" {
+			repo.byVault[vaultID][i].UpdatedAt = time.Now().Add(time.Minute)
+		}
+	}
+	if err := os.Remove(filepath.Join(root, "gone.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(root, "old.md"), filepath.Join(root, "new.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dropped.md"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := svc.Reconcile(ctx, vaultID, false, uuid.Nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Notes != 4 || rep.Files != 4 {
+		t.Fatalf("counted %d notes, %d files", rep.Notes, rep.Files)
+	}
+	if !reflect.DeepEqual(rep.Changed, []string{"edited.md"}) ||
+		!reflect.DeepEqual(rep.Appeared, []string{"dropped.md"}) ||
+		!reflect.DeepEqual(rep.Vanished, []string{"gone.md"}) ||
+		!reflect.DeepEqual(rep.Moved, []ReconcileMove{{NoteID: "old", From: "old.md", To: "new.md"}}) {
+		t.Fatalf("report = %+v", rep)
+	}
+	want := []FSChange{
+		{Kind: FSChangeMove, Path: "new.md", OldPath: "old.md"},
+		{Kind: FSChangeRemove, Path: "gone.md"},
+		{Kind: FSChangeWrite, Path: "edited.md"},
+		{Kind: FSChangeWrite, Path: "dropped.md"},
+	}
+	if !reflect.DeepEqual(rec.got, want) {
+		t.Fatalf("replayed %+v, want %+v", rec.got, want)
+	}
+}
+
+func TestReconcileDeepComparesCRDTText(t *testing.T) {
+	svc, repo, _, mgr, vaultID := newFSEventsService(t, "a.md")
+	ctx := context.Background()
+	rec := &replayRecorder{}
+	svc.SetFSReplayer(rec)
+	repo.byVault[vaultID][0].UpdatedAt = time.Now().Add(time.Minute)
+	if err := svc.crdt.InitFromText(ctx, vaultID, "a", "body", uuid.Nil, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	rep, err := svc.Reconcile(ctx, vaultID, true, uuid.Nil, "", "")
+	if err != nil || rep.Dirty() {
+		t.Fatalf("matching text reported dirty: %+v, %v", rep, err)
+	}
+
+	if err := mgr.WriteNote("v", "a.md", map[string]any{"id": "a"}, []byte("restored with old mtime")); err != nil {
+		t.Fatal(err)
+	}
+	if rep, err := svc.Reconcile(ctx, vaultID, false, uuid.Nil, "", ""); err != nil || rep.Dirty() {
+		t.Fatalf("quick pass looked past mtimes: %+v, %v", rep, err)
+	}
+	rep, err = svc.Reconcile(ctx, vaultID, true, uuid.Nil, "", "")
+	if err != nil || !reflect.DeepEqual(rep.Changed, []string{"a.md"}) {
+		t.Fatalf("deep pass = %+v, %v", rep, err)
+	}
+}
+
+func TestReconcileRefusesMissingVaultDir(t *testing.T) {
+	svc, _, _, mgr, vaultID := newFSEventsService(t, "a.md")
+	ctx := context.Background()
+	if _, err := svc.Reconcile(ctx, vaultID, false, uuid.Nil, "", ""); !errors.Is(err, errReconcileUnavailable) {
+		t.Fatalf("without replayer: %v", err)
+	}
+	rec := &replayRecorder{}
+	svc.SetFSReplayer(rec)
+	if err := os.RemoveAll(filepath.Join(mgr.Root, "v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Reconcile(ctx, vaultID, false, uuid.Nil, "", ""); err == nil {
+		t.Fatal("missing vault dir reconciled")
+	}
+	if len(rec.got) != 0 {
+		t.Fatalf("replayed %+v for a missing vault dir", rec.got)
+	}
+}
+
+func TestReconcileLeavesIgnoredPathsAlone(t *testing.T) {
+	svc, _, _, mgr, vaultID := newFSEventsService(t, "drafts/old.md")
+	ctx := context.Background()
+	rec := &replayRecorder{}
+	svc.SetFSReplayer(rec)
+	root := filepath.Join(mgr.Root, "v")
+	if err := os.MkdirAll(filepath.Join(root, ".lumi"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".lumi", "ignore"), []byte("drafts/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// drafts/old.md was imported before the rule; drafts/new.md after.
+	if err := os.WriteFile(filepath.Join(root, "drafts", "new.md"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := svc.Reconcile(ctx, vaultID, false, uuid.Nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Dirty() || rep.Ignored != 1 || len(rec.got) != 0 {
+		t.Fatalf("report = %+v, replayed %+v", rep, rec.got)
+	}
+	if _, err := svc.ImportFromFS(ctx, vaultID, "drafts/new.md"); err == nil {
+		t.Fatal("imported an ignored file")
+	}
+}
@@ -0,0 +1,268 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Claims are the ID token claims lumi uses. Raw keeps every claim so a
+// configurable one (the groups claim) can be read with Strings.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Raw               map[string]any
+}
+
+// Strings reads a claim holding a string or a list of strings.
+func (c Claims) Strings(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Iss               string          `json:"iss"`
+	Sub               string          `json:"sub"`
+	Aud               json.RawMessage `json:"aud"`
+	Azp               string          `json:"azp"`
+	Exp               int64           `json:"exp"`
+	Iat               int64           `json:"iat"`
+	Nbf               int64           `json:"nbf"`
+	Nonce             string          `json:"nonce"`
+	Email             string          `json:"email"`
+	EmailVerified     any             `json:"email_verified"`
+	Name              string          `json:"name"`
+	PreferredUsername string          `json:"preferred_username"`
+}
+
+// VerifyIDToken checks the token's signature against the provider's
+// keys and its iss, aud, azp, exp, iat and nonce claims.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: not a compact JWS", ErrInvalidToken)
+	}
+	var hdr jwtHeader
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	key, err := p.key(ctx, hdr.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifySignature(hdr.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Claims{}, err
+	}
+
+	var c idTokenClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	raws := map[string]any{}
+	_ = decodeSegment(parts[1], &raws)
+
+	now := time.Now()
+	switch {
+	case c.Iss != p.cfg.Issuer:
+		return Claims{}, fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Iss)
+	case c.Sub == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case !audienceContains(c.Aud, p.cfg.ClientID):
+		return Claims{}, fmt.Errorf("%w: audience", ErrInvalidToken)
+	case c.Azp != "" && c.Azp != p.cfg.ClientID:
+		return Claims{}, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, c.Azp)
+	case c.Exp == 0 || now.After(time.Unix(c.Exp, 0).Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.Iat != 0 && time.Unix(c.Iat, 0).After(now.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case c.Nbf != 0 && time.Unix(c.Nbf, 0).After(now.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	verified := false
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string: // some providers send "true"
+		verified = v == "true"
+	}
+	return Claims{
+		Issuer:            c.Iss,
+		Subject:           c.Sub,
+		Email:             c.Email,
+		EmailVerified:     verified,
+		Name:              c.Name,
+		PreferredUsername: c.PreferredUsername,
+		Raw:               raws,
+	}, nil
+}
+
+func decodeSegment(seg string, out any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
+func audienceContains(raw json.RawMessage, clientID string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == clientID
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return slices.Contains(many, clientID)
+	}
+	return false
+}
+
+func verifySignature(alg string, key any, signingInput string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil {
+			return nil
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if ok && len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(pub, sum[:], r, s) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+}
+
+// ---- JWKS ------------------------------------------------------------------
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// key returns the signing key for kid, refetching the JWKS when the kid
+// is unknown (the provider rotated keys) but at most every
+// jwksMinRefresh.
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc: fetch jwks: %w", err)
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// lookup finds kid; a token without kid matches a single-key set.
+func (p *Provider) lookup(kid string) (any, bool) {
+	if k, ok := p.keys[kid]; ok {
+		return k, true
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("bad exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("bad point")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
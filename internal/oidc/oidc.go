@@ -0,0 +1,192 @@
+// Package oidc is a minimal OpenID Connect relying party for the
+// authorization code flow with PKCE (RFC 7636): provider discovery, the
+// authorization URL, the code exchange and ID token verification
+// against the provider's JWKS. It has no dependencies beyond the
+// standard library and supports the signature algorithms providers
+// issue ID tokens with in practice (RS256, ES256).
+//
+// Only the ID token is used. Access and refresh tokens from the
+// provider are discarded: lumi issues its own session once the user's
+// identity is established.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken is wrapped by every ID token rejection.
+var ErrInvalidToken = errors.New("oidc: invalid id token")
+
+const (
+	// clockSkew tolerated on exp/iat/nbf.
+	clockSkew = time.Minute
+	// jwksMinRefresh bounds how often an unknown kid triggers a refetch.
+	jwksMinRefresh = time.Minute
+	maxBody        = 1 << 20
+)
+
+// Config describes the client registration at the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client; PKCE still applies
+	RedirectURL  string
+	Scopes       []string // "openid" is always requested
+	HTTPClient   *http.Client
+}
+
+// Provider is a discovered OpenID provider.
+type Provider struct {
+	cfg           Config
+	client        *http.Client
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu          sync.Mutex
+	keys        map[string]any // kid → *rsa.PublicKey | *ecdsa.PublicKey
+	keysFetched time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Discover fetches the provider's metadata from
+// <issuer>/.well-known/openid-configuration.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client id and redirect url are required")
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p := &Provider{cfg: cfg, client: client}
+
+	var d discovery
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &d); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if d.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match configured %q", d.Issuer, cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: provider metadata is incomplete")
+	}
+	p.authEndpoint, p.tokenEndpoint, p.jwksURI = d.AuthorizationEndpoint, d.TokenEndpoint, d.JWKSURI
+	return p, nil
+}
+
+// Issuer returns the provider's issuer identifier.
+func (p *Provider) Issuer() string { return p.cfg.Issuer }
+
+// RandomString returns a URL-safe random value for state, nonce or a
+// PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("oidc: read random: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL is where to send the browser to log in.
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	scopes := []string{"openid"}
+	for _, s := range p.cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + q.Encode()
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("oidc: exchange: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc: exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return "", fmt.Errorf("oidc: exchange: %w", err)
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	_ = json.Unmarshal(body, &tok)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc: exchange: provider answered %d %s", resp.StatusCode, tok.Error)
+	}
+	if tok.IDToken == "" {
+		return "", errors.New("oidc: exchange: response has no id_token")
+	}
+	return tok.IDToken, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxBody)).Decode(out)
+}
@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIdP is a local OpenID provider: it "logs in" whoever the test
+// says, remembers the PKCE challenge and nonce per code, and signs ID
+// tokens with an RSA key published at its JWKS endpoint.
+type mockIdP struct {
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	codes  map[string]pendingCode
+	claims map[string]any // extra claims for the next token
+}
+
+type pendingCode struct {
+	challenge, nonce, sub string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIdP{key: key, codes: map[string]pendingCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "lumi" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		m.mu.Lock()
+		pc, ok := m.codes[r.PostFormValue("code")]
+		delete(m.codes, r.PostFormValue("code"))
+		m.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != pc.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		claims := map[string]any{
+			"iss": m.srv.URL, "sub": pc.sub, "aud": "lumi", "nonce": pc.nonce,
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+			"preferred_username": "ada", "groups": []string{"eng"},
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(t, claims), "token_type": "Bearer"})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+// authorize plays the browser and the provider's login page: it reads
+// the authorization URL and returns the code the redirect would carry.
+func (m *mockIdP) authorize(t *testing.T, authURL, sub string) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != "https://lumi.example/oidc" {
+		t.Fatalf("authorization url = %s", authURL)
+	}
+	code, _ = RandomString()
+	m.mu.Lock()
+	m.codes[code] = pendingCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), sub: sub}
+	m.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (m *mockIdP) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	sum := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func discover(t *testing.T, m *mockIdP) *Provider {
+	t.Helper()
+	p, err := Discover(context.Background(), Config{
+		Issuer: m.srv.URL, ClientID: "lumi", ClientSecret: "s3cret",
+		RedirectURL: "https://lumi.example/oidc", Scopes: []string{"profile"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCodeFlowWithPKCE(t *testing.T) {
+	m := newMockIdP(t)
+	p := discover(t, m)
+	ctx := context.Background()
+
+	state, _ := RandomString()
+	nonce, _ := RandomString()
+	verifier, _ := RandomString()
+	code, gotState := m.authorize(t, p.AuthCodeURL(state, nonce, verifier), "user-42")
+	if gotState != state {
+		t.Fatalf("state = %q", gotState)
+	}
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	claims, err := p.VerifyIDToken(ctx, raw, nonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims.Subject != "user-42" || claims.PreferredUsername != "ada" || len(claims.Strings("groups")) != 1 {
+		t.Fatalf("claims = %+v", claims)
+	}
+
+	// The code is single-use and bound to the verifier.
+	if _, err := p.Exchange(ctx, code, verifier); err == nil {
+		t.Fatal("code redeemed twice")
+	}
+	code, _ = m.authorize(t, p.AuthCodeURL(state, nonce, verifier), "user-42")
+	if _, err := p.Exchange(ctx, code, "not-the-verifier"); err == nil {
+		t.Fatal("code redeemed without the PKCE verifier")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockIdP(t)
+	p := discover(t, m)
+	now := time.Now()
+	base := func() map[string]any {
+		return map[string]any{"iss": m.srv.URL, "sub": "u", "aud": "lumi", "nonce": "n", "exp": now.Add(time.Hour).Unix()}
+	}
+	cases := []struct {
+		name  string
+		edit  func(map[string]any)
+		nonce string
+	}{
+		{"wrong nonce", func(map[string]any) {}, "other"},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "someone-else" }, "n"},
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example" }, "n"},
+		{"expired", func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() }, "n"},
+		{"foreign azp", func(c map[string]any) { c["aud"] = []string{"lumi", "x"}; c["azp"] = "x" }, "n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := base()
+			tc.edit(c)
+			if _, err := p.VerifyIDToken(context.Background(), m.sign(t, c), tc.nonce); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("err = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+
+	tok := m.sign(t, base())
+	tampered := tok[:len(tok)-4] + "AAAA"
+	if _, err := p.VerifyIDToken(context.Background(), tampered, "n"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("tampered signature: err = %v", err)
+	}
+	if _, err := p.VerifyIDToken(context.Background(), tok, "n"); err != nil {
+		t.Fatalf("untouched token: %v", err)
+	}
+}
@@ -37,29 +37,6 @@ type Repo interface {
 // nameRe enforces the role-name grammar from SPEC.md.
 var nameRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9 _-]{0,30}$`)
 
-var knownCaps = map[domain.Capability]struct{}{
-	domain.CapNoteRead:      {},
-	domain.CapNoteCreate:    {},
-	domain.CapNoteEdit:      {},
-	domain.CapNoteDelete:    {},
-	domain.CapNoteMove:      {},
-	domain.CapMembersInvite: {},
-	domain.CapMembersManage: {},
-	domain.CapRolesManage:   {},
-	domain.CapVaultManage:   {},
-	domain.CapVaultExport:   {},
-	domain.CapVaultFederate: {},
-	domain.CapAuditRead:     {},
-}
-
-var knownWildcardPrefixes = map[string]struct{}{
-	"note.":    {},
-	"members.": {},
-	"roles.":   {},
-	"vault.":   {},
-	"audit.":   {},
-}
-
 // Service is the business-logic layer.
 type Service struct {
 	repo          Repo
@@ -252,20 +229,13 @@ func validateCapability(c domain.Capability) error {
 	if s == "" {
 		return fmt.Errorf("%w: empty capability string", domain.ErrValidation)
 	}
-	if s == "*" {
+	if c.Known() {
 		return nil
 	}
 	if strings.HasSuffix(s, ".*") {
-		prefix := strings.TrimSuffix(s, "*")
-		if _, ok := knownWildcardPrefixes[prefix]; !ok {
-			return fmt.Errorf("%w: unknown wildcard capability %q", domain.ErrValidation, s)
-		}
-		return nil
+		return fmt.Errorf("%w: unknown wildcard capability %q", domain.ErrValidation, s)
 	}
-	if _, ok := knownCaps[c]; !ok {
-		return fmt.Errorf("%w: unknown capability %q", domain.ErrValidation, s)
-	}
-	return nil
+	return fmt.Errorf("%w: unknown capability %q", domain.ErrValidation, s)
 }
 
 func dedupeCaps(in domain.CapabilitySet) domain.CapabilitySet {
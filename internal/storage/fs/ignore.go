@@ -0,0 +1,178 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IgnoreFile is the vault-relative path of the per-vault ignore rules.
+// The syntax is gitignore's: `#` comments, `!` negation, a trailing `/`
+// for directories only, a leading or inner `/` to anchor at the vault
+// root, and `*`, `?`, `[...]` and `**` wildcards. As in git, a file
+// inside an ignored directory cannot be re-included.
+const IgnoreFile = ".lumi/ignore"
+
+// IgnoreRules is a parsed ignore file. The nil value ignores nothing.
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	segs    []string
+	negate  bool
+	dirOnly bool
+}
+
+// ParseIgnore parses gitignore-style rules. Malformed patterns never
+// match rather than failing the whole file.
+func ParseIgnore(data []byte) *IgnoreRules {
+	r := &IgnoreRules{}
+	for _, line := range strings.Split(string(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // \# and \! escape a leading metacharacter
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segs = strings.Split(line, "/")
+		if !anchored {
+			rule.segs = append([]string{"**"}, rule.segs...)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	return r
+}
+
+// Match reports whether the slash-separated vault-relative path is
+// ignored. isDir says whether rel names a directory.
+func (r *IgnoreRules) Match(rel string, isDir bool) bool {
+	if r == nil || len(r.rules) == 0 {
+		return false
+	}
+	segs := strings.Split(strings.Trim(path.Clean("/"+rel), "/"), "/")
+	// Walk down from the top: once a directory is ignored everything
+	// beneath it is.
+	for k := 1; k < len(segs); k++ {
+		if r.matchOne(segs[:k], true) {
+			return true
+		}
+	}
+	return r.matchOne(segs, isDir)
+}
+
+// matchOne applies the rules to one path; the last match wins.
+func (r *IgnoreRules) matchOne(segs []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegs(rule.segs, segs) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegs matches path segments against pattern segments, where "**"
+// spans any number of segments (at least one when it ends the pattern).
+func matchSegs(pat, segs []string) bool {
+	if len(pat) == 0 {
+		return len(segs) == 0
+	}
+	if pat[0] == "**" {
+		if len(pat) == 1 {
+			return len(segs) > 0
+		}
+		for i := 0; i <= len(segs); i++ {
+			if matchSegs(pat[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pat[0], segs[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegs(pat[1:], segs[1:])
+}
+
+// ---- Per-vault cache -------------------------------------------------------
+
+// ignoreCache remembers a vault's parsed rules with the stat of the
+// file they came from.
+type ignoreCache struct {
+	mu      sync.Mutex
+	byVault map[string]ignoreEntry
+}
+
+type ignoreEntry struct {
+	mtime time.Time
+	size  int64
+	rules *IgnoreRules
+}
+
+// IgnoreRules returns the vault's current rules. The file is stat'ed on
+// every call and re-read when its mtime or size moved, so edits apply
+// without a restart; callers matching many paths should fetch the rules
+// once. A missing file ignores nothing; an unreadable one keeps the
+// last rules read.
+func (m *Manager) IgnoreRules(slug string) *IgnoreRules {
+	p, err := SafeJoin(m.Root, filepath.Join(slug, filepath.FromSlash(IgnoreFile)))
+	if err != nil {
+		return nil
+	}
+	c := &m.ignores
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, cached := c.byVault[slug]
+	info, err := os.Stat(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			delete(c.byVault, slug)
+			return nil
+		}
+		return prev.rules
+	}
+	if cached && info.ModTime().Equal(prev.mtime) && info.Size() == prev.size {
+		return prev.rules
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return prev.rules
+	}
+	if c.byVault == nil {
+		c.byVault = make(map[string]ignoreEntry)
+	}
+	e := ignoreEntry{mtime: info.ModTime(), size: info.Size(), rules: ParseIgnore(data)}
+	c.byVault[slug] = e
+	return e.rules
+}
+
+// Ignored reports whether rel is excluded by the vault's ignore file.
+func (m *Manager) Ignored(slug, rel string, isDir bool) bool {
+	return m.IgnoreRules(slug).Match(rel, isDir)
+}
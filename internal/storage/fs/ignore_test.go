@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIgnoreRulesMatch(t *testing.T) {
+	rules := ParseIgnore([]byte(`
+# generated
+drafts/
+.obsidian
+/exports/*.md
+!exports/keep.md
+**/tmp/**
+a/**/z.md
+*.bak.md
+\#literal.md
+`))
+	cases := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"drafts", true, true},
+		{"drafts/x.md", false, true},
+		{"notes/drafts/deep/x.md", false, true},
+		{"drafts", false, false}, // dir-only rule, file named drafts
+		{".obsidian/workspace.md", false, true},
+		{"exports/report.md", false, true},
+		{"exports/keep.md", false, false},
+		{"sub/exports/report.md", false, false}, // anchored
+		{"x/tmp/y.md", false, true},
+		{"tmp", true, false}, // trailing ** needs something inside
+		{"a/z.md", false, true},
+		{"a/b/c/z.md", false, true},
+		{"b/z.md", false, false},
+		{"old.bak.md", false, true},
+		{"#literal.md", false, true},
+		{"note.md", false, false},
+	}
+	for _, c := range cases {
+		if got := rules.Match(c.rel, c.isDir); got != c.want {
+			t.Errorf("Match(%q, dir=%v) = %v, want %v", c.rel, c.isDir, got, c.want)
+		}
+	}
+	// As in git, a parent directory's exclusion cannot be undone.
+	rules = ParseIgnore([]byte("drafts/\n!drafts/keep.md\n"))
+	if !rules.Match("drafts/keep.md", false) {
+		t.Error("negation re-included a file inside an ignored directory")
+	}
+	var none *IgnoreRules
+	if none.Match("anything.md", false) {
+		t.Error("nil rules matched")
+	}
+}
+
+func TestManagerIgnoreReloadAndListNotes(t *testing.T) {
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.EnsureVaultDir("v"); err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"a.md", "drafts/b.md", "exports/c.md"} {
+		if err := m.WriteNote("v", rel, nil, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignorePath := filepath.Join(m.Root, "v", filepath.FromSlash(IgnoreFile))
+	if err := os.MkdirAll(filepath.Dir(ignorePath), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ignorePath, []byte("drafts/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.ListNotes("v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.md", "exports/c.md"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ListNotes = %v, want %v", got, want)
+	}
+
+	// Edits apply on the next lookup.
+	if err := os.WriteFile(ignorePath, []byte("exports/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(ignorePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if m.Ignored("v", "drafts/b.md", false) || !m.Ignored("v", "exports/c.md", false) {
+		t.Fatal("ignore file edit not picked up")
+	}
+	if err := os.Remove(ignorePath); err != nil {
+		t.Fatal(err)
+	}
+	if m.Ignored("v", "exports/c.md", false) {
+		t.Fatal("rules outlived the ignore file")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ViniZap4/lumi-server/internal/domain"
 )
@@ -109,3 +110,62 @@ func (m *Manager) MoveNote(slug, oldPath, newPath string) error {
 	}
 	return nil
 }
+
+// ListNotes walks the vault directory and returns the slash-separated
+// relative path of every markdown note, in lexical order. Dot-prefixed
+// files and directories (`.lumi`, `.git`, editor swap files) are skipped,
+// as is anything not ending in `.md` — which also excludes in-flight
+// AtomicWrite temp files. Paths excluded by the vault's IgnoreFile are
+// left out too.
+func (m *Manager) ListNotes(slug string) ([]string, error) {
+	if slug == "" {
+		return nil, fmt.Errorf("%w: empty slug", domain.ErrValidation)
+	}
+	vaultDir, err := SafeJoin(m.Root, slug)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Clean(vaultDir) == filepath.Clean(m.Root) {
+		return nil, fmt.Errorf("%w: slug %q resolves to root", domain.ErrPathEscape, slug)
+	}
+	ignore := m.IgnoreRules(slug)
+	var out []string
+	err = filepath.WalkDir(vaultDir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == vaultDir {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(vaultDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if ignore.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasSuffix(name, ".md") || ignore.Match(rel, false) {
+			return nil
+		}
+		out = append(out, rel)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: vault %q has no directory", domain.ErrNotFound, slug)
+		}
+		return nil, fmt.Errorf("storage/fs: list notes: %w", err)
+	}
+	return out, nil
+}
@@ -196,3 +196,39 @@ func TestManager_RemoveVaultDir_RefusesToDeleteRoot(t *testing.T) {
 		// Either ErrPathEscape (root resolve) or ErrValidation is fine.
 	}
 }
+
+func TestManager_ListNotes(t *testing.T) {
+	mgr, root := newTestManager(t)
+	for _, p := range []string{"b.md", "a.md", "sub/c.md", "sub/deep/d.md"} {
+		if err := mgr.WriteNote("vault", p, nil, []byte("x")); err != nil {
+			t.Fatalf("WriteNote(%q): %v", p, err)
+		}
+	}
+	// Noise that must not be listed.
+	for _, p := range []string{".hidden.md", "notes.txt", "a.md.tmp.0123", ".lumi/vault.md", ".git/x.md"} {
+		abs := filepath.Join(root, "vault", filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := mgr.ListNotes("vault")
+	if err != nil {
+		t.Fatalf("ListNotes: %v", err)
+	}
+	want := []string{"a.md", "b.md", "sub/c.md", "sub/deep/d.md"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("ListNotes = %v, want %v", got, want)
+	}
+
+	if _, err := mgr.ListNotes("missing"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("missing vault: want ErrNotFound, got %v", err)
+	}
+	for _, slug := range []string{"", ".", "..", "/abs"} {
+		if _, err := mgr.ListNotes(slug); err == nil {
+			t.Fatalf("ListNotes(%q) should fail", slug)
+		}
+	}
+}
@@ -59,6 +59,8 @@ type RoleSnapshot struct {
 // needs. Safe for concurrent use.
 type Manager struct {
 	Root string
+
+	ignores ignoreCache
 }
 
 func NewManager(root string) (*Manager, error) {
@@ -134,6 +136,39 @@ func (m *Manager) RemoveVaultDir(slug string) error {
 	return nil
 }
 
+// DiskUsage sums the sizes of the regular files under a vault's
+// directory, .lumi included. Symlinks are not followed.
+func (m *Manager) DiskUsage(slug string) (int64, error) {
+	if slug == "" {
+		return 0, fmt.Errorf("%w: empty slug", domain.ErrValidation)
+	}
+	vaultDir, err := SafeJoin(m.Root, slug)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	err = filepath.WalkDir(vaultDir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return nil // removed mid-walk
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("storage/fs: disk usage: %w", err)
+	}
+	return total, nil
+}
+
 func (m *Manager) vaultYAMLPath(slug string) (string, error) {
 	vaultDir, err := SafeJoin(m.Root, slug)
 	if err != nil {
@@ -0,0 +1,118 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// APITokenStore persists personal access tokens.
+type APITokenStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewAPITokenStore(pool *pgxpool.Pool) *APITokenStore {
+	return &APITokenStore{pool: pool}
+}
+
+const apiTokenColumns = `id, user_id, name, token_hash, vault_id, capabilities, created_at, expires_at, last_used_at`
+
+// CreateAPIToken inserts a new token row.
+func (s *APITokenStore) CreateAPIToken(ctx context.Context, t domain.APIToken) error {
+	caps, err := marshalCaps(t.Capabilities)
+	if err != nil {
+		return fmt.Errorf("api token store: create: marshal caps: %w", err)
+	}
+	const q = `
+INSERT INTO api_tokens (` + apiTokenColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $8, $9)`
+	_, err = s.pool.Exec(ctx, q,
+		t.ID, t.UserID, t.Name, t.TokenHash, t.VaultID, caps, t.CreatedAt, t.ExpiresAt, t.LastUsedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("api token store: create: %w", errMap(err))
+	}
+	return nil
+}
+
+// GetAPITokenByHash looks a token up by the hash of its secret. Expired
+// rows are returned; the caller decides.
+func (s *APITokenStore) GetAPITokenByHash(ctx context.Context, hash string) (domain.APIToken, error) {
+	const q = `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = $1`
+	t, err := scanAPIToken(s.pool.QueryRow(ctx, q, hash))
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.APIToken{}, fmt.Errorf("api token store: get: %w", domain.ErrNotFound)
+		}
+		return domain.APIToken{}, fmt.Errorf("api token store: get: %w", errMap(err))
+	}
+	return t, nil
+}
+
+// ListAPITokens returns the user's tokens, newest first.
+func (s *APITokenStore) ListAPITokens(ctx context.Context, userID uuid.UUID) ([]domain.APIToken, error) {
+	const q = `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := s.pool.Query(ctx, q, userID)
+	if err != nil {
+		return nil, fmt.Errorf("api token store: list: %w", errMap(err))
+	}
+	defer rows.Close()
+	out := []domain.APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("api token store: list scan: %w", err)
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("api token store: list: %w", errMap(err))
+	}
+	return out, nil
+}
+
+// TouchAPIToken records a use of the token.
+func (s *APITokenStore) TouchAPIToken(ctx context.Context, id uuid.UUID, at time.Time) error {
+	const q = `UPDATE api_tokens SET last_used_at = $2 WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, at); err != nil {
+		return fmt.Errorf("api token store: touch: %w", errMap(err))
+	}
+	return nil
+}
+
+// DeleteAPIToken removes one of the user's tokens. ErrNotFound when the
+// id does not exist or belongs to someone else.
+func (s *APITokenStore) DeleteAPIToken(ctx context.Context, userID, id uuid.UUID) (domain.APIToken, error) {
+	const q = `DELETE FROM api_tokens WHERE id = $1 AND user_id = $2 RETURNING ` + apiTokenColumns
+	t, err := scanAPIToken(s.pool.QueryRow(ctx, q, id, userID))
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.APIToken{}, fmt.Errorf("api token store: delete: %w", domain.ErrNotFound)
+		}
+		return domain.APIToken{}, fmt.Errorf("api token store: delete: %w", errMap(err))
+	}
+	return t, nil
+}
+
+func scanAPIToken(r rowScanner) (domain.APIToken, error) {
+	var (
+		t    domain.APIToken
+		caps []byte
+	)
+	if err := r.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.VaultID, &caps,
+		&t.CreatedAt, &t.ExpiresAt, &t.LastUsedAt); err != nil {
+		return domain.APIToken{}, err
+	}
+	cs, err := unmarshalCaps(caps)
+	if err != nil {
+		return domain.APIToken{}, fmt.Errorf("decode capabilities: %w", err)
+	}
+	t.Capabilities = cs
+	return t, nil
+}
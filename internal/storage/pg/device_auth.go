@@ -0,0 +1,118 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// DeviceAuthStore persists device authorization grants.
+type DeviceAuthStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewDeviceAuthStore(pool *pgxpool.Pool) *DeviceAuthStore {
+	return &DeviceAuthStore{pool: pool}
+}
+
+const deviceAuthColumns = `device_code_hash, user_code, client_name, ip, user_agent, created_at, expires_at,
+       last_polled_at, user_id, approved_at, denied_at`
+
+// CreateDeviceAuthorization stores a new grant and drops expired ones.
+// A user code collision surfaces as ErrConflict.
+func (s *DeviceAuthStore) CreateDeviceAuthorization(ctx context.Context, d domain.DeviceAuthorization) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM device_authorizations WHERE expires_at <= NOW()`); err != nil {
+			return fmt.Errorf("device auth store: purge: %w", errMap(err))
+		}
+		const q = `
+INSERT INTO device_authorizations (device_code_hash, user_code, client_name, ip, user_agent, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		_, err := tx.Exec(ctx, q, d.DeviceCodeHash, d.UserCode, d.ClientName,
+			nullableString(d.IP), nullableString(d.UserAgent), d.CreatedAt, d.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("device auth store: create: %w", errMap(err))
+		}
+		return nil
+	})
+}
+
+// GetPendingDeviceAuthorization returns the undecided, unexpired grant
+// with userCode. ErrNotFound otherwise.
+func (s *DeviceAuthStore) GetPendingDeviceAuthorization(ctx context.Context, userCode string) (domain.DeviceAuthorization, error) {
+	const q = `
+SELECT ` + deviceAuthColumns + `
+  FROM device_authorizations
+ WHERE user_code = $1 AND approved_at IS NULL AND denied_at IS NULL AND expires_at > NOW()`
+	return s.one(ctx, "get pending", q, userCode)
+}
+
+// DecideDeviceAuthorization approves or denies the pending grant with
+// userCode on userID's behalf and returns it. ErrNotFound when there is
+// no such undecided, unexpired grant.
+func (s *DeviceAuthStore) DecideDeviceAuthorization(ctx context.Context, userCode string, userID uuid.UUID, approve bool, at time.Time) (domain.DeviceAuthorization, error) {
+	const q = `
+UPDATE device_authorizations
+   SET user_id = $2,
+       approved_at = CASE WHEN $3 THEN $4::timestamptz END,
+       denied_at = CASE WHEN $3 THEN NULL ELSE $4::timestamptz END
+ WHERE user_code = $1 AND approved_at IS NULL AND denied_at IS NULL AND expires_at > $4
+RETURNING ` + deviceAuthColumns
+	return s.one(ctx, "decide", q, userCode, userID, approve, at)
+}
+
+// PollDeviceAuthorization records a poll at at and returns the grant as
+// it was before, so the caller can tell how long ago the previous poll
+// was. Expired grants are returned too.
+func (s *DeviceAuthStore) PollDeviceAuthorization(ctx context.Context, deviceCodeHash string, at time.Time) (domain.DeviceAuthorization, error) {
+	const q = `
+WITH old AS (
+  SELECT ` + deviceAuthColumns + `
+    FROM device_authorizations
+   WHERE device_code_hash = $1
+     FOR UPDATE
+)
+UPDATE device_authorizations d
+   SET last_polled_at = $2
+  FROM old
+ WHERE d.device_code_hash = old.device_code_hash
+RETURNING old.device_code_hash, old.user_code, old.client_name, old.ip, old.user_agent, old.created_at,
+          old.expires_at, old.last_polled_at, old.user_id, old.approved_at, old.denied_at`
+	return s.one(ctx, "poll", q, deviceCodeHash, at)
+}
+
+// TakeDeviceAuthorization removes and returns a grant, so its outcome
+// is collected at most once.
+func (s *DeviceAuthStore) TakeDeviceAuthorization(ctx context.Context, deviceCodeHash string) (domain.DeviceAuthorization, error) {
+	const q = `DELETE FROM device_authorizations WHERE device_code_hash = $1 RETURNING ` + deviceAuthColumns
+	return s.one(ctx, "take", q, deviceCodeHash)
+}
+
+func (s *DeviceAuthStore) one(ctx context.Context, op, q string, args ...any) (domain.DeviceAuthorization, error) {
+	var (
+		d      domain.DeviceAuthorization
+		ip, ua *string
+	)
+	err := s.pool.QueryRow(ctx, q, args...).Scan(&d.DeviceCodeHash, &d.UserCode, &d.ClientName, &ip, &ua,
+		&d.CreatedAt, &d.ExpiresAt, &d.LastPolledAt, &d.UserID, &d.ApprovedAt, &d.DeniedAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.DeviceAuthorization{}, fmt.Errorf("device auth store: %s: %w", op, domain.ErrNotFound)
+		}
+		return domain.DeviceAuthorization{}, fmt.Errorf("device auth store: %s: %w", op, errMap(err))
+	}
+	if ip != nil {
+		d.IP = *ip
+	}
+	if ua != nil {
+		d.UserAgent = *ua
+	}
+	return d, nil
+}
@@ -156,9 +156,24 @@ SELECT token, vault_id, inviter_user_id, role_id, email_hint,
   FROM invites
  WHERE vault_id = $1
  ORDER BY created_at DESC`
-	rows, err := s.pool.Query(ctx, q, vaultID)
+	return s.list(ctx, "list", q, vaultID)
+}
+
+// ListByInviter returns the invites userID issued, in every vault.
+func (s *InviteStore) ListByInviter(ctx context.Context, userID uuid.UUID) ([]domain.Invite, error) {
+	const q = `
+SELECT token, vault_id, inviter_user_id, role_id, email_hint,
+       max_uses, use_count, expires_at, created_at, revoked_at
+  FROM invites
+ WHERE inviter_user_id = $1
+ ORDER BY created_at DESC`
+	return s.list(ctx, "list by inviter", q, userID)
+}
+
+func (s *InviteStore) list(ctx context.Context, op, q string, args ...any) ([]domain.Invite, error) {
+	rows, err := s.pool.Query(ctx, q, args...)
 	if err != nil {
-		return nil, fmt.Errorf("invite store: list: %w", errMap(err))
+		return nil, fmt.Errorf("invite store: %s: %w", op, errMap(err))
 	}
 	defer rows.Close()
 
@@ -172,7 +187,7 @@ SELECT token, vault_id, inviter_user_id, role_id, email_hint,
 			&inv.Token, &inv.VaultID, &inv.InviterUserID, &inv.RoleID, &emailHint,
 			&inv.MaxUses, &inv.UseCount, &inv.ExpiresAt, &inv.CreatedAt, &inv.RevokedAt,
 		); err != nil {
-			return nil, fmt.Errorf("invite store: list scan: %w", err)
+			return nil, fmt.Errorf("invite store: %s scan: %w", op, err)
 		}
 		if emailHint != nil {
 			inv.EmailHint = *emailHint
@@ -180,7 +195,7 @@ SELECT token, vault_id, inviter_user_id, role_id, email_hint,
 		out = append(out, inv)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("invite store: list rows: %w", err)
+		return nil, fmt.Errorf("invite store: %s rows: %w", op, err)
 	}
 	return out, nil
 }
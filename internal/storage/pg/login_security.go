@@ -0,0 +1,141 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// LoginSecurityStore persists each user's known devices and networks and
+// the security events raised against them.
+type LoginSecurityStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewLoginSecurityStore(pool *pgxpool.Pool) *LoginSecurityStore {
+	return &LoginSecurityStore{pool: pool}
+}
+
+// LoginBaseline reports whether fingerprint and network have been seen
+// for userID before, and where the user last logged in from.
+func (s *LoginSecurityStore) LoginBaseline(ctx context.Context, userID uuid.UUID, fingerprint, network string) (domain.LoginBaseline, error) {
+	const q = `
+SELECT (SELECT COUNT(*) FROM known_devices WHERE user_id = $1),
+       EXISTS (SELECT 1 FROM known_devices WHERE user_id = $1 AND fingerprint = $2),
+       EXISTS (SELECT 1 FROM known_networks WHERE user_id = $1 AND network = $3)`
+	var b domain.LoginBaseline
+	if err := s.pool.QueryRow(ctx, q, userID, fingerprint, network).Scan(&b.Devices, &b.DeviceKnown, &b.NetworkKnown); err != nil {
+		return domain.LoginBaseline{}, fmt.Errorf("login security store: baseline: %w", errMap(err))
+	}
+
+	const lastQ = `
+SELECT network, last_ip, latitude, longitude, last_seen_at
+  FROM known_networks
+ WHERE user_id = $1 AND latitude IS NOT NULL
+ ORDER BY last_seen_at DESC
+ LIMIT 1`
+	last := domain.LoginSighting{UserID: userID, Located: true}
+	err := s.pool.QueryRow(ctx, lastQ, userID).Scan(&last.Network, &last.IP, &last.Latitude, &last.Longitude, &last.At)
+	switch {
+	case errors.Is(errMap(err), domain.ErrNotFound):
+	case err != nil:
+		return domain.LoginBaseline{}, fmt.Errorf("login security store: last location: %w", errMap(err))
+	default:
+		b.LastLocated = &last
+	}
+	return b, nil
+}
+
+// RememberLogin records a successful login's device and network, adding
+// them or bumping their last-seen time.
+func (s *LoginSecurityStore) RememberLogin(ctx context.Context, l domain.LoginSighting) error {
+	var lat, lon any
+	if l.Located {
+		lat, lon = l.Latitude, l.Longitude
+	}
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		const devQ = `
+INSERT INTO known_devices (user_id, fingerprint, user_agent, first_seen_at, last_seen_at)
+VALUES ($1, $2, $3, $4, $4)
+ON CONFLICT (user_id, fingerprint)
+DO UPDATE SET user_agent = EXCLUDED.user_agent, last_seen_at = EXCLUDED.last_seen_at`
+		if _, err := tx.Exec(ctx, devQ, l.UserID, l.Fingerprint, l.UserAgent, l.At); err != nil {
+			return fmt.Errorf("login security store: remember device: %w", errMap(err))
+		}
+		if l.Network == "" {
+			return nil
+		}
+		const netQ = `
+INSERT INTO known_networks (user_id, network, last_ip, latitude, longitude, first_seen_at, last_seen_at)
+VALUES ($1, $2, $3, $4, $5, $6, $6)
+ON CONFLICT (user_id, network)
+DO UPDATE SET last_ip = EXCLUDED.last_ip,
+              latitude = COALESCE(EXCLUDED.latitude, known_networks.latitude),
+              longitude = COALESCE(EXCLUDED.longitude, known_networks.longitude),
+              last_seen_at = EXCLUDED.last_seen_at`
+		if _, err := tx.Exec(ctx, netQ, l.UserID, l.Network, l.IP, lat, lon, l.At); err != nil {
+			return fmt.Errorf("login security store: remember network: %w", errMap(err))
+		}
+		return nil
+	})
+}
+
+// RecordSecurityEvent appends an event to the user's security log.
+func (s *LoginSecurityStore) RecordSecurityEvent(ctx context.Context, e domain.SecurityEvent) error {
+	const q = `
+INSERT INTO security_events (id, user_id, kind, ip, user_agent, details, created_at)
+VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), COALESCE($6::jsonb, '{}'), $7)`
+	var details any
+	if len(e.Details) > 0 {
+		details = string(e.Details)
+	}
+	if _, err := s.pool.Exec(ctx, q, e.ID, e.UserID, e.Kind, e.IP, e.UserAgent, details, e.CreatedAt); err != nil {
+		return fmt.Errorf("login security store: record event: %w", errMap(err))
+	}
+	return nil
+}
+
+// ListSecurityEvents returns the user's newest events first.
+func (s *LoginSecurityStore) ListSecurityEvents(ctx context.Context, userID uuid.UUID, limit int) ([]domain.SecurityEvent, error) {
+	const q = `
+SELECT id, user_id, kind, COALESCE(ip, ''), COALESCE(user_agent, ''), details, created_at
+  FROM security_events
+ WHERE user_id = $1
+ ORDER BY created_at DESC
+ LIMIT $2`
+	rows, err := s.pool.Query(ctx, q, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("login security store: list events: %w", errMap(err))
+	}
+	defer rows.Close()
+	var out []domain.SecurityEvent
+	for rows.Next() {
+		var e domain.SecurityEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Kind, &e.IP, &e.UserAgent, &e.Details, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("login security store: scan event: %w", errMap(err))
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("login security store: list events: %w", errMap(err))
+	}
+	return out, nil
+}
+
+// CountLoginFailures counts the user's failed logins in the audit log
+// since the given time.
+func (s *LoginSecurityStore) CountLoginFailures(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	const q = `SELECT COUNT(*) FROM audit_log WHERE user_id = $1 AND action = $2 AND created_at >= $3`
+	var n int
+	if err := s.pool.QueryRow(ctx, q, userID, domain.ActionAuthLoginFailed, since).Scan(&n); err != nil {
+		return 0, fmt.Errorf("login security store: count failures: %w", errMap(err))
+	}
+	return n, nil
+}
@@ -0,0 +1,105 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// MailOutboxStore persists the outgoing email queue.
+type MailOutboxStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewMailOutboxStore(pool *pgxpool.Pool) *MailOutboxStore {
+	return &MailOutboxStore{pool: pool}
+}
+
+func (s *MailOutboxStore) EnqueueMail(ctx context.Context, m domain.OutboxMail) error {
+	const q = `
+INSERT INTO mail_outbox (id, recipient, subject, body, template, next_attempt_at, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := s.pool.Exec(ctx, q, m.ID, m.Recipient, m.Subject, m.Body, m.Template, m.NextAttemptAt, m.CreatedAt); err != nil {
+		return fmt.Errorf("mail outbox store: enqueue: %w", errMap(err))
+	}
+	return nil
+}
+
+// ClaimMail takes up to limit pending messages due at now, counts the
+// attempt and hides them from other workers for lease. A worker that
+// dies mid-send leaves its claim to expire, so the message is retried.
+func (s *MailOutboxStore) ClaimMail(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]domain.OutboxMail, error) {
+	const q = `
+UPDATE mail_outbox
+   SET attempts = attempts + 1, next_attempt_at = $2
+ WHERE id IN (
+       SELECT id FROM mail_outbox
+        WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1
+        ORDER BY next_attempt_at
+        LIMIT $3
+          FOR UPDATE SKIP LOCKED)
+RETURNING id, recipient, subject, body, template, attempts, COALESCE(last_error, ''), next_attempt_at, created_at`
+	rows, err := s.pool.Query(ctx, q, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, fmt.Errorf("mail outbox store: claim: %w", errMap(err))
+	}
+	defer rows.Close()
+	out := []domain.OutboxMail{}
+	for rows.Next() {
+		var m domain.OutboxMail
+		if err := rows.Scan(&m.ID, &m.Recipient, &m.Subject, &m.Body, &m.Template, &m.Attempts,
+			&m.LastError, &m.NextAttemptAt, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("mail outbox store: claim scan: %w", err)
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("mail outbox store: claim rows: %w", err)
+	}
+	return out, nil
+}
+
+// MarkMailSent records a delivery and clears the body: it carries
+// one-time links and codes, and only the metadata is kept until purged.
+func (s *MailOutboxStore) MarkMailSent(ctx context.Context, id uuid.UUID, at time.Time) error {
+	const q = `UPDATE mail_outbox SET sent_at = $2, last_error = NULL, body = '' WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, at); err != nil {
+		return fmt.Errorf("mail outbox store: mark sent: %w", errMap(err))
+	}
+	return nil
+}
+
+// RetryMail records a failed attempt and schedules the next one.
+func (s *MailOutboxStore) RetryMail(ctx context.Context, id uuid.UUID, next time.Time, lastErr string) error {
+	const q = `UPDATE mail_outbox SET next_attempt_at = $2, last_error = $3 WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, next, lastErr); err != nil {
+		return fmt.Errorf("mail outbox store: retry: %w", errMap(err))
+	}
+	return nil
+}
+
+// FailMail gives up on a message and clears its body, as MarkMailSent
+// does.
+func (s *MailOutboxStore) FailMail(ctx context.Context, id uuid.UUID, at time.Time, lastErr string) error {
+	const q = `UPDATE mail_outbox SET failed_at = $2, last_error = $3, body = '' WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, at, lastErr); err != nil {
+		return fmt.Errorf("mail outbox store: fail: %w", errMap(err))
+	}
+	return nil
+}
+
+// PurgeMail deletes sent and abandoned messages older than before.
+// Their bodies were already cleared when they left the queue.
+func (s *MailOutboxStore) PurgeMail(ctx context.Context, before time.Time) (int64, error) {
+	const q = `DELETE FROM mail_outbox WHERE COALESCE(sent_at, failed_at) < $1`
+	tag, err := s.pool.Exec(ctx, q, before)
+	if err != nil {
+		return 0, fmt.Errorf("mail outbox store: purge: %w", errMap(err))
+	}
+	return tag.RowsAffected(), nil
+}
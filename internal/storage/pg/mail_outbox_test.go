@@ -0,0 +1,57 @@
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+func TestMailOutboxClearsBodyWhenDone(t *testing.T) {
+	pool := testPool(t)
+	s := NewMailOutboxStore(pool)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	sent, failed := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{sent, failed} {
+		err := s.EnqueueMail(ctx, domain.OutboxMail{
+			ID:            id,
+			Recipient:     "ana@example.com",
+			Subject:       "Reset your password",
+			Body:          "https://lumi.example/reset?token=secret",
+			Template:      "password_reset",
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		_, _ = pool.Exec(context.Background(), `DELETE FROM mail_outbox WHERE id = ANY($1)`, []uuid.UUID{sent, failed})
+	})
+
+	if err := s.MarkMailSent(ctx, sent, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FailMail(ctx, failed, now, "mailbox unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []uuid.UUID{sent, failed} {
+		var body, subject string
+		err := pool.QueryRow(ctx, `SELECT body, subject FROM mail_outbox WHERE id = $1`, id).Scan(&body, &subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "" {
+			t.Fatalf("body kept after the message left the queue: %q", body)
+		}
+		if subject == "" {
+			t.Fatal("metadata dropped with the body")
+		}
+	}
+}
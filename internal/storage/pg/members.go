@@ -85,7 +85,7 @@ type MemberJoined struct {
 func (s *MemberStore) ListForVault(ctx context.Context, vaultID uuid.UUID) ([]MemberJoined, error) {
 	const q = `
 SELECT m.vault_id, m.user_id, m.role_id, m.joined_at,
-       u.id, u.username, u.password_hash, u.display_name, u.created_at,
+       u.id, u.username, u.password_hash, u.display_name, u.created_at, u.suspended_at,
        r.id, r.vault_id, r.name, r.capabilities, r.is_seed
   FROM vault_members m
   JOIN users u ON u.id = m.user_id
@@ -106,7 +106,7 @@ SELECT m.vault_id, m.user_id, m.role_id, m.joined_at,
 		)
 		err := rows.Scan(
 			&mj.Member.VaultID, &mj.Member.UserID, &mj.Member.RoleID, &mj.Member.JoinedAt,
-			&mj.User.ID, &mj.User.Username, &mj.User.PasswordHash, &mj.User.DisplayName, &mj.User.CreatedAt,
+			&mj.User.ID, &mj.User.Username, &mj.User.PasswordHash, &mj.User.DisplayName, &mj.User.CreatedAt, &mj.User.SuspendedAt,
 			&mj.Role.ID, &mj.Role.VaultID, &mj.Role.Name, &caps, &mj.Role.IsSeed,
 		)
 		if err != nil {
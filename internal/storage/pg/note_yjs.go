@@ -191,3 +191,166 @@ SELECT COALESCE(MAX(id), 0) FROM note_yjs_updates
 	}
 	return id, nil
 }
+
+// compactionLockClass namespaces the compaction advisory locks: the
+// first key of the two-key form, the note's hash being the second.
+const compactionLockClass = 0x6c756d69 // "lumi"
+
+// TryLockCompaction takes a session advisory lock on the note for the
+// length of one compaction, so replicas never compact it at once. ok is
+// false when another session holds it. The lock pins a pooled
+// connection until release, which unlocks it and returns the connection.
+func (s *NoteYjsStore) TryLockCompaction(ctx context.Context, vaultID uuid.UUID, noteID string) (func(), bool, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("note_yjs compaction lock: acquire: %w", errMap(err))
+	}
+	key := vaultID.String() + "/" + noteID
+	var ok bool
+	const q = `SELECT pg_try_advisory_lock($1, hashtext($2))`
+	if err := conn.QueryRow(ctx, q, compactionLockClass, key).Scan(&ok); err != nil {
+		conn.Release()
+		return nil, false, fmt.Errorf("note_yjs compaction lock: %w", errMap(err))
+	}
+	if !ok {
+		conn.Release()
+		return nil, false, nil
+	}
+	release := func() {
+		// ctx may be done by now; the unlock must still run.
+		const q = `SELECT pg_advisory_unlock($1, hashtext($2))`
+		if _, err := conn.Exec(context.Background(), q, compactionLockClass, key); err != nil {
+			// The lock lives as long as the session: close it rather
+			// than hand a locked connection back to the pool.
+			_ = conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}
+	return release, true, nil
+}
+
+// ---- Compaction scans ------------------------------------------------------
+
+// NoteYjsLogStat is the update-log footprint of one note.
+type NoteYjsLogStat struct {
+	VaultID uuid.UUID
+	NoteID  string
+	Count   int
+	Bytes   int64
+}
+
+// NoteYjsSnapshotStat is the stored snapshot size of one note.
+type NoteYjsSnapshotStat struct {
+	VaultID uuid.UUID
+	NoteID  string
+	Bytes   int64
+}
+
+// NoteYjsTotals aggregates note_yjs_updates and note_yjs_snapshots.
+type NoteYjsTotals struct {
+	NotesWithLog  int
+	LogRows       int64
+	LogBytes      int64
+	Snapshots     int
+	SnapshotBytes int64
+}
+
+// vaultFilter maps uuid.Nil ("every vault") to SQL NULL.
+func vaultFilter(vaultID uuid.UUID) any {
+	if vaultID == uuid.Nil {
+		return nil
+	}
+	return vaultID
+}
+
+// ListCompactionCandidates returns notes whose update log holds at least
+// minCount rows or minBytes bytes, busiest first. vaultID = uuid.Nil
+// scans every vault. Served by note_yjs_updates_note_idx.
+func (s *NoteYjsStore) ListCompactionCandidates(
+	ctx context.Context,
+	vaultID uuid.UUID, minCount int, minBytes int64, limit int,
+) ([]NoteYjsLogStat, error) {
+	const q = `
+SELECT vault_id, note_id, COUNT(*) AS n, SUM(OCTET_LENGTH(update_blob)) AS b
+  FROM note_yjs_updates
+ WHERE $1::uuid IS NULL OR vault_id = $1
+ GROUP BY vault_id, note_id
+HAVING COUNT(*) >= $2 OR SUM(OCTET_LENGTH(update_blob)) >= $3
+ ORDER BY n DESC, b DESC
+ LIMIT $4`
+	rows, err := s.pool.Query(ctx, q, vaultFilter(vaultID), minCount, minBytes, limit)
+	if err != nil {
+		return nil, fmt.Errorf("note_yjs update: candidates: %w", errMap(err))
+	}
+	defer rows.Close()
+	out := []NoteYjsLogStat{}
+	for rows.Next() {
+		var st NoteYjsLogStat
+		if err := rows.Scan(&st.VaultID, &st.NoteID, &st.Count, &st.Bytes); err != nil {
+			return nil, fmt.Errorf("note_yjs update: candidates scan: %w", err)
+		}
+		out = append(out, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("note_yjs update: candidates rows: %w", err)
+	}
+	return out, nil
+}
+
+// ListSnapshotsAfter pages through snapshots in (vault_id, note_id)
+// order, strictly after the given position. Pass uuid.Nil and "" to
+// start from the beginning; vaultID = uuid.Nil scans every vault.
+func (s *NoteYjsStore) ListSnapshotsAfter(
+	ctx context.Context,
+	vaultID uuid.UUID, afterVault uuid.UUID, afterNote string, limit int,
+) ([]NoteYjsSnapshotStat, error) {
+	const q = `
+SELECT vault_id, note_id, OCTET_LENGTH(state)
+  FROM note_yjs_snapshots
+ WHERE ($1::uuid IS NULL OR vault_id = $1)
+   AND (vault_id, note_id) > ($2, $3)
+ ORDER BY vault_id, note_id
+ LIMIT $4`
+	rows, err := s.pool.Query(ctx, q, vaultFilter(vaultID), afterVault, afterNote, limit)
+	if err != nil {
+		return nil, fmt.Errorf("note_yjs snapshot: page: %w", errMap(err))
+	}
+	defer rows.Close()
+	out := []NoteYjsSnapshotStat{}
+	for rows.Next() {
+		var st NoteYjsSnapshotStat
+		if err := rows.Scan(&st.VaultID, &st.NoteID, &st.Bytes); err != nil {
+			return nil, fmt.Errorf("note_yjs snapshot: page scan: %w", err)
+		}
+		out = append(out, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("note_yjs snapshot: page rows: %w", err)
+	}
+	return out, nil
+}
+
+// Totals aggregates the log and snapshot tables for one vault, or for
+// every vault when vaultID = uuid.Nil.
+func (s *NoteYjsStore) Totals(ctx context.Context, vaultID uuid.UUID) (NoteYjsTotals, error) {
+	const q = `
+SELECT
+  (SELECT COUNT(DISTINCT (vault_id, note_id)) FROM note_yjs_updates
+    WHERE $1::uuid IS NULL OR vault_id = $1),
+  (SELECT COUNT(*) FROM note_yjs_updates
+    WHERE $1::uuid IS NULL OR vault_id = $1),
+  (SELECT COALESCE(SUM(OCTET_LENGTH(update_blob)), 0) FROM note_yjs_updates
+    WHERE $1::uuid IS NULL OR vault_id = $1),
+  (SELECT COUNT(*) FROM note_yjs_snapshots
+    WHERE $1::uuid IS NULL OR vault_id = $1),
+  (SELECT COALESCE(SUM(OCTET_LENGTH(state)), 0) FROM note_yjs_snapshots
+    WHERE $1::uuid IS NULL OR vault_id = $1)`
+	var t NoteYjsTotals
+	err := s.pool.QueryRow(ctx, q, vaultFilter(vaultID)).Scan(
+		&t.NotesWithLog, &t.LogRows, &t.LogBytes, &t.Snapshots, &t.SnapshotBytes,
+	)
+	if err != nil {
+		return NoteYjsTotals{}, fmt.Errorf("note_yjs totals: %w", errMap(err))
+	}
+	return t, nil
+}
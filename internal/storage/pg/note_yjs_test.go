@@ -0,0 +1,33 @@
+package pg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCompactionLockIsExclusive(t *testing.T) {
+	s := NewNoteYjsStore(testPool(t))
+	ctx := context.Background()
+	v := uuid.New()
+
+	release, ok, err := s.TryLockCompaction(ctx, v, "a")
+	if err != nil || !ok {
+		t.Fatalf("first lock = %v, %v", ok, err)
+	}
+	if _, ok, err := s.TryLockCompaction(ctx, v, "a"); err != nil || ok {
+		t.Fatalf("second lock on the same note = %v, %v, want refused", ok, err)
+	}
+	other, ok, err := s.TryLockCompaction(ctx, v, "b")
+	if err != nil || !ok {
+		t.Fatalf("lock on another note = %v, %v", ok, err)
+	}
+	other()
+	release()
+	again, ok, err := s.TryLockCompaction(ctx, v, "a")
+	if err != nil || !ok {
+		t.Fatalf("lock after release = %v, %v", ok, err)
+	}
+	again()
+}
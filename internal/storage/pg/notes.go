@@ -95,6 +95,44 @@ SELECT id, vault_id, path, title, created_at, updated_at
 	return out, nil
 }
 
+// ListAuthoredNotes returns the notes in vaultIDs that userID created or
+// edited: live edits carry the author in note_yjs_updates.origin_user_id,
+// and the audit log keeps the attribution once compaction has folded
+// those updates into a snapshot.
+func (s *NoteStore) ListAuthoredNotes(ctx context.Context, userID uuid.UUID, vaultIDs []uuid.UUID) ([]domain.Note, error) {
+	const q = `
+SELECT n.id, n.vault_id, n.path, n.title, n.created_at, n.updated_at
+  FROM notes n
+ WHERE n.vault_id = ANY($2)
+   AND (EXISTS (SELECT 1 FROM note_yjs_updates u
+                 WHERE u.vault_id = n.vault_id AND u.note_id = n.id AND u.origin_user_id = $1)
+     OR EXISTS (SELECT 1 FROM audit_log a
+                 WHERE a.user_id = $1 AND a.vault_id = n.vault_id
+                   AND a.action = ANY($3) AND a.payload->>'note_id' = n.id))
+ ORDER BY n.vault_id, n.path`
+	actions := []string{domain.ActionNoteCreate, domain.ActionNoteEdit}
+	rows, err := s.pool.Query(ctx, q, userID, vaultIDs, actions)
+	if err != nil {
+		return nil, fmt.Errorf("note store: list authored: %w", errMap(err))
+	}
+	defer rows.Close()
+
+	var out []domain.Note
+	for rows.Next() {
+		var n domain.Note
+		if err := rows.Scan(
+			&n.ID, &n.VaultID, &n.Path, &n.Title, &n.CreatedAt, &n.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("note store: list authored scan: %w", err)
+		}
+		out = append(out, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("note store: list authored rows: %w", err)
+	}
+	return out, nil
+}
+
 func (s *NoteStore) Delete(ctx context.Context, vaultID uuid.UUID, id string) error {
 	const q = `DELETE FROM notes WHERE vault_id = $1 AND id = $2`
 	tag, err := s.pool.Exec(ctx, q, vaultID, id)
@@ -0,0 +1,151 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// OIDCStore persists OpenID Connect identities and authorization
+// requests in flight.
+type OIDCStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewOIDCStore(pool *pgxpool.Pool) *OIDCStore {
+	return &OIDCStore{pool: pool}
+}
+
+// GetIdentity returns the link for a provider subject.
+func (s *OIDCStore) GetIdentity(ctx context.Context, issuer, subject string) (domain.UserIdentity, error) {
+	const q = `
+SELECT issuer, subject, user_id, created_at
+  FROM user_identities
+ WHERE issuer = $1 AND subject = $2`
+	var id domain.UserIdentity
+	err := s.pool.QueryRow(ctx, q, issuer, subject).Scan(&id.Issuer, &id.Subject, &id.UserID, &id.CreatedAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.UserIdentity{}, fmt.Errorf("oidc store: get identity: %w", domain.ErrNotFound)
+		}
+		return domain.UserIdentity{}, fmt.Errorf("oidc store: get identity: %w", errMap(err))
+	}
+	return id, nil
+}
+
+// CreateIdentity links a subject to a user. ErrConflict when the
+// subject is already linked.
+func (s *OIDCStore) CreateIdentity(ctx context.Context, id domain.UserIdentity) error {
+	const q = `
+INSERT INTO user_identities (issuer, subject, user_id, created_at)
+VALUES ($1, $2, $3, $4)`
+	if _, err := s.pool.Exec(ctx, q, id.Issuer, id.Subject, id.UserID, id.CreatedAt); err != nil {
+		return fmt.Errorf("oidc store: create identity: %w", errMap(err))
+	}
+	return nil
+}
+
+// ProvisionUser creates the account of a first-time OIDC subject, links
+// the identity and records the consent in one transaction, so a failed
+// link leaves no account behind. ErrConflict when the username is taken
+// or the subject already linked.
+func (s *OIDCStore) ProvisionUser(ctx context.Context, u domain.User, id domain.UserIdentity, c domain.Consent) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		const qUser = `
+INSERT INTO users (id, username, password_hash, display_name, created_at)
+VALUES ($1, $2, $3, $4, $5)`
+		if _, err := tx.Exec(ctx, qUser, u.ID, u.Username, u.PasswordHash, u.DisplayName, u.CreatedAt); err != nil {
+			return fmt.Errorf("oidc store: provision user: %w", errMap(err))
+		}
+		const qIdentity = `
+INSERT INTO user_identities (issuer, subject, user_id, created_at)
+VALUES ($1, $2, $3, $4)`
+		if _, err := tx.Exec(ctx, qIdentity, id.Issuer, id.Subject, id.UserID, id.CreatedAt); err != nil {
+			return fmt.Errorf("oidc store: provision identity: %w", errMap(err))
+		}
+		const qConsent = `
+INSERT INTO user_consents (user_id, tos_version, privacy_version, accepted_at, ip, user_agent)
+VALUES ($1, $2, $3, $4, $5::inet, $6)`
+		_, err := tx.Exec(ctx, qConsent, c.UserID, c.TosVersion, c.PrivacyVersion, c.AcceptedAt,
+			nullableStringPtr(c.IP), nullableStringPtr(c.UserAgent))
+		if err != nil {
+			return fmt.Errorf("oidc store: provision consent: %w", errMap(err))
+		}
+		return nil
+	})
+}
+
+// CreateState stores an authorization request, sweeping expired ones
+// first.
+func (s *OIDCStore) CreateState(ctx context.Context, st domain.OIDCState) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM oidc_states WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("oidc store: sweep states: %w", errMap(err))
+	}
+	const q = `
+INSERT INTO oidc_states (state_hash, nonce, code_verifier, link_user_id, tos_version, privacy_version, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := s.pool.Exec(ctx, q, st.StateHash, st.Nonce, st.CodeVerifier, st.LinkUserID,
+		st.TosVersion, st.PrivacyVersion, st.CreatedAt, st.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("oidc store: create state: %w", errMap(err))
+	}
+	return nil
+}
+
+// TakeState removes and returns an authorization request, so a state
+// is redeemed at most once. Expired rows are returned; the caller
+// decides.
+func (s *OIDCStore) TakeState(ctx context.Context, stateHash string) (domain.OIDCState, error) {
+	const q = `
+DELETE FROM oidc_states
+ WHERE state_hash = $1
+RETURNING state_hash, nonce, code_verifier, link_user_id, tos_version, privacy_version, created_at, expires_at`
+	var st domain.OIDCState
+	err := s.pool.QueryRow(ctx, q, stateHash).Scan(&st.StateHash, &st.Nonce, &st.CodeVerifier, &st.LinkUserID,
+		&st.TosVersion, &st.PrivacyVersion, &st.CreatedAt, &st.ExpiresAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.OIDCState{}, fmt.Errorf("oidc store: take state: %w", domain.ErrNotFound)
+		}
+		return domain.OIDCState{}, fmt.Errorf("oidc store: take state: %w", errMap(err))
+	}
+	return st, nil
+}
+
+// CreateReauthGrant stores a re-authentication grant, sweeping expired
+// ones first.
+func (s *OIDCStore) CreateReauthGrant(ctx context.Context, g domain.ReauthGrant) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM oidc_reauth_grants WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("oidc store: sweep reauth grants: %w", errMap(err))
+	}
+	const q = `
+INSERT INTO oidc_reauth_grants (token_hash, user_id, created_at, expires_at)
+VALUES ($1, $2, $3, $4)`
+	if _, err := s.pool.Exec(ctx, q, g.TokenHash, g.UserID, g.CreatedAt, g.ExpiresAt); err != nil {
+		return fmt.Errorf("oidc store: create reauth grant: %w", errMap(err))
+	}
+	return nil
+}
+
+// TakeReauthGrant removes and returns a grant, so it is spent at most
+// once. Expired rows are returned; the caller decides.
+func (s *OIDCStore) TakeReauthGrant(ctx context.Context, tokenHash string) (domain.ReauthGrant, error) {
+	const q = `
+DELETE FROM oidc_reauth_grants
+ WHERE token_hash = $1
+RETURNING token_hash, user_id, created_at, expires_at`
+	var g domain.ReauthGrant
+	err := s.pool.QueryRow(ctx, q, tokenHash).Scan(&g.TokenHash, &g.UserID, &g.CreatedAt, &g.ExpiresAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.ReauthGrant{}, fmt.Errorf("oidc store: take reauth grant: %w", domain.ErrNotFound)
+		}
+		return domain.ReauthGrant{}, fmt.Errorf("oidc store: take reauth grant: %w", errMap(err))
+	}
+	return g, nil
+}
@@ -0,0 +1,42 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+func TestProvisionUserRollsBackOnLinkConflict(t *testing.T) {
+	pool := testPool(t)
+	s := NewOIDCStore(pool)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	subject := uuid.NewString()
+
+	provision := func() (uuid.UUID, error) {
+		u := domain.User{ID: uuid.New(), Username: "oidc-" + uuid.NewString()[:8], PasswordHash: "!oidc", CreatedAt: now}
+		t.Cleanup(func() { _, _ = pool.Exec(context.Background(), `DELETE FROM users WHERE id = $1`, u.ID) })
+		return u.ID, s.ProvisionUser(ctx, u,
+			domain.UserIdentity{Issuer: "https://idp.example", Subject: subject, UserID: u.ID, CreatedAt: now},
+			domain.Consent{UserID: u.ID, TosVersion: "1", PrivacyVersion: "1", AcceptedAt: now})
+	}
+	if _, err := provision(); err != nil {
+		t.Fatal(err)
+	}
+	orphan, err := provision()
+	if !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("second account for the subject: %v, want ErrConflict", err)
+	}
+	var n int
+	if err := pool.QueryRow(ctx, `SELECT count(*) FROM users WHERE id = $1`, orphan).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("account left behind by a failed link")
+	}
+}
@@ -0,0 +1,56 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// PasswordResetStore persists admin-issued password reset tokens.
+type PasswordResetStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPasswordResetStore(pool *pgxpool.Pool) *PasswordResetStore {
+	return &PasswordResetStore{pool: pool}
+}
+
+// CreatePasswordReset stores a reset token, replacing any the user
+// still had pending so only the newest one works.
+func (s *PasswordResetStore) CreatePasswordReset(ctx context.Context, r domain.PasswordReset) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM password_resets WHERE user_id = $1 OR expires_at <= NOW()`, r.UserID); err != nil {
+			return fmt.Errorf("password reset store: replace: %w", errMap(err))
+		}
+		const q = `
+INSERT INTO password_resets (token_hash, user_id, created_by, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)`
+		if _, err := tx.Exec(ctx, q, r.TokenHash, r.UserID, r.CreatedBy, r.CreatedAt, r.ExpiresAt); err != nil {
+			return fmt.Errorf("password reset store: create: %w", errMap(err))
+		}
+		return nil
+	})
+}
+
+// TakePasswordReset removes and returns a reset token, so it is
+// redeemed at most once. Expired rows are returned; the caller decides.
+func (s *PasswordResetStore) TakePasswordReset(ctx context.Context, tokenHash string) (domain.PasswordReset, error) {
+	const q = `
+DELETE FROM password_resets
+ WHERE token_hash = $1
+RETURNING token_hash, user_id, created_by, created_at, expires_at`
+	var r domain.PasswordReset
+	err := s.pool.QueryRow(ctx, q, tokenHash).Scan(&r.TokenHash, &r.UserID, &r.CreatedBy, &r.CreatedAt, &r.ExpiresAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.PasswordReset{}, fmt.Errorf("password reset store: take: %w", domain.ErrNotFound)
+		}
+		return domain.PasswordReset{}, fmt.Errorf("password reset store: take: %w", errMap(err))
+	}
+	return r, nil
+}
@@ -0,0 +1,35 @@
+package pg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testPool connects to the database in LUMI_TEST_DATABASE_URL and brings
+// it up to the latest migration. Tests that need Postgres are skipped
+// when it is unset.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("LUMI_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("LUMI_TEST_DATABASE_URL not set")
+	}
+	dir, err := filepath.Abs(filepath.Join("..", "..", "..", "migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := Migrate(ctx, dsn, dir); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
@@ -0,0 +1,113 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// RateLimitStore persists rate-limit buckets shared by every replica.
+type RateLimitStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewRateLimitStore(pool *pgxpool.Pool) *RateLimitStore {
+	return &RateLimitStore{pool: pool}
+}
+
+// UpdateBucket runs fn on the (scope, key) bucket under a row lock and
+// saves the result. Two first attempts on a key can both see an empty
+// bucket; the later write wins, which costs at most one token.
+func (s *RateLimitStore) UpdateBucket(ctx context.Context, scope, key string, fn func(b *domain.RateBucket) bool) (bool, error) {
+	var allowed bool
+	err := runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		const q = `
+SELECT tokens, updated_at, lockouts, locked_until
+  FROM rate_limits
+ WHERE scope = $1 AND key = $2
+   FOR UPDATE`
+		b := domain.RateBucket{Scope: scope, Key: key}
+		err := tx.QueryRow(ctx, q, scope, key).Scan(&b.Tokens, &b.UpdatedAt, &b.Lockouts, &b.LockedUntil)
+		if err != nil && !errors.Is(errMap(err), domain.ErrNotFound) {
+			return fmt.Errorf("rate limit store: get bucket: %w", errMap(err))
+		}
+		allowed = fn(&b)
+		const upsert = `
+INSERT INTO rate_limits (scope, key, tokens, updated_at, lockouts, locked_until)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (scope, key)
+DO UPDATE SET tokens = EXCLUDED.tokens,
+              updated_at = EXCLUDED.updated_at,
+              lockouts = EXCLUDED.lockouts,
+              locked_until = EXCLUDED.locked_until`
+		if _, err := tx.Exec(ctx, upsert, scope, key, b.Tokens, b.UpdatedAt, b.Lockouts, b.LockedUntil); err != nil {
+			return fmt.Errorf("rate limit store: save bucket: %w", errMap(err))
+		}
+		return nil
+	})
+	return allowed, err
+}
+
+// DeleteBucket forgets one bucket. Idempotent.
+func (s *RateLimitStore) DeleteBucket(ctx context.Context, scope, key string) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM rate_limits WHERE scope = $1 AND key = $2`, scope, key); err != nil {
+		return fmt.Errorf("rate limit store: delete bucket: %w", errMap(err))
+	}
+	return nil
+}
+
+// DeleteBucketsByKey forgets the buckets for any of keys, in every scope.
+func (s *RateLimitStore) DeleteBucketsByKey(ctx context.Context, keys ...string) (int, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM rate_limits WHERE key = ANY($1)`, keys)
+	if err != nil {
+		return 0, fmt.Errorf("rate limit store: delete buckets: %w", errMap(err))
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// ListLockedBuckets returns the buckets locked out at now, longest
+// lockout first.
+func (s *RateLimitStore) ListLockedBuckets(ctx context.Context, now time.Time) ([]domain.RateBucket, error) {
+	const q = `
+SELECT scope, key, tokens, updated_at, lockouts, locked_until
+  FROM rate_limits
+ WHERE locked_until > $1
+ ORDER BY locked_until DESC`
+	rows, err := s.pool.Query(ctx, q, now)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit store: list locked: %w", errMap(err))
+	}
+	defer rows.Close()
+	var out []domain.RateBucket
+	for rows.Next() {
+		var b domain.RateBucket
+		if err := rows.Scan(&b.Scope, &b.Key, &b.Tokens, &b.UpdatedAt, &b.Lockouts, &b.LockedUntil); err != nil {
+			return nil, fmt.Errorf("rate limit store: scan bucket: %w", errMap(err))
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rate limit store: list locked: %w", errMap(err))
+	}
+	return out, nil
+}
+
+// PurgeBuckets deletes buckets untouched since before whose lockout, if
+// any, has also ended by then.
+func (s *RateLimitStore) PurgeBuckets(ctx context.Context, before time.Time) (int64, error) {
+	const q = `
+DELETE FROM rate_limits
+ WHERE updated_at < $1
+   AND (locked_until IS NULL OR locked_until < $1)`
+	tag, err := s.pool.Exec(ctx, q, before)
+	if err != nil {
+		return 0, fmt.Errorf("rate limit store: purge: %w", errMap(err))
+	}
+	return tag.RowsAffected(), nil
+}
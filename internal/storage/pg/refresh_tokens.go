@@ -0,0 +1,71 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// RefreshTokenStore persists the refresh tokens of signed-token sessions.
+type RefreshTokenStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewRefreshTokenStore(pool *pgxpool.Pool) *RefreshTokenStore {
+	return &RefreshTokenStore{pool: pool}
+}
+
+// IssueRefreshToken stores t and extends its session to t.ExpiresAt.
+// ErrNotFound when the session is gone.
+func (s *RefreshTokenStore) IssueRefreshToken(ctx context.Context, t domain.RefreshToken) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE sessions SET last_used_at = $2, expires_at = $3 WHERE id = $1`,
+			t.SessionID, t.CreatedAt, t.ExpiresAt)
+		if err != nil {
+			return fmt.Errorf("refresh token store: touch session: %w", errMap(err))
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("refresh token store: touch session: %w", domain.ErrNotFound)
+		}
+		const q = `
+INSERT INTO refresh_tokens (token_hash, session_id, user_id, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)`
+		if _, err := tx.Exec(ctx, q, t.TokenHash, t.SessionID, t.UserID, t.CreatedAt, t.ExpiresAt); err != nil {
+			return fmt.Errorf("refresh token store: create: %w", errMap(err))
+		}
+		return nil
+	})
+}
+
+// UseRefreshToken marks a token used at at and returns it as it was
+// before: a non-nil UsedAt means it had already been spent. Of two
+// concurrent uses exactly one sees it unspent.
+func (s *RefreshTokenStore) UseRefreshToken(ctx context.Context, tokenHash string, at time.Time) (domain.RefreshToken, error) {
+	const q = `
+WITH old AS (
+  SELECT token_hash, session_id, user_id, created_at, expires_at, used_at
+    FROM refresh_tokens
+   WHERE token_hash = $1
+     FOR UPDATE
+)
+UPDATE refresh_tokens r
+   SET used_at = COALESCE(r.used_at, $2)
+  FROM old
+ WHERE r.token_hash = old.token_hash
+RETURNING old.token_hash, old.session_id, old.user_id, old.created_at, old.expires_at, old.used_at`
+	var t domain.RefreshToken
+	err := s.pool.QueryRow(ctx, q, tokenHash, at).Scan(&t.TokenHash, &t.SessionID, &t.UserID, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.RefreshToken{}, fmt.Errorf("refresh token store: use: %w", domain.ErrNotFound)
+		}
+		return domain.RefreshToken{}, fmt.Errorf("refresh token store: use: %w", errMap(err))
+	}
+	return t, nil
+}
@@ -21,13 +21,20 @@ func NewSessionStore(pool *pgxpool.Pool) *SessionStore {
 	return &SessionStore{pool: pool}
 }
 
-// Create / CreateSession (alias) inserts a new session row.
+const sessionColumns = `id, token, user_id, ip, user_agent, created_at, expires_at, last_used_at, device_name`
+
+// Create / CreateSession (alias) inserts a new session row. A zero ID is
+// filled in.
 func (s *SessionStore) Create(ctx context.Context, sess domain.Session) error {
+	if sess.ID == uuid.Nil {
+		sess.ID = uuid.New()
+	}
 	const q = `
-INSERT INTO sessions (token, user_id, created_at, expires_at, last_used_at)
-VALUES ($1, $2, $3, $4, $5)`
+INSERT INTO sessions (` + sessionColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 	_, err := s.pool.Exec(ctx, q,
-		sess.Token, sess.UserID, sess.CreatedAt, sess.ExpiresAt, sess.LastUsedAt,
+		sess.ID, sess.Token, sess.UserID, nullableString(sess.IP), nullableString(sess.UserAgent),
+		sess.CreatedAt, sess.ExpiresAt, sess.LastUsedAt, nullableString(sess.DeviceName),
 	)
 	if err != nil {
 		return fmt.Errorf("session store: create: %w", errMap(err))
@@ -48,12 +55,9 @@ UPDATE sessions
    SET last_used_at = NOW()
  WHERE token = $1
    AND expires_at > NOW()
-RETURNING token, user_id, created_at, expires_at, last_used_at`
+RETURNING ` + sessionColumns
 
-	var out domain.Session
-	err := s.pool.QueryRow(ctx, q, token).Scan(
-		&out.Token, &out.UserID, &out.CreatedAt, &out.ExpiresAt, &out.LastUsedAt,
-	)
+	out, err := scanSession(s.pool.QueryRow(ctx, q, token))
 	if err != nil {
 		if errors.Is(errMap(err), domain.ErrNotFound) {
 			var exists bool
@@ -74,14 +78,8 @@ RETURNING token, user_id, created_at, expires_at, last_used_at`
 // Note: this version does not bump last_used_at; auth's middleware handles
 // that explicitly via TouchSession.
 func (s *SessionStore) GetSession(ctx context.Context, token string) (domain.Session, error) {
-	const q = `
-SELECT token, user_id, created_at, expires_at, last_used_at
-  FROM sessions
- WHERE token = $1`
-	var out domain.Session
-	err := s.pool.QueryRow(ctx, q, token).Scan(
-		&out.Token, &out.UserID, &out.CreatedAt, &out.ExpiresAt, &out.LastUsedAt,
-	)
+	const q = `SELECT ` + sessionColumns + ` FROM sessions WHERE token = $1`
+	out, err := scanSession(s.pool.QueryRow(ctx, q, token))
 	if err != nil {
 		if errors.Is(errMap(err), domain.ErrNotFound) {
 			return domain.Session{}, fmt.Errorf("session store: get: %w", domain.ErrNotFound)
@@ -147,6 +145,81 @@ func (s *SessionStore) DeleteSessionsForUser(ctx context.Context, userID uuid.UU
 	return int(tag.RowsAffected()), nil
 }
 
+// ListSessionsForUser returns the user's unexpired sessions, most
+// recently used first.
+func (s *SessionStore) ListSessionsForUser(ctx context.Context, userID uuid.UUID) ([]domain.Session, error) {
+	const q = `
+SELECT ` + sessionColumns + `
+  FROM sessions
+ WHERE user_id = $1 AND expires_at > NOW()
+ ORDER BY last_used_at DESC`
+	return s.query(ctx, "list", q, userID)
+}
+
+// DeleteSessionByID revokes one of the user's sessions by public id and
+// returns it. ErrNotFound when the id does not exist or belongs to
+// someone else.
+func (s *SessionStore) DeleteSessionByID(ctx context.Context, userID, id uuid.UUID) (domain.Session, error) {
+	const q = `DELETE FROM sessions WHERE id = $1 AND user_id = $2 RETURNING ` + sessionColumns
+	out, err := scanSession(s.pool.QueryRow(ctx, q, id, userID))
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.Session{}, fmt.Errorf("session store: delete by id: %w", domain.ErrNotFound)
+		}
+		return domain.Session{}, fmt.Errorf("session store: delete by id: %w", errMap(err))
+	}
+	return out, nil
+}
+
+// DeleteOtherSessions revokes every session of the user except keepID
+// and returns the ones removed.
+func (s *SessionStore) DeleteOtherSessions(ctx context.Context, userID, keepID uuid.UUID) ([]domain.Session, error) {
+	const q = `DELETE FROM sessions WHERE user_id = $1 AND id <> $2 RETURNING ` + sessionColumns
+	return s.query(ctx, "delete others", q, userID, keepID)
+}
+
+func (s *SessionStore) query(ctx context.Context, op, q string, args ...any) ([]domain.Session, error) {
+	rows, err := s.pool.Query(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("session store: %s: %w", op, errMap(err))
+	}
+	defer rows.Close()
+	out := []domain.Session{}
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("session store: %s scan: %w", op, err)
+		}
+		out = append(out, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("session store: %s: %w", op, errMap(err))
+	}
+	return out, nil
+}
+
+func scanSession(r rowScanner) (domain.Session, error) {
+	var (
+		out        domain.Session
+		ip, ua     *string
+		deviceName *string
+	)
+	if err := r.Scan(&out.ID, &out.Token, &out.UserID, &ip, &ua,
+		&out.CreatedAt, &out.ExpiresAt, &out.LastUsedAt, &deviceName); err != nil {
+		return domain.Session{}, err
+	}
+	if deviceName != nil {
+		out.DeviceName = *deviceName
+	}
+	if ip != nil {
+		out.IP = *ip
+	}
+	if ua != nil {
+		out.UserAgent = *ua
+	}
+	return out, nil
+}
+
 // PurgeExpired deletes sessions past their expiry.
 func (s *SessionStore) PurgeExpired(ctx context.Context) (int64, error) {
 	const q = `DELETE FROM sessions WHERE expires_at <= NOW()`
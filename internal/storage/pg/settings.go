@@ -0,0 +1,47 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// SettingsStore persists the server-wide settings admins change at
+// runtime, shared by every replica.
+type SettingsStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewSettingsStore(pool *pgxpool.Pool) *SettingsStore {
+	return &SettingsStore{pool: pool}
+}
+
+// GetSetting returns the value stored under key. ErrNotFound when none
+// was ever set.
+func (s *SettingsStore) GetSetting(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.pool.QueryRow(ctx, `SELECT value FROM server_settings WHERE key = $1`, key).Scan(&value)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return "", fmt.Errorf("settings store: get: %w", domain.ErrNotFound)
+		}
+		return "", fmt.Errorf("settings store: get: %w", errMap(err))
+	}
+	return value, nil
+}
+
+// PutSetting stores value under key, replacing any previous value.
+func (s *SettingsStore) PutSetting(ctx context.Context, key, value string) error {
+	const q = `
+INSERT INTO server_settings (key, value, updated_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`
+	if _, err := s.pool.Exec(ctx, q, key, value); err != nil {
+		return fmt.Errorf("settings store: put: %w", errMap(err))
+	}
+	return nil
+}
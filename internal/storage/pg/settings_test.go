@@ -0,0 +1,32 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+func TestSettingsStoreRoundTrip(t *testing.T) {
+	pool := testPool(t)
+	s := NewSettingsStore(pool)
+	ctx := context.Background()
+	key := "test-" + uuid.NewString()
+	t.Cleanup(func() { _, _ = pool.Exec(context.Background(), `DELETE FROM server_settings WHERE key = $1`, key) })
+
+	if _, err := s.GetSetting(ctx, key); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("unset key: %v, want ErrNotFound", err)
+	}
+	for _, v := range []string{"open", "invite-only"} {
+		if err := s.PutSetting(ctx, key, v); err != nil {
+			t.Fatal(err)
+		}
+		got, err := s.GetSetting(ctx, key)
+		if err != nil || got != v {
+			t.Fatalf("GetSetting = %q, %v; want %q", got, err, v)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// TwoFactorStore persists TOTP enrolments, recovery codes and the
+// challenges of two-step logins.
+type TwoFactorStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewTwoFactorStore(pool *pgxpool.Pool) *TwoFactorStore {
+	return &TwoFactorStore{pool: pool}
+}
+
+// GetTOTP returns the user's enrolment, pending or confirmed.
+func (s *TwoFactorStore) GetTOTP(ctx context.Context, userID uuid.UUID) (domain.TOTPEnrolment, error) {
+	const q = `
+SELECT user_id, secret, created_at, confirmed_at, last_step
+  FROM user_totp
+ WHERE user_id = $1`
+	var e domain.TOTPEnrolment
+	err := s.pool.QueryRow(ctx, q, userID).Scan(&e.UserID, &e.Secret, &e.CreatedAt, &e.ConfirmedAt, &e.LastStep)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.TOTPEnrolment{}, fmt.Errorf("two-factor store: get totp: %w", domain.ErrNotFound)
+		}
+		return domain.TOTPEnrolment{}, fmt.Errorf("two-factor store: get totp: %w", errMap(err))
+	}
+	return e, nil
+}
+
+// BeginTOTP stores a pending secret, replacing an earlier unconfirmed
+// one. ErrConflict when the user already has a confirmed enrolment.
+func (s *TwoFactorStore) BeginTOTP(ctx context.Context, userID uuid.UUID, secret string) error {
+	const q = `
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+   SET secret = EXCLUDED.secret, created_at = NOW(), last_step = 0
+ WHERE user_totp.confirmed_at IS NULL`
+	tag, err := s.pool.Exec(ctx, q, userID, secret)
+	if err != nil {
+		return fmt.Errorf("two-factor store: begin totp: %w", errMap(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("two-factor store: begin totp: %w", domain.ErrConflict)
+	}
+	return nil
+}
+
+// ConfirmTOTP activates the pending enrolment at the accepted step and
+// installs a fresh set of recovery codes.
+func (s *TwoFactorStore) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, codeHashes []string, at time.Time) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		const q = `
+UPDATE user_totp
+   SET confirmed_at = $2, last_step = $3
+ WHERE user_id = $1 AND confirmed_at IS NULL`
+		tag, err := tx.Exec(ctx, q, userID, at, step)
+		if err != nil {
+			return fmt.Errorf("two-factor store: confirm totp: %w", errMap(err))
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("two-factor store: confirm totp: %w", domain.ErrConflict)
+		}
+		return replaceRecoveryCodesTx(ctx, tx, userID, codeHashes)
+	})
+}
+
+// DeleteTOTP removes the enrolment and its recovery codes.
+func (s *TwoFactorStore) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return fmt.Errorf("two-factor store: delete recovery codes: %w", errMap(err))
+		}
+		tag, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+		if err != nil {
+			return fmt.Errorf("two-factor store: delete totp: %w", errMap(err))
+		}
+		if tag.RowsAffected() == 0 {
+			return fmt.Errorf("two-factor store: delete totp: %w", domain.ErrNotFound)
+		}
+		return nil
+	})
+}
+
+// AdvanceTOTPStep records step as used. False when it is not newer than
+// the last accepted step, i.e. the code is a replay.
+func (s *TwoFactorStore) AdvanceTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	const q = `UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+	tag, err := s.pool.Exec(ctx, q, userID, step)
+	if err != nil {
+		return false, fmt.Errorf("two-factor store: advance step: %w", errMap(err))
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes for a new set.
+func (s *TwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	return runTx(ctx, s.pool, func(tx pgx.Tx) error {
+		return replaceRecoveryCodesTx(ctx, tx, userID, codeHashes)
+	})
+}
+
+// UseRecoveryCode burns an unused code. False when there is no such code
+// or it was already used.
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string, at time.Time) (bool, error) {
+	const q = `
+UPDATE recovery_codes
+   SET used_at = $3
+ WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := s.pool.Exec(ctx, q, userID, codeHash, at)
+	if err != nil {
+		return false, fmt.Errorf("two-factor store: use recovery code: %w", errMap(err))
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CountRecoveryCodes returns how many unused codes the user has left.
+func (s *TwoFactorStore) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	const q = `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+	var n int
+	if err := s.pool.QueryRow(ctx, q, userID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("two-factor store: count recovery codes: %w", errMap(err))
+	}
+	return n, nil
+}
+
+func replaceRecoveryCodesTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("two-factor store: clear recovery codes: %w", errMap(err))
+	}
+	const q = `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`
+	if _, err := tx.Exec(ctx, q, userID, codeHashes); err != nil {
+		return fmt.Errorf("two-factor store: insert recovery codes: %w", errMap(err))
+	}
+	return nil
+}
+
+// ---- Login challenges -----------------------------------------------------
+
+// CreateLoginChallenge stores a challenge, sweeping expired ones first.
+func (s *TwoFactorStore) CreateLoginChallenge(ctx context.Context, c domain.LoginChallenge) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM login_challenges WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("two-factor store: sweep challenges: %w", errMap(err))
+	}
+	const q = `
+INSERT INTO login_challenges (token_hash, user_id, attempts, created_at, expires_at, email_code_hash, oidc_groups)
+VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)`
+	if _, err := s.pool.Exec(ctx, q, c.TokenHash, c.UserID, c.Attempts, c.CreatedAt, c.ExpiresAt, c.CodeHash, c.OIDCGroups); err != nil {
+		return fmt.Errorf("two-factor store: create challenge: %w", errMap(err))
+	}
+	return nil
+}
+
+// GetLoginChallenge looks a challenge up by token hash.
+func (s *TwoFactorStore) GetLoginChallenge(ctx context.Context, tokenHash string) (domain.LoginChallenge, error) {
+	const q = `
+SELECT token_hash, user_id, attempts, created_at, expires_at, COALESCE(email_code_hash, ''),
+       COALESCE(oidc_groups, '{}')
+  FROM login_challenges
+ WHERE token_hash = $1`
+	var c domain.LoginChallenge
+	err := s.pool.QueryRow(ctx, q, tokenHash).Scan(&c.TokenHash, &c.UserID, &c.Attempts, &c.CreatedAt, &c.ExpiresAt, &c.CodeHash,
+		&c.OIDCGroups)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.LoginChallenge{}, fmt.Errorf("two-factor store: get challenge: %w", domain.ErrNotFound)
+		}
+		return domain.LoginChallenge{}, fmt.Errorf("two-factor store: get challenge: %w", errMap(err))
+	}
+	return c, nil
+}
+
+// BumpLoginChallenge counts a failed attempt and returns the new total.
+func (s *TwoFactorStore) BumpLoginChallenge(ctx context.Context, tokenHash string) (int, error) {
+	const q = `UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = $1 RETURNING attempts`
+	var n int
+	if err := s.pool.QueryRow(ctx, q, tokenHash).Scan(&n); err != nil {
+		return 0, fmt.Errorf("two-factor store: bump challenge: %w", errMap(err))
+	}
+	return n, nil
+}
+
+// TakeLoginChallenge removes a challenge that is being redeemed.
+// ErrNotFound when it was already gone: of two concurrent redemptions
+// exactly one takes it.
+func (s *TwoFactorStore) TakeLoginChallenge(ctx context.Context, tokenHash string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM login_challenges WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("two-factor store: take challenge: %w", errMap(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("two-factor store: take challenge: %w", domain.ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteLoginChallenge removes a challenge. Idempotent.
+func (s *TwoFactorStore) DeleteLoginChallenge(ctx context.Context, tokenHash string) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM login_challenges WHERE token_hash = $1`, tokenHash); err != nil {
+		return fmt.Errorf("two-factor store: delete challenge: %w", errMap(err))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// UserExportStore persists the data export jobs.
+type UserExportStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewUserExportStore(pool *pgxpool.Pool) *UserExportStore {
+	return &UserExportStore{pool: pool}
+}
+
+const userExportColumns = `id, user_id, attempts, COALESCE(last_error, ''), size_bytes,
+       next_attempt_at, created_at, ready_at, failed_at, expires_at`
+
+func scanUserExport(scan func(dest ...any) error) (domain.UserExport, error) {
+	var e domain.UserExport
+	err := scan(&e.ID, &e.UserID, &e.Attempts, &e.LastError, &e.SizeBytes,
+		&e.NextAttemptAt, &e.CreatedAt, &e.ReadyAt, &e.FailedAt, &e.ExpiresAt)
+	return e, err
+}
+
+func (s *UserExportStore) CreateExport(ctx context.Context, e domain.UserExport) error {
+	const q = `
+INSERT INTO user_exports (id, user_id, next_attempt_at, created_at)
+VALUES ($1, $2, $3, $4)`
+	if _, err := s.pool.Exec(ctx, q, e.ID, e.UserID, e.NextAttemptAt, e.CreatedAt); err != nil {
+		return fmt.Errorf("user export store: create: %w", errMap(err))
+	}
+	return nil
+}
+
+// GetExport returns one of userID's exports; ErrNotFound for anyone
+// else's.
+func (s *UserExportStore) GetExport(ctx context.Context, userID, id uuid.UUID) (domain.UserExport, error) {
+	q := `SELECT ` + userExportColumns + ` FROM user_exports WHERE id = $1 AND user_id = $2`
+	e, err := scanUserExport(s.pool.QueryRow(ctx, q, id, userID).Scan)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.UserExport{}, fmt.Errorf("user export store: get: %w", domain.ErrNotFound)
+		}
+		return domain.UserExport{}, fmt.Errorf("user export store: get: %w", errMap(err))
+	}
+	return e, nil
+}
+
+// ListExports returns userID's exports, newest first.
+func (s *UserExportStore) ListExports(ctx context.Context, userID uuid.UUID) ([]domain.UserExport, error) {
+	q := `SELECT ` + userExportColumns + ` FROM user_exports WHERE user_id = $1 ORDER BY created_at DESC`
+	rows, err := s.pool.Query(ctx, q, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user export store: list: %w", errMap(err))
+	}
+	defer rows.Close()
+	out := []domain.UserExport{}
+	for rows.Next() {
+		e, err := scanUserExport(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("user export store: list scan: %w", err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("user export store: list rows: %w", err)
+	}
+	return out, nil
+}
+
+// ClaimExport takes the oldest pending export due at now, counts the
+// attempt and hides it from other workers for lease. ErrNotFound when
+// nothing is due. A worker that dies mid-export leaves its claim to
+// expire, so the export is retried.
+func (s *UserExportStore) ClaimExport(ctx context.Context, now time.Time, lease time.Duration) (domain.UserExport, error) {
+	q := `
+UPDATE user_exports
+   SET attempts = attempts + 1, next_attempt_at = $2
+ WHERE id = (
+       SELECT id FROM user_exports
+        WHERE ready_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1
+        ORDER BY next_attempt_at
+        LIMIT 1
+          FOR UPDATE SKIP LOCKED)
+RETURNING ` + userExportColumns
+	e, err := scanUserExport(s.pool.QueryRow(ctx, q, now, now.Add(lease)).Scan)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.UserExport{}, fmt.Errorf("user export store: claim: %w", domain.ErrNotFound)
+		}
+		return domain.UserExport{}, fmt.Errorf("user export store: claim: %w", errMap(err))
+	}
+	return e, nil
+}
+
+// FinishExport records a written archive of size bytes, downloadable
+// until expiresAt.
+func (s *UserExportStore) FinishExport(ctx context.Context, id uuid.UUID, size int64, at, expiresAt time.Time) error {
+	const q = `
+UPDATE user_exports
+   SET ready_at = $2, expires_at = $3, size_bytes = $4, last_error = NULL
+ WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, at, expiresAt, size); err != nil {
+		return fmt.Errorf("user export store: finish: %w", errMap(err))
+	}
+	return nil
+}
+
+// RetryExport records a failed attempt and schedules the next one.
+func (s *UserExportStore) RetryExport(ctx context.Context, id uuid.UUID, next time.Time, lastErr string) error {
+	const q = `UPDATE user_exports SET next_attempt_at = $2, last_error = $3 WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, next, lastErr); err != nil {
+		return fmt.Errorf("user export store: retry: %w", errMap(err))
+	}
+	return nil
+}
+
+// FailExport gives up on an export.
+func (s *UserExportStore) FailExport(ctx context.Context, id uuid.UUID, at time.Time, lastErr string) error {
+	const q = `UPDATE user_exports SET failed_at = $2, last_error = $3 WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, at, lastErr); err != nil {
+		return fmt.Errorf("user export store: fail: %w", errMap(err))
+	}
+	return nil
+}
+
+// PurgeExports deletes exports that expired, or failed, before before
+// and returns their ids so the caller can remove the archives.
+func (s *UserExportStore) PurgeExports(ctx context.Context, before time.Time) ([]uuid.UUID, error) {
+	const q = `DELETE FROM user_exports WHERE COALESCE(expires_at, failed_at) < $1 RETURNING id`
+	rows, err := s.pool.Query(ctx, q, before)
+	if err != nil {
+		return nil, fmt.Errorf("user export store: purge: %w", errMap(err))
+	}
+	defer rows.Close()
+	var out []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("user export store: purge scan: %w", err)
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("user export store: purge rows: %w", err)
+	}
+	return out, nil
+}
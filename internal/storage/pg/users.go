@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -66,22 +67,43 @@ func (s *UserStore) CreateUserDirect(ctx context.Context, u domain.User) error {
 	return s.Create(ctx, u)
 }
 
+// userColumns selects a users row aliased u, deriving the 2FA flag from
+// a confirmed TOTP enrolment or a WebAuthn credential.
+const userColumns = `u.id, u.username, u.password_hash, u.display_name, u.created_at,
+       EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.confirmed_at IS NOT NULL)
+       OR EXISTS (SELECT 1 FROM webauthn_credentials w WHERE w.user_id = u.id),
+       u.is_admin, u.suspended_at, COALESCE(u.email, '')`
+
 func (s *UserStore) GetByID(ctx context.Context, id uuid.UUID) (domain.User, error) {
-	const q = `
-SELECT id, username, password_hash, display_name, created_at
-  FROM users
- WHERE id = $1`
+	const q = `SELECT ` + userColumns + ` FROM users u WHERE u.id = $1`
 	return s.scanOne(ctx, q, id)
 }
 
 func (s *UserStore) GetByUsername(ctx context.Context, username string) (domain.User, error) {
-	const q = `
-SELECT id, username, password_hash, display_name, created_at
-  FROM users
- WHERE username = $1`
+	const q = `SELECT ` + userColumns + ` FROM users u WHERE u.username = $1`
 	return s.scanOne(ctx, q, username)
 }
 
+// GetByEmail matches the address case-insensitively.
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (domain.User, error) {
+	const q = `SELECT ` + userColumns + ` FROM users u WHERE lower(u.email) = lower($1)`
+	return s.scanOne(ctx, q, email)
+}
+
+// UpdateEmail sets or, with "", clears the user's address. ErrConflict
+// when another account already uses it.
+func (s *UserStore) UpdateEmail(ctx context.Context, id uuid.UUID, email string) error {
+	const q = `UPDATE users SET email = NULLIF($2, '') WHERE id = $1`
+	tag, err := s.pool.Exec(ctx, q, id, email)
+	if err != nil {
+		return fmt.Errorf("user store: update email: %w", errMap(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user store: update email: %w", domain.ErrNotFound)
+	}
+	return nil
+}
+
 func (s *UserStore) UpdateDisplayName(ctx context.Context, id uuid.UUID, name string) error {
 	const q = `UPDATE users SET display_name = $2 WHERE id = $1`
 	tag, err := s.pool.Exec(ctx, q, id, name)
@@ -112,6 +134,102 @@ func (s *UserStore) UpdatePasswordHash(ctx context.Context, id uuid.UUID, newHas
 	return s.UpdatePassword(ctx, id, newHash)
 }
 
+// ReplacePasswordHash swaps oldHash for newHash, reporting whether the
+// stored hash was still oldHash.
+func (s *UserStore) ReplacePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) (bool, error) {
+	const q = `UPDATE users SET password_hash = $3 WHERE id = $1 AND password_hash = $2`
+	tag, err := s.pool.Exec(ctx, q, id, oldHash, newHash)
+	if err != nil {
+		return false, fmt.Errorf("user store: replace password: %w", errMap(err))
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// PromoteAdmin grants server-admin authority to username, reporting
+// whether the flag changed. ErrNotFound when no such user exists.
+func (s *UserStore) PromoteAdmin(ctx context.Context, username string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET is_admin = TRUE WHERE username = $1 AND NOT is_admin`, username)
+	if err != nil {
+		return false, fmt.Errorf("user store: promote admin: %w", errMap(err))
+	}
+	if tag.RowsAffected() == 1 {
+		return true, nil
+	}
+	var exists bool
+	if err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)`, username).Scan(&exists); err != nil {
+		return false, fmt.Errorf("user store: promote admin: %w", errMap(err))
+	}
+	if !exists {
+		return false, fmt.Errorf("user store: promote admin: %w", domain.ErrNotFound)
+	}
+	return false, nil
+}
+
+// SetAdmin grants or withdraws server-admin authority.
+func (s *UserStore) SetAdmin(ctx context.Context, id uuid.UUID, admin bool) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET is_admin = $2 WHERE id = $1`, id, admin)
+	if err != nil {
+		return fmt.Errorf("user store: set admin: %w", errMap(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user store: set admin: %w", domain.ErrNotFound)
+	}
+	return nil
+}
+
+// SetSuspended suspends the account as of at, or lifts the suspension
+// when at is nil.
+func (s *UserStore) SetSuspended(ctx context.Context, id uuid.UUID, at *time.Time) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET suspended_at = $2 WHERE id = $1`, id, at)
+	if err != nil {
+		return fmt.Errorf("user store: set suspended: %w", errMap(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user store: set suspended: %w", domain.ErrNotFound)
+	}
+	return nil
+}
+
+// Search pages through users whose username or display name contains
+// query (case-insensitive; empty matches everyone), ordered by
+// username, and returns the total number of matches.
+func (s *UserStore) Search(ctx context.Context, query string, limit, offset int) ([]domain.User, int, error) {
+	const q = `
+SELECT ` + userColumns + `, COUNT(*) OVER ()
+  FROM users u
+ WHERE $1 = ''
+    OR u.username ILIKE '%' || $1 || '%' ESCAPE '\'
+    OR u.display_name ILIKE '%' || $1 || '%' ESCAPE '\'
+ ORDER BY u.username ASC
+ LIMIT $2 OFFSET $3`
+	rows, err := s.pool.Query(ctx, q, escapeLike(query), limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("user store: search: %w", errMap(err))
+	}
+	defer rows.Close()
+
+	out := []domain.User{}
+	total := 0
+	for rows.Next() {
+		var u domain.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.DisplayName, &u.CreatedAt,
+			&u.TwoFactorEnabled, &u.IsAdmin, &u.SuspendedAt, &u.Email, &total); err != nil {
+			return nil, 0, fmt.Errorf("user store: search scan: %w", err)
+		}
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("user store: search rows: %w", err)
+	}
+	return out, total, nil
+}
+
+// escapeLike quotes the LIKE metacharacters in a user-supplied needle,
+// for a pattern with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
 // CountUsers is used by the auth bootstrap routine to detect first-run state.
 func (s *UserStore) CountUsers(ctx context.Context) (int, error) {
 	const q = `SELECT COUNT(*) FROM users`
@@ -207,7 +325,7 @@ func ownedVaultIDsTx(ctx context.Context, tx pgx.Tx, id uuid.UUID) ([]uuid.UUID,
 func (s *UserStore) scanOne(ctx context.Context, q string, args ...any) (domain.User, error) {
 	var u domain.User
 	err := s.pool.QueryRow(ctx, q, args...).Scan(
-		&u.ID, &u.Username, &u.PasswordHash, &u.DisplayName, &u.CreatedAt,
+		&u.ID, &u.Username, &u.PasswordHash, &u.DisplayName, &u.CreatedAt, &u.TwoFactorEnabled, &u.IsAdmin, &u.SuspendedAt, &u.Email,
 	)
 	if err != nil {
 		if errors.Is(errMap(err), domain.ErrNotFound) {
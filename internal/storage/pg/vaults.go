@@ -77,6 +77,78 @@ SELECT v.id, v.slug, v.name, v.created_by, v.owner_user_id, v.copied_from, v.cre
 	return out, nil
 }
 
+// ListAll returns every vault on the server, ordered by slug. Used by
+// operator tooling (fsck) that walks the whole tree.
+func (s *VaultStore) ListAll(ctx context.Context) ([]domain.Vault, error) {
+	q := `SELECT ` + vaultCols + ` FROM vaults ORDER BY slug ASC`
+	rows, err := s.pool.Query(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("vault store: list all: %w", errMap(err))
+	}
+	defer rows.Close()
+
+	var out []domain.Vault
+	for rows.Next() {
+		v, err := scanVault(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("vault store: list all scan: %w", err)
+		}
+		out = append(out, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("vault store: list all rows: %w", err)
+	}
+	return out, nil
+}
+
+// VaultSummary is the admin overview of one vault: its owner and how
+// much it holds in the database.
+type VaultSummary struct {
+	Vault         domain.Vault
+	OwnerUsername string
+	Members       int
+	Notes         int
+	CRDTBytes     int64
+}
+
+// ListSummaries returns every vault with its owner's username, member
+// and note counts and the size of its CRDT log and snapshots, ordered
+// by slug.
+func (s *VaultStore) ListSummaries(ctx context.Context) ([]VaultSummary, error) {
+	const q = `
+SELECT v.id, v.slug, v.name, v.created_by, v.owner_user_id, v.copied_from, v.created_at,
+       COALESCE(o.username, ''),
+       (SELECT COUNT(*) FROM vault_members m WHERE m.vault_id = v.id),
+       (SELECT COUNT(*) FROM notes n WHERE n.vault_id = v.id),
+       (SELECT COALESCE(SUM(OCTET_LENGTH(update_blob)), 0) FROM note_yjs_updates y WHERE y.vault_id = v.id)
+       + (SELECT COALESCE(SUM(OCTET_LENGTH(state)), 0) FROM note_yjs_snapshots y WHERE y.vault_id = v.id)
+  FROM vaults v
+  LEFT JOIN users o ON o.id = v.owner_user_id
+ ORDER BY v.slug ASC`
+	rows, err := s.pool.Query(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("vault store: list summaries: %w", errMap(err))
+	}
+	defer rows.Close()
+
+	out := []VaultSummary{}
+	for rows.Next() {
+		var sum VaultSummary
+		v, err := scanVault(func(dest ...any) error {
+			return rows.Scan(append(dest, &sum.OwnerUsername, &sum.Members, &sum.Notes, &sum.CRDTBytes)...)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vault store: list summaries scan: %w", err)
+		}
+		sum.Vault = v
+		out = append(out, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("vault store: list summaries rows: %w", err)
+	}
+	return out, nil
+}
+
 func (s *VaultStore) UpdateName(ctx context.Context, id uuid.UUID, name string) error {
 	const q = `UPDATE vaults SET name = $2 WHERE id = $1`
 	tag, err := s.pool.Exec(ctx, q, id, name)
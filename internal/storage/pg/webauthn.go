@@ -0,0 +1,147 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// WebAuthnStore persists WebAuthn credentials and in-flight ceremonies.
+type WebAuthnStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewWebAuthnStore(pool *pgxpool.Pool) *WebAuthnStore {
+	return &WebAuthnStore{pool: pool}
+}
+
+const webauthnColumns = `id, user_id, name, credential_id, public_key, sign_count, transports, created_at, last_used_at`
+
+// CreateCredential inserts a credential. ErrConflict when the credential
+// id is already registered.
+func (s *WebAuthnStore) CreateCredential(ctx context.Context, c domain.WebAuthnCredential) error {
+	if c.Transports == nil {
+		c.Transports = []string{}
+	}
+	const q = `
+INSERT INTO webauthn_credentials (` + webauthnColumns + `)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := s.pool.Exec(ctx, q,
+		c.ID, c.UserID, c.Name, c.CredentialID, c.PublicKey, int64(c.SignCount), c.Transports, c.CreatedAt, c.LastUsedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("webauthn store: create: %w", errMap(err))
+	}
+	return nil
+}
+
+// GetCredential looks a credential up by the authenticator's id for it.
+func (s *WebAuthnStore) GetCredential(ctx context.Context, credentialID []byte) (domain.WebAuthnCredential, error) {
+	const q = `SELECT ` + webauthnColumns + ` FROM webauthn_credentials WHERE credential_id = $1`
+	c, err := scanWebAuthnCredential(s.pool.QueryRow(ctx, q, credentialID))
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.WebAuthnCredential{}, fmt.Errorf("webauthn store: get: %w", domain.ErrNotFound)
+		}
+		return domain.WebAuthnCredential{}, fmt.Errorf("webauthn store: get: %w", errMap(err))
+	}
+	return c, nil
+}
+
+// ListCredentials returns the user's credentials, oldest first.
+func (s *WebAuthnStore) ListCredentials(ctx context.Context, userID uuid.UUID) ([]domain.WebAuthnCredential, error) {
+	const q = `SELECT ` + webauthnColumns + ` FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at`
+	rows, err := s.pool.Query(ctx, q, userID)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn store: list: %w", errMap(err))
+	}
+	defer rows.Close()
+	out := []domain.WebAuthnCredential{}
+	for rows.Next() {
+		c, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("webauthn store: list scan: %w", err)
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("webauthn store: list: %w", errMap(err))
+	}
+	return out, nil
+}
+
+// RecordCredentialUse stores the counter from a successful assertion.
+func (s *WebAuthnStore) RecordCredentialUse(ctx context.Context, id uuid.UUID, signCount uint32, at time.Time) error {
+	const q = `UPDATE webauthn_credentials SET sign_count = $2, last_used_at = $3 WHERE id = $1`
+	if _, err := s.pool.Exec(ctx, q, id, int64(signCount), at); err != nil {
+		return fmt.Errorf("webauthn store: record use: %w", errMap(err))
+	}
+	return nil
+}
+
+// DeleteCredential removes one of the user's credentials. ErrNotFound
+// when the id does not exist or belongs to someone else.
+func (s *WebAuthnStore) DeleteCredential(ctx context.Context, userID, id uuid.UUID) (domain.WebAuthnCredential, error) {
+	const q = `DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2 RETURNING ` + webauthnColumns
+	c, err := scanWebAuthnCredential(s.pool.QueryRow(ctx, q, id, userID))
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.WebAuthnCredential{}, fmt.Errorf("webauthn store: delete: %w", domain.ErrNotFound)
+		}
+		return domain.WebAuthnCredential{}, fmt.Errorf("webauthn store: delete: %w", errMap(err))
+	}
+	return c, nil
+}
+
+func scanWebAuthnCredential(r rowScanner) (domain.WebAuthnCredential, error) {
+	var (
+		c     domain.WebAuthnCredential
+		count int64
+	)
+	if err := r.Scan(&c.ID, &c.UserID, &c.Name, &c.CredentialID, &c.PublicKey, &count,
+		&c.Transports, &c.CreatedAt, &c.LastUsedAt); err != nil {
+		return domain.WebAuthnCredential{}, err
+	}
+	c.SignCount = uint32(count)
+	return c, nil
+}
+
+// ---- Ceremonies ------------------------------------------------------------
+
+// CreateChallenge stores a ceremony, sweeping expired ones first.
+func (s *WebAuthnStore) CreateChallenge(ctx context.Context, c domain.WebAuthnChallenge) error {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM webauthn_challenges WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("webauthn store: sweep challenges: %w", errMap(err))
+	}
+	const q = `
+INSERT INTO webauthn_challenges (challenge, kind, user_id, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)`
+	if _, err := s.pool.Exec(ctx, q, c.Challenge, c.Kind, c.UserID, c.CreatedAt, c.ExpiresAt); err != nil {
+		return fmt.Errorf("webauthn store: create challenge: %w", errMap(err))
+	}
+	return nil
+}
+
+// TakeChallenge removes and returns a ceremony, so each challenge is
+// answered at most once. Expired rows are returned; the caller decides.
+func (s *WebAuthnStore) TakeChallenge(ctx context.Context, challenge []byte) (domain.WebAuthnChallenge, error) {
+	const q = `
+DELETE FROM webauthn_challenges
+ WHERE challenge = $1
+RETURNING challenge, kind, user_id, created_at, expires_at`
+	var c domain.WebAuthnChallenge
+	err := s.pool.QueryRow(ctx, q, challenge).Scan(&c.Challenge, &c.Kind, &c.UserID, &c.CreatedAt, &c.ExpiresAt)
+	if err != nil {
+		if errors.Is(errMap(err), domain.ErrNotFound) {
+			return domain.WebAuthnChallenge{}, fmt.Errorf("webauthn store: take challenge: %w", domain.ErrNotFound)
+		}
+		return domain.WebAuthnChallenge{}, fmt.Errorf("webauthn store: take challenge: %w", errMap(err))
+	}
+	return c, nil
+}
@@ -0,0 +1,510 @@
+package users
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+// ---- Export jobs --------------------------------------------------------
+
+// An export is requested, prepared by a background worker into
+// <dir>/<id>.zip and downloaded later, so a large account never builds
+// its archive in memory or inside a request. Every replica may run the
+// worker; the store's claims keep two of them off the same job. With
+// more than one replica, dir must be shared storage.
+
+// Export job tunables.
+var (
+	ExportPollInterval = 30 * time.Second
+	ExportClaimLease   = 30 * time.Minute
+	ExportMaxAttempts  = 3
+	ExportRetryBackoff = time.Minute
+	ExportLinkTTL      = 72 * time.Hour
+	KeepExports        = 7 * 24 * time.Hour
+)
+
+// Export statuses as reported to the user.
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+var (
+	ErrExportsUnavailable = errors.New("users: data export is not configured")
+	ErrExportNotReady     = errors.New("users: export is not ready")
+	ErrExportExpired      = errors.New("users: export has expired")
+)
+
+// ExportStore persists export jobs. Implemented by pg.UserExportStore.
+type ExportStore interface {
+	CreateExport(ctx context.Context, e domain.UserExport) error
+	GetExport(ctx context.Context, userID, id uuid.UUID) (domain.UserExport, error)
+	ListExports(ctx context.Context, userID uuid.UUID) ([]domain.UserExport, error)
+	ClaimExport(ctx context.Context, now time.Time, lease time.Duration) (domain.UserExport, error)
+	FinishExport(ctx context.Context, id uuid.UUID, size int64, at, expiresAt time.Time) error
+	RetryExport(ctx context.Context, id uuid.UUID, next time.Time, lastErr string) error
+	FailExport(ctx context.Context, id uuid.UUID, at time.Time, lastErr string) error
+	PurgeExports(ctx context.Context, before time.Time) ([]uuid.UUID, error)
+}
+
+// NoteAuthorship finds the notes a user created or edited. Implemented
+// by pg.NoteStore.
+type NoteAuthorship interface {
+	ListAuthoredNotes(ctx context.Context, userID uuid.UUID, vaultIDs []uuid.UUID) ([]domain.Note, error)
+}
+
+// NoteFiles locates note bodies on disk. Implemented by *fs.Manager.
+type NoteFiles interface {
+	NotePath(slug, relativePath string) (string, error)
+}
+
+// InviteLister returns the invites a user issued. Implemented by
+// pg.InviteStore.
+type InviteLister interface {
+	ListByInviter(ctx context.Context, userID uuid.UUID) ([]domain.Invite, error)
+}
+
+// SessionLister returns a user's sessions. Implemented by pg.SessionStore.
+type SessionLister interface {
+	ListSessionsForUser(ctx context.Context, userID uuid.UUID) ([]domain.Session, error)
+}
+
+// ExportSources are the stores an export reads beyond the account
+// itself. All are required.
+type ExportSources struct {
+	Notes    NoteAuthorship
+	Files    NoteFiles
+	Invites  InviteLister
+	Sessions SessionLister
+}
+
+// SetExports enables data exports: jobs in store, archives under dir.
+func (s *Service) SetExports(store ExportStore, dir string, src ExportSources, log zerolog.Logger) error {
+	if store == nil || src.Notes == nil || src.Files == nil || src.Invites == nil || src.Sessions == nil {
+		panic("users.SetExports: store and all sources are required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("users: export dir: %w", err)
+	}
+	s.exports = store
+	s.exportDir = dir
+	s.exportSrc = src
+	s.exportLog = log
+	s.exportWake = make(chan struct{}, 1)
+	return nil
+}
+
+// RequestExport records the request, which starts the rate-limit window
+// (callers check CanExportNow first), and queues an export of id's data.
+func (s *Service) RequestExport(ctx context.Context, id uuid.UUID, ip, ua *string) (domain.UserExport, error) {
+	if s.exports == nil {
+		return domain.UserExport{}, ErrExportsUnavailable
+	}
+	if err := s.RecordExportRequest(ctx, id, ip, ua); err != nil {
+		return domain.UserExport{}, fmt.Errorf("users: audit export request: %w", err)
+	}
+	now := s.now().UTC()
+	e := domain.UserExport{ID: uuid.New(), UserID: id, NextAttemptAt: now, CreatedAt: now}
+	if err := s.exports.CreateExport(ctx, e); err != nil {
+		return domain.UserExport{}, fmt.Errorf("users: request export: %w", err)
+	}
+	select {
+	case s.exportWake <- struct{}{}:
+	default:
+	}
+	return e, nil
+}
+
+// ListExports returns the user's export jobs, newest first.
+func (s *Service) ListExports(ctx context.Context, userID uuid.UUID) ([]domain.UserExport, error) {
+	if s.exports == nil {
+		return nil, ErrExportsUnavailable
+	}
+	return s.exports.ListExports(ctx, userID)
+}
+
+// GetExport returns one of the user's export jobs.
+func (s *Service) GetExport(ctx context.Context, userID, id uuid.UUID) (domain.UserExport, error) {
+	if s.exports == nil {
+		return domain.UserExport{}, ErrExportsUnavailable
+	}
+	return s.exports.GetExport(ctx, userID, id)
+}
+
+// OpenExport opens a ready archive for download. The caller closes it.
+func (s *Service) OpenExport(ctx context.Context, userID, id uuid.UUID) (domain.UserExport, *os.File, error) {
+	e, err := s.GetExport(ctx, userID, id)
+	if err != nil {
+		return domain.UserExport{}, nil, err
+	}
+	switch ExportStatus(e, s.now()) {
+	case ExportReady:
+	case ExportExpired:
+		return e, nil, ErrExportExpired
+	case ExportFailed:
+		return e, nil, fmt.Errorf("users: export failed: %w", domain.ErrNotFound)
+	default:
+		return e, nil, ErrExportNotReady
+	}
+	f, err := os.Open(s.exportPath(e.ID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return e, nil, fmt.Errorf("users: export archive missing: %w", domain.ErrNotFound)
+		}
+		return e, nil, fmt.Errorf("users: open export: %w", err)
+	}
+	return e, f, nil
+}
+
+// OpenLatestExport opens the user's most recently finished archive that
+// can still be downloaded. ErrNotFound when none is ready.
+func (s *Service) OpenLatestExport(ctx context.Context, userID uuid.UUID) (domain.UserExport, *os.File, error) {
+	list, err := s.ListExports(ctx, userID)
+	if err != nil {
+		return domain.UserExport{}, nil, err
+	}
+	now := s.now()
+	var latest *domain.UserExport
+	for i, e := range list {
+		if ExportStatus(e, now) == ExportReady && (latest == nil || e.ReadyAt.After(*latest.ReadyAt)) {
+			latest = &list[i]
+		}
+	}
+	if latest == nil {
+		return domain.UserExport{}, nil, fmt.Errorf("users: no ready export: %w", domain.ErrNotFound)
+	}
+	return s.OpenExport(ctx, userID, latest.ID)
+}
+
+// ExportStatus says where e stands at now.
+func ExportStatus(e domain.UserExport, now time.Time) string {
+	switch {
+	case e.FailedAt != nil:
+		return ExportFailed
+	case e.ReadyAt == nil:
+		return ExportPending
+	case e.ExpiresAt != nil && !e.ExpiresAt.After(now):
+		return ExportExpired
+	default:
+		return ExportReady
+	}
+}
+
+// RunExports prepares queued exports until ctx is cancelled: on every
+// ExportPollInterval, and right after RequestExport on this replica.
+func (s *Service) RunExports(ctx context.Context) {
+	if s.exports == nil {
+		return
+	}
+	tick := time.NewTicker(ExportPollInterval)
+	defer tick.Stop()
+	lastPurge := time.Time{}
+	for {
+		if _, err := s.ProcessExports(ctx); err != nil && ctx.Err() == nil {
+			s.exportLog.Warn().Err(err).Msg("users: process exports")
+		}
+		if now := s.now(); now.Sub(lastPurge) > time.Hour {
+			lastPurge = now
+			s.purgeExports(ctx, now.UTC())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		case <-s.exportWake:
+		}
+	}
+}
+
+// ProcessExports prepares every export due now and reports how many
+// became ready.
+func (s *Service) ProcessExports(ctx context.Context) (int, error) {
+	ready := 0
+	for {
+		e, err := s.exports.ClaimExport(ctx, s.now().UTC(), ExportClaimLease)
+		if err != nil {
+			if errors.Is(err, domain.ErrNotFound) {
+				return ready, nil
+			}
+			return ready, err
+		}
+		if s.runExport(ctx, e) {
+			ready++
+		}
+	}
+}
+
+// runExport writes one claimed export and records the outcome.
+func (s *Service) runExport(ctx context.Context, e domain.UserExport) bool {
+	log := s.exportLog.With().Stringer("export_id", e.ID).Int("attempt", e.Attempts).Logger()
+	size, err := s.writeArchive(ctx, e)
+	now := s.now().UTC()
+	if err == nil {
+		if err := s.exports.FinishExport(ctx, e.ID, size, now, now.Add(ExportLinkTTL)); err != nil {
+			log.Warn().Err(err).Msg("users: export written but not marked ready")
+			return false
+		}
+		return true
+	}
+	if e.Attempts >= ExportMaxAttempts {
+		log.Error().Err(err).Msg("users: giving up on export")
+		if err := s.exports.FailExport(ctx, e.ID, now, err.Error()); err != nil {
+			log.Warn().Err(err).Msg("users: record failed export")
+		}
+		return false
+	}
+	log.Warn().Err(err).Msg("users: export failed; will retry")
+	next := now.Add(ExportRetryBackoff * time.Duration(e.Attempts))
+	if err := s.exports.RetryExport(ctx, e.ID, next, err.Error()); err != nil {
+		log.Warn().Err(err).Msg("users: reschedule export")
+	}
+	return false
+}
+
+// writeArchive writes e's archive next to its final name and renames it
+// into place, so a download never sees half a file.
+func (s *Service) writeArchive(ctx context.Context, e domain.UserExport) (int64, error) {
+	final := s.exportPath(e.ID)
+	tmp := final + ".partial"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("create archive: %w", err)
+	}
+	err = s.writeExport(ctx, e.UserID, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, final)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	info, err := os.Stat(final)
+	if err != nil {
+		return 0, fmt.Errorf("stat archive: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// purgeExports drops jobs that expired or failed more than KeepExports
+// ago, with their archives.
+func (s *Service) purgeExports(ctx context.Context, now time.Time) {
+	ids, err := s.exports.PurgeExports(ctx, now.Add(-KeepExports))
+	if err != nil {
+		if ctx.Err() == nil {
+			s.exportLog.Warn().Err(err).Msg("users: purge exports")
+		}
+		return
+	}
+	for _, id := range ids {
+		s.removeExport(id)
+	}
+	// Rows outlive their links so the user sees "expired" rather than a
+	// 404; the archives go as soon as nobody can download them, together
+	// with partial files a crashed worker left behind.
+	entries, err := os.ReadDir(s.exportDir)
+	if err != nil {
+		s.exportLog.Warn().Err(err).Msg("users: read export dir")
+		return
+	}
+	for _, ent := range entries {
+		info, err := ent.Info()
+		if err == nil && now.Sub(info.ModTime()) > ExportLinkTTL+ExportClaimLease {
+			_ = os.Remove(filepath.Join(s.exportDir, ent.Name()))
+		}
+	}
+}
+
+func (s *Service) exportPath(id uuid.UUID) string {
+	return filepath.Join(s.exportDir, id.String()+".zip")
+}
+
+func (s *Service) removeExport(id uuid.UUID) {
+	if err := os.Remove(s.exportPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.exportLog.Warn().Err(err).Stringer("export_id", id).Msg("users: remove export archive")
+	}
+}
+
+// ---- Archive ------------------------------------------------------------
+
+// writeExport writes id's data as a zip to w: manifest.json, the same
+// sections as separate files, and the body of every note the user
+// created or edited under notes/<vault-slug>/<path>.
+func (s *Service) writeExport(ctx context.Context, id uuid.UUID, w io.Writer) error {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	consents, err := s.consents.ListForUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("consents: %w", err)
+	}
+	auditEntries, err := s.audit.ListForUser(ctx, id, 0, 0)
+	if err != nil {
+		return fmt.Errorf("audit list: %w", err)
+	}
+	vaultList, err := s.vaults.ListForUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("vaults: %w", err)
+	}
+
+	mvs := make([]ManifestVault, 0, len(vaultList))
+	slugs := make(map[uuid.UUID]string, len(vaultList))
+	vaultIDs := make([]uuid.UUID, 0, len(vaultList))
+	for _, v := range vaultList {
+		slugs[v.ID] = v.Slug
+		vaultIDs = append(vaultIDs, v.ID)
+		mv := ManifestVault{ID: v.ID, Slug: v.Slug, Name: v.Name}
+		if s.federations != nil {
+			feds, err := s.federations.ListForVault(ctx, v.ID)
+			if err != nil {
+				return fmt.Errorf("federations: %w", err)
+			}
+			for _, f := range feds {
+				mv.Federations = append(mv.Federations, ManifestFederation{
+					PeerURL:      f.PeerURL,
+					Role:         f.Role,
+					Status:       f.Status,
+					Jurisdiction: f.Jurisdiction,
+				})
+			}
+		}
+		mvs = append(mvs, mv)
+	}
+
+	notes, err := s.exportSrc.Notes.ListAuthoredNotes(ctx, id, vaultIDs)
+	if err != nil {
+		return fmt.Errorf("authored notes: %w", err)
+	}
+	invites, err := s.exportSrc.Invites.ListByInviter(ctx, id)
+	if err != nil {
+		return fmt.Errorf("invites: %w", err)
+	}
+	sessions, err := s.exportSrc.Sessions.ListSessionsForUser(ctx, id)
+	if err != nil {
+		return fmt.Errorf("sessions: %w", err)
+	}
+
+	manifest := Manifest{
+		GeneratedAt: s.now().UTC(),
+		Version:     "1.1",
+		User: ManifestUser{
+			ID:          user.ID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			Email:       user.Email,
+			CreatedAt:   user.CreatedAt,
+		},
+		Vaults:   mvs,
+		Notes:    make([]ManifestNote, 0, len(notes)),
+		Invites:  make([]ManifestInvite, 0, len(invites)),
+		Sessions: make([]ManifestSession, 0, len(sessions)),
+		Audit:    auditEntries,
+		Consents: consents,
+	}
+	for _, inv := range invites {
+		manifest.Invites = append(manifest.Invites, ManifestInvite{
+			VaultID:   inv.VaultID,
+			RoleID:    inv.RoleID,
+			EmailHint: inv.EmailHint,
+			MaxUses:   inv.MaxUses,
+			UseCount:  inv.UseCount,
+			CreatedAt: inv.CreatedAt,
+			ExpiresAt: inv.ExpiresAt,
+			RevokedAt: inv.RevokedAt,
+		})
+	}
+	for _, sess := range sessions {
+		manifest.Sessions = append(manifest.Sessions, ManifestSession{
+			ID:         sess.ID,
+			Device:     sess.DeviceName,
+			IP:         sess.IP,
+			UserAgent:  sess.UserAgent,
+			CreatedAt:  sess.CreatedAt,
+			LastUsedAt: sess.LastUsedAt,
+			ExpiresAt:  sess.ExpiresAt,
+		})
+	}
+
+	zw := zip.NewWriter(w)
+	for _, n := range notes {
+		mn := ManifestNote{
+			VaultID:   n.VaultID,
+			ID:        n.ID,
+			Path:      n.Path,
+			Title:     n.Title,
+			CreatedAt: n.CreatedAt,
+			UpdatedAt: n.UpdatedAt,
+		}
+		if mn.File, err = s.writeNote(zw, slugs[n.VaultID], n); err != nil {
+			return err
+		}
+		manifest.Notes = append(manifest.Notes, mn)
+	}
+	if err := writeJSON(zw, "manifest.json", manifest); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "consents.json", consents); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "audit.json", auditEntries); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "invites.json", manifest.Invites); err != nil {
+		return err
+	}
+	if err := writeJSON(zw, "sessions.json", manifest.Sessions); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("zip close: %w", err)
+	}
+	return nil
+}
+
+// writeNote copies a note's file into the archive and returns its name
+// there; "" when the file is gone from disk.
+func (s *Service) writeNote(zw *zip.Writer, slug string, n domain.Note) (string, error) {
+	rel := path.Clean(filepath.ToSlash(n.Path))
+	if slug == "" || rel == "." || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", nil
+	}
+	full, err := s.exportSrc.Files.NotePath(slug, n.Path)
+	if err != nil {
+		return "", nil
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("open note %s/%s: %w", slug, n.Path, err)
+	}
+	defer f.Close()
+	name := path.Join("notes", slug, rel)
+	dst, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: n.UpdatedAt})
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", name, err)
+	}
+	if _, err := io.Copy(dst, f); err != nil {
+		return "", fmt.Errorf("copy %s: %w", name, err)
+	}
+	return name, nil
+}
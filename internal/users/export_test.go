@@ -0,0 +1,271 @@
+package users
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+
+	"github.com/ViniZap4/lumi-server/internal/domain"
+)
+
+type fakeExportStore struct {
+	jobs map[uuid.UUID]*domain.UserExport
+}
+
+func (f *fakeExportStore) CreateExport(_ context.Context, e domain.UserExport) error {
+	f.jobs[e.ID] = &e
+	return nil
+}
+func (f *fakeExportStore) GetExport(_ context.Context, userID, id uuid.UUID) (domain.UserExport, error) {
+	if e, ok := f.jobs[id]; ok && e.UserID == userID {
+		return *e, nil
+	}
+	return domain.UserExport{}, domain.ErrNotFound
+}
+func (f *fakeExportStore) ListExports(_ context.Context, userID uuid.UUID) ([]domain.UserExport, error) {
+	var out []domain.UserExport
+	for _, e := range f.jobs {
+		if e.UserID == userID {
+			out = append(out, *e)
+		}
+	}
+	return out, nil
+}
+func (f *fakeExportStore) ClaimExport(_ context.Context, now time.Time, lease time.Duration) (domain.UserExport, error) {
+	for _, e := range f.jobs {
+		if e.ReadyAt == nil && e.FailedAt == nil && !e.NextAttemptAt.After(now) {
+			e.Attempts++
+			e.NextAttemptAt = now.Add(lease)
+			return *e, nil
+		}
+	}
+	return domain.UserExport{}, domain.ErrNotFound
+}
+func (f *fakeExportStore) FinishExport(_ context.Context, id uuid.UUID, size int64, at, expiresAt time.Time) error {
+	e := f.jobs[id]
+	e.SizeBytes, e.ReadyAt, e.ExpiresAt = size, &at, &expiresAt
+	return nil
+}
+func (f *fakeExportStore) RetryExport(_ context.Context, id uuid.UUID, next time.Time, lastErr string) error {
+	f.jobs[id].NextAttemptAt, f.jobs[id].LastError = next, lastErr
+	return nil
+}
+func (f *fakeExportStore) FailExport(_ context.Context, id uuid.UUID, at time.Time, lastErr string) error {
+	f.jobs[id].FailedAt, f.jobs[id].LastError = &at, lastErr
+	return nil
+}
+func (f *fakeExportStore) PurgeExports(context.Context, time.Time) ([]uuid.UUID, error) {
+	return nil, nil
+}
+
+type staticVaults []domain.Vault
+
+func (v staticVaults) ListForUser(context.Context, uuid.UUID) ([]domain.Vault, error) {
+	return v, nil
+}
+
+type fakeAuthorship struct {
+	notes    []domain.Note
+	vaultIDs []uuid.UUID
+}
+
+func (f *fakeAuthorship) ListAuthoredNotes(_ context.Context, _ uuid.UUID, vaultIDs []uuid.UUID) ([]domain.Note, error) {
+	f.vaultIDs = vaultIDs
+	return f.notes, nil
+}
+
+type dirFiles string
+
+func (d dirFiles) NotePath(slug, rel string) (string, error) {
+	return filepath.Join(string(d), slug, rel), nil
+}
+
+type fakeInvites []domain.Invite
+
+func (f fakeInvites) ListByInviter(context.Context, uuid.UUID) ([]domain.Invite, error) {
+	return f, nil
+}
+
+type fakeSessions []domain.Session
+
+func (f fakeSessions) ListSessionsForUser(context.Context, uuid.UUID) ([]domain.Session, error) {
+	return f, nil
+}
+
+type exportFixture struct {
+	svc   *Service
+	store *fakeExportStore
+	user  uuid.UUID
+	vault domain.Vault
+}
+
+func newExportFixture(t *testing.T) exportFixture {
+	t.Helper()
+	root := t.TempDir()
+	vault := domain.Vault{ID: uuid.New(), Slug: "team", Name: "Team"}
+	if err := os.MkdirAll(filepath.Join(root, "team", "ideas"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "team", "ideas", "plan.md"), []byte("# Plan\n\nship it\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	user := uuid.New()
+	store := &fakeExportStore{jobs: map[uuid.UUID]*domain.UserExport{}}
+	svc := NewService(&fakeUserRepo{}, fakeConsents{}, fakeAuditReader{}, &fakeRecorder{}, staticVaults{vault})
+	err := svc.SetExports(store, filepath.Join(root, "exports"), ExportSources{
+		Notes: &fakeAuthorship{notes: []domain.Note{
+			{ID: "plan", VaultID: vault.ID, Path: "ideas/plan.md", Title: "Plan"},
+			{ID: "gone", VaultID: vault.ID, Path: "gone.md", Title: "Gone"},
+		}},
+		Files:    dirFiles(root),
+		Invites:  fakeInvites{{Token: "secret-invite", VaultID: vault.ID, InviterUserID: user, MaxUses: 1}},
+		Sessions: fakeSessions{{ID: uuid.New(), Token: "secret-session", UserID: user, DeviceName: "cli"}},
+	}, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exportFixture{svc: svc, store: store, user: user, vault: vault}
+}
+
+func TestWriteExportIncludesAuthoredNotes(t *testing.T) {
+	fx := newExportFixture(t)
+	var buf bytes.Buffer
+	if err := fx.svc.writeExport(context.Background(), fx.user, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	if got := files["notes/team/ideas/plan.md"]; got != "# Plan\n\nship it\n" {
+		t.Fatalf("note body = %q", got)
+	}
+	for _, name := range []string{"manifest.json", "consents.json", "audit.json", "invites.json", "sessions.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("archive lacks %s", name)
+		}
+	}
+	for name, body := range files {
+		if strings.Contains(body, "secret-invite") || strings.Contains(body, "secret-session") {
+			t.Errorf("%s leaks a token", name)
+		}
+	}
+
+	var m Manifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Notes) != 2 || m.Notes[0].File != "notes/team/ideas/plan.md" || m.Notes[1].File != "" {
+		t.Fatalf("manifest notes = %+v, want the plan with its file and the missing one without", m.Notes)
+	}
+	if len(m.Invites) != 1 || len(m.Sessions) != 1 || m.Sessions[0].Device != "cli" {
+		t.Fatalf("manifest invites/sessions = %+v / %+v", m.Invites, m.Sessions)
+	}
+}
+
+func TestProcessExportsWritesArchive(t *testing.T) {
+	fx := newExportFixture(t)
+	ctx := context.Background()
+	e, err := fx.svc.RequestExport(ctx, fx.user, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fx.svc.OpenExport(ctx, fx.user, e.ID); !errors.Is(err, ErrExportNotReady) {
+		t.Fatalf("before the worker ran: %v, want ErrExportNotReady", err)
+	}
+	if n, err := fx.svc.ProcessExports(ctx); err != nil || n != 1 {
+		t.Fatalf("ProcessExports = %d, %v", n, err)
+	}
+
+	got, f, err := fx.svc.OpenExport(ctx, fx.user, e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	if got.SizeBytes == 0 || got.SizeBytes != info.Size() {
+		t.Fatalf("recorded size %d, file has %d", got.SizeBytes, info.Size())
+	}
+	if _, err := os.Stat(fx.svc.exportPath(e.ID) + ".partial"); !os.IsNotExist(err) {
+		t.Fatalf("partial file left behind: %v", err)
+	}
+	if _, _, err := fx.svc.OpenExport(ctx, uuid.New(), e.ID); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("another user's export: %v, want ErrNotFound", err)
+	}
+
+	fx.svc.now = func() time.Time { return time.Now().Add(ExportLinkTTL + time.Minute) }
+	if _, _, err := fx.svc.OpenExport(ctx, fx.user, e.ID); !errors.Is(err, ErrExportExpired) {
+		t.Fatalf("after the link expired: %v, want ErrExportExpired", err)
+	}
+}
+
+func TestDeleteRemovesExportArchives(t *testing.T) {
+	fx := newExportFixture(t)
+	ctx := context.Background()
+	e, _ := fx.svc.RequestExport(ctx, fx.user, nil, nil)
+	if _, err := fx.svc.ProcessExports(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fx.svc.Delete(ctx, fx.user, false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fx.svc.exportPath(e.ID)); !os.IsNotExist(err) {
+		t.Fatalf("archive survived erasure: %v", err)
+	}
+}
+
+func TestOpenLatestExportPicksNewestReady(t *testing.T) {
+	fx := newExportFixture(t)
+	ctx := context.Background()
+	if _, _, err := fx.svc.OpenLatestExport(ctx, fx.user); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("no exports: %v, want ErrNotFound", err)
+	}
+	older, _ := fx.svc.RequestExport(ctx, fx.user, nil, nil)
+	if _, err := fx.svc.ProcessExports(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newer, _ := fx.svc.RequestExport(ctx, fx.user, nil, nil)
+	if _, err := fx.svc.ProcessExports(ctx); err != nil {
+		t.Fatal(err)
+	}
+	later := *fx.store.jobs[older.ID].ReadyAt
+	later = later.Add(time.Second)
+	fx.store.jobs[newer.ID].ReadyAt = &later
+	// Still pending: never picked over a ready archive.
+	if _, err := fx.svc.RequestExport(ctx, fx.user, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	e, f, err := fx.svc.OpenLatestExport(ctx, fx.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if e.ID != newer.ID {
+		t.Fatalf("opened %s, want the newest ready export %s", e.ID, newer.ID)
+	}
+	if _, _, err := fx.svc.OpenLatestExport(ctx, uuid.New()); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("another user: %v, want ErrNotFound", err)
+	}
+}
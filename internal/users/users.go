@@ -6,20 +6,22 @@ package users
 
 import (
 	"archive/zip"
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 
 	"github.com/ViniZap4/lumi-server/internal/audit"
+	"github.com/ViniZap4/lumi-server/internal/capguard"
 	"github.com/ViniZap4/lumi-server/internal/domain"
 )
 
@@ -73,6 +75,11 @@ type Service struct {
 	vaultDirs     VaultDirRemover
 	controlNotify ControlPlaneNotifier
 	federations   FederationLister
+	exports       ExportStore
+	exportDir     string
+	exportSrc     ExportSources
+	exportLog     zerolog.Logger
+	exportWake    chan struct{}
 	now           func() time.Time
 }
 
@@ -183,10 +190,19 @@ func (s *Service) Delete(ctx context.Context, id uuid.UUID, forceVaults bool, ip
 	if s.controlNotify != nil {
 		memberVaults, _ = s.vaults.ListForUser(ctx, id)
 	}
+	// Export rows cascade with the user; their archives hold the user's
+	// data and go with them.
+	var exports []domain.UserExport
+	if s.exports != nil {
+		exports, _ = s.exports.ListExports(ctx, id)
+	}
 
 	if err := s.repo.Delete(ctx, id, forceVaults); err != nil {
 		return err
 	}
+	for _, e := range exports {
+		s.removeExport(e.ID)
+	}
 	// Rows are gone; remove the on-disk directories of the force-deleted
 	// owned vaults. Failures are non-fatal (the DB is authoritative and
 	// the operator can sweep orphans), matching vaults.Service.Delete.
@@ -229,6 +245,9 @@ type Manifest struct {
 	Version     string              `json:"manifest_version"`
 	User        ManifestUser        `json:"user"`
 	Vaults      []ManifestVault     `json:"vaults"`
+	Notes       []ManifestNote      `json:"notes"`
+	Invites     []ManifestInvite    `json:"invites"`
+	Sessions    []ManifestSession   `json:"sessions"`
 	Audit       []domain.AuditEntry `json:"audit"`
 	Consents    []domain.Consent    `json:"consents"`
 }
@@ -237,6 +256,7 @@ type ManifestUser struct {
 	ID          uuid.UUID `json:"id"`
 	Username    string    `json:"username"`
 	DisplayName string    `json:"display_name"`
+	Email       string    `json:"email,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -257,6 +277,42 @@ type ManifestFederation struct {
 	Jurisdiction *string `json:"jurisdiction,omitempty"`
 }
 
+// ManifestNote is a note the user created or edited. File is its body's
+// name in the archive; empty when the file was missing from disk.
+type ManifestNote struct {
+	VaultID   uuid.UUID `json:"vault_id"`
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	File      string    `json:"file,omitempty"`
+}
+
+// ManifestInvite is an invite the user issued. The token is left out:
+// it still admits whoever holds it.
+type ManifestInvite struct {
+	VaultID   uuid.UUID  `json:"vault_id"`
+	RoleID    uuid.UUID  `json:"role_id"`
+	EmailHint string     `json:"email_hint,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	UseCount  int        `json:"use_count"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ManifestSession is one of the user's logins, without its token.
+type ManifestSession struct {
+	ID         uuid.UUID `json:"id"`
+	Device     string    `json:"device,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
 // FederationLister exposes vault federation links for the export manifest.
 type FederationLister interface {
 	ListForVault(ctx context.Context, vaultID uuid.UUID) ([]domain.Federation, error)
@@ -265,76 +321,6 @@ type FederationLister interface {
 // SetFederationLister wires federation visibility into exports; nil disables.
 func (s *Service) SetFederationLister(f FederationLister) { s.federations = f }
 
-// Export builds a zip in memory and returns it. Phase 1 keeps it simple
-// (manifest + consents + audit + vault metadata; not note bodies). Phase 2
-// extends to streaming + per-vault note content.
-func (s *Service) Export(ctx context.Context, id uuid.UUID) ([]byte, error) {
-	user, err := s.repo.GetByID(ctx, id)
-	if err != nil {
-		return nil, err
-	}
-	consents, err := s.consents.ListForUser(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("consents: %w", err)
-	}
-	auditEntries, err := s.audit.ListForUser(ctx, id, 0, 0)
-	if err != nil {
-		return nil, fmt.Errorf("audit list: %w", err)
-	}
-	vaultList, err := s.vaults.ListForUser(ctx, id)
-	if err != nil {
-		return nil, fmt.Errorf("vaults: %w", err)
-	}
-
-	mvs := make([]ManifestVault, 0, len(vaultList))
-	for _, v := range vaultList {
-		mv := ManifestVault{ID: v.ID, Slug: v.Slug, Name: v.Name}
-		if s.federations != nil {
-			if feds, err := s.federations.ListForVault(ctx, v.ID); err == nil {
-				for _, f := range feds {
-					mv.Federations = append(mv.Federations, ManifestFederation{
-						PeerURL:      f.PeerURL,
-						Role:         f.Role,
-						Status:       f.Status,
-						Jurisdiction: f.Jurisdiction,
-					})
-				}
-			}
-		}
-		mvs = append(mvs, mv)
-	}
-
-	manifest := Manifest{
-		GeneratedAt: s.now().UTC(),
-		Version:     "1.0",
-		User: ManifestUser{
-			ID:          user.ID,
-			Username:    user.Username,
-			DisplayName: user.DisplayName,
-			CreatedAt:   user.CreatedAt,
-		},
-		Vaults:   mvs,
-		Audit:    auditEntries,
-		Consents: consents,
-	}
-
-	var buf bytes.Buffer
-	zw := zip.NewWriter(&buf)
-	if err := writeJSON(zw, "manifest.json", manifest); err != nil {
-		return nil, err
-	}
-	if err := writeJSON(zw, "consents.json", consents); err != nil {
-		return nil, err
-	}
-	if err := writeJSON(zw, "audit.json", auditEntries); err != nil {
-		return nil, err
-	}
-	if err := zw.Close(); err != nil {
-		return nil, fmt.Errorf("zip close: %w", err)
-	}
-	return buf.Bytes(), nil
-}
-
 // RecordExportRequest stamps the rate-limit window start.
 func (s *Service) RecordExportRequest(ctx context.Context, id uuid.UUID, ip, ua *string) error {
 	return s.recorder.Record(ctx, domain.AuditEntry{
@@ -376,8 +362,13 @@ func NewHandlers(svc *Service, pc PasswordChecker) *Handlers {
 }
 
 func (h *Handlers) Register(r fiber.Router) {
-	r.Get("/users/me/export", h.Export)
-	r.Delete("/users/me", h.DeleteMe)
+	r.Post("/users/me/exports", capguard.SessionOnly(), h.RequestExport)
+	r.Get("/users/me/exports", capguard.SessionOnly(), h.ListExports)
+	r.Get("/users/me/exports/:id", capguard.SessionOnly(), h.GetExport)
+	r.Get("/users/me/exports/:id/download", capguard.SessionOnly(), h.DownloadExport)
+	// Deprecated: the synchronous export route, kept for one release.
+	r.Get("/users/me/export", capguard.SessionOnly(), h.LegacyExport)
+	r.Delete("/users/me", capguard.SessionOnly(), h.DeleteMe)
 }
 
 func (h *Handlers) currentUser(c *fiber.Ctx) (uuid.UUID, error) {
@@ -392,7 +383,9 @@ func (h *Handlers) currentUser(c *fiber.Ctx) (uuid.UUID, error) {
 	return u.ID, nil
 }
 
-func (h *Handlers) Export(c *fiber.Ctx) error {
+// RequestExport queues a data export. The archive is prepared in the
+// background; poll GetExport until it is ready, then download it.
+func (h *Handlers) RequestExport(c *fiber.Ctx) error {
 	uid, err := h.currentUser(c)
 	if err != nil {
 		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
@@ -411,29 +404,128 @@ func (h *Handlers) Export(c *fiber.Ctx) error {
 		})
 	}
 
-	ip, ua := callerIP(c), callerUA(c)
-	if err := h.svc.RecordExportRequest(ctx, uid, ip, ua); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "audit_unavailable"})
+	e, err := h.svc.RequestExport(ctx, uid, callerIP(c), callerUA(c))
+	if err != nil {
+		return mapErr(c, err)
+	}
+	c.Set(fiber.HeaderLocation, c.Path()+"/"+e.ID.String())
+	return c.Status(http.StatusAccepted).JSON(h.exportDTO(e, c.Path()))
+}
+
+// LegacyExport serves GET /users/me/export, which used to stream the
+// archive. It streams the most recent ready export if there is one and
+// otherwise answers 410 pointing at POST /users/me/exports; a GET never
+// queues a job. Remove it in the next release.
+func (h *Handlers) LegacyExport(c *fiber.Ctx) error {
+	uid, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	successor := c.Path() + "s"
+	c.Set("Deprecation", "true")
+	c.Set(fiber.HeaderLink, "<"+successor+`>; rel="successor-version"`)
+	e, f, err := h.svc.OpenLatestExport(c.UserContext(), uid)
+	if errors.Is(err, domain.ErrNotFound) {
+		return c.Status(http.StatusGone).JSON(fiber.Map{"error": "gone", "use": "POST " + successor})
+	}
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return h.sendExport(c, uid, e, f)
+}
+
+func (h *Handlers) ListExports(c *fiber.Ctx) error {
+	uid, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	list, err := h.svc.ListExports(c.UserContext(), uid)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	out := make([]exportDTO, 0, len(list))
+	for _, e := range list {
+		out = append(out, h.exportDTO(e, c.Path()))
 	}
+	return c.JSON(fiber.Map{"exports": out})
+}
 
-	user, err := h.svc.Get(ctx, uid)
+func (h *Handlers) GetExport(c *fiber.Ctx) error {
+	uid, err := h.currentUser(c)
+	if err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
+	}
+	e, err := h.svc.GetExport(c.UserContext(), uid, id)
 	if err != nil {
 		return mapErr(c, err)
 	}
+	return c.JSON(h.exportDTO(e, strings.TrimSuffix(c.Path(), "/"+c.Params("id"))))
+}
 
-	body, err := h.svc.Export(ctx, uid)
+func (h *Handlers) DownloadExport(c *fiber.Ctx) error {
+	uid, err := h.currentUser(c)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "export_failed"})
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
+	}
+	e, f, err := h.svc.OpenExport(c.UserContext(), uid, id)
+	if err != nil {
+		return mapErr(c, err)
+	}
+	return h.sendExport(c, uid, e, f)
+}
+
+// sendExport streams the archive f of e, owned by uid, and closes it.
+func (h *Handlers) sendExport(c *fiber.Ctx, uid uuid.UUID, e domain.UserExport, f *os.File) error {
+	user, err := h.svc.Get(c.UserContext(), uid)
+	if err != nil {
+		_ = f.Close()
+		return mapErr(c, err)
 	}
 
 	filename := fmt.Sprintf("lumi-export-%s-%s.zip",
 		safeFilename(user.Username),
-		time.Now().UTC().Format("2006-01-02"),
+		e.ReadyAt.UTC().Format("2006-01-02"),
 	)
 	c.Set(fiber.HeaderContentType, "application/zip")
 	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename=%q`, filename))
 	c.Set(fiber.HeaderCacheControl, "no-store")
-	return c.Send(body)
+	// fasthttp closes the file once the body is sent.
+	return c.SendStream(f, int(e.SizeBytes))
+}
+
+type exportDTO struct {
+	ID          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReadyAt     *time.Time `json:"ready_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	SizeBytes   int64      `json:"size_bytes,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// exportDTO describes e; base is the collection path the download link
+// hangs off.
+func (h *Handlers) exportDTO(e domain.UserExport, base string) exportDTO {
+	out := exportDTO{
+		ID:        e.ID,
+		Status:    ExportStatus(e, h.svc.now()),
+		CreatedAt: e.CreatedAt,
+		ReadyAt:   e.ReadyAt,
+		ExpiresAt: e.ExpiresAt,
+	}
+	if out.Status == ExportReady {
+		out.SizeBytes = e.SizeBytes
+		out.DownloadURL = strings.TrimSuffix(base, "/") + "/" + e.ID.String() + "/download"
+	}
+	return out
 }
 
 type deleteMeReq struct {
@@ -459,6 +551,9 @@ func (h *Handlers) DeleteMe(c *fiber.Ctx) error {
 		if errors.Is(err, domain.ErrInvalidCredentials) {
 			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{"error": "invalid_credentials"})
 		}
+		if errors.Is(err, domain.ErrRateLimited) {
+			return c.Status(http.StatusTooManyRequests).JSON(fiber.Map{"error": "rate_limited"})
+		}
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal"})
 	}
 
@@ -519,6 +614,12 @@ func mapErr(c *fiber.Ctx, err error) error {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "validation"})
 	case errors.Is(err, domain.ErrConflict):
 		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "conflict"})
+	case errors.Is(err, ErrExportNotReady):
+		return c.Status(http.StatusConflict).JSON(fiber.Map{"error": "export_not_ready"})
+	case errors.Is(err, ErrExportExpired):
+		return c.Status(http.StatusGone).JSON(fiber.Map{"error": "export_expired"})
+	case errors.Is(err, ErrExportsUnavailable):
+		return c.Status(http.StatusServiceUnavailable).JSON(fiber.Map{"error": "exports_unavailable"})
 	default:
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "internal"})
 	}
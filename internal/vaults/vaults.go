@@ -73,6 +73,9 @@ type Service struct {
 
 	// F3 control plane; nil disables.
 	controlNotify ControlPlaneNotifier
+
+	// CRDT document cache; nil disables.
+	docs DocInvalidator
 }
 
 func NewService(
@@ -114,6 +117,15 @@ type ControlPlaneNotifier interface {
 // SetControlNotifier wires the federation control plane; nil disables.
 func (s *Service) SetControlNotifier(n ControlPlaneNotifier) { s.controlNotify = n }
 
+// DocInvalidator drops cached CRDT documents of a deleted vault.
+// *crdt.Registry satisfies it.
+type DocInvalidator interface {
+	InvalidateVault(vaultID uuid.UUID)
+}
+
+// SetDocInvalidator wires the CRDT document cache; nil disables.
+func (s *Service) SetDocInvalidator(d DocInvalidator) { s.docs = d }
+
 // ---- Slug ------------------------------------------------------------------
 
 var slugRE = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
@@ -326,6 +338,9 @@ func (s *Service) Delete(ctx context.Context, vaultID, actor uuid.UUID, ip, ua s
 	if err := s.repo.Delete(ctx, vaultID); err != nil {
 		return err
 	}
+	if s.docs != nil {
+		s.docs.InvalidateVault(vaultID)
+	}
 	_ = s.fs.RemoveVaultDir(v.Slug)
 	s.recordAudit(ctx, actor, v.ID, domain.ActionVaultDelete, ip, ua, map[string]any{
 		"vault_id": v.ID,
@@ -400,7 +415,7 @@ func NewHandlers(svc *Service) *Handlers {
 
 func (h *Handlers) Register(r fiber.Router) {
 	r.Get("/vaults", h.list)
-	r.Post("/vaults", h.create)
+	r.Post("/vaults", capguard.SessionOnly(), h.create)
 	r.Get("/vaults/:vault", h.detail)
 	r.Patch("/vaults/:vault",
 		capguard.RequireCapability(h.svc.resolver, domain.CapVaultManage),
@@ -412,7 +427,7 @@ func (h *Handlers) Register(r fiber.Router) {
 	)
 	// Owner check happens in the service (only the owner may transfer),
 	// so no capability middleware: a non-owner gets 403 regardless of role.
-	r.Post("/vaults/:vault/transfer-ownership", h.transferOwnership)
+	r.Post("/vaults/:vault/transfer-ownership", capguard.SessionOnly(), h.transferOwnership)
 	r.Post("/vaults/:vault/copies",
 		capguard.RequireCapability(h.svc.resolver, domain.CapVaultExport),
 		h.copy,
@@ -462,8 +477,12 @@ func (h *Handlers) list(c *fiber.Ctx) error {
 	if err != nil {
 		return mapErr(c, err)
 	}
+	sc, scoped := capguard.ScopeFrom(c)
 	out := make([]vaultDTO, 0, len(vs))
 	for _, v := range vs {
+		if scoped && !sc.AllowsVault(v.ID) {
+			continue
+		}
 		out = append(out, toDTO(v))
 	}
 	return c.JSON(fiber.Map{"vaults": out})
@@ -513,6 +532,9 @@ func (h *Handlers) detail(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Status(http.StatusBadRequest).JSON(fiber.Map{"error": "invalid_vault_id"})
 	}
+	if sc, ok := capguard.ScopeFrom(c); ok && !sc.AllowsVault(vaultID) {
+		return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
+	}
 	if _, err := h.svc.resolver.RoleForUser(c.UserContext(), vaultID, u.ID); err != nil {
 		if errors.Is(err, domain.ErrNotFound) {
 			return c.Status(http.StatusNotFound).JSON(fiber.Map{"error": "not_found"})
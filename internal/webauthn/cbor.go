@@ -0,0 +1,207 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A decoder for the subset of CBOR (RFC 8949) that authenticators emit:
+// definite-length items only, integer or text map keys, tags skipped.
+// Values decode to int64, []byte, string, []any, map[any]any, bool, nil
+// or float64.
+
+const cborMaxDepth = 16
+
+var errCBOR = errors.New("webauthn: malformed cbor")
+
+// decodeCBOR decodes the first item in b and returns it with the number
+// of bytes it occupied.
+func decodeCBOR(b []byte) (any, int, error) {
+	d := cborDecoder{b: b}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.off, nil
+}
+
+type cborDecoder struct {
+	b   []byte
+	off int
+}
+
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("%w: nested too deep", errCBOR)
+	}
+	if d.off >= len(d.b) {
+		return nil, fmt.Errorf("%w: truncated", errCBOR)
+	}
+	head := d.b[d.off]
+	d.off++
+	major, info := head>>5, head&0x1f
+
+	if major == 7 {
+		return d.simple(info)
+	}
+	n, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(n), nil
+	case 1:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(n), nil
+	case 2, 3:
+		raw, err := d.take(n)
+		if err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(raw), nil
+		}
+		return append([]byte(nil), raw...), nil
+	case 4:
+		if n > uint64(len(d.b)-d.off) {
+			return nil, fmt.Errorf("%w: truncated", errCBOR)
+		}
+		out := make([]any, 0, n)
+		for range n {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case 5:
+		if n > uint64(len(d.b)-d.off) {
+			return nil, fmt.Errorf("%w: truncated", errCBOR)
+		}
+		out := make(map[any]any, n)
+		for range n {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key %T", errCBOR, k)
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = v
+		}
+		return out, nil
+	default: // 6: tag; the tagged item stands for itself
+		return d.item(depth + 1)
+	}
+}
+
+// argument reads the length or value that follows an item's initial byte.
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.take(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.take(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.take(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.take(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	default:
+		return 0, fmt.Errorf("%w: indefinite or reserved length", errCBOR)
+	}
+}
+
+func (d *cborDecoder) simple(info byte) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+	}
+}
+
+func (d *cborDecoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.b)-d.off) {
+		return nil, fmt.Errorf("%w: truncated", errCBOR)
+	}
+	out := d.b[d.off : d.off+int(n)]
+	d.off += int(n)
+	return out, nil
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		v = -v
+	}
+	return v
+}
@@ -0,0 +1,108 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE (RFC 9053) algorithm identifiers the relying party accepts, in
+// order of preference. They cover platform passkeys (ES256), most
+// security keys (ES256, EdDSA) and Windows Hello (RS256).
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+var supportedAlgs = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters.
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1 // EC2/OKP curve; RSA n
+	coseX   = -2 // EC2/OKP x; RSA e
+	coseY   = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// publicKey is a parsed COSE key.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, accepting only supportedAlgs.
+func parsePublicKey(cose []byte) (publicKey, error) {
+	v, _, err := decodeCBOR(cose)
+	if err != nil {
+		return publicKey{}, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return publicKey{}, fmt.Errorf("%w: public key is not a map", ErrVerification)
+	}
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, fmt.Errorf("%w: bad ES256 key", ErrVerification)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return publicKey{}, fmt.Errorf("%w: bad ES256 key: %v", ErrVerification, err)
+		}
+		return publicKey{alg: alg, key: key}, nil
+
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, fmt.Errorf("%w: bad EdDSA key", ErrVerification)
+		}
+		return publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseCrv)].([]byte)
+		e, _ := m[int64(coseX)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return publicKey{}, fmt.Errorf("%w: bad RS256 key", ErrVerification)
+		}
+		exp := int(new(big.Int).SetBytes(e).Int64())
+		return publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+	return publicKey{}, fmt.Errorf("%w: unsupported key type %d / algorithm %d", ErrVerification, kty, alg)
+}
+
+// verify checks sig over data.
+func (k publicKey) verify(data, sig []byte) bool {
+	switch k.alg {
+	case AlgES256:
+		sum := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(k.key.(*ecdsa.PublicKey), sum[:], sig)
+	case AlgEdDSA:
+		return ed25519.Verify(k.key.(ed25519.PublicKey), data, sig)
+	case AlgRS256:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, sum[:], sig) == nil
+	}
+	return false
+}
@@ -0,0 +1,455 @@
+// Package webauthn implements the relying-party side of WebAuthn Level 3
+// registration and authentication ceremonies, without external
+// dependencies: it builds the options the browser passes to
+// navigator.credentials.create/get and verifies what comes back.
+//
+// Attestation is deliberately not verified. The server asks for
+// attestation "none" and treats any statement it receives the same way:
+// lumi has no authenticator allow-list to enforce, and synced passkeys
+// carry no attestation anyway. What is verified on every ceremony is the
+// client data (type, challenge, origin), the RP ID hash, the user-present
+// and (when required) user-verified flags, and — for authentication —
+// the signature and the signature counter.
+//
+// Byte fields travel as unpadded base64url, matching
+// PublicKeyCredential.toJSON() and parseCreationOptionsFromJSON().
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrVerification is wrapped by every ceremony rejection.
+var ErrVerification = errors.New("webauthn: verification failed")
+
+const (
+	// Timeout is how long a ceremony may take, in the options and for
+	// the server-side challenge alike.
+	Timeout       = 5 * time.Minute
+	challengeSize = 32
+
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// RelyingParty identifies this server to authenticators. ID is the
+// registrable domain credentials are scoped to; Origins are the exact
+// web origins ceremonies may run on.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Enabled reports whether enough is configured to run ceremonies.
+func (rp RelyingParty) Enabled() bool { return rp.ID != "" && len(rp.Origins) > 0 }
+
+// NewChallenge returns a fresh random ceremony challenge.
+func NewChallenge() ([]byte, error) {
+	c := make([]byte, challengeSize)
+	if _, err := rand.Read(c); err != nil {
+		return nil, fmt.Errorf("webauthn: read random: %w", err)
+	}
+	return c, nil
+}
+
+// Encode is the base64url form used for every byte field on the wire.
+func Encode(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// Decode accepts base64url with or without padding.
+func Decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// ---- Options ---------------------------------------------------------------
+
+// User is the account a credential is registered for. ID is the opaque
+// user handle authenticators store and return on usernameless login.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// CredentialDescriptor names an existing credential.
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// Descriptor describes a stored credential for allow/exclude lists.
+func Descriptor(id []byte, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{Type: "public-key", ID: Encode(id), Transports: transports}
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credParam struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is PublicKeyCredentialCreationOptionsJSON.
+type CreationOptions struct {
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []credParam            `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is PublicKeyCredentialRequestOptionsJSON.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions builds registration options. Discoverable credentials
+// are preferred so the key also works for usernameless login; exclude
+// stops the same authenticator registering twice.
+func (rp RelyingParty) CreationOptions(challenge []byte, u User, exclude []CredentialDescriptor) CreationOptions {
+	params := make([]credParam, 0, len(supportedAlgs))
+	for _, alg := range supportedAlgs {
+		params = append(params, credParam{Type: "public-key", Alg: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+	return CreationOptions{
+		RP:                     rpEntity{ID: rp.ID, Name: rp.Name},
+		User:                   userEntity{ID: Encode(u.ID), Name: u.Name, DisplayName: u.DisplayName},
+		Challenge:              Encode(challenge),
+		PubKeyCredParams:       params,
+		Timeout:                Timeout.Milliseconds(),
+		ExcludeCredentials:     exclude,
+		AuthenticatorSelection: authenticatorSelection{ResidentKey: "preferred", UserVerification: "preferred"},
+		Attestation:            "none",
+	}
+}
+
+// RequestOptions builds authentication options. An empty allow list
+// lets the browser offer any discoverable credential for this RP.
+func (rp RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor, userVerification string) RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return RequestOptions{
+		Challenge:        Encode(challenge),
+		Timeout:          Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: userVerification,
+	}
+}
+
+// ---- Responses -------------------------------------------------------------
+
+// AttestationResponse is a registration PublicKeyCredential as
+// serialised by toJSON().
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON"`
+		AttestationObject string   `json:"attestationObject"`
+		Transports        []string `json:"transports"`
+	} `json:"response"`
+}
+
+// AssertionResponse is an authentication PublicKeyCredential as
+// serialised by toJSON().
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+// Challenge returns the challenge the browser signed, so the caller can
+// find the ceremony it belongs to. It is not verified here.
+func (r AttestationResponse) Challenge() ([]byte, error) {
+	return challengeOf(r.Response.ClientDataJSON)
+}
+
+// Challenge returns the challenge the browser signed.
+func (r AssertionResponse) Challenge() ([]byte, error) {
+	return challengeOf(r.Response.ClientDataJSON)
+}
+
+// CredentialID returns the id of the credential that signed.
+func (r AssertionResponse) CredentialID() ([]byte, error) {
+	id, err := Decode(r.RawID)
+	if err != nil || len(id) == 0 {
+		return nil, fmt.Errorf("%w: bad credential id", ErrVerification)
+	}
+	return id, nil
+}
+
+// Credential is a verified registration, ready to store.
+type Credential struct {
+	ID           []byte
+	PublicKey    []byte // COSE_Key
+	SignCount    uint32
+	Transports   []string
+	UserVerified bool
+}
+
+// Assertion is a verified authentication.
+type Assertion struct {
+	UserHandle   []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyRegistration checks a registration response against the
+// challenge issued for it.
+func (rp RelyingParty) VerifyRegistration(r AttestationResponse, challenge []byte, requireUV bool) (Credential, error) {
+	if r.Type != "public-key" {
+		return Credential{}, fmt.Errorf("%w: credential type %q", ErrVerification, r.Type)
+	}
+	if _, err := rp.checkClientData(r.Response.ClientDataJSON, typeCreate, challenge); err != nil {
+		return Credential{}, err
+	}
+	rawAtt, err := Decode(r.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: bad attestation object encoding", ErrVerification)
+	}
+	v, _, err := decodeCBOR(rawAtt)
+	if err != nil {
+		return Credential{}, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	att, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object is not a map", ErrVerification)
+	}
+	rawAuth, ok := att["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object lacks authData", ErrVerification)
+	}
+	ad, err := parseAuthData(rawAuth)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := rp.checkAuthData(ad, requireUV); err != nil {
+		return Credential{}, err
+	}
+	if ad.flags&flagAttested == 0 {
+		return Credential{}, fmt.Errorf("%w: no attested credential data", ErrVerification)
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return Credential{}, err
+	}
+	if rawID, err := Decode(r.RawID); err != nil || !bytes.Equal(rawID, ad.credentialID) {
+		return Credential{}, fmt.Errorf("%w: credential id mismatch", ErrVerification)
+	}
+	return Credential{
+		ID:           ad.credentialID,
+		PublicKey:    ad.publicKey,
+		SignCount:    ad.signCount,
+		Transports:   r.Response.Transports,
+		UserVerified: ad.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// VerifyAssertion checks an authentication response signed by the
+// stored credential (COSE publicKey, last known signCount).
+func (rp RelyingParty) VerifyAssertion(r AssertionResponse, challenge, publicKey []byte, signCount uint32, requireUV bool) (Assertion, error) {
+	if r.Type != "public-key" {
+		return Assertion{}, fmt.Errorf("%w: credential type %q", ErrVerification, r.Type)
+	}
+	clientData, err := rp.checkClientData(r.Response.ClientDataJSON, typeGet, challenge)
+	if err != nil {
+		return Assertion{}, err
+	}
+	rawAuth, err := Decode(r.Response.AuthenticatorData)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: bad authenticator data encoding", ErrVerification)
+	}
+	ad, err := parseAuthData(rawAuth)
+	if err != nil {
+		return Assertion{}, err
+	}
+	if err := rp.checkAuthData(ad, requireUV); err != nil {
+		return Assertion{}, err
+	}
+	sig, err := Decode(r.Response.Signature)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: bad signature encoding", ErrVerification)
+	}
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return Assertion{}, err
+	}
+	clientHash := sha256.Sum256(clientData)
+	if !key.verify(append(rawAuth[:len(rawAuth):len(rawAuth)], clientHash[:]...), sig) {
+		return Assertion{}, fmt.Errorf("%w: bad signature", ErrVerification)
+	}
+	// Authenticators without a counter report 0 forever; any other
+	// value must move forward or the key may have been cloned.
+	if (ad.signCount != 0 || signCount != 0) && ad.signCount <= signCount {
+		return Assertion{}, fmt.Errorf("%w: signature counter went backwards", ErrVerification)
+	}
+	var handle []byte
+	if r.Response.UserHandle != "" {
+		if handle, err = Decode(r.Response.UserHandle); err != nil {
+			return Assertion{}, fmt.Errorf("%w: bad user handle encoding", ErrVerification)
+		}
+	}
+	return Assertion{
+		UserHandle:   handle,
+		SignCount:    ad.signCount,
+		UserVerified: ad.flags&flagUserVerified != 0,
+	}, nil
+}
+
+// ---- Verification helpers --------------------------------------------------
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func challengeOf(clientDataB64 string) ([]byte, error) {
+	raw, err := Decode(clientDataB64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad client data encoding", ErrVerification)
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("%w: bad client data", ErrVerification)
+	}
+	c, err := Decode(cd.Challenge)
+	if err != nil || len(c) == 0 {
+		return nil, fmt.Errorf("%w: bad challenge", ErrVerification)
+	}
+	return c, nil
+}
+
+// checkClientData verifies clientDataJSON and returns its raw bytes,
+// which the signature covers by hash.
+func (rp RelyingParty) checkClientData(clientDataB64, wantType string, challenge []byte) ([]byte, error) {
+	raw, err := Decode(clientDataB64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad client data encoding", ErrVerification)
+	}
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, fmt.Errorf("%w: bad client data", ErrVerification)
+	}
+	if cd.Type != wantType {
+		return nil, fmt.Errorf("%w: client data type %q", ErrVerification, cd.Type)
+	}
+	got, err := Decode(cd.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return nil, fmt.Errorf("%w: challenge mismatch", ErrVerification)
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) {
+		return nil, fmt.Errorf("%w: origin %q not allowed", ErrVerification, cd.Origin)
+	}
+	if cd.CrossOrigin {
+		return nil, fmt.Errorf("%w: cross-origin ceremony", ErrVerification)
+	}
+	return raw, nil
+}
+
+type authData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData splits authenticator data; the attested credential part
+// is only present during registration.
+func parseAuthData(b []byte) (authData, error) {
+	if len(b) < 37 {
+		return authData{}, fmt.Errorf("%w: authenticator data too short", ErrVerification)
+	}
+	ad := authData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+	rest := b[37:]
+	if len(rest) < 18 { // AAGUID + credential id length
+		return authData{}, fmt.Errorf("%w: attested credential data too short", ErrVerification)
+	}
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || n > 1023 || len(rest) < n {
+		return authData{}, fmt.Errorf("%w: bad credential id length", ErrVerification)
+	}
+	ad.credentialID = append([]byte(nil), rest[:n]...)
+	rest = rest[n:]
+	_, used, err := decodeCBOR(rest)
+	if err != nil {
+		return authData{}, fmt.Errorf("%w: credential public key: %v", ErrVerification, err)
+	}
+	ad.publicKey = append([]byte(nil), rest[:used]...)
+	return ad, nil
+}
+
+func (rp RelyingParty) checkAuthData(ad authData, requireUV bool) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.rpIDHash, want[:]) != 1 {
+		return fmt.Errorf("%w: rp id mismatch", ErrVerification)
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrVerification)
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrVerification)
+	}
+	return nil
+}
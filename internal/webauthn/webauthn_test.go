@@ -0,0 +1,252 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// ---- Software authenticator ------------------------------------------------
+
+// cborPair keeps map entries in order so encodings are deterministic.
+type cborPair struct {
+	k, v any
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+}
+
+func cborEncode(v any) []byte {
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return cborHead(1, uint64(-1-x))
+		}
+		return cborHead(0, uint64(x))
+	case []byte:
+		return append(cborHead(2, uint64(len(x))), x...)
+	case string:
+		return append(cborHead(3, uint64(len(x))), x...)
+	case []cborPair:
+		out := cborHead(5, uint64(len(x)))
+		for _, p := range x {
+			out = append(out, cborEncode(p.k)...)
+			out = append(out, cborEncode(p.v)...)
+		}
+		return out
+	}
+	panic("cborEncode: unsupported type")
+}
+
+// softAuthenticator is an ES256 platform authenticator holding one
+// credential.
+type softAuthenticator struct {
+	key    *ecdsa.PrivateKey
+	id     []byte
+	count  uint32
+	rpID   string
+	origin string
+	flags  byte
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &softAuthenticator{key: key, id: id, rpID: rpID, origin: origin, flags: flagUserPresent | flagUserVerified}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	pub, _ := a.key.PublicKey.ECDH()
+	point := pub.Bytes() // 0x04 || x || y
+	return cborEncode([]cborPair{
+		{coseKty, ktyEC2}, {coseAlg, int(AlgES256)}, {coseCrv, crvP256},
+		{coseX, point[1:33]}, {coseY, point[33:]},
+	})
+}
+
+func (a *softAuthenticator) authData(extra []byte, flags byte) []byte {
+	h := sha256.Sum256([]byte(a.rpID))
+	out := append(h[:], flags)
+	out = binary.BigEndian.AppendUint32(out, a.count)
+	return append(out, extra...)
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	b, _ := json.Marshal(map[string]any{"type": typ, "challenge": Encode(challenge), "origin": a.origin})
+	return b
+}
+
+func (a *softAuthenticator) create(challenge []byte) AttestationResponse {
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(append(attested, a.id...), a.coseKey()...)
+	att := cborEncode([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authData(attested, a.flags|flagAttested)},
+	})
+	var r AttestationResponse
+	r.ID, r.RawID, r.Type = Encode(a.id), Encode(a.id), "public-key"
+	r.Response.ClientDataJSON = Encode(a.clientData(typeCreate, challenge))
+	r.Response.AttestationObject = Encode(att)
+	r.Response.Transports = []string{"internal"}
+	return r
+}
+
+func (a *softAuthenticator) get(t *testing.T, challenge []byte, userHandle []byte) AssertionResponse {
+	t.Helper()
+	a.count++
+	ad := a.authData(nil, a.flags)
+	cd := a.clientData(typeGet, challenge)
+	h := sha256.Sum256(cd)
+	digest := sha256.Sum256(append(append([]byte(nil), ad...), h[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r AssertionResponse
+	r.ID, r.RawID, r.Type = Encode(a.id), Encode(a.id), "public-key"
+	r.Response.ClientDataJSON = Encode(cd)
+	r.Response.AuthenticatorData = Encode(ad)
+	r.Response.Signature = Encode(sig)
+	r.Response.UserHandle = Encode(userHandle)
+	return r
+}
+
+// ---- Tests -----------------------------------------------------------------
+
+var testRP = RelyingParty{ID: "lumi.example", Name: "lumi", Origins: []string{"https://lumi.example"}}
+
+func mustChallenge(t *testing.T) []byte {
+	t.Helper()
+	c, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRegisterThenAuthenticate(t *testing.T) {
+	auth := newSoftAuthenticator(t, testRP.ID, testRP.Origins[0])
+
+	regChallenge := mustChallenge(t)
+	reg := auth.create(regChallenge)
+	if got, err := reg.Challenge(); err != nil || string(got) != string(regChallenge) {
+		t.Fatalf("Challenge() = %x, %v", got, err)
+	}
+	cred, err := testRP.VerifyRegistration(reg, regChallenge, true)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if string(cred.ID) != string(auth.id) || !cred.UserVerified {
+		t.Fatalf("credential = %+v", cred)
+	}
+
+	loginChallenge := mustChallenge(t)
+	resp := auth.get(t, loginChallenge, []byte("user-1"))
+	if id, err := resp.CredentialID(); err != nil || string(id) != string(auth.id) {
+		t.Fatalf("CredentialID() = %x, %v", id, err)
+	}
+	as, err := testRP.VerifyAssertion(resp, loginChallenge, cred.PublicKey, cred.SignCount, true)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if as.SignCount != 1 || string(as.UserHandle) != "user-1" {
+		t.Fatalf("assertion = %+v", as)
+	}
+
+	// Replaying the same response is caught by the counter.
+	if _, err := testRP.VerifyAssertion(resp, loginChallenge, cred.PublicKey, as.SignCount, true); !errors.Is(err, ErrVerification) {
+		t.Fatalf("replay: err = %v", err)
+	}
+}
+
+func TestRejectsForgedCeremonies(t *testing.T) {
+	auth := newSoftAuthenticator(t, testRP.ID, testRP.Origins[0])
+	challenge := mustChallenge(t)
+	cred, err := testRP.VerifyRegistration(auth.create(challenge), challenge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newSoftAuthenticator(t, testRP.ID, testRP.Origins[0])
+
+	cases := []struct {
+		name string
+		run  func() error
+	}{
+		{"wrong challenge", func() error {
+			_, err := testRP.VerifyAssertion(auth.get(t, challenge, nil), mustChallenge(t), cred.PublicKey, 0, false)
+			return err
+		}},
+		{"wrong origin", func() error {
+			phish := *auth
+			phish.origin = "https://lumi.example.evil"
+			_, err := testRP.VerifyAssertion(phish.get(t, challenge, nil), challenge, cred.PublicKey, 0, false)
+			return err
+		}},
+		{"wrong rp id", func() error {
+			phish := *auth
+			phish.rpID = "evil.example"
+			_, err := testRP.VerifyAssertion(phish.get(t, challenge, nil), challenge, cred.PublicKey, 0, false)
+			return err
+		}},
+		{"signed by another key", func() error {
+			_, err := testRP.VerifyAssertion(other.get(t, challenge, nil), challenge, cred.PublicKey, 0, false)
+			return err
+		}},
+		{"registration replayed as login", func() error {
+			reg := auth.create(challenge)
+			var r AssertionResponse
+			r.Type, r.RawID = "public-key", reg.RawID
+			r.Response.ClientDataJSON = reg.Response.ClientDataJSON
+			r.Response.AuthenticatorData = Encode(auth.authData(nil, auth.flags))
+			_, err := testRP.VerifyAssertion(r, challenge, cred.PublicKey, 0, false)
+			return err
+		}},
+		{"user verification required", func() error {
+			presenceOnly := *auth
+			presenceOnly.flags = flagUserPresent
+			_, err := testRP.VerifyAssertion(presenceOnly.get(t, challenge, nil), challenge, cred.PublicKey, 0, true)
+			return err
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.run(); !errors.Is(err, ErrVerification) {
+				t.Fatalf("err = %v, want ErrVerification", err)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORRejectsTruncation(t *testing.T) {
+	full := cborEncode([]cborPair{{"fmt", "none"}, {"authData", []byte{1, 2, 3}}})
+	for i := range len(full) {
+		if _, _, err := decodeCBOR(full[:i]); err == nil {
+			t.Fatalf("prefix of %d bytes decoded", i)
+		}
+	}
+	if _, n, err := decodeCBOR(full); err != nil || n != len(full) {
+		t.Fatalf("full: n = %d, err = %v", n, err)
+	}
+}
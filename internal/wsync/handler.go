@@ -12,6 +12,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/ViniZap4/lumi-server/internal/capguard"
+	"github.com/ViniZap4/lumi-server/internal/crdt"
 	"github.com/ViniZap4/lumi-server/internal/domain"
 )
 
@@ -100,6 +101,7 @@ func (h *Handler) upgradeGuard(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_note_id"})
 	}
 	uid, _ := capguard.UserIDFrom(c)
+	sessionID, _ := capguard.SessionIDFrom(c)
 	// Optional presence identity. When the client supplies a valid
 	// UUID we stamp it on the Subscriber so Leave can fan out a
 	// "left" awareness frame. A missing or malformed value is silently
@@ -110,10 +112,22 @@ func (h *Handler) upgradeGuard(c *fiber.Ctx) error {
 			clientID = id
 		}
 	}
+	// Optional update encoding. Rich editors built on Yjs' v2 codec
+	// pass `?encoding=v2`; everything else gets v1. Builds on the
+	// purego CRDT backend cannot speak v2 and refuse it up front.
+	enc, err := crdt.ParseEncoding(strings.TrimSpace(c.Query("encoding")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_encoding"})
+	}
+	if !crdt.SupportsEncoding(enc) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "unsupported_encoding"})
+	}
 	c.Locals("wsync.vault", vaultID)
 	c.Locals("wsync.note", noteID)
 	c.Locals("wsync.user", uid)
+	c.Locals("wsync.session", sessionID)
 	c.Locals("wsync.client", clientID)
+	c.Locals("wsync.encoding", enc)
 	return c.Next()
 }
 
@@ -133,6 +147,7 @@ func (h *Handler) handleConn(c *websocket.Conn) {
 	}
 	userID, _ := c.Locals("wsync.user").(uuid.UUID)
 	clientID, _ := c.Locals("wsync.client").(uuid.UUID)
+	sessionID, _ := c.Locals("wsync.session").(uuid.UUID)
 
 	// Cap memory growth from a hostile peer. Default is unlimited.
 	c.SetReadLimit(MaxFrameBytes)
@@ -147,6 +162,8 @@ func (h *Handler) handleConn(c *websocket.Conn) {
 	defer h.hub.ReleaseUserSlot(userID)
 
 	sub := h.hub.NewSubscriberWithClient(userID, clientID)
+	sub.SessionID = sessionID
+	sub.Encoding, _ = c.Locals("wsync.encoding").(crdt.Encoding)
 	// websocket.Conn doesn't expose UserContext — use a background ctx
 	// for the synchronous Join call (it returns quickly after LoadDoc).
 	room, err := h.hub.Join(context.Background(), vaultID, noteID, sub)
@@ -232,8 +249,9 @@ func (h *Handler) handleMessage(room *Room, sub *Subscriber, userID uuid.UUID, m
 	case MessageSync:
 		switch msg.SyncSub {
 		case SyncStep1:
-			// Client's state vector — answer with our diff.
-			diff, err := room.Doc().EncodeDiffSince(msg.Body)
+			// Client's state vector — answer with our diff in the
+			// encoding the client negotiated.
+			diff, err := room.Doc().EncodeDiffSinceAs(msg.Body, sub.Encoding)
 			if err != nil {
 				return
 			}
@@ -245,6 +263,8 @@ func (h *Handler) handleMessage(room *Room, sub *Subscriber, userID uuid.UUID, m
 		case SyncStep2, SyncUpdate:
 			// Both carry an update blob the server should adopt and
 			// fan out. The distinction matters only to the client.
+			// The blob is in sub.Encoding; ApplyAndBroadcast handles
+			// the conversion.
 			if len(msg.Body) == 0 {
 				return
 			}
@@ -39,8 +39,9 @@ const DefaultMirrorDebounce = 500 * time.Millisecond
 // update arrives over this transport.
 const OriginLive = "web"
 
-// FSMirrorFunc writes the current CRDT text back to the on-disk
-// markdown file. Wired in main.go to notes.Service.WriteBodyFromCRDT.
+// FSMirrorFunc writes the current CRDT body (crdt.Doc.Markdown — the
+// rendered prosemirror fragment for structured notes) back to the
+// on-disk markdown file. Wired in main.go to notes.Service.WriteBodyFromCRDT.
 // Nil means "no mirror" — useful for tests and for deployments that
 // do not yet have notes.Service in the dep graph.
 type FSMirrorFunc func(ctx context.Context, vaultID uuid.UUID, noteID, text string) error
@@ -79,12 +80,22 @@ type Room struct {
 // awareness "left" frame to remaining subscribers so they can drop the
 // peer from their presence list immediately instead of waiting on the
 // client-side TTL.
+//
+// Encoding is the update encoding the client negotiated via
+// `?encoding=v2`. Inbound Step2/Update bodies are decoded with it and
+// outbound updates are re-encoded per subscriber; storage stays v1.
+//
+// SessionID is the login session the connection was opened with, so
+// revoking the session can cut it (see CloseSessions); uuid.Nil for
+// token-authenticated connections.
 type Subscriber struct {
-	UserID   uuid.UUID
-	ClientID uuid.UUID
-	Out      chan []byte
-	Done     chan struct{}
-	doneCh   sync.Once
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+	ClientID  uuid.UUID
+	Encoding  crdt.Encoding
+	Out       chan []byte
+	Done      chan struct{}
+	doneCh    sync.Once
 }
 
 // CloseSubscriber closes Done idempotently so the pump goroutine can exit.
@@ -219,8 +230,10 @@ func (h *Hub) Join(ctx context.Context, vaultID uuid.UUID, noteID string, sub *S
 		h.mu.Unlock()
 		room.cancelIdle()
 	} else {
-		// Load the doc fresh — we don't hold h.mu across LoadDoc to
-		// avoid serialising opens of distinct notes.
+		// Borrow the doc from the registry cache (REST, FS and
+		// federation writes land on the same YDoc while it is cached) —
+		// we don't hold h.mu across LoadDoc to avoid serialising opens
+		// of distinct notes.
 		h.mu.Unlock()
 		doc, err := h.registry.LoadDoc(ctx, vaultID, noteID)
 		if err != nil {
@@ -235,7 +248,7 @@ func (h *Hub) Join(ctx context.Context, vaultID uuid.UUID, noteID string, sub *S
 			persistCtx: h.rootCtx,
 		}
 		// Race window: two concurrent first-joiners could each LoadDoc.
-		// The loser closes its doc and adopts the winner's.
+		// The loser closes its handle and adopts the winner's.
 		h.mu.Lock()
 		if existing, ok2 := h.rooms[key]; ok2 {
 			h.mu.Unlock()
@@ -293,8 +306,13 @@ const OriginFSWatcher = "fs-watcher"
 // ApplyAndBroadcast applies update to the room's doc, persists it via
 // the CRDT registry, and fans it out to every subscriber except origin.
 // originUser is the user id recorded in note_yjs_updates.origin_user_id.
+// update is in origin's negotiated Encoding (v1 when origin is nil).
 func (r *Room) ApplyAndBroadcast(update []byte, origin *Subscriber, originUser uuid.UUID) error {
-	return r.applyAndBroadcastWithOrigin(update, origin, originUser, OriginLive)
+	enc := crdt.EncodingV1
+	if origin != nil {
+		enc = origin.Encoding
+	}
+	return r.applyAndBroadcastWithOrigin(update, enc, origin, originUser, OriginLive)
 }
 
 // ApplyAndBroadcastFromFS applies an externally-sourced update (the FS
@@ -303,7 +321,7 @@ func (r *Room) ApplyAndBroadcast(update []byte, origin *Subscriber, originUser u
 // connection to suppress because the change did not originate from
 // the WS transport.
 func (r *Room) ApplyAndBroadcastFromFS(update []byte) error {
-	return r.applyAndBroadcastWithOrigin(update, nil, uuid.Nil, OriginFSWatcher)
+	return r.applyAndBroadcastWithOrigin(update, crdt.EncodingV1, nil, uuid.Nil, OriginFSWatcher)
 }
 
 // ApplyAndBroadcastFederation applies an update relayed from a federated
@@ -313,24 +331,59 @@ func (r *Room) ApplyAndBroadcastFromFS(update []byte) error {
 // originKind is "federation:<peer-url>" so the registry persist hook can
 // suppress echoing the update back to its source link.
 func (r *Room) ApplyAndBroadcastFederation(update []byte, originKind string) error {
-	return r.applyAndBroadcastWithOrigin(update, nil, uuid.Nil, originKind)
+	return r.applyAndBroadcastWithOrigin(update, crdt.EncodingV1, nil, uuid.Nil, originKind)
+}
+
+// ApplyAndBroadcastFederationAs is ApplyAndBroadcastFederation for an
+// update in an explicit encoding (a peer's v2 noteSync frame).
+func (r *Room) ApplyAndBroadcastFederationAs(update []byte, enc crdt.Encoding, originKind string) error {
+	return r.applyAndBroadcastWithOrigin(update, enc, nil, uuid.Nil, originKind)
 }
 
-func (r *Room) applyAndBroadcastWithOrigin(update []byte, origin *Subscriber, originUser uuid.UUID, originKind string) error {
+func (r *Room) applyAndBroadcastWithOrigin(update []byte, enc crdt.Encoding, origin *Subscriber, originUser uuid.UUID, originKind string) error {
 	if r.evicted.Load() {
 		return fmt.Errorf("wsync: room evicted")
 	}
-	if err := r.doc.ApplyUpdate(update); err != nil {
-		return err
+	// v1 is what we persist and what v1 subscribers get; v2 is only
+	// produced when someone in the room speaks it. For a v1 update the
+	// v2 form is the doc's diff against its pre-apply state vector.
+	var v1, v2 []byte
+	switch {
+	case enc == crdt.EncodingV2:
+		var err error
+		if v1, err = r.doc.ApplyUpdateV2(update); err != nil {
+			return err
+		}
+		if len(v1) == 0 {
+			return nil
+		}
+		v2 = update
+	case r.hasEncoding(crdt.EncodingV2, origin):
+		svBefore, err := r.doc.StateVectorV1()
+		if err != nil {
+			return err
+		}
+		if err := r.doc.ApplyUpdate(update); err != nil {
+			return err
+		}
+		v1 = update
+		if v2, err = r.doc.EncodeDiffSinceV2(svBefore); err != nil {
+			return err
+		}
+	default:
+		if err := r.doc.ApplyUpdate(update); err != nil {
+			return err
+		}
+		v1 = update
 	}
 	// Persist before broadcasting so a crash between the two doesn't
 	// leave subscribers with state the server then loses.
 	if err := r.hub.registry.PersistChange(
-		r.persistCtx, r.vaultID, r.noteID, update, originUser, originKind, r.doc,
+		r.persistCtx, r.vaultID, r.noteID, v1, originUser, originKind, r.doc,
 	); err != nil {
 		return err
 	}
-	r.broadcast(EncodeSyncUpdate(update), origin)
+	r.broadcastUpdate(v1, v2, origin)
 	// Schedule the FS mirror. We do NOT mirror FS-originated updates
 	// — the file is already the source for that change; mirroring
 	// would race the fswatch suppression window.
@@ -366,7 +419,7 @@ func (r *Room) fireMirror() {
 	if fn == nil {
 		return
 	}
-	text, err := r.doc.Text()
+	text, err := r.doc.Markdown()
 	if err != nil {
 		return
 	}
@@ -406,6 +459,55 @@ func (r *Room) broadcastPresenceLeave(clientID uuid.UUID) {
 	r.broadcast(EncodeAwareness(payload), nil)
 }
 
+// hasEncoding reports whether any subscriber other than origin
+// negotiated enc.
+func (r *Room) hasEncoding(enc crdt.Encoding, origin *Subscriber) bool {
+	r.subsMu.RLock()
+	defer r.subsMu.RUnlock()
+	for s := range r.subs {
+		if s != origin && s.Encoding == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// broadcastUpdate fans an update out like broadcast, picking the v1 or
+// v2 frame per subscriber. v2 is nil when no v2 subscriber was present
+// at apply time; a v2 subscriber that joined in between is skipped —
+// its own SyncStep1 exchange already covers the update.
+func (r *Room) broadcastUpdate(v1, v2 []byte, origin *Subscriber) {
+	msgV1 := EncodeSyncUpdate(v1)
+	var msgV2 []byte
+	if v2 != nil {
+		msgV2 = EncodeSyncUpdate(v2)
+	}
+	r.subsMu.RLock()
+	subs := make([]*Subscriber, 0, len(r.subs))
+	for s := range r.subs {
+		if s == origin {
+			continue
+		}
+		subs = append(subs, s)
+	}
+	r.subsMu.RUnlock()
+
+	for _, s := range subs {
+		msg := msgV1
+		if s.Encoding == crdt.EncodingV2 {
+			if msgV2 == nil {
+				continue
+			}
+			msg = msgV2
+		}
+		select {
+		case s.Out <- msg:
+		default:
+			s.CloseSubscriber()
+		}
+	}
+}
+
 // broadcast pushes msg into every subscriber's Out channel except
 // origin. Subscribers whose outbound queue is full are dropped (their
 // Done is closed); the pump will catch up next.
@@ -467,7 +569,8 @@ func (r *Room) evict() {
 	r.mirrorMu.Unlock()
 
 	// Signal any remaining subscribers (Close should have drained, but
-	// defensive) and close the doc.
+	// defensive) and release our handle on the doc. The registry cache
+	// keeps it warm for the next Join unless LRU pressure drops it.
 	r.subsMu.Lock()
 	subs := make([]*Subscriber, 0, len(r.subs))
 	for s := range r.subs {
@@ -512,6 +615,64 @@ func (h *Hub) RoomIfActive(vaultID uuid.UUID, noteID string) *Room {
 	return h.rooms[roomKey(vaultID, noteID)]
 }
 
+// CloseRoom evicts the live Room for (vaultID, noteID), if any,
+// disconnecting its subscribers. Used when the note is deleted out from
+// under its editors; a reconnecting client then finds it gone instead
+// of editing a document nothing persists anymore.
+func (h *Hub) CloseRoom(vaultID uuid.UUID, noteID string) {
+	if r := h.RoomIfActive(vaultID, noteID); r != nil {
+		r.evict()
+	}
+}
+
+// CloseSessions disconnects every subscriber opened with one of the
+// given login sessions and returns how many were closed. Called when
+// sessions are revoked so a stolen token cannot keep editing over an
+// already-open socket.
+func (h *Hub) CloseSessions(ids ...uuid.UUID) int {
+	if len(ids) == 0 {
+		return 0
+	}
+	want := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		if id != uuid.Nil {
+			want[id] = true
+		}
+	}
+	return h.closeWhere(func(sub *Subscriber) bool { return want[sub.SessionID] })
+}
+
+// CloseUser disconnects every subscriber of the user, whether it was
+// opened with a session or a personal access token. Called when the
+// account is suspended.
+func (h *Hub) CloseUser(userID uuid.UUID) int {
+	if userID == uuid.Nil {
+		return 0
+	}
+	return h.closeWhere(func(sub *Subscriber) bool { return sub.UserID == userID })
+}
+
+func (h *Hub) closeWhere(match func(*Subscriber) bool) int {
+	h.mu.Lock()
+	rooms := make([]*Room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	h.mu.Unlock()
+	n := 0
+	for _, r := range rooms {
+		r.subsMu.RLock()
+		for sub := range r.subs {
+			if match(sub) {
+				sub.CloseSubscriber()
+				n++
+			}
+		}
+		r.subsMu.RUnlock()
+	}
+	return n
+}
+
 // TryAcquireUserSlot atomically checks the per-user WS cap and
 // increments the counter on success. Returns false if the cap is
 // already reached. Pair every successful call with ReleaseUserSlot.
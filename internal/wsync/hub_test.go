@@ -203,6 +203,80 @@ func TestRoomBroadcastsToOthers(t *testing.T) {
 	}
 }
 
+func TestRoomBroadcastsPerSubscriberEncoding(t *testing.T) {
+	if !crdt.SupportsEncoding(crdt.EncodingV2) {
+		t.Skipf("%s backend has no lib0-v2 support", crdt.Backend)
+	}
+	repo := newMemRepo()
+	reg := crdt.NewRegistry(repo)
+	hub := NewHub(reg, WithIdleTTL(50*time.Millisecond))
+	defer hub.Close()
+
+	vault := uuid.New()
+	subA := hub.NewSubscriber(uuid.New())
+	subB := hub.NewSubscriber(uuid.New())
+	subB.Encoding = crdt.EncodingV2
+	room, err := hub.Join(context.Background(), vault, "n", subA)
+	if err != nil {
+		t.Fatalf("Join A: %v", err)
+	}
+	defer hub.Leave(room, subA)
+	if _, err := hub.Join(context.Background(), vault, "n", subB); err != nil {
+		t.Fatalf("Join B: %v", err)
+	}
+	defer hub.Leave(room, subB)
+
+	// A fresh peer doc produces the v1 update A sends.
+	peer := crdt.NewDoc()
+	defer peer.Close()
+	update, err := peer.ApplyTextDiff("typed by A", "test")
+	if err != nil {
+		t.Fatalf("ApplyTextDiff: %v", err)
+	}
+	if err := room.ApplyAndBroadcast(update, subA, subA.UserID); err != nil {
+		t.Fatalf("ApplyAndBroadcast: %v", err)
+	}
+
+	select {
+	case msg := <-subB.Out:
+		parsed, err := DecodeMessage(msg)
+		if err != nil || parsed.SyncSub != SyncUpdate {
+			t.Fatalf("B frame: %+v %v", parsed, err)
+		}
+		got := crdt.NewDoc()
+		defer got.Close()
+		if _, err := got.ApplyUpdateV2(parsed.Body); err != nil {
+			t.Fatalf("B body is not a v2 update: %v", err)
+		}
+		if txt, _ := got.Text(); txt != "typed by A" {
+			t.Fatalf("B text = %q", txt)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("B did not receive broadcast")
+	}
+
+	// B answers in v2; storage must still receive v1.
+	if _, err := peer.ApplyTextDiff("typed by A, then B", "test"); err != nil {
+		t.Fatalf("ApplyTextDiff: %v", err)
+	}
+	v2, err := peer.EncodeStateAsUpdateV2()
+	if err != nil {
+		t.Fatalf("EncodeStateAsUpdateV2: %v", err)
+	}
+	if err := room.ApplyAndBroadcast(v2, subB, subB.UserID); err != nil {
+		t.Fatalf("ApplyAndBroadcast v2: %v", err)
+	}
+	fresh := crdt.NewRegistry(repo, crdt.WithCacheBytes(0))
+	d, err := fresh.LoadDoc(context.Background(), vault, "n")
+	if err != nil {
+		t.Fatalf("LoadDoc: %v", err)
+	}
+	defer d.Close()
+	if txt, _ := d.Text(); txt != "typed by A, then B" {
+		t.Fatalf("persisted text = %q", txt)
+	}
+}
+
 func TestHubCloseSignalsSubscribers(t *testing.T) {
 	repo := newMemRepo()
 	reg := crdt.NewRegistry(repo)
@@ -278,6 +352,109 @@ func TestHubNoGoroutineLeakAfterClose(t *testing.T) {
 	}
 }
 
+func TestCloseRoomDisconnectsOnlyThatRoom(t *testing.T) {
+	repo := newMemRepo()
+	reg := crdt.NewRegistry(repo)
+	hub := NewHub(reg)
+	defer hub.Close()
+
+	vault := uuid.New()
+	gone, kept := hub.NewSubscriber(uuid.New()), hub.NewSubscriber(uuid.New())
+	room, err := hub.Join(context.Background(), vault, "gone", gone)
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if _, err := hub.Join(context.Background(), vault, "kept", kept); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+
+	hub.CloseRoom(vault, "gone")
+	select {
+	case <-gone.Done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("subscriber of the closed room still connected")
+	}
+	select {
+	case <-kept.Done:
+		t.Fatal("CloseRoom disconnected another room")
+	default:
+	}
+	if hub.RoomIfActive(vault, "gone") != nil {
+		t.Fatal("closed room still indexed")
+	}
+	// The pump's deferred Leave on the evicted room must be harmless.
+	hub.Leave(room, gone)
+	hub.CloseRoom(vault, "missing")
+}
+
+func TestCloseSessionsDisconnectsOnlyThatSession(t *testing.T) {
+	repo := newMemRepo()
+	reg := crdt.NewRegistry(repo)
+	hub := NewHub(reg)
+	defer hub.Close()
+
+	vault, user := uuid.New(), uuid.New()
+	revoked, other := uuid.New(), uuid.New()
+	var subs []*Subscriber
+	for i, sess := range []uuid.UUID{revoked, other, revoked, uuid.Nil} {
+		s := hub.NewSubscriber(user)
+		s.SessionID = sess
+		if _, err := hub.Join(context.Background(), vault, "n"+string(rune('0'+i%2)), s); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+		subs = append(subs, s)
+	}
+
+	if n := hub.CloseSessions(revoked, uuid.Nil); n != 2 {
+		t.Fatalf("CloseSessions = %d, want 2", n)
+	}
+	for i, s := range subs {
+		closed := false
+		select {
+		case <-s.Done:
+			closed = true
+		default:
+		}
+		if want := s.SessionID == revoked; closed != want {
+			t.Fatalf("subscriber %d closed = %v, want %v", i, closed, want)
+		}
+	}
+}
+
+func TestCloseUserDisconnectsEveryConnection(t *testing.T) {
+	repo := newMemRepo()
+	reg := crdt.NewRegistry(repo)
+	hub := NewHub(reg)
+	defer hub.Close()
+
+	vault, suspended, other := uuid.New(), uuid.New(), uuid.New()
+	viaSession := hub.NewSubscriber(suspended)
+	viaSession.SessionID = uuid.New()
+	viaToken := hub.NewSubscriber(suspended)
+	bystander := hub.NewSubscriber(other)
+	for _, s := range []*Subscriber{viaSession, viaToken, bystander} {
+		if _, err := hub.Join(context.Background(), vault, "n", s); err != nil {
+			t.Fatalf("Join: %v", err)
+		}
+	}
+
+	if n := hub.CloseUser(suspended); n != 2 {
+		t.Fatalf("CloseUser = %d, want 2", n)
+	}
+	for _, s := range []*Subscriber{viaSession, viaToken} {
+		select {
+		case <-s.Done:
+		default:
+			t.Fatal("suspended user's subscriber still open")
+		}
+	}
+	select {
+	case <-bystander.Done:
+		t.Fatal("other user's subscriber closed")
+	default:
+	}
+}
+
 func TestRoomEvictsAfterIdle(t *testing.T) {
 	repo := newMemRepo()
 	reg := crdt.NewRegistry(repo)
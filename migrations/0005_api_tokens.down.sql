@@ -0,0 +1,3 @@
+-- 0005_api_tokens.down.sql
+
+DROP TABLE api_tokens;
@@ -0,0 +1,17 @@
+-- 0005_api_tokens.up.sql
+-- Personal access tokens for scripts and terminal clients. Only the
+-- SHA-256 of the secret is stored. vault_id NULL means every vault the
+-- user belongs to; capabilities is a JSON array in the vault_roles
+-- format and is intersected with the user's role on every request.
+CREATE TABLE api_tokens (
+  id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id      UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name         TEXT NOT NULL,
+  token_hash   TEXT NOT NULL UNIQUE,
+  vault_id     UUID REFERENCES vaults(id) ON DELETE CASCADE,
+  capabilities JSONB NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at   TIMESTAMPTZ NOT NULL,
+  last_used_at TIMESTAMPTZ
+);
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
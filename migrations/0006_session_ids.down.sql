@@ -0,0 +1,6 @@
+-- 0006_session_ids.down.sql
+
+ALTER TABLE sessions DROP COLUMN user_agent;
+ALTER TABLE sessions DROP COLUMN ip;
+ALTER TABLE sessions DROP CONSTRAINT sessions_id_key;
+ALTER TABLE sessions DROP COLUMN id;
@@ -0,0 +1,8 @@
+-- 0006_session_ids.up.sql
+-- Sessions get a public id so users can list and revoke them without the
+-- raw token ever leaving the login response, plus the client they were
+-- issued to. Existing rows get a fresh id and no client details.
+ALTER TABLE sessions ADD COLUMN id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE sessions ADD CONSTRAINT sessions_id_key UNIQUE (id);
+ALTER TABLE sessions ADD COLUMN ip TEXT;
+ALTER TABLE sessions ADD COLUMN user_agent TEXT;
@@ -0,0 +1,5 @@
+-- 0007_two_factor.down.sql
+
+DROP TABLE login_challenges;
+DROP TABLE recovery_codes;
+DROP TABLE user_totp;
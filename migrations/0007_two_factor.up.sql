@@ -0,0 +1,29 @@
+-- 0007_two_factor.up.sql
+-- TOTP second factor. user_totp holds one authenticator secret per user,
+-- pending until confirmed with a first code; last_step blocks replaying
+-- an accepted code. Recovery codes and login challenges store SHA-256
+-- hashes only.
+CREATE TABLE user_totp (
+  user_id      UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  secret       TEXT NOT NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  confirmed_at TIMESTAMPTZ,
+  last_step    BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes (
+  user_id   UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  code_hash TEXT NOT NULL,
+  used_at   TIMESTAMPTZ,
+  PRIMARY KEY (user_id, code_hash)
+);
+
+-- Issued after a correct password when a second factor is due.
+CREATE TABLE login_challenges (
+  token_hash TEXT PRIMARY KEY,
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  attempts   INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX login_challenges_expires_at_idx ON login_challenges (expires_at);
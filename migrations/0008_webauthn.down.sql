@@ -0,0 +1,4 @@
+-- 0008_webauthn.down.sql
+
+DROP TABLE webauthn_challenges;
+DROP TABLE webauthn_credentials;
@@ -0,0 +1,28 @@
+-- 0008_webauthn.up.sql
+-- WebAuthn credentials (passkeys and security keys). credential_id is
+-- the authenticator's handle, public_key the COSE-encoded key it
+-- registered; sign_count tracks the authenticator's counter so a cloned
+-- key shows up as a regression.
+CREATE TABLE webauthn_credentials (
+  id            UUID PRIMARY KEY,
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  name          TEXT NOT NULL,
+  credential_id BYTEA NOT NULL UNIQUE,
+  public_key    BYTEA NOT NULL,
+  sign_count    BIGINT NOT NULL DEFAULT 0,
+  transports    TEXT[] NOT NULL DEFAULT '{}',
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_used_at  TIMESTAMPTZ
+);
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+-- One row per ceremony in flight, keyed by the challenge the browser
+-- echoes back in clientDataJSON. Rows are consumed on first use.
+CREATE TABLE webauthn_challenges (
+  challenge  BYTEA PRIMARY KEY,
+  kind       TEXT NOT NULL,
+  user_id    UUID REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX webauthn_challenges_expires_at_idx ON webauthn_challenges (expires_at);
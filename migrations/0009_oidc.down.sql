@@ -0,0 +1,4 @@
+-- 0009_oidc.down.sql
+
+DROP TABLE oidc_states;
+DROP TABLE user_identities;
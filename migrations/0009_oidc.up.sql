@@ -0,0 +1,26 @@
+-- 0009_oidc.up.sql
+-- OpenID Connect sign-in. user_identities links a provider subject to a
+-- lumi account; the pair (issuer, subject) is the stable identity, never
+-- the email or username claims. oidc_states holds each authorization
+-- request in flight between /start and the callback, keyed by the hash
+-- of its state parameter.
+CREATE TABLE user_identities (
+  issuer     TEXT NOT NULL,
+  subject    TEXT NOT NULL,
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE oidc_states (
+  state_hash      TEXT PRIMARY KEY,
+  nonce           TEXT NOT NULL,
+  code_verifier   TEXT NOT NULL,
+  link_user_id    UUID REFERENCES users(id) ON DELETE CASCADE,
+  tos_version     TEXT NOT NULL DEFAULT '',
+  privacy_version TEXT NOT NULL DEFAULT '',
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX oidc_states_expires_at_idx ON oidc_states (expires_at);
@@ -0,0 +1,4 @@
+-- 0010_password_resets.down.sql
+
+DROP TABLE password_resets;
+ALTER TABLE users DROP COLUMN is_admin;
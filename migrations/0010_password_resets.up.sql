@@ -0,0 +1,15 @@
+-- 0010_password_resets.up.sql
+-- Server administrators and admin-issued password resets. is_admin is
+-- server-wide authority, separate from vault roles; the bootstrap admin
+-- (LUMI_ADMIN_USERNAME) is promoted at boot. A password reset token is
+-- shown once to the issuing admin and stored hashed; redeeming it
+-- deletes the row, and issuing a new one replaces any still pending.
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE password_resets (
+  token_hash TEXT PRIMARY KEY,
+  user_id    UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+  created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,3 @@
+-- 0011_admin.down.sql
+
+ALTER TABLE users DROP COLUMN suspended_at;
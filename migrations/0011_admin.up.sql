@@ -0,0 +1,5 @@
+-- 0011_admin.up.sql
+-- Account suspension for the admin API. A suspended account keeps its
+-- data, memberships and vault ownership, but none of its credentials
+-- are accepted until an admin lifts the suspension.
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMPTZ;
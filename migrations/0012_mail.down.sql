@@ -0,0 +1,5 @@
+-- 0012_mail.down.sql
+
+DROP TABLE mail_outbox;
+DROP INDEX users_email_key;
+ALTER TABLE users DROP COLUMN email;
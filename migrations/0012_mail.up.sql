@@ -0,0 +1,24 @@
+-- 0012_mail.up.sql
+-- Outgoing email. users.email is optional, unique regardless of case,
+-- and is where password reset links and security alerts go. mail_outbox
+-- holds rendered messages until the worker hands them to the mail
+-- transport; a failed attempt pushes next_attempt_at back, and failed_at
+-- marks a message given up on after too many.
+ALTER TABLE users ADD COLUMN email TEXT;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email)) WHERE email IS NOT NULL;
+
+CREATE TABLE mail_outbox (
+  id              UUID PRIMARY KEY,
+  recipient       TEXT NOT NULL,
+  subject         TEXT NOT NULL,
+  body            TEXT NOT NULL,
+  template        TEXT NOT NULL,
+  attempts        INT NOT NULL DEFAULT 0,
+  last_error      TEXT,
+  next_attempt_at TIMESTAMPTZ NOT NULL,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  sent_at         TIMESTAMPTZ,
+  failed_at       TIMESTAMPTZ
+);
+CREATE INDEX mail_outbox_due_idx ON mail_outbox (next_attempt_at)
+  WHERE sent_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,6 @@
+-- 0013_login_security.down.sql
+
+ALTER TABLE login_challenges DROP COLUMN email_code_hash;
+DROP TABLE security_events;
+DROP TABLE known_networks;
+DROP TABLE known_devices;
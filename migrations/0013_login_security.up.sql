@@ -0,0 +1,40 @@
+-- 0013_login_security.up.sql
+-- What successful logins have looked like, per user, so a new one can be
+-- compared with them. known_devices keys on a fingerprint of the user
+-- agent, known_networks on the /24 (IPv4) or /48 (IPv6) the login came
+-- from, with approximate coordinates when a GeoIP database is loaded.
+-- security_events is the user-visible record of logins that looked
+-- unusual. login_challenges.email_code_hash is set when a flagged login
+-- must be confirmed with a code sent by email.
+CREATE TABLE known_devices (
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  fingerprint   TEXT NOT NULL,
+  user_agent    TEXT NOT NULL,
+  first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, fingerprint)
+);
+
+CREATE TABLE known_networks (
+  user_id       UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  network       TEXT NOT NULL,
+  last_ip       TEXT NOT NULL,
+  latitude      DOUBLE PRECISION,
+  longitude     DOUBLE PRECISION,
+  first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (user_id, network)
+);
+
+CREATE TABLE security_events (
+  id         UUID PRIMARY KEY,
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  kind       TEXT NOT NULL,
+  ip         TEXT,
+  user_agent TEXT,
+  details    JSONB NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX security_events_user_idx ON security_events (user_id, created_at DESC);
+
+ALTER TABLE login_challenges ADD COLUMN email_code_hash TEXT;
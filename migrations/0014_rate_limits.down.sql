@@ -0,0 +1,3 @@
+-- 0014_rate_limits.down.sql
+
+DROP TABLE rate_limits;
@@ -0,0 +1,17 @@
+-- 0014_rate_limits.up.sql
+-- Rate-limit buckets shared by every replica and kept across restarts.
+-- scope names the limiter (login_user, login_ip, ...), key what it
+-- counts: a username, a user id or an IP address. lockouts counts the
+-- times the bucket has run dry since it was last idle or reset, and
+-- locked_until is set while the latest lockout lasts.
+CREATE TABLE rate_limits (
+  scope        TEXT NOT NULL,
+  key          TEXT NOT NULL,
+  tokens       DOUBLE PRECISION NOT NULL,
+  updated_at   TIMESTAMPTZ NOT NULL,
+  lockouts     INT NOT NULL DEFAULT 0,
+  locked_until TIMESTAMPTZ,
+  PRIMARY KEY (scope, key)
+);
+CREATE INDEX rate_limits_key_idx ON rate_limits (key);
+CREATE INDEX rate_limits_updated_at_idx ON rate_limits (updated_at);
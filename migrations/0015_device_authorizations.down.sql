@@ -0,0 +1,4 @@
+-- 0015_device_authorizations.down.sql
+
+ALTER TABLE sessions DROP COLUMN device_name;
+DROP TABLE device_authorizations;
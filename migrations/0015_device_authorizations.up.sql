@@ -0,0 +1,23 @@
+-- 0015_device_authorizations.up.sql
+-- Device authorization grants (RFC 8628) for terminal clients. The
+-- client holds the device code (stored as a SHA-256) and polls with it;
+-- the user types user_code into the web UI to approve or deny. A grant
+-- is undecided while approved_at and denied_at are both NULL, and is
+-- deleted once the client has collected the outcome. sessions.device_name
+-- labels the sessions such a grant produced.
+CREATE TABLE device_authorizations (
+  device_code_hash TEXT PRIMARY KEY,
+  user_code        TEXT NOT NULL UNIQUE,
+  client_name      TEXT NOT NULL,
+  ip               TEXT,
+  user_agent       TEXT,
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at       TIMESTAMPTZ NOT NULL,
+  last_polled_at   TIMESTAMPTZ,
+  user_id          UUID REFERENCES users(id) ON DELETE CASCADE,
+  approved_at      TIMESTAMPTZ,
+  denied_at        TIMESTAMPTZ
+);
+CREATE INDEX device_authorizations_expires_at_idx ON device_authorizations (expires_at);
+
+ALTER TABLE sessions ADD COLUMN device_name TEXT;
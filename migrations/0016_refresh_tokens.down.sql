@@ -0,0 +1,3 @@
+-- 0016_refresh_tokens.down.sql
+
+DROP TABLE refresh_tokens;
@@ -0,0 +1,14 @@
+-- 0016_refresh_tokens.up.sql
+-- Refresh tokens for signed access tokens. Each refresh hands out a new
+-- token and marks the old one used; the used rows are kept for the life
+-- of the session so a replayed token can be recognised, which revokes
+-- the session. Tokens are stored as SHA-256 hashes.
+CREATE TABLE refresh_tokens (
+  token_hash TEXT PRIMARY KEY,
+  session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at    TIMESTAMPTZ
+);
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
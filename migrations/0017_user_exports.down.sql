@@ -0,0 +1,3 @@
+-- 0017_user_exports.down.sql
+
+DROP TABLE user_exports;
@@ -0,0 +1,21 @@
+-- 0017_user_exports.up.sql
+-- LGPD data exports are prepared in the background. A row is pending
+-- while ready_at and failed_at are both NULL; the worker claims it by
+-- pushing next_attempt_at past a lease, writes <id>.zip to the export
+-- directory and sets ready_at. The archive is downloadable until
+-- expires_at, after which the row and the file are purged.
+CREATE TABLE user_exports (
+  id              UUID PRIMARY KEY,
+  user_id         UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  attempts        INT NOT NULL DEFAULT 0,
+  last_error      TEXT,
+  size_bytes      BIGINT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMPTZ NOT NULL,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  ready_at        TIMESTAMPTZ,
+  failed_at       TIMESTAMPTZ,
+  expires_at      TIMESTAMPTZ
+);
+CREATE INDEX user_exports_user_idx ON user_exports (user_id, created_at DESC);
+CREATE INDEX user_exports_due_idx ON user_exports (next_attempt_at)
+  WHERE ready_at IS NULL AND failed_at IS NULL;
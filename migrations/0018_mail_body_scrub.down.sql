@@ -0,0 +1,3 @@
+-- 0018_mail_body_scrub.down.sql
+-- Cleared bodies cannot be restored.
+SELECT 1;
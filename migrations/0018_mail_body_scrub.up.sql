@@ -0,0 +1,5 @@
+-- 0018_mail_body_scrub.up.sql
+-- Bodies of outgoing mail carry one-time links and codes. The store now
+-- clears them once a message is sent or given up on; clear the ones
+-- already kept. Only the metadata stays until the purge.
+UPDATE mail_outbox SET body = '' WHERE sent_at IS NOT NULL OR failed_at IS NOT NULL;
@@ -0,0 +1,3 @@
+-- 0019_challenge_oidc_groups.down.sql
+
+ALTER TABLE login_challenges DROP COLUMN oidc_groups;
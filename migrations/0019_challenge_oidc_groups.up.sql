@@ -0,0 +1,5 @@
+-- 0019_challenge_oidc_groups.up.sql
+-- An OIDC login held for a second factor or a step-up code grants its
+-- group roles only once the challenge is answered; the groups wait on
+-- the challenge until then.
+ALTER TABLE login_challenges ADD COLUMN oidc_groups TEXT[];
@@ -0,0 +1,3 @@
+-- 0020_oidc_reauth.down.sql
+
+DROP TABLE IF EXISTS oidc_reauth_grants;
@@ -0,0 +1,12 @@
+-- 0020_oidc_reauth.up.sql
+-- Accounts created through OIDC have no password to confirm sensitive
+-- changes with. A fresh round trip through the provider mints a
+-- single-use grant instead, accepted in place of the password for a few
+-- minutes; rows are keyed by the hash of the grant token.
+CREATE TABLE oidc_reauth_grants (
+  token_hash TEXT PRIMARY KEY,
+  user_id    UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX oidc_reauth_grants_expires_at_idx ON oidc_reauth_grants (expires_at);
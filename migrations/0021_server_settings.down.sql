@@ -0,0 +1,3 @@
+-- 0021_server_settings.down.sql
+
+DROP TABLE IF EXISTS server_settings;
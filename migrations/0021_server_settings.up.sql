@@ -0,0 +1,9 @@
+-- 0021_server_settings.up.sql
+-- Server-wide settings that admins change at runtime, such as the
+-- registration policy. Every replica reads them from here, and they
+-- outlive restarts; a key without a row falls back to the environment.
+CREATE TABLE server_settings (
+  key        TEXT PRIMARY KEY,
+  value      TEXT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);